@@ -0,0 +1,34 @@
+package goliquify
+
+import "os"
+
+// First Liquibase version accepting --analytics-enabled, older versions
+// reject the flag but collect no analytics either
+const ANALYTICS_FLAG_VERSION = "4.30.0"
+
+// Environment variables turning off Liquibase analytics and Hub traffic
+var ANALYTICS_OPT_OUT_ENV = []string{"LIQUIBASE_ANALYTICS_ENABLED=false", "LIQUIBASE_HUB_MODE=off"}
+
+// Global arguments turning off analytics, for versions known to accept them
+func (pl *GoLiquibase) analyticsArgs() []string {
+	version, err := liquibaseSemver(pl.InstalledVersion())
+	if err != nil {
+		// User provided installs of unknown version get the environment, which is enough
+		return nil
+	}
+	minimum, _ := ParseSemver(ANALYTICS_FLAG_VERSION)
+	if version.Less(minimum) {
+		return nil
+	}
+	return []string{"--analytics-enabled=false"}
+}
+
+// Turn off analytics in the environment of the command
+func optOutAnalytics(cmd *Command) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	// The last value of a variable wins, so these override inherited ones
+	cmd.Env = append(env, ANALYTICS_OPT_OUT_ENV...)
+}
@@ -0,0 +1,236 @@
+package goliquify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	APPROVAL_KEY_ENV     = "GOLIQUIFY_APPROVAL_KEY"
+	APPROVAL_GITHUB      = "github"
+	APPROVAL_PROMPT      = "prompt"
+	GITHUB_API_URL       = "https://api.github.com"
+	APPROVAL_FILE_SUFFIX = ".approval"
+)
+
+// Values in generated SQL that change on every run and say nothing about the
+// planned changes: when Liquibase's header says the script ran, and the
+// deployment ID, the last value of the rows inserted into the changelog table
+var planVolatilePatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?m)^-- Ran at: .*\r?\n`), ""},
+	{regexp.MustCompile(`(?im)^(INSERT INTO \S+ \(ID, AUTHOR, FILENAME, .*, DEPLOYMENT_ID\) VALUES \(.*, )'\d{10}'(\);\r?)$`), "${1}'DEPLOYMENT_ID'${2}"},
+}
+
+// Approval is a signed statement that a plan may be applied to an environment
+type Approval struct {
+	PlanDigest  string    `json:"planDigest"`
+	Environment string    `json:"environment"`
+	Approver    string    `json:"approver"`
+	ApprovedAt  time.Time `json:"approvedAt"`
+	Signature   string    `json:"signature"`
+}
+
+// Normalize planned SQL so plans of the same changes compare equal
+func normalizePlan(sql string) string {
+	for _, p := range planVolatilePatterns {
+		sql = p.pattern.ReplaceAllString(sql, p.replacement)
+	}
+	return strings.TrimSpace(sql)
+}
+
+// Digest of a plan, ignoring when it was generated and its deployment ID
+func PlanDigest(sql string) string {
+	sum := sha256.Sum256([]byte(normalizePlan(sql)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *Approval) payload() string {
+	return strings.Join([]string{a.PlanDigest, a.Environment, a.Approver, a.ApprovedAt.UTC().Format(time.RFC3339)}, "|")
+}
+
+func approvalKey() ([]byte, error) {
+	key := os.Getenv(APPROVAL_KEY_ENV)
+	if key == "" {
+		return nil, fmt.Errorf("%s must be set to sign or verify approvals", APPROVAL_KEY_ENV)
+	}
+	return []byte(key), nil
+}
+
+// Sign the approval with the key from GOLIQUIFY_APPROVAL_KEY
+func (a *Approval) Sign() error {
+	key, err := approvalKey()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(a.payload()))
+	a.Signature = hex.EncodeToString(mac.Sum(nil))
+	return nil
+}
+
+// Verify the signature and that the approval covers the plan and environment
+func (a *Approval) Verify(planDigest, environment string) error {
+	key, err := approvalKey()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(a.payload()))
+	signature, err := hex.DecodeString(a.Signature)
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("approval signature is invalid")
+	}
+	if a.PlanDigest != planDigest {
+		return fmt.Errorf("approval is for a different plan")
+	}
+	if a.Environment != environment {
+		return fmt.Errorf("approval is for environment %s, not %s", a.Environment, environment)
+	}
+	return nil
+}
+
+// Write a signed approval file
+func WriteApproval(path string, approval *Approval) error {
+	if err := approval.Sign(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Read an approval file
+func ReadApproval(path string) (*Approval, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	approval := &Approval{}
+	if err := json.Unmarshal(data, approval); err != nil {
+		return nil, fmt.Errorf("failed to parse approval %s: %v", path, err)
+	}
+	return approval, nil
+}
+
+// Check the plan is approved with the given method: a path to a signed approval file,
+// "github" for a GitHub Actions deployment review, or "prompt" to ask on the terminal
+func CheckApproval(method, plan, environment string, in io.Reader, out io.Writer) error {
+	switch method {
+	case "":
+		return fmt.Errorf("environment %s is protected, an approval is required (--approval file|github|prompt)", environment)
+	case APPROVAL_GITHUB:
+		return checkGitHubApproval(environment)
+	case APPROVAL_PROMPT:
+		return promptApproval(plan, environment, in, out)
+	default:
+		approval, err := ReadApproval(method)
+		if err != nil {
+			return err
+		}
+		return approval.Verify(PlanDigest(plan), environment)
+	}
+}
+
+// Refuse to change the database of a protected environment without an
+// approval of the plan, the SQL of the command's SQL variant. Commands without
+// one can only be approved on the terminal or by a GitHub deployment review.
+func (pl *GoLiquibase) checkApproval(ctx context.Context, command string, arguments []string) error {
+	if !pl.Protected || pl.DryRun || readOnlyCommand(command) {
+		return nil
+	}
+	sqlCommand, ok := DRY_RUN_COMMANDS[command]
+	if !ok {
+		if pl.Approval != APPROVAL_GITHUB && pl.Approval != APPROVAL_PROMPT {
+			return fmt.Errorf("environment %s is protected and %s has no plan to approve, approve it with --approval github or prompt", pl.Environment, command)
+		}
+		return CheckApproval(pl.Approval, QuoteArgs(arguments), pl.Environment, os.Stdin, os.Stderr)
+	}
+	var plan bytes.Buffer
+	if err := pl.reader().execute(ctx, ExecOptions{Stdout: &plan}, replaceCommand(arguments, command, sqlCommand)...); err != nil {
+		return fmt.Errorf("failed to plan %s for its approval: %v", command, err)
+	}
+	if err := CheckApproval(pl.Approval, plan.String(), pl.Environment, os.Stdin, os.Stderr); err != nil {
+		return err
+	}
+	pl.logger().Printf("Plan %s approved for %s", PlanDigest(plan.String()), pl.Environment)
+	return nil
+}
+
+// Show the plan and ask the operator to confirm by typing the environment name
+func promptApproval(plan, environment string, in io.Reader, out io.Writer) error {
+	fmt.Fprintln(out, plan)
+	fmt.Fprintf(out, "Type the environment name (%s) to apply this plan: ", environment)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.TrimSpace(answer) != environment {
+		return fmt.Errorf("plan was not approved")
+	}
+	return nil
+}
+
+// Check that the running GitHub Actions workflow was approved for the environment
+func checkGitHubApproval(environment string) error {
+	repo, runID, token := os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"), os.Getenv("GITHUB_TOKEN")
+	if repo == "" || runID == "" || token == "" {
+		return fmt.Errorf("GitHub approval needs GITHUB_REPOSITORY, GITHUB_RUN_ID and GITHUB_TOKEN")
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/actions/runs/%s/approvals", GITHUB_API_URL, repo, runID)
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Accept", "application/vnd.github+json")
+	response, err := (&http.Client{Timeout: 30 * time.Second}).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get workflow approvals: %s", response.Status)
+	}
+
+	var reviews []struct {
+		State        string `json:"state"`
+		Environments []struct {
+			Name string `json:"name"`
+		} `json:"environments"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&reviews); err != nil {
+		return err
+	}
+	for _, review := range reviews {
+		if review.State != "approved" {
+			continue
+		}
+		for _, env := range review.Environments {
+			if env.Name == environment {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("workflow run %s has no approved deployment review for environment %s", runID, environment)
+}
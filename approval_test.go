@@ -0,0 +1,75 @@
+package goliquify_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+const approvedPlan = `-- *********************************************************************
+-- Update Database Script
+-- *********************************************************************
+-- Change Log: db/changelog.xml
+-- Ran at: 10/15/26, 9:12 AM
+-- Against: app@jdbc:postgresql://localhost:5432/app
+-- Liquibase version: 4.21.1
+-- *********************************************************************
+
+-- Changeset db/changelog.xml::1::bob
+-- Credit limit of new accounts
+UPDATE accounts SET credit_limit = '1000000000';
+
+INSERT INTO public.databasechangelog (ID, AUTHOR, FILENAME, DATEEXECUTED, ORDEREXECUTED, MD5SUM, DESCRIPTION, COMMENTS, EXEC_TYPE, CONTEXTS, LABELS, LIQUIBASE, DEPLOYMENT_ID) VALUES ('1', 'bob', 'db/changelog.xml', NOW(), 1, '9:2b5e', 'sql', '', 'EXECUTED', NULL, NULL, '4.21.1', '6139128301');
+`
+
+func TestPlanDigestOnlyIgnoresGeneratedValues(t *testing.T) {
+	digest := goliquify.PlanDigest(approvedPlan)
+	rerun := strings.NewReplacer("9:12 AM", "4:40 PM", "'6139128301'", "'6149920417'").Replace(approvedPlan)
+	if goliquify.PlanDigest(rerun) != digest {
+		t.Fatal("a later plan of the same changes has another digest")
+	}
+	for name, edited := range map[string]string{
+		"value":   strings.Replace(approvedPlan, "'1000000000'", "'9000000000'", 1),
+		"comment": strings.Replace(approvedPlan, "-- Credit limit", "-- Credit limit raised", 1),
+		"target":  strings.Replace(approvedPlan, "localhost:5432/app", "prod:5432/app", 1),
+	} {
+		if goliquify.PlanDigest(edited) == digest {
+			t.Errorf("plan with an edited %s has the approved digest", name)
+		}
+	}
+}
+
+func TestProtectedEnvironmentNeedsAnApproval(t *testing.T) {
+	t.Setenv(goliquify.APPROVAL_KEY_ENV, "key")
+	approval := filepath.Join(t.TempDir(), "plan.sql"+goliquify.APPROVAL_FILE_SUFFIX)
+	if err := goliquify.WriteApproval(approval, &goliquify.Approval{PlanDigest: goliquify.PlanDigest(approvedPlan), Environment: "prod",
+		Approver: "ana", ApprovedAt: time.Now().UTC().Truncate(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		approval string
+		plan     string
+		command  string
+		ran      bool
+	}{
+		"no approval":       {"", approvedPlan, "update", false},
+		"approved plan":     {approval, approvedPlan, "update", true},
+		"another plan":      {approval, strings.Replace(approvedPlan, "'1000000000'", "'9000000000'", 1), "update", false},
+		"no plan to review": {approval, approvedPlan, "set-labels", false},
+		"read only":         {"", approvedPlan, "status", true},
+	} {
+		pl, runner := goliquifytest.New(t, goliquify.WithEnvironment("prod"), goliquify.WithApproval(true, tc.approval))
+		runner.On("update-sql", goliquifytest.Response{Stdout: tc.plan})
+		err := pl.Execute(tc.command)
+		commands := runner.Commands()
+		ran := len(commands) > 0 && commands[len(commands)-1] == tc.command
+		if ran != tc.ran || (err == nil) != tc.ran {
+			t.Errorf("%s: ran %v with %v", name, commands, err)
+		}
+	}
+}
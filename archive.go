@@ -0,0 +1,489 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Directory archived changesets are moved to, next to the root changelog
+const DEFAULT_ARCHIVE_DIR = "archive"
+
+var (
+	xmlChangelogEndPattern = regexp.MustCompile(`</(\w+:)?databaseChangeLog\s*>`)
+	sqlChangelogHeader     = regexp.MustCompile(`(?m)^--\s*liquibase formatted sql[^\n]*\n`)
+)
+
+// ArchivedChangeSet is a changeset moved between its changelog and the archive
+type ArchivedChangeSet struct {
+	ChangeSet string `json:"changeset"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// ArchiveOptions configure ArchiveChangeSets and UnarchiveChangeSets
+type ArchiveOptions struct {
+	// BeforeTag archives the changesets deployed up to the one carrying the tag
+	BeforeTag string
+	// Dir holding the archive changelogs, relative to the root changelog,
+	// DEFAULT_ARCHIVE_DIR by default
+	Dir string
+	// Write changes the changelogs, otherwise the moves are only returned
+	Write bool
+}
+
+// ArchiveResult lists the changesets moved, and the changelogs left alone
+// because their format can't be archived
+type ArchiveResult struct {
+	Moved   []ArchivedChangeSet `json:"moved"`
+	Skipped []string            `json:"skipped,omitempty"`
+}
+
+// Where the archive of a changelog goes, by the name Liquibase finds it and on disk
+type archiveTarget struct {
+	source   *ChangelogFile
+	name     string
+	diskPath string
+	// logical is the path the changesets stay recorded by
+	logical    string
+	changeSets []*ChangeSet
+}
+
+// The archive directory of a tree, as a changelog path and on disk. It must
+// be below the root changelog's directory: unarchiving removes the files
+// found in it.
+func archiveLocation(tree *ChangelogTree, dir string) (name, diskDir string, err error) {
+	root := tree.Files[0]
+	dir = path.Clean(filepath.ToSlash(firstNonEmpty(dir, DEFAULT_ARCHIVE_DIR)))
+	if path.IsAbs(dir) || filepath.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", "", fmt.Errorf("the archive dir %s must be below the directory of the root changelog", dir)
+	}
+	name = path.Join(path.Dir(root.Path), dir)
+	searchDir := strings.TrimSuffix(filepath.ToSlash(root.DiskPath), root.Path)
+	return name, filepath.Join(filepath.FromSlash(searchDir), filepath.FromSlash(name)), nil
+}
+
+// ArchiveChangeSets moves the changesets deployed up to a tag out of the
+// active changelogs into archive changelogs, one per changelog at the same
+// path under the archive directory, included from the top of the root
+// changelog. The archives pin the changesets to the path they were deployed
+// by with logicalFilePath, and their definitions are moved as they are, so
+// their checksums don't change. applied is the deployment history in the
+// order it was applied.
+func ArchiveChangeSets(tree *ChangelogTree, applied []AppliedChangeSet, opts ArchiveOptions) (*ArchiveResult, error) {
+	root := tree.Files[0]
+	if opts.BeforeTag == "" {
+		return nil, fmt.Errorf("archiving needs the tag to archive up to")
+	}
+	tagged := -1
+	for i, a := range applied {
+		if a.Tag == opts.BeforeTag {
+			tagged = i
+		}
+	}
+	if tagged < 0 {
+		return nil, fmt.Errorf("tag %s not found in the deployment history", opts.BeforeTag)
+	}
+	archiveName, archiveDir, err := archiveLocation(tree, opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range tree.Files {
+		for _, inc := range f.Includes {
+			if inc.All && (inc.File == archiveName || strings.HasPrefix(archiveName, strings.TrimSuffix(inc.File, "/")+"/")) {
+				return nil, fmt.Errorf("the archive %s is inside the includeAll directory %s, pick another archive dir", archiveName, inc.File)
+			}
+		}
+	}
+
+	deployed := map[string][]string{}
+	for _, a := range applied[:tagged+1] {
+		key := a.ID + "::" + a.Author
+		deployed[key] = append(deployed[key], a.File)
+	}
+	isDeployed := func(cs *ChangeSet) bool {
+		for _, file := range deployed[cs.ID+"::"+cs.Author] {
+			if sameChangelogPath(file, cs.File) {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := &ArchiveResult{}
+	var targets []*archiveTarget
+	for _, f := range tree.Files {
+		if f.Path == archiveName || strings.HasPrefix(f.Path, archiveName+"/") {
+			continue
+		}
+		var moved []*ChangeSet
+		for _, cs := range f.ChangeSets {
+			if isDeployed(cs) {
+				moved = append(moved, cs)
+			}
+		}
+		if len(moved) == 0 {
+			continue
+		}
+		if strings.EqualFold(filepath.Ext(f.DiskPath), ".json") {
+			result.Skipped = append(result.Skipped, f.DiskPath)
+			continue
+		}
+		rel := strings.TrimPrefix(f.Path, path.Dir(root.Path)+"/")
+		target := &archiveTarget{
+			source:     f,
+			name:       path.Join(archiveName, rel),
+			diskPath:   filepath.Join(archiveDir, filepath.FromSlash(rel)),
+			logical:    firstNonEmpty(f.LogicalFilePath, f.Path),
+			changeSets: moved,
+		}
+		targets = append(targets, target)
+		for _, cs := range moved {
+			result.Moved = append(result.Moved, ArchivedChangeSet{ChangeSet: cs.Key(), From: f.DiskPath, To: target.diskPath})
+		}
+	}
+	if len(targets) == 0 || !opts.Write {
+		return result, nil
+	}
+
+	// Every file is edited in memory first, the root may lose changesets
+	// and gain includes
+	contents := map[string]string{}
+	read := func(diskPath string) (string, error) {
+		if c, ok := contents[diskPath]; ok {
+			return c, nil
+		}
+		data, err := os.ReadFile(diskPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return string(data), nil
+	}
+	var includes []string
+	for _, target := range targets {
+		source := target.source
+		content, err := read(source.DiskPath)
+		if err != nil {
+			return nil, err
+		}
+		contents[source.DiskPath], err = removeChangeSetLines(content, target.changeSets)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source.DiskPath, err)
+		}
+		archive, err := read(target.diskPath)
+		if err != nil {
+			return nil, err
+		}
+		if archive == "" {
+			includes = append(includes, target.name)
+		}
+		if contents[target.diskPath], err = appendArchivedChangeSets(archive, target); err != nil {
+			return nil, err
+		}
+	}
+	if len(includes) > 0 {
+		content, err := read(root.DiskPath)
+		if err != nil {
+			return nil, err
+		}
+		if contents[root.DiskPath], err = addArchiveIncludes(root.DiskPath, content, includes, archiveName); err != nil {
+			return nil, err
+		}
+	}
+	return result, writeChangelogs(contents)
+}
+
+// UnarchiveChangeSets moves the archived changesets back to the top of the
+// changelogs they came from, removes the archives and their includes
+func UnarchiveChangeSets(tree *ChangelogTree, opts ArchiveOptions) (*ArchiveResult, error) {
+	root := tree.Files[0]
+	archiveName, archiveDir, err := archiveLocation(tree, opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	searchDir := strings.TrimSuffix(filepath.ToSlash(root.DiskPath), root.Path)
+	result := &ArchiveResult{}
+	contents := map[string]string{}
+	var removed []string
+	for _, f := range tree.Files {
+		rel, ok := strings.CutPrefix(f.Path, archiveName+"/")
+		if !ok || len(f.ChangeSets) == 0 {
+			continue
+		}
+		// By its exact path, FindFile would also match the archive itself
+		name := path.Join(path.Dir(root.Path), rel)
+		var source *ChangelogFile
+		for _, candidate := range tree.Files {
+			if candidate.Path == name {
+				source = candidate
+			}
+		}
+		if source == nil {
+			return nil, fmt.Errorf("%s: the changelog %s it was archived from is no longer in the changelog", f.DiskPath, name)
+		}
+		for _, cs := range f.ChangeSets {
+			result.Moved = append(result.Moved, ArchivedChangeSet{ChangeSet: cs.Key(), From: f.DiskPath, To: source.DiskPath})
+		}
+		if !opts.Write {
+			continue
+		}
+		archive, err := os.ReadFile(f.DiskPath)
+		if err != nil {
+			return nil, err
+		}
+		content, ok := contents[source.DiskPath]
+		if !ok {
+			data, err := os.ReadFile(source.DiskPath)
+			if err != nil {
+				return nil, err
+			}
+			content = string(data)
+		}
+		if contents[source.DiskPath], err = prependChangeSets(source.DiskPath, content, changeSetLines(string(archive), f.ChangeSets)); err != nil {
+			return nil, err
+		}
+		removed = append(removed, f.Path)
+	}
+	if !opts.Write || len(removed) == 0 {
+		return result, nil
+	}
+	content, ok := contents[root.DiskPath]
+	if !ok {
+		data, err := os.ReadFile(root.DiskPath)
+		if err != nil {
+			return nil, err
+		}
+		content = string(data)
+	}
+	contents[root.DiskPath] = removeArchiveIncludes(content, removed)
+	if err := writeChangelogs(contents); err != nil {
+		return nil, err
+	}
+	for _, name := range removed {
+		diskPath := filepath.Join(filepath.FromSlash(searchDir), filepath.FromSlash(name))
+		if err := os.Remove(diskPath); err != nil {
+			return nil, err
+		}
+		// Directories left empty go too, up to the archive directory
+		for dir := filepath.Dir(diskPath); strings.HasPrefix(dir, archiveDir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// The full lines of changeset definitions
+func changeSetLines(content string, changeSets []*ChangeSet) string {
+	lines := strings.Split(content, "\n")
+	var b strings.Builder
+	for _, cs := range changeSets {
+		for _, line := range lines[cs.Line-1 : min(cs.EndLine, len(lines))] {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// Remove the lines of changeset definitions, which must not share lines
+// with anything else
+func removeChangeSetLines(content string, changeSets []*ChangeSet) (string, error) {
+	lines := strings.Split(content, "\n")
+	drop := map[int]bool{}
+	for _, cs := range changeSets {
+		if cs.EndLine < cs.Line {
+			return "", fmt.Errorf("can't find the end of changeset %s", cs.Key())
+		}
+		for i := cs.Line - 1; i < cs.EndLine && i < len(lines); i++ {
+			drop[i] = true
+		}
+	}
+	var kept []string
+	for i, line := range lines {
+		if !drop[i] {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n"), nil
+}
+
+// Append changesets to an archive changelog, starting it when it is new
+func appendArchivedChangeSets(archive string, target *archiveTarget) (string, error) {
+	source := target.source
+	content, err := os.ReadFile(source.DiskPath)
+	if err != nil {
+		return "", err
+	}
+	moved := changeSetLines(string(content), target.changeSets)
+	switch strings.ToLower(filepath.Ext(source.DiskPath)) {
+	case ".xml":
+		if archive == "" {
+			// The root element is copied for its namespaces and defaults
+			loc := xmlChangelogRootPattern.FindStringIndex(string(content))
+			end := -1
+			if loc != nil {
+				end = strings.Index(string(content[loc[0]:]), ">")
+			}
+			if end < 0 {
+				return "", fmt.Errorf("%s: no databaseChangeLog element", source.DiskPath)
+			}
+			start := regexp.MustCompile(`\s+logicalFilePath\s*=\s*("[^"]*"|'[^']*')`).ReplaceAllString(string(content[loc[0]:loc[0]+end]), "")
+			start = strings.TrimSuffix(start, "/")
+			closing := "</" + strings.TrimPrefix(xmlChangelogRootPattern.FindString(start), "<") + ">"
+			archive = fmt.Sprintf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n%s logicalFilePath=\"%s\">\n%s\n", start, xmlEscape(target.logical), closing)
+		}
+		loc := xmlChangelogEndPattern.FindAllStringIndex(archive, -1)
+		if loc == nil {
+			return "", fmt.Errorf("%s: no databaseChangeLog end tag", target.diskPath)
+		}
+		last := loc[len(loc)-1][0]
+		return archive[:last] + moved + archive[last:], nil
+	case ".yaml", ".yml":
+		if archive == "" {
+			archive = fmt.Sprintf("databaseChangeLog:\n  - logicalFilePath: %q\n", target.logical)
+		}
+		return strings.TrimRight(archive, "\n") + "\n" + moved, nil
+	case ".sql":
+		if archive == "" {
+			archive = "--liquibase formatted sql\n"
+		}
+		// Formatted SQL has no root, every changeset is pinned
+		lines := strings.Split(moved, "\n")
+		for i, line := range lines {
+			if sqlChangesetPattern.MatchString(strings.TrimSpace(line)) && !strings.Contains(line, "logicalFilePath:") {
+				lines[i] = strings.TrimRight(line, "\r") + " logicalFilePath:" + target.logical
+			}
+		}
+		return strings.TrimRight(archive, "\n") + "\n\n" + strings.Join(lines, "\n"), nil
+	}
+	return "", fmt.Errorf("%s: unsupported changelog format", source.DiskPath)
+}
+
+// Include new archives from the top of the root changelog, after the
+// archives it already includes
+func addArchiveIncludes(diskPath, content string, names []string, archiveName string) (string, error) {
+	var block strings.Builder
+	ext := strings.ToLower(filepath.Ext(diskPath))
+	for _, name := range names {
+		switch ext {
+		case ".xml":
+			fmt.Fprintf(&block, "    <include file=\"%s\"/>\n", xmlEscape(name))
+		case ".yaml", ".yml":
+			fmt.Fprintf(&block, "  - include:\n      file: %s\n", name)
+		case ".sql":
+			fmt.Fprintf(&block, "--include file:%s\n", name)
+		default:
+			return "", fmt.Errorf("%s: archives can't be included from this root changelog format", diskPath)
+		}
+	}
+
+	// After the last line naming the archive directory, when there is one
+	insert := -1
+	lines := strings.SplitAfter(content, "\n")
+	offset := 0
+	for _, line := range lines {
+		offset += len(line)
+		if strings.Contains(line, archiveName+"/") {
+			insert = offset
+		}
+	}
+	if insert < 0 {
+		switch ext {
+		case ".xml":
+			loc := xmlChangelogRootPattern.FindStringIndex(content)
+			if loc == nil {
+				return "", fmt.Errorf("%s: no databaseChangeLog element", diskPath)
+			}
+			end := strings.Index(content[loc[1]:], ">")
+			if end < 0 {
+				return "", fmt.Errorf("%s: no databaseChangeLog element", diskPath)
+			}
+			insert = loc[1] + end + 1
+			if nl := strings.Index(content[insert:], "\n"); nl >= 0 {
+				insert += nl + 1
+			}
+		case ".yaml", ".yml":
+			loc := yamlChangelogRootPattern.FindStringIndex(content)
+			if loc == nil {
+				return "", fmt.Errorf("%s: no databaseChangeLog list", diskPath)
+			}
+			insert = loc[1]
+		case ".sql":
+			insert = 0
+			if loc := sqlChangelogHeader.FindStringIndex(content); loc != nil {
+				insert = loc[1]
+			}
+		}
+	}
+	return content[:insert] + block.String() + content[insert:], nil
+}
+
+// Put changesets back at the top of the changelog they were archived from
+func prependChangeSets(diskPath, content, changeSets string) (string, error) {
+	changeSets = strings.TrimRight(changeSets, "\n") + "\n"
+	insert := 0
+	switch strings.ToLower(filepath.Ext(diskPath)) {
+	case ".xml":
+		loc := xmlChangelogRootPattern.FindStringIndex(content)
+		if loc == nil {
+			return "", fmt.Errorf("%s: no databaseChangeLog element", diskPath)
+		}
+		insert = loc[1] + strings.Index(content[loc[1]:], ">") + 1
+		if nl := strings.Index(content[insert:], "\n"); nl >= 0 {
+			insert += nl + 1
+		}
+	case ".yaml", ".yml":
+		loc := yamlChangelogRootPattern.FindStringIndex(content)
+		if loc == nil {
+			return "", fmt.Errorf("%s: no databaseChangeLog list", diskPath)
+		}
+		insert = loc[1]
+	case ".sql":
+		if loc := sqlChangelogHeader.FindStringIndex(content); loc != nil {
+			insert = loc[1]
+		}
+		changeSets = "\n" + changeSets
+	}
+	return content[:insert] + changeSets + content[insert:], nil
+}
+
+// Remove the includes of archives, an include being the lines naming them
+// up to the end of its entry
+func removeArchiveIncludes(content string, names []string) string {
+	lines := strings.Split(content, "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		named := false
+		for _, name := range names {
+			if strings.Contains(lines[i], name) {
+				named = true
+			}
+		}
+		if !named {
+			kept = append(kept, lines[i])
+			continue
+		}
+		// A YAML include names its file on the line after "- include:"
+		if n := len(kept); n > 0 && strings.TrimSpace(kept[n-1]) == "- include:" {
+			kept = kept[:n-1]
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// Write edited changelogs, creating the directories of new ones
+func writeChangelogs(contents map[string]string) error {
+	for _, diskPath := range sortedKeys(contents) {
+		if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(diskPath, []byte(contents[diskPath]), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
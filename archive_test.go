@@ -0,0 +1,121 @@
+package goliquify
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const archivedChangelog = `<?xml version="1.0" encoding="UTF-8"?>
+<databaseChangeLog xmlns="http://www.liquibase.org/xml/ns/dbchangelog">
+    <changeSet id="1" author="bob">
+        <sql>CREATE TABLE a (id INT)</sql>
+    </changeSet>
+    <changeSet id="2" author="bob">
+        <sql>CREATE TABLE b (id INT)</sql>
+    </changeSet>
+    <changeSet id="3" author="bob">
+        <sql>CREATE TABLE c (id INT)</sql>
+    </changeSet>
+</databaseChangeLog>
+`
+
+// The changesets of a changelog by the key Liquibase records them with
+func changeSetKeys(t *testing.T, changelog string) []string {
+	t.Helper()
+	tree, err := LoadChangelogTree(changelog, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, cs := range tree.ChangeSets {
+		keys = append(keys, cs.Key())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	chdir(t, t.TempDir())
+	if err := os.WriteFile("changelog.xml", []byte(archivedChangelog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := changeSetKeys(t, "changelog.xml")
+	applied := []AppliedChangeSet{{ID: "1", Author: "bob", File: "changelog.xml"}, {ID: "2", Author: "bob", File: "changelog.xml", Tag: "v1"}}
+
+	tree, err := LoadChangelogTree("changelog.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ArchiveChangeSets(tree, applied, ArchiveOptions{BeforeTag: "v1", Write: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Moved) != 2 {
+		t.Fatalf("moved %+v, want changesets 1 and 2", result.Moved)
+	}
+	root, _ := os.ReadFile("changelog.xml")
+	if !strings.Contains(string(root), `<include file="archive/changelog.xml"/>`) || strings.Contains(string(root), `id="1"`) || !strings.Contains(string(root), `id="3"`) {
+		t.Fatalf("archived root changelog:\n%s", root)
+	}
+	// The archived changesets are still recorded by their original path
+	if keys := changeSetKeys(t, "changelog.xml"); strings.Join(keys, " ") != strings.Join(before, " ") {
+		t.Fatalf("changesets after archiving %v, want %v", keys, before)
+	}
+
+	if tree, err = LoadChangelogTree("changelog.xml", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnarchiveChangeSets(tree, ArchiveOptions{Write: true}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := changeSetKeys(t, "changelog.xml"); strings.Join(keys, " ") != strings.Join(before, " ") {
+		t.Fatalf("changesets after unarchiving %v, want %v", keys, before)
+	}
+	if dirExists(DEFAULT_ARCHIVE_DIR) {
+		t.Fatal("the archive dir was left behind")
+	}
+	root, _ = os.ReadFile("changelog.xml")
+	if strings.Contains(string(root), "archive/") || strings.Count(string(root), "<changeSet") != 3 {
+		t.Fatalf("unarchived root changelog:\n%s", root)
+	}
+}
+
+func TestArchiveDirStaysBelowTheChangelog(t *testing.T) {
+	chdir(t, t.TempDir())
+	root := `<?xml version="1.0" encoding="UTF-8"?>
+<databaseChangeLog xmlns="http://www.liquibase.org/xml/ns/dbchangelog">
+    <include file="users.xml" relativeToChangelogFile="true"/>
+    <include file="../shared/users.xml" relativeToChangelogFile="true"/>
+</databaseChangeLog>
+`
+	users := strings.ReplaceAll(archivedChangelog, `author="bob"`, `author="ana"`)
+	for name, content := range map[string]string{"db/changelog.xml": root, "db/users.xml": archivedChangelog, "shared/users.xml": users} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := LoadChangelogTree("db/changelog.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := []AppliedChangeSet{{ID: "1", Author: "bob", File: "db/users.xml", Tag: "v1"}}
+
+	// shared/users.xml would be taken for the archive of db/users.xml and removed
+	for _, archiveDir := range []string{"../shared", "archive/../../shared", "..", "/tmp/archive", "."} {
+		if _, err := UnarchiveChangeSets(tree, ArchiveOptions{Dir: archiveDir, Write: true}); err == nil || !strings.Contains(err.Error(), "below") {
+			t.Errorf("restoring from %s: %v", archiveDir, err)
+		}
+		if _, err := ArchiveChangeSets(tree, applied, ArchiveOptions{BeforeTag: "v1", Dir: archiveDir, Write: true}); err == nil || !strings.Contains(err.Error(), "below") {
+			t.Errorf("archiving to %s: %v", archiveDir, err)
+		}
+	}
+	if data, err := os.ReadFile("shared/users.xml"); err != nil || string(data) != users {
+		t.Fatalf("a changelog outside the archive dir was changed: %v", err)
+	}
+}
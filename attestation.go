@@ -0,0 +1,368 @@
+package goliquify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ATTESTATION_KEY_ENV = "GOLIQUIFY_ATTESTATION_KEY"
+	// in-toto statement and DSSE envelope types
+	INTOTO_STATEMENT_TYPE   = "https://in-toto.io/Statement/v1"
+	INTOTO_PAYLOAD_TYPE     = "application/vnd.in-toto+json"
+	ATTESTATION_PREDICATE   = "https://github.com/TFMV/GoLiquify/attestation/run/v1"
+	ATTESTATION_FILE_SUFFIX = ".intoto.json"
+)
+
+// Credentials in JDBC urls, user:password@ and user or password parameters
+var urlCredentialPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`//[^/@]*@`),
+	regexp.MustCompile(`(?i)([?&;])(user|username|password)=[^&;]*`),
+}
+
+// AttestationConfig turns on signed attestations of the runs of an environment
+type AttestationConfig struct {
+	// Dir the attestations are written to, one file per run
+	Dir string `yaml:"dir"`
+	// Key is the PEM ed25519 private key file, GOLIQUIFY_ATTESTATION_KEY by default
+	Key string `yaml:"key"`
+	// Commands attested, the commands guarded by maintenance windows by default
+	Commands []string `yaml:"commands"`
+}
+
+// Check if a command is attested
+func (c *AttestationConfig) attests(command string) bool {
+	return (&WindowPolicy{Commands: c.Commands}).Guards(command)
+}
+
+// AttestationSubject is an artifact the statement is about, the changelog
+type AttestationSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Operator is who ran the command
+type Operator struct {
+	User     string `json:"user"`
+	Host     string `json:"host,omitempty"`
+	CIActor  string `json:"ciActor,omitempty"`
+	Provider string `json:"ciProvider,omitempty"`
+}
+
+// RunPredicate describes a run: what was applied, where, by whom and when
+type RunPredicate struct {
+	RunID             string      `json:"runId"`
+	Command           string      `json:"command"`
+	Environment       string      `json:"environment,omitempty"`
+	Target            string      `json:"target,omitempty"`
+	Operator          Operator    `json:"operator"`
+	CI                *CIMetadata `json:"ci,omitempty"`
+	LiquibaseVersion  string      `json:"liquibaseVersion,omitempty"`
+	StartedOn         time.Time   `json:"startedOn"`
+	FinishedOn        time.Time   `json:"finishedOn"`
+	Status            string      `json:"status"`
+	Error             string      `json:"error,omitempty"`
+	ChangeSetsApplied []string    `json:"changeSetsApplied"`
+}
+
+// AttestationStatement is an in-toto statement about a run
+type AttestationStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []AttestationSubject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     RunPredicate         `json:"predicate"`
+}
+
+// Signature of a DSSE envelope
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Envelope is a DSSE envelope holding a signed statement
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// The DSSE pre-authentication encoding that is signed
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// Key ID of a public key, the hex sha256 of its PKIX encoding
+func attestationKeyID(public ed25519.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAttestationKey writes a new ed25519 key pair as PEM files, the
+// private key readable by the owner only
+func GenerateAttestationKey(privatePath, publicPath string) error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+}
+
+// ReadAttestationKey reads a PEM ed25519 private key
+func ReadAttestationKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("attestation key %s is not a PEM file", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse attestation key %s: %v", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("attestation key %s is not an ed25519 key", path)
+	}
+	return private, nil
+}
+
+// ReadAttestationPublicKey reads a PEM ed25519 public key
+func ReadAttestationPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not a PEM file", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	return public, nil
+}
+
+// SignStatement wraps a statement in a signed DSSE envelope
+func SignStatement(statement *AttestationStatement, key ed25519.PrivateKey) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(key, preAuthEncoding(INTOTO_PAYLOAD_TYPE, payload))
+	return &Envelope{
+		PayloadType: INTOTO_PAYLOAD_TYPE,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{{KeyID: attestationKeyID(key.Public().(ed25519.PublicKey)), Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, nil
+}
+
+// VerifyEnvelope checks an envelope is signed by the public key and returns its statement
+func VerifyEnvelope(envelope *Envelope, public ed25519.PublicKey) (*AttestationStatement, error) {
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("attestation payload is not base64: %v", err)
+	}
+	keyID := attestationKeyID(public)
+	verified := false
+	for _, s := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil || (s.KeyID != "" && s.KeyID != keyID) {
+			continue
+		}
+		if ed25519.Verify(public, preAuthEncoding(envelope.PayloadType, payload), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("attestation signature is invalid for key %s", keyID[:16])
+	}
+	statement := &AttestationStatement{}
+	if err := json.Unmarshal(payload, statement); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation statement: %v", err)
+	}
+	return statement, nil
+}
+
+// ReadEnvelope reads an attestation file
+func ReadEnvelope(path string) (*Envelope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	envelope := &Envelope{}
+	if err := json.Unmarshal(data, envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation %s: %v", path, err)
+	}
+	return envelope, nil
+}
+
+// ChangelogDigest is the sha256 of a changelog and every file it includes,
+// taken over the sorted file paths and contents
+func ChangelogDigest(tree *ChangelogTree) (string, error) {
+	files := append([]*ChangelogFile{}, tree.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	h := sha256.New()
+	for _, f := range files {
+		content, err := os.ReadFile(f.DiskPath)
+		if err != nil {
+			return "", err
+		}
+		fileSum := sha256.Sum256(content)
+		fmt.Fprintf(h, "%s %s\n", hex.EncodeToString(fileSum[:]), f.Path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Remove credentials from a JDBC url before recording it
+func stripURLCredentials(jdbcURL string) string {
+	jdbcURL = urlCredentialPatterns[0].ReplaceAllString(jdbcURL, "//")
+	jdbcURL = urlCredentialPatterns[1].ReplaceAllString(jdbcURL, "$1")
+	jdbcURL = strings.NewReplacer("?&", "?", "&&", "&", ";;", ";").Replace(jdbcURL)
+	return strings.TrimRight(jdbcURL, "?&;")
+}
+
+// Who is running goliquify, the CI actor is added when known
+func currentOperator(ci *CIMetadata) Operator {
+	operator := Operator{User: os.Getenv("USER")}
+	if u, err := user.Current(); err == nil {
+		operator.User = u.Username
+	}
+	operator.Host, _ = os.Hostname()
+	if ci != nil {
+		operator.CIActor, operator.Provider = ci.Actor, ci.Provider
+	}
+	return operator
+}
+
+// appliedCollector picks the changesets a run applied out of the Liquibase output
+type appliedCollector struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	keys []string
+}
+
+func (c *appliedCollector) observe(line string) {
+	key := ""
+	if m := changesetRanPattern.FindStringSubmatch(line); m != nil {
+		key = fmt.Sprintf("%s::%s::%s", m[1], m[2], m[3])
+	} else if m := runningChangesetPattern.FindStringSubmatch(line); m != nil {
+		key = strings.TrimSpace(m[1])
+	}
+	if key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = map[string]bool{}
+	}
+	if !c.seen[key] {
+		c.seen[key] = true
+		c.keys = append(c.keys, key)
+	}
+}
+
+// Load the signing key of the attestations, before the run so a missing key stops it
+func (pl *GoLiquibase) attestationKey() (ed25519.PrivateKey, error) {
+	path := pl.Attestations.Key
+	if path == "" {
+		path = os.Getenv(ATTESTATION_KEY_ENV)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("attestations need a signing key, set the key of the attestations or %s", ATTESTATION_KEY_ENV)
+	}
+	return ReadAttestationKey(path)
+}
+
+// Build the statement about a finished run
+func (pl *GoLiquibase) attestationStatement(predicate RunPredicate) (*AttestationStatement, error) {
+	statement := &AttestationStatement{
+		Type:          INTOTO_STATEMENT_TYPE,
+		Subject:       []AttestationSubject{},
+		PredicateType: ATTESTATION_PREDICATE,
+		Predicate:     predicate,
+	}
+	if statement.Predicate.ChangeSetsApplied == nil {
+		statement.Predicate.ChangeSetsApplied = []string{}
+	}
+	if props, err := ReadDefaultsFile(pl.DefaultsFile); err == nil {
+		statement.Predicate.Target = stripURLCredentials(firstNonEmpty(props["url"], props["liquibase.command.url"]))
+	}
+	if changelog, searchPath := pl.ChangelogLocation(); changelog != "" {
+		tree, err := LoadChangelogTree(changelog, searchPath)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := ChangelogDigest(tree)
+		if err != nil {
+			return nil, err
+		}
+		statement.Subject = append(statement.Subject, AttestationSubject{Name: changelog, Digest: map[string]string{"sha256": digest}})
+	}
+	return statement, nil
+}
+
+// Sign and write the attestation of a run to the attestations dir
+func (pl *GoLiquibase) writeAttestation(key ed25519.PrivateKey, predicate RunPredicate) (string, error) {
+	statement, err := pl.attestationStatement(predicate)
+	if err != nil {
+		return "", err
+	}
+	envelope, err := SignStatement(statement, key)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(pl.Attestations.Dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(pl.Attestations.Dir, predicate.RunID+ATTESTATION_FILE_SUFFIX)
+	// Never overwrite an earlier record
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return "", err
+	}
+	return path, nil
+}
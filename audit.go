@@ -0,0 +1,235 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	OSV_QUERYBATCH_URL = "https://api.osv.dev/v1/querybatch"
+	OSV_VULN_URL       = "https://api.osv.dev/v1/vulns/%s"
+)
+
+// Advisory severities, as GitHub rates them
+const (
+	SEVERITY_UNKNOWN  = "unknown"
+	SEVERITY_LOW      = "low"
+	SEVERITY_MODERATE = "moderate"
+	SEVERITY_HIGH     = "high"
+	SEVERITY_CRITICAL = "critical"
+)
+
+const DEFAULT_AUDIT_FAIL_ON = SEVERITY_HIGH
+
+var severityRank = map[string]int{
+	SEVERITY_UNKNOWN:  0,
+	SEVERITY_LOW:      1,
+	SEVERITY_MODERATE: 2,
+	SEVERITY_HIGH:     3,
+	SEVERITY_CRITICAL: 4,
+}
+
+// AuditConfig sets which advisories fail an audit
+type AuditConfig struct {
+	// FailOn is the lowest severity that fails, high by default
+	FailOn string `yaml:"failOn"`
+	// Ignore lists advisory IDs or aliases that were reviewed and accepted
+	Ignore []string `yaml:"ignore"`
+}
+
+// Advisory is a known vulnerability of a toolchain component
+type Advisory struct {
+	ID        string   `json:"id"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Summary   string   `json:"summary,omitempty"`
+	Severity  string   `json:"severity"`
+	Package   string   `json:"package"`
+	Version   string   `json:"version"`
+	Kind      string   `json:"kind"`
+	Path      string   `json:"path,omitempty"`
+	FixedIn   []string `json:"fixedIn,omitempty"`
+	Reference string   `json:"reference"`
+}
+
+// ParseSeverity checks a severity name, moderate is also accepted as medium
+func ParseSeverity(severity string) (string, error) {
+	severity = strings.ToLower(severity)
+	if severity == "medium" {
+		severity = SEVERITY_MODERATE
+	}
+	if _, ok := severityRank[severity]; !ok || severity == SEVERITY_UNKNOWN {
+		return "", fmt.Errorf("unknown severity %s, expecting low, moderate, high or critical", severity)
+	}
+	return severity, nil
+}
+
+// SeverityAtLeast checks if a severity reaches a threshold. Unknown
+// severities only reach the lowest threshold.
+func SeverityAtLeast(severity, threshold string) bool {
+	if severity == SEVERITY_UNKNOWN {
+		return threshold == SEVERITY_LOW
+	}
+	return severityRank[severity] >= severityRank[threshold]
+}
+
+// The package URL a component is looked up by, only Maven packages are in OSV
+func auditPURL(c ToolchainComponent) string {
+	if c.Kind == COMPONENT_LIQUIBASE && c.Version != "" {
+		return fmt.Sprintf("pkg:maven/org.liquibase/liquibase-core@%s", c.Version)
+	}
+	if strings.HasPrefix(c.PURL, "pkg:maven/") {
+		return c.PURL
+	}
+	return ""
+}
+
+// AuditToolchain looks the toolchain components up in the OSV database, which
+// includes the GitHub advisories, and returns their advisories sorted by
+// severity. Advisories whose ID or alias is ignored are left out.
+func AuditToolchain(ctx context.Context, components []ToolchainComponent, ignore []string) ([]Advisory, error) {
+	var queried []ToolchainComponent
+	var queries []map[string]any
+	seen := map[string]bool{}
+	for _, c := range components {
+		purl := auditPURL(c)
+		if purl == "" || seen[purl] {
+			continue
+		}
+		seen[purl] = true
+		queried = append(queried, c)
+		queries = append(queries, map[string]any{"package": map[string]string{"purl": purl}})
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	var batch struct {
+		Results []struct {
+			Vulns []struct {
+				ID string `json:"id"`
+			} `json:"vulns"`
+		} `json:"results"`
+	}
+	if err := osvRequest(ctx, http.MethodPost, OSV_QUERYBATCH_URL, map[string]any{"queries": queries}, &batch); err != nil {
+		return nil, err
+	}
+
+	var advisories []Advisory
+	for i, result := range batch.Results {
+		if i >= len(queried) {
+			break
+		}
+		c := queried[i]
+		for _, v := range result.Vulns {
+			advisory, err := osvAdvisory(ctx, v.ID, c)
+			if err != nil {
+				return nil, err
+			}
+			if containsFold(ignore, advisory.ID) || containsAnyFold(ignore, advisory.Aliases) {
+				continue
+			}
+			advisories = append(advisories, advisory)
+		}
+	}
+	sort.SliceStable(advisories, func(i, j int) bool {
+		return severityRank[advisories[i].Severity] > severityRank[advisories[j].Severity]
+	})
+	return advisories, nil
+}
+
+// Fetch the details of a vulnerability for a component
+func osvAdvisory(ctx context.Context, id string, c ToolchainComponent) (Advisory, error) {
+	var vuln struct {
+		ID               string   `json:"id"`
+		Aliases          []string `json:"aliases"`
+		Summary          string   `json:"summary"`
+		DatabaseSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"database_specific"`
+		Affected []struct {
+			Package struct {
+				Name string `json:"name"`
+			} `json:"package"`
+			Ranges []struct {
+				Events []map[string]string `json:"events"`
+			} `json:"ranges"`
+		} `json:"affected"`
+	}
+	if err := osvRequest(ctx, http.MethodGet, fmt.Sprintf(OSV_VULN_URL, id), nil, &vuln); err != nil {
+		return Advisory{}, err
+	}
+	advisory := Advisory{
+		ID:        vuln.ID,
+		Aliases:   vuln.Aliases,
+		Summary:   vuln.Summary,
+		Severity:  SEVERITY_UNKNOWN,
+		Package:   strings.TrimPrefix(c.Group+":"+c.Name, ":"),
+		Version:   c.Version,
+		Kind:      c.Kind,
+		Path:      c.Path,
+		Reference: "https://osv.dev/vulnerability/" + vuln.ID,
+	}
+	if severity, err := ParseSeverity(vuln.DatabaseSpecific.Severity); err == nil {
+		advisory.Severity = severity
+	}
+	for _, affected := range vuln.Affected {
+		if !strings.HasSuffix(affected.Package.Name, ":"+c.Name) {
+			continue
+		}
+		for _, r := range affected.Ranges {
+			for _, event := range r.Events {
+				if fixed := event["fixed"]; fixed != "" && !containsString(advisory.FixedIn, fixed) {
+					advisory.FixedIn = append(advisory.FixedIn, fixed)
+				}
+			}
+		}
+	}
+	return advisory, nil
+}
+
+// Send a request to the OSV API and decode the JSON response
+func osvRequest(ctx context.Context, method, url string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to query OSV: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to query OSV: %s", response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse the OSV response: %v", err)
+	}
+	return nil
+}
+
+// Check if any of values is in list, ignoring case
+func containsAnyFold(list, values []string) bool {
+	for _, v := range values {
+		if containsFold(list, v) {
+			return true
+		}
+	}
+	return false
+}
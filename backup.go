@@ -0,0 +1,244 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backup types
+const (
+	BACKUP_PG_DUMP   = "pg_dump"
+	BACKUP_MYSQLDUMP = "mysqldump"
+	BACKUP_RDS       = "rds"
+	BACKUP_CLOUDSQL  = "cloudsql"
+)
+
+// Directory dumps are written to when none is set
+const DEFAULT_BACKUP_DIR = ".goliquify/backups"
+
+// BackupConfig takes a backup of the database before the backed up commands
+// of an environment run. Dumps are taken with pg_dump or mysqldump, snapshots
+// with the aws and gcloud CLIs, which find their credentials the usual way.
+type BackupConfig struct {
+	// Type is pg_dump, mysqldump, rds or cloudsql
+	Type string `yaml:"type"`
+	// Dir the dumps are written to, DEFAULT_BACKUP_DIR by default
+	Dir string `yaml:"dir"`
+	// SchemaOnly dumps the schema without the data
+	SchemaOnly bool `yaml:"schemaOnly"`
+	// Instance is the RDS DB instance identifier or the Cloud SQL instance name
+	Instance string `yaml:"instance"`
+	// Region of the RDS instance, the aws CLI's default when empty
+	Region string `yaml:"region"`
+	// Project of the Cloud SQL instance, the gcloud CLI's default when empty
+	Project string `yaml:"project"`
+	// Commands backed up, the commands guarded by maintenance windows by default
+	Commands []string `yaml:"commands"`
+	// Binary runs the backup instead of the type's tool on the PATH
+	Binary string `yaml:"binary"`
+}
+
+// BackupRecord references a backup taken before a run, recorded in the journal
+type BackupRecord struct {
+	Type string `json:"type"`
+	// Reference is the dump file, the RDS snapshot identifier or the Cloud SQL backup ID
+	Reference  string    `json:"reference"`
+	Instance   string    `json:"instance,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Project    string    `json:"project,omitempty"`
+	Database   string    `json:"database,omitempty"`
+	SchemaOnly bool      `json:"schemaOnly,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Validate checks the backup type and the settings it needs
+func (c *BackupConfig) Validate() error {
+	switch c.Type {
+	case BACKUP_PG_DUMP, BACKUP_MYSQLDUMP:
+	case BACKUP_RDS, BACKUP_CLOUDSQL:
+		if c.Instance == "" {
+			return fmt.Errorf("%s backups need an instance", c.Type)
+		}
+	default:
+		return fmt.Errorf("unknown backup type %q, expecting pg_dump, mysqldump, rds or cloudsql", c.Type)
+	}
+	return nil
+}
+
+// Check if a command is backed up
+func (c *BackupConfig) backsUp(command string) bool {
+	return (&WindowPolicy{Commands: c.Commands}).Guards(command)
+}
+
+// Return the backup tool
+func (c *BackupConfig) binary() string {
+	if c.Binary != "" {
+		return c.Binary
+	}
+	switch c.Type {
+	case BACKUP_RDS:
+		return "aws"
+	case BACKUP_CLOUDSQL:
+		return "gcloud"
+	}
+	return c.Type
+}
+
+// Connection details of the defaults file url
+type backupTarget struct {
+	host, port, database, username, password string
+}
+
+// Add the connection arguments of the Postgres or MySQL client tools to a
+// command. The password is passed in the environment, not on the command line.
+func (t *backupTarget) connect(cmd *Command, postgres bool) {
+	user, password := "--user=", "MYSQL_PWD="
+	if postgres {
+		user, password = "--username=", "PGPASSWORD="
+	}
+	if t.host != "" {
+		cmd.Args = append(cmd.Args, "--host="+t.host)
+	}
+	if t.port != "" {
+		cmd.Args = append(cmd.Args, "--port="+t.port)
+	}
+	if t.username != "" {
+		cmd.Args = append(cmd.Args, user+t.username)
+	}
+	if t.password != "" {
+		cmd.Env = append(cmd.Env, password+t.password)
+	}
+	if postgres {
+		cmd.Args = append(cmd.Args, "--dbname="+t.database)
+	} else {
+		cmd.Args = append(cmd.Args, t.database)
+	}
+}
+
+// Read the connection details of the database from the defaults file
+func (pl *GoLiquibase) backupTarget(scheme string) (*backupTarget, error) {
+	props, err := ReadDefaultsFile(pl.DefaultsFile)
+	if err != nil {
+		return nil, err
+	}
+	jdbcURL := firstNonEmpty(props["url"], props["liquibase.command.url"])
+	m := jdbcURLPattern.FindStringSubmatch(jdbcURL)
+	if m == nil || (m[1] == "postgresql") != (scheme == "postgresql") {
+		return nil, fmt.Errorf("%s has no %s url to back up", pl.DefaultsFile, scheme)
+	}
+	query, err := url.ParseQuery(m[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters in %s: %v", stripURLCredentials(jdbcURL), err)
+	}
+	target := &backupTarget{
+		database: m[3],
+		username: firstNonEmpty(props["username"], props["liquibase.command.username"], query.Get("user")),
+		password: firstNonEmpty(props["password"], props["liquibase.command.password"], query.Get("password")),
+	}
+	// Credentials in the url, user:password@host, are left to the properties
+	hostPort := m[2]
+	if at := strings.LastIndex(hostPort, "@"); at >= 0 {
+		hostPort = hostPort[at+1:]
+	}
+	target.host, target.port, _ = strings.Cut(hostPort, ":")
+	return target, nil
+}
+
+// Take the backup before a run. The dump file or snapshot is named after the run.
+func (pl *GoLiquibase) takeBackup(ctx context.Context, runID string) (*BackupRecord, error) {
+	c := pl.Backup
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	record := &BackupRecord{Type: c.Type, Instance: c.Instance, Region: c.Region, Project: c.Project, SchemaOnly: c.SchemaOnly, Time: time.Now()}
+	name := "goliquify-" + strings.ToLower(runID)
+	cmd := &Command{Path: c.binary(), Env: os.Environ(), Stderr: pl.logger().Writer()}
+
+	switch c.Type {
+	case BACKUP_PG_DUMP, BACKUP_MYSQLDUMP:
+		scheme := "postgresql"
+		if c.Type == BACKUP_MYSQLDUMP {
+			scheme = "mysql"
+		}
+		target, err := pl.backupTarget(scheme)
+		if err != nil {
+			return nil, err
+		}
+		// The journal keeps an absolute path, restores may run from elsewhere
+		dir, err := filepath.Abs(firstNonEmpty(c.Dir, DEFAULT_BACKUP_DIR))
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		record.Database = target.database
+		if c.Type == BACKUP_PG_DUMP {
+			record.Reference = filepath.Join(dir, name+".dump")
+			cmd.Args = []string{"--format=custom", "--file=" + record.Reference}
+			if c.SchemaOnly {
+				cmd.Args = append(cmd.Args, "--schema-only")
+			}
+		} else {
+			record.Reference = filepath.Join(dir, name+".sql")
+			cmd.Args = []string{"--single-transaction", "--routines", "--triggers", "--result-file=" + record.Reference}
+			if c.SchemaOnly {
+				cmd.Args = append(cmd.Args, "--no-data")
+			}
+		}
+		target.connect(cmd, c.Type == BACKUP_PG_DUMP)
+		if err := pl.runner().Run(ctx, cmd); err != nil {
+			os.Remove(record.Reference)
+			return nil, fmt.Errorf("%s failed: %v", c.Type, err)
+		}
+
+	case BACKUP_RDS:
+		// The snapshot must be available before the migration starts
+		var region []string
+		if c.Region != "" {
+			region = []string{"--region", c.Region}
+		}
+		record.Reference = name
+		cmd.Args = append([]string{"rds", "create-db-snapshot", "--db-instance-identifier", c.Instance, "--db-snapshot-identifier", name}, region...)
+		cmd.Stdout = &bytes.Buffer{}
+		if err := pl.runner().Run(ctx, cmd); err != nil {
+			return nil, fmt.Errorf("failed to create RDS snapshot of %s: %v", c.Instance, err)
+		}
+		pl.logger().Printf("Waiting for RDS snapshot %s to become available", name)
+		wait := *cmd
+		wait.Args = append([]string{"rds", "wait", "db-snapshot-available", "--db-snapshot-identifier", name}, region...)
+		if err := pl.runner().Run(ctx, &wait); err != nil {
+			return nil, fmt.Errorf("RDS snapshot %s didn't become available: %v", name, err)
+		}
+
+	case BACKUP_CLOUDSQL:
+		// gcloud waits for the backup, its ID is looked up by description
+		var project []string
+		if c.Project != "" {
+			project = []string{"--project", c.Project}
+		}
+		cmd.Args = append([]string{"sql", "backups", "create", "--instance", c.Instance, "--description", name}, project...)
+		cmd.Stdout = pl.logger().Writer()
+		if err := pl.runner().Run(ctx, cmd); err != nil {
+			return nil, fmt.Errorf("failed to create Cloud SQL backup of %s: %v", c.Instance, err)
+		}
+		var ids bytes.Buffer
+		list := *cmd
+		list.Args = append([]string{"sql", "backups", "list", "--instance", c.Instance, "--filter", "description=" + name, "--format", "value(id)"}, project...)
+		list.Stdout = &ids
+		if err := pl.runner().Run(ctx, &list); err != nil {
+			return nil, fmt.Errorf("failed to look up Cloud SQL backup %s: %v", name, err)
+		}
+		record.Reference = strings.TrimSpace(firstLine(ids.String()))
+		if record.Reference == "" {
+			return nil, fmt.Errorf("no Cloud SQL backup %s of %s found", name, c.Instance)
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,113 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	BASELINE_CHANGELOG = "baseline.xml"
+	BASELINE_SNAPSHOT  = "baseline-snapshot.json"
+)
+
+// Baseline records how an existing database was adopted
+type Baseline struct {
+	Changelog string    `yaml:"changelog"`
+	Snapshot  string    `yaml:"snapshot"`
+	Tag       string    `yaml:"tag,omitempty"`
+	CreatedAt time.Time `yaml:"createdAt"`
+}
+
+// CreateBaseline adopts an existing database: it snapshots the schema, generates
+// a changelog describing it into dir, marks that changelog as applied and
+// optionally tags the result
+func (pl *GoLiquibase) CreateBaseline(dir, tag string) (*Baseline, error) {
+	if tag != "" {
+		if err := ValidateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+	baseline := &Baseline{
+		Changelog: filepath.Join(dir, BASELINE_CHANGELOG),
+		Snapshot:  filepath.Join(dir, BASELINE_SNAPSHOT),
+		Tag:       tag,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if fileExists(baseline.Changelog) {
+		return nil, fmt.Errorf("baseline changelog %s already exists", baseline.Changelog)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	pl.logger().Printf("Snapshotting the database to %s", baseline.Snapshot)
+	if err := pl.Execute("snapshot", "--snapshot-format=json", fmt.Sprintf("--output-file=%s", baseline.Snapshot)); err != nil {
+		return nil, fmt.Errorf("failed to snapshot the database: %v", err)
+	}
+	pl.logger().Printf("Generating the baseline changelog %s", baseline.Changelog)
+	if err := pl.Execute("generate-changelog", fmt.Sprintf("--changelog-file=%s", baseline.Changelog)); err != nil {
+		return nil, fmt.Errorf("failed to generate the baseline changelog: %v", err)
+	}
+
+	// Sync relative to the baseline directory so the recorded file name does not depend on where we run
+	opts := ExecOptions{Args: []string{fmt.Sprintf("--search-path=%s", dir)}}
+	changelogArg := fmt.Sprintf("--changelog-file=%s", BASELINE_CHANGELOG)
+	if err := pl.ExecuteWithOptions(context.Background(), opts, "changelog-sync", changelogArg); err != nil {
+		return nil, fmt.Errorf("failed to mark the baseline as applied: %v", err)
+	}
+	if tag != "" {
+		if err := pl.Execute("tag", fmt.Sprintf("--tag=%s", tag)); err != nil {
+			return nil, fmt.Errorf("failed to tag the baseline: %v", err)
+		}
+	}
+	return baseline, nil
+}
+
+// SaveBaseline records the baseline in the config file, keeping the rest of
+// the file including its comments as it is
+func SaveBaseline(configPath string, baseline *Baseline) error {
+	var doc yaml.Node
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", configPath, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s is not a mapping", configPath)
+	}
+
+	var value yaml.Node
+	if err := value.Encode(baseline); err != nil {
+		return err
+	}
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "baseline" {
+			root.Content[i+1] = &value
+			replaced = true
+		}
+	}
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "baseline"}, &value)
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, out.Bytes(), 0644)
+}
@@ -0,0 +1,403 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Branch providers built in
+const (
+	BRANCH_NEON        = "neon"
+	BRANCH_PLANETSCALE = "planetscale"
+)
+
+const (
+	PLANETSCALE_API_URL          = "https://api.planetscale.com/v1"
+	PLANETSCALE_TOKEN_ID_ENV     = "PLANETSCALE_SERVICE_TOKEN_ID"
+	PLANETSCALE_TOKEN_ENV        = "PLANETSCALE_SERVICE_TOKEN"
+	PLANETSCALE_READY_TIMEOUT    = 5 * time.Minute
+	PLANETSCALE_DEFAULT_BRANCH   = "main"
+	BRANCH_PLUGIN_PREFIX         = "goliquify-branch-"
+	BRANCH_PLUGIN_ENV_PREFIX     = "GOLIQUIFY_BRANCH_"
+	DEFAULT_BRANCH_POLL_INTERVAL = 2 * time.Second
+)
+
+// Steps of a branch deploy
+const (
+	BRANCH_STEP_CREATE  = "branch"
+	BRANCH_STEP_MIGRATE = "migrate-branch"
+	BRANCH_STEP_VERIFY  = "verify-branch"
+	BRANCH_STEP_APPLY   = "apply"
+)
+
+// BranchConfig is how an environment's database is branched for branch deploys
+type BranchConfig struct {
+	// Provider is neon, planetscale, one registered with RegisterBranchProvider,
+	// or else a plugin: the executable goliquify-branch-<provider> on the PATH
+	Provider string `yaml:"provider"`
+	// Project is the Neon project ID
+	Project string `yaml:"project"`
+	// Organization and Database are the PlanetScale organization and database
+	Organization string `yaml:"organization"`
+	Database     string `yaml:"database"`
+	// Parent is the branch branched from, the provider's default branch by default
+	Parent string `yaml:"parent"`
+	// Options are passed to plugins
+	Options map[string]string `yaml:"options"`
+	// Verify are queries run on the branch after the migration
+	Verify []VerificationQuery `yaml:"verify"`
+}
+
+// VerificationQuery checks the migrated branch. It fails when it errors, or
+// when the first column of its first row isn't the expected value.
+type VerificationQuery struct {
+	Name string `yaml:"name" json:"name"`
+	SQL  string `yaml:"sql" json:"sql"`
+	// Expect is the expected value, any result passes when empty
+	Expect string `yaml:"expect" json:"expect,omitempty"`
+}
+
+// BranchProviderFactory creates the provider of a branch config
+type BranchProviderFactory func(pl *GoLiquibase, c *BranchConfig) (CloneProvider, error)
+
+var (
+	branchProvidersMu sync.RWMutex
+	branchProviders   = map[string]BranchProviderFactory{
+		BRANCH_NEON:        newNeonBranchProvider,
+		BRANCH_PLANETSCALE: newPlanetScaleBranchProvider,
+	}
+)
+
+// RegisterBranchProvider adds a branch provider, or replaces one of the same name
+func RegisterBranchProvider(name string, factory BranchProviderFactory) {
+	branchProvidersMu.Lock()
+	defer branchProvidersMu.Unlock()
+	branchProviders[name] = factory
+}
+
+// BranchProvider returns the provider of a branch config: a registered one,
+// or else a plugin on the PATH
+func (pl *GoLiquibase) BranchProvider(c *BranchConfig) (CloneProvider, error) {
+	if c.Provider == "" {
+		return nil, fmt.Errorf("no branch provider configured")
+	}
+	branchProvidersMu.RLock()
+	factory, ok := branchProviders[c.Provider]
+	branchProvidersMu.RUnlock()
+	if ok {
+		return factory(pl, c)
+	}
+	plugin, err := exec.LookPath(BRANCH_PLUGIN_PREFIX + c.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("unknown branch provider %q, and no plugin %s%s on the PATH", c.Provider, BRANCH_PLUGIN_PREFIX, c.Provider)
+	}
+	return &pluginBranchProvider{pl: pl, path: plugin, config: c}, nil
+}
+
+func newNeonBranchProvider(pl *GoLiquibase, c *BranchConfig) (CloneProvider, error) {
+	return pl.neonProvider(c.Project, c.Parent)
+}
+
+// Create a Neon provider for the database of the defaults file
+func (pl *GoLiquibase) neonProvider(project, parent string) (*NeonCloneProvider, error) {
+	if project == "" {
+		return nil, fmt.Errorf("neon branches need a project")
+	}
+	apiKey := os.Getenv(NEON_API_KEY_ENV)
+	if apiKey == "" {
+		return nil, fmt.Errorf("neon branches need an API key in %s", NEON_API_KEY_ENV)
+	}
+	provider := &NeonCloneProvider{Project: project, Parent: parent, APIKey: apiKey}
+	if target, err := pl.backupTarget("postgresql"); err == nil {
+		provider.Database = target.database
+	}
+	return provider, nil
+}
+
+// PlanetScaleBranchProvider creates PlanetScale branches with an admin password
+type PlanetScaleBranchProvider struct {
+	Organization string
+	Database     string
+	// Parent is the branch branched from, main when empty
+	Parent  string
+	TokenID string
+	Token   string
+	// BaseURL of the PlanetScale API, PLANETSCALE_API_URL by default
+	BaseURL string
+	// PollInterval is how often the branch is checked for readiness
+	PollInterval time.Duration
+}
+
+func newPlanetScaleBranchProvider(pl *GoLiquibase, c *BranchConfig) (CloneProvider, error) {
+	if c.Organization == "" || c.Database == "" {
+		return nil, fmt.Errorf("planetscale branches need an organization and a database")
+	}
+	tokenID, token := os.Getenv(PLANETSCALE_TOKEN_ID_ENV), os.Getenv(PLANETSCALE_TOKEN_ENV)
+	if tokenID == "" || token == "" {
+		return nil, fmt.Errorf("planetscale branches need a service token in %s and %s", PLANETSCALE_TOKEN_ID_ENV, PLANETSCALE_TOKEN_ENV)
+	}
+	return &PlanetScaleBranchProvider{Organization: c.Organization, Database: c.Database, Parent: c.Parent, TokenID: tokenID, Token: token}, nil
+}
+
+// Send a request about a branch of the database to the PlanetScale API
+func (p *PlanetScaleBranchProvider) request(ctx context.Context, method, path string, body, result any) error {
+	base := fmt.Sprintf("%s/organizations/%s/databases/%s/branches", firstNonEmpty(p.BaseURL, PLANETSCALE_API_URL), url.PathEscape(p.Organization), url.PathEscape(p.Database))
+	return apiRequest(ctx, "PlanetScale", method, base+path, p.TokenID+":"+p.Token, body, result)
+}
+
+// Provision creates the branch, waits for it and creates its password
+func (p *PlanetScaleBranchProvider) Provision(ctx context.Context, name string) (*Clone, error) {
+	body := map[string]string{"name": name, "parent_branch": firstNonEmpty(p.Parent, PLANETSCALE_DEFAULT_BRANCH)}
+	if err := p.request(ctx, http.MethodPost, "", body, nil); err != nil {
+		return nil, err
+	}
+	clone := &Clone{Name: name, ID: name}
+	interval := p.PollInterval
+	if interval <= 0 {
+		interval = DEFAULT_BRANCH_POLL_INTERVAL
+	}
+	deadline := time.Now().Add(PLANETSCALE_READY_TIMEOUT)
+	for {
+		var branch struct {
+			Ready bool `json:"ready"`
+		}
+		if err := p.request(ctx, http.MethodGet, "/"+url.PathEscape(name), nil, &branch); err != nil {
+			p.Teardown(ctx, clone)
+			return nil, err
+		}
+		if branch.Ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			p.Teardown(ctx, clone)
+			return nil, fmt.Errorf("PlanetScale branch %s wasn't ready within %s", name, PLANETSCALE_READY_TIMEOUT)
+		}
+		select {
+		case <-ctx.Done():
+			p.Teardown(context.WithoutCancel(ctx), clone)
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	var password struct {
+		Username      string `json:"username"`
+		PlainText     string `json:"plain_text"`
+		AccessHostURL string `json:"access_host_url"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/"+url.PathEscape(name)+"/passwords", map[string]string{"name": name, "role": "admin"}, &password); err != nil {
+		p.Teardown(ctx, clone)
+		return nil, err
+	}
+	clone.URL = fmt.Sprintf("jdbc:mysql://%s/%s?sslMode=REQUIRED", password.AccessHostURL, p.Database)
+	clone.Username, clone.Password = password.Username, password.PlainText
+	return clone, nil
+}
+
+// Teardown deletes the branch and its passwords
+func (p *PlanetScaleBranchProvider) Teardown(ctx context.Context, clone *Clone) error {
+	return p.request(ctx, http.MethodDelete, "/"+url.PathEscape(clone.ID), nil, nil)
+}
+
+// pluginBranchProvider runs a goliquify-branch-<provider> executable. It is
+// run as "create <name>", printing the branch as JSON with the fields id,
+// url, username and password, and as "delete <id> <name>". The parent branch
+// and options are passed as GOLIQUIFY_BRANCH_PARENT and GOLIQUIFY_BRANCH_<OPTION>.
+type pluginBranchProvider struct {
+	pl     *GoLiquibase
+	path   string
+	config *BranchConfig
+}
+
+// Run the plugin, returning its output
+func (p *pluginBranchProvider) run(ctx context.Context, args ...string) ([]byte, error) {
+	env := os.Environ()
+	if p.config.Parent != "" {
+		env = append(env, BRANCH_PLUGIN_ENV_PREFIX+"PARENT="+p.config.Parent)
+	}
+	for _, key := range sortedKeys(p.config.Options) {
+		name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+		env = append(env, BRANCH_PLUGIN_ENV_PREFIX+name+"="+p.config.Options[key])
+	}
+	var stdout bytes.Buffer
+	cmd := &Command{Path: p.path, Args: args, Env: env, Stdout: &stdout, Stderr: p.pl.logger().Writer()}
+	if err := p.pl.runner().Run(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("branch plugin %s %s failed: %v", p.path, args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Provision creates the branch with the plugin
+func (p *pluginBranchProvider) Provision(ctx context.Context, name string) (*Clone, error) {
+	out, err := p.run(ctx, "create", name)
+	if err != nil {
+		return nil, err
+	}
+	var branch struct {
+		ID       string `json:"id"`
+		URL      string `json:"url"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(out, &branch); err != nil {
+		return nil, fmt.Errorf("failed to parse the branch printed by %s: %v", p.path, err)
+	}
+	clone := &Clone{Name: name, ID: firstNonEmpty(branch.ID, name), URL: branch.URL, Username: branch.Username, Password: branch.Password}
+	if clone.URL == "" {
+		p.Teardown(ctx, clone)
+		return nil, fmt.Errorf("%s printed no JDBC url for branch %s", p.path, name)
+	}
+	return clone, nil
+}
+
+// Teardown deletes the branch with the plugin
+func (p *pluginBranchProvider) Teardown(ctx context.Context, clone *Clone) error {
+	_, err := p.run(ctx, "delete", clone.ID, clone.Name)
+	return err
+}
+
+// BranchDeployOptions configure a branch deploy
+type BranchDeployOptions struct {
+	Branches CloneProvider
+	// Verify are queries run on the migrated branch
+	Verify []VerificationQuery
+	// OpenDB opens the branch through database/sql for the verification queries
+	OpenDB func(dialect, dsn string) (*sql.DB, error)
+	// Keep leaves the branch up after the deploy
+	Keep bool
+	// Args are added to both updates, e.g. --contexts
+	Args []string
+}
+
+// BranchDeploy creates a branch of the database, migrates it, runs the
+// verification queries on it and only when they pass updates the database
+// itself. The branch is deleted afterwards. The steps are reported like a
+// pipeline's, the report is returned even when a step fails.
+func (pl *GoLiquibase) BranchDeploy(ctx context.Context, opts BranchDeployOptions) (*PipelineReport, error) {
+	if len(opts.Verify) > 0 && opts.OpenDB == nil {
+		return nil, fmt.Errorf("verification queries need a database/sql driver for the branch")
+	}
+	for i, query := range opts.Verify {
+		if strings.TrimSpace(query.SQL) == "" {
+			return nil, fmt.Errorf("verification query %d has no sql", i+1)
+		}
+	}
+	dir, err := os.MkdirTemp("", "goliquify-branch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var clone *Clone
+	defer func() {
+		if clone == nil || opts.Keep {
+			return
+		}
+		pl.logger().Printf("Deleting branch %s", clone.Name)
+		if err := opts.Branches.Teardown(context.WithoutCancel(ctx), clone); err != nil {
+			pl.logger().Printf("Failed to delete branch %s: %v", clone.Name, err)
+		}
+	}()
+
+	// Every step needs the ones before it to succeed, the rest are skipped
+	report := &PipelineReport{Environment: pl.Environment, Status: STEP_SUCCEEDED, Start: time.Now()}
+	var stepErr error
+	step := func(name string, run func() (map[string]string, error)) {
+		result := StepResult{Name: name, Run: name, Status: STEP_SKIPPED}
+		if stepErr == nil {
+			pl.logger().Printf("Branch deploy step %s", name)
+			result.Start = time.Now()
+			details, err := run()
+			result.DurationMs = time.Since(result.Start).Milliseconds()
+			result.Details = details
+			result.Status = STEP_SUCCEEDED
+			if err != nil {
+				result.Status, result.Error = STEP_FAILED, err.Error()
+				report.Status = STEP_FAILED
+				stepErr = fmt.Errorf("branch deploy step %s failed: %v", name, err)
+			}
+		}
+		report.Steps = append(report.Steps, result)
+	}
+
+	var branch *GoLiquibase
+	step(BRANCH_STEP_CREATE, func() (map[string]string, error) {
+		name := "goliquify-" + strings.ToLower(newRunID(time.Now()))
+		var err error
+		if clone, err = opts.Branches.Provision(ctx, name); err != nil {
+			return nil, err
+		}
+		if opts.Keep {
+			pl.logger().Printf("Keeping branch %s", clone.Name)
+		}
+		if branch, err = pl.onClone(clone, dir); err != nil {
+			return nil, err
+		}
+		return map[string]string{"branch": clone.Name, "url": stripURLCredentials(clone.URL)}, nil
+	})
+	step(BRANCH_STEP_MIGRATE, func() (map[string]string, error) {
+		return nil, branch.ExecuteWithOptions(ctx, ExecOptions{}, append([]string{"update"}, opts.Args...)...)
+	})
+	step(BRANCH_STEP_VERIFY, func() (map[string]string, error) {
+		return branch.verifyBranch(ctx, opts.OpenDB, opts.Verify)
+	})
+	step(BRANCH_STEP_APPLY, func() (map[string]string, error) {
+		return nil, pl.ExecuteWithOptions(ctx, ExecOptions{}, append([]string{"update"}, opts.Args...)...)
+	})
+	report.End = time.Now()
+	report.DurationMs = report.End.Sub(report.Start).Milliseconds()
+	return report, stepErr
+}
+
+// Run the verification queries on a branch, reporting their results
+func (pl *GoLiquibase) verifyBranch(ctx context.Context, open func(dialect, dsn string) (*sql.DB, error), queries []VerificationQuery) (map[string]string, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	dialect, dsn, err := pl.DatabaseDSN()
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	details := map[string]string{}
+	var failed []string
+	for i, query := range queries {
+		name := firstNonEmpty(query.Name, fmt.Sprintf("query %d", i+1))
+		var value sql.NullString
+		err := db.QueryRowContext(ctx, query.SQL).Scan(&value)
+		switch {
+		case err == sql.ErrNoRows && query.Expect == "":
+			details[name] = "no rows"
+		case err == sql.ErrNoRows:
+			details[name] = "no rows"
+			failed = append(failed, fmt.Sprintf("%s returned no rows, expected %s", name, query.Expect))
+		case err != nil:
+			details[name] = "error"
+			failed = append(failed, fmt.Sprintf("%s failed: %v", name, err))
+		case query.Expect != "" && value.String != query.Expect:
+			details[name] = value.String
+			failed = append(failed, fmt.Sprintf("%s returned %s, expected %s", name, value.String, query.Expect))
+		default:
+			details[name] = value.String
+		}
+	}
+	if len(failed) > 0 {
+		return details, fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return details, nil
+}
@@ -0,0 +1,262 @@
+package goliquify
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Marker in every cached toolchain, its modification time is the last use
+	CACHE_USED_FILE = ".goliquify-used"
+	// Marker in the cache dir, its modification time is the last automatic prune
+	CACHE_PRUNED_FILE = ".goliquify-pruned"
+	// How often the cache is pruned automatically
+	CACHE_PRUNE_INTERVAL = 24 * time.Hour
+	// Prefix of entries being removed, renamed first so a half removed toolchain is never used
+	CACHE_TRASH_PREFIX = ".trash-"
+)
+
+// Kinds of cache entries
+const (
+	CACHE_LIQUIBASE = "liquibase"
+	CACHE_JRE       = "jre"
+)
+
+// CacheEntry is a toolchain in the cache dir
+type CacheEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Kind     string    `json:"kind"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// CacheRetention limits the toolchains kept in the cache dir. The Keep most
+// recently used of each kind are always kept, the others are removed once
+// unused for MaxAge, or right away when MaxAge is zero.
+type CacheRetention struct {
+	Keep   int
+	MaxAge time.Duration
+	// Auto prunes the cache in the background after installs
+	Auto bool
+}
+
+// CacheConfig is the cache retention of the config file, ages like 90d or 2160h
+type CacheConfig struct {
+	Keep      int    `yaml:"keep"`
+	MaxAge    string `yaml:"maxAge"`
+	AutoPrune bool   `yaml:"autoPrune"`
+}
+
+// Retention converts the config into a retention, nil when nothing is configured
+func (c CacheConfig) Retention() (*CacheRetention, error) {
+	if c.Keep == 0 && c.MaxAge == "" {
+		return nil, nil
+	}
+	maxAge, err := ParseAge(c.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+	return &CacheRetention{Keep: c.Keep, MaxAge: maxAge, Auto: c.AutoPrune}, nil
+}
+
+// ParseAge parses a duration that may be given in days, e.g. 90d, or as a Go duration
+func ParseAge(age string) (time.Duration, error) {
+	if age == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %s, expecting days like 90d or a duration like 12h", age)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %s, expecting days like 90d or a duration like 12h", age)
+	}
+	return d, nil
+}
+
+// Record the use of the cache entry holding path
+func touchCacheEntry(cacheDir, path string) {
+	rel, err := filepath.Rel(cacheDir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+	marker := filepath.Join(cacheDir, strings.Split(filepath.ToSlash(rel), "/")[0], CACHE_USED_FILE)
+	now := time.Now()
+	if err := os.Chtimes(marker, now, now); err != nil {
+		os.WriteFile(marker, nil, 0644)
+	}
+}
+
+// ListCache lists the Liquibase and JRE toolchains in a cache dir, most recently used first
+func ListCache(cacheDir string) ([]CacheEntry, error) {
+	dirs, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	liquibasePrefix := strings.TrimSuffix(LIQUIBASE_DIR, "{version}")
+	jrePrefix := strings.TrimSuffix(JRE_DIR, "{version}")
+	var entries []CacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		entry := CacheEntry{Name: d.Name(), Path: filepath.Join(cacheDir, d.Name())}
+		switch {
+		case strings.HasPrefix(d.Name(), liquibasePrefix):
+			entry.Kind = CACHE_LIQUIBASE
+		case strings.HasPrefix(d.Name(), jrePrefix):
+			entry.Kind = CACHE_JRE
+		default:
+			continue
+		}
+		// Toolchains installed before usage was tracked count from their install
+		info, err := os.Stat(filepath.Join(entry.Path, CACHE_USED_FILE))
+		if err != nil {
+			info, err = d.Info()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.LastUsed = info.ModTime()
+		entry.Size = dirSize(entry.Path)
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+	return entries, nil
+}
+
+// Total size of the files under a directory
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// CacheGarbage picks the entries a retention removes, never the protected
+// ones. Entries are expected most recently used first, as ListCache returns them.
+func CacheGarbage(entries []CacheEntry, retention CacheRetention, now time.Time, protected ...string) []CacheEntry {
+	var garbage []CacheEntry
+	kept := map[string]int{}
+	for _, entry := range entries {
+		if containsString(protected, entry.Name) {
+			kept[entry.Kind]++
+			continue
+		}
+		if kept[entry.Kind] < retention.Keep {
+			kept[entry.Kind]++
+			continue
+		}
+		if retention.MaxAge > 0 && now.Sub(entry.LastUsed) < retention.MaxAge {
+			continue
+		}
+		garbage = append(garbage, entry)
+	}
+	return garbage
+}
+
+// Remove cache entries, each is renamed out of the way first so no run picks
+// up a partly removed toolchain. Leftovers of interrupted removals go too.
+func removeCacheEntries(cacheDir string, entries []CacheEntry) error {
+	for _, entry := range entries {
+		trash := filepath.Join(cacheDir, CACHE_TRASH_PREFIX+entry.Name)
+		if err := os.Rename(entry.Path, trash); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(trash); err != nil {
+			return err
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(cacheDir, CACHE_TRASH_PREFIX+"*"))
+	for _, leftover := range leftovers {
+		os.RemoveAll(leftover)
+	}
+	return nil
+}
+
+// The cache entries the instance runs with, which pruning leaves alone
+func (pl *GoLiquibase) cacheEntriesInUse() []string {
+	installMu.Lock()
+	defer installMu.Unlock()
+	var names []string
+	cacheDir := pl.cacheDir()
+	for _, path := range []string{pl.LiquibaseDir, pl.javaHome} {
+		if rel, err := filepath.Rel(cacheDir, path); path != "" && err == nil && !strings.HasPrefix(rel, "..") {
+			names = append(names, strings.Split(filepath.ToSlash(rel), "/")[0])
+		}
+	}
+	// Before the install the configured version is the one in use
+	if pl.LiquibaseDir == "" && pl.Version != "" && !IsVersionChannel(pl.Version) {
+		names = append(names, versioned(LIQUIBASE_DIR, pl.Version))
+	}
+	return names
+}
+
+// CachedToolchains lists the toolchains in the instance's cache dir, most recently used first
+func (pl *GoLiquibase) CachedToolchains() ([]CacheEntry, error) {
+	return ListCache(pl.cacheDir())
+}
+
+// PruneCache removes the toolchains the retention doesn't keep from the cache
+// dir, except the ones this instance runs with. With dryRun nothing is
+// removed. Returns the entries removed.
+func (pl *GoLiquibase) PruneCache(retention CacheRetention, dryRun bool) ([]CacheEntry, error) {
+	if retention.Keep < 0 || retention.MaxAge < 0 {
+		return nil, fmt.Errorf("cache retention must not be negative")
+	}
+	cacheDir := pl.cacheDir()
+	entries, err := ListCache(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	garbage := CacheGarbage(entries, retention, time.Now(), pl.cacheEntriesInUse()...)
+	if dryRun {
+		return garbage, nil
+	}
+	return garbage, removeCacheEntries(cacheDir, garbage)
+}
+
+// Prune the cache in the background, at most once per CACHE_PRUNE_INTERVAL
+func (pl *GoLiquibase) autoPruneCache(cacheDir string) {
+	if pl.CacheRetention == nil || !pl.CacheRetention.Auto {
+		return
+	}
+	marker := filepath.Join(cacheDir, CACHE_PRUNED_FILE)
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < CACHE_PRUNE_INTERVAL {
+		return
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return
+	}
+	retention := *pl.CacheRetention
+	go func() {
+		removed, err := pl.PruneCache(retention, false)
+		if err != nil {
+			pl.logger().Printf("Failed to prune the cache in %s: %v", cacheDir, err)
+			return
+		}
+		for _, entry := range removed {
+			pl.logger().Printf("Pruned %s from the cache, last used %s", entry.Name, entry.LastUsed.Format(time.DateOnly))
+		}
+	}()
+}
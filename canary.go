@@ -0,0 +1,164 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Time between health checks while canaries bake
+const DEFAULT_CANARY_INTERVAL = 30 * time.Second
+
+// Status of the shards a canary rollout didn't continue to
+const TARGET_STATUS_HALTED = "halted"
+
+// Environment variable listing the canaries to a health check command
+const CANARIES_ENV = "GOLIQUIFY_CANARIES"
+
+// CanaryConfig is the health check watching canaries
+type CanaryConfig struct {
+	// HealthCheck is an http(s) URL answering 2xx while healthy, or a shell
+	// command exiting 0, which gets the canaries in GOLIQUIFY_CANARIES
+	HealthCheck string `yaml:"healthCheck"`
+	// Interval between health checks while baking, 30s by default
+	Interval time.Duration `yaml:"interval"`
+}
+
+// CanaryOptions configure a canary rollout over shards
+type CanaryOptions struct {
+	CanaryConfig
+	// Count of shards migrated first
+	Count int
+	// Bake is how long the canaries are watched before the rest follow
+	Bake time.Duration
+}
+
+// Canary migrates a fleet of shards in two waves: the first Count shards,
+// then, once they succeeded and stayed healthy for the bake time, the rest.
+// A failing canary or health check halts the rollout, leaving the rest of
+// the shards untouched with the status halted. run migrates a wave, done
+// holds the shards that succeeded before it.
+func (pl *GoLiquibase) Canary(ctx context.Context, opts CanaryOptions, shards []string, run func(wave []string, done map[string]bool) []TargetResult) []TargetResult {
+	if opts.Count <= 0 || opts.Count >= len(shards) {
+		return run(shards, map[string]bool{})
+	}
+	canaries, rest := shards[:opts.Count], shards[opts.Count:]
+	pl.logger().Printf("Canary rollout: migrating %s first", strings.Join(canaries, ", "))
+	results := run(canaries, map[string]bool{})
+
+	done := map[string]bool{}
+	var problem string
+	for _, r := range results {
+		if r.Status == "success" {
+			done[r.Target] = true
+		} else if problem == "" {
+			problem = fmt.Sprintf("canary %s %s", r.Target, r.Status)
+		}
+	}
+	if problem == "" {
+		if err := pl.bake(ctx, opts, canaries); err != nil {
+			problem = err.Error()
+		}
+	}
+	if problem != "" {
+		pl.logger().Printf("Canary rollout halted: %s", problem)
+		for _, shard := range rest {
+			results = append(results, TargetResult{Target: shard, Command: results[0].Command, Status: TARGET_STATUS_HALTED, Error: problem})
+		}
+		return results
+	}
+	pl.logger().Printf("Canaries are healthy, migrating the other %d shard(s)", len(rest))
+	return append(results, run(rest, done)...)
+}
+
+// CanaryTargets runs a command against targets in order as a canary rollout
+func (pl *GoLiquibase) CanaryTargets(ctx context.Context, opts CanaryOptions, targets []*Target, command string, arguments ...string) []TargetResult {
+	byName := map[string]*Target{}
+	var names []string
+	for _, t := range targets {
+		byName[t.Name] = t
+		names = append(names, t.Name)
+	}
+	return pl.Canary(ctx, opts, names, func(wave []string, done map[string]bool) []TargetResult {
+		var waveTargets []*Target
+		for _, name := range wave {
+			waveTargets = append(waveTargets, byName[name])
+		}
+		return pl.runTargets(targets, waveTargets, done, command, arguments...)
+	})
+}
+
+// CanarySchemas runs a command against schemas as a canary rollout
+func (pl *GoLiquibase) CanarySchemas(ctx context.Context, opts CanaryOptions, schemas []string, command string, arguments ...string) []TargetResult {
+	if len(schemas) == 0 {
+		schemas = pl.Schemas
+	}
+	return pl.Canary(ctx, opts, schemas, func(wave []string, done map[string]bool) []TargetResult {
+		return pl.ForEachSchema(wave, command, arguments...)
+	})
+}
+
+// Watch the canaries for the bake time, failing on the first failed health check
+func (pl *GoLiquibase) bake(ctx context.Context, opts CanaryOptions, canaries []string) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DEFAULT_CANARY_INTERVAL
+	}
+	if opts.Bake > 0 {
+		pl.logger().Printf("Baking the canaries for %s", opts.Bake)
+	}
+	deadline := time.Now().Add(opts.Bake)
+	for {
+		if opts.HealthCheck != "" {
+			if err := checkCanaryHealth(ctx, opts.HealthCheck, canaries); err != nil {
+				return fmt.Errorf("health check failed: %v", err)
+			}
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(min(wait, interval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Run a health check once
+func checkCanaryHealth(ctx context.Context, check string, canaries []string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if strings.HasPrefix(check, "http://") || strings.HasPrefix(check, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, check, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s answered %s", check, resp.Status)
+		}
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", check)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", check)
+	}
+	cmd.Env = append(os.Environ(), CANARIES_ENV+"="+strings.Join(canaries, ","))
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
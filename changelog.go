@@ -0,0 +1,576 @@
+package goliquify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File extensions Liquibase reads changelogs from
+var CHANGELOG_EXTENSIONS = []string{".xml", ".yaml", ".yml", ".json", ".sql"}
+
+var (
+	sqlChangesetPattern     = regexp.MustCompile(`^--\s*changeset\s+(?:"([^"]+)"|([^:\s]+)):(\S+)(.*)$`)
+	sqlValidCheckSumPattern = regexp.MustCompile(`^--\s*validCheckSum:?\s*(.+)$`)
+	sqlIncludePattern       = regexp.MustCompile(`^--\s*include\s+file:(\S+)`)
+	sqlAttributePattern     = regexp.MustCompile(`(\w+):("[^"]*"|\S+)`)
+	sqlRollbackPattern      = regexp.MustCompile(`^--\s*rollback\b`)
+	sqlCommentPattern       = regexp.MustCompile(`^--\s*comment:?\s*(.*)$`)
+)
+
+// ChangeSet is a changeset definition found in a changelog file
+type ChangeSet struct {
+	ID     string `json:"id"`
+	Author string `json:"author"`
+	// File is the changelog path as Liquibase records it, its logicalFilePath when set
+	File string `json:"file"`
+	// Pinned is set when File is a logicalFilePath, which doesn't change when the file moves
+	Pinned bool `json:"pinned,omitempty"`
+	// Line of the changeset definition in the file
+	Line           int      `json:"line"`
+	Context        string   `json:"context,omitempty"`
+	Labels         string   `json:"labels,omitempty"`
+	Comment        string   `json:"comment,omitempty"`
+	ValidCheckSums []string `json:"validCheckSums,omitempty"`
+	// Changes are the change types in the changeset, e.g. createTable or sql
+	Changes []string `json:"changes,omitempty"`
+	// Rollback is set when the changeset defines its own rollback
+	Rollback bool `json:"rollback,omitempty"`
+	// Body is the source of the changeset definition
+	Body string `json:"-"`
+	// EndLine is the last line of the definition
+	EndLine int `json:"-"`
+}
+
+// Key returns the file::id::author coordinates Liquibase identifies the changeset by
+func (cs *ChangeSet) Key() string {
+	return fmt.Sprintf("%s::%s::%s", cs.File, cs.ID, cs.Author)
+}
+
+// Include is a reference to another changelog file
+type Include struct {
+	// File is the included path as Liquibase records it
+	File string
+	Line int
+	// All is set for includeAll, File is then a directory
+	All bool
+	// Relative is set when the path is relative to the including file
+	Relative bool
+	// Missing is set when the file can't be found on the search path
+	Missing bool
+}
+
+// ChangelogFile is a parsed changelog file
+type ChangelogFile struct {
+	// Path as Liquibase records it, relative to the search path
+	Path string
+	// DiskPath is where the file was read from
+	DiskPath string
+	// LogicalFilePath recorded for the changesets instead of Path, when set
+	LogicalFilePath string
+	ChangeSets      []*ChangeSet
+	Includes        []Include
+}
+
+// ChangelogTree is a root changelog and every changelog it includes
+type ChangelogTree struct {
+	Files      []*ChangelogFile
+	ChangeSets []*ChangeSet
+	// DoubleIncludes are includes of files an earlier include already loaded
+	DoubleIncludes []DoubleInclude
+}
+
+// DoubleInclude is a changelog included a second time, which Liquibase
+// either skips or fails on as duplicate changesets
+type DoubleInclude struct {
+	// File is the included path
+	File string
+	// By is the disk path of the including changelog
+	By   string
+	Line int
+	// FirstBy is the disk path of the changelog including it first, empty
+	// for the root changelog
+	FirstBy   string
+	FirstLine int
+}
+
+// ParseChangelogFile parses one changelog file. name is the path Liquibase
+// knows the file by and is used in the changeset coordinates.
+func ParseChangelogFile(diskPath, name string) (*ChangelogFile, error) {
+	content, err := os.ReadFile(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseChangelogContent(diskPath, name, content)
+}
+
+// Parse changelog content, the format is picked by the extension of diskPath
+func parseChangelogContent(diskPath, name string, content []byte) (*ChangelogFile, error) {
+	var err error
+	file := &ChangelogFile{Path: name, DiskPath: diskPath}
+	switch strings.ToLower(filepath.Ext(diskPath)) {
+	case ".xml":
+		err = parseXMLChangelog(file, content)
+	case ".yaml", ".yml", ".json":
+		err = parseYAMLChangelog(file, content)
+	case ".sql":
+		err = parseSQLChangelog(file, content)
+	default:
+		err = fmt.Errorf("unsupported changelog format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", diskPath, err)
+	}
+	return file, nil
+}
+
+// LoadChangelogTree parses a root changelog and follows its includes. The
+// files are looked up in the search path directories, the current directory
+// when none are given. Includes that can't be found are kept as Missing.
+func LoadChangelogTree(changelogFile string, searchPath []string) (*ChangelogTree, error) {
+	if len(searchPath) == 0 {
+		searchPath = []string{"."}
+	}
+	tree := &ChangelogTree{}
+	type site struct {
+		by   string
+		line int
+	}
+	seen := map[string]site{}
+
+	var load func(name string, by site) error
+	load = func(name string, by site) error {
+		name = path.Clean(filepath.ToSlash(name))
+		if first, ok := seen[name]; ok {
+			tree.DoubleIncludes = append(tree.DoubleIncludes, DoubleInclude{File: name, By: by.by, Line: by.line, FirstBy: first.by, FirstLine: first.line})
+			return nil
+		}
+		seen[name] = by
+
+		diskPath, ok := findOnSearchPath(name, searchPath)
+		if !ok {
+			return fmt.Errorf("changelog %s not found in search path %s", name, strings.Join(searchPath, ","))
+		}
+		file, err := ParseChangelogFile(diskPath, name)
+		if err != nil {
+			return err
+		}
+		tree.Files = append(tree.Files, file)
+		tree.ChangeSets = append(tree.ChangeSets, file.ChangeSets...)
+
+		for i, inc := range file.Includes {
+			files := []string{inc.File}
+			if inc.All {
+				files = findChangelogsInDir(inc.File, searchPath)
+			} else if _, ok := findOnSearchPath(inc.File, searchPath); !ok {
+				files = nil
+			}
+			if len(files) == 0 {
+				file.Includes[i].Missing = true
+				continue
+			}
+			for _, f := range files {
+				if err := load(f, site{file.DiskPath, inc.Line}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := load(changelogFile, site{}); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Find returns the changeset with the given file::id::author coordinates.
+// Files are compared leniently since Liquibase may record them with a
+// different prefix, e.g. classpath: or an absolute path.
+func (t *ChangelogTree) Find(key string) *ChangeSet {
+	file, id, author, ok := splitChangesetKey(key)
+	if !ok {
+		return nil
+	}
+	var match *ChangeSet
+	for _, cs := range t.ChangeSets {
+		if cs.ID != id || cs.Author != author {
+			continue
+		}
+		if cs.File == file {
+			return cs
+		}
+		if sameChangelogPath(cs.File, file) {
+			match = cs
+		}
+	}
+	return match
+}
+
+// FindFile returns the parsed file with the given path
+func (t *ChangelogTree) FindFile(name string) *ChangelogFile {
+	for _, f := range t.Files {
+		if f.Path == name || sameChangelogPath(f.Path, name) {
+			return f
+		}
+	}
+	for _, f := range t.Files {
+		if f.LogicalFilePath != "" && sameChangelogPath(f.LogicalFilePath, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Split file::id::author coordinates
+func splitChangesetKey(key string) (file, id, author string, ok bool) {
+	parts := strings.SplitN(key, "::", 3)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// Normalize a changelog path as Liquibase may record it: slash separated,
+// also when recorded on Windows, cleaned and without a classpath: prefix
+func normalizeChangelogPath(name string) string {
+	return path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "classpath:"))
+}
+
+// Check if two changelog paths name the same file, ignoring a classpath:
+// prefix and leading directories one of them may lack
+func sameChangelogPath(a, b string) bool {
+	a, b = normalizeChangelogPath(a), normalizeChangelogPath(b)
+	if a == b {
+		return true
+	}
+	return strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// Find a changelog on the search path
+func findOnSearchPath(name string, searchPath []string) (string, bool) {
+	if filepath.IsAbs(name) {
+		return name, fileExists(name)
+	}
+	for _, dir := range searchPath {
+		candidate := filepath.Join(dir, filepath.FromSlash(name))
+		if fileExists(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// List the changelogs in an includeAll directory on the search path, in the
+// alphabetical order Liquibase applies them in
+func findChangelogsInDir(dir string, searchPath []string) []string {
+	for _, sp := range searchPath {
+		entries, err := os.ReadDir(filepath.Join(sp, filepath.FromSlash(dir)))
+		if err != nil {
+			continue
+		}
+		var names []string
+		for _, e := range entries {
+			if !e.IsDir() && isChangelogFile(e.Name()) {
+				names = append(names, path.Join(dir, e.Name()))
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
+
+// Resolve an included path to the name Liquibase records it by
+func includePath(parent, file string, relative bool) string {
+	if relative {
+		return path.Join(path.Dir(filepath.ToSlash(parent)), filepath.ToSlash(file))
+	}
+	return path.Clean(filepath.ToSlash(file))
+}
+
+// Check if a file name has a changelog extension
+func isChangelogFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range CHANGELOG_EXTENSIONS {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Build a table mapping byte offsets to line numbers
+func lineOffsets(content []byte) []int {
+	offsets := []int{0}
+	for i, b := range content {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// Return the 1-based line number of a byte offset
+func lineAt(offsets []int, offset int) int {
+	return sort.Search(len(offsets), func(i int) bool { return offsets[i] > offset })
+}
+
+// Parse an XML changelog, keeping the position and source of every changeset
+func parseXMLChangelog(file *ChangelogFile, content []byte) error {
+	offsets := lineOffsets(content)
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	var current *ChangeSet
+	var currentStart, depth int
+	var inValidCheckSum, inComment bool
+
+	for {
+		start := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if current != nil {
+				depth++
+				// Direct children of a changeSet are its changes, apart from these
+				if depth == 1 {
+					switch t.Name.Local {
+					case "rollback":
+						current.Rollback = true
+					case "validCheckSum", "comment", "preConditions", "tagDatabase":
+					default:
+						current.Changes = append(current.Changes, t.Name.Local)
+					}
+				}
+			}
+			switch t.Name.Local {
+			case "databaseChangeLog":
+				file.LogicalFilePath = attrs["logicalFilePath"]
+			case "changeSet":
+				current = &ChangeSet{
+					ID:      attrs["id"],
+					Author:  attrs["author"],
+					File:    firstNonEmpty(attrs["logicalFilePath"], file.LogicalFilePath, file.Path),
+					Pinned:  attrs["logicalFilePath"] != "" || file.LogicalFilePath != "",
+					Line:    lineAt(offsets, start),
+					Context: firstNonEmpty(attrs["contextFilter"], attrs["context"]),
+					Labels:  attrs["labels"],
+				}
+				currentStart = start
+				depth = 0
+			case "validCheckSum":
+				inValidCheckSum = current != nil
+			case "comment":
+				inComment = current != nil && depth == 1
+			case "include":
+				relative := attrs["relativeToChangelogFile"] == "true"
+				file.Includes = append(file.Includes, Include{File: includePath(file.Path, attrs["file"], relative), Line: lineAt(offsets, start), Relative: relative})
+			case "includeAll":
+				relative := attrs["relativeToChangelogFile"] == "true"
+				file.Includes = append(file.Includes, Include{File: includePath(file.Path, attrs["path"], relative), Line: lineAt(offsets, start), All: true, Relative: relative})
+			}
+		case xml.CharData:
+			if inValidCheckSum {
+				if sum := strings.TrimSpace(string(t)); sum != "" {
+					current.ValidCheckSums = append(current.ValidCheckSums, sum)
+				}
+			}
+			if inComment {
+				current.Comment += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			if current != nil && t.Name.Local != "changeSet" {
+				depth--
+			}
+			switch t.Name.Local {
+			case "validCheckSum":
+				inValidCheckSum = false
+			case "comment":
+				inComment = false
+			case "changeSet":
+				if current != nil {
+					end := int(decoder.InputOffset())
+					current.Body = string(content[currentStart:end])
+					current.EndLine = lineAt(offsets, end-1)
+					file.ChangeSets = append(file.ChangeSets, current)
+					current = nil
+				}
+			}
+		}
+	}
+}
+
+// Parse a YAML or JSON changelog
+func parseYAMLChangelog(file *ChangelogFile, content []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	entries := mappingValue(doc.Content[0], "databaseChangeLog")
+	if entries == nil {
+		return fmt.Errorf("no databaseChangeLog found")
+	}
+	lines := strings.Split(string(content), "\n")
+
+	for i, entry := range entries.Content {
+		if entry.Kind != yaml.MappingNode {
+			continue
+		}
+		if logical := scalarValue(entry, "logicalFilePath"); logical != "" {
+			file.LogicalFilePath = logical
+		}
+		if node := mappingValue(entry, "changeSet"); node != nil {
+			cs := &ChangeSet{
+				ID:      scalarValue(node, "id"),
+				Author:  scalarValue(node, "author"),
+				File:    firstNonEmpty(scalarValue(node, "logicalFilePath"), file.LogicalFilePath, file.Path),
+				Pinned:  scalarValue(node, "logicalFilePath") != "" || file.LogicalFilePath != "",
+				Line:    entry.Line,
+				Context: firstNonEmpty(scalarValue(node, "contextFilter"), scalarValue(node, "context")),
+				Labels:  scalarValue(node, "labels"),
+				Comment: scalarValue(node, "comment"),
+			}
+			if sums := mappingValue(node, "validCheckSum"); sums != nil {
+				if sums.Kind == yaml.ScalarNode {
+					cs.ValidCheckSums = []string{sums.Value}
+				}
+				for _, s := range sums.Content {
+					cs.ValidCheckSums = append(cs.ValidCheckSums, s.Value)
+				}
+			}
+			if changes := mappingValue(node, "changes"); changes != nil {
+				for _, change := range changes.Content {
+					if change.Kind == yaml.MappingNode && len(change.Content) > 0 {
+						cs.Changes = append(cs.Changes, change.Content[0].Value)
+					}
+				}
+			}
+			cs.Rollback = mappingValue(node, "rollback") != nil
+			// The definition runs up to the next entry
+			cs.EndLine = len(lines)
+			if i+1 < len(entries.Content) {
+				cs.EndLine = entries.Content[i+1].Line - 1
+			}
+			cs.Body = strings.Join(lines[cs.Line-1:cs.EndLine], "\n")
+			file.ChangeSets = append(file.ChangeSets, cs)
+		}
+		if node := mappingValue(entry, "include"); node != nil {
+			relative := scalarValue(node, "relativeToChangelogFile") == "true"
+			file.Includes = append(file.Includes, Include{File: includePath(file.Path, scalarValue(node, "file"), relative), Line: entry.Line, Relative: relative})
+		}
+		if node := mappingValue(entry, "includeAll"); node != nil {
+			relative := scalarValue(node, "relativeToChangelogFile") == "true"
+			file.Includes = append(file.Includes, Include{File: includePath(file.Path, scalarValue(node, "path"), relative), Line: entry.Line, All: true, Relative: relative})
+		}
+	}
+	return nil
+}
+
+// Return the value of a key in a YAML mapping
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Return the scalar value of a key in a YAML mapping
+func scalarValue(node *yaml.Node, key string) string {
+	if v := mappingValue(node, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+// Parse a formatted SQL changelog
+func parseSQLChangelog(file *ChangelogFile, content []byte) error {
+	var current *ChangeSet
+	var body []string
+	finish := func(lastLine int) {
+		if current != nil {
+			current.Body = strings.TrimRight(strings.Join(body, "\n"), "\n")
+			current.EndLine = lastLine
+			file.ChangeSets = append(file.ChangeSets, current)
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if m := sqlChangesetPattern.FindStringSubmatch(trimmed); m != nil {
+			finish(line - 1)
+			current = &ChangeSet{Author: firstNonEmpty(m[1], m[2]), ID: m[3], File: file.Path, Line: line}
+			for _, attr := range sqlAttributePattern.FindAllStringSubmatch(m[4], -1) {
+				value := strings.Trim(attr[2], `"`)
+				switch attr[1] {
+				case "context", "contextFilter":
+					current.Context = value
+				case "labels":
+					current.Labels = value
+				case "logicalFilePath":
+					current.File, current.Pinned = value, true
+				}
+			}
+			body = nil
+		}
+		if m := sqlIncludePattern.FindStringSubmatch(trimmed); m != nil {
+			file.Includes = append(file.Includes, Include{File: includePath(file.Path, m[1], false), Line: line})
+		}
+		if current != nil {
+			if m := sqlValidCheckSumPattern.FindStringSubmatch(trimmed); m != nil {
+				current.ValidCheckSums = append(current.ValidCheckSums, strings.TrimSpace(m[1]))
+			}
+			if m := sqlCommentPattern.FindStringSubmatch(trimmed); m != nil {
+				current.Comment = strings.TrimSpace(m[1])
+			}
+			if sqlRollbackPattern.MatchString(trimmed) {
+				current.Rollback = true
+			} else if len(current.Changes) == 0 && trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				current.Changes = []string{"sql"}
+			}
+			body = append(body, text)
+		}
+	}
+	finish(line)
+	return scanner.Err()
+}
+
+// Return the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,441 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	TICKET_JIRA       = "jira"
+	TICKET_SERVICENOW = "servicenow"
+	// CHANGE_TICKET_ENV holds the change ticket of a run, like --ticket
+	CHANGE_TICKET_ENV       = "GOLIQUIFY_CHANGE_TICKET"
+	DEFAULT_JIRA_ISSUE_TYPE = "Change"
+	SERVICENOW_CHANGE_TABLE = "change_request"
+)
+
+// Commands with an SQL preview attached to their ticket as the plan
+var planCommands = map[string]string{
+	"update":           "update-sql",
+	"update-count":     "update-count-sql",
+	"update-to-tag":    "update-to-tag-sql",
+	"rollback":         "rollback-sql",
+	"rollback-count":   "rollback-count-sql",
+	"rollback-to-date": "rollback-to-date-sql",
+	"rollbackToDate":   "rollbackToDateSQL",
+}
+
+// ChangeTicketConfig records the runs of an environment in change tickets:
+// planned with the SQL before the run, resolved with the outcome after it
+type ChangeTicketConfig struct {
+	// Provider is jira or servicenow
+	Provider string `yaml:"provider"`
+	// URL of the Jira site or ServiceNow instance
+	URL string `yaml:"url"`
+	// UserEnv and TokenEnv are the environment variables holding the user
+	// and its API token or password. Jira uses the token as a personal
+	// access token when there's no user.
+	UserEnv  string `yaml:"userEnv"`
+	TokenEnv string `yaml:"tokenEnv"`
+	// Project and IssueType of the Jira issues created
+	Project   string `yaml:"project"`
+	IssueType string `yaml:"issueType"`
+	// Create opens a ticket for runs started without one
+	Create bool `yaml:"create"`
+	// Required refuses runs without a ticket
+	Required bool `yaml:"required"`
+	// States the ticket of a run must be in, e.g. Implement, any when empty
+	States []string `yaml:"states"`
+	// Transitions move the ticket once the run completed or failed: the
+	// Jira transition name, or the ServiceNow state
+	Transitions map[string]string `yaml:"transitions"`
+	// Commands recorded, the commands guarded by maintenance windows by default
+	Commands []string `yaml:"commands"`
+}
+
+// Validate checks the provider and the settings it needs
+func (c *ChangeTicketConfig) Validate() error {
+	switch c.Provider {
+	case TICKET_JIRA:
+		if c.Create && c.Project == "" {
+			return fmt.Errorf("jira change tickets need a project to create issues in")
+		}
+	case TICKET_SERVICENOW:
+		if c.UserEnv == "" {
+			return fmt.Errorf("servicenow change tickets need userEnv")
+		}
+	default:
+		return fmt.Errorf("unknown change ticket provider %q, expecting jira or servicenow", c.Provider)
+	}
+	if c.URL == "" || c.TokenEnv == "" {
+		return fmt.Errorf("%s change tickets need a url and tokenEnv", c.Provider)
+	}
+	for outcome := range c.Transitions {
+		if outcome != EVENT_COMPLETED && outcome != EVENT_FAILED {
+			return fmt.Errorf("change ticket transition for %q, expecting completed or failed", outcome)
+		}
+	}
+	return nil
+}
+
+// Check if a command is recorded in change tickets
+func (c *ChangeTicketConfig) records(command string) bool {
+	return (&WindowPolicy{Commands: c.Commands}).Guards(command)
+}
+
+// ChangeTicket is a change ticket and its state
+type ChangeTicket struct {
+	Key   string
+	State string
+}
+
+// ChangeTicketProvider manages change tickets in a ticketing system
+type ChangeTicketProvider interface {
+	Create(ctx context.Context, summary, description string) (*ChangeTicket, error)
+	Get(ctx context.Context, key string) (*ChangeTicket, error)
+	Comment(ctx context.Context, key, text string) error
+	Attach(ctx context.Context, key, name string, data []byte) error
+	// Resolve records the outcome of the run, moving the ticket to the
+	// state of the outcome when set
+	Resolve(ctx context.Context, key string, succeeded bool, notes, state string) error
+}
+
+// ChangeTicketProvider returns the provider of a change ticket config
+func (c *ChangeTicketConfig) ChangeTicketProvider() (ChangeTicketProvider, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	token := os.Getenv(c.TokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("%s change tickets need a token in %s", c.Provider, c.TokenEnv)
+	}
+	authorization := "Bearer " + token
+	if c.UserEnv != "" {
+		user := os.Getenv(c.UserEnv)
+		if user == "" {
+			return nil, fmt.Errorf("%s change tickets need a user in %s", c.Provider, c.UserEnv)
+		}
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+token))
+	}
+	base := strings.TrimSuffix(c.URL, "/")
+	if c.Provider == TICKET_JIRA {
+		return &JiraTicketProvider{BaseURL: base, Authorization: authorization, Project: c.Project, IssueType: firstNonEmpty(c.IssueType, DEFAULT_JIRA_ISSUE_TYPE)}, nil
+	}
+	return &ServiceNowTicketProvider{BaseURL: base, Authorization: authorization}, nil
+}
+
+// The change ticket of a run in progress
+type changeTicketRun struct {
+	provider ChangeTicketProvider
+	key      string
+}
+
+// Key of the ticket, empty without one
+func (t *changeTicketRun) Key() string {
+	if t == nil {
+		return ""
+	}
+	return t.key
+}
+
+// Open the change ticket of a run, or check the one given, and attach the
+// plan. A run requiring a ticket doesn't start without one.
+func (pl *GoLiquibase) openChangeTicket(ctx context.Context, runID, command string, arguments []string) (*changeTicketRun, error) {
+	c := pl.ChangeTickets
+	key := pl.ChangeTicket
+	if key == "" && !c.Create {
+		if c.Required {
+			return nil, fmt.Errorf("%s needs a change ticket on environment %s, pass --ticket or set %s", command, pl.Environment, CHANGE_TICKET_ENV)
+		}
+		return nil, nil
+	}
+	// Without a required ticket, ticketing problems are logged and the run goes on
+	fail := func(err error) (*changeTicketRun, error) {
+		if c.Required {
+			return nil, fmt.Errorf("change ticket: %v, not running %s", err, command)
+		}
+		pl.logger().Printf("Change ticket: %v", err)
+		return nil, nil
+	}
+	provider, err := c.ChangeTicketProvider()
+	if err != nil {
+		return fail(err)
+	}
+
+	var plan string
+	if sqlCommand, ok := planCommands[command]; ok {
+		planArgs := append([]string{}, arguments...)
+		for i, arg := range planArgs {
+			if arg == command {
+				planArgs[i] = sqlCommand
+				break
+			}
+		}
+		if plan, err = pl.Output(planArgs...); err != nil {
+			return fail(fmt.Errorf("failed to plan %s: %v", command, err))
+		}
+	}
+
+	operator := currentOperator(pl.CI)
+	environment := firstNonEmpty(pl.Environment, pl.DefaultsFile)
+	var description strings.Builder
+	fmt.Fprintf(&description, "Planned: goliquify %s on %s\n", strings.Join(arguments, " "), environment)
+	fmt.Fprintf(&description, "Run: %s\nOperator: %s on %s\n", runID, operator.User, operator.Host)
+	if pl.CI != nil {
+		fmt.Fprintf(&description, "CI: %s %s, commit %s\n", pl.CI.Provider, pl.CI.PipelineURL, pl.CI.GitSHA)
+	}
+	if plan != "" {
+		fmt.Fprintf(&description, "Plan: plan-%s.sql, digest %s\n", runID, PlanDigest(plan))
+	}
+
+	if key == "" {
+		ticket, err := provider.Create(ctx, fmt.Sprintf("Database change: %s on %s", command, environment), description.String())
+		if err != nil {
+			return fail(err)
+		}
+		key = ticket.Key
+		pl.logger().Printf("Opened change ticket %s", key)
+	} else {
+		ticket, err := provider.Get(ctx, key)
+		if err != nil {
+			return fail(err)
+		}
+		if len(c.States) > 0 && !containsFold(c.States, ticket.State) {
+			return nil, fmt.Errorf("change ticket %s is %s, %s runs on %s need it in %s", key, ticket.State, command, environment, strings.Join(c.States, " or "))
+		}
+		if err := provider.Comment(ctx, key, description.String()); err != nil {
+			pl.logger().Printf("Failed to comment on change ticket %s: %v", key, err)
+		}
+	}
+	if plan != "" {
+		if err := provider.Attach(ctx, key, "plan-"+runID+".sql", []byte(plan)); err != nil {
+			pl.logger().Printf("Failed to attach the plan to change ticket %s: %v", key, err)
+		}
+	}
+	return &changeTicketRun{provider: provider, key: key}, nil
+}
+
+// Record the outcome of a run in its change ticket. Failures are logged.
+func (pl *GoLiquibase) resolveChangeTicket(ctx context.Context, ticket *changeTicketRun, runID string, finished Event) {
+	notes := fmt.Sprintf("goliquify %s %s, run %s, in %s", finished.Command, finished.Type, runID, (time.Duration(finished.ElapsedMs) * time.Millisecond).Round(time.Second))
+	if finished.Error != "" {
+		notes += fmt.Sprintf("\nError: %s\nLast changeset: %s", finished.Error, finished.Changeset)
+	}
+	if finished.LogFile != "" {
+		notes += "\nLog: " + finished.LogFile
+	}
+	succeeded := finished.Type == EVENT_COMPLETED
+	if err := ticket.provider.Resolve(ctx, ticket.key, succeeded, notes, pl.ChangeTickets.Transitions[finished.Type]); err != nil {
+		pl.logger().Printf("Failed to record the outcome in change ticket %s: %v", ticket.key, err)
+	}
+}
+
+// JiraTicketProvider manages change tickets as Jira issues, with the REST API v2
+type JiraTicketProvider struct {
+	BaseURL       string
+	Authorization string
+	Project       string
+	IssueType     string
+}
+
+func (j *JiraTicketProvider) issueURL(key, path string) string {
+	return j.BaseURL + "/rest/api/2/issue/" + url.PathEscape(key) + path
+}
+
+// Create creates an issue in the project
+func (j *JiraTicketProvider) Create(ctx context.Context, summary, description string) (*ChangeTicket, error) {
+	body := map[string]any{"fields": map[string]any{
+		"project":     map[string]string{"key": j.Project},
+		"issuetype":   map[string]string{"name": j.IssueType},
+		"summary":     summary,
+		"description": description,
+	}}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := apiRequest(ctx, "Jira", http.MethodPost, j.BaseURL+"/rest/api/2/issue", j.Authorization, body, &created); err != nil {
+		return nil, err
+	}
+	return &ChangeTicket{Key: created.Key}, nil
+}
+
+// Get returns an issue and its status
+func (j *JiraTicketProvider) Get(ctx context.Context, key string) (*ChangeTicket, error) {
+	var issue struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Status struct {
+				Name string `json:"name"`
+			} `json:"status"`
+		} `json:"fields"`
+	}
+	if err := apiRequest(ctx, "Jira", http.MethodGet, j.issueURL(key, "?fields=status"), j.Authorization, nil, &issue); err != nil {
+		return nil, err
+	}
+	return &ChangeTicket{Key: issue.Key, State: issue.Fields.Status.Name}, nil
+}
+
+// Comment adds a comment to an issue
+func (j *JiraTicketProvider) Comment(ctx context.Context, key, text string) error {
+	return apiRequest(ctx, "Jira", http.MethodPost, j.issueURL(key, "/comment"), j.Authorization, map[string]string{"body": text}, nil)
+}
+
+// Attach uploads a file to an issue
+func (j *JiraTicketProvider) Attach(ctx context.Context, key, name string, data []byte) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	form.Close()
+	headers := map[string]string{"Content-Type": form.FormDataContentType(), "X-Atlassian-Token": "no-check"}
+	return uploadRequest(ctx, "Jira", j.issueURL(key, "/attachments"), j.Authorization, headers, &body)
+}
+
+// Resolve comments the outcome and applies the transition of the given name
+func (j *JiraTicketProvider) Resolve(ctx context.Context, key string, succeeded bool, notes, state string) error {
+	if err := j.Comment(ctx, key, notes); err != nil {
+		return err
+	}
+	if state == "" {
+		return nil
+	}
+	var transitions struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if err := apiRequest(ctx, "Jira", http.MethodGet, j.issueURL(key, "/transitions"), j.Authorization, nil, &transitions); err != nil {
+		return err
+	}
+	for _, t := range transitions.Transitions {
+		if strings.EqualFold(t.Name, state) {
+			return apiRequest(ctx, "Jira", http.MethodPost, j.issueURL(key, "/transitions"), j.Authorization, map[string]any{"transition": map[string]string{"id": t.ID}}, nil)
+		}
+	}
+	return fmt.Errorf("issue %s has no transition %q", key, state)
+}
+
+// ServiceNowTicketProvider manages change requests of a ServiceNow instance
+// with the Table API. Tickets are keyed by their number, e.g. CHG0030001.
+type ServiceNowTicketProvider struct {
+	BaseURL       string
+	Authorization string
+}
+
+type serviceNowChange struct {
+	SysID  string `json:"sys_id"`
+	Number string `json:"number"`
+	State  string `json:"state"`
+}
+
+// Look up a change request by number
+func (s *ServiceNowTicketProvider) find(ctx context.Context, number string) (*serviceNowChange, error) {
+	query := url.Values{
+		"sysparm_query":         {"number=" + number},
+		"sysparm_fields":        {"sys_id,number,state"},
+		"sysparm_display_value": {"true"},
+		"sysparm_limit":         {"1"},
+	}
+	var found struct {
+		Result []serviceNowChange `json:"result"`
+	}
+	if err := apiRequest(ctx, "ServiceNow", http.MethodGet, s.BaseURL+"/api/now/table/"+SERVICENOW_CHANGE_TABLE+"?"+query.Encode(), s.Authorization, nil, &found); err != nil {
+		return nil, err
+	}
+	if len(found.Result) == 0 {
+		return nil, fmt.Errorf("no change request %s", number)
+	}
+	return &found.Result[0], nil
+}
+
+func (s *ServiceNowTicketProvider) update(ctx context.Context, number string, fields map[string]string) error {
+	change, err := s.find(ctx, number)
+	if err != nil {
+		return err
+	}
+	return apiRequest(ctx, "ServiceNow", http.MethodPatch, s.BaseURL+"/api/now/table/"+SERVICENOW_CHANGE_TABLE+"/"+change.SysID, s.Authorization, fields, nil)
+}
+
+// Create creates a normal change request
+func (s *ServiceNowTicketProvider) Create(ctx context.Context, summary, description string) (*ChangeTicket, error) {
+	body := map[string]string{"type": "normal", "short_description": summary, "description": description}
+	var created struct {
+		Result serviceNowChange `json:"result"`
+	}
+	if err := apiRequest(ctx, "ServiceNow", http.MethodPost, s.BaseURL+"/api/now/table/"+SERVICENOW_CHANGE_TABLE, s.Authorization, body, &created); err != nil {
+		return nil, err
+	}
+	return &ChangeTicket{Key: created.Result.Number, State: created.Result.State}, nil
+}
+
+// Get returns a change request and the display value of its state
+func (s *ServiceNowTicketProvider) Get(ctx context.Context, key string) (*ChangeTicket, error) {
+	change, err := s.find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &ChangeTicket{Key: change.Number, State: change.State}, nil
+}
+
+// Comment adds work notes to a change request
+func (s *ServiceNowTicketProvider) Comment(ctx context.Context, key, text string) error {
+	return s.update(ctx, key, map[string]string{"work_notes": text})
+}
+
+// Attach uploads a file to a change request
+func (s *ServiceNowTicketProvider) Attach(ctx context.Context, key, name string, data []byte) error {
+	change, err := s.find(ctx, key)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"table_name": {SERVICENOW_CHANGE_TABLE}, "table_sys_id": {change.SysID}, "file_name": {name}}
+	return uploadRequest(ctx, "ServiceNow", s.BaseURL+"/api/now/attachment/file?"+query.Encode(), s.Authorization, map[string]string{"Content-Type": "text/plain"}, bytes.NewReader(data))
+}
+
+// Resolve sets the close code and notes, and the state when given
+func (s *ServiceNowTicketProvider) Resolve(ctx context.Context, key string, succeeded bool, notes, state string) error {
+	fields := map[string]string{"close_code": "successful", "close_notes": notes, "work_notes": notes}
+	if !succeeded {
+		fields["close_code"] = "unsuccessful"
+	}
+	if state != "" {
+		fields["state"] = state
+	}
+	return s.update(ctx, key, fields)
+}
+
+// Send a request with a raw body, e.g. a file upload
+func uploadRequest(ctx context.Context, api, url, authorization string, headers map[string]string, body io.Reader) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	response, err := (&http.Client{Timeout: 60 * time.Second}).Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call the %s API: %v", api, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%s API POST %s: %s %s", api, url, response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CHECKSUM_FIX_VALID = "valid-checksum"
+	CHECKSUM_FIX_CLEAR = "clear"
+)
+
+// ChecksumMismatch is a changeset that changed after it was deployed
+type ChecksumMismatch struct {
+	// Key is the file::id::author coordinates reported by Liquibase
+	Key      string
+	Deployed string
+	Current  string
+	// ChangeSet is the current definition, nil if it can't be found on disk
+	ChangeSet *ChangeSet
+	// DiskPath is the file defining the changeset
+	DiskPath string
+	// DeployedBody is the definition before the change, recovered from git
+	// history, and Commit the commit it was read from. Empty if unknown.
+	DeployedBody string
+	Commit       string
+}
+
+// ChecksumMismatches runs validate and returns the changesets whose checksum
+// no longer matches the deployed one, with their deployed definition where
+// it can be recovered from git
+func (pl *GoLiquibase) ChecksumMismatches(arguments ...string) ([]*ChecksumMismatch, error) {
+	diagnostics, err := pl.ValidateDiagnostics(arguments...)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree *ChangelogTree
+	if changelogFile, searchPath := pl.ChangelogLocation(arguments...); changelogFile != "" {
+		tree, _ = LoadChangelogTree(changelogFile, searchPath)
+	}
+
+	var mismatches []*ChecksumMismatch
+	for _, d := range diagnostics {
+		if d.Kind != DIAGNOSTIC_CHECKSUM {
+			continue
+		}
+		m := &ChecksumMismatch{Key: d.ChangeSet, Deployed: d.Deployed, Current: d.Current}
+		if tree != nil {
+			if m.ChangeSet = tree.Find(d.ChangeSet); m.ChangeSet != nil {
+				if f := tree.FindFile(m.ChangeSet.File); f != nil {
+					m.DiskPath = f.DiskPath
+				}
+				m.DeployedBody, m.Commit = recoverDeployedDefinition(m.DiskPath, m.ChangeSet)
+			}
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches, nil
+}
+
+// Walk back through the git history of a changelog to the last version of the
+// changeset that differs from the current one, which is most likely what was
+// deployed. Returns empty strings when git has no such version.
+func recoverDeployedDefinition(diskPath string, cs *ChangeSet) (string, string) {
+	if diskPath == "" || cs == nil {
+		return "", ""
+	}
+	dir, name := filepath.Split(diskPath)
+	if dir == "" {
+		dir = "."
+	}
+	ctx := context.Background()
+	commits, err := runGit(ctx, dir, "log", "--format=%H", "--", name)
+	if err != nil {
+		return "", ""
+	}
+	prefix, err := runGit(ctx, dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", ""
+	}
+
+	for _, commit := range strings.Fields(commits) {
+		content, err := runGit(ctx, dir, "show", fmt.Sprintf("%s:%s%s", commit, prefix, name))
+		if err != nil {
+			continue
+		}
+		old, err := parseChangelogContent(name, cs.File, []byte(content))
+		if err != nil {
+			continue
+		}
+		for _, oldCS := range old.ChangeSets {
+			if oldCS.ID == cs.ID && oldCS.Author == cs.Author && strings.TrimSpace(oldCS.Body) != strings.TrimSpace(cs.Body) {
+				return oldCS.Body, commit
+			}
+		}
+	}
+	return "", ""
+}
+
+// AddValidCheckSum marks the deployed checksum as valid for the changeset by
+// adding a validCheckSum to its definition, so the change is accepted without
+// touching the database
+func AddValidCheckSum(diskPath string, cs *ChangeSet, checksum string) error {
+	content, err := os.ReadFile(diskPath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(content), "\n")
+	if cs.Line < 1 || cs.Line > len(lines) {
+		return fmt.Errorf("changeset %s is not on line %d of %s", cs.Key(), cs.Line, diskPath)
+	}
+	at := cs.Line - 1
+	indent := leadingWhitespace(lines[at])
+
+	var insert []string
+	switch strings.ToLower(filepath.Ext(diskPath)) {
+	case ".xml":
+		// Insert after the end of the opening changeSet tag, which may span lines
+		for at < len(lines) && !strings.Contains(lines[at], ">") {
+			at++
+		}
+		if at == len(lines) {
+			return fmt.Errorf("can't find the changeSet tag on line %d of %s", cs.Line, diskPath)
+		}
+		insert = []string{fmt.Sprintf("%s    <validCheckSum>%s</validCheckSum>", indent, checksum)}
+	case ".yaml", ".yml":
+		if len(cs.ValidCheckSums) > 0 {
+			return fmt.Errorf("%s already lists validCheckSum entries, add %s by hand", cs.Key(), checksum)
+		}
+		// Line is the "- changeSet:" entry, keys are indented below it
+		at++
+		if at >= len(lines) {
+			return fmt.Errorf("changeset %s in %s has no attributes", cs.Key(), diskPath)
+		}
+		keyIndent := leadingWhitespace(lines[at])
+		insert = []string{keyIndent + "validCheckSum:", keyIndent + "  - " + checksum}
+		at--
+	case ".sql":
+		insert = []string{"--validCheckSum: " + checksum}
+	default:
+		return fmt.Errorf("adding validCheckSum to %s files is not supported, add %s by hand", filepath.Ext(diskPath), checksum)
+	}
+
+	updated := append(append(append([]string{}, lines[:at+1]...), insert...), lines[at+1:]...)
+	return os.WriteFile(diskPath, []byte(strings.Join(updated, "\n")), 0644)
+}
+
+// ClearChecksum clears the stored checksum of one changeset, so it is
+// recomputed on the next update instead of clearing every checksum
+func (pl *GoLiquibase) ClearChecksum(key string) error {
+	file, id, author, ok := splitChangesetKey(key)
+	if !ok {
+		return fmt.Errorf("invalid changeset %q, expecting file::id::author", key)
+	}
+	sql := fmt.Sprintf("UPDATE %s SET MD5SUM = NULL WHERE FILENAME = %s AND ID = %s AND AUTHOR = %s",
+		pl.ChangelogTable(), sqlString(file), sqlString(id), sqlString(author))
+	pl.logger().Printf("Clearing the checksum of %s", key)
+	return pl.Execute("execute-sql", fmt.Sprintf("--sql=%s", sql))
+}
+
+// Quote a SQL string literal
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Return the leading spaces and tabs of a line
+func leadingWhitespace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// LineDiff returns a line by line diff of two texts, with - for removed and
+// + for added lines
+func LineDiff(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// Longest common subsequence table
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			fmt.Fprintf(&out, "  %s\n", x[i])
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "- %s\n", x[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", y[j])
+			j++
+		}
+	}
+	return out.String()
+}
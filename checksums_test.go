@@ -0,0 +1,19 @@
+package goliquify_test
+
+import (
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+func TestClearChecksumUpdatesConfiguredTable(t *testing.T) {
+	pl, runner := goliquifytest.New(t, goliquify.WithLiquibaseSchema("tracking", ""))
+	if err := pl.ClearChecksum("db/changelog.xml::1::bob"); err != nil {
+		t.Fatal(err)
+	}
+	sql, _ := runner.Invocations()[0].Arg("sql")
+	if want := "UPDATE tracking.DATABASECHANGELOG SET MD5SUM = NULL WHERE FILENAME = 'db/changelog.xml' AND ID = '1' AND AUTHOR = 'bob'"; sql != want {
+		t.Fatalf("ran %q, want %q", sql, want)
+	}
+}
@@ -0,0 +1,89 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+)
+
+// Changelog properties carrying the run and CI metadata
+const (
+	PROPERTY_RUN_ID       = "goliquify.runId"
+	PROPERTY_GIT_SHA      = "goliquify.gitSha"
+	PROPERTY_PIPELINE_URL = "goliquify.pipelineUrl"
+	PROPERTY_ACTOR        = "goliquify.actor"
+)
+
+// CIMetadata ties a run to the commit and pipeline that started it
+type CIMetadata struct {
+	Provider    string `json:"provider,omitempty"`
+	GitSHA      string `json:"gitSha,omitempty"`
+	PipelineURL string `json:"pipelineUrl,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+}
+
+// DetectCIMetadata reads CI metadata from the environment of GitHub Actions,
+// GitLab CI, Jenkins or CircleCI. GOLIQUIFY_GIT_SHA, GOLIQUIFY_PIPELINE_URL and
+// GOLIQUIFY_ACTOR override the detected values. Returns nil outside CI.
+func DetectCIMetadata(getenv func(string) string) *CIMetadata {
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	ci := &CIMetadata{}
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true":
+		ci.Provider = "github"
+		ci.GitSHA = getenv("GITHUB_SHA")
+		if getenv("GITHUB_RUN_ID") != "" {
+			ci.PipelineURL = fmt.Sprintf("%s/%s/actions/runs/%s", getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"))
+		}
+		ci.Actor = getenv("GITHUB_ACTOR")
+	case getenv("GITLAB_CI") == "true":
+		ci.Provider = "gitlab"
+		ci.GitSHA = getenv("CI_COMMIT_SHA")
+		ci.PipelineURL = getenv("CI_PIPELINE_URL")
+		ci.Actor = getenv("GITLAB_USER_LOGIN")
+	case getenv("JENKINS_URL") != "":
+		ci.Provider = "jenkins"
+		ci.GitSHA = getenv("GIT_COMMIT")
+		ci.PipelineURL = getenv("BUILD_URL")
+		ci.Actor = getenv("BUILD_USER_ID")
+	case getenv("CIRCLECI") == "true":
+		ci.Provider = "circleci"
+		ci.GitSHA = getenv("CIRCLE_SHA1")
+		ci.PipelineURL = getenv("CIRCLE_BUILD_URL")
+		ci.Actor = getenv("CIRCLE_USERNAME")
+	}
+
+	if v := getenv("GOLIQUIFY_GIT_SHA"); v != "" {
+		ci.GitSHA = v
+	}
+	if v := getenv("GOLIQUIFY_PIPELINE_URL"); v != "" {
+		ci.PipelineURL = v
+	}
+	if v := getenv("GOLIQUIFY_ACTOR"); v != "" {
+		ci.Actor = v
+	}
+	if *ci == (CIMetadata{}) {
+		return nil
+	}
+	return ci
+}
+
+// Changelog properties exposing the run ID and CI metadata to changesets,
+// e.g. to record them in an audit table with ${goliquify.gitSha}
+func runProperties(runID string, ci *CIMetadata) map[string]string {
+	props := map[string]string{PROPERTY_RUN_ID: runID}
+	if ci == nil {
+		return props
+	}
+	for key, val := range map[string]string{
+		PROPERTY_GIT_SHA:      ci.GitSHA,
+		PROPERTY_PIPELINE_URL: ci.PipelineURL,
+		PROPERTY_ACTOR:        ci.Actor,
+	} {
+		if val != "" {
+			props[key] = val
+		}
+	}
+	return props
+}
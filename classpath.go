@@ -0,0 +1,141 @@
+package goliquify
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Classpath builds the --classpath value: every jar under JdbcDriversDir, the
+// AdditionalClasspath entries and the extension jars of a managed install.
+// Returns an empty string when there is nothing to add.
+func (pl *GoLiquibase) Classpath() (string, error) {
+	liquibaseDir, err := pl.installedDir()
+	if err != nil {
+		return "", err
+	}
+	return pl.classpath(liquibaseDir)
+}
+
+func (pl *GoLiquibase) classpath(liquibaseDir string) (string, error) {
+	var entries []string
+
+	if pl.JdbcDriversDir != "" {
+		jars, err := findJars(pl.JdbcDriversDir, true)
+		if err != nil {
+			return "", fmt.Errorf("invalid JDBC drivers dir: %v", err)
+		}
+		entries = append(entries, jars...)
+	}
+
+	for _, entry := range splitClasspath(pl.AdditionalClasspath) {
+		expanded, err := expandClasspathEntry(entry)
+		if err != nil {
+			return "", fmt.Errorf("invalid classpath entry: %v", err)
+		}
+		entries = append(entries, expanded...)
+	}
+
+	// Extensions are downloaded into the lib dir of installs we manage
+	installMu.Lock()
+	managed := pl.managedInstall
+	installMu.Unlock()
+	if managed {
+		if jars, err := findJars(filepath.Join(liquibaseDir, "lib"), false); err == nil {
+			entries = append(entries, jars...)
+		}
+	}
+
+	return strings.Join(uniquePaths(entries), string(os.PathListSeparator)), nil
+}
+
+// Split a classpath on the OS path list separator. Commas are accepted too so
+// the same setting works on every OS.
+func splitClasspath(classpath string) []string {
+	var entries []string
+	for _, entry := range strings.FieldsFunc(classpath, func(r rune) bool {
+		return r == os.PathListSeparator || r == ','
+	}) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Expand a classpath entry: globs to the files they match, directories to the
+// jars in them (or the directory itself when it only holds classes), files as-is
+func expandClasspathEntry(entry string) ([]string, error) {
+	if strings.ContainsAny(entry, "*?[") {
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s matches no files", entry)
+		}
+		sort.Strings(matches)
+		return matches, nil
+	}
+
+	info, err := os.Stat(entry)
+	if err != nil {
+		return nil, fmt.Errorf("%s does not exist", entry)
+	}
+	if !info.IsDir() {
+		return []string{entry}, nil
+	}
+	jars, err := findJars(entry, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(jars) == 0 {
+		return []string{entry}, nil
+	}
+	return jars, nil
+}
+
+// Find the jar files in a directory, optionally in its sub-directories too
+func findJars(dir string, recursive bool) ([]string, error) {
+	if !dirExists(dir) {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	var jars []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".jar") {
+			jars = append(jars, path)
+		}
+		return nil
+	})
+	sort.Strings(jars)
+	return jars, err
+}
+
+// Remove duplicate paths, comparing them in their cleaned absolute form
+func uniquePaths(paths []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		key := filepath.Clean(p)
+		if abs, err := filepath.Abs(p); err == nil {
+			key = abs
+		}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
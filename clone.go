@@ -0,0 +1,463 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Clone providers
+const (
+	CLONE_DOCKER = "docker"
+	CLONE_RDS    = "rds"
+	CLONE_NEON   = "neon"
+)
+
+// Images dumps are restored into when none is set
+var DEFAULT_CLONE_IMAGES = map[string]string{
+	DIALECT_POSTGRES: "postgres:16",
+	DIALECT_MYSQL:    "mysql:8.0",
+}
+
+const (
+	NEON_API_URL         = "https://console.neon.tech/api/v2"
+	NEON_API_KEY_ENV     = "NEON_API_KEY"
+	DOCKER_READY_TIMEOUT = 2 * time.Minute
+)
+
+// Clone is a throwaway copy of the database
+type Clone struct {
+	// Name is the container, instance or branch name
+	Name string `json:"name"`
+	// ID identifies the clone to its provider
+	ID       string `json:"id,omitempty"`
+	URL      string `json:"url"`
+	Username string `json:"-"`
+	Password string `json:"-"`
+}
+
+// Properties of the defaults file naming the database and its credentials
+var (
+	URL_PROPERTIES      = []string{"url", "liquibase.command.url"}
+	USERNAME_PROPERTIES = []string{"username", "liquibase.command.username"}
+	PASSWORD_PROPERTIES = []string{"password", "liquibase.command.password"}
+)
+
+// CloneProvider provisions clones of the database and tears them down
+type CloneProvider interface {
+	Provision(ctx context.Context, name string) (*Clone, error)
+	Teardown(ctx context.Context, clone *Clone) error
+}
+
+// CloneConfig is how clones of an environment's database are provisioned
+type CloneConfig struct {
+	// Provider is docker, rds or neon
+	Provider string `yaml:"provider"`
+	// Image the dump is restored into with docker, postgres:16 or mysql:8.0 by default
+	Image string `yaml:"image"`
+	// Dump restored with docker, the latest pg_dump or mysqldump backup in the journal by default
+	Dump string `yaml:"dump"`
+	// Snapshot restored on RDS, the latest rds backup in the journal by default
+	Snapshot string `yaml:"snapshot"`
+	// Region of the RDS snapshot
+	Region string `yaml:"region"`
+	// InstanceClass of the RDS clone, the snapshot's by default
+	InstanceClass string `yaml:"instanceClass"`
+	// Project is the Neon project ID
+	Project string `yaml:"project"`
+	// Branch is the Neon branch the clone branches from, the project's default branch by default
+	Branch string `yaml:"branch"`
+}
+
+// LatestBackup returns the most recent backup of the given types taken in an environment
+func LatestBackup(journal, environment string, types ...string) (*BackupRecord, error) {
+	records, err := ReadJournal(journal)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		backup := records[i].Backup
+		if backup != nil && records[i].Environment == environment && containsString(types, backup.Type) {
+			return backup, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s backup of environment %q in the journal %s", strings.Join(types, " or "), environment, journal)
+}
+
+// CloneProvider returns the provider configured by c
+func (pl *GoLiquibase) CloneProvider(c *CloneConfig) (CloneProvider, error) {
+	switch c.Provider {
+	case CLONE_DOCKER:
+		return &dockerCloneProvider{pl: pl, config: c}, nil
+	case CLONE_RDS:
+		return &rdsCloneProvider{pl: pl, config: c}, nil
+	case CLONE_NEON:
+		return pl.neonProvider(c.Project, c.Branch)
+	}
+	return nil, fmt.Errorf("unknown clone provider %q, expecting docker, rds or neon", c.Provider)
+}
+
+// Run a tool, returning what it wrote to stdout. Its stderr goes to the log.
+func (pl *GoLiquibase) runTool(ctx context.Context, path string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := &Command{Path: path, Args: args, Env: os.Environ(), Stdout: &stdout, Stderr: pl.logger().Writer()}
+	if err := pl.runner().Run(ctx, cmd); err != nil {
+		return "", fmt.Errorf("%s %s failed: %v", path, firstNonEmpty(args...), err)
+	}
+	return stdout.String(), nil
+}
+
+// Generate a password for a throwaway database
+func randomPassword() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// dockerCloneProvider restores a dump into a database container
+type dockerCloneProvider struct {
+	pl     *GoLiquibase
+	config *CloneConfig
+}
+
+// Provision starts the container, waits for it and restores the dump
+func (p *dockerCloneProvider) Provision(ctx context.Context, name string) (*Clone, error) {
+	pl := p.pl
+	dialect, _, err := pl.DatabaseDSN()
+	if err != nil {
+		return nil, err
+	}
+	postgres := dialect == DIALECT_POSTGRES
+	scheme := "postgresql"
+	if !postgres {
+		scheme = "mysql"
+	}
+	target, err := pl.backupTarget(scheme)
+	if err != nil {
+		return nil, err
+	}
+	dump := p.config.Dump
+	if dump == "" {
+		backup, err := LatestBackup(pl.JournalFile, pl.Environment, BACKUP_PG_DUMP, BACKUP_MYSQLDUMP)
+		if err != nil {
+			return nil, fmt.Errorf("set the dump to clone from: %v", err)
+		}
+		dump = backup.Reference
+	}
+	if !fileExists(dump) {
+		return nil, fmt.Errorf("dump file %s not found", dump)
+	}
+
+	image := firstNonEmpty(p.config.Image, DEFAULT_CLONE_IMAGES[dialect])
+	password := randomPassword()
+	clone := &Clone{Name: name, Password: password}
+	args := []string{"run", "--detach", "--rm", "--name", name, "--publish-all"}
+	port := "5432/tcp"
+	if postgres {
+		clone.Username = "postgres"
+		args = append(args, "--env", "POSTGRES_PASSWORD="+password)
+	} else {
+		clone.Username = "root"
+		port = "3306/tcp"
+		args = append(args, "--env", "MYSQL_ROOT_PASSWORD="+password, "--env", "MYSQL_DATABASE="+target.database)
+	}
+	pl.logger().Printf("Starting clone container %s from %s", name, image)
+	out, err := pl.runTool(ctx, "docker", append(args, image)...)
+	if err != nil {
+		return nil, err
+	}
+	clone.ID = strings.TrimSpace(out)
+	if err := p.restore(ctx, clone, postgres, port, target.database, dump); err != nil {
+		p.Teardown(ctx, clone)
+		return nil, err
+	}
+	return clone, nil
+}
+
+// Wait for the container's database and restore the dump into it
+func (p *dockerCloneProvider) restore(ctx context.Context, clone *Clone, postgres bool, port, database, dump string) error {
+	pl := p.pl
+	out, err := pl.runTool(ctx, "docker", "port", clone.ID, port)
+	if err != nil {
+		return err
+	}
+	mapped := firstLine(out)
+	hostPort := mapped[strings.LastIndex(mapped, ":")+1:]
+
+	// The servers only listen on TCP once their initialization is done
+	ready := []string{"exec", clone.ID, "pg_isready", "--host=127.0.0.1", "--username=postgres"}
+	if !postgres {
+		ready = []string{"exec", "--env", "MYSQL_PWD=" + clone.Password, clone.ID, "mysqladmin", "ping", "--host=127.0.0.1", "--user=root", "--silent"}
+	}
+	pl.logger().Printf("Waiting for the database in %s", clone.Name)
+	deadline := time.Now().Add(DOCKER_READY_TIMEOUT)
+	for {
+		cmd := &Command{Path: "docker", Args: ready, Env: os.Environ(), Stdout: io.Discard, Stderr: io.Discard}
+		if err := pl.runner().Run(ctx, cmd); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the database in %s wasn't ready within %s", clone.Name, DOCKER_READY_TIMEOUT)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+
+	inContainer := "/tmp/goliquify-clone" + filepath.Ext(dump)
+	if _, err := pl.runTool(ctx, "docker", "cp", dump, clone.ID+":"+inContainer); err != nil {
+		return err
+	}
+	pl.logger().Printf("Restoring %s into %s", dump, clone.Name)
+	switch {
+	case !postgres:
+		_, err = pl.runTool(ctx, "docker", "exec", "--env", "MYSQL_PWD="+clone.Password, clone.ID, "mysql", "--user=root", "--execute=source "+inContainer, database)
+	case filepath.Ext(dump) == ".sql":
+		if database != "postgres" {
+			if _, err := pl.runTool(ctx, "docker", "exec", clone.ID, "createdb", "--username=postgres", database); err != nil {
+				return err
+			}
+		}
+		_, err = pl.runTool(ctx, "docker", "exec", clone.ID, "psql", "--username=postgres", "--set=ON_ERROR_STOP=1", "--quiet", "--file="+inContainer, database)
+	default:
+		// The custom format dump recreates its database, unless that is the one that exists
+		restore := []string{"exec", clone.ID, "pg_restore", "--username=postgres", "--no-owner", "--no-privileges", "--dbname=postgres"}
+		if database != "postgres" {
+			restore = append(restore, "--create")
+		}
+		_, err = pl.runTool(ctx, "docker", append(restore, inContainer)...)
+	}
+	if err != nil {
+		return err
+	}
+	scheme := "postgresql"
+	if !postgres {
+		scheme = "mysql"
+	}
+	clone.URL = fmt.Sprintf("jdbc:%s://localhost:%s/%s", scheme, hostPort, database)
+	return nil
+}
+
+// Teardown removes the container
+func (p *dockerCloneProvider) Teardown(ctx context.Context, clone *Clone) error {
+	_, err := p.pl.runTool(ctx, "docker", "rm", "--force", "--volumes", clone.ID)
+	return err
+}
+
+// rdsCloneProvider restores an RDS snapshot to a new instance
+type rdsCloneProvider struct {
+	pl     *GoLiquibase
+	config *CloneConfig
+}
+
+// Provision restores the snapshot and waits for the instance
+func (p *rdsCloneProvider) Provision(ctx context.Context, name string) (*Clone, error) {
+	pl := p.pl
+	snapshot, region := p.config.Snapshot, p.config.Region
+	if snapshot == "" {
+		backup, err := LatestBackup(pl.JournalFile, pl.Environment, BACKUP_RDS)
+		if err != nil {
+			return nil, fmt.Errorf("set the snapshot to clone from: %v", err)
+		}
+		snapshot, region = backup.Reference, firstNonEmpty(region, backup.Region)
+	}
+	var regionArgs []string
+	if region != "" {
+		regionArgs = []string{"--region", region}
+	}
+	args := []string{"rds", "restore-db-instance-from-db-snapshot", "--db-instance-identifier", name, "--db-snapshot-identifier", snapshot}
+	if p.config.InstanceClass != "" {
+		args = append(args, "--db-instance-class", p.config.InstanceClass)
+	}
+	pl.logger().Printf("Restoring RDS snapshot %s to instance %s", snapshot, name)
+	if _, err := pl.runTool(ctx, "aws", append(args, regionArgs...)...); err != nil {
+		return nil, err
+	}
+	clone := &Clone{Name: name, ID: region}
+	pl.logger().Printf("Waiting for RDS instance %s to become available", name)
+	if _, err := pl.runTool(ctx, "aws", append([]string{"rds", "wait", "db-instance-available", "--db-instance-identifier", name}, regionArgs...)...); err != nil {
+		p.Teardown(ctx, clone)
+		return nil, err
+	}
+	out, err := pl.runTool(ctx, "aws", append([]string{"rds", "describe-db-instances", "--db-instance-identifier", name, "--query", "DBInstances[0].Endpoint.Address", "--output", "text"}, regionArgs...)...)
+	if err == nil {
+		clone.URL, err = pl.restoredURL(strings.TrimSpace(out))
+	}
+	if err != nil {
+		p.Teardown(ctx, clone)
+		return nil, err
+	}
+	return clone, nil
+}
+
+// Teardown deletes the instance without a final snapshot
+func (p *rdsCloneProvider) Teardown(ctx context.Context, clone *Clone) error {
+	args := []string{"rds", "delete-db-instance", "--db-instance-identifier", clone.Name, "--skip-final-snapshot", "--delete-automated-backups"}
+	if clone.ID != "" {
+		args = append(args, "--region", clone.ID)
+	}
+	_, err := p.pl.runTool(ctx, "aws", args...)
+	return err
+}
+
+// NeonCloneProvider creates Neon branches, copy-on-write clones of a project's data
+type NeonCloneProvider struct {
+	Project string
+	// Parent is the branch ID branched from, the project's default branch when empty
+	Parent string
+	APIKey string
+	// BaseURL of the Neon API, NEON_API_URL by default
+	BaseURL string
+	// Database the clone connects to, the first of the branch when empty
+	Database string
+}
+
+// Send a request to the Neon API and decode the JSON response
+func (p *NeonCloneProvider) request(ctx context.Context, method, path string, body, result any) error {
+	return apiRequest(ctx, "Neon", method, firstNonEmpty(p.BaseURL, NEON_API_URL)+path, "Bearer "+p.APIKey, body, result)
+}
+
+// Send a request to the JSON API of a database provider and decode the response
+func apiRequest(ctx context.Context, api, method, url, authorization string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := (&http.Client{Timeout: 60 * time.Second}).Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call the %s API: %v", api, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%s API %s %s: %s %s", api, method, url, response.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse the %s API response: %v", api, err)
+	}
+	return nil
+}
+
+// Provision creates a branch with a read-write endpoint
+func (p *NeonCloneProvider) Provision(ctx context.Context, name string) (*Clone, error) {
+	branch := map[string]any{"name": name}
+	if p.Parent != "" {
+		branch["parent_id"] = p.Parent
+	}
+	body := map[string]any{
+		"branch":    branch,
+		"endpoints": []map[string]string{{"type": "read_write"}},
+	}
+	var created struct {
+		Branch struct {
+			ID string `json:"id"`
+		} `json:"branch"`
+		ConnectionURIs []struct {
+			ConnectionURI string `json:"connection_uri"`
+		} `json:"connection_uris"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/projects/"+url.PathEscape(p.Project)+"/branches", body, &created); err != nil {
+		return nil, err
+	}
+	clone := &Clone{Name: name, ID: created.Branch.ID}
+	for _, uri := range created.ConnectionURIs {
+		u, err := url.Parse(uri.ConnectionURI)
+		if err != nil {
+			continue
+		}
+		database := strings.TrimPrefix(u.Path, "/")
+		if p.Database != "" && database != p.Database {
+			continue
+		}
+		clone.URL = fmt.Sprintf("jdbc:postgresql://%s/%s", u.Host, database)
+		if u.RawQuery != "" {
+			clone.URL += "?" + u.RawQuery
+		}
+		clone.Username = u.User.Username()
+		clone.Password, _ = u.User.Password()
+		break
+	}
+	if clone.URL == "" {
+		p.Teardown(ctx, clone)
+		return nil, fmt.Errorf("Neon returned no connection uri for branch %s", name)
+	}
+	return clone, nil
+}
+
+// Teardown deletes the branch
+func (p *NeonCloneProvider) Teardown(ctx context.Context, clone *Clone) error {
+	return p.request(ctx, http.MethodDelete, "/projects/"+url.PathEscape(p.Project)+"/branches/"+url.PathEscape(clone.ID), nil, nil)
+}
+
+// Write a copy of the defaults file pointing at a clone, to dir. Credentials
+// go into the file rather than on the command line, where they'd be logged.
+func (pl *GoLiquibase) cloneDefaultsFile(clone *Clone, dir string) (string, error) {
+	data, err := os.ReadFile(pl.DefaultsFile)
+	if err != nil {
+		return "", err
+	}
+	replaced := append([]string{}, URL_PROPERTIES...)
+	overrides := []string{"url=" + clone.URL}
+	if clone.Username != "" {
+		replaced = append(append(replaced, USERNAME_PROPERTIES...), PASSWORD_PROPERTIES...)
+		overrides = append(overrides, "username="+clone.Username, "password="+clone.Password)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		key := strings.TrimSpace(line)
+		if i := strings.IndexAny(key, "=:"); i >= 0 {
+			key = strings.TrimSpace(key[:i])
+		}
+		if !containsString(replaced, key) {
+			lines = append(lines, line)
+		}
+	}
+	path := filepath.Join(dir, "liquibase.properties")
+	content := strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n" + strings.Join(overrides, "\n") + "\n"
+	return path, os.WriteFile(path, []byte(content), 0600)
+}
+
+// Copy the instance to run commands against a clone as they would run in the
+// environment, without its backups, windows, journal and attestations. The
+// clone's defaults file is written to dir.
+func (pl *GoLiquibase) onClone(clone *Clone, dir string) (*GoLiquibase, error) {
+	defaultsFile, err := pl.cloneDefaultsFile(clone, dir)
+	if err != nil {
+		return nil, err
+	}
+	c := pl.withDefaultsFile(defaultsFile)
+	c.Backup = nil
+	c.WindowPolicy = nil
+	c.JournalFile = ""
+	c.Attestations = nil
+	c.DryRun = false
+	return c, nil
+}
@@ -0,0 +1,212 @@
+package goliquify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DSQL_ADMIN_USER = "admin"
+	DSQL_DATABASE   = "postgres"
+	// DSQL tokens are only checked when connecting, a run outliving its
+	// token keeps its connections
+	DSQL_TOKEN_EXPIRY  = 15 * time.Minute
+	SPANNER_URL_PREFIX = "jdbc:cloudspanner:"
+)
+
+// Region of a cluster endpoint, e.g. abc123.dsql.us-east-1.on.aws
+var dsqlEndpointPattern = regexp.MustCompile(`^[^.]+\.dsql\.([a-z0-9-]+)\.on\.aws$`)
+
+// DSQLConfig connects to an Aurora DSQL cluster with an IAM authentication
+// token, signed with the AWS credentials of the environment for every run
+type DSQLConfig struct {
+	// Endpoint is the host name of the cluster
+	Endpoint string `yaml:"endpoint"`
+	// Region of the cluster, read from the endpoint when empty
+	Region string `yaml:"region"`
+	// User is the database role, DSQL_ADMIN_USER by default. Other roles
+	// sign their tokens for the DbConnect action instead of DbConnectAdmin.
+	User string `yaml:"user"`
+	// Profile is the AWS CLI profile the credentials are read from when
+	// they aren't in the environment
+	Profile string `yaml:"profile"`
+}
+
+// Validate checks the endpoint and region are known
+func (c *DSQLConfig) Validate() error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("dsql needs the endpoint of the cluster")
+	}
+	if c.region() == "" {
+		return fmt.Errorf("can't tell the region of dsql endpoint %s, set it as region", c.Endpoint)
+	}
+	return nil
+}
+
+func (c *DSQLConfig) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	if m := dsqlEndpointPattern.FindStringSubmatch(c.Endpoint); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func (c *DSQLConfig) user() string {
+	return firstNonEmpty(c.User, DSQL_ADMIN_USER)
+}
+
+// URL of the cluster, DSQL only accepts connections over TLS
+func (c *DSQLConfig) URL() string {
+	return fmt.Sprintf("jdbc:postgresql://%s:5432/%s?sslmode=require", c.Endpoint, DSQL_DATABASE)
+}
+
+// awsCredentials are the keys tokens are signed with
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+}
+
+// The credentials of the environment, or those the AWS CLI resolves for
+// the profile, covering SSO logins, assumed roles and instance profiles
+func (pl *GoLiquibase) awsCredentials(ctx context.Context, profile string) (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" && profile == "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	args := []string{"configure", "export-credentials", "--format", "process"}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+	out, err := pl.runTool(ctx, "aws", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aws credentials: %v", err)
+	}
+	creds := &awsCredentials{}
+	if err := json.Unmarshal([]byte(out), creds); err != nil || creds.AccessKeyID == "" {
+		return nil, fmt.Errorf("failed to read aws credentials from the aws cli output")
+	}
+	return creds, nil
+}
+
+// DSQLAuthToken generates an IAM authentication token for the cluster, used
+// as the password of the connection
+func (pl *GoLiquibase) DSQLAuthToken(ctx context.Context, c *DSQLConfig) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	creds, err := pl.awsCredentials(ctx, c.Profile)
+	if err != nil {
+		return "", err
+	}
+	action := "DbConnect"
+	if c.user() == DSQL_ADMIN_USER {
+		action = "DbConnectAdmin"
+	}
+	return presignDSQL(c.Endpoint, c.region(), action, creds, time.Now().UTC()), nil
+}
+
+// Presign a DSQL connect request with Signature Version 4, the token is the
+// presigned url without its scheme
+func presignDSQL(host, region, action string, creds *awsCredentials, now time.Time) string {
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/dsql/aws4_request"
+	query := map[string]string{
+		"Action":              action,
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    creds.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       fmt.Sprint(int(DSQL_TOKEN_EXPIRY.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if creds.SessionToken != "" {
+		query["X-Amz-Security-Token"] = creds.SessionToken
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]string, len(keys))
+	for i, key := range keys {
+		params[i] = awsEscape(key) + "=" + awsEscape(query[key])
+	}
+	canonicalQuery := strings.Join(params, "&")
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{"GET", "/", canonicalQuery, "host:" + host, "", "host", hex.EncodeToString(emptyHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", query["X-Amz-Date"], scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "dsql", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return host + "/?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Escape a query parameter the way SigV4 expects, spaces as %20
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// SpannerConfig connects to a Cloud Spanner database through the Liquibase
+// Spanner extension, installed with the spanner driver bundle. The driver
+// logs in with Application Default Credentials unless a key file is set.
+type SpannerConfig struct {
+	Project  string `yaml:"project"`
+	Instance string `yaml:"instance"`
+	Database string `yaml:"database"`
+	// Credentials is a service account key file
+	Credentials string `yaml:"credentials"`
+	// Emulator is the host:port of a Spanner emulator, which the driver
+	// creates the instance and database on
+	Emulator string `yaml:"emulator"`
+}
+
+// Validate checks the database is named and the key file exists
+func (c *SpannerConfig) Validate() error {
+	if c.Project == "" || c.Instance == "" || c.Database == "" {
+		return fmt.Errorf("spanner needs a project, instance and database")
+	}
+	if c.Credentials != "" && !fileExists(c.Credentials) {
+		return fmt.Errorf("spanner credentials %s not found", c.Credentials)
+	}
+	return nil
+}
+
+// URL of the database
+func (c *SpannerConfig) URL() string {
+	u := SPANNER_URL_PREFIX
+	if c.Emulator != "" {
+		u += "//" + c.Emulator
+	}
+	u += fmt.Sprintf("/projects/%s/instances/%s/databases/%s", c.Project, c.Instance, c.Database)
+	if c.Emulator != "" {
+		u += ";autoConfigEmulator=true"
+	}
+	if c.Credentials != "" {
+		credentials, _ := filepath.Abs(c.Credentials)
+		u += ";credentials=" + credentials
+	}
+	return u
+}
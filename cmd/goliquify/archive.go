@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newArchiveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Move changesets deployed before a tag into archive changelogs",
+		Long: `Move the changesets deployed up to the changeset carrying a tag out of
+the active changelogs, into changelogs of the same name under the archive
+directory, included from the top of the root changelog. The archives keep
+the path the changesets were deployed by as their logicalFilePath and the
+definitions are moved as they are, so the deployed checksums still match.
+Lists the moves unless --write is passed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tag, _ := cmd.Flags().GetString("before-tag")
+			dir, _ := cmd.Flags().GetString("dir")
+			write, _ := cmd.Flags().GetBool("write")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			if tag == "" {
+				return fmt.Errorf("--before-tag is required")
+			}
+
+			pl, tree, err := loadChangelogTreeFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if dsn == "" {
+				if dialect, dsn, err = pl.ReadOnlyDSN(); err != nil {
+					return fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+				}
+			}
+			db, err := openDatabase(dialect, dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			applied, err := goliquify.ReadDeploymentHistory(context.Background(), db, pl.LiquibaseSchemaName)
+			if err != nil {
+				return err
+			}
+			result, err := goliquify.ArchiveChangeSets(tree, applied, goliquify.ArchiveOptions{BeforeTag: tag, Dir: dir, Write: write})
+			if err != nil {
+				return err
+			}
+			printArchiveResult(result, write)
+			return nil
+		},
+	}
+	cmd.Flags().String("before-tag", "", "Archive the changesets deployed up to the one carrying this tag")
+	cmd.Flags().String("dir", goliquify.DEFAULT_ARCHIVE_DIR, "Archive directory, relative to the root changelog")
+	cmd.Flags().Bool("write", false, "Move the changesets instead of listing them")
+	cmd.Flags().String("dsn", "", "Data source name of the database (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	return cmd
+}
+
+func newUnarchiveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unarchive",
+		Short: "Move archived changesets back into the changelogs they came from",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _ := cmd.Flags().GetString("dir")
+			write, _ := cmd.Flags().GetBool("write")
+			_, tree, err := loadChangelogTreeFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			result, err := goliquify.UnarchiveChangeSets(tree, goliquify.ArchiveOptions{Dir: dir, Write: write})
+			if err != nil {
+				return err
+			}
+			printArchiveResult(result, write)
+			return nil
+		},
+	}
+	cmd.Flags().String("dir", goliquify.DEFAULT_ARCHIVE_DIR, "Archive directory, relative to the root changelog")
+	cmd.Flags().Bool("write", false, "Move the changesets instead of listing them")
+	return cmd
+}
+
+func printArchiveResult(result *goliquify.ArchiveResult, write bool) {
+	for _, m := range result.Moved {
+		fmt.Printf("%s: %s -> %s\n", m.ChangeSet, m.From, m.To)
+	}
+	for _, file := range result.Skipped {
+		fmt.Printf("%s: JSON changelogs can't be archived, left as they are\n", file)
+	}
+	switch {
+	case len(result.Moved) == 0:
+		fmt.Println("No changesets to move")
+	case !write:
+		fmt.Printf("\n%d changeset(s) to move, pass --write to move them\n", len(result.Moved))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newAttestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attest",
+		Short: "Manage the signed attestations of runs",
+	}
+	cmd.AddCommand(newAttestKeygenCmd())
+	cmd.AddCommand(newAttestVerifyCmd())
+	return cmd
+}
+
+func newAttestKeygenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an ed25519 key pair to sign attestations with",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			private, _ := cmd.Flags().GetString("private")
+			public, _ := cmd.Flags().GetString("public")
+			if err := goliquify.GenerateAttestationKey(private, public); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote the signing key to %s and the public key to %s\n", private, public)
+			return nil
+		},
+	}
+	cmd.Flags().String("private", "attestation.key", "File the private signing key is written to")
+	cmd.Flags().String("public", "attestation.pub", "File the public key is written to")
+	return cmd
+}
+
+func newAttestVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify <attestation>...",
+		Short: "Verify the signature of attestations and show what they record",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyFile, _ := cmd.Flags().GetString("key")
+			public, err := goliquify.ReadAttestationPublicKey(keyFile)
+			if err != nil {
+				return err
+			}
+			failed := 0
+			for _, path := range args {
+				envelope, err := goliquify.ReadEnvelope(path)
+				if err == nil {
+					var statement *goliquify.AttestationStatement
+					if statement, err = goliquify.VerifyEnvelope(envelope, public); err == nil {
+						p := statement.Predicate
+						fmt.Printf("%s: verified, %s by %s on %s at %s, %s, %d changeset(s) applied\n",
+							path, p.Command, p.Operator.User, p.Target, p.FinishedOn.Format("2006-01-02T15:04:05Z07:00"), p.Status, len(p.ChangeSetsApplied))
+						for _, s := range statement.Subject {
+							fmt.Printf("  %s sha256:%s\n", s.Name, s.Digest["sha256"])
+						}
+						if len(p.ChangeSetsApplied) > 0 {
+							fmt.Printf("  %s\n", strings.Join(p.ChangeSetsApplied, "\n  "))
+						}
+						continue
+					}
+				}
+				failed++
+				fmt.Printf("%s: %v\n", path, err)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d attestation(s) failed verification", failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("key", "attestation.pub", "Public key the attestations were signed with")
+	return cmd
+}
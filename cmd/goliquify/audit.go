@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Check the Liquibase, extension and driver jars against known vulnerabilities",
+		Long: `Look up every jar of the Liquibase toolchain in the OSV database, which
+includes the GitHub security advisories. Fails when an advisory reaches the
+--fail-on severity. Advisories without a severity only fail at low.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			failOn, _ := cmd.Flags().GetString("fail-on")
+			ignore, _ := cmd.Flags().GetStringSlice("ignore")
+			format, _ := cmd.Flags().GetString("format")
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("fail-on") && cfg.Audit.FailOn != "" {
+				failOn = cfg.Audit.FailOn
+			}
+			threshold, err := goliquify.ParseSeverity(failOn)
+			if err != nil {
+				return err
+			}
+			components, err := pl.Toolchain()
+			if err != nil {
+				return err
+			}
+			advisories, err := goliquify.AuditToolchain(context.Background(), components, append(cfg.Audit.Ignore, ignore...))
+			if err != nil {
+				return err
+			}
+
+			failing := 0
+			for _, a := range advisories {
+				if goliquify.SeverityAtLeast(a.Severity, threshold) {
+					failing++
+				}
+			}
+			if format == "json" {
+				if advisories == nil {
+					advisories = []goliquify.Advisory{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(advisories); err != nil {
+					return err
+				}
+			} else if len(advisories) == 0 {
+				fmt.Printf("No known vulnerabilities in %d components\n", len(components))
+			} else {
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "SEVERITY\tADVISORY\tPACKAGE\tVERSION\tKIND\tFIXED IN\tSUMMARY")
+				for _, a := range advisories {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Severity, a.ID, a.Package, a.Version, a.Kind, strings.Join(a.FixedIn, ", "), a.Summary)
+				}
+				tw.Flush()
+			}
+			if failing > 0 {
+				return fmt.Errorf("%d advisories at or above %s severity", failing, threshold)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("fail-on", goliquify.DEFAULT_AUDIT_FAIL_ON, "Lowest severity that fails the audit: low, moderate, high or critical")
+	cmd.Flags().StringSlice("ignore", nil, "Advisory IDs or CVEs to ignore, in addition to the configured ones")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
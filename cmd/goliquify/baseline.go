@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newBaselineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "baseline",
+		Short: "Adopt an existing database: snapshot it, generate a baseline changelog and mark it applied",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _ := cmd.Flags().GetString("dir")
+			tag, _ := cmd.Flags().GetString("tag")
+			configFile, _ := cmd.Flags().GetString("config")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if cfg.Baseline != nil {
+				return fmt.Errorf("%s already records a baseline created %s, remove it to create a new one", configFile, cfg.Baseline.CreatedAt.Format("2006-01-02"))
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+
+			baseline, err := pl.CreateBaseline(dir, tag)
+			if err != nil {
+				return err
+			}
+			if pl.DryRun {
+				fmt.Printf("Baseline changelog generated in %s, dry run so nothing was recorded\n", baseline.Changelog)
+				return nil
+			}
+			if err := goliquify.SaveBaseline(configFile, baseline); err != nil {
+				return err
+			}
+			fmt.Printf("Baseline %s applied and recorded in %s\n", baseline.Changelog, configFile)
+			return nil
+		},
+	}
+	cmd.Flags().String("dir", "db/baseline", "Directory the baseline changelog and snapshot are written to")
+	cmd.Flags().String("tag", "baseline", "Tag applied after the baseline, empty to skip")
+	return cmd
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newBranchDeployCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "branch-deploy [-- liquibase args]",
+		Short: "Migrate a branch of the database and verify it before updating the database itself",
+		Long: `Create a branch of the database on a branch-capable host, run update on
+the branch, run the verification queries on it, and only when they pass run
+update on the database itself. The branch is deleted afterwards. Configure
+the branching of the environment:
+
+  environments:
+    prod:
+      branching:
+        provider: neon          # or planetscale, or a plugin
+        project: round-sun-123456
+        verify:
+          - name: no orphaned orders
+            sql: SELECT count(*) FROM orders WHERE customer_id IS NULL
+            expect: "0"
+
+Neon needs NEON_API_KEY, PlanetScale PLANETSCALE_SERVICE_TOKEN_ID and
+PLANETSCALE_SERVICE_TOKEN. Other providers are plugins: executables named
+goliquify-branch-<provider> on the PATH. Verification queries run through
+goliquify's database drivers, see the drift command.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			provider, _ := cmd.Flags().GetString("provider")
+			keep, _ := cmd.Flags().GetBool("keep")
+			reportFile, _ := cmd.Flags().GetString("report")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			branching := &goliquify.BranchConfig{}
+			if pl.Environment != "" {
+				env, err := cfg.Environment(pl.Environment)
+				if err != nil {
+					return err
+				}
+				if env.Branching != nil {
+					branching = env.Branching
+				}
+			}
+			if provider != "" {
+				branching.Provider = provider
+			}
+			branches, err := pl.BranchProvider(branching)
+			if err != nil {
+				return err
+			}
+
+			report, err := pl.BranchDeploy(context.Background(), goliquify.BranchDeployOptions{
+				Branches: branches,
+				Verify:   branching.Verify,
+				OpenDB:   openDatabase,
+				Keep:     keep,
+				Args:     args,
+			})
+			if report == nil {
+				return err
+			}
+			for _, step := range report.Steps {
+				line := fmt.Sprintf("%-14s %-9s %8s", step.Name, step.Status, (time.Duration(step.DurationMs) * time.Millisecond).String())
+				if step.Error != "" {
+					line += "  " + step.Error
+				}
+				fmt.Println(line)
+			}
+			if reportFile != "" {
+				if writeErr := report.WriteJSON(reportFile); writeErr != nil {
+					log.Printf("Failed to write the branch deploy report: %v", writeErr)
+				}
+			}
+			return err
+		},
+	}
+	cmd.Flags().String("provider", "", "Branch provider: neon, planetscale or a plugin (default is the environment's)")
+	cmd.Flags().Bool("keep", false, "Keep the branch after the deploy")
+	cmd.Flags().String("report", "", "Write a JSON report of the steps to this file")
+	return cmd
+}
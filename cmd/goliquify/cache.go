@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the Liquibase versions and JREs in the cache dir",
+	}
+	cmd.AddCommand(newCacheListCmd())
+	cmd.AddCommand(newCacheGCCmd())
+	return cmd
+}
+
+func newCacheListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the cached toolchains, most recently used first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			entries, err := pl.CachedToolchains()
+			if err != nil {
+				return err
+			}
+			printCacheEntries(entries)
+			return nil
+		},
+	}
+}
+
+func newCacheGCCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove cached toolchains that haven't been used for a while",
+		Long: `Remove cached Liquibase versions and JREs. The --keep most recently used
+of each kind are always kept, the others are removed once unused for
+--max-age, or right away without it. The configured Liquibase version is
+never removed. Defaults come from the cache section of the config file.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keep, _ := cmd.Flags().GetInt("keep")
+			maxAge, _ := cmd.Flags().GetString("max-age")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			retention := goliquify.CacheRetention{}
+			if configured, err := cfg.Cache.Retention(); err != nil {
+				return err
+			} else if configured != nil {
+				retention = *configured
+			}
+			if cmd.Flags().Changed("keep") {
+				retention.Keep = keep
+			}
+			if cmd.Flags().Changed("max-age") {
+				if retention.MaxAge, err = goliquify.ParseAge(maxAge); err != nil {
+					return err
+				}
+			}
+			if retention.Keep == 0 && retention.MaxAge == 0 {
+				return fmt.Errorf("pass --keep or --max-age, or configure the cache retention")
+			}
+
+			removed, err := pl.PruneCache(retention, dryRun)
+			if err != nil {
+				return err
+			}
+			var freed int64
+			for _, entry := range removed {
+				freed += entry.Size
+			}
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+			printCacheEntries(removed)
+			fmt.Printf("%s %d toolchains, %s\n", verb, len(removed), formatBytes(freed))
+			return nil
+		},
+	}
+	cmd.Flags().Int("keep", 0, "Most recently used toolchains of each kind to keep")
+	cmd.Flags().String("max-age", "", "Remove toolchains unused for this long, e.g. 90d or 720h")
+	cmd.Flags().Bool("dry-run", false, "Only list the toolchains that would be removed")
+	return cmd
+}
+
+func printCacheEntries(entries []goliquify.CacheEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tKIND\tSIZE\tLAST USED")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", entry.Name, entry.Kind, formatBytes(entry.Size), entry.LastUsed.Format(time.DateTime))
+	}
+	tw.Flush()
+}
+
+// Format a size in bytes for people
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
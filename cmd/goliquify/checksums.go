@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newChecksumsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "checksums",
+		Short: "Inspect and repair changeset checksums",
+	}
+	cmd.AddCommand(newChecksumsRepairCmd())
+	return cmd
+}
+
+func newChecksumsRepairCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repair [-- liquibase args]",
+		Short: "List changesets with checksum mismatches and fix them one by one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fix, _ := cmd.Flags().GetString("fix")
+			only, _ := cmd.Flags().GetStringArray("changeset")
+			if fix != "" && fix != goliquify.CHECKSUM_FIX_VALID && fix != goliquify.CHECKSUM_FIX_CLEAR {
+				return fmt.Errorf("unknown fix %q, expecting %s or %s", fix, goliquify.CHECKSUM_FIX_VALID, goliquify.CHECKSUM_FIX_CLEAR)
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			mismatches, err := pl.ChecksumMismatches(args...)
+			if err != nil {
+				return err
+			}
+			if len(mismatches) == 0 {
+				fmt.Println("No checksum mismatches")
+				return nil
+			}
+
+			failed := 0
+			for _, m := range mismatches {
+				if len(only) > 0 && !containsString(only, m.Key) {
+					continue
+				}
+				printMismatch(m)
+				if fix == "" {
+					continue
+				}
+				if err := repairMismatch(pl, m, fix); err != nil {
+					fmt.Printf("  fix failed: %v\n", err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d checksum fix(es) failed", failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("fix", "", "Fix to apply: valid-checksum (accept the change in the changelog) or clear (reset the stored checksum)")
+	cmd.Flags().StringArray("changeset", nil, "Only handle this changeset (file::id::author), may be repeated")
+	return cmd
+}
+
+// Print a mismatch with the change made since it was deployed and the fixes available
+func printMismatch(m *goliquify.ChecksumMismatch) {
+	location := m.Key
+	if m.ChangeSet != nil && m.DiskPath != "" {
+		location = fmt.Sprintf("%s:%d", m.DiskPath, m.ChangeSet.Line)
+	}
+	fmt.Printf("%s: %s changed after it was deployed (deployed %s, now %s)\n", location, m.Key, m.Deployed, m.Current)
+	if m.DeployedBody != "" {
+		fmt.Printf("  change since commit %.8s:\n", m.Commit)
+		for _, line := range strings.Split(strings.TrimRight(goliquify.LineDiff(m.DeployedBody, m.ChangeSet.Body), "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	} else {
+		fmt.Println("  the deployed definition can't be recovered from git history")
+	}
+	fmt.Printf("  keep the change:  goliquify checksums repair --fix %s --changeset '%s'\n", goliquify.CHECKSUM_FIX_VALID, m.Key)
+	fmt.Printf("  re-record it:     goliquify checksums repair --fix %s --changeset '%s'\n", goliquify.CHECKSUM_FIX_CLEAR, m.Key)
+}
+
+// Apply a fix to one mismatch
+func repairMismatch(pl *goliquify.GoLiquibase, m *goliquify.ChecksumMismatch, fix string) error {
+	switch fix {
+	case goliquify.CHECKSUM_FIX_VALID:
+		if m.ChangeSet == nil || m.DiskPath == "" {
+			return fmt.Errorf("can't find %s in the changelogs", m.Key)
+		}
+		if err := goliquify.AddValidCheckSum(m.DiskPath, m.ChangeSet, m.Deployed); err != nil {
+			return err
+		}
+		fmt.Printf("  added validCheckSum %s to %s\n", m.Deployed, m.DiskPath)
+	case goliquify.CHECKSUM_FIX_CLEAR:
+		if err := pl.ClearChecksum(m.Key); err != nil {
+			return err
+		}
+		fmt.Println("  cleared the stored checksum, it is recorded again on the next update")
+	}
+	return nil
+}
+
+// Check if a list contains a string
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newCodegenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "codegen",
+		Short: "Generate Go structs, a sqlc schema, OpenAPI components or protobuf messages from the schema",
+		Long: `Generate code for the tables of a Liquibase snapshot, the baseline snapshot
+by default, or of the live database with --live:
+
+  go       Go structs with db and json tags, nullable columns as pointers
+  sql      CREATE TABLE and CREATE INDEX statements, the schema sqlc reads
+  openapi  The components section of an OpenAPI 3.0 document
+  proto    proto3 messages, field numbers following the column order
+
+Regenerate after every migration to keep application models in sync, e.g.
+with go:generate or in CI.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			snapshot, _ := cmd.Flags().GetString("snapshot")
+			live, _ := cmd.Flags().GetBool("live")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			schema, _ := cmd.Flags().GetString("schema")
+			pkg, _ := cmd.Flags().GetString("package")
+			tables, _ := cmd.Flags().GetStringSlice("tables")
+			output, _ := cmd.Flags().GetString("output")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			model, dialect, err := readSchemaModel(pl, cfg, live, snapshot, dsn, dialect, schema)
+			if err != nil {
+				return err
+			}
+			code, err := goliquify.GenerateCode(model, format, goliquify.CodegenOptions{Package: pkg, Dialect: dialect, Tables: tables})
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = os.Stdout.Write(code)
+				return err
+			}
+			if err := os.WriteFile(output, code, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Generated %s\n", output)
+			return nil
+		},
+	}
+	cmd.Flags().String("format", goliquify.CODEGEN_GO, "Generated code: "+strings.Join(goliquify.CODEGEN_FORMATS, ", "))
+	cmd.Flags().String("snapshot", "", "Liquibase JSON snapshot to generate from (default is the baseline snapshot)")
+	cmd.Flags().Bool("live", false, "Generate from the live database instead of a snapshot")
+	cmd.Flags().String("dsn", "", "Data source name of the database with --live (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	cmd.Flags().String("schema", "", "Schema to read with --live (default is the default schema, or public on Postgres)")
+	cmd.Flags().String("package", "models", "Package of the Go file or protobuf messages")
+	cmd.Flags().StringSlice("tables", nil, "Tables to generate, comma separated (default is every table)")
+	cmd.Flags().String("output", "", "File to write (default is stdout)")
+	return cmd
+}
+
+// Read the schema model of a snapshot, or of the live database, and the
+// dialect it was read with
+func readSchemaModel(pl *goliquify.GoLiquibase, cfg *goliquify.Config, live bool, snapshot, dsn, dialect, schema string) (*goliquify.SchemaModel, string, error) {
+	if !live {
+		if snapshot == "" && cfg.Baseline != nil {
+			snapshot = cfg.Baseline.Snapshot
+		}
+		if snapshot == "" {
+			return nil, "", fmt.Errorf("no snapshot to read, pass --snapshot or --live")
+		}
+		model, err := goliquify.ReadSnapshot(snapshot)
+		return model, dialect, err
+	}
+	var err error
+	if dsn == "" {
+		if dialect, dsn, err = pl.ReadOnlyDSN(); err != nil {
+			return nil, "", fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+		}
+	}
+	if schema == "" {
+		schema = pl.DefaultSchemaName
+	}
+	if schema == "" {
+		if dialect != goliquify.DIALECT_POSTGRES {
+			return nil, "", fmt.Errorf("pass --schema, the database to read")
+		}
+		schema = "public"
+	}
+	model, err := introspect(context.Background(), dialect, dsn, schema)
+	return model, dialect, err
+}
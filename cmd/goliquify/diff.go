@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff [-- liquibase args]",
+		Short: "Run Liquibase diff, failing when a change breaks the policies given with --enforce",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			enforce, _ := cmd.Flags().GetString("enforce")
+			changelog, _ := cmd.Flags().GetString("changelog")
+			reportFile, _ := cmd.Flags().GetString("report")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			schemaName, _ := cmd.Flags().GetString("schema")
+			format, _ := cmd.Flags().GetString("format")
+
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if enforce == "" {
+				if changelog != "" || reportFile != "" {
+					return fmt.Errorf("--changelog and --report are only read with --enforce")
+				}
+				return pl.Execute(append([]string{"diff"}, args...)...)
+			}
+			policy, err := goliquify.ReadDiffPolicy(enforce)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			var changes []goliquify.SchemaChange
+			switch {
+			case changelog != "" && reportFile != "":
+				return fmt.Errorf("pass --changelog or --report, not both")
+			case changelog != "":
+				changes, err = goliquify.ParseDiffChangelog(changelog)
+			case reportFile != "":
+				var file *os.File
+				if file, err = os.Open(reportFile); err == nil {
+					changes, err = goliquify.ParseDiffReport(file)
+					file.Close()
+				}
+			default:
+				changes, err = pl.DiffChanges(ctx, args...)
+			}
+			if err != nil {
+				return err
+			}
+
+			// With the live schema, existing indexes and column types are known
+			if dsn == "" {
+				dialect, dsn, _ = pl.ReadOnlyDSN()
+			}
+			if schemaName == "" {
+				schemaName = pl.DefaultSchemaName
+			}
+			if schemaName == "" && dialect == goliquify.DIALECT_POSTGRES {
+				schemaName = "public"
+			}
+			var schema *goliquify.SchemaModel
+			if dsn != "" && schemaName != "" {
+				if schema, err = introspect(ctx, dialect, dsn, schemaName); err != nil {
+					fmt.Fprintf(os.Stderr, "Checking without the live schema, only indexes in the diff count: %v\n", err)
+				}
+			}
+
+			violations := goliquify.EnforceDiffPolicy(policy, changes, schema)
+			goliquify.SortViolations(violations)
+			if format == "json" {
+				if violations == nil {
+					violations = []goliquify.PolicyViolation{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(violations); err != nil {
+					return err
+				}
+			} else {
+				for _, v := range violations {
+					fmt.Println(v.String())
+				}
+			}
+			errors := 0
+			for _, v := range violations {
+				if v.Severity == goliquify.SEVERITY_ERROR {
+					errors++
+				}
+			}
+			if errors > 0 {
+				return fmt.Errorf("%d change(s) violate the policies in %s", errors, enforce)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("enforce", "", "Policies file the diff is checked against")
+	cmd.Flags().String("changelog", "", "Check a changelog written by diff-changelog instead of running diff")
+	cmd.Flags().String("report", "", "Check a saved diff report instead of running diff")
+	cmd.Flags().String("dsn", "", "Data source name of the database being changed (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	cmd.Flags().String("schema", "", "Schema being changed (default is the default schema, or public on Postgres)")
+	cmd.Flags().String("format", "text", "Output format for violations: text or json")
+	return cmd
+}
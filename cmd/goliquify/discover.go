@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newDiscoverCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "discover [root]",
+		Short: "Find all changelog roots in a monorepo and run a command across them",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := "."
+			if len(args) > 0 {
+				root = args[0]
+			}
+			run, _ := cmd.Flags().GetString("run")
+			orderFile, _ := cmd.Flags().GetString("order")
+			report, _ := cmd.Flags().GetString("report")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			targets, err := goliquify.LoadOrderedTargets(root, orderFile, cfg)
+			if err != nil {
+				return err
+			}
+
+			if run == "" {
+				for _, t := range targets {
+					fmt.Printf("%s\t%s\n", t.Name, t.Changelog)
+				}
+				return nil
+			}
+			if run != "validate" && run != "status" && run != "update" {
+				return fmt.Errorf("unsupported command %q, expecting validate, status or update", run)
+			}
+
+			return reportTargetResults(pl.RunTargets(targets, run), report)
+		},
+	}
+	cmd.Flags().String("run", "", "Command to run for every target: validate, status or update")
+	cmd.Flags().String("order", "", "Dependency ordering file (defaults to goliquify.order in the root)")
+	cmd.Flags().String("report", "", "Write the consolidated report as JSON to this file")
+	return cmd
+}
+
+// Print the consolidated report, optionally write it as JSON, and fail if any target did not succeed
+func reportTargetResults(results []goliquify.TargetResult, report string) error {
+	goliquify.WriteTargetReport(os.Stdout, results)
+	if report != "" {
+		if err := goliquify.WriteTargetReportJSON(report, results); err != nil {
+			return err
+		}
+	}
+	for _, r := range results {
+		if r.Status != "success" {
+			return fmt.Errorf("%s failed for one or more targets", r.Command)
+		}
+	}
+	return nil
+}
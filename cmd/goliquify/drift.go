@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// database/sql driver names by dialect, drivers are linked in with build tags
+var sqlDrivers = map[string]string{
+	goliquify.DIALECT_POSTGRES: "postgres",
+	goliquify.DIALECT_MYSQL:    "mysql",
+}
+
+func newDriftCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Compare the live schema with a Liquibase snapshot or another database, without Java",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshot, _ := cmd.Flags().GetString("snapshot")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			referenceDSN, _ := cmd.Flags().GetString("reference-dsn")
+			schema, _ := cmd.Flags().GetString("schema")
+			format, _ := cmd.Flags().GetString("format")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if dsn == "" {
+				if dialect, dsn, err = pl.ReadOnlyDSN(); err != nil {
+					return fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+				}
+			}
+			if snapshot == "" && referenceDSN == "" && cfg.Baseline != nil {
+				snapshot = cfg.Baseline.Snapshot
+			}
+			if snapshot == "" && referenceDSN == "" {
+				return fmt.Errorf("nothing to compare against, pass --snapshot or --reference-dsn")
+			}
+			if schema == "" {
+				schema = pl.DefaultSchemaName
+			}
+			if schema == "" {
+				if dialect != goliquify.DIALECT_POSTGRES {
+					return fmt.Errorf("pass --schema, the database to compare")
+				}
+				schema = "public"
+			}
+
+			ctx := context.Background()
+			actual, err := introspect(ctx, dialect, dsn, schema)
+			if err != nil {
+				return err
+			}
+			var expected *goliquify.SchemaModel
+			if referenceDSN != "" {
+				expected, err = introspect(ctx, dialect, referenceDSN, schema)
+			} else {
+				expected, err = goliquify.ReadSnapshot(snapshot)
+			}
+			if err != nil {
+				return err
+			}
+
+			diffs := goliquify.DiffSchemas(expected, actual)
+			switch format {
+			case "json":
+				if diffs == nil {
+					diffs = []goliquify.SchemaDifference{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(diffs); err != nil {
+					return err
+				}
+			case "text":
+				for _, d := range diffs {
+					fmt.Println(d.String())
+				}
+			default:
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+			if len(diffs) > 0 {
+				err := fmt.Errorf("schema %s drifted: %d difference(s)", schema, len(diffs))
+				pl.SendEvent(goliquify.Event{Type: goliquify.EVENT_FAILED, Command: goliquify.OPERATION_DRIFT, Error: err.Error(), Differences: len(diffs), CI: pl.CI})
+				return err
+			}
+			pl.SendEvent(goliquify.Event{Type: goliquify.EVENT_COMPLETED, Command: goliquify.OPERATION_DRIFT, CI: pl.CI})
+			return nil
+		},
+	}
+	cmd.Flags().String("snapshot", "", "Liquibase JSON snapshot to compare against (default is the baseline snapshot)")
+	cmd.Flags().String("reference-dsn", "", "Compare against this database instead of a snapshot")
+	cmd.Flags().String("dsn", "", "Data source name of the database to check (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	cmd.Flags().String("schema", "", "Schema to compare (default is the default schema, or public on Postgres)")
+	cmd.Flags().String("format", "text", "Output format for differences: text or json")
+	return cmd
+}
+
+// Open a database through the driver linked in for its dialect
+func openDatabase(dialect, dsn string) (*sql.DB, error) {
+	driver, ok := sqlDrivers[dialect]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %q, expecting postgres or mysql", dialect)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		if strings.Contains(err.Error(), "unknown driver") {
+			return nil, fmt.Errorf("goliquify was built without the %s driver, rebuild with -tags %s", dialect, dialect)
+		}
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open a database and read its schema
+func introspect(ctx context.Context, dialect, dsn, schema string) (*goliquify.SchemaModel, error) {
+	db, err := openDatabase(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return goliquify.Introspect(ctx, db, dialect, schema)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newDriversCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drivers",
+		Short: "Manage curated JDBC driver bundles",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the driver bundles that can be installed",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range goliquify.DriverBundleNames() {
+				bundle := goliquify.DRIVER_BUNDLES[name]
+				license := bundle.License
+				if bundle.AcceptLicense {
+					license += ", must be accepted"
+				}
+				fmt.Printf("%-8s %s [%s]\n", name, bundle.Description, license)
+			}
+		},
+	}
+	install := &cobra.Command{
+		Use:   "install <driver>...",
+		Short: "Download driver bundles into the JDBC drivers dir or the managed Liquibase install",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			acceptLicense, _ := cmd.Flags().GetBool("accept-license")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if acceptLicense {
+				pl.AcceptedLicenses = append(pl.AcceptedLicenses, args...)
+			}
+			ctx := context.Background()
+			if err := pl.EnsureInstalled(ctx); err != nil {
+				return err
+			}
+
+			bundles, err := pl.InstallDrivers(ctx, args...)
+			var licenseErr *goliquify.LicenseError
+			for errors.As(err, &licenseErr) {
+				if !promptLicense(licenseErr.Bundle) {
+					return err
+				}
+				pl.AcceptedLicenses = append(pl.AcceptedLicenses, licenseErr.Bundle.Name)
+				bundles, err = pl.InstallDrivers(ctx, args...)
+			}
+			if err != nil {
+				return err
+			}
+			for _, bundle := range bundles {
+				fmt.Printf("Installed the %s driver\n", bundle.Name)
+				if bundle.Notes != "" {
+					fmt.Printf("  %s\n", bundle.Notes)
+				}
+			}
+			return nil
+		},
+	}
+	install.Flags().Bool("accept-license", false, "Accept the licenses of the drivers without prompting")
+	cmd.AddCommand(list, install)
+	return cmd
+}
+
+// Ask to accept a driver license, only when a terminal can answer
+func promptLicense(bundle *goliquify.DriverBundle) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Printf("The %s driver is licensed under the %s:\n  %s\nAccept the license? [y/N] ", bundle.Name, bundle.License, bundle.LicenseURL)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
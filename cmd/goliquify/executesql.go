@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newExecuteSQLCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "execute-sql",
+		Short: "Run SQL through Liquibase on the connection of the migrations and print the result sets",
+		Example: `  goliquify execute-sql --env prod --file query.sql
+  goliquify execute-sql --sql "select count(*) from orders" --format csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sql, _ := cmd.Flags().GetString("sql")
+			file, _ := cmd.Flags().GetString("file")
+			if sqlFile, _ := cmd.Flags().GetString("sql-file"); file == "" {
+				file = sqlFile
+			}
+			delimiter, _ := cmd.Flags().GetString("delimiter")
+			format, _ := cmd.Flags().GetString("format")
+			if format != "text" && format != "json" && format != "csv" {
+				return fmt.Errorf("unknown format %q, expecting text, json or csv", format)
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			// execute-sql has no SQL variant, so a dry run only shows what would run
+			if pl.DryRun {
+				switch {
+				case file != "" && sql != "":
+					return fmt.Errorf("pass either SQL or a SQL file, not both")
+				case file == "" && strings.TrimSpace(sql) == "":
+					return fmt.Errorf("no SQL to execute")
+				}
+				if file != "" {
+					fmt.Printf("Would execute the SQL of %s\n", file)
+				} else {
+					fmt.Printf("Would execute:\n%s\n", sql)
+				}
+				return nil
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			results, err := pl.ExecuteSQL(context.Background(), sql, goliquify.ExecuteSQLOptions{File: file, Delimiter: delimiter})
+			if err != nil {
+				return err
+			}
+
+			switch format {
+			case "json":
+				if results == nil {
+					results = []goliquify.SQLResult{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(results)
+			case "csv":
+				w := csv.NewWriter(os.Stdout)
+				written := 0
+				for _, r := range results {
+					if len(r.Columns) == 0 {
+						continue
+					}
+					if written++; written > 1 {
+						// A blank line between result sets
+						w.Flush()
+						fmt.Println()
+					}
+					w.Write(r.Columns)
+					w.WriteAll(r.Rows)
+				}
+				w.Flush()
+				return w.Error()
+			}
+			if len(results) == 0 {
+				fmt.Println("SQL executed, no result sets")
+			}
+			for i, r := range results {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%s\n\n", r.Statement)
+				if len(r.Columns) > 0 {
+					tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, strings.Join(r.Columns, "\t"))
+					for _, row := range r.Rows {
+						fmt.Fprintln(tw, strings.Join(row, "\t"))
+					}
+					tw.Flush()
+				}
+				fmt.Printf("(%d row(s))\n", len(r.Rows))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("sql", "", "SQL to run")
+	cmd.Flags().String("file", "", "File with the SQL to run")
+	cmd.Flags().String("delimiter", "", "Delimiter between statements, Liquibase's default when empty")
+	cmd.Flags().String("format", "text", "Output format: text, json or csv")
+	// Liquibase's own name of --file
+	cmd.Flags().String("sql-file", "", "")
+	cmd.Flags().MarkHidden("sql-file")
+	return cmd
+}
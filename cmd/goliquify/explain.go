@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newExplainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain [error-code-or-snippet]...",
+		Short: "Explain a Liquibase or JDBC failure and how to fix it",
+		Long: `Look up the causes and fixes of a failure by its ID, e.g. unknown-host, by
+an error code like a SQLSTATE (42P07) or a MySQL, SQL Server or Oracle code
+(1045, ORA-00942), or by a snippet of output. Without arguments the output is
+read from stdin, e.g. goliquify explain < failed-run.log. Failures the
+bundled explanations don't know are looked up in the knowledge base of the
+config, when one is set.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			offline, _ := cmd.Flags().GetBool("offline")
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+			query := strings.Join(args, " ")
+			if len(args) == 0 {
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				query = string(data)
+			}
+			if strings.TrimSpace(query) == "" {
+				return fmt.Errorf("nothing to explain, pass an error code or a snippet of output")
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			found := goliquify.LookupFailure(query)
+			if len(found) == 0 && pl.KnowledgeBase != nil && !offline {
+				if found, err = pl.KnowledgeBase.Lookup(context.Background(), strings.TrimSpace(query)); err != nil {
+					return err
+				}
+			}
+			if format == "json" {
+				if found == nil {
+					found = []*goliquify.FailureExplanation{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(found); err != nil {
+					return err
+				}
+			} else {
+				for i, e := range found {
+					if i > 0 {
+						fmt.Println()
+					}
+					fmt.Print(e)
+					if e.Source != "" {
+						fmt.Printf("  (from %s)\n", e.Source)
+					}
+				}
+			}
+			if len(found) == 0 {
+				return fmt.Errorf("no known explanation, the full output is in the run log when --log-dir is set")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	cmd.Flags().Bool("offline", false, "Only use the bundled explanations, not the knowledge base")
+	return cmd
+}
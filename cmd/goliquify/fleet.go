@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newFleetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fleet",
+		Short: "Inspect many deployment targets at once",
+	}
+	cmd.AddCommand(newFleetStatusCmd())
+	return cmd
+}
+
+func newFleetStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the pending changesets of every target, and which are behind a tag",
+		Long: `Query the deployment status of the configured targets, and of every
+defaults file matching --defaults-glob, concurrently. Targets are read
+through database/sql when goliquify was built with their driver, and through
+Liquibase status otherwise. Fails when any target is behind or can't be read.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pattern, _ := cmd.Flags().GetString("defaults-glob")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			tag, _ := cmd.Flags().GetString("tag")
+			format, _ := cmd.Flags().GetString("format")
+			if format != "table" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting table or json", format)
+			}
+			if tag != "" {
+				if err := goliquify.ValidateTag(tag); err != nil {
+					return err
+				}
+			}
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			var targets []*goliquify.Target
+			for name, t := range cfg.Targets {
+				t.Name = name
+				targets = append(targets, t)
+			}
+			sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+			if pattern != "" {
+				matched, err := goliquify.FleetTargetsFromGlob(pattern)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, matched...)
+			}
+			if len(targets) == 0 {
+				return fmt.Errorf("no targets, configure targets or pass --defaults-glob")
+			}
+
+			statuses := pl.CollectFleetStatus(context.Background(), targets, goliquify.FleetOptions{
+				Parallel:    parallel,
+				OpenDB:      openDatabase,
+				ExpectedTag: tag,
+			})
+			if format == "json" {
+				if err := goliquify.WriteFleetReportJSON(os.Stdout, statuses); err != nil {
+					return err
+				}
+			} else {
+				goliquify.WriteFleetReport(os.Stdout, statuses)
+			}
+
+			failing := 0
+			for _, s := range statuses {
+				if s.Status != goliquify.FLEET_UP_TO_DATE {
+					failing++
+				}
+			}
+			if failing > 0 {
+				return fmt.Errorf("%d of %d targets are behind or failed", failing, len(statuses))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("defaults-glob", "", "Add a target per defaults file matching this pattern, e.g. envs/*/liquibase.properties")
+	cmd.Flags().Int("parallel", goliquify.DEFAULT_FLEET_PARALLEL, "Number of targets queried at once")
+	cmd.Flags().String("tag", "", "Report targets without this tag as behind")
+	cmd.Flags().String("format", "table", "Output format: table or json")
+	return cmd
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newFlowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flow",
+		Short: "Run a Liquibase flow file, with Liquibase Pro or the built-in runner",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			engine, _ := cmd.Flags().GetString("engine")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			// Parse first, so a broken flow file fails before Liquibase is installed
+			if _, err := goliquify.ReadFlowFile(file); err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			if engine, err = pl.FlowEngine(engine); err != nil {
+				return err
+			}
+			if engine == goliquify.FLOW_ENGINE_LIQUIBASE {
+				return pl.RunLiquibaseFlow(context.Background(), file)
+			}
+			return pl.RunFlow(context.Background(), file)
+		},
+	}
+	cmd.Flags().String("file", goliquify.DEFAULT_FLOW_FILE, "Flow file to run")
+	cmd.Flags().String("engine", goliquify.FLOW_ENGINE_AUTO, "auto, liquibase (the Pro flow command) or builtin; auto uses Liquibase when a Pro license key is configured")
+	return cmd
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newGitOpsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gitops",
+		Short: "Watch a git branch and apply changelog changes to the discovered targets",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, _ := cmd.Flags().GetString("repo")
+			branch, _ := cmd.Flags().GetString("branch")
+			path, _ := cmd.Flags().GetString("path")
+			workDir, _ := cmd.Flags().GetString("workdir")
+			poll, _ := cmd.Flags().GetDuration("poll")
+			manualSync, _ := cmd.Flags().GetBool("manual-sync")
+			sync, _ := cmd.Flags().GetBool("sync")
+			showStatus, _ := cmd.Flags().GetBool("status")
+			approval, _ := cmd.Flags().GetString("approval")
+			statusFile, _ := cmd.Flags().GetString("status-file")
+
+			if showStatus {
+				status, err := goliquify.ReadGitOpsStatus(statusFile)
+				if err != nil {
+					return err
+				}
+				goliquify.WriteGitOpsStatus(os.Stdout, status)
+				return nil
+			}
+			if repo == "" {
+				return fmt.Errorf("--repo is required")
+			}
+			if workDir == "" {
+				workDir = filepath.Join(".goliquify", "gitops", strings.TrimSuffix(filepath.Base(repo), ".git"))
+			}
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			protected := false
+			if pl.Environment != "" {
+				if env, err := cfg.Environment(pl.Environment); err == nil {
+					protected = env.Protected
+				}
+			}
+
+			g := &goliquify.GitOps{
+				Repo:       repo,
+				Branch:     branch,
+				Path:       path,
+				WorkDir:    workDir,
+				Poll:       poll,
+				ManualSync: manualSync,
+				Protected:  protected,
+				Approval:   approval,
+				StatusFile: statusFile,
+				Config:     cfg,
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			if sync {
+				status, err := g.Check(ctx, pl, true)
+				if status != nil {
+					goliquify.WriteGitOpsStatus(os.Stdout, status)
+				}
+				return err
+			}
+			if err := g.Watch(ctx, pl); err != context.Canceled {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("repo", "", "Git repository URL to watch")
+	cmd.Flags().String("branch", "main", "Branch to apply")
+	cmd.Flags().String("path", ".", "Directory in the repository holding the changelogs")
+	cmd.Flags().String("workdir", "", "Local checkout of the repository (default .goliquify/gitops/<repo>)")
+	cmd.Flags().Duration("poll", goliquify.DEFAULT_GITOPS_POLL, "How often to check the branch for changes")
+	cmd.Flags().Bool("manual-sync", false, "Only report pending changes, apply them with --sync")
+	cmd.Flags().Bool("sync", false, "Check and apply pending changes once, then exit")
+	cmd.Flags().Bool("status", false, "Print the last status and exit")
+	cmd.Flags().String("status-file", goliquify.DEFAULT_GITOPS_STATUS_FILE, "File the status is written to")
+	return cmd
+}
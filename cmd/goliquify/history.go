@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Work with the deployment history of a database",
+	}
+	cmd.AddCommand(newHistoryExportCmd())
+	return cmd
+}
+
+func newHistoryExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the deployment history as CSV or JSON for reports and dashboards",
+		Long: `Export every row of the DATABASECHANGELOG table, with deployment IDs,
+execution types and tags. The table is read through database/sql, so
+goliquify needs the driver for the database, see the drift command.
+With --pending the changesets of the changelog that haven't been applied
+are added with the execution type PENDING.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			output, _ := cmd.Flags().GetString("output")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			pending, _ := cmd.Flags().GetBool("pending")
+			if format != "csv" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting csv or json", format)
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if dsn == "" {
+				if dialect, dsn, err = pl.ReadOnlyDSN(); err != nil {
+					return fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+				}
+			}
+			db, err := openDatabase(dialect, dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			entries, err := goliquify.ReadHistory(context.Background(), db, pl.LiquibaseSchemaName)
+			if err != nil {
+				return err
+			}
+			if pending {
+				changelog, searchPath := pl.ChangelogLocation()
+				if changelog == "" {
+					return fmt.Errorf("no changelog file to find pending changesets in")
+				}
+				tree, err := goliquify.LoadChangelogTree(changelog, searchPath)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, goliquify.PendingHistory(tree, entries)...)
+			}
+
+			var w io.Writer = os.Stdout
+			if output != "" {
+				file, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+			if format == "json" {
+				err = goliquify.WriteHistoryJSON(w, entries)
+			} else {
+				err = goliquify.WriteHistoryCSV(w, entries)
+			}
+			if err != nil {
+				return err
+			}
+			if output != "" {
+				log.Printf("Exported %d history entries to %s", len(entries), output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("format", "csv", "Output format: csv or json")
+	cmd.Flags().String("output", "", "File to write the export to (default is stdout)")
+	cmd.Flags().String("dsn", "", "Data source name of the database (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	cmd.Flags().Bool("pending", false, "Include changesets of the changelog that haven't been applied")
+	return cmd
+}
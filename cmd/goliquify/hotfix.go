@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newHotfixCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hotfix",
+		Short: "Apply ad-hoc SQL as a tracked changeset in the hotfix changelog and open a pull request with it",
+		Example: `  goliquify hotfix --env prod --sql fix.sql --rollback undo.sql --message "Unblock stuck orders"
+  goliquify hotfix --sql fix.sql --no-pr`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sqlFile, _ := cmd.Flags().GetString("sql")
+			rollbackFile, _ := cmd.Flags().GetString("rollback")
+			message, _ := cmd.Flags().GetString("message")
+			author, _ := cmd.Flags().GetString("author")
+			noPR, _ := cmd.Flags().GetBool("no-pr")
+
+			if sqlFile == "" {
+				return fmt.Errorf("--sql is required")
+			}
+			sql, err := os.ReadFile(sqlFile)
+			if err != nil {
+				return err
+			}
+			var rollback []byte
+			if rollbackFile != "" {
+				if rollback, err = os.ReadFile(rollbackFile); err != nil {
+					return err
+				}
+			}
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			result, err := pl.Hotfix(context.Background(), cfg.Hotfix, goliquify.HotfixOptions{
+				SQL:         string(sql),
+				Rollback:    string(rollback),
+				Message:     message,
+				Author:      author,
+				PullRequest: !noPR,
+			})
+			if result != nil && !pl.DryRun {
+				fmt.Printf("Hotfix %s applied and appended to %s\n", result.ChangeSet, result.Changelog)
+				if result.PullRequest != "" {
+					fmt.Printf("Pull request: %s\n", result.PullRequest)
+				}
+			}
+			return err
+		},
+	}
+	cmd.Flags().String("sql", "", "File with the SQL to apply")
+	cmd.Flags().String("rollback", "", "File with the SQL undoing the hotfix")
+	cmd.Flags().String("message", "", "What the hotfix fixes, the changeset comment and pull request title")
+	cmd.Flags().String("author", "", "Changeset author, the git user by default")
+	cmd.Flags().Bool("no-pr", false, "Don't open a pull request with the hotfix changelog")
+	return cmd
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newIDsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ids",
+		Short: "Check the ids of new changesets against the id policy, and fix them",
+		Long: `Check the changesets added since a git ref against the idPolicy of the
+config: timestamp ids, ids starting with a ticket, or sequential ids per
+file. With --fix the ids breaking the policy are rewritten. Only added
+changesets are checked and fixed, the ones existing at the ref may be
+deployed and keep their ids.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			since, _ := cmd.Flags().GetString("since")
+			fix, _ := cmd.Flags().GetBool("fix")
+			issue, _ := cmd.Flags().GetString("issue")
+			format, _ := cmd.Flags().GetString("format")
+			configFile, _ := cmd.Flags().GetString("config")
+
+			cfg, err := goliquify.LoadConfig(configFile, cmd.Flags().Changed("config"))
+			if err != nil {
+				return err
+			}
+			if cfg.IDPolicy == nil {
+				return fmt.Errorf("no idPolicy in the config")
+			}
+			if err := cfg.IDPolicy.Validate(); err != nil {
+				return err
+			}
+			changed, _, err := goliquify.ChangedChangeSets(since)
+			if err != nil {
+				return err
+			}
+			if !fix {
+				return reportDiagnostics(goliquify.CheckIDPolicy(cfg.IDPolicy, changed), format)
+			}
+			fixes, err := goliquify.FixChangeSetIDs(cfg.IDPolicy, changed, goliquify.IDFixOptions{Ticket: issue, Write: true})
+			if err != nil {
+				return err
+			}
+			for _, f := range fixes {
+				fmt.Printf("%s:%d: %s -> %s\n", f.File, f.Line, f.Old, f.New)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("since", "HEAD", "Check the changesets added since this git ref")
+	cmd.Flags().Bool("fix", false, "Rewrite the ids of added changesets breaking the policy")
+	cmd.Flags().String("issue", "", "Ticket of fixed ticket ids (default is found in the changeset comment and labels, or the git branch)")
+	cmd.Flags().String("format", "text", "Output format for problems: text or json")
+	return cmd
+}
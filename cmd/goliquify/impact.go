@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newImpactCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "impact",
+		Short: "Flag risky operations of the pending changesets given the size of their tables",
+		Long: `Read the pending changesets of the changelog and the estimated row
+counts of the database, both through database/sql, and flag operations that
+are risky on tables that size: full table rewrites and index builds blocking
+writes on large tables, and NOT NULL columns without a default on tables
+with rows. Run it before update, it needs no JVM.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			schema, _ := cmd.Flags().GetString("schema")
+			largeTableRows, _ := cmd.Flags().GetInt64("large-table-rows")
+			failOn, _ := cmd.Flags().GetString("fail-on")
+			format, _ := cmd.Flags().GetString("format")
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+			if failOn != goliquify.SEVERITY_ERROR && failOn != goliquify.SEVERITY_WARNING {
+				return fmt.Errorf("unknown severity %q, expecting error or warning", failOn)
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if dsn == "" {
+				if dialect, dsn, err = pl.ReadOnlyDSN(); err != nil {
+					return fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+				}
+			}
+			if schema == "" {
+				schema = pl.DefaultSchemaName
+			}
+			if schema == "" {
+				if dialect != goliquify.DIALECT_POSTGRES {
+					return fmt.Errorf("pass --schema, the database to analyze")
+				}
+				schema = "public"
+			}
+			changelog, searchPath := pl.ChangelogLocation()
+			if changelog == "" {
+				return fmt.Errorf("no changelog file to find pending changesets in")
+			}
+			tree, err := goliquify.LoadChangelogTree(changelog, searchPath)
+			if err != nil {
+				return err
+			}
+
+			db, err := openDatabase(dialect, dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			ctx := context.Background()
+			applied, err := goliquify.ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName)
+			if err != nil {
+				return err
+			}
+			rowCounts, err := goliquify.TableRowCounts(ctx, db, dialect, schema)
+			if err != nil {
+				return err
+			}
+
+			findings, err := goliquify.AnalyzeImpact(goliquify.PendingChangeSets(tree, applied), rowCounts, largeTableRows)
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				if findings == nil {
+					findings = []goliquify.ImpactFinding{}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(findings); err != nil {
+					return err
+				}
+			} else {
+				for _, f := range findings {
+					fmt.Println(f.String())
+				}
+			}
+			failing := 0
+			for _, f := range findings {
+				if f.Severity == goliquify.SEVERITY_ERROR || failOn == goliquify.SEVERITY_WARNING {
+					failing++
+				}
+			}
+			if failing > 0 {
+				return fmt.Errorf("%d risky operation(s) in the pending changesets", failing)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("dsn", "", "Data source name of the database (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	cmd.Flags().String("schema", "", "Schema holding the tables (default is the default schema, or public on Postgres)")
+	cmd.Flags().Int64("large-table-rows", goliquify.DEFAULT_LARGE_TABLE_ROWS, "Tables with at least this many rows count as large")
+	cmd.Flags().String("fail-on", goliquify.SEVERITY_ERROR, "Lowest severity that fails: error or warning")
+	cmd.Flags().String("format", "text", "Output format for findings: text or json")
+	return cmd
+}
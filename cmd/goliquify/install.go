@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newInstallCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "install",
+		Short: "Download and verify Liquibase, e.g. to pre-warm a container image",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.EnsureInstalled(context.Background()); err != nil {
+				return err
+			}
+			version := pl.InstalledVersion()
+			if version == "" {
+				version = goliquify.USER_PROVIDED_VERSION
+			}
+			fmt.Printf("Liquibase %s installed in %s\n", version, pl.LiquibaseDir)
+			if home := pl.JavaHome(); home != "" {
+				fmt.Printf("Java %d installed in %s\n", pl.JavaVersion, home)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newLintCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint [changelog files]",
+		Short: "Check changelogs for problems without a database",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			staged, _ := cmd.Flags().GetBool("staged")
+			since, _ := cmd.Flags().GetString("since")
+			strict, _ := cmd.Flags().GetBool("strict")
+			format, _ := cmd.Flags().GetString("format")
+			cache, _ := cmd.Flags().GetString("cache")
+			requireLogicalPaths, _ := cmd.Flags().GetBool("require-logical-file-path")
+			configFile, _ := cmd.Flags().GetString("config")
+			diffFile, _ := cmd.Flags().GetString("diff")
+
+			cfg, err := goliquify.LoadConfig(configFile, cmd.Flags().Changed("config"))
+			if err != nil {
+				return err
+			}
+
+			files := args
+			if staged || since != "" {
+				changed, err := goliquify.ChangedChangelogs(since, staged)
+				if err != nil {
+					return err
+				}
+				files = append(files, changed...)
+			}
+			if len(files) == 0 {
+				if staged || since != "" {
+					return nil
+				}
+				return fmt.Errorf("no changelog files given, pass files, --staged or --since")
+			}
+
+			diagnostics, err := goliquify.LintFiles(files, cache)
+			if err != nil {
+				return err
+			}
+			// Only new changesets follow the id policy, so it needs to know which are new
+			if cfg.IDPolicy != nil && (staged || since != "") {
+				if err := cfg.IDPolicy.Validate(); err != nil {
+					return err
+				}
+				ref := since
+				if ref == "" {
+					ref = "HEAD"
+				}
+				changed, _, err := goliquify.ChangedChangeSets(ref)
+				if err != nil {
+					return err
+				}
+				diagnostics = append(diagnostics, goliquify.CheckIDPolicy(cfg.IDPolicy, changed)...)
+			}
+			if requireLogicalPaths {
+				diagnostics = append(diagnostics, goliquify.LintLogicalFilePaths(files)...)
+			}
+			if cfg.Ownership != nil {
+				ownership, err := goliquify.LoadOwnership(cfg.Ownership)
+				if err != nil {
+					return err
+				}
+				diagnostics = append(diagnostics, ownership.LintFiles(files)...)
+			}
+			if cfg.Policies != nil {
+				var differences []goliquify.SchemaDifference
+				if diffFile != "" {
+					if differences, err = goliquify.ReadSchemaDifferences(diffFile); err != nil {
+						return err
+					}
+				}
+				found, err := goliquify.RunPolicies(cmd.Context(), cfg.Policies, goliquify.NewPolicyInput(files, differences))
+				if err != nil {
+					return err
+				}
+				diagnostics = append(diagnostics, found...)
+			}
+			err = reportDiagnostics(diagnostics, format)
+			if err != nil && len(diagnostics) > 0 && !strict && !goliquify.HasErrors(diagnostics) {
+				// Warnings alone don't fail the lint
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().Bool("staged", false, "Lint the changelogs staged in git")
+	cmd.Flags().String("since", "", "Lint the changelogs changed since this git ref")
+	cmd.Flags().Bool("strict", false, "Fail on warnings too")
+	cmd.Flags().String("format", "text", "Output format for problems: text or json")
+	cmd.Flags().Bool("require-logical-file-path", false, "Fail on changesets without a logicalFilePath, which run again when their file moves")
+	cmd.Flags().String("diff", "", "Schema differences from drift --format json to give the policy modules")
+	cmd.Flags().String("cache", goliquify.DEFAULT_LINT_CACHE_FILE, "Cache of lint results for unchanged files, empty to disable")
+	return cmd
+}
+
+func newHooksCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hooks",
+		Short: "Manage git hooks checking changelogs",
+	}
+	install := &cobra.Command{
+		Use:   "install",
+		Short: "Install pre-commit and pre-push hooks linting changed changelogs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			validate, _ := cmd.Flags().GetBool("validate")
+			force, _ := cmd.Flags().GetBool("force")
+			written, err := goliquify.InstallHooks(validate, force)
+			if err != nil {
+				return err
+			}
+			for _, path := range written {
+				fmt.Printf("Installed %s\n", path)
+			}
+			return nil
+		},
+	}
+	install.Flags().Bool("validate", false, "Also run Liquibase validate before pushing (needs a database connection)")
+	install.Flags().Bool("force", false, "Replace existing hooks")
+	cmd.AddCommand(install)
+	return cmd
+}
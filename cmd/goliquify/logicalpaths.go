@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newLogicalPathsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logical-paths",
+		Short: "Audit and pin the logicalFilePath of changelogs, so moving files doesn't break deployments",
+		Long: `Liquibase records changesets by the path of their changelog unless it
+sets a logicalFilePath, so moving or renaming a changelog makes it run the
+deployed changesets again, or fail on their checksums. audit lists the
+changelogs recorded by their path, set pins them to the path they are
+recorded by now, and migrate fixes databases whose changelogs already moved.`,
+	}
+	cmd.AddCommand(newLogicalPathsAuditCmd(), newLogicalPathsSetCmd(), newLogicalPathsMigrateCmd())
+	return cmd
+}
+
+// Load the changelog tree of the configured root changelog
+func loadChangelogTreeFromFlags(cmd *cobra.Command) (*goliquify.GoLiquibase, *goliquify.ChangelogTree, error) {
+	pl, _, err := newGoLiquibaseFromFlags(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	changelog, searchPath := pl.ChangelogLocation()
+	if changelog == "" {
+		return nil, nil, fmt.Errorf("no changelog file to check")
+	}
+	tree, err := goliquify.LoadChangelogTree(changelog, searchPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pl, tree, nil
+}
+
+func newLogicalPathsAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "List the changelogs and whether their changesets are pinned to a logicalFilePath",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+			_, tree, err := loadChangelogTreeFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			statuses := goliquify.AuditLogicalFilePaths(tree)
+			if format == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(statuses)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "FILE\tLOGICAL PATH\tCHANGESETS\tUNPINNED")
+			unpinned := 0
+			for _, s := range statuses {
+				logical := s.LogicalFilePath
+				if logical == "" {
+					logical = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", s.Path, logical, s.ChangeSets, s.Unpinned)
+				unpinned += s.Unpinned
+			}
+			w.Flush()
+			if unpinned > 0 {
+				fmt.Printf("\n%d changeset(s) run again if their file moves, pin them with goliquify logical-paths set --write\n", unpinned)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
+
+func newLogicalPathsSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Pin every changelog without a logicalFilePath to the path it is recorded by now",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			write, _ := cmd.Flags().GetBool("write")
+			_, tree, err := loadChangelogTreeFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			pinned := 0
+			for i, status := range goliquify.AuditLogicalFilePaths(tree) {
+				f := tree.Files[i]
+				if status.Unpinned == 0 {
+					continue
+				}
+				if write {
+					changed, err := goliquify.SetLogicalFilePath(f, f.Path)
+					if err != nil {
+						return err
+					}
+					if !changed {
+						continue
+					}
+				}
+				fmt.Printf("%s: logicalFilePath=%s\n", f.DiskPath, f.Path)
+				pinned++
+			}
+			if !write && pinned > 0 {
+				fmt.Printf("\n%d changelog(s) to pin, pass --write to change them\n", pinned)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Bool("write", false, "Write the logicalFilePath into the changelogs instead of listing them")
+	return cmd
+}
+
+func newLogicalPathsMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Fix databases whose changelogs moved, so Liquibase doesn't run their changesets again",
+		Long: `Read the deployment history and find the pending changesets deployed
+under another path, because their changelog moved. Prints the statements
+recording them under their new path, runs them with --apply, or with --pin
+pins the moved changelogs to their old path instead, leaving the databases
+as they are.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+			apply, _ := cmd.Flags().GetBool("apply")
+			pin, _ := cmd.Flags().GetBool("pin")
+			if apply && pin {
+				return fmt.Errorf("pass either --apply or --pin")
+			}
+
+			pl, tree, err := loadChangelogTreeFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if dsn == "" {
+				if dialect, dsn, err = pl.DatabaseDSN(); err != nil {
+					return fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+				}
+			}
+			db, err := openDatabase(dialect, dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			ctx := context.Background()
+			applied, err := goliquify.ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName)
+			if err != nil {
+				return err
+			}
+			moved := goliquify.FindMovedChangeSets(tree, applied)
+			if len(moved) == 0 {
+				fmt.Println("No moved changesets")
+				return nil
+			}
+
+			if pin {
+				// A moved changelog is pinned to the path its changesets were deployed by
+				paths := map[string]string{}
+				for _, m := range moved {
+					if previous, ok := paths[m.File]; ok && previous != m.From {
+						return fmt.Errorf("%s: changesets were deployed from %s and %s, pin it by hand", m.File, previous, m.From)
+					}
+					paths[m.File] = m.From
+				}
+				for _, f := range tree.Files {
+					from, ok := paths[f.DiskPath]
+					if !ok {
+						continue
+					}
+					changed, err := goliquify.SetLogicalFilePath(f, from)
+					if err != nil {
+						return err
+					}
+					if changed {
+						fmt.Printf("%s: logicalFilePath=%s\n", f.DiskPath, from)
+					} else {
+						fmt.Printf("%s: already has a logicalFilePath, fix the deployment history with --apply\n", f.DiskPath)
+					}
+				}
+				return nil
+			}
+
+			statements := goliquify.MovedChangeSetsSQL(moved, pl.LiquibaseSchemaName)
+			if !apply {
+				for _, statement := range statements {
+					fmt.Println(statement + ";")
+				}
+				return nil
+			}
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			for _, statement := range statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("failed to update the deployment history: %v", err)
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("failed to update the deployment history: %v", err)
+			}
+			fmt.Printf("Recorded %d moved changeset(s) under their new path\n", len(moved))
+			return nil
+		},
+	}
+	cmd.Flags().String("dsn", "", "Data source name of the database (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	cmd.Flags().Bool("apply", false, "Update the deployment history instead of printing the statements")
+	cmd.Flags().Bool("pin", false, "Pin the moved changelogs to their old path instead of updating the deployment history")
+	return cmd
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// Build a GoLiquibase instance and the config from the root command flags
+func newGoLiquibaseFromFlags(cmd *cobra.Command) (*goliquify.GoLiquibase, *goliquify.Config, error) {
+	defaultsFile, _ := cmd.Flags().GetString("defaultsFile")
+	liquibaseHubMode, _ := cmd.Flags().GetString("liquibaseHubMode")
+	logLevel, _ := cmd.Flags().GetString("logLevel")
+	liquibaseDir, _ := cmd.Flags().GetString("liquibaseDir")
+	liquibaseURL, _ := cmd.Flags().GetString("liquibase-url")
+	liquibaseZip, _ := cmd.Flags().GetString("liquibase-zip")
+	jdbcDriversDir, _ := cmd.Flags().GetString("jdbcDriversDir")
+	additionalClasspath, _ := cmd.Flags().GetString("additionalClasspath")
+	version, _ := cmd.Flags().GetString("version")
+	configFile, _ := cmd.Flags().GetString("config")
+	defines, _ := cmd.Flags().GetStringArray("define")
+	templateDir, _ := cmd.Flags().GetString("templateDir")
+	timingReport, _ := cmd.Flags().GetString("timing-report")
+	timingThreshold, _ := cmd.Flags().GetDuration("timing-threshold")
+	heartbeat, _ := cmd.Flags().GetDuration("heartbeat")
+	webhooks, _ := cmd.Flags().GetStringArray("webhook")
+	envName, _ := cmd.Flags().GetString("env")
+	overrideWindow, _ := cmd.Flags().GetString("override-window")
+	journal, _ := cmd.Flags().GetString("journal")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	sessionFlags, _ := cmd.Flags().GetStringArray("session")
+	skipChangeSets, _ := cmd.Flags().GetStringArray("skip-changeset")
+	onlyChangeSets, _ := cmd.Flags().GetStringArray("only-changeset")
+	plugins, _ := cmd.Flags().GetStringArray("plugin")
+	pgSafeRewrite, _ := cmd.Flags().GetBool("pg-safe-rewrite")
+	lockTimeout, _ := cmd.Flags().GetDuration("lock-timeout")
+	cacheDir, _ := cmd.Flags().GetString("cache-dir")
+	defaultSchema, _ := cmd.Flags().GetString("default-schema")
+	liquibaseSchema, _ := cmd.Flags().GetString("liquibase-schema")
+	liquibaseCatalog, _ := cmd.Flags().GetString("liquibase-catalog")
+	schemas, _ := cmd.Flags().GetStringSlice("schemas")
+	sandbox, _ := cmd.Flags().GetBool("sandbox")
+	disableAnalytics, _ := cmd.Flags().GetBool("disable-analytics")
+	javaVersion, _ := cmd.Flags().GetInt("java-version")
+	report, _ := cmd.Flags().GetBool("report")
+	reportPath, _ := cmd.Flags().GetString("report-path")
+	role, _ := cmd.Flags().GetString("role")
+	logDir, _ := cmd.Flags().GetString("log-dir")
+	logMaxFiles, _ := cmd.Flags().GetInt("log-max-files")
+	logMaxAge, _ := cmd.Flags().GetDuration("log-max-age")
+	ticket, _ := cmd.Flags().GetString("ticket")
+	approval, _ := cmd.Flags().GetString("approval")
+	strict, _ := cmd.Flags().GetBool("strict")
+	stackTraces, _ := cmd.Flags().GetBool("stack-traces")
+	fileEncoding, _ := cmd.Flags().GetString("file-encoding")
+	outputFileEncoding, _ := cmd.Flags().GetString("output-file-encoding")
+	locale, _ := cmd.Flags().GetString("locale")
+	tunnelBastion, _ := cmd.Flags().GetString("tunnel")
+	sslMode, _ := cmd.Flags().GetString("ssl-mode")
+	sslRootCert, _ := cmd.Flags().GetString("ssl-root-cert")
+	sslCert, _ := cmd.Flags().GetString("ssl-cert")
+	sslKey, _ := cmd.Flags().GetString("ssl-key")
+
+	cfg, err := goliquify.LoadConfig(configFile, cmd.Flags().Changed("config"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if strict {
+		if err := goliquify.CheckConfigKeys(configFile); err != nil {
+			return nil, nil, fmt.Errorf("strict mode: %v", err)
+		}
+	}
+	flagProps, err := goliquify.ParseDefines(defines)
+	if err != nil {
+		return nil, nil, err
+	}
+	flagSession, err := goliquify.ParseDefines(sessionFlags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := &goliquify.Environment{}
+	if envName != "" {
+		if env, err = cfg.Environment(envName); err != nil {
+			return nil, nil, err
+		}
+		if env.DefaultsFile != "" && !cmd.Flags().Changed("defaultsFile") {
+			defaultsFile = env.DefaultsFile
+		}
+	}
+	// Layer liquibase.<env>.properties over the defaults file
+	if defaultsFile, err = goliquify.RenderLayeredDefaults(defaultsFile, envName, cacheDir); err != nil {
+		return nil, nil, err
+	}
+
+	windows := &goliquify.WindowPolicy{Windows: env.Windows, Commands: env.WindowCommands}
+	if env.Timezone != "" {
+		if windows.Location, err = time.LoadLocation(env.Timezone); err != nil {
+			return nil, nil, fmt.Errorf("invalid timezone for environment %s: %v", envName, err)
+		}
+	}
+
+	if defaultSchema == "" {
+		defaultSchema = env.DefaultSchema
+	}
+	if liquibaseSchema == "" {
+		liquibaseSchema = env.LiquibaseSchema
+	}
+	if liquibaseCatalog == "" {
+		liquibaseCatalog = env.LiquibaseCatalog
+	}
+	if len(schemas) == 0 {
+		schemas = env.Schemas
+	}
+
+	var location *time.Location
+	if env.DatabaseTimezone != "" {
+		if location, err = time.LoadLocation(env.DatabaseTimezone); err != nil {
+			return nil, nil, fmt.Errorf("invalid database timezone for environment %s: %v", envName, err)
+		}
+	}
+
+	if templateDir == "" {
+		templateDir = cfg.TemplateDir
+	}
+	if !cmd.Flags().Changed("heartbeat") {
+		heartbeat = cfg.Heartbeat
+	}
+	if journal == "" {
+		journal = cfg.Journal
+	}
+	if role == "" {
+		role = os.Getenv("GOLIQUIFY_ROLE")
+	}
+	if ticket == "" {
+		ticket = os.Getenv(goliquify.CHANGE_TICKET_ENV)
+	}
+	if env.ChangeTickets != nil {
+		if err := env.ChangeTickets.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", envName, err)
+		}
+	}
+	if env.ReadOnly != nil {
+		if err := env.ReadOnly.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", envName, err)
+		}
+	}
+
+	// Tunnel and TLS: the environment's, then flags
+	tunnel := env.Tunnel
+	if tunnelBastion != "" {
+		tunnel = &goliquify.TunnelConfig{Bastion: tunnelBastion}
+		if env.Tunnel != nil {
+			tunnel.IdentityFile, tunnel.Options, tunnel.SSH = env.Tunnel.IdentityFile, env.Tunnel.Options, env.Tunnel.SSH
+		}
+	}
+	tls := env.TLS
+	if sslMode != "" || sslRootCert != "" || sslCert != "" || sslKey != "" {
+		merged := goliquify.TLSConfig{}
+		if tls != nil {
+			merged = *tls
+		}
+		if sslMode != "" {
+			merged.Mode = sslMode
+		}
+		if sslRootCert != "" {
+			merged.RootCert = sslRootCert
+		}
+		if sslCert != "" {
+			merged.ClientCert = sslCert
+		}
+		if sslKey != "" {
+			merged.ClientKey = sslKey
+		}
+		tls = &merged
+	}
+	if tls != nil {
+		if err := tls.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if env.Kerberos != nil {
+		if err := env.Kerberos.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", envName, err)
+		}
+	}
+	if env.Entra != nil {
+		if err := env.Entra.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", envName, err)
+		}
+	}
+	if env.DSQL != nil {
+		if err := env.DSQL.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", envName, err)
+		}
+	}
+	if env.Spanner != nil {
+		if err := env.Spanner.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", envName, err)
+		}
+	}
+
+	// Encodings: the config's, then the environment's, then flags
+	encoding := cfg.Encoding.Merge(env.Encoding).Merge(goliquify.EncodingOptions{
+		FileEncoding:       fileEncoding,
+		OutputFileEncoding: outputFileEncoding,
+		Locale:             locale,
+	})
+	if err := encoding.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	// A build to test against instead of a release, named by --version when given
+	liquibaseArtifact, artifactVersion := liquibaseURL, ""
+	if liquibaseZip != "" {
+		if liquibaseURL != "" {
+			return nil, nil, fmt.Errorf("pass either --liquibase-url or --liquibase-zip")
+		}
+		liquibaseArtifact = liquibaseZip
+	}
+	if liquibaseArtifact != "" && liquibaseDir != "" {
+		return nil, nil, fmt.Errorf("--liquibaseDir can't be combined with a Liquibase build to install")
+	}
+	if cmd.Flags().Changed("version") {
+		artifactVersion = version
+	}
+
+	// A version file pins the Liquibase version of the repository, over the flag
+	versionFile, pinned, err := goliquify.FindVersionFile(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	if pinned != "" && liquibaseArtifact != "" {
+		log.Printf("Warning: installing the Liquibase build %s, which may not be the version %s pinned by %s", liquibaseArtifact, pinned, versionFile)
+	} else if pinned != "" {
+		if cmd.Flags().Changed("version") && version != pinned {
+			log.Printf("Warning: %s pins Liquibase %s, ignoring --version %s", versionFile, pinned, version)
+		}
+		version = pinned
+		if liquibaseDir != "" {
+			log.Printf("Warning: using the Liquibase install in %s, which may not be the version %s pinned by %s", liquibaseDir, pinned, versionFile)
+		}
+	}
+	reports := cfg.OperationReports
+	if report || reportPath != "" {
+		if reports == nil {
+			reports = &goliquify.OperationReports{}
+		}
+		if reportPath != "" {
+			reports.Path = reportPath
+		}
+	}
+	if !cmd.Flags().Changed("java-version") {
+		javaVersion = cfg.JavaVersion
+	}
+	if logDir == "" {
+		logDir = cfg.LogDir
+	}
+	logRetention := cfg.LogRetention
+	if cmd.Flags().Changed("log-max-files") {
+		logRetention.MaxFiles = logMaxFiles
+	}
+	if cmd.Flags().Changed("log-max-age") {
+		logRetention.MaxAge = logMaxAge
+	}
+	cacheRetention, err := cfg.Cache.Retention()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cache retention: %v", err)
+	}
+	switch journal {
+	case "":
+		journal = goliquify.DEFAULT_JOURNAL_FILE
+	case "off":
+		journal = ""
+	}
+
+	var safeRewrite *goliquify.SafeRewriteOptions
+	if pgSafeRewrite {
+		safeRewrite = &goliquify.SafeRewriteOptions{LockTimeout: lockTimeout}
+	}
+
+	opts := []goliquify.Option{
+		goliquify.WithDefaultsFile(defaultsFile),
+		goliquify.WithHubMode(liquibaseHubMode),
+		goliquify.WithLogLevel(logLevel),
+		goliquify.WithJDBCDriversDir(jdbcDriversDir),
+		goliquify.WithAdditionalClasspath(additionalClasspath),
+		goliquify.WithVersion(version),
+		goliquify.WithCacheDir(cacheDir),
+		goliquify.WithCacheRetention(cacheRetention),
+		goliquify.WithTemplateDir(templateDir, cfg.TemplateValues),
+		goliquify.WithTimingReport(timingReport, timingThreshold),
+		goliquify.WithHeartbeat(heartbeat),
+		goliquify.WithEnvironment(envName),
+		goliquify.WithWindowPolicy(windows, overrideWindow),
+		goliquify.WithJournal(journal),
+		goliquify.WithLogDir(logDir, logRetention),
+		goliquify.WithSecrets(cfg.Secrets.Merge(env.Secrets)),
+		goliquify.WithCommandPolicy(env.Guardrails, role),
+		goliquify.WithReadOnlyCredentials(env.ReadOnly),
+		goliquify.WithTunnel(tunnel),
+		goliquify.WithTLS(tls),
+		goliquify.WithKerberos(env.Kerberos),
+		goliquify.WithEntra(env.Entra),
+		goliquify.WithDSQL(env.DSQL),
+		goliquify.WithChangeSetFilter(skipChangeSets, onlyChangeSets),
+		goliquify.WithSpanner(env.Spanner),
+		goliquify.WithManagedJRE(javaVersion),
+		goliquify.WithOperationReports(reports),
+		goliquify.WithAttestations(env.Attestations),
+		goliquify.WithDrivers(cfg.Drivers, cfg.AcceptLicenses),
+		goliquify.WithExtensionMatrix(cfg.ExtensionMatrix),
+		goliquify.WithDryRun(dryRun),
+		goliquify.WithStrict(strict),
+		goliquify.WithStackTraces(stackTraces),
+		goliquify.WithKnowledgeBase(cfg.KnowledgeBase),
+		goliquify.WithEncoding(encoding),
+		goliquify.WithSafeRewrite(safeRewrite),
+		goliquify.WithBackup(env.Backup),
+		goliquify.WithChangeTickets(env.ChangeTickets, ticket),
+		goliquify.WithApproval(env.Protected, approval),
+		goliquify.WithSchemaRegistry(cfg.SchemaRegistry),
+		// Session settings: the environment's, then flags
+		goliquify.WithSessionSettings(env.Session),
+		goliquify.WithSessionSettings(flagSession),
+		goliquify.WithLocation(location),
+		goliquify.WithDefaultSchema(defaultSchema),
+		goliquify.WithLiquibaseSchema(liquibaseSchema, liquibaseCatalog),
+		goliquify.WithSchemas(schemas...),
+		goliquify.WithSandbox(sandbox),
+		goliquify.WithAnalyticsDisabled(disableAnalytics || cfg.DisableAnalytics || env.DisableAnalytics),
+		goliquify.WithCIMetadata(goliquify.DetectCIMetadata(os.Getenv)),
+		// Changelog properties: config, then the environment's, then environment variables, then flags
+		goliquify.WithChangelogProperties(cfg.ChangelogProperties),
+		goliquify.WithChangelogProperties(env.ChangelogProperties),
+		goliquify.WithChangelogProperties(goliquify.ChangelogPropertiesFromEnv(os.Environ())),
+		goliquify.WithChangelogProperties(flagProps),
+	}
+	if liquibaseDir != "" {
+		opts = append(opts, goliquify.WithLiquibaseDir(liquibaseDir))
+	}
+	if liquibaseArtifact != "" {
+		opts = append(opts, goliquify.WithLiquibaseArtifact(liquibaseArtifact, artifactVersion))
+	}
+	for _, url := range append(cfg.Webhooks, webhooks...) {
+		opts = append(opts, goliquify.WithEventSink(&goliquify.WebhookSink{URL: url}))
+	}
+	incidents, err := goliquify.NewIncidentSink(cfg.Incidents, envName, env.IncidentSeverity)
+	if err != nil {
+		return nil, nil, err
+	}
+	if incidents != nil {
+		opts = append(opts, goliquify.WithEventSink(incidents))
+	}
+	for i := range cfg.Metrics {
+		sink, err := goliquify.NewMetricsSink(&cfg.Metrics[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, goliquify.WithEventSink(sink))
+	}
+	for i := range cfg.EventPublishers {
+		publisher, err := goliquify.NewEventPublisher(&cfg.EventPublishers[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, goliquify.WithEventSink(publisher))
+	}
+
+	pl := goliquify.New(opts...)
+	// Plugins of the config, then of the flags
+	for _, name := range append(cfg.Plugins, plugins...) {
+		middleware, err := goliquify.PluginMiddleware(pl, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		pl.Middleware = append(pl.Middleware, middleware)
+	}
+	return pl, cfg, nil
+}
+
+func main() {
+	var rootCmd = &cobra.Command{
+		Use:   "goliquify",
+		Short: "A Go implementation of GoLiquibase",
+		Args:  cobra.ArbitraryArgs,
+		// Errors are reported once by log.Fatal below
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := pl.Initialize(); err != nil {
+				log.Fatal(err)
+			}
+
+			// Parse and handle arguments
+			if err := pl.Execute(args...); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	rootCmd.PersistentFlags().StringP("defaultsFile", "d", "liquibase.properties", "Relative path to liquibase.properties file")
+	rootCmd.PersistentFlags().StringP("liquibaseHubMode", "h", "off", "Liquibase Hub mode, dropped on Liquibase 4.24 and later which removed Hub")
+	rootCmd.PersistentFlags().StringP("logLevel", "l", "", "Log level name")
+	rootCmd.PersistentFlags().StringP("liquibaseDir", "D", "", "User provided Liquibase directory")
+	rootCmd.PersistentFlags().String("liquibase-url", "", "Install the Liquibase build at this zip or tar.gz url instead of a release, e.g. a nightly")
+	rootCmd.PersistentFlags().String("liquibase-zip", "", "Install a local Liquibase build instead of a release: a zip, a tar.gz or an extracted build directory")
+	rootCmd.PersistentFlags().StringP("jdbcDriversDir", "j", "", "User provided JDBC drivers directory. All jar files under this directory are loaded")
+	rootCmd.PersistentFlags().StringP("additionalClasspath", "a", "", "Additional classpath to import java libraries and Liquibase extensions")
+	rootCmd.PersistentFlags().StringP("version", "v", goliquify.DEFAULT_LIQUIBASE_VERSION, "Liquibase version, or latest, latest-<major>.x or latest-<major>.<minor>.x for the newest release")
+	rootCmd.PersistentFlags().StringP("config", "c", goliquify.DEFAULT_CONFIG_FILE, "Path to the GoLiquify config file")
+	rootCmd.PersistentFlags().StringArray("define", nil, "Changelog property as key=value, may be repeated")
+	rootCmd.PersistentFlags().StringP("templateDir", "t", "", "Render changelogs in this directory through Go templates before execution")
+	rootCmd.PersistentFlags().String("timing-report", "", "Write per-changeset execution times as JSON to this file")
+	rootCmd.PersistentFlags().Duration("timing-threshold", 0, "Flag changesets running at least this long in the timing report")
+	rootCmd.PersistentFlags().Duration("heartbeat", 0, "Emit a heartbeat event at this interval while Liquibase runs")
+	rootCmd.PersistentFlags().StringArray("webhook", nil, "Post run events as JSON to this URL, may be repeated")
+	rootCmd.PersistentFlags().StringP("env", "e", "", "Environment from the config file to run against")
+	rootCmd.PersistentFlags().String("role", "", "Role for the environment's guardrails, a guard against mistakes rather than access control (default $GOLIQUIFY_ROLE)")
+	rootCmd.PersistentFlags().String("override-window", "", "Run outside the maintenance window, giving the reason")
+	rootCmd.PersistentFlags().String("journal", "", "Run journal file (default .goliquify/journal.jsonl, 'off' to disable)")
+	rootCmd.PersistentFlags().String("log-dir", "", "Write the full Liquibase output of every run to a file in this directory")
+	rootCmd.PersistentFlags().Int("log-max-files", 0, "Run logs to keep in the log dir (default 50)")
+	rootCmd.PersistentFlags().Duration("log-max-age", 0, "Remove run logs older than this from the log dir")
+	rootCmd.PersistentFlags().String("cache-dir", "", "Directory Liquibase is installed into (default is the user cache dir)")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Print the SQL mutating commands would run instead of running them")
+	rootCmd.PersistentFlags().String("file-encoding", "", "Encoding Liquibase reads changelogs with and the JVM default encoding, e.g. UTF-8")
+	rootCmd.PersistentFlags().String("output-file-encoding", "", "Encoding of the files Liquibase writes, e.g. UTF-8")
+	rootCmd.PersistentFlags().String("locale", "", "Locale of the JVM, e.g. en-US")
+	rootCmd.PersistentFlags().Bool("stack-traces", false, "Print Java stack traces in full, by default their frames are collapsed and known failures explained")
+	rootCmd.PersistentFlags().Bool("strict", false, "Fail fast on unknown config keys, missing changelog includes, a missing JDBC driver and deprecated flags")
+	rootCmd.PersistentFlags().StringArray("session", nil, "Session setting of every connection as name=value, e.g. lock_timeout=5s, may be repeated")
+	rootCmd.PersistentFlags().StringArray("skip-changeset", nil, "Leave a changeset out of this run as id::author::path, may be repeated")
+	rootCmd.PersistentFlags().StringArray("only-changeset", nil, "Run only this changeset, given as id::author::path, may be repeated")
+	rootCmd.PersistentFlags().StringArray("plugin", nil, "Run the goliquify-plugin-<name> executable on the PATH around every command, may be repeated")
+	rootCmd.PersistentFlags().Bool("pg-safe-rewrite", false, "Rewrite the SQL update-sql generates for Postgres: concurrent indexes, NOT VALID constraints and a lock timeout")
+	rootCmd.PersistentFlags().Duration("lock-timeout", goliquify.DEFAULT_LOCK_TIMEOUT, "Lock timeout of SQL rewritten with --pg-safe-rewrite")
+	rootCmd.PersistentFlags().Bool("sandbox", false, "Run Liquibase in a private working directory with a scratch copy of the install")
+	rootCmd.PersistentFlags().Bool("disable-analytics", false, "Turn off Liquibase analytics and Hub traffic")
+	rootCmd.PersistentFlags().Bool("report", false, "Write a Liquibase operation report for commands that support one, Liquibase 4.26 and later")
+	rootCmd.PersistentFlags().String("report-path", "", "Directory operation reports are written to, implies --report")
+	rootCmd.PersistentFlags().Int("java-version", 0, "Run Liquibase with a managed JRE of this Java version, e.g. 17, instead of the system Java")
+	rootCmd.PersistentFlags().String("default-schema", "", "Schema unqualified database objects are created in")
+	rootCmd.PersistentFlags().String("liquibase-schema", "", "Schema holding the Liquibase tracking tables")
+	rootCmd.PersistentFlags().String("liquibase-catalog", "", "Catalog holding the Liquibase tracking tables")
+	rootCmd.PersistentFlags().StringSlice("schemas", nil, "Run update once per schema, comma separated (e.g. one per tenant)")
+	rootCmd.PersistentFlags().String("tunnel", "", "Reach the database through an SSH tunnel to this bastion, user@host[:port]")
+	rootCmd.PersistentFlags().String("ssl-mode", "", "SSL mode of the database connection: require, verify-ca or verify-full")
+	rootCmd.PersistentFlags().String("ssl-root-cert", "", "CA of the database server, a PEM file on Postgres and a truststore otherwise")
+	rootCmd.PersistentFlags().String("ssl-cert", "", "Client certificate, a PEM file on Postgres and a keystore with its key otherwise")
+	rootCmd.PersistentFlags().String("ssl-key", "", "Client key in PKCS-8 on Postgres")
+	rootCmd.PersistentFlags().String("approval", "", "Approval of changes to a protected environment: a signed approval file, 'github' or 'prompt'")
+	rootCmd.PersistentFlags().String("ticket", "", "Change ticket of the run, e.g. CHG0030001 (default is $GOLIQUIFY_CHANGE_TICKET)")
+
+	// -h is taken by liquibaseHubMode, so help is only available as --help
+	rootCmd.PersistentFlags().Bool("help", false, "Help for goliquify")
+
+	rootCmd.AddCommand(newDiscoverCmd())
+	rootCmd.AddCommand(newUpdateCmd())
+	rootCmd.AddCommand(newSeedCmd())
+	rootCmd.AddCommand(newPlanCmd())
+	rootCmd.AddCommand(newApproveCmd())
+	rootCmd.AddCommand(newApplyCmd())
+	rootCmd.AddCommand(newInstallCmd())
+	rootCmd.AddCommand(newReleaseCmd())
+	rootCmd.AddCommand(newGitOpsCmd())
+	rootCmd.AddCommand(newBaselineCmd())
+	rootCmd.AddCommand(newValidateCmd())
+	rootCmd.AddCommand(newChecksumsCmd())
+	rootCmd.AddCommand(newLintCmd())
+	rootCmd.AddCommand(newHooksCmd())
+	rootCmd.AddCommand(newDriftCmd())
+	rootCmd.AddCommand(newDriversCmd())
+	rootCmd.AddCommand(newVersionsCmd())
+	rootCmd.AddCommand(newFlowCmd())
+	rootCmd.AddCommand(newPipelineCmd())
+	rootCmd.AddCommand(newFleetCmd())
+	rootCmd.AddCommand(newHistoryCmd())
+	rootCmd.AddCommand(newAttestCmd())
+	rootCmd.AddCommand(newSBOMCmd())
+	rootCmd.AddCommand(newAuditCmd())
+	rootCmd.AddCommand(newCacheCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newImpactCmd())
+	rootCmd.AddCommand(newRestoreCmd())
+	rootCmd.AddCommand(newRehearseCmd())
+	rootCmd.AddCommand(newBranchDeployCmd())
+	rootCmd.AddCommand(newSnippetsCmd())
+	rootCmd.AddCommand(newUICmd())
+	rootCmd.AddCommand(newServeCmd())
+	rootCmd.AddCommand(newCodegenCmd())
+	rootCmd.AddCommand(newSqlcCmd())
+	rootCmd.AddCommand(newERDCmd())
+	rootCmd.AddCommand(newStatsCmd())
+	rootCmd.AddCommand(newExplainCmd())
+	rootCmd.AddCommand(newLogicalPathsCmd())
+	rootCmd.AddCommand(newOrphansCmd())
+	rootCmd.AddCommand(newIDsCmd())
+	rootCmd.AddCommand(newRollbackCmd())
+	rootCmd.AddCommand(newHotfixCmd())
+	rootCmd.AddCommand(newExecuteSQLCmd())
+	rootCmd.AddCommand(newArchiveCmd())
+	rootCmd.AddCommand(newUnarchiveCmd())
+	rootCmd.AddCommand(newPluginsCmd())
+
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Check if a file exists
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && !info.IsDir()
+}
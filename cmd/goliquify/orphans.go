@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newOrphansCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "orphans [dirs]",
+		Short: "List changelogs the root changelog never includes, and files it includes twice",
+		Long: `Walk the directories for changelog files with changesets that the root
+changelog doesn't reach through its include and includeAll entries, so
+Liquibase silently never runs them, and report the files included more than
+once. Without directories the directory of the root changelog is walked.
+Fails when anything is found, so it can guard CI.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+
+			_, tree, err := loadChangelogTreeFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			orphans, err := tree.Orphans(args...)
+			if err != nil {
+				return err
+			}
+			diagnostics := goliquify.OrphanDiagnostics(orphans)
+			for _, d := range tree.Diagnostics() {
+				if d.Kind == goliquify.DIAGNOSTIC_DOUBLE_INCLUDE {
+					diagnostics = append(diagnostics, d)
+				}
+			}
+			return reportDiagnostics(diagnostics, format)
+		},
+	}
+	cmd.Flags().String("format", "text", "Output format for problems: text or json")
+	return cmd
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newPipelineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pipeline",
+		Short: "Run the configured pipeline of steps, validate, plan, apply and verify by default",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stepNames, _ := cmd.Flags().GetStringSlice("steps")
+			reportFile, _ := cmd.Flags().GetString("report")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			steps := cfg.Pipeline.Steps
+			if len(stepNames) > 0 {
+				steps = nil
+				for _, name := range stepNames {
+					steps = append(steps, goliquify.PipelineStep{Run: name})
+				}
+			}
+			if reportFile == "" {
+				reportFile = cfg.Pipeline.Report
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+
+			report, err := pl.RunPipeline(context.Background(), steps)
+			if report == nil {
+				return err
+			}
+			for _, step := range report.Steps {
+				line := fmt.Sprintf("%-10s %-9s %8s", step.Name, step.Status, (time.Duration(step.DurationMs) * time.Millisecond).String())
+				if step.Error != "" {
+					line += "  " + step.Error
+				}
+				fmt.Println(line)
+			}
+			if reportFile != "" {
+				if writeErr := report.WriteJSON(reportFile); writeErr != nil {
+					log.Printf("Failed to write the pipeline report: %v", writeErr)
+				}
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringSlice("steps", nil, "Steps to run instead of the configured ones, e.g. validate,plan")
+	cmd.Flags().String("report", "", "Write a JSON report of the pipeline to this file")
+	return cmd
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newPlanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan [-- liquibase args]",
+		Short: "Write the SQL an update would run to a plan file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out, _ := cmd.Flags().GetString("out")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			plan, err := pl.Output(append([]string{"update-sql"}, args...)...)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(out, []byte(plan), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Plan written to %s, digest %s\n", out, goliquify.PlanDigest(plan))
+			return nil
+		},
+	}
+	cmd.Flags().String("out", "plan.sql", "Plan file to write")
+	return cmd
+}
+
+func newApproveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approve",
+		Short: "Sign an approval for a plan file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			planFile, _ := cmd.Flags().GetString("plan")
+			approver, _ := cmd.Flags().GetString("approver")
+			envName, _ := cmd.Flags().GetString("env")
+			out, _ := cmd.Flags().GetString("out")
+
+			if envName == "" {
+				return fmt.Errorf("--env is required to approve a plan")
+			}
+			if approver == "" {
+				if u, err := user.Current(); err == nil {
+					approver = u.Username
+				}
+			}
+			if out == "" {
+				out = planFile + goliquify.APPROVAL_FILE_SUFFIX
+			}
+
+			plan, err := os.ReadFile(planFile)
+			if err != nil {
+				return err
+			}
+			approval := &goliquify.Approval{
+				PlanDigest:  goliquify.PlanDigest(string(plan)),
+				Environment: envName,
+				Approver:    approver,
+				ApprovedAt:  time.Now().UTC().Truncate(time.Second),
+			}
+			if err := goliquify.WriteApproval(out, approval); err != nil {
+				return err
+			}
+			fmt.Printf("Approval for %s in %s written to %s\n", planFile, envName, out)
+			return nil
+		},
+	}
+	cmd.Flags().String("plan", "plan.sql", "Plan file to approve")
+	cmd.Flags().String("approver", "", "Name of the approver (defaults to the current user)")
+	cmd.Flags().String("out", "", "Approval file to write (defaults to the plan file with .approval appended)")
+	return cmd
+}
+
+func newApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply [-- liquibase args]",
+		Short: "Apply a plan, requiring an approval for protected environments",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			planFile, _ := cmd.Flags().GetString("plan")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+
+			approved, err := os.ReadFile(planFile)
+			if err != nil {
+				return err
+			}
+
+			// Re-plan so we only apply what was reviewed
+			current, err := pl.Output(append([]string{"update-sql"}, args...)...)
+			if err != nil {
+				return err
+			}
+			if goliquify.PlanDigest(current) != goliquify.PlanDigest(string(approved)) {
+				return fmt.Errorf("the pending changes no longer match %s, create and review a new plan", planFile)
+			}
+
+			// Protected environments check the approval before updating
+			if pl.Approval == "" && fileExists(planFile+goliquify.APPROVAL_FILE_SUFFIX) {
+				pl.Approval = planFile + goliquify.APPROVAL_FILE_SUFFIX
+			}
+			return pl.Execute(append([]string{"update"}, args...)...)
+		},
+	}
+	cmd.Flags().String("plan", "plan.sql", "Plan file to apply")
+	cmd.Flags().Bool("require-approval", false, "Require an approved plan before applying to protected environments")
+	cmd.Flags().MarkDeprecated("require-approval", "protected environments always require an approval")
+	return cmd
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newPluginsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "plugins",
+		Short: "List the goliquify-plugin-* executables on the PATH",
+		Long: `Plugins are executables named goliquify-plugin-<name> on the PATH, enabled
+with --plugin <name> or plugins in the config. They run before every
+Liquibase command with the invocation as JSON on stdin, and may refuse it
+by failing or change its arguments by printing the invocation back. They
+run again after it, with its result.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			plugins := goliquify.ListPlugins()
+			if len(plugins) == 0 {
+				fmt.Printf("No %s* executables on the PATH\n", goliquify.PLUGIN_PREFIX)
+				return
+			}
+			names := make([]string, 0, len(plugins))
+			for name := range plugins {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("%-16s %s\n", name, plugins[name])
+			}
+		},
+	}
+}
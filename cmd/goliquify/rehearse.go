@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// Risk levels in increasing order
+var riskLevels = []string{goliquify.RISK_LOW, goliquify.RISK_MEDIUM, goliquify.RISK_HIGH}
+
+func newRehearseCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rehearse [-- liquibase args]",
+		Short: "Rehearse update on a clone of the database and report its risks",
+		Long: `Provision a throwaway clone of the database, apply the pending changesets
+to it and tear it down, reporting how long each changeset took, the table
+locks taken and the impact findings of the pending changesets. Clones are
+a dump restored into a docker container, an RDS snapshot restored to a new
+instance, or a Neon branch, configured per environment:
+
+  environments:
+    prod:
+      rehearsal:
+        clone:
+          provider: docker   # or rds, neon
+        slowThreshold: 30s
+        lockThreshold: 1s
+
+Docker and RDS clone the environment's latest backup unless --dump or
+--snapshot is given. Neon needs NEON_API_KEY. Timings and locks are
+recorded with goliquify's database drivers, see the drift command.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			provider, _ := cmd.Flags().GetString("clone")
+			dump, _ := cmd.Flags().GetString("dump")
+			snapshot, _ := cmd.Flags().GetString("snapshot")
+			keep, _ := cmd.Flags().GetBool("keep")
+			output, _ := cmd.Flags().GetString("output")
+			format, _ := cmd.Flags().GetString("format")
+			failOnRisk, _ := cmd.Flags().GetString("fail-on-risk")
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+			if failOnRisk != "" && failOnRisk != goliquify.RISK_MEDIUM && failOnRisk != goliquify.RISK_HIGH {
+				return fmt.Errorf("unknown risk %q, expecting medium or high", failOnRisk)
+			}
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			rehearsal := &goliquify.RehearsalConfig{}
+			if pl.Environment != "" {
+				env, err := cfg.Environment(pl.Environment)
+				if err != nil {
+					return err
+				}
+				if env.Rehearsal != nil {
+					rehearsal = env.Rehearsal
+				}
+			}
+			clone := rehearsal.Clone
+			if provider != "" {
+				clone.Provider = provider
+			}
+			if dump != "" {
+				clone.Dump = dump
+			}
+			if snapshot != "" {
+				clone.Snapshot = snapshot
+			}
+			if clone.Provider == "" {
+				return fmt.Errorf("no clone provider, pass --clone or configure the environment's rehearsal")
+			}
+			clones, err := pl.CloneProvider(&clone)
+			if err != nil {
+				return err
+			}
+
+			report, rehearsalErr := pl.Rehearse(context.Background(), goliquify.RehearsalOptions{
+				Clone:          clones,
+				Keep:           keep,
+				OpenDB:         openDatabase,
+				SlowThreshold:  rehearsal.SlowThreshold,
+				LockThreshold:  rehearsal.LockThreshold,
+				LargeTableRows: rehearsal.LargeTableRows,
+				Args:           args,
+			})
+			if report == nil {
+				return rehearsalErr
+			}
+			if output != "" {
+				if err := report.WriteJSON(output); err != nil {
+					return err
+				}
+			}
+			if format == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printRehearsal(report)
+			}
+			if rehearsalErr != nil {
+				return fmt.Errorf("update failed on the clone: %v", rehearsalErr)
+			}
+			if failOnRisk != "" && riskAtLeast(report.Risk, failOnRisk) {
+				return fmt.Errorf("rehearsal risk is %s", report.Risk)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("clone", "", "Clone provider: docker, rds or neon (default is the environment's)")
+	cmd.Flags().String("dump", "", "Dump to restore into the docker clone (default is the latest backup)")
+	cmd.Flags().String("snapshot", "", "RDS snapshot to clone (default is the latest backup)")
+	cmd.Flags().Bool("keep", false, "Keep the clone up after the rehearsal")
+	cmd.Flags().String("output", "", "Write the JSON report to this file")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	cmd.Flags().String("fail-on-risk", "", "Fail when the risk is at least this level: medium or high")
+	return cmd
+}
+
+// Check if a risk is at least a level
+func riskAtLeast(risk, level string) bool {
+	for _, r := range riskLevels {
+		if r == level {
+			return true
+		}
+		if r == risk {
+			return false
+		}
+	}
+	return false
+}
+
+func printRehearsal(report *goliquify.RehearsalReport) {
+	fmt.Printf("Rehearsal on %s %s in %s, risk %s\n", report.Clone, report.Status, time.Duration(report.DurationMs)*time.Millisecond, report.Risk)
+	if report.Error != "" {
+		fmt.Printf("  error: %s\n", report.Error)
+	}
+	fmt.Printf("%d pending changeset(s), %d applied, %d slow\n", report.Pending, len(report.Changesets), report.SlowCount)
+	for _, c := range report.Changesets {
+		slow := ""
+		if c.Slow {
+			slow = " (slow)"
+		}
+		fmt.Printf("  %s::%s::%s %dms%s\n", c.File, c.ID, c.Author, c.DurationMs, slow)
+	}
+	if len(report.Locks) > 0 {
+		fmt.Println("Locks:")
+		for _, l := range report.Locks {
+			blocking := ""
+			if l.Blocking {
+				blocking = " (blocking)"
+			}
+			fmt.Printf("  %s %s ~%dms%s\n", l.Table, l.Mode, l.HeldMs, blocking)
+		}
+	}
+	for _, f := range report.Findings {
+		fmt.Println(f.String())
+	}
+	for _, note := range report.Notes {
+		fmt.Printf("Note: %s\n", note)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/spf13/cobra"
+)
+
+func newReleaseCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "release",
+		Short: "Tag the database with the next semantic version after the latest deployed tag",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bump, _ := cmd.Flags().GetString("bump")
+			gitTag, _ := cmd.Flags().GetBool("git-tag")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+
+			// tag has no SQL variant, so a dry run only shows the next version
+			if pl.DryRun {
+				next, err := pl.NextRelease(bump)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Next release would be %s\n", next)
+				return nil
+			}
+
+			next, err := pl.Release(bump)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Released %s\n", next)
+
+			if gitTag {
+				git := exec.Command("git", "tag", "-a", next.String(), "-m", fmt.Sprintf("Release %s", next))
+				git.Stdout, git.Stderr = os.Stdout, os.Stderr
+				if err := git.Run(); err != nil {
+					return fmt.Errorf("database tagged %s but creating the git tag failed: %v", next, err)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("bump", "patch", "Version part to increment: major, minor or patch")
+	cmd.Flags().Bool("git-tag", false, "Also create a matching annotated git tag")
+	return cmd
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newRestoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore --run <id>",
+		Short: "Restore the backup taken before a run and sync the changelog to a tag",
+		Long: `Restore the pre-migration backup of a run from the journal, then run
+changelog-sync-to-tag so Liquibase's records match the restored database.
+pg_dump and mysqldump backups are restored into the database of the
+defaults file with pg_restore and mysql, Cloud SQL backups into their
+instance with gcloud. RDS snapshots are restored to a new instance with
+the aws CLI, --target-instance, and the changelog is synced there.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, _ := cmd.Flags().GetString("run")
+			tag, _ := cmd.Flags().GetString("tag")
+			targetInstance, _ := cmd.Flags().GetString("target-instance")
+			allowSchemaOnly, _ := cmd.Flags().GetBool("allow-schema-only")
+			yes, _ := cmd.Flags().GetBool("yes")
+			if runID == "" {
+				return fmt.Errorf("pass --run, the ID of the run whose backup to restore")
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			if !yes && !pl.DryRun && !promptRestore(runID, pl.Environment) {
+				return fmt.Errorf("restore not confirmed, pass --yes to restore without prompting")
+			}
+			return pl.Restore(context.Background(), goliquify.RestoreOptions{
+				RunID:           runID,
+				Tag:             tag,
+				TargetInstance:  targetInstance,
+				AllowSchemaOnly: allowSchemaOnly,
+			})
+		},
+	}
+	cmd.Flags().String("run", "", "Journal ID of the run whose backup to restore")
+	cmd.Flags().String("tag", "", "Tag to sync the changelog to after the restore")
+	cmd.Flags().String("target-instance", "", "RDS instance to restore the snapshot to (default <instance>-restored)")
+	cmd.Flags().Bool("allow-schema-only", false, "Restore a schema-only backup, recreating its tables empty")
+	cmd.Flags().Bool("yes", false, "Restore without prompting")
+	return cmd
+}
+
+func promptRestore(runID, environment string) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	target := "the database"
+	if environment != "" {
+		target = environment
+	}
+	fmt.Printf("Restoring the backup of run %s overwrites %s. Continue? [y/N] ", runID, target)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
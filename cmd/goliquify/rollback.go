@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newRollbackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback [-- liquibase args]",
+		Short: "Roll back the database, picking the point from its history with --interactive",
+		Long: `Without --interactive the arguments are passed to Liquibase rollback.
+
+With --interactive the latest tags and deployments are read from the
+deployment history and listed with the changesets rolling back to each one
+undoes. The rollback SQL of the picked point is previewed, and the rollback
+only runs once the number of changesets it undoes is typed back, so an
+incident doesn't end with rolling back too far.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			interactive, _ := cmd.Flags().GetBool("interactive")
+			limit, _ := cmd.Flags().GetInt("points")
+			dsn, _ := cmd.Flags().GetString("dsn")
+			dialect, _ := cmd.Flags().GetString("dialect")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if !interactive {
+				if err := pl.Initialize(); err != nil {
+					return err
+				}
+				return pl.Execute(append([]string{"rollback"}, args...)...)
+			}
+			if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+				return fmt.Errorf("--interactive needs a terminal")
+			}
+
+			if dsn == "" {
+				if dialect, dsn, err = pl.ReadOnlyDSN(); err != nil {
+					return fmt.Errorf("pass --dsn and --dialect, or a defaults file with a postgresql or mysql url: %v", err)
+				}
+			}
+			db, err := openDatabase(dialect, dsn)
+			if err != nil {
+				return err
+			}
+			history, err := goliquify.ReadHistory(context.Background(), db, pl.LiquibaseSchemaName)
+			db.Close()
+			if err != nil {
+				return err
+			}
+			points := goliquify.RollbackPoints(history, limit)
+			if len(points) == 0 {
+				return fmt.Errorf("the deployment history has no tags or deployments to roll back to")
+			}
+
+			input := bufio.NewReader(os.Stdin)
+			fmt.Println("Rollback points, the latest first:")
+			for i, p := range points {
+				fmt.Printf("  %2d  %s\n", i+1, p.String())
+			}
+			fmt.Printf("Roll back to which point? [1-%d, q to quit] ", len(points))
+			answer, _ := input.ReadString('\n')
+			n, err := strconv.Atoi(strings.TrimSpace(answer))
+			if err != nil || n < 1 || n > len(points) {
+				return fmt.Errorf("no rollback point picked")
+			}
+			point := points[n-1]
+
+			fmt.Printf("\nRolling back to %s undoes:\n", point.String())
+			for _, e := range point.ChangeSets {
+				fmt.Printf("  %s::%s::%s\n", e.File, e.ID, e.Author)
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			fmt.Printf("\nRollback SQL (%s):\n\n", strings.Join(point.Args(true), " "))
+			if err := pl.ExecuteWithOptions(context.Background(), goliquify.ExecOptions{}, append(point.Args(true), args...)...); err != nil {
+				return fmt.Errorf("failed to preview the rollback: %v", err)
+			}
+
+			target := pl.Environment
+			if target == "" {
+				target = "the database"
+			}
+			count := len(point.ChangeSets)
+			fmt.Printf("\nType %d to roll back %d changeset(s) on %s: ", count, count, target)
+			answer, _ = input.ReadString('\n')
+			if strings.TrimSpace(answer) != strconv.Itoa(count) {
+				return fmt.Errorf("rollback not confirmed")
+			}
+			return pl.Execute(append(point.Args(false), args...)...)
+		},
+	}
+	cmd.Flags().BoolP("interactive", "i", false, "Pick the rollback point from the deployment history, preview and confirm it")
+	cmd.Flags().Int("points", goliquify.DEFAULT_ROLLBACK_POINTS, "Rollback points listed with --interactive")
+	cmd.Flags().String("dsn", "", "Data source name of the database (default is the defaults file url)")
+	cmd.Flags().String("dialect", goliquify.DIALECT_POSTGRES, "Database dialect: postgres or mysql")
+	return cmd
+}
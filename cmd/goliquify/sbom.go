@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newSBOMCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sbom",
+		Short: "Write an SBOM of the Liquibase distribution, extensions, JDBC drivers and JRE",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			output, _ := cmd.Flags().GetString("output")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			components, err := pl.Toolchain()
+			if err != nil {
+				return err
+			}
+			var w io.Writer = os.Stdout
+			if output != "" {
+				file, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+			if err := goliquify.WriteSBOM(w, format, components); err != nil {
+				return err
+			}
+			if output != "" {
+				log.Printf("Wrote an SBOM of %d components to %s", len(components), output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("format", goliquify.SBOM_CYCLONEDX, "SBOM format: cyclonedx or spdx")
+	cmd.Flags().String("output", "", "File to write the SBOM to (default is stdout)")
+	return cmd
+}
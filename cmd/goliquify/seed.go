@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newSeedCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Load reference data from CSV/JSON fixtures",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dataDir, _ := cmd.Flags().GetString("data")
+			context, _ := cmd.Flags().GetString("context")
+			insertOnly, _ := cmd.Flags().GetBool("insert-only")
+			output, _ := cmd.Flags().GetString("output")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Only generate the changelog for review or to commit it
+			if output != "" {
+				seeds, err := goliquify.FindSeedFiles(dataDir)
+				if err != nil {
+					return err
+				}
+				if err := os.MkdirAll(output, 0755); err != nil {
+					return err
+				}
+				if err := goliquify.GenerateSeedChangelog(seeds, output, cfg.Seeds, !insertOnly); err != nil {
+					return err
+				}
+				fmt.Printf("Generated %s with %d seed changesets\n", goliquify.SEED_CHANGELOG_FILE, len(seeds))
+				return nil
+			}
+
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			return pl.Seed(dataDir, context, cfg.Seeds, !insertOnly)
+		},
+	}
+	cmd.Flags().String("data", "seeds", "Directory holding the CSV/JSON fixtures")
+	cmd.Flags().String("context", "", "Only load fixtures for this context (environment)")
+	cmd.Flags().Bool("insert-only", false, "Use loadData instead of loadUpdateData")
+	cmd.Flags().String("output", "", "Write the generated changelog and data to this directory instead of executing it")
+	return cmd
+}
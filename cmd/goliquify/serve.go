@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the deployment state of the environments over a REST API",
+		Long: `Serve a JSON API over the environments of the config file: their pending
+changesets, changelog lock, drift from the baseline snapshot and the recent
+runs of the journal. --ui also serves a web dashboard at /.
+
+Commands are triggered by callers with a role allowing them: viewers may
+run status, validate, diff and history, operators also updateSQL, update
+and rollbackSQL, admins anything including rollback and drop-all. Callers
+send a static token or an OpenID Connect ID token:
+
+  server:
+    tokens:
+      - name: ci
+        role: operator
+        env: GOLIQUIFY_CI_TOKEN   # or sha256: <hex digest of the token>
+    oidc:
+      issuer: https://login.example.com
+      audience: goliquify
+      roles:
+        dba: admin
+        developers: viewer
+
+The token of --token or GOLIQUIFY_SERVER_TOKEN has the admin role. With
+tokens or a provider every request needs one, without any the state is
+readable by anyone and commands are disabled. Triggered and refused
+commands are logged to .goliquify/api-audit.jsonl.
+
+Commands are queued as jobs, run one at a time per database and in
+parallel across databases. Their state and logs are kept in --jobs-dir, a
+restarted server resumes the queued ones and reruns the interrupted ones
+Liquibase picks up safely, e.g. update. An interrupted rollback or drop-all
+waits for POST /api/jobs/{id}/resume.
+
+Scheduled operations are queued as jobs when their cron expression fires:
+
+  server:
+    schedules:
+      - name: nightly-drift
+        cron: "0 2 * * *"
+        operation: drift            # fails when the schema drifted
+        environments: [prod]
+        jitter: 10m
+        notify: [https://hooks.example.com/dba]
+      - name: hourly-status
+        cron: "0 * * * *"
+        operation: export-status
+        output: reports/{env}-status.json
+
+Operations are drift, export-status or a command of the API. A run is
+skipped while the previous one is queued or running, failures are sent as
+failed events to the webhooks of the config and of the schedule.
+
+Deployment state and drift are read with goliquify's database drivers, see
+the drift command. The server listens on localhost unless --addr says
+otherwise, put it behind TLS when exposing it.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, _ := cmd.Flags().GetString("addr")
+			token, _ := cmd.Flags().GetString("token")
+			ui, _ := cmd.Flags().GetBool("ui")
+			audit, _ := cmd.Flags().GetString("audit")
+			jobsDir, _ := cmd.Flags().GetString("jobs-dir")
+			if token == "" {
+				token = os.Getenv(goliquify.SERVER_TOKEN_ENV)
+			}
+			if audit == "" {
+				audit = goliquify.DEFAULT_API_AUDIT_FILE
+			}
+
+			environments, cfg, err := environmentInstances(cmd)
+			if err != nil {
+				return err
+			}
+			auth, err := goliquify.NewAuthenticator(cfg.Server, token)
+			if err != nil {
+				return err
+			}
+			if cfg.Server != nil && cfg.Server.Audit != "" && !cmd.Flags().Changed("audit") {
+				audit = cfg.Server.Audit
+			}
+			if audit == "off" {
+				audit = ""
+			}
+			var schedules []goliquify.ScheduledOperation
+			if cfg.Server != nil {
+				schedules = cfg.Server.Schedules
+			}
+			server, err := goliquify.NewServer(goliquify.ServerOptions{
+				Environments: environments,
+				OpenDB:       openDatabase,
+				Drift: func(ctx context.Context, pl *goliquify.GoLiquibase) ([]goliquify.SchemaDifference, error) {
+					if cfg.Baseline == nil || cfg.Baseline.Snapshot == "" {
+						return nil, fmt.Errorf("no baseline snapshot to compare against")
+					}
+					dialect, dsn, err := pl.ReadOnlyDSN()
+					if err != nil {
+						return nil, err
+					}
+					schema := pl.DefaultSchemaName
+					if schema == "" && dialect == goliquify.DIALECT_POSTGRES {
+						schema = "public"
+					}
+					actual, err := introspect(ctx, dialect, dsn, schema)
+					if err != nil {
+						return nil, err
+					}
+					expected, err := goliquify.ReadSnapshot(cfg.Baseline.Snapshot)
+					if err != nil {
+						return nil, err
+					}
+					return goliquify.DiffSchemas(expected, actual), nil
+				},
+				Auth:      auth,
+				AuditFile: audit,
+				JobsDir:   jobsDir,
+				Schedules: schedules,
+				UI:        ui,
+			})
+			if err != nil {
+				return err
+			}
+			if len(schedules) > 0 {
+				log.Printf("Running %d scheduled operation(s)", len(schedules))
+				go server.RunSchedules(context.Background())
+			}
+			if !auth.Enabled() {
+				log.Printf("No tokens, commands can't be triggered through the API")
+			}
+			log.Printf("Serving %d environment(s) on http://%s", len(environments), addr)
+			return http.ListenAndServe(addr, server.Handler())
+		},
+	}
+	cmd.Flags().String("addr", goliquify.DEFAULT_SERVER_ADDR, "Address to listen on")
+	cmd.Flags().String("token", "", "Token with the admin role (default is $GOLIQUIFY_SERVER_TOKEN)")
+	cmd.Flags().String("audit", "", "Audit log of API triggered commands, 'off' to disable (default .goliquify/api-audit.jsonl)")
+	cmd.Flags().String("jobs-dir", goliquify.DEFAULT_JOBS_DIR, "Directory holding the state and logs of queued commands")
+	cmd.Flags().Bool("ui", false, "Serve the web dashboard at /")
+	return cmd
+}
+
+// Build the GoLiquibase instance of every environment of the config, or of
+// the defaults file alone when there are none
+func environmentInstances(cmd *cobra.Command) (map[string]*goliquify.GoLiquibase, *goliquify.Config, error) {
+	pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cfg.Environments) == 0 {
+		return map[string]*goliquify.GoLiquibase{"default": pl}, cfg, nil
+	}
+	environments := map[string]*goliquify.GoLiquibase{}
+	for name := range cfg.Environments {
+		if err := cmd.Flags().Set("env", name); err != nil {
+			return nil, nil, err
+		}
+		if environments[name], _, err = newGoLiquibaseFromFlags(cmd); err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %v", name, err)
+		}
+	}
+	return environments, cfg, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newSnippetsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snippets",
+		Short: "Render reusable changesets from the snippet library",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the snippets and their parameters",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range goliquify.SnippetNames() {
+				snippet := goliquify.SNIPPETS[name]
+				fmt.Printf("%-20s %s\n", name, snippet.Description)
+				for _, param := range snippet.Params {
+					detail := "required"
+					if !param.Required {
+						detail = "default " + param.Default
+					}
+					fmt.Printf("  %-18s %s (%s)\n", param.Name, param.Description, detail)
+				}
+			}
+		},
+	}
+	render := &cobra.Command{
+		Use:   "render <snippet> [param=value]...",
+		Short: "Render a snippet as a changelog in the project's format",
+		Long: `Render a snippet as a changelog holding its changeset. The format is the
+root changelog's, xml, yaml, json or formatted sql, and the database the
+one of the defaults file url, unless --format and --dbms are given:
+
+  goliquify snippets render soft-delete table=orders --output db/changes/`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			dbms, _ := cmd.Flags().GetString("dbms")
+			id, _ := cmd.Flags().GetString("id")
+			author, _ := cmd.Flags().GetString("author")
+			output, _ := cmd.Flags().GetString("output")
+
+			values := map[string]string{}
+			for _, arg := range args[1:] {
+				key, value, ok := strings.Cut(arg, "=")
+				if !ok {
+					return fmt.Errorf("invalid parameter %q, expecting name=value", arg)
+				}
+				values[key] = value
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if format == "" {
+				changelogFile, _ := pl.ChangelogLocation()
+				format = goliquify.ChangelogFormat(changelogFile)
+			}
+			if dbms == "" {
+				dialect, _, _ := pl.DatabaseDSN()
+				dbms = goliquify.DialectDBMS(dialect)
+			}
+
+			data, err := goliquify.RenderSnippet(args[0], goliquify.SnippetOptions{
+				Values: values,
+				Format: format,
+				DBMS:   dbms,
+				ID:     id,
+				Author: author,
+			})
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			// A directory gets a changelog named after the snippet and table
+			if info, err := os.Stat(output); (err == nil && info.IsDir()) || strings.HasSuffix(output, "/") {
+				name := args[0] + "-" + strings.ReplaceAll(values["table"], ".", "-")
+				if id != "" {
+					name = id
+				}
+				if err := os.MkdirAll(output, 0755); err != nil {
+					return err
+				}
+				output = filepath.Join(output, name+"."+format)
+			}
+			if _, err := os.Stat(output); err == nil {
+				return fmt.Errorf("%s already exists", output)
+			}
+			if err := os.WriteFile(output, data, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", output)
+			return nil
+		},
+	}
+	render.Flags().String("format", "", "Changelog format: xml, yaml, json or sql (default is the root changelog's)")
+	render.Flags().String("dbms", "", "Database: postgresql or mysql (default is the defaults file url's)")
+	render.Flags().String("id", "", "Changeset id (default is the snippet name and table)")
+	render.Flags().String("author", goliquify.DEFAULT_SNIPPET_AUTHOR, "Changeset author")
+	render.Flags().String("output", "", "Write the changelog to this file or directory instead of stdout")
+	cmd.AddCommand(list, render)
+	return cmd
+}
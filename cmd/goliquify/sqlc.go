@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newSqlcCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sqlc",
+		Short: "Write the schema of the whole changelog as one SQL file for sqlc",
+		Long: `Render the DDL of every changeset with update-sql against a Liquibase
+offline connection, so no database is needed, and write it as one
+schema file for sqlc:
+
+  # sqlc.yaml
+  sql:
+    - engine: postgresql
+      schema: db/schema.sql
+      queries: db/queries
+
+The Liquibase tracking tables are left out. Regenerate it whenever the
+changelog changes, e.g. in the same CI job as sqlc generate. For GORM
+models, see codegen --format gorm.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			database, _ := cmd.Flags().GetString("database")
+			output, _ := cmd.Flags().GetString("output")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := pl.Initialize(); err != nil {
+				return err
+			}
+			schema, err := pl.ConsolidatedSchema(context.Background(), database)
+			if err != nil {
+				return err
+			}
+			if output == "-" {
+				fmt.Print(schema)
+				return nil
+			}
+			if err := os.WriteFile(output, []byte(schema), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Schema written to %s\n", output)
+			return nil
+		},
+	}
+	cmd.Flags().String("database", "postgresql", "Liquibase offline database, e.g. postgresql, mysql, mssql or oracle")
+	cmd.Flags().String("output", "schema.sql", "File to write, - for stdout")
+	return cmd
+}
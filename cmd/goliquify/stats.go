@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newStatsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Report changeset counts, rollback coverage, raw SQL sizes and growth of the changelog",
+		Long: `Count the changesets of the changelog by author, change type and context,
+report the percentage of changesets that can be rolled back and the largest
+raw SQL changesets, and, from git blame of the changelog files, how many
+changesets were added each month. Run it now and then to keep the changelog
+in shape, it needs no JVM and no database.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			top, _ := cmd.Flags().GetInt("top")
+			noGit, _ := cmd.Flags().GetBool("no-git")
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expecting text or json", format)
+			}
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			changelog, searchPath := pl.ChangelogLocation()
+			if changelog == "" {
+				return fmt.Errorf("no changelog file to report on")
+			}
+			tree, err := goliquify.LoadChangelogTree(changelog, searchPath)
+			if err != nil {
+				return err
+			}
+			stats, err := goliquify.ComputeStats(context.Background(), tree, goliquify.StatsOptions{Top: top, Git: !noGit})
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(stats)
+			}
+			goliquify.PrintStats(os.Stdout, stats)
+			return nil
+		},
+	}
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	cmd.Flags().Int("top", goliquify.DEFAULT_STATS_TOP, "Number of largest raw SQL changesets to list")
+	cmd.Flags().Bool("no-git", false, "Skip the growth over time read from git history")
+	return cmd
+}
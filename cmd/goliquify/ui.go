@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+const (
+	// ANSI sequences styling the dashboard
+	ansiBold  = "\033[1m"
+	ansiDim   = "\033[2m"
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+	ansiReset = "\033[0m"
+
+	uiPendingRows = 15
+	uiHistoryRows = 8
+	uiRunRows     = 5
+	// Lines of command output kept for the log pane
+	uiLogLines = 1000
+)
+
+// dashboard is the state of an environment shown by the ui command
+type dashboard struct {
+	pending []string
+	applied []goliquify.AppliedChangeSet
+	lock    *goliquify.ChangelogLock
+	runs    []goliquify.RunRecord
+	errors  []string
+}
+
+// Messages of the console program
+type (
+	dashboardMsg struct {
+		environment string
+		dashboard   dashboard
+	}
+	logMsg  string
+	doneMsg struct {
+		command string
+		err     error
+	}
+	tickMsg time.Time
+)
+
+// console is the bubbletea model of the ui command
+type console struct {
+	cmd          *cobra.Command
+	pl           *goliquify.GoLiquibase
+	environments []string
+	dashboard    dashboard
+	loading      bool
+	message      string
+	confirming   bool
+
+	// The running command, its output and how to stop it
+	running string
+	output  chan tea.Msg
+	cancel  context.CancelFunc
+	log     []string
+
+	width, height int
+}
+
+func newUICmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ui",
+		Short: "Operator console showing environments, pending changesets, history and lock state",
+		Long: `An interactive console over the environments of the config file. It shows
+the selected environment's pending changesets, latest deployments, changelog
+lock and recent runs, and runs status, updateSQL and update on it with their
+logs streamed live below the dashboard. Keys act immediately:
+
+  1-9, up/down  select an environment     s  status
+  r             refresh                   p  updateSQL (preview)
+  q             quit                      u  update, after confirming with y
+
+Deployments and locks are read with goliquify's database drivers, see the
+drift command. Ctrl-C or Esc stops a running command.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+				return fmt.Errorf("the console needs a terminal")
+			}
+			_, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			m := &console{cmd: cmd}
+			for name := range cfg.Environments {
+				m.environments = append(m.environments, name)
+			}
+			sort.Strings(m.environments)
+			if env, _ := cmd.Flags().GetString("env"); env == "" && len(m.environments) > 0 {
+				cmd.Flags().Set("env", m.environments[0])
+			}
+			if m.pl, _, err = newGoLiquibaseFromFlags(cmd); err != nil {
+				return err
+			}
+			_, err = tea.NewProgram(m, tea.WithAltScreen()).Run()
+			return err
+		},
+	}
+}
+
+func (m *console) Init() tea.Cmd {
+	return tea.Batch(m.refresh(), tick())
+}
+
+// Redraw every second for the clock
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+func (m *console) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tickMsg:
+		return m, tick()
+	case dashboardMsg:
+		// Ignore a load of an environment that is no longer selected
+		if msg.environment == m.pl.Environment {
+			m.dashboard, m.loading = msg.dashboard, false
+		}
+	case logMsg:
+		m.log = append(m.log, string(msg))
+		if len(m.log) > uiLogLines {
+			m.log = m.log[len(m.log)-uiLogLines:]
+		}
+		return m, m.waitForOutput()
+	case doneMsg:
+		if msg.err != nil {
+			m.message = fmt.Sprintf("%s%s failed: %v%s", ansiRed, msg.command, msg.err, ansiReset)
+		} else {
+			m.message = fmt.Sprintf("%s%s completed%s", ansiGreen, msg.command, ansiReset)
+		}
+		m.running, m.output, m.cancel = "", nil, nil
+		return m, m.refresh()
+	case tea.KeyMsg:
+		return m.key(msg)
+	}
+	return m, nil
+}
+
+func (m *console) key(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+	if m.running != "" {
+		if key == "ctrl+c" || key == "esc" {
+			m.cancel()
+			m.message = "Stopping " + m.running
+		}
+		return m, nil
+	}
+	if m.confirming {
+		m.confirming = false
+		if key == "y" || key == "Y" {
+			return m, m.run("update")
+		}
+		m.message = "Update cancelled"
+		return m, nil
+	}
+
+	m.message = ""
+	switch key {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "r":
+		return m, m.refresh()
+	case "s":
+		return m, m.run("status", "--verbose")
+	case "p":
+		return m, m.run("updateSQL")
+	case "u":
+		m.confirming = true
+	case "up", "k":
+		return m, m.selectEnvironment(m.selected() - 1)
+	case "down", "j":
+		return m, m.selectEnvironment(m.selected() + 1)
+	default:
+		if len(key) == 1 && key[0] >= '1' && key[0] <= '9' {
+			return m, m.selectEnvironment(int(key[0] - '1'))
+		}
+	}
+	return m, nil
+}
+
+// The index of the selected environment, -1 without environments
+func (m *console) selected() int {
+	for i, name := range m.environments {
+		if name == m.pl.Environment {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *console) selectEnvironment(i int) tea.Cmd {
+	if i < 0 || i >= len(m.environments) || i == m.selected() {
+		return nil
+	}
+	m.cmd.Flags().Set("env", m.environments[i])
+	pl, _, err := newGoLiquibaseFromFlags(m.cmd)
+	if err != nil {
+		m.message = ansiRed + err.Error() + ansiReset
+		return nil
+	}
+	m.pl, m.log = pl, nil
+	return m.refresh()
+}
+
+// Load the dashboard of the selected environment in the background
+func (m *console) refresh() tea.Cmd {
+	m.loading = true
+	pl := m.pl
+	return func() tea.Msg {
+		return dashboardMsg{environment: pl.Environment, dashboard: loadDashboard(pl)}
+	}
+}
+
+// Start a command, streaming its output and logs into the log pane
+func (m *console) run(command string, args ...string) tea.Cmd {
+	pl, _, err := newGoLiquibaseFromFlags(m.cmd)
+	if err != nil {
+		m.message = ansiRed + err.Error() + ansiReset
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	output := make(chan tea.Msg, 64)
+	m.running, m.output, m.cancel = command, output, cancel
+	m.log = []string{ansiBold + strings.Join(append([]string{command}, args...), " ") + ansiReset}
+
+	stdout, stderr := &lineWriter{out: output}, &lineWriter{out: output}
+	pl.Logger = log.New(stderr, "", log.LstdFlags)
+	go func() {
+		defer cancel()
+		err := pl.Initialize()
+		if err == nil {
+			err = pl.ExecuteWithOptions(ctx, goliquify.ExecOptions{Stdout: stdout, Stderr: stderr}, append([]string{command}, args...)...)
+		}
+		stdout.Flush()
+		stderr.Flush()
+		output <- doneMsg{command: command, err: err}
+		close(output)
+	}()
+	return m.waitForOutput()
+}
+
+func (m *console) waitForOutput() tea.Cmd {
+	output := m.output
+	if output == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		msg, ok := <-output
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+func (m *console) View() string {
+	var b strings.Builder
+	d := m.dashboard
+	fmt.Fprintf(&b, "%sGoLiquify%s  %s%s%s", ansiBold, ansiReset, ansiDim, time.Now().Format("15:04:05"), ansiReset)
+	if m.loading {
+		b.WriteString("  " + ansiDim + "loading..." + ansiReset)
+	}
+	b.WriteString("\n\n")
+
+	b.WriteString(ansiBold + "Environments" + ansiReset + "\n")
+	if len(m.environments) == 0 {
+		fmt.Fprintf(&b, "  %s (no environments configured)\n", m.pl.DefaultsFile)
+	}
+	for i, name := range m.environments {
+		marker := " "
+		if name == m.pl.Environment {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, " %s %d %s\n", marker, i+1, name)
+	}
+
+	b.WriteString("\n" + ansiBold + "Lock" + ansiReset + "\n")
+	switch {
+	case d.lock == nil:
+		b.WriteString("  unknown\n")
+	case d.lock.Locked:
+		fmt.Fprintf(&b, "  %slocked%s by %s since %s\n", ansiRed, ansiReset, d.lock.LockedBy, d.lock.Granted)
+	default:
+		fmt.Fprintf(&b, "  %sfree%s\n", ansiGreen, ansiReset)
+	}
+
+	fmt.Fprintf(&b, "\n%sPending changesets (%d)%s\n", ansiBold, len(d.pending), ansiReset)
+	for i, key := range d.pending {
+		if i == uiPendingRows {
+			fmt.Fprintf(&b, "  ... %d more\n", len(d.pending)-i)
+			break
+		}
+		fmt.Fprintf(&b, "  %s\n", key)
+	}
+
+	fmt.Fprintf(&b, "\n%sLatest deployments (%d applied)%s\n", ansiBold, len(d.applied), ansiReset)
+	for i := len(d.applied) - 1; i >= 0 && i >= len(d.applied)-uiHistoryRows; i-- {
+		a := d.applied[i]
+		tag := ""
+		if a.Tag != "" {
+			tag = " [" + a.Tag + "]"
+		}
+		fmt.Fprintf(&b, "  %s::%s::%s%s\n", a.File, a.ID, a.Author, tag)
+	}
+
+	b.WriteString("\n" + ansiBold + "Recent runs" + ansiReset + "\n")
+	for _, run := range d.runs {
+		status := ansiGreen + run.Status + ansiReset
+		if run.Status != goliquify.EVENT_COMPLETED {
+			status = ansiRed + run.Status + ansiReset
+		}
+		fmt.Fprintf(&b, "  %s %-22s %s %s\n", run.Start.Local().Format("2006-01-02 15:04"), run.Command, status, run.Error)
+	}
+	for _, e := range d.errors {
+		fmt.Fprintf(&b, "\n%s%s%s", ansiRed, e, ansiReset)
+	}
+
+	// The log pane takes the rows left below the dashboard
+	if len(m.log) > 0 {
+		b.WriteString("\n\n")
+		rows := m.height - strings.Count(b.String(), "\n") - 4
+		if rows < 5 {
+			rows = 5
+		}
+		lines := m.log
+		if len(lines) > rows {
+			lines = lines[len(lines)-rows:]
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+	}
+
+	b.WriteString("\n\n")
+	switch {
+	case m.confirming:
+		target := m.pl.Environment
+		if target == "" {
+			target = m.pl.DefaultsFile
+		}
+		fmt.Fprintf(&b, "Run update on %s? [y/N]", target)
+	case m.running != "" && m.message != "":
+		b.WriteString(m.message)
+	case m.running != "":
+		fmt.Fprintf(&b, "Running %s, [ctrl+c] stop", m.running)
+	default:
+		if m.message != "" {
+			b.WriteString(m.message + "\n")
+		}
+		b.WriteString("[1-9] environment  [s]tatus  [p]review updateSQL  [u]pdate  [r]efresh  [q]uit")
+	}
+	return b.String()
+}
+
+// lineWriter sends the complete lines written to it as log messages
+type lineWriter struct {
+	mu      sync.Mutex
+	out     chan<- tea.Msg
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.out <- logMsg(strings.TrimRight(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// Send the last line when it has no newline
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.out <- logMsg(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// Read the state of the selected environment. Failures are shown on the
+// dashboard rather than ending the console.
+func loadDashboard(pl *goliquify.GoLiquibase) dashboard {
+	var d dashboard
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if journal, err := goliquify.ReadJournal(pl.JournalFile); err == nil {
+		for i := len(journal) - 1; i >= 0 && len(d.runs) < uiRunRows; i-- {
+			if pl.Environment == "" || journal[i].Environment == pl.Environment {
+				d.runs = append(d.runs, journal[i])
+			}
+		}
+	}
+
+	dialect, dsn, err := pl.ReadOnlyDSN()
+	if err != nil {
+		d.errors = append(d.errors, err.Error())
+		return d
+	}
+	db, err := openDatabase(dialect, dsn)
+	if err != nil {
+		d.errors = append(d.errors, err.Error())
+		return d
+	}
+	defer db.Close()
+	if d.lock, err = goliquify.ReadChangelogLock(ctx, db, pl.LiquibaseSchemaName); err != nil {
+		d.errors = append(d.errors, err.Error())
+	}
+	if d.applied, err = goliquify.ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName); err != nil {
+		d.errors = append(d.errors, err.Error())
+		return d
+	}
+	changelog, searchPath := pl.ChangelogLocation()
+	if changelog == "" {
+		d.errors = append(d.errors, "no changelog file in the defaults file")
+		return d
+	}
+	tree, err := goliquify.LoadChangelogTree(changelog, searchPath)
+	if err != nil {
+		d.errors = append(d.errors, err.Error())
+		return d
+	}
+	for _, cs := range goliquify.PendingChangeSets(tree, d.applied) {
+		d.pending = append(d.pending, cs.Key())
+	}
+	return d
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newUpdateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update [-- liquibase args]",
+		Short: "Update the database, every schema given with --schemas, or every discovered target with --all",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, _ := cmd.Flags().GetBool("all")
+			root, _ := cmd.Flags().GetString("root")
+			orderFile, _ := cmd.Flags().GetString("order")
+			report, _ := cmd.Flags().GetString("report")
+			phase, _ := cmd.Flags().GetString("phase")
+			appVersionTag, _ := cmd.Flags().GetString("app-version-tag")
+			rollbackOnError, _ := cmd.Flags().GetBool("rollback-on-error")
+			toCommit, _ := cmd.Flags().GetString("to-commit")
+			canaryCount, _ := cmd.Flags().GetInt("canary")
+			bake, _ := cmd.Flags().GetDuration("bake")
+			healthCheck, _ := cmd.Flags().GetString("health-check")
+			rollout := goliquify.RolloutOptions{}
+			rollout.StateFile, _ = cmd.Flags().GetString("state-file")
+			rollout.Resume, _ = cmd.Flags().GetBool("resume")
+			rollout.RetryFailed, _ = cmd.Flags().GetBool("retry-failed")
+
+			pl, cfg, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			var phaseArgs []string
+			if phase != "" {
+				if phaseArgs, err = goliquify.PhaseArgs(cfg.Phases, phase); err != nil {
+					return err
+				}
+				if appVersionTag == "" {
+					appVersionTag = cfg.Phases.ContractRequiresTag
+				}
+			}
+
+			if toCommit != "" && (all || phase != "" || len(pl.Schemas) > 0) {
+				return fmt.Errorf("--to-commit can't be combined with --all, --phase or --schemas")
+			}
+			canary := goliquify.CanaryOptions{CanaryConfig: cfg.Canary, Count: canaryCount, Bake: bake}
+			if healthCheck != "" {
+				canary.HealthCheck = healthCheck
+			}
+			if canaryCount > 0 && !all && len(pl.Schemas) == 0 {
+				return fmt.Errorf("--canary needs a fleet to roll out to, pass --all or --schemas")
+			}
+			if (rollout.Resume || rollout.RetryFailed) && !all {
+				return fmt.Errorf("--resume and --retry-failed resume an update --all")
+			}
+
+			if !all {
+				if err := pl.Initialize(); err != nil {
+					return err
+				}
+				if phase == goliquify.PHASE_CONTRACT {
+					if err := pl.CheckContractAllowed(appVersionTag); err != nil {
+						return err
+					}
+				}
+				if len(pl.Schemas) > 0 && rollbackOnError {
+					return fmt.Errorf("--rollback-on-error can't be combined with --schemas")
+				}
+				if len(pl.Schemas) > 0 {
+					return reportTargetResults(pl.CanarySchemas(context.Background(), canary, nil, "update", append(phaseArgs, args...)...), report)
+				}
+				opts := goliquify.UpdateOptions{
+					RollbackOnError: rollbackOnError,
+					Args:            append(phaseArgs, args...),
+				}
+				if toCommit != "" {
+					if opts.Count, err = countToCommit(pl, toCommit, args); err != nil {
+						return err
+					}
+					if opts.Count == 0 {
+						log.Printf("Every changeset of %s is deployed", toCommit)
+						return nil
+					}
+					log.Printf("Deploying the %d pending changeset(s) that existed at %s", opts.Count, toCommit)
+				}
+				if err := pl.UpdateWith(opts); err != nil {
+					return err
+				}
+				publishSchema(pl)
+				return nil
+			}
+			if rollbackOnError {
+				return fmt.Errorf("--rollback-on-error can't be combined with --all")
+			}
+
+			targets, err := goliquify.LoadOrderedTargets(root, orderFile, cfg)
+			if err != nil {
+				return err
+			}
+			if phase == goliquify.PHASE_CONTRACT {
+				for _, t := range targets {
+					tpl, err := pl.ForTarget(t)
+					if err != nil {
+						return err
+					}
+					if err := tpl.CheckContractAllowed(appVersionTag); err != nil {
+						return fmt.Errorf("%s: %v", t.Name, err)
+					}
+				}
+			}
+			results, err := pl.RolloutTargets(context.Background(), rollout, canary, targets, "update", append(phaseArgs, args...)...)
+			if err != nil {
+				return err
+			}
+			return reportTargetResults(results, report)
+		},
+	}
+	cmd.Flags().Bool("all", false, "Update every discovered target in dependency order")
+	cmd.Flags().String("root", ".", "Root directory to discover targets in with --all")
+	cmd.Flags().String("order", "", "Dependency ordering file (defaults to goliquify.order in the root)")
+	cmd.Flags().String("report", "", "Write the consolidated report as JSON to this file")
+	cmd.Flags().String("phase", "", "Only apply changesets of a rollout phase: expand, migrate or contract")
+	cmd.Flags().String("app-version-tag", "", "Tag marking the app version deployed before contract changesets may run")
+	cmd.Flags().String("to-commit", "", "Only deploy the changesets that existed at this git revision, so the schema matches a build of it")
+	cmd.Flags().Int("canary", 0, "With --all or --schemas, update this many targets first and only continue once they are healthy")
+	cmd.Flags().Duration("bake", 0, "How long the canaries are health checked before the other targets are updated")
+	cmd.Flags().String("health-check", "", "URL answering 2xx or shell command exiting 0 while the canaries are healthy (default is canary.healthCheck of the config)")
+	cmd.Flags().Bool("resume", false, "Resume an interrupted update --all, only updating the targets that didn't succeed or fail yet")
+	cmd.Flags().Bool("retry-failed", false, "Resume an update --all, updating the targets that failed again too")
+	cmd.Flags().String("state-file", goliquify.DEFAULT_ROLLOUT_STATE_FILE, "File the progress of update --all is kept in for --resume")
+	cmd.Flags().Bool("rollback-on-error", false, "Roll back the changesets the update deployed when one fails, emulated before Liquibase Pro 4.26")
+	return cmd
+}
+
+// Count the pending changesets that existed at a git revision, reading the
+// applied ones from the database
+func countToCommit(pl *goliquify.GoLiquibase, revision string, args []string) (int, error) {
+	dialect, dsn, err := pl.ReadOnlyDSN()
+	if err != nil {
+		return 0, fmt.Errorf("--to-commit reads the deployment history and needs a defaults file with a postgresql or mysql url: %v", err)
+	}
+	db, err := openDatabase(dialect, dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	ctx := context.Background()
+	applied, err := goliquify.ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName)
+	if err != nil {
+		return 0, err
+	}
+	return pl.CountToCommit(ctx, revision, applied, args...)
+}
+
+// Publish the schema to the registry of the config, if any. The update
+// succeeded, so failing to publish is only logged.
+func publishSchema(pl *goliquify.GoLiquibase) {
+	if pl.SchemaRegistry == nil || pl.DryRun {
+		return
+	}
+	written, err := pl.PublishSchema(context.Background(), openDatabase)
+	if err != nil {
+		log.Printf("Failed to publish the schema: %v", err)
+		return
+	}
+	log.Printf("Schema published to %s", strings.Join(written, ", "))
+}
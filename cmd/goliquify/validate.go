@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate [-- liquibase args]",
+		Short: "Validate the changelog and report problems as file:line: message",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			since, _ := cmd.Flags().GetString("since")
+			offline, _ := cmd.Flags().GetBool("offline")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if offline && since == "" {
+				return fmt.Errorf("--offline needs --since, use lint to check changelogs without a database")
+			}
+			// Offline checks don't need a Liquibase install
+			if !offline {
+				if err := pl.Initialize(); err != nil {
+					return err
+				}
+			}
+			var diagnostics []goliquify.Diagnostic
+			if since != "" {
+				diagnostics, err = pl.ValidateSince(since, offline, args...)
+			} else {
+				diagnostics, err = pl.ValidateDiagnostics(args...)
+			}
+			if err != nil {
+				return err
+			}
+			err = reportDiagnostics(diagnostics, format)
+			if err != nil && len(diagnostics) > 0 && !goliquify.HasErrors(diagnostics) {
+				// Lint warnings on the changed changesets don't fail validation
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().String("format", "text", "Output format for problems: text or json")
+	cmd.Flags().String("since", "", "Only check the changesets added or modified since this git ref")
+	cmd.Flags().Bool("offline", false, "With --since, only lint the changed changesets without running Liquibase")
+	return cmd
+}
+
+// Print diagnostics and fail if there are any
+func reportDiagnostics(diagnostics []goliquify.Diagnostic, format string) error {
+	switch format {
+	case "json":
+		if diagnostics == nil {
+			diagnostics = []goliquify.Diagnostic{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diagnostics); err != nil {
+			return err
+		}
+	case "text":
+		goliquify.WriteDiagnostics(os.Stderr, diagnostics)
+	default:
+		return fmt.Errorf("unknown format %q, expecting text or json", format)
+	}
+	if len(diagnostics) > 0 {
+		return fmt.Errorf("found %d problem(s)", len(diagnostics))
+	}
+	return nil
+}
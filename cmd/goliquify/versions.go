@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func newVersionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "versions",
+		Short: "Show Liquibase releases",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the Liquibase releases available to install, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, _ := cmd.Flags().GetBool("all")
+			limit, _ := cmd.Flags().GetInt("limit")
+
+			pl, _, err := newGoLiquibaseFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			releases, err := pl.Releases(context.Background())
+			if err != nil {
+				return err
+			}
+			selected := pl.Version
+			if goliquify.IsVersionChannel(selected) {
+				selected, _ = goliquify.LatestRelease(releases, selected)
+			}
+			shown := 0
+			for _, r := range releases {
+				if r.Prerelease && !all {
+					continue
+				}
+				if limit > 0 && shown == limit {
+					break
+				}
+				shown++
+				var notes []string
+				if r.Prerelease {
+					notes = append(notes, "prerelease")
+				}
+				if r.Version == goliquify.DEFAULT_LIQUIBASE_VERSION {
+					notes = append(notes, "default")
+				}
+				if r.Version == selected {
+					notes = append(notes, "selected")
+				}
+				if pl.IsVersionCached(r.Version) {
+					notes = append(notes, "installed")
+				}
+				line := fmt.Sprintf("%-10s %s", r.Version, r.Published.Format("2006-01-02"))
+				if len(notes) > 0 {
+					line += fmt.Sprintf("  (%s)", strings.Join(notes, ", "))
+				}
+				fmt.Println(line)
+			}
+			return nil
+		},
+	}
+	list.Flags().Bool("all", false, "Include prereleases")
+	list.Flags().Int("limit", 20, "Number of releases to show, 0 for all")
+	cmd.AddCommand(list)
+	return cmd
+}
@@ -0,0 +1,390 @@
+package goliquify
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Code generation formats
+const (
+	CODEGEN_GO      = "go"
+	CODEGEN_SQL     = "sql"
+	CODEGEN_OPENAPI = "openapi"
+	CODEGEN_PROTO   = "proto"
+	CODEGEN_GORM    = "gorm"
+)
+
+var CODEGEN_FORMATS = []string{CODEGEN_GO, CODEGEN_SQL, CODEGEN_OPENAPI, CODEGEN_PROTO, CODEGEN_GORM}
+
+// CodegenOptions configure the generated code
+type CodegenOptions struct {
+	// Package of the Go file or of the protobuf messages, models by default
+	Package string
+	// Dialect of the generated SQL, postgres or mysql
+	Dialect string
+	// Tables to generate, every table when empty
+	Tables []string
+}
+
+// Initialisms kept upper case in Go names
+var goInitialisms = map[string]bool{"id": true, "url": true, "uri": true, "uuid": true, "api": true, "http": true, "ip": true, "json": true, "sql": true, "html": true, "sku": true}
+
+// GenerateCode generates Go structs, a sqlc schema, an OpenAPI components
+// section or protobuf messages for the tables of a schema model
+func GenerateCode(model *SchemaModel, format string, opts CodegenOptions) ([]byte, error) {
+	if opts.Package == "" {
+		opts.Package = "models"
+	}
+	var tables []*TableModel
+	for _, name := range model.TableNames() {
+		if len(opts.Tables) == 0 || containsFold(opts.Tables, name) {
+			tables = append(tables, model.Tables[name])
+		}
+	}
+	for _, name := range opts.Tables {
+		if _, ok := model.Tables[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("no table %s in the schema", name)
+		}
+	}
+	switch format {
+	case CODEGEN_GO:
+		return generateGo(tables, opts.Package, false)
+	case CODEGEN_GORM:
+		return generateGo(tables, opts.Package, true)
+	case CODEGEN_SQL:
+		return generateSchemaSQL(tables, opts.Dialect), nil
+	case CODEGEN_OPENAPI:
+		return generateOpenAPI(tables)
+	case CODEGEN_PROTO:
+		return generateProto(tables, opts.Package), nil
+	}
+	return nil, fmt.Errorf("unknown codegen format %q, expecting one of %s", format, strings.Join(CODEGEN_FORMATS, ", "))
+}
+
+// The type of a normalized column type without its size, and whether it is unsigned
+func baseType(t string) (string, bool) {
+	unsigned := strings.HasSuffix(t, " unsigned")
+	t = strings.TrimSuffix(t, " unsigned")
+	if i := strings.Index(t, "("); i >= 0 {
+		t = t[:i]
+	}
+	return strings.TrimSpace(t), unsigned
+}
+
+// Convert a snake_case name to an exported Go name, e.g. user_id to UserID
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' || r == '.' }) {
+		lower := strings.ToLower(part)
+		if goInitialisms[lower] {
+			b.WriteString(strings.ToUpper(lower))
+		} else {
+			b.WriteString(strings.ToUpper(lower[:1]) + lower[1:])
+		}
+	}
+	s := b.String()
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "X" + s
+	}
+	return s
+}
+
+// The Go type of a column, and the package it needs
+func goType(column *ColumnModel) (string, string) {
+	t, unsigned := baseType(column.Type)
+	var typ, pkg string
+	switch t {
+	case "tinyint":
+		typ = "int8"
+	case "smallint":
+		typ = "int16"
+	case "int", "mediumint", "serial":
+		typ = "int32"
+	case "bigint", "bigserial":
+		typ = "int64"
+	case "boolean":
+		typ = "bool"
+	case "real", "float":
+		typ = "float32"
+	case "double":
+		typ = "float64"
+	case "timestamp", "timestamptz", "date", "time", "timetz", "year":
+		typ, pkg = "time.Time", "time"
+	case "json", "jsonb":
+		typ, pkg = "json.RawMessage", "encoding/json"
+	case "bytea", "blob", "binary", "varbinary", "longblob", "mediumblob", "tinyblob":
+		return "[]byte", ""
+	default:
+		// decimal keeps its precision as a string
+		typ = "string"
+	}
+	if unsigned && strings.HasPrefix(typ, "int") {
+		typ = "u" + typ
+	}
+	if column.Nullable && pkg != "encoding/json" {
+		typ = "*" + typ
+	}
+	return typ, pkg
+}
+
+// Generate Go structs, with GORM tags and TableName methods for gorm
+func generateGo(tables []*TableModel, pkg string, gorm bool) ([]byte, error) {
+	var body bytes.Buffer
+	imports := map[string]bool{}
+	for _, table := range tables {
+		name := goName(table.Name)
+		fmt.Fprintf(&body, "\n// %s is a row of the %s table\ntype %s struct {\n", name, table.Name, name)
+		for _, column := range table.Columns {
+			typ, imp := goType(column)
+			if imp != "" {
+				imports[imp] = true
+			}
+			tag := fmt.Sprintf("db:\"%s\"", column.Name)
+			if gorm {
+				tag = fmt.Sprintf("gorm:\"%s\"", gormTag(table, column))
+			}
+			fmt.Fprintf(&body, "\t%s %s `%s json:\"%s\"`\n", goName(column.Name), typ, tag, column.Name)
+		}
+		body.WriteString("}\n")
+		if gorm {
+			fmt.Fprintf(&body, "\n// TableName maps %s to its table\nfunc (%s) TableName() string {\n\treturn %q\n}\n", name, name, table.Name)
+		}
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by goliquify codegen. DO NOT EDIT.\n\npackage %s\n", pkg)
+	if len(imports) > 0 {
+		out.WriteString("\nimport (\n")
+		var packages []string
+		for imp := range imports {
+			packages = append(packages, imp)
+		}
+		sort.Strings(packages)
+		for _, imp := range packages {
+			fmt.Fprintf(&out, "\t%q\n", imp)
+		}
+		out.WriteString(")\n")
+	}
+	out.Write(body.Bytes())
+	return format.Source(out.Bytes())
+}
+
+// The GORM tag of a column: its name, type, key, constraints and indexes
+func gormTag(table *TableModel, column *ColumnModel) string {
+	parts := []string{"column:" + column.Name, "type:" + column.Type}
+	if containsString(table.PrimaryKey, column.Name) {
+		parts = append(parts, "primaryKey")
+	} else if !column.Nullable {
+		parts = append(parts, "not null")
+	}
+	for _, index := range table.Indexes {
+		if !containsString(index.Columns, column.Name) {
+			continue
+		}
+		kind := "index"
+		if index.Unique {
+			kind = "uniqueIndex"
+		}
+		parts = append(parts, fmt.Sprintf("%s:%s,priority:%d", kind, index.Name, indexOf(index.Columns, column.Name)+1))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Order tables so that referenced tables come first, as far as the
+// foreign keys allow
+func dependencyOrder(tables []*TableModel) []*TableModel {
+	byName := map[string]*TableModel{}
+	for _, t := range tables {
+		byName[t.Name] = t
+	}
+	var ordered []*TableModel
+	state := map[string]int{}
+	var visit func(t *TableModel)
+	visit = func(t *TableModel) {
+		if state[t.Name] != 0 {
+			return
+		}
+		state[t.Name] = 1
+		for _, fk := range t.ForeignKeys {
+			if ref, ok := byName[fk.RefTable]; ok && ref != t {
+				visit(ref)
+			}
+		}
+		state[t.Name] = 2
+		ordered = append(ordered, t)
+	}
+	for _, t := range tables {
+		visit(t)
+	}
+	return ordered
+}
+
+// Generate CREATE statements sqlc reads as the schema of its queries
+func generateSchemaSQL(tables []*TableModel, dialect string) []byte {
+	var out bytes.Buffer
+	out.WriteString("-- Code generated by goliquify codegen. DO NOT EDIT.\n")
+	for _, table := range dependencyOrder(tables) {
+		var lines []string
+		for _, column := range table.Columns {
+			typ := column.Type
+			switch {
+			case typ == "double" && dialect != DIALECT_MYSQL:
+				typ = "double precision"
+			case typ == "timestamptz" && dialect == DIALECT_MYSQL:
+				typ = "timestamp"
+			}
+			line := fmt.Sprintf("  %s %s", column.Name, typ)
+			if !column.Nullable {
+				line += " NOT NULL"
+			}
+			lines = append(lines, line)
+		}
+		if len(table.PrimaryKey) > 0 {
+			lines = append(lines, fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(table.PrimaryKey, ", ")))
+		}
+		for _, fk := range table.ForeignKeys {
+			lines = append(lines, fmt.Sprintf("  CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)", fk.Name, strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", ")))
+		}
+		fmt.Fprintf(&out, "\nCREATE TABLE %s (\n%s\n);\n", table.Name, strings.Join(lines, ",\n"))
+		for _, index := range table.Indexes {
+			unique := ""
+			if index.Unique {
+				unique = "UNIQUE "
+			}
+			fmt.Fprintf(&out, "CREATE %sINDEX %s ON %s (%s);\n", unique, index.Name, table.Name, strings.Join(index.Columns, ", "))
+		}
+	}
+	return out.Bytes()
+}
+
+// The OpenAPI schema of a column
+func openAPIProperty(column *ColumnModel) map[string]any {
+	t, unsigned := baseType(column.Type)
+	property := map[string]any{}
+	switch t {
+	case "tinyint", "smallint", "int", "mediumint", "serial":
+		property["type"], property["format"] = "integer", "int32"
+	case "bigint", "bigserial":
+		property["type"], property["format"] = "integer", "int64"
+	case "boolean":
+		property["type"] = "boolean"
+	case "real", "float":
+		property["type"], property["format"] = "number", "float"
+	case "double":
+		property["type"], property["format"] = "number", "double"
+	case "decimal":
+		property["type"], property["format"] = "string", "decimal"
+	case "timestamp", "timestamptz":
+		property["type"], property["format"] = "string", "date-time"
+	case "date":
+		property["type"], property["format"] = "string", "date"
+	case "uuid":
+		property["type"], property["format"] = "string", "uuid"
+	case "json", "jsonb":
+		// Any JSON value
+	case "bytea", "blob", "binary", "varbinary", "longblob", "mediumblob", "tinyblob":
+		property["type"], property["format"] = "string", "byte"
+	default:
+		property["type"] = "string"
+		if m := typeSizePattern.FindStringSubmatch(column.Type); m != nil && m[2] != "" && (t == "varchar" || t == "char") {
+			var size int
+			if _, err := fmt.Sscanf(m[2], "%d", &size); err == nil {
+				property["maxLength"] = size
+			}
+		}
+	}
+	if unsigned {
+		property["minimum"] = 0
+	}
+	if column.Nullable {
+		property["nullable"] = true
+	}
+	return property
+}
+
+func generateOpenAPI(tables []*TableModel) ([]byte, error) {
+	schemas := map[string]any{}
+	for _, table := range tables {
+		properties := map[string]any{}
+		var required []string
+		for _, column := range table.Columns {
+			properties[column.Name] = openAPIProperty(column)
+			if !column.Nullable {
+				required = append(required, column.Name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		schemas[goName(table.Name)] = schema
+	}
+	var out bytes.Buffer
+	out.WriteString("# Code generated by goliquify codegen. DO NOT EDIT.\n")
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string]any{"components": map[string]any{"schemas": schemas}}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), encoder.Close()
+}
+
+// The protobuf type of a column
+func protoType(column *ColumnModel) string {
+	t, unsigned := baseType(column.Type)
+	switch t {
+	case "tinyint", "smallint", "int", "mediumint", "serial":
+		if unsigned {
+			return "uint32"
+		}
+		return "int32"
+	case "bigint", "bigserial":
+		if unsigned {
+			return "uint64"
+		}
+		return "int64"
+	case "boolean":
+		return "bool"
+	case "real", "float":
+		return "float"
+	case "double":
+		return "double"
+	case "timestamp", "timestamptz":
+		return "google.protobuf.Timestamp"
+	case "bytea", "blob", "binary", "varbinary", "longblob", "mediumblob", "tinyblob":
+		return "bytes"
+	}
+	return "string"
+}
+
+// Generate proto3 messages. Field numbers follow the column order, so
+// regenerating after reordering columns renumbers the fields.
+func generateProto(tables []*TableModel, pkg string) []byte {
+	var body bytes.Buffer
+	timestamps := false
+	for _, table := range tables {
+		fmt.Fprintf(&body, "\n// A row of the %s table\nmessage %s {\n", table.Name, goName(table.Name))
+		for i, column := range table.Columns {
+			typ := protoType(column)
+			timestamps = timestamps || strings.HasPrefix(typ, "google.")
+			optional := ""
+			if column.Nullable && !strings.HasPrefix(typ, "google.") {
+				optional = "optional "
+			}
+			fmt.Fprintf(&body, "  %s%s %s = %d;\n", optional, typ, strings.ToLower(column.Name), i+1)
+		}
+		body.WriteString("}\n")
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by goliquify codegen. DO NOT EDIT.\n\nsyntax = \"proto3\";\n\npackage %s;\n", pkg)
+	if timestamps {
+		out.WriteString("\nimport \"google/protobuf/timestamp.proto\";\n")
+	}
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
@@ -0,0 +1,251 @@
+package goliquify
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// First Liquibase version of the kebab-case CLI. Older versions only know the
+// camelCase names, newer ones still accept them but log deprecation warnings.
+const KEBAB_CASE_VERSION = "4.4.0"
+
+// camelCase command names and their kebab-case replacements
+var COMMAND_RENAMES = map[string]string{
+	"updateSQL":                "update-sql",
+	"updateCount":              "update-count",
+	"updateCountSQL":           "update-count-sql",
+	"updateToTag":              "update-to-tag",
+	"updateToTagSQL":           "update-to-tag-sql",
+	"updateTestingRollback":    "update-testing-rollback",
+	"rollbackSQL":              "rollback-sql",
+	"rollbackCount":            "rollback-count",
+	"rollbackCountSQL":         "rollback-count-sql",
+	"rollbackToDate":           "rollback-to-date",
+	"rollbackToDateSQL":        "rollback-to-date-sql",
+	"futureRollbackSQL":        "future-rollback-sql",
+	"futureRollbackCountSQL":   "future-rollback-count-sql",
+	"futureRollbackFromTagSQL": "future-rollback-from-tag-sql",
+	"changelogSync":            "changelog-sync",
+	"changelogSyncSQL":         "changelog-sync-sql",
+	"changelogSyncToTag":       "changelog-sync-to-tag",
+	"changelogSyncToTagSQL":    "changelog-sync-to-tag-sql",
+	"markNextChangeSetRan":     "mark-next-changeset-ran",
+	"markNextChangeSetRanSQL":  "mark-next-changeset-ran-sql",
+	"generateChangeLog":        "generate-changelog",
+	"diffChangeLog":            "diff-changelog",
+	"clearCheckSums":           "clear-checksums",
+	"calculateCheckSum":        "calculate-checksum",
+	"dropAll":                  "drop-all",
+	"releaseLocks":             "release-locks",
+	"listLocks":                "list-locks",
+	"tagExists":                "tag-exists",
+	"dbDoc":                    "db-doc",
+	"executeSql":               "execute-sql",
+	"registerChangeLog":        "register-changelog",
+	"deactivateChangeLog":      "deactivate-changelog",
+	"syncHub":                  "sync-hub",
+}
+
+// camelCase flag names and their kebab-case replacements
+var FLAG_RENAMES = map[string]string{
+	"defaultsFile":                   "defaults-file",
+	"changeLogFile":                  "changelog-file",
+	"classpath":                      "classpath",
+	"logLevel":                       "log-level",
+	"logFile":                        "log-file",
+	"searchPath":                     "search-path",
+	"hubMode":                        "hub-mode",
+	"hubApiKey":                      "hub-api-key",
+	"hubConnectionId":                "hub-connection-id",
+	"hubProjectId":                   "hub-project-id",
+	"hubProjectName":                 "hub-project-name",
+	"defaultSchemaName":              "default-schema-name",
+	"defaultCatalogName":             "default-catalog-name",
+	"liquibaseSchemaName":            "liquibase-schema-name",
+	"liquibaseCatalogName":           "liquibase-catalog-name",
+	"liquibaseTablespaceName":        "liquibase-tablespace-name",
+	"databaseChangeLogTableName":     "database-changelog-table-name",
+	"databaseChangeLogLockTableName": "database-changelog-lock-table-name",
+	"referenceUrl":                   "reference-url",
+	"referenceUsername":              "reference-username",
+	"referencePassword":              "reference-password",
+	"referenceDefaultSchemaName":     "reference-default-schema-name",
+	"outputFile":                     "output-file",
+	"outputDefaultSchema":            "output-default-schema",
+	"outputDefaultCatalog":           "output-default-catalog",
+	"contexts":                       "contexts",
+	"labels":                         "labels",
+	"labelFilter":                    "label-filter",
+	"contextFilter":                  "context-filter",
+	"diffTypes":                      "diff-types",
+	"includeObjects":                 "include-objects",
+	"excludeObjects":                 "exclude-objects",
+	"includeSchema":                  "include-schema",
+	"includeCatalog":                 "include-catalog",
+	"includeTablespace":              "include-tablespace",
+	"dataOutputDirectory":            "data-output-directory",
+	"snapshotFormat":                 "snapshot-format",
+	"rollbackScript":                 "rollback-script",
+	"changeSetId":                    "changeset-id",
+	"changeSetAuthor":                "changeset-author",
+	"changeSetPath":                  "changeset-path",
+	"sqlFile":                        "sql-file",
+	"promptForNonLocalDatabase":      "prompt-for-non-local-database",
+	"outputFileEncoding":             "output-file-encoding",
+}
+
+// RemovedArgument is a flag or command a Liquibase version dropped
+type RemovedArgument struct {
+	// Name in kebab-case, e.g. hub-mode
+	Name string
+	// Since is the first version without it
+	Since string
+	// Command is set for commands, which can't be dropped from a run like flags can
+	Command bool
+}
+
+// Flags and commands removed from Liquibase, Hub was sunset in 4.24
+var REMOVED_ARGUMENTS = []RemovedArgument{
+	{Name: "hub-mode", Since: "4.24.0"},
+	{Name: "hub-api-key", Since: "4.24.0"},
+	{Name: "hub-connection-id", Since: "4.24.0"},
+	{Name: "hub-project-id", Since: "4.24.0"},
+	{Name: "hub-project-name", Since: "4.24.0"},
+	{Name: "register-changelog", Since: "4.24.0", Command: true},
+	{Name: "deactivate-changelog", Since: "4.24.0", Command: true},
+	{Name: "sync-hub", Since: "4.24.0", Command: true},
+}
+
+// Reverse lookups, kebab-case to camelCase
+var (
+	commandCamelCase = reverseNames(COMMAND_RENAMES)
+	flagCamelCase    = reverseNames(FLAG_RENAMES)
+)
+
+func reverseNames(names map[string]string) map[string]string {
+	reversed := make(map[string]string, len(names))
+	for camel, kebab := range names {
+		reversed[kebab] = camel
+	}
+	return reversed
+}
+
+// Convert a camelCase flag without a known rename, defaultsFile becomes defaults-file
+func kebabCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || nextLower) {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// TranslateArgs rewrites flag and command names for a Liquibase version:
+// camelCase names become kebab-case from 4.4 on, kebab-case names become
+// camelCase before it, and flags the version removed are dropped. Commands the
+// version removed fail. Returns the arguments and a note per change.
+func TranslateArgs(version Semver, args []string) ([]string, []string, error) {
+	kebabVersion, _ := ParseSemver(KEBAB_CASE_VERSION)
+	kebab := !version.Less(kebabVersion)
+
+	var result, notes []string
+	commandSeen := false
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			// Only the command is renamed, other positional values are left alone
+			if commandSeen {
+				result = append(result, arg)
+				continue
+			}
+			commandSeen = true
+			name := arg
+			if camel, ok := commandCamelCase[arg]; ok && !kebab {
+				name = camel
+			} else if renamed, ok := COMMAND_RENAMES[arg]; ok && kebab {
+				name = renamed
+			}
+			if removed := removedArgument(name, true, version); removed != nil {
+				return nil, nil, fmt.Errorf("the %s command was removed in Liquibase %s, %s doesn't have it", arg, removed.Since, version)
+			}
+			if name != arg {
+				notes = append(notes, fmt.Sprintf("command %s runs as %s on Liquibase %s", arg, name, version))
+			}
+			result = append(result, name)
+			continue
+		}
+
+		if !strings.HasPrefix(arg, "--") {
+			// Changelog properties like -Dschema=app keep their names
+			result = append(result, arg)
+			continue
+		}
+		flag, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		name := flag
+		switch {
+		case kebab && strings.ContainsFunc(flag, unicode.IsUpper):
+			if renamed, ok := FLAG_RENAMES[flag]; ok {
+				name = renamed
+			} else {
+				name = kebabCase(flag)
+			}
+		case !kebab:
+			if camel, ok := flagCamelCase[flag]; ok {
+				name = camel
+			}
+		}
+		if removed := removedArgument(name, false, version); removed != nil {
+			notes = append(notes, fmt.Sprintf("--%s is dropped, Liquibase removed it in %s", flag, removed.Since))
+			continue
+		}
+		if name != flag {
+			notes = append(notes, fmt.Sprintf("--%s is passed as --%s on Liquibase %s", flag, name, version))
+		}
+		translated := "--" + name
+		if hasValue {
+			translated += "=" + value
+		}
+		result = append(result, translated)
+	}
+	return result, notes, nil
+}
+
+// Find a flag or command, in either naming, that a version no longer has
+func removedArgument(name string, command bool, version Semver) *RemovedArgument {
+	if renamed, ok := COMMAND_RENAMES[name]; ok {
+		name = renamed
+	} else if renamed, ok := FLAG_RENAMES[name]; ok {
+		name = renamed
+	}
+	for i, removed := range REMOVED_ARGUMENTS {
+		since, _ := ParseSemver(removed.Since)
+		if removed.Name == name && removed.Command == command && !version.Less(since) {
+			return &REMOVED_ARGUMENTS[i]
+		}
+	}
+	return nil
+}
+
+// Translate the arguments for the installed Liquibase version. User provided
+// installs of unknown version are passed the arguments unchanged.
+func (pl *GoLiquibase) translateArgs(args []string) ([]string, error) {
+	version, err := liquibaseSemver(pl.InstalledVersion())
+	if err != nil {
+		return args, nil
+	}
+	translated, notes, err := TranslateArgs(version, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		pl.logger().Printf("Compatibility: %s", note)
+	}
+	return translated, nil
+}
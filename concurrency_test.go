@@ -0,0 +1,112 @@
+package goliquify_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+// Run with go test -race, the instance is shared by every goroutine
+func TestConcurrentExecuteIsolatesArguments(t *testing.T) {
+	pl, runner := goliquifytest.New(t, goliquify.WithArgs("--log-level=info"))
+	const calls = 32
+
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := goliquify.ExecOptions{
+				Args:                []string{fmt.Sprintf("--contexts=call%d", i)},
+				ChangelogProperties: map[string]string{"call": fmt.Sprint(i)},
+			}
+			if i%2 == 0 {
+				errs <- pl.ExecuteWithOptions(context.Background(), opts, "update", fmt.Sprintf("--tag=t%d", i))
+			} else {
+				errs <- pl.Execute("status", fmt.Sprintf("--tag=t%d", i))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invocations := runner.Invocations()
+	if len(invocations) != calls {
+		t.Fatalf("%d invocations, want %d", len(invocations), calls)
+	}
+	for _, inv := range invocations {
+		tag, ok := inv.Arg("tag")
+		if !ok {
+			t.Fatalf("invocation without a tag: %v", inv.Args)
+		}
+		call := strings.TrimPrefix(tag, "t")
+		contexts, hasContexts := inv.Arg("contexts")
+		switch inv.Command {
+		case "update":
+			if !hasContexts || contexts != "call"+call {
+				t.Errorf("update of call %s got the contexts of another call: %v", call, inv.Args)
+			}
+			if count := countArg(inv.Args, "-Dcall="+call); count != 1 {
+				t.Errorf("update of call %s got the properties of another call: %v", call, inv.Args)
+			}
+		case "status":
+			if hasContexts || countArg(inv.Args, "-Dcall="+call) != 0 {
+				t.Errorf("status of call %s got the options of an update: %v", call, inv.Args)
+			}
+		default:
+			t.Errorf("unexpected command %s", inv.Command)
+		}
+		if count := countArg(inv.Args, "--log-level=info"); count != 1 {
+			t.Errorf("instance argument passed %d times: %v", count, inv.Args)
+		}
+	}
+	if len(pl.Args) != 1 {
+		t.Fatalf("instance arguments changed to %v", pl.Args)
+	}
+}
+
+func TestConcurrentOutput(t *testing.T) {
+	pl, runner := goliquifytest.New(t)
+	runner.On("tag-exists", goliquifytest.Response{Stdout: "The tag exists\n"})
+	runner.On("history", goliquifytest.Response{Stdout: "history\n"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			command, want := "history", "history\n"
+			if i%2 == 0 {
+				command, want = "tag-exists", "The tag exists\n"
+			}
+			out, err := pl.Output(command)
+			if err != nil {
+				t.Error(err)
+			} else if out != want {
+				t.Errorf("%s printed %q, want %q", command, out, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func countArg(args []string, arg string) int {
+	count := 0
+	for _, a := range args {
+		if a == arg {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,155 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_CONFIG_FILE = "goliquify.yaml"
+	PROPERTY_ENV_PREFIX = "GOLIQUIFY_PROP_"
+)
+
+// Config holds the settings read from the goliquify.yaml file
+type Config struct {
+	ChangelogProperties map[string]string       `yaml:"changelogProperties"`
+	TemplateDir         string                  `yaml:"templateDir"`
+	TemplateValues      map[string]any          `yaml:"templateValues"`
+	Targets             map[string]*Target      `yaml:"targets"`
+	Phases              PhaseConfig             `yaml:"phases"`
+	Seeds               SeedConfig              `yaml:"seeds"`
+	Heartbeat           time.Duration           `yaml:"heartbeat"`
+	Webhooks            []string                `yaml:"webhooks"`
+	Journal             string                  `yaml:"journal"`
+	Environments        map[string]*Environment `yaml:"environments"`
+	Baseline            *Baseline               `yaml:"baseline"`
+	DisableAnalytics    bool                    `yaml:"disableAnalytics"`
+	LogDir              string                  `yaml:"logDir"`
+	LogRetention        LogRetention            `yaml:"logRetention"`
+	Secrets             SecretsConfig           `yaml:"secrets"`
+	Ownership           *OwnershipConfig        `yaml:"ownership"`
+	Policies            *PolicyConfig           `yaml:"policies"`
+	JavaVersion         int                     `yaml:"javaVersion"`
+	OperationReports    *OperationReports       `yaml:"operationReports"`
+	Pipeline            PipelineConfig          `yaml:"pipeline"`
+	Audit               AuditConfig             `yaml:"audit"`
+	Cache               CacheConfig             `yaml:"cache"`
+	Drivers             []string                `yaml:"drivers"`
+	ExtensionMatrix     string                  `yaml:"extensionMatrix"`
+	Plugins             []string                `yaml:"plugins"`
+	AcceptLicenses      []string                `yaml:"acceptLicenses"`
+	Server              *ServerConfig           `yaml:"server"`
+	Incidents           *IncidentConfig         `yaml:"incidents"`
+	Metrics             []MetricsConfig         `yaml:"metrics"`
+	EventPublishers     []EventPublisherConfig  `yaml:"eventPublishers"`
+	SchemaRegistry      *SchemaRegistryConfig   `yaml:"schemaRegistry"`
+	KnowledgeBase       *KnowledgeBaseConfig    `yaml:"knowledgeBase"`
+	Encoding            EncodingOptions         `yaml:"encoding"`
+	IDPolicy            *IDPolicyConfig         `yaml:"idPolicy"`
+	Canary              CanaryConfig            `yaml:"canary"`
+	Hotfix              *HotfixConfig           `yaml:"hotfix"`
+}
+
+// Environment holds the settings for one deployment environment
+type Environment struct {
+	DefaultsFile        string               `yaml:"defaultsFile"`
+	Protected           bool                 `yaml:"protected"`
+	ChangelogProperties map[string]string    `yaml:"changelogProperties"`
+	Timezone            string               `yaml:"timezone"`
+	DatabaseTimezone    string               `yaml:"databaseTimezone"`
+	DefaultSchema       string               `yaml:"defaultSchema"`
+	LiquibaseSchema     string               `yaml:"liquibaseSchema"`
+	LiquibaseCatalog    string               `yaml:"liquibaseCatalog"`
+	Schemas             []string             `yaml:"schemas"`
+	Windows             []MaintenanceWindow  `yaml:"windows"`
+	WindowCommands      []string             `yaml:"windowCommands"`
+	DisableAnalytics    bool                 `yaml:"disableAnalytics"`
+	Secrets             SecretsConfig        `yaml:"secrets"`
+	Guardrails          *CommandPolicy       `yaml:"guardrails"`
+	ReadOnly            *ReadOnlyCredentials `yaml:"readOnly"`
+	Tunnel              *TunnelConfig        `yaml:"tunnel"`
+	TLS                 *TLSConfig           `yaml:"tls"`
+	Kerberos            *KerberosConfig      `yaml:"kerberos"`
+	Entra               *EntraConfig         `yaml:"entra"`
+	DSQL                *DSQLConfig          `yaml:"dsql"`
+	Spanner             *SpannerConfig       `yaml:"spanner"`
+	Attestations        *AttestationConfig   `yaml:"attestations"`
+	Session             map[string]string    `yaml:"session"`
+	Backup              *BackupConfig        `yaml:"backup"`
+	Rehearsal           *RehearsalConfig     `yaml:"rehearsal"`
+	Branching           *BranchConfig        `yaml:"branching"`
+	ChangeTickets       *ChangeTicketConfig  `yaml:"changeTickets"`
+	Encoding            EncodingOptions      `yaml:"encoding"`
+	// IncidentSeverity of the alerts opened for the environment, none to
+	// open none
+	IncidentSeverity string `yaml:"incidentSeverity"`
+}
+
+// Look up an environment by name
+func (c *Config) Environment(name string) (*Environment, error) {
+	env, ok := c.Environments[name]
+	if !ok || env == nil {
+		return nil, fmt.Errorf("environment %s is not defined in the config", name)
+	}
+	return env, nil
+}
+
+// LoadConfig reads a config file. A missing file is only an error if required is set.
+func LoadConfig(path string, required bool) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ChangelogPropertiesFromEnv collects changelog properties from GOLIQUIFY_PROP_<name>=<value> environment variables
+func ChangelogPropertiesFromEnv(environ []string) map[string]string {
+	props := map[string]string{}
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, PROPERTY_ENV_PREFIX) {
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimPrefix(kv, PROPERTY_ENV_PREFIX), "=")
+		if !ok || key == "" {
+			continue
+		}
+		props[key] = val
+	}
+	return props
+}
+
+// ParseDefines parses key=value pairs given with --define
+func ParseDefines(defines []string) (map[string]string, error) {
+	props := map[string]string{}
+	for _, d := range defines {
+		key, val, ok := strings.Cut(d, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --define %q, expecting key=value", d)
+		}
+		props[key] = val
+	}
+	return props, nil
+}
+
+// Return the keys of a string map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,134 @@
+package goliquify_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		fails string
+	}{
+		{expr: "0 2 * * *"},
+		{expr: "*/15 9-17 * * MON-FRI"},
+		{expr: "30 4 1,15 jan,jul 7"},
+		{expr: "0 2 * *", fails: "expecting 5 fields"},
+		{expr: "60 2 * * *", fails: "out of range 0-59"},
+		{expr: "0 2 0 * *", fails: "out of range 1-31"},
+		{expr: "0 17-9 * * *", fails: "out of range"},
+		{expr: "*/0 * * * *", fails: "invalid cron step"},
+		{expr: "0 2 * * FUN", fails: "invalid cron value"},
+	} {
+		_, err := goliquify.ParseCron(tc.expr)
+		if tc.fails == "" && err != nil {
+			t.Errorf("%q: unexpected error %v", tc.expr, err)
+		}
+		if tc.fails != "" && (err == nil || !strings.Contains(err.Error(), tc.fails)) {
+			t.Errorf("%q: error %v, want %q", tc.expr, err, tc.fails)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, 1, 16, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{"10/20 * * * *", time.Date(2025, 1, 15, 10, 10, 0, 0, time.UTC)},
+		{"0 9 * * MON", time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC)},
+		// 0 and 7 are both Sunday
+		{"0 9 * * 7", time.Date(2025, 1, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 0", time.Date(2025, 1, 19, 9, 0, 0, 0, time.UTC)},
+		// A restricted day-of-month and day-of-week fire on either
+		{"0 0 1 * FRI", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb *", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	} {
+		cron, err := goliquify.ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		if next := cron.Next(from); !next.Equal(tc.want) {
+			t.Errorf("%q: next at %v, want %v", tc.expr, next, tc.want)
+		}
+	}
+}
+
+func TestCronMatchesInTimezone(t *testing.T) {
+	cron, err := goliquify.ParseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+	at := time.Date(2025, 1, 15, 1, 0, 0, 0, time.UTC)
+	if cron.Matches(at) || !cron.Matches(at.In(berlin)) {
+		t.Fatalf("02:00 in Berlin is %v, expecting a match in Berlin time only", at)
+	}
+}
+
+func TestScheduledOperationValidate(t *testing.T) {
+	for _, tc := range []struct {
+		op    goliquify.ScheduledOperation
+		fails string
+	}{
+		{op: goliquify.ScheduledOperation{Name: "nightly-drift", Cron: "0 2 * * *", Operation: goliquify.OPERATION_DRIFT, Jitter: time.Minute}},
+		{op: goliquify.ScheduledOperation{Name: "weekly-validate", Cron: "0 6 * * MON", Timezone: "UTC", Operation: "validate"}},
+		{op: goliquify.ScheduledOperation{Name: "hourly-status", Cron: "0 * * * *", Operation: goliquify.OPERATION_EXPORT_STATUS, Output: "status/{env}-{time}.json"}},
+		{op: goliquify.ScheduledOperation{Name: "reset", Cron: "0 0 * * SUN", Operation: "rollback", Tag: "v1"}},
+		{op: goliquify.ScheduledOperation{Cron: "0 2 * * *", Operation: "status"}, fails: "without a name"},
+		{op: goliquify.ScheduledOperation{Name: "bad-cron", Cron: "0 25 * * *", Operation: "status"}, fails: "schedule bad-cron: cron field"},
+		{op: goliquify.ScheduledOperation{Name: "bad-zone", Cron: "0 2 * * *", Timezone: "Mars/Olympus", Operation: "status"}, fails: "invalid timezone"},
+		{op: goliquify.ScheduledOperation{Name: "no-output", Cron: "0 2 * * *", Operation: goliquify.OPERATION_EXPORT_STATUS}, fails: "needs an output file"},
+		{op: goliquify.ScheduledOperation{Name: "no-tag", Cron: "0 2 * * *", Operation: "rollback"}, fails: "schedule no-tag:"},
+		{op: goliquify.ScheduledOperation{Name: "unknown", Cron: "0 2 * * *", Operation: "changelog-sync"}, fails: `unknown operation "changelog-sync"`},
+		{op: goliquify.ScheduledOperation{Name: "jitter", Cron: "0 2 * * *", Operation: "status", Jitter: -time.Second}, fails: "negative jitter"},
+	} {
+		err := tc.op.Validate()
+		if tc.fails == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.op.Name, err)
+		}
+		if tc.fails != "" && (err == nil || !strings.Contains(err.Error(), tc.fails)) {
+			t.Errorf("%s: error %v, want %q", tc.op.Name, err, tc.fails)
+		}
+	}
+}
+
+func TestServerChecksSchedules(t *testing.T) {
+	pl, _ := goliquifytest.New(t)
+	nightly := goliquify.ScheduledOperation{Name: "nightly", Cron: "0 2 * * *", Operation: "status"}
+	for _, tc := range []struct {
+		name      string
+		schedules []goliquify.ScheduledOperation
+		fails     string
+	}{
+		{name: "valid", schedules: []goliquify.ScheduledOperation{nightly, {Name: "weekly", Cron: "0 6 * * MON", Operation: "validate", Environments: []string{"prod"}}}},
+		{name: "twice", schedules: []goliquify.ScheduledOperation{nightly, nightly}, fails: "schedule nightly is defined twice"},
+		{name: "unknown environment", schedules: []goliquify.ScheduledOperation{{Name: "weekly", Cron: "0 6 * * MON", Operation: "validate", Environments: []string{"staging"}}}, fails: "unknown environment staging"},
+		{name: "invalid", schedules: []goliquify.ScheduledOperation{{Name: "weekly", Cron: "0 6 * *", Operation: "validate"}}, fails: "expecting 5 fields"},
+	} {
+		_, err := goliquify.NewServer(goliquify.ServerOptions{
+			Environments: map[string]*goliquify.GoLiquibase{"prod": pl},
+			JobsDir:      t.TempDir(),
+			Schedules:    tc.schedules,
+		})
+		if tc.fails == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if tc.fails != "" && (err == nil || !strings.Contains(err.Error(), tc.fails)) {
+			t.Errorf("%s: error %v, want %q", tc.name, err, tc.fails)
+		}
+	}
+}
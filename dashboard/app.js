@@ -0,0 +1,178 @@
+// Dashboard of goliquify serve --ui, reading the JSON API
+"use strict";
+
+const COMMANDS = ["status", "validate", "updateSQL", "update"];
+
+function token() {
+  return sessionStorage.getItem("goliquify-token");
+}
+
+async function api(path, options = {}) {
+  const headers = {};
+  if (token()) {
+    headers["Authorization"] = "Bearer " + token();
+  }
+  const response = await fetch(path, { ...options, headers });
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text === undefined || text === null ? "" : text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function statusClass(status) {
+  if (status === "up to date" || status === "completed") {
+    return "ok";
+  }
+  return status === "behind" ? "warn" : "bad";
+}
+
+async function loadEnvironments() {
+  const tbody = document.querySelector("#environments tbody");
+  const environments = await api("api/environments");
+  tbody.replaceChildren();
+  for (const env of environments) {
+    const row = tbody.insertRow();
+    cell(row, env.target);
+    let status = env.status + (env.error ? ": " + env.error : "");
+    if (env.running) {
+      status = env.running + " running" + (env.queued ? ", " + env.queued + " queued" : "");
+    }
+    cell(row, status, env.running ? "warn" : statusClass(env.status));
+    const pending = cell(row, env.pending);
+    if (env.pending > 0) {
+      const link = document.createElement("a");
+      link.href = "#";
+      link.textContent = env.pending;
+      link.onclick = (e) => {
+        e.preventDefault();
+        showPending(env);
+      };
+      pending.replaceChildren(link);
+    }
+    cell(row, env.latestTag);
+    if (env.lock) {
+      cell(row, env.lock.locked ? "locked by " + env.lock.lockedBy : "free", env.lock.locked ? "bad" : "ok");
+    } else {
+      cell(row, "unknown", "muted");
+    }
+    loadDrift(env.target, cell(row, "...", "muted"));
+    const actions = cell(row, "");
+    for (const command of COMMANDS) {
+      const button = document.createElement("button");
+      button.textContent = command;
+      button.disabled = !token();
+      button.onclick = () => run(env.target, command);
+      actions.appendChild(button);
+    }
+  }
+}
+
+async function loadDrift(environment, td) {
+  try {
+    const drift = await api("api/environments/" + encodeURIComponent(environment) + "/drift");
+    if (drift.error) {
+      td.textContent = drift.error;
+      td.className = "muted";
+    } else {
+      td.textContent = drift.drifted ? drift.differences.length + " difference(s)" : "none";
+      td.className = drift.drifted ? "bad" : "ok";
+    }
+  } catch (e) {
+    td.textContent = e.message;
+    td.className = "muted";
+  }
+}
+
+function showPending(env) {
+  const section = document.getElementById("pending");
+  document.getElementById("pending-env").textContent = env.target;
+  const list = section.querySelector("ul");
+  list.replaceChildren();
+  for (const changeset of env.pendingChangeSets || []) {
+    const item = document.createElement("li");
+    item.textContent = changeset;
+    list.appendChild(item);
+  }
+  section.hidden = false;
+}
+
+async function loadRuns() {
+  const tbody = document.querySelector("#runs tbody");
+  const runs = await api("api/runs?limit=25");
+  tbody.replaceChildren();
+  for (const run of runs) {
+    const row = tbody.insertRow();
+    cell(row, new Date(run.start).toLocaleString());
+    cell(row, run.environment);
+    cell(row, run.command);
+    cell(row, run.status, statusClass(run.status));
+    cell(row, run.error);
+  }
+}
+
+async function run(environment, command) {
+  if (command === "update" && !confirm("Run update on " + environment + "?")) {
+    return;
+  }
+  const section = document.getElementById("output");
+  const pre = section.querySelector("pre");
+  document.getElementById("output-title").textContent = command + " on " + environment;
+  pre.textContent = "Queueing...";
+  section.hidden = false;
+  try {
+    const job = await api("api/environments/" + encodeURIComponent(environment) + "/commands/" + command, { method: "POST" });
+    refresh();
+    await follow(job, pre);
+  } catch (e) {
+    pre.textContent = e.message;
+  }
+  refresh();
+}
+
+// Append the log of a job until it finishes
+async function follow(job, pre) {
+  const headers = token() ? { Authorization: "Bearer " + token() } : {};
+  let offset = 0;
+  pre.textContent = "";
+  for (;;) {
+    const current = await api("api/jobs/" + job.id);
+    const response = await fetch("api/jobs/" + job.id + "/log?offset=" + offset, { headers });
+    pre.textContent += await response.text();
+    offset = Number(response.headers.get("X-Log-Offset")) || offset;
+    if (current.status !== "queued" && current.status !== "running") {
+      pre.textContent += "\n" + current.command + " " + current.status + (current.error ? ": " + current.error : "");
+      return;
+    }
+    await new Promise((resolve) => setTimeout(resolve, 1000));
+  }
+}
+
+async function refresh() {
+  try {
+    await Promise.all([loadEnvironments(), loadRuns()]);
+    document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (e) {
+    document.getElementById("updated").textContent = e.message;
+  }
+}
+
+document.getElementById("refresh").onclick = refresh;
+document.getElementById("token").onclick = () => {
+  const value = prompt("API token or ID token");
+  if (value !== null) {
+    sessionStorage.setItem("goliquify-token", value);
+    refresh();
+  }
+};
+refresh();
+setInterval(refresh, 30000);
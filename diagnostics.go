@@ -0,0 +1,349 @@
+package goliquify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DIAGNOSTIC_CHECKSUM        = "checksum"
+	DIAGNOSTIC_DUPLICATE       = "duplicate"
+	DIAGNOSTIC_MISSING_INCLUDE = "missing-include"
+	DIAGNOSTIC_DOUBLE_INCLUDE  = "double-include"
+	DIAGNOSTIC_ORPHAN          = "orphan"
+	DIAGNOSTIC_PARSE           = "parse"
+	DIAGNOSTIC_ERROR           = "error"
+)
+
+var (
+	// "     2 changesets check sum" and the other group headers of a validation failure
+	validateGroupPattern = regexp.MustCompile(`^\s*\d+\s+change ?sets?\s+(.+?)\s*$`)
+	checksumPattern      = regexp.MustCompile(`^(.+?)\s+was:\s*(\S+)\s+but is now:\s*(\S+)`)
+	missingFilePattern   = regexp.MustCompile(`(?:[Ff]ile\s+)?(\S+\.(?:xml|ya?ml|json|sql))\s+(?:was not found|does not exist)`)
+	parseErrorPattern    = regexp.MustCompile(`[Ee]rror parsing line (\d+) column \d+ of (\S+?):\s*(.*)$`)
+)
+
+// Diagnostic is a validation problem located in a changelog file
+type Diagnostic struct {
+	Kind string `json:"kind"`
+	// Severity is error or warning, empty means error
+	Severity string `json:"severity,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	// ChangeSet is the file::id::author coordinates of the changeset, if any
+	ChangeSet string `json:"changeSet,omitempty"`
+	Message   string `json:"message"`
+	// Deployed and Current checksums of a checksum mismatch
+	Deployed string `json:"deployed,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+// String formats the diagnostic compiler-style as file:line: message
+func (d Diagnostic) String() string {
+	location := d.File
+	if location == "" {
+		location = "<unknown>"
+	}
+	if d.Line > 0 {
+		location += ":" + strconv.Itoa(d.Line)
+	}
+	if d.Severity == SEVERITY_WARNING {
+		return fmt.Sprintf("%s: warning: %s", location, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", location, d.Message)
+}
+
+// ParseValidateOutput extracts the problems from the output of a failed validate
+func ParseValidateOutput(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := map[string]bool{}
+	add := func(d Diagnostic) {
+		if key := d.String(); !seen[key] {
+			seen[key] = true
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	group := ""
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if m := validateGroupPattern.FindStringSubmatch(line); m != nil {
+			group = m[1]
+			continue
+		}
+		if m := parseErrorPattern.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			add(Diagnostic{Kind: DIAGNOSTIC_PARSE, File: m[2], Line: n, Message: m[3]})
+			continue
+		}
+		if m := missingFilePattern.FindStringSubmatch(line); m != nil {
+			add(Diagnostic{Kind: DIAGNOSTIC_MISSING_INCLUDE, File: m[1], Message: fmt.Sprintf("included file %s was not found", m[1])})
+			continue
+		}
+		if group == "" || !strings.Contains(line, "::") {
+			continue
+		}
+
+		switch {
+		case strings.Contains(group, "check sum") || strings.Contains(group, "checksum"):
+			if m := checksumPattern.FindStringSubmatch(line); m != nil {
+				add(Diagnostic{
+					Kind:      DIAGNOSTIC_CHECKSUM,
+					ChangeSet: m[1],
+					Deployed:  m[2],
+					Current:   m[3],
+					Message:   fmt.Sprintf("changeset %s was modified after it was deployed (checksum was %s, is now %s)", changesetLabel(m[1]), m[2], m[3]),
+				})
+			}
+		case strings.Contains(group, "duplicate"):
+			add(Diagnostic{Kind: DIAGNOSTIC_DUPLICATE, ChangeSet: line, Message: fmt.Sprintf("duplicate changeset %s", changesetLabel(line))})
+		default:
+			key, detail, _ := strings.Cut(line, ": ")
+			message := fmt.Sprintf("changeset %s %s", changesetLabel(key), group)
+			if detail != "" {
+				message += ": " + detail
+			}
+			add(Diagnostic{Kind: DIAGNOSTIC_ERROR, ChangeSet: key, Message: message})
+		}
+	}
+	for i := range diagnostics {
+		if diagnostics[i].File == "" && diagnostics[i].ChangeSet != "" {
+			diagnostics[i].File, _, _, _ = splitChangesetKey(diagnostics[i].ChangeSet)
+		}
+	}
+	return diagnostics
+}
+
+// Format file::id::author coordinates as id::author for messages that already name the file
+func changesetLabel(key string) string {
+	if _, id, author, ok := splitChangesetKey(key); ok {
+		return id + "::" + author
+	}
+	return key
+}
+
+// Locate fills in the files and lines of the diagnostics from the changelogs on disk
+func (t *ChangelogTree) Locate(diagnostics []Diagnostic) {
+	for i := range diagnostics {
+		d := &diagnostics[i]
+		switch {
+		case d.Kind == DIAGNOSTIC_MISSING_INCLUDE:
+			// Point at the include rather than the file that doesn't exist
+			for _, f := range t.Files {
+				for _, inc := range f.Includes {
+					if inc.Missing && sameChangelogPath(inc.File, d.File) {
+						d.File, d.Line = f.DiskPath, inc.Line
+					}
+				}
+			}
+		case d.ChangeSet != "":
+			if cs := t.Find(d.ChangeSet); cs != nil {
+				if f := t.FindFile(cs.File); f != nil {
+					d.File = f.DiskPath
+				}
+				d.Line = cs.Line
+			}
+		case d.File != "":
+			if f := t.FindFile(d.File); f != nil {
+				d.File = f.DiskPath
+			}
+		}
+	}
+}
+
+// Diagnostics for problems found by parsing the changelogs: includes that
+// don't exist, files included twice and changesets defined twice. Liquibase
+// only reports the first problem it runs into, these are found all at once.
+func (t *ChangelogTree) Diagnostics() []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, f := range t.Files {
+		for _, inc := range f.Includes {
+			if inc.Missing {
+				diagnostics = append(diagnostics, Diagnostic{Kind: DIAGNOSTIC_MISSING_INCLUDE, File: f.DiskPath, Line: inc.Line, Message: fmt.Sprintf("included file %s was not found", inc.File)})
+			}
+		}
+	}
+	for _, d := range t.DoubleIncludes {
+		message := fmt.Sprintf("%s is included again, it is the root changelog", d.File)
+		if d.FirstBy != "" {
+			message = fmt.Sprintf("%s is included again, it is already included on %s:%d", d.File, d.FirstBy, d.FirstLine)
+		}
+		diagnostics = append(diagnostics, Diagnostic{Kind: DIAGNOSTIC_DOUBLE_INCLUDE, Severity: SEVERITY_WARNING, File: d.By, Line: d.Line, Message: message})
+	}
+	first := map[string]*ChangeSet{}
+	for _, cs := range t.ChangeSets {
+		if prev, dup := first[cs.Key()]; dup {
+			file := cs.File
+			if f := t.FindFile(cs.File); f != nil {
+				file = f.DiskPath
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Kind:      DIAGNOSTIC_DUPLICATE,
+				File:      file,
+				Line:      cs.Line,
+				ChangeSet: cs.Key(),
+				Message:   fmt.Sprintf("duplicate changeset %s, first defined on line %d", changesetLabel(cs.Key()), prev.Line),
+			})
+			continue
+		}
+		first[cs.Key()] = cs
+	}
+	return diagnostics
+}
+
+// ReadDefaultsFile reads the settings of a liquibase.properties file
+func ReadDefaultsFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	props := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			props[line] = ""
+			continue
+		}
+		props[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return props, scanner.Err()
+}
+
+// ChangelogLocation returns the root changelog and the search path a command
+// uses, from its arguments or else the defaults file
+func (pl *GoLiquibase) ChangelogLocation(arguments ...string) (string, []string) {
+	var changelogFile, searchPath string
+	for _, arg := range append(append([]string{}, pl.Args...), arguments...) {
+		if v, ok := strings.CutPrefix(arg, "--changelog-file="); ok {
+			changelogFile = v
+		}
+		if v, ok := strings.CutPrefix(arg, "--search-path="); ok {
+			searchPath = v
+		}
+	}
+	if changelogFile == "" || searchPath == "" {
+		if props, err := ReadDefaultsFile(pl.DefaultsFile); err == nil {
+			if changelogFile == "" {
+				changelogFile = firstNonEmpty(props["changelog-file"], props["changeLogFile"], props["liquibase.command.changelogFile"])
+			}
+			if searchPath == "" {
+				searchPath = firstNonEmpty(props["search-path"], props["searchPath"], props["liquibase.searchPath"])
+			}
+		}
+	}
+
+	var dirs []string
+	for _, dir := range strings.Split(searchPath, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+		// Liquibase also finds changelogs next to the defaults file
+		if dir := filepath.Dir(pl.DefaultsFile); dir != "." {
+			dirs = append(dirs, dir)
+		}
+	}
+	return changelogFile, dirs
+}
+
+// ChangelogTable returns the table Liquibase records deployments in,
+// qualified with the Liquibase schema or catalog when one is configured, from
+// the arguments, the environment or else the defaults file
+func (pl *GoLiquibase) ChangelogTable() string {
+	setting := func(flag, property, value string) string {
+		for _, arg := range pl.Args {
+			if v, ok := strings.CutPrefix(arg, "--"+flag+"="); ok {
+				value = v
+			}
+		}
+		if value == "" {
+			value = os.Getenv("LIQUIBASE_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_")))
+		}
+		if value == "" {
+			if props, err := ReadDefaultsFile(pl.DefaultsFile); err == nil {
+				value = firstNonEmpty(props[flag], props[property], props["liquibase."+property])
+			}
+		}
+		return value
+	}
+	table := firstNonEmpty(setting("database-changelog-table-name", "databaseChangeLogTableName", ""), "DATABASECHANGELOG")
+	qualifier := firstNonEmpty(
+		setting("liquibase-schema-name", "liquibaseSchemaName", pl.LiquibaseSchemaName),
+		setting("liquibase-catalog-name", "liquibaseCatalogName", pl.LiquibaseCatalogName),
+	)
+	if qualifier != "" {
+		return qualifier + "." + table
+	}
+	return table
+}
+
+// ValidateDiagnostics runs validate and returns the problems it reports,
+// located in the changelog files. Liquibase output goes to stderr, so stdout
+// is free for the diagnostics. The error is only set when validate could not
+// be run or failed without reporting a problem.
+func (pl *GoLiquibase) ValidateDiagnostics(arguments ...string) ([]Diagnostic, error) {
+	var output bytes.Buffer
+	opts := ExecOptions{Stdout: io.MultiWriter(os.Stderr, &output), Stderr: io.MultiWriter(os.Stderr, &output)}
+	runErr := pl.ExecuteWithOptions(context.Background(), opts, append([]string{"validate"}, arguments...)...)
+	diagnostics := ParseValidateOutput(output.String())
+
+	changelogFile, searchPath := pl.ChangelogLocation(arguments...)
+	if changelogFile != "" {
+		if tree, err := LoadChangelogTree(changelogFile, searchPath); err == nil {
+			tree.Locate(diagnostics)
+			diagnostics = mergeDiagnostics(diagnostics, tree.Diagnostics())
+			if orphans, err := tree.Orphans(); err == nil {
+				diagnostics = mergeDiagnostics(diagnostics, OrphanDiagnostics(orphans))
+			}
+		} else if len(diagnostics) == 0 {
+			diagnostics = append(diagnostics, Diagnostic{Kind: DIAGNOSTIC_PARSE, File: changelogFile, Message: err.Error()})
+		}
+	}
+
+	if runErr != nil && len(diagnostics) == 0 {
+		return nil, runErr
+	}
+	return diagnostics, nil
+}
+
+// Add diagnostics that aren't reported yet
+func mergeDiagnostics(diagnostics, more []Diagnostic) []Diagnostic {
+	seen := map[string]bool{}
+	for _, d := range diagnostics {
+		seen[d.Kind+d.File+strconv.Itoa(d.Line)] = true
+	}
+	for _, d := range more {
+		if !seen[d.Kind+d.File+strconv.Itoa(d.Line)] {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+// WriteDiagnostics prints diagnostics one per line
+func WriteDiagnostics(w io.Writer, diagnostics []Diagnostic) {
+	for _, d := range diagnostics {
+		fmt.Fprintln(w, d.String())
+	}
+}
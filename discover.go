@@ -0,0 +1,394 @@
+package goliquify
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const DEFAULT_ORDER_FILE = "goliquify.order"
+
+// Changelog root file names picked up by discovery
+var CHANGELOG_ROOT_NAMES = []string{"changelog.xml", "changelog.yaml", "changelog.yml", "changelog.json", "changelog.sql"}
+
+// Directory names that describe the layout rather than the service they belong to
+var CHANGELOG_LAYOUT_DIRS = []string{"db", "database", "migrations", "changelog", "changelogs", "liquibase"}
+
+// Target is a changelog and the connection settings it is applied with
+type Target struct {
+	Name         string        `yaml:"-" json:"name"`
+	Changelog    string        `yaml:"changelog" json:"changelog"`
+	DefaultsFile string        `yaml:"defaultsFile" json:"defaultsFile,omitempty"`
+	DependsOn    []string      `yaml:"dependsOn" json:"dependsOn,omitempty"`
+	Requires     []Requirement `yaml:"requires" json:"requires,omitempty"`
+}
+
+// Requirement declares that another target must be deployed up to a tag first
+type Requirement struct {
+	Target string `yaml:"target" json:"target"`
+	Tag    string `yaml:"tag" json:"tag"`
+}
+
+// TargetResult is the outcome of running a command against one target
+type TargetResult struct {
+	Target   string        `json:"target"`
+	Command  string        `json:"command"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Find all changelog roots under a directory and map them to targets.
+// Configured targets are found whatever their changelog is named, and added
+// even outside the directory. One whose changelog doesn't exist is an error.
+func DiscoverTargets(root string, cfg *Config) ([]*Target, error) {
+	configured := map[string]*Target{}
+	for name, t := range cfg.Targets {
+		t.Name = name
+		configured[filepath.Clean(t.Changelog)] = t
+	}
+
+	var targets []*Target
+	seen := map[string]string{}
+	add := func(target *Target, path string) error {
+		if other, dup := seen[target.Name]; dup {
+			return fmt.Errorf("target %s maps to both %s and %s", target.Name, other, path)
+		}
+		seen[target.Name] = path
+		if target.DefaultsFile == "" {
+			localDefaults := filepath.Join(filepath.Dir(path), "liquibase.properties")
+			if fileExists(localDefaults) {
+				target.DefaultsFile = localDefaults
+			}
+		}
+		targets = append(targets, target)
+		return nil
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules" || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target, ok := configured[filepath.Clean(path)]
+		if !ok && !isChangelogRoot(d.Name()) {
+			return nil
+		}
+		if !ok {
+			target = &Target{Name: targetNameForChangelog(root, path), Changelog: path}
+		}
+		delete(configured, filepath.Clean(path))
+		return add(target, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(cfg.Targets))
+	for name := range cfg.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var missing []string
+	for _, name := range names {
+		t := cfg.Targets[name]
+		if _, ok := configured[filepath.Clean(t.Changelog)]; !ok {
+			continue
+		}
+		if !fileExists(t.Changelog) {
+			missing = append(missing, fmt.Sprintf("%s (%s)", name, t.Changelog))
+			continue
+		}
+		if err := add(t, t.Changelog); err != nil {
+			return nil, err
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("changelogs of configured targets not found: %s", strings.Join(missing, ", "))
+	}
+	return targets, nil
+}
+
+// Check if a file name is a changelog root
+func isChangelogRoot(name string) bool {
+	for _, n := range CHANGELOG_ROOT_NAMES {
+		if strings.EqualFold(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Derive a target name from the directory holding the changelog
+func targetNameForChangelog(root, path string) string {
+	dir := filepath.Dir(path)
+	for {
+		base := filepath.Base(dir)
+		isLayout := false
+		for _, l := range CHANGELOG_LAYOUT_DIRS {
+			if strings.EqualFold(base, l) {
+				isLayout = true
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if !isLayout || dir == root || parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Base(dir)
+}
+
+// Read a dependency ordering file, one "service: dependency..." entry per line
+func LoadOrderFile(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	deps := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rest, _ := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		deps[name] = append(deps[name], strings.Fields(rest)...)
+	}
+	return deps, scanner.Err()
+}
+
+// Sort targets so every target comes after the targets it depends on.
+// A dependency written as name@tag also requires that tag to be deployed.
+// The returned targets are copies with the dependencies merged, the given
+// ones are left unchanged.
+func OrderTargets(targets []*Target, extraDeps map[string][]string) ([]*Target, error) {
+	byName := map[string]*Target{}
+	for _, t := range targets {
+		merged := *t
+		merged.Requires = append([]Requirement{}, t.Requires...)
+		var deps []string
+		for _, dep := range append(append([]string{}, t.DependsOn...), extraDeps[t.Name]...) {
+			name, tag, hasTag := strings.Cut(dep, "@")
+			deps = append(deps, name)
+			if req := (Requirement{Target: name, Tag: tag}); hasTag && !slices.Contains(merged.Requires, req) {
+				merged.Requires = append(merged.Requires, req)
+			}
+		}
+		for _, req := range merged.Requires {
+			deps = append(deps, req.Target)
+		}
+		merged.DependsOn = uniqueStrings(deps)
+		byName[t.Name] = &merged
+	}
+	for _, t := range targets {
+		for _, dep := range byName[t.Name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("target %s depends on unknown target %s", t.Name, dep)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ordered []*Target
+	state := map[string]int{} // 1 = visiting, 2 = done
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		deps := append([]string{}, byName[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Run a Liquibase command against every target in order. Targets whose
+// dependencies did not succeed are skipped, and updates are blocked when a
+// required tag is not deployed yet.
+func (pl *GoLiquibase) RunTargets(targets []*Target, command string, arguments ...string) []TargetResult {
+	return pl.runTargets(targets, targets, map[string]bool{}, command, arguments...)
+}
+
+// Run a command against a wave of the targets, the ones in done already
+// succeeded and satisfy the dependencies on them
+func (pl *GoLiquibase) runTargets(targets, wave []*Target, done map[string]bool, command string, arguments ...string) []TargetResult {
+	var results []TargetResult
+	ok := map[string]bool{}
+	for name := range done {
+		ok[name] = true
+	}
+	byName := map[string]*Target{}
+	for _, t := range targets {
+		byName[t.Name] = t
+	}
+	for _, t := range wave {
+		result := TargetResult{Target: t.Name, Command: command}
+
+		for _, dep := range t.DependsOn {
+			if !ok[dep] {
+				result.Status = "skipped"
+				result.Error = fmt.Sprintf("dependency %s did not succeed", dep)
+				break
+			}
+		}
+		if result.Status == "" && command == "update" {
+			if err := pl.checkRequirements(t, byName); err != nil {
+				result.Status = "blocked"
+				result.Error = err.Error()
+			}
+		}
+		if result.Status == "" {
+			pl.logger().Printf("Running %s for target %s", command, t.Name)
+			start := time.Now()
+			err := pl.runTarget(t, command, arguments...)
+			result.Duration = time.Since(start)
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+			} else {
+				result.Status = "success"
+				ok[t.Name] = true
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Check that the tags a target requires are deployed in the required targets
+func (pl *GoLiquibase) checkRequirements(t *Target, byName map[string]*Target) error {
+	for _, req := range t.Requires {
+		tpl, err := pl.ForTarget(byName[req.Target])
+		if err != nil {
+			return err
+		}
+		exists, err := tpl.TagExists(req.Tag)
+		if err != nil {
+			return fmt.Errorf("failed to check tag %s in %s: %v", req.Tag, req.Target, err)
+		}
+		if !exists {
+			return fmt.Errorf("requires %s at tag %s, which is not deployed", req.Target, req.Tag)
+		}
+	}
+	return nil
+}
+
+// Run a Liquibase command for a single target
+func (pl *GoLiquibase) runTarget(t *Target, command string, arguments ...string) error {
+	tpl, err := pl.ForTarget(t)
+	if err != nil {
+		return err
+	}
+	args := append([]string{command, fmt.Sprintf("--changelog-file=%s", filepath.Base(t.Changelog))}, arguments...)
+	return tpl.Execute(args...)
+}
+
+// ForTarget builds an initialized instance connected to a target
+func (pl *GoLiquibase) ForTarget(t *Target) (*GoLiquibase, error) {
+	defaultsFile := pl.DefaultsFile
+	if t.DefaultsFile != "" {
+		defaultsFile = t.DefaultsFile
+	}
+	tpl := pl.withDefaultsFile(defaultsFile)
+	if err := tpl.Initialize(); err != nil {
+		return nil, err
+	}
+	if err := tpl.AddArg("search-path", filepath.Dir(t.Changelog)); err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+// Load the targets under root and sort them by their dependencies
+func LoadOrderedTargets(root, orderFile string, cfg *Config) ([]*Target, error) {
+	targets, err := DiscoverTargets(root, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := map[string][]string{}
+	if orderFile == "" && fileExists(filepath.Join(root, DEFAULT_ORDER_FILE)) {
+		orderFile = filepath.Join(root, DEFAULT_ORDER_FILE)
+	}
+	if orderFile != "" {
+		if deps, err = LoadOrderFile(orderFile); err != nil {
+			return nil, err
+		}
+	}
+	return OrderTargets(targets, deps)
+}
+
+// Remove duplicates from a list of strings, keeping the first occurrence
+func uniqueStrings(values []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// Write the consolidated report as a table
+func WriteTargetReport(w io.Writer, results []TargetResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tCOMMAND\tSTATUS\tDURATION\tERROR")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Target, r.Command, r.Status, r.Duration.Round(time.Millisecond), r.Error)
+	}
+	tw.Flush()
+}
+
+// Write the consolidated report as JSON
+func WriteTargetReportJSON(path string, results []TargetResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
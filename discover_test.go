@@ -0,0 +1,73 @@
+package goliquify
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOrderTargetsLeavesInputsUnchanged(t *testing.T) {
+	targets := []*Target{
+		{Name: "orders", DependsOn: []string{"users@v1"}},
+		{Name: "users"},
+	}
+	extra := map[string][]string{"orders": {"users@v1"}}
+
+	for i := 0; i < 2; i++ {
+		ordered, err := OrderTargets(targets, extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ordered[0].Name != "users" || ordered[1].Name != "orders" {
+			t.Fatalf("unexpected order %s, %s", ordered[0].Name, ordered[1].Name)
+		}
+		if want := []Requirement{{Target: "users", Tag: "v1"}}; !reflect.DeepEqual(ordered[1].Requires, want) {
+			t.Fatalf("call %d: requires %v, want %v", i+1, ordered[1].Requires, want)
+		}
+		if want := []string{"users"}; !reflect.DeepEqual(ordered[1].DependsOn, want) {
+			t.Fatalf("call %d: depends on %v, want %v", i+1, ordered[1].DependsOn, want)
+		}
+	}
+	if targets[0].Requires != nil || !reflect.DeepEqual(targets[0].DependsOn, []string{"users@v1"}) {
+		t.Fatalf("input target changed: %+v", targets[0])
+	}
+}
+
+func TestDiscoverTargetsFindsConfiguredChangelogs(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"services/users/db/changelog.xml", "services/orders/db/master.yaml", "shared/reports.sql"} {
+		path = filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	services := filepath.Join(root, "services")
+	// Named other than changelog.*, and outside the discovered directory
+	cfg := &Config{Targets: map[string]*Target{
+		"orders":  {Changelog: filepath.Join(services, "orders", "db", "master.yaml")},
+		"reports": {Changelog: filepath.Join(root, "shared", "reports.sql")},
+	}}
+	targets, err := DiscoverTargets(services, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"orders", "reports", "users"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("targets %v, want %v", names, want)
+	}
+
+	cfg.Targets["billing"] = &Target{Changelog: filepath.Join(root, "billing", "changelog.xml")}
+	if _, err := DiscoverTargets(services, cfg); err == nil || !strings.Contains(err.Error(), "billing") {
+		t.Fatalf("missing configured changelog: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package goliquify
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const MAVEN_CENTRAL_URL = "https://repo1.maven.org/maven2"
+
+// MavenArtifact is a jar on Maven Central
+type MavenArtifact struct {
+	Group    string
+	Artifact string
+	Version  string
+	// Classifier picks a variant of the jar, e.g. one bundling its dependencies
+	Classifier string
+}
+
+// URL of the jar on Maven Central
+func (a MavenArtifact) URL() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", MAVEN_CENTRAL_URL, strings.ReplaceAll(a.Group, ".", "/"), a.Artifact, a.Version, a.FileName())
+}
+
+// FileName of the jar
+func (a MavenArtifact) FileName() string {
+	if a.Classifier != "" {
+		return fmt.Sprintf("%s-%s-%s.jar", a.Artifact, a.Version, a.Classifier)
+	}
+	return fmt.Sprintf("%s-%s.jar", a.Artifact, a.Version)
+}
+
+// DriverBundle is a curated set of JDBC driver jars for a database
+type DriverBundle struct {
+	Name        string
+	Description string
+	Artifacts   []MavenArtifact
+	License     string
+	LicenseURL  string
+	// AcceptLicense is set for licenses that must be accepted before download
+	AcceptLicense bool
+	// Notes are setup hints shown after installing, e.g. for native client libraries
+	Notes string
+}
+
+// Curated JDBC driver bundles by name
+var DRIVER_BUNDLES = map[string]*DriverBundle{
+	"mssql": {
+		Name:        "mssql",
+		Description: "Microsoft SQL Server and Azure SQL (mssql-jdbc)",
+		Artifacts:   []MavenArtifact{{Group: "com.microsoft.sqlserver", Artifact: "mssql-jdbc", Version: "12.8.1.jre11"}},
+		License:     "MIT",
+		LicenseURL:  "https://github.com/microsoft/mssql-jdbc/blob/main/LICENSE",
+		Notes: "Integrated Windows authentication needs the native mssql-jdbc_auth library from the Microsoft JDBC driver download " +
+			"on java.library.path, Kerberos and Entra ID authentication work without it.",
+	},
+	"oracle": {
+		Name:          "oracle",
+		Description:   "Oracle Database thin driver (ojdbc11)",
+		Artifacts:     []MavenArtifact{{Group: "com.oracle.database.jdbc", Artifact: "ojdbc11", Version: "23.5.0.24.07"}},
+		License:       "Oracle Free Use Terms and Conditions",
+		LicenseURL:    "https://www.oracle.com/downloads/licenses/oracle-free-license.html",
+		AcceptLicense: true,
+		Notes: "The thin driver needs no client install, use jdbc:oracle:thin:@host:1521/service urls. Thick mode " +
+			"(jdbc:oracle:oci:@...) needs Oracle Instant Client matching the driver version: unpack it, put its directory on " +
+			"LD_LIBRARY_PATH (PATH on Windows, DYLD_LIBRARY_PATH on macOS) and pass -Djava.library.path=<dir> in JAVA_OPTS. " +
+			"Wallets for mTLS connections are set with TNS_ADMIN.",
+	},
+	"db2": {
+		Name:          "db2",
+		Description:   "IBM Db2 for LUW and z/OS (jcc)",
+		Artifacts:     []MavenArtifact{{Group: "com.ibm.db2", Artifact: "jcc", Version: "11.5.9.0"}},
+		License:       "IBM International Program License Agreement",
+		LicenseURL:    "https://www.ibm.com/support/pages/db2-jdbc-driver-versions-and-downloads",
+		AcceptLicense: true,
+		Notes:         "Connecting to Db2 for z/OS or IBM i may also need the db2jcc_license_cisuz.jar license jar from your Db2 Connect install in the drivers dir.",
+	},
+	"spanner": {
+		Name:        "spanner",
+		Description: "Google Cloud Spanner Liquibase extension with its JDBC driver (liquibase-spanner)",
+		Artifacts:   []MavenArtifact{{Group: "com.google.cloudspannerecosystem", Artifact: "liquibase-spanner", Version: "4.30.0", Classifier: "all"}},
+		License:     "Apache-2.0",
+		LicenseURL:  "https://github.com/cloudspannerecosystem/liquibase-spanner/blob/master/LICENSE",
+		Notes: "Connects with Application Default Credentials, set by gcloud auth application-default login or the service account " +
+			"of the machine, or with the key file set as spanner.credentials in the config. Run the Spanner emulator with " +
+			"spanner.emulator to test changelogs locally.",
+	},
+}
+
+// LicenseError reports a driver whose license was not accepted
+type LicenseError struct {
+	Bundle *DriverBundle
+}
+
+func (e *LicenseError) Error() string {
+	return fmt.Sprintf("the %s driver is licensed under the %s (%s), accept it to install the driver", e.Bundle.Name, e.Bundle.License, e.Bundle.LicenseURL)
+}
+
+// DriverBundleNames returns the names of the curated driver bundles, sorted
+func DriverBundleNames() []string {
+	names := make([]string, 0, len(DRIVER_BUNDLES))
+	for name := range DRIVER_BUNDLES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Directory drivers are installed into: the JDBC drivers dir when set, else
+// the lib dir of the managed install, which is on the classpath
+func (pl *GoLiquibase) driversDir() (string, error) {
+	if pl.JdbcDriversDir != "" {
+		return pl.JdbcDriversDir, nil
+	}
+	installMu.Lock()
+	managed, libDir := pl.managedInstall, pl.LiquibaseLibDir
+	installMu.Unlock()
+	if !managed {
+		return "", fmt.Errorf("set a JDBC drivers dir to install drivers for a user provided Liquibase install")
+	}
+	return libDir, nil
+}
+
+// InstallDrivers downloads the named driver bundles and verifies them
+// against the checksums Maven Central publishes. Bundles whose license must
+// be accepted fail with a LicenseError unless their name is in
+// AcceptedLicenses. Returns the bundles installed.
+func (pl *GoLiquibase) InstallDrivers(ctx context.Context, names ...string) ([]*DriverBundle, error) {
+	dir, err := pl.driversDir()
+	if err != nil {
+		return nil, err
+	}
+	return pl.installDrivers(ctx, dir, names)
+}
+
+// Install driver bundles into a directory
+func (pl *GoLiquibase) installDrivers(ctx context.Context, dir string, names []string) ([]*DriverBundle, error) {
+	var bundles []*DriverBundle
+	for _, name := range names {
+		bundle, ok := DRIVER_BUNDLES[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown driver %s, expecting one of %s", name, strings.Join(DriverBundleNames(), ", "))
+		}
+		if bundle.AcceptLicense && !containsFold(pl.AcceptedLicenses, bundle.Name) {
+			return nil, &LicenseError{Bundle: bundle}
+		}
+		bundles = append(bundles, bundle)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for _, bundle := range bundles {
+		for _, artifact := range bundle.Artifacts {
+			if err := pl.downloadArtifact(ctx, artifact, dir); err != nil {
+				return nil, fmt.Errorf("failed to install the %s driver: %v", bundle.Name, err)
+			}
+		}
+	}
+	return bundles, nil
+}
+
+// Download a jar from Maven Central unless it is already there, checking its sha1
+func (pl *GoLiquibase) downloadArtifact(ctx context.Context, artifact MavenArtifact, dir string) error {
+	destination := filepath.Join(dir, artifact.FileName())
+	if fileExists(destination) {
+		pl.logger().Printf("Driver already available, skipping download: %s", destination)
+		return nil
+	}
+	partial := destination + ".part"
+	defer os.Remove(partial)
+	if err := pl.downloadFile(ctx, artifact.URL(), partial); err != nil {
+		return err
+	}
+
+	checksumFile := partial + ".sha1"
+	defer os.Remove(checksumFile)
+	if err := pl.downloadFile(ctx, artifact.URL()+".sha1", checksumFile); err != nil {
+		return err
+	}
+	expected, err := os.ReadFile(checksumFile)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(expected))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum for %s", artifact.FileName())
+	}
+	actual, err := sha1File(partial)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fields[0], actual) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", artifact.FileName(), fields[0], actual)
+	}
+	return os.Rename(partial, destination)
+}
+
+// Compute the hex sha1 of a file
+func sha1File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
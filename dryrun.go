@@ -0,0 +1,73 @@
+package goliquify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SQL variants of mutating commands, used by --dry-run. Both the kebab-case and
+// the older camelCase command names are accepted.
+var DRY_RUN_COMMANDS = map[string]string{
+	"update":                  "update-sql",
+	"update-count":            "update-count-sql",
+	"updateCount":             "update-count-sql",
+	"update-to-tag":           "update-to-tag-sql",
+	"updateToTag":             "update-to-tag-sql",
+	"rollback":                "rollback-sql",
+	"rollback-count":          "rollback-count-sql",
+	"rollbackCount":           "rollback-count-sql",
+	"rollback-to-date":        "rollback-to-date-sql",
+	"rollbackToDate":          "rollback-to-date-sql",
+	"changelog-sync":          "changelog-sync-sql",
+	"changelogSync":           "changelog-sync-sql",
+	"changelog-sync-to-tag":   "changelog-sync-to-tag-sql",
+	"changelogSyncToTag":      "changelog-sync-to-tag-sql",
+	"mark-next-changeset-ran": "mark-next-changeset-ran-sql",
+	"markNextChangeSetRan":    "mark-next-changeset-ran-sql",
+}
+
+// Commands that only read the database, which a dry run passes through as
+// they are, like the SQL variants
+var DRY_RUN_READ_ONLY = []string{"", "status", "history", "validate", "diff", "diff-changelog", "diffChangeLog", "generate-changelog", "generateChangeLog",
+	"snapshot", "snapshot-reference", "snapshotReference", "list-locks", "listLocks", "tag-exists", "tagExists", "db-doc", "dbDoc",
+	"calculate-checksum", "calculateCheckSum", "unexpected-changesets", "unexpectedChangeSets", "connect", "help"}
+
+// Replace the command in the arguments with its SQL variant, returning both.
+// Commands that are neither mapped nor known to only read the database are
+// refused.
+func (pl *GoLiquibase) dryRunArguments(command string, arguments []string) (string, []string, error) {
+	if readOnlyCommand(command) {
+		return command, arguments, nil
+	}
+	sqlCommand, ok := DRY_RUN_COMMANDS[command]
+	if !ok {
+		return "", nil, fmt.Errorf("%s can change the database and has no SQL variant to preview with --dry-run", command)
+	}
+
+	if sqlCommand != command {
+		pl.logger().Printf("Dry run: %s runs as %s, the database is not changed", command, sqlCommand)
+	}
+	return sqlCommand, replaceCommand(arguments, command, sqlCommand), nil
+}
+
+// Copy the arguments with the command replaced by another one
+func replaceCommand(arguments []string, command, replacement string) []string {
+	replaced := append([]string{}, arguments...)
+	for i, arg := range replaced {
+		if arg == command {
+			replaced[i] = replacement
+			break
+		}
+	}
+	return replaced
+}
+
+// Whether a command only reads the database. Besides DRY_RUN_READ_ONLY
+// those are the SQL variants, every command ending in -sql or SQL except
+// execute-sql, which runs its SQL.
+func readOnlyCommand(command string) bool {
+	if strings.EqualFold(strings.ReplaceAll(command, "-", ""), "executesql") {
+		return false
+	}
+	return containsString(DRY_RUN_READ_ONLY, command) || strings.HasSuffix(command, "-sql") || strings.HasSuffix(command, "SQL")
+}
@@ -0,0 +1,89 @@
+package goliquify_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+func TestDryRunDoesntExecuteSQL(t *testing.T) {
+	pl, runner := goliquifytest.New(t, goliquify.WithDryRun(true))
+	for name, run := range map[string]func() error{
+		"execute-sql": func() error { return pl.Execute("execute-sql", "--sql=DELETE FROM orders") },
+		"executeSql":  func() error { return pl.Execute("executeSql", "--sql=DELETE FROM orders") },
+		"ExecuteSQL": func() error {
+			_, err := pl.ExecuteSQL(context.Background(), "DELETE FROM orders", goliquify.ExecuteSQLOptions{})
+			return err
+		},
+		"ClearChecksum": func() error { return pl.ClearChecksum("db/changelog.xml::1::bob") },
+	} {
+		if err := run(); err == nil {
+			t.Errorf("%s succeeded under a dry run", name)
+		}
+	}
+	if invocations := runner.Invocations(); len(invocations) != 0 {
+		t.Fatalf("dry run ran %v", invocations)
+	}
+}
+
+func TestDryRunReadsDeployedVersions(t *testing.T) {
+	pl, runner := goliquifytest.New(t, goliquify.WithDryRun(true))
+	runner.On("execute-sql", goliquifytest.Response{Stdout: "v1.2.0 |\n"})
+	next, err := pl.NextRelease(goliquify.BUMP_MINOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.String() != "v1.3.0" {
+		t.Fatalf("next release %s, want v1.3.0", next)
+	}
+	if !pl.DryRun {
+		t.Fatal("reading the versions ended the dry run")
+	}
+}
+
+func TestDryRunRunsOnlyPreviewsAndReads(t *testing.T) {
+	pl, runner := goliquifytest.New(t, goliquify.WithDryRun(true))
+	for _, args := range [][]string{
+		// Commands without a SQL variant
+		{"update-one-changeset", "--changeset-id=1"},
+		{"rollback-one-changeset", "--changeset-id=1"},
+		{"rollback-one-update", "--deployment-id=1"},
+		{"set-labels", "--set-as=prod"},
+		{"set-contexts", "--set-as=prod"},
+		{"flow"},
+		// A global option value mistaken for the command
+		{"--log-level", "info", "update"},
+		{"--", "--log-level", "info", "update"},
+	} {
+		if err := pl.Execute(args...); err == nil {
+			t.Errorf("%v succeeded under a dry run", args)
+		}
+	}
+	if invocations := runner.Invocations(); len(invocations) != 0 {
+		t.Fatalf("dry run ran %v", invocations)
+	}
+
+	for _, args := range [][]string{{"status", "--verbose"}, {"--log-level=info", "update"}, {"future-rollback-sql"}, {"--version"}} {
+		if err := pl.Execute(args...); err != nil {
+			t.Errorf("%v failed under a dry run: %v", args, err)
+		}
+	}
+	if commands, want := runner.Commands(), []string{"status", "update-sql", "future-rollback-sql", ""}; strings.Join(commands, ",") != strings.Join(want, ",") {
+		t.Fatalf("ran %q, want %q", commands, want)
+	}
+}
+
+func TestOptionValuesAreNeverTheCommand(t *testing.T) {
+	pl, runner := goliquifytest.New(t)
+	for _, args := range [][]string{{"--log-level", "info", "update"}, {"--", "update"}} {
+		if err := pl.Execute(args...); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+	if invocations := runner.Invocations(); len(invocations) != 0 {
+		t.Fatalf("ran %v", invocations)
+	}
+}
@@ -0,0 +1,84 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Java charset names, e.g. UTF-8, windows-1252 or ISO-8859-1
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:+-]*$`)
+
+// Locales as en, en-US or en_US
+var localePattern = regexp.MustCompile(`^([A-Za-z]{2,3})(?:[-_]([A-Za-z]{2}|[0-9]{3}))?$`)
+
+// EncodingOptions set the encodings and locale of Liquibase, for changelogs
+// with non-ASCII identifiers and data on hosts whose default encoding isn't
+// UTF-8, like many Windows CI agents
+type EncodingOptions struct {
+	// FileEncoding Liquibase reads changelogs and SQL files with, and the
+	// default encoding of the JVM, e.g. UTF-8
+	FileEncoding string `yaml:"fileEncoding"`
+	// OutputFileEncoding of the files Liquibase writes, e.g. update-sql output
+	OutputFileEncoding string `yaml:"outputFileEncoding"`
+	// Locale of the JVM, e.g. en-US, which formats numbers and dates and
+	// lower cases identifiers
+	Locale string `yaml:"locale"`
+}
+
+// Merge returns the options with the set fields of other over them
+func (o EncodingOptions) Merge(other EncodingOptions) EncodingOptions {
+	o.FileEncoding = firstNonEmpty(other.FileEncoding, o.FileEncoding)
+	o.OutputFileEncoding = firstNonEmpty(other.OutputFileEncoding, o.OutputFileEncoding)
+	o.Locale = firstNonEmpty(other.Locale, o.Locale)
+	return o
+}
+
+// Validate checks the encoding names and the locale
+func (o EncodingOptions) Validate() error {
+	for _, charset := range []string{o.FileEncoding, o.OutputFileEncoding} {
+		if charset != "" && !charsetPattern.MatchString(charset) {
+			return fmt.Errorf("invalid encoding %q", charset)
+		}
+	}
+	if o.Locale != "" && !localePattern.MatchString(o.Locale) {
+		return fmt.Errorf("invalid locale %q, expecting e.g. en or en-US", o.Locale)
+	}
+	return nil
+}
+
+// System properties of the JVM for the options
+func (o EncodingOptions) javaOptions() []string {
+	var opts []string
+	if o.FileEncoding != "" {
+		// The console encodings keep Liquibase's output readable as well
+		opts = append(opts,
+			"-Dfile.encoding="+o.FileEncoding,
+			"-Dstdout.encoding="+o.FileEncoding,
+			"-Dstderr.encoding="+o.FileEncoding,
+			"-Dliquibase.fileEncoding="+o.FileEncoding)
+	}
+	if m := localePattern.FindStringSubmatch(o.Locale); m != nil {
+		opts = append(opts, "-Duser.language="+strings.ToLower(m[1]))
+		if m[2] != "" {
+			opts = append(opts, "-Duser.country="+strings.ToUpper(m[2]))
+		}
+	}
+	return opts
+}
+
+// Add options to the JAVA_OPTS of a command, after the ones it inherits
+func addJavaOptions(cmd *Command, opts []string) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	current := ""
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "JAVA_OPTS="); ok {
+			current = v
+		}
+	}
+	cmd.Env = append(env, "JAVA_OPTS="+strings.TrimSpace(current+" "+strings.Join(opts, " ")))
+}
@@ -0,0 +1,212 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ENTRA_MANAGED_IDENTITY    = "managed-identity"
+	ENTRA_SERVICE_PRINCIPAL   = "service-principal"
+	ENTRA_AUTHORITY           = "https://login.microsoftonline.com"
+	ENTRA_SQL_RESOURCE        = "https://database.windows.net/"
+	ENTRA_IMDS_TOKEN_ENDPOINT = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// Tokens this close to expiring are refreshed before a run
+	ENTRA_TOKEN_REFRESH_MARGIN = 5 * time.Minute
+)
+
+// Failures of a login with an expired or revoked access token
+var entraLoginFailurePattern = regexp.MustCompile(`(?i)login failed for user '<token-identified principal>'|token is expired|AADSTS\d+`)
+
+// Failed run Azure SQL rejected the access token of
+var errAccessTokenRejected = errors.New("azure sql rejected the access token")
+
+// EntraConfig logs in to Azure SQL with a Microsoft Entra ID access token
+// instead of a password, acquired for a managed identity or a service
+// principal and passed to the driver as its accessToken property
+type EntraConfig struct {
+	// Method is managed-identity or service-principal
+	Method string `yaml:"method"`
+	// ClientID of a user-assigned managed identity, the system-assigned one
+	// when empty, or of the service principal
+	ClientID string `yaml:"clientId"`
+	// TenantID and the variable holding the secret of the service principal
+	TenantID        string `yaml:"tenantId"`
+	ClientSecretEnv string `yaml:"clientSecretEnv"`
+	// Authority is the login host, ENTRA_AUTHORITY by default, for
+	// sovereign clouds
+	Authority string `yaml:"authority"`
+	// Resource the token is for, ENTRA_SQL_RESOURCE by default
+	Resource string `yaml:"resource"`
+
+	// The token is reused by the runs until it is about to expire
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Validate checks the method has what it needs
+func (c *EntraConfig) Validate() error {
+	switch c.Method {
+	case ENTRA_MANAGED_IDENTITY:
+	case ENTRA_SERVICE_PRINCIPAL:
+		if c.TenantID == "" || c.ClientID == "" || c.ClientSecretEnv == "" {
+			return fmt.Errorf("entra service-principal needs a tenantId, clientId and clientSecretEnv")
+		}
+	default:
+		return fmt.Errorf("unknown entra method %q, expecting managed-identity or service-principal", c.Method)
+	}
+	return nil
+}
+
+// AccessToken returns a token for the resource, reusing the last one while
+// it is valid
+func (c *EntraConfig) AccessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expires) > ENTRA_TOKEN_REFRESH_MARGIN {
+		return c.token, nil
+	}
+	var req *http.Request
+	var err error
+	if c.Method == ENTRA_SERVICE_PRINCIPAL {
+		req, err = c.servicePrincipalRequest(ctx)
+	} else {
+		req, err = c.managedIdentityRequest(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire an entra access token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var token struct {
+		AccessToken string `json:"access_token"`
+		// A number from the login host, a string from managed identity endpoints
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	json.Unmarshal(body, &token)
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		description := firstNonEmpty(token.ErrorDescription, strings.TrimSpace(string(body)))
+		return "", fmt.Errorf("failed to acquire an entra access token: %s: %s", resp.Status, description)
+	}
+	seconds, _ := strconv.Atoi(strings.Trim(string(token.ExpiresIn), `"`))
+	c.token, c.expires = token.AccessToken, time.Now().Add(time.Duration(seconds)*time.Second)
+	return c.token, nil
+}
+
+// Forget the token, the next run acquires a fresh one
+func (c *EntraConfig) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+func (c *EntraConfig) resource() string {
+	return firstNonEmpty(c.Resource, ENTRA_SQL_RESOURCE)
+}
+
+// The client credentials request of a service principal
+func (c *EntraConfig) servicePrincipalRequest(ctx context.Context) (*http.Request, error) {
+	secret := os.Getenv(c.ClientSecretEnv)
+	if secret == "" {
+		return nil, fmt.Errorf("the entra client secret variable %s is not set", c.ClientSecretEnv)
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.ClientID},
+		"client_secret": {secret},
+		"scope":         {strings.TrimSuffix(c.resource(), "/") + "/.default"},
+	}
+	endpoint := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(firstNonEmpty(c.Authority, ENTRA_AUTHORITY), "/"), url.PathEscape(c.TenantID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// The token request of a managed identity: the App Service and Functions
+// endpoint when the platform sets one, the instance metadata service of VMs
+// and AKS otherwise
+func (c *EntraConfig) managedIdentityRequest(ctx context.Context) (*http.Request, error) {
+	query := url.Values{"resource": {c.resource()}}
+	if c.ClientID != "" {
+		query.Set("client_id", c.ClientID)
+	}
+	endpoint, header := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER")
+	if endpoint != "" && header != "" {
+		query.Set("api-version", "2019-08-01")
+	} else {
+		endpoint = ENTRA_IMDS_TOKEN_ENDPOINT
+		query.Set("api-version", "2018-02-01")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if header != "" && endpoint != ENTRA_IMDS_TOKEN_ENDPOINT {
+		req.Header.Set("X-IDENTITY-HEADER", header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+	return req, nil
+}
+
+// Replace the rejected access token of a connection with a fresh one, for
+// the command to log in again
+func (c *connection) refreshAccessToken(ctx context.Context, entra *EntraConfig) error {
+	entra.invalidate()
+	token, err := entra.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	c.driverProps["accessToken"] = token
+	c.entra.reset()
+	return c.writeDriverProperties()
+}
+
+// Watches the output of a run for Azure SQL rejecting its access token
+type entraLoginObserver struct {
+	mu       sync.Mutex
+	rejected bool
+}
+
+func (o *entraLoginObserver) observe(line string) {
+	if entraLoginFailurePattern.MatchString(line) {
+		o.mu.Lock()
+		o.rejected = true
+		o.mu.Unlock()
+	}
+}
+
+func (o *entraLoginObserver) reset() {
+	o.mu.Lock()
+	o.rejected = false
+	o.mu.Unlock()
+}
+
+func (o *entraLoginObserver) tokenRejected() bool {
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.rejected
+}
@@ -0,0 +1,97 @@
+package goliquify_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []goliquify.Event
+}
+
+func (l *eventLog) Send(event goliquify.Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+// Records the access token each command logs in with
+type tokenSpy struct {
+	*goliquifytest.Runner
+	used []string
+}
+
+func (s *tokenSpy) Run(ctx context.Context, cmd *goliquify.Command) error {
+	for _, arg := range cmd.Args {
+		if file, ok := strings.CutPrefix(arg, "--driver-properties-file="); ok {
+			data, _ := os.ReadFile(file)
+			s.used = append(s.used, strings.TrimSpace(string(data)))
+		}
+	}
+	return s.Runner.Run(ctx, cmd)
+}
+
+func TestRejectedAccessTokenOnlyRerunsTheCommand(t *testing.T) {
+	var tokens int
+	login := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		fmt.Fprintf(w, `{"access_token": "token%d", "expires_in": 3600}`, tokens)
+	}))
+	defer login.Close()
+	t.Setenv("ENTRA_TEST_SECRET", "secret")
+
+	dir := t.TempDir()
+	defaults := filepath.Join(dir, "liquibase.properties")
+	if err := os.WriteFile(defaults, []byte("url: jdbc:sqlserver://app.database.windows.net:1433;databaseName=app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journal := filepath.Join(dir, "journal.jsonl")
+	events := &eventLog{}
+	runner := goliquifytest.NewRunner()
+	spy := &tokenSpy{Runner: runner}
+	pl, _ := goliquifytest.New(t, goliquify.WithRunner(spy), goliquify.WithDefaultsFile(defaults), goliquify.WithJournal(journal), goliquify.WithEventSink(events),
+		goliquify.WithEntra(&goliquify.EntraConfig{Method: goliquify.ENTRA_SERVICE_PRINCIPAL, TenantID: "tenant", ClientID: "client",
+			ClientSecretEnv: "ENTRA_TEST_SECRET", Authority: login.URL}))
+
+	runner.Once("update", goliquifytest.Response{Stderr: "Login failed for user '<token-identified principal>'.\n", ExitCode: 1})
+
+	if err := pl.Execute("update"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"accessToken=token1", "accessToken=token2"}; strings.Join(spy.used, ",") != strings.Join(want, ",") {
+		t.Fatalf("logged in with %q, want %q", spy.used, want)
+	}
+	if commands := runner.Commands(); len(commands) != 2 {
+		t.Fatalf("ran %v, want update twice", commands)
+	}
+
+	// The retry is part of the one run
+	records, err := goliquify.ReadJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Status != goliquify.EVENT_COMPLETED {
+		t.Fatalf("journal %+v, want one completed run", records)
+	}
+	var started int
+	for _, event := range events.events {
+		if event.Type == goliquify.EVENT_STARTED {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Fatalf("%d started events, want 1", started)
+	}
+}
@@ -0,0 +1,263 @@
+package goliquify
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// ERD formats
+const (
+	ERD_MERMAID  = "mermaid"
+	ERD_PLANTUML = "plantuml"
+	ERD_SVG      = "svg"
+)
+
+var ERD_FORMATS = []string{ERD_MERMAID, ERD_PLANTUML, ERD_SVG}
+
+// Characters Mermaid and PlantUML don't take in names and types
+var diagramUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// ERDOptions select the tables of a diagram
+type ERDOptions struct {
+	// Schemas whose tables are drawn, every schema when empty
+	Schemas []string
+	// Tables drawn, every table when empty
+	Tables []string
+}
+
+// GenerateERD draws the tables of a schema model and the foreign keys
+// between them as a Mermaid or PlantUML entity relationship diagram, or as
+// an SVG image
+func GenerateERD(model *SchemaModel, format string, opts ERDOptions) ([]byte, error) {
+	var tables []*TableModel
+	for _, name := range model.TableNames() {
+		t := model.Tables[name]
+		if len(opts.Schemas) > 0 && !containsFold(opts.Schemas, t.Schema) {
+			continue
+		}
+		if len(opts.Tables) > 0 && !containsFold(opts.Tables, t.Name) {
+			continue
+		}
+		tables = append(tables, t)
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no tables to draw")
+	}
+	switch format {
+	case ERD_MERMAID:
+		return generateMermaid(tables), nil
+	case ERD_PLANTUML:
+		return generatePlantUML(tables), nil
+	case ERD_SVG:
+		return generateSVG(tables), nil
+	}
+	return nil, fmt.Errorf("unknown erd format %q, expecting one of %s", format, strings.Join(ERD_FORMATS, ", "))
+}
+
+// A foreign key between two drawn tables
+type erdRelation struct {
+	from, to *TableModel
+	fk       *ForeignKeyModel
+	// optional when a column of the foreign key is nullable
+	optional bool
+}
+
+func erdRelations(tables []*TableModel) []erdRelation {
+	byName := map[string]*TableModel{}
+	for _, t := range tables {
+		byName[strings.ToLower(t.Name)] = t
+	}
+	var relations []erdRelation
+	for _, t := range tables {
+		for _, fk := range t.ForeignKeys {
+			to, ok := byName[strings.ToLower(fk.RefTable)]
+			if !ok {
+				continue
+			}
+			r := erdRelation{from: t, to: to, fk: fk}
+			for _, c := range t.Columns {
+				if c.Nullable && containsString(fk.Columns, c.Name) {
+					r.optional = true
+				}
+			}
+			relations = append(relations, r)
+		}
+	}
+	return relations
+}
+
+// The key markers of a column: PK, FK and UK
+func columnKeys(t *TableModel, column string) []string {
+	var keys []string
+	if containsString(t.PrimaryKey, column) {
+		keys = append(keys, "PK")
+	}
+	for _, fk := range t.ForeignKeys {
+		if containsString(fk.Columns, column) {
+			keys = append(keys, "FK")
+			break
+		}
+	}
+	for _, index := range t.Indexes {
+		if index.Unique && len(index.Columns) == 1 && index.Columns[0] == column {
+			keys = append(keys, "UK")
+			break
+		}
+	}
+	return keys
+}
+
+// A name Mermaid and PlantUML take as an identifier
+func diagramName(t *TableModel) string {
+	return strings.Trim(diagramUnsafe.ReplaceAllString(t.Name, "_"), "_")
+}
+
+func generateMermaid(tables []*TableModel) []byte {
+	var out bytes.Buffer
+	out.WriteString("erDiagram\n")
+	for _, t := range tables {
+		fmt.Fprintf(&out, "    %s {\n", diagramName(t))
+		for _, c := range t.Columns {
+			line := fmt.Sprintf("        %s %s", strings.Trim(diagramUnsafe.ReplaceAllString(c.Type, "_"), "_"), diagramUnsafe.ReplaceAllString(c.Name, "_"))
+			if keys := columnKeys(t, c.Name); len(keys) > 0 {
+				line += " " + strings.Join(keys, ",")
+			}
+			out.WriteString(line + "\n")
+		}
+		out.WriteString("    }\n")
+	}
+	for _, r := range erdRelations(tables) {
+		parent := "||"
+		if r.optional {
+			parent = "|o"
+		}
+		fmt.Fprintf(&out, "    %s %s--o{ %s : %q\n", diagramName(r.to), parent, diagramName(r.from), r.fk.Name)
+	}
+	return out.Bytes()
+}
+
+func generatePlantUML(tables []*TableModel) []byte {
+	var out bytes.Buffer
+	out.WriteString("@startuml\nhide circle\nskinparam linetype ortho\n")
+	for _, t := range tables {
+		fmt.Fprintf(&out, "\nentity %q as %s {\n", t.Name, diagramName(t))
+		var keys, others []string
+		for _, c := range t.Columns {
+			line := fmt.Sprintf("  %s : %s", c.Name, c.Type)
+			if !c.Nullable {
+				line = "  * " + strings.TrimSpace(line)
+			}
+			if markers := columnKeys(t, c.Name); len(markers) > 0 {
+				line += " <<" + strings.Join(markers, ",") + ">>"
+			}
+			if containsString(t.PrimaryKey, c.Name) {
+				keys = append(keys, line)
+			} else {
+				others = append(others, line)
+			}
+		}
+		for _, line := range keys {
+			out.WriteString(line + "\n")
+		}
+		if len(keys) > 0 {
+			out.WriteString("  --\n")
+		}
+		for _, line := range others {
+			out.WriteString(line + "\n")
+		}
+		out.WriteString("}\n")
+	}
+	out.WriteString("\n")
+	for _, r := range erdRelations(tables) {
+		parent := "||"
+		if r.optional {
+			parent = "|o"
+		}
+		fmt.Fprintf(&out, "%s %s..o{ %s : %s\n", diagramName(r.to), parent, diagramName(r.from), r.fk.Name)
+	}
+	out.WriteString("@enduml\n")
+	return out.Bytes()
+}
+
+// Sizes of the SVG boxes, in pixels
+const (
+	svgBoxWidth  = 260
+	svgRowHeight = 18
+	svgGap       = 60
+)
+
+// Draw the tables as boxes laid out on a grid, with a line per foreign key
+// from the referencing table to the referenced one
+func generateSVG(tables []*TableModel) []byte {
+	columns := int(math.Ceil(math.Sqrt(float64(len(tables)))))
+	type box struct{ x, y, h int }
+	boxes := map[*TableModel]box{}
+	rowHeights := map[int]int{}
+	for i, t := range tables {
+		if h := svgRowHeight * (len(t.Columns) + 1); h > rowHeights[i/columns] {
+			rowHeights[i/columns] = h
+		}
+	}
+	y, width, height := svgGap/2, 0, 0
+	for i, t := range tables {
+		row := i / columns
+		if i > 0 && i%columns == 0 {
+			y += rowHeights[row-1] + svgGap
+		}
+		b := box{x: svgGap/2 + (i%columns)*(svgBoxWidth+svgGap), y: y, h: svgRowHeight * (len(t.Columns) + 1)}
+		boxes[t] = b
+		width = max(width, b.x+svgBoxWidth+svgGap/2)
+		height = max(height, b.y+b.h+svgGap/2)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"monospace\" font-size=\"12\">\n", width, height, width, height)
+	out.WriteString("  <defs><marker id=\"arrow\" viewBox=\"0 0 10 10\" refX=\"10\" refY=\"5\" markerWidth=\"8\" markerHeight=\"8\" orient=\"auto\"><path d=\"M0,0 L10,5 L0,10 z\" fill=\"#555\"/></marker></defs>\n")
+	out.WriteString("  <rect width=\"100%\" height=\"100%\" fill=\"white\"/>\n")
+	for _, r := range erdRelations(tables) {
+		from, to := boxes[r.from], boxes[r.to]
+		x1, y1 := from.x+svgBoxWidth/2, from.y+from.h/2
+		x2, y2 := to.x+svgBoxWidth/2, to.y+to.h/2
+		// Attach the line to the sides facing each other
+		switch {
+		case from.x+svgBoxWidth < to.x:
+			x1, x2 = from.x+svgBoxWidth, to.x
+		case to.x+svgBoxWidth < from.x:
+			x1, x2 = from.x, to.x+svgBoxWidth
+		case from.y < to.y:
+			y1, y2 = from.y+from.h, to.y
+		case from.y > to.y:
+			y1, y2 = from.y, to.y+to.h
+		default:
+			// A table referencing itself
+			x1, y1, x2, y2 = from.x+svgBoxWidth, from.y+svgRowHeight/2, from.x+svgBoxWidth, from.y+from.h-svgRowHeight/2
+		}
+		dash := ""
+		if r.optional {
+			dash = " stroke-dasharray=\"4 3\""
+		}
+		fmt.Fprintf(&out, "  <line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#555\"%s marker-end=\"url(#arrow)\"><title>%s</title></line>\n", x1, y1, x2, y2, dash, html.EscapeString(r.fk.Name))
+	}
+	for _, t := range tables {
+		b := boxes[t]
+		fmt.Fprintf(&out, "  <g>\n    <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#fff\" stroke=\"#333\"/>\n", b.x, b.y, svgBoxWidth, b.h)
+		fmt.Fprintf(&out, "    <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#2f5d8a\"/>\n", b.x, b.y, svgBoxWidth, svgRowHeight)
+		fmt.Fprintf(&out, "    <text x=\"%d\" y=\"%d\" fill=\"#fff\" font-weight=\"bold\">%s</text>\n", b.x+6, b.y+13, html.EscapeString(t.Name))
+		for i, c := range t.Columns {
+			name := c.Name
+			if keys := columnKeys(t, c.Name); len(keys) > 0 {
+				name += " (" + strings.Join(keys, ",") + ")"
+			}
+			rowY := b.y + svgRowHeight*(i+1) + 13
+			fmt.Fprintf(&out, "    <text x=\"%d\" y=\"%d\">%s</text>\n", b.x+6, rowY, html.EscapeString(truncate(name, 24)))
+			fmt.Fprintf(&out, "    <text x=\"%d\" y=\"%d\" text-anchor=\"end\" fill=\"#666\">%s</text>\n", b.x+svgBoxWidth-6, rowY, html.EscapeString(truncate(c.Type, 14)))
+		}
+		out.WriteString("  </g>\n")
+	}
+	out.WriteString("</svg>\n")
+	return out.Bytes()
+}
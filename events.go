@@ -0,0 +1,190 @@
+package goliquify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_STARTED   = "started"
+	EVENT_HEARTBEAT = "heartbeat"
+	EVENT_COMPLETED = "completed"
+	EVENT_FAILED    = "failed"
+	// A changeset was applied, only sent to sinks subscribing to them
+	EVENT_CHANGESET = "changeset"
+)
+
+// Liquibase prints "Running Changeset: <file>::<id>::<author>" before applying a changeset
+var runningChangesetPattern = regexp.MustCompile(`Running Changeset: ?(.+::.+::.+)$`)
+
+// Event describes a step in the life of a Liquibase run
+type Event struct {
+	Type    string    `json:"type"`
+	RunID   string    `json:"runId,omitempty"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	// Environment of the run, when it has one
+	Environment string `json:"environment,omitempty"`
+	// Schedule is the scheduled operation the run belongs to
+	Schedule  string      `json:"schedule,omitempty"`
+	ElapsedMs int64       `json:"elapsedMs,omitempty"`
+	Changeset string      `json:"changeset,omitempty"`
+	LastLine  string      `json:"lastLine,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	CI        *CIMetadata `json:"ci,omitempty"`
+	// LogFile is the run log holding the full output, if output is logged
+	LogFile string `json:"logFile,omitempty"`
+	// Differences a drift check found
+	Differences int `json:"differences,omitempty"`
+}
+
+// EventSink receives run events
+type EventSink interface {
+	Send(event Event) error
+}
+
+// ChangesetEventSink is a sink receiving an event per applied changeset.
+// Liquibase only logs applied changesets at info level, which runs with
+// such a sink are switched to.
+type ChangesetEventSink interface {
+	EventSink
+	ChangesetEvents() bool
+}
+
+// WebhookSink posts events as JSON to a URL
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Send posts the event to the webhook
+func (w *WebhookSink) Send(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Post(w.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, response.Status)
+	}
+	return nil
+}
+
+// Send an event to every sink. Failing sinks are logged and never fail the run.
+func (pl *GoLiquibase) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.CI == nil {
+		event.CI = pl.CI
+	}
+	if event.Environment == "" {
+		event.Environment = pl.Environment
+	}
+	for _, sink := range pl.EventSinks {
+		if s, ok := sink.(ChangesetEventSink); event.Type == EVENT_CHANGESET && (!ok || !s.ChangesetEvents()) {
+			continue
+		}
+		if err := sink.Send(event); err != nil {
+			pl.logger().Printf("Failed to send %s event: %v", event.Type, err)
+		}
+	}
+}
+
+// SendEvent sends an event to the sinks of the instance, for checks that
+// run without Liquibase like drift detection. Failing sinks are logged.
+func (pl *GoLiquibase) SendEvent(event Event) {
+	pl.emit(event)
+}
+
+// Check if a sink subscribes to changeset events
+func (pl *GoLiquibase) changesetEvents() bool {
+	for _, sink := range pl.EventSinks {
+		if s, ok := sink.(ChangesetEventSink); ok && s.ChangesetEvents() {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit an event for every changeset Liquibase reports applied
+func (pl *GoLiquibase) changesetObserver(runID, command string) func(string) {
+	return func(line string) {
+		m := changesetRanPattern.FindStringSubmatch(line)
+		if m == nil {
+			return
+		}
+		elapsed, _ := strconv.ParseInt(m[4], 10, 64)
+		pl.emit(Event{
+			Type:      EVENT_CHANGESET,
+			RunID:     runID,
+			Command:   command,
+			Changeset: fmt.Sprintf("%s::%s::%s", m[1], m[2], m[3]),
+			ElapsedMs: elapsed,
+		})
+	}
+}
+
+// progressTracker remembers the last output line and the changeset being applied
+type progressTracker struct {
+	mu        sync.Mutex
+	lastLine  string
+	changeset string
+}
+
+func (p *progressTracker) observe(line string) {
+	if line == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastLine = line
+	if m := runningChangesetPattern.FindStringSubmatch(line); m != nil {
+		p.changeset = m[1]
+	}
+}
+
+func (p *progressTracker) snapshot() (string, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastLine, p.changeset
+}
+
+// Emit a heartbeat every interval until stop is closed, so slow runs can be told apart from hung ones
+func (pl *GoLiquibase) heartbeat(runID, command string, start time.Time, interval time.Duration, progress *progressTracker, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			lastLine, changeset := progress.snapshot()
+			elapsed := now.Sub(start).Round(time.Second)
+			pl.logger().Printf("Heartbeat: liquibase %s running for %s, changeset: %s, last output: %s", command, elapsed, changeset, lastLine)
+			pl.emit(Event{
+				Type:      EVENT_HEARTBEAT,
+				RunID:     runID,
+				Time:      now,
+				Command:   command,
+				ElapsedMs: elapsed.Milliseconds(),
+				Changeset: changeset,
+				LastLine:  lastLine,
+			})
+		}
+	}
+}
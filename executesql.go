@@ -0,0 +1,119 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SQLResult is the result set of one query run with execute-sql
+type SQLResult struct {
+	// Statement is the query as Liquibase echoes it
+	Statement string `json:"statement"`
+	// Columns are sorted by name, the order Liquibase prints them in
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// ExecuteSQLOptions configure ExecuteSQL
+type ExecuteSQLOptions struct {
+	// File is a SQL file run instead of the sql argument
+	File string
+	// Delimiter between statements, Liquibase's own default when empty
+	Delimiter string
+	// Stdout also receives the output of Liquibase as it runs
+	Stdout io.Writer
+}
+
+// ExecuteSQL runs SQL with Liquibase's execute-sql command, on the
+// connection, credentials and guardrails of the migrations, and returns the
+// result sets of its queries. Statements that aren't queries have none.
+func (pl *GoLiquibase) ExecuteSQL(ctx context.Context, sql string, opts ExecuteSQLOptions) ([]SQLResult, error) {
+	args := []string{"execute-sql"}
+	switch {
+	case opts.File != "" && sql != "":
+		return nil, fmt.Errorf("pass either SQL or a SQL file, not both")
+	case opts.File != "":
+		args = append(args, "--sql-file="+opts.File)
+	case strings.TrimSpace(sql) != "":
+		args = append(args, "--sql="+sql)
+	default:
+		return nil, fmt.Errorf("no SQL to execute")
+	}
+	if opts.Delimiter != "" {
+		args = append(args, "--delimiter="+opts.Delimiter)
+	}
+	var stdout bytes.Buffer
+	var out io.Writer = &stdout
+	if opts.Stdout != nil {
+		out = io.MultiWriter(&stdout, opts.Stdout)
+	}
+	if err := pl.ExecuteWithOptions(ctx, ExecOptions{Stdout: out}, args...); err != nil {
+		return nil, err
+	}
+	return ParseExecuteSQLOutput(stdout.String()), nil
+}
+
+// ParseExecuteSQLOutput reads the result sets out of execute-sql output.
+// Every query prints an "Output of <query>:" header, a line of column names
+// and a line per row, with values separated by " | ". Values holding that
+// separator themselves can't be told apart.
+func ParseExecuteSQLOutput(output string) []SQLResult {
+	var results []SQLResult
+	var current *SQLResult
+	var statement []string
+	inStatement := false
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "Output of "); ok {
+			results = append(results, SQLResult{})
+			current, statement, inStatement = &results[len(results)-1], nil, true
+			line = rest
+		}
+		if current == nil {
+			continue
+		}
+		// The query may span lines, up to the one ending with a colon
+		if inStatement {
+			statement = append(statement, line)
+			if strings.HasSuffix(strings.TrimSpace(line), ":") {
+				current.Statement = strings.TrimSuffix(strings.TrimSpace(strings.Join(statement, "\n")), ":")
+				inStatement = false
+			}
+			continue
+		}
+		if !strings.Contains(line, "|") {
+			// Empty result sets print a comment, anything else ends the result
+			if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				current = nil
+			}
+			continue
+		}
+		values := splitResultLine(line)
+		if current.Columns == nil {
+			current.Columns = values
+		} else {
+			current.Rows = append(current.Rows, values)
+		}
+	}
+	for i := range results {
+		if results[i].Columns == nil {
+			results[i].Columns = []string{}
+		}
+		if results[i].Rows == nil {
+			results[i].Rows = [][]string{}
+		}
+	}
+	return results
+}
+
+// Split a line of execute-sql output, which ends with a separator
+func splitResultLine(line string) []string {
+	line = strings.TrimSuffix(strings.TrimSpace(line), "|")
+	values := strings.Split(line, " | ")
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+	}
+	return values
+}
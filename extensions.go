@@ -0,0 +1,160 @@
+package goliquify
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	EXTENSION_MATRIX_CACHE_FILE = "extension-matrix.json"
+	// How long a fetched matrix is used without fetching it again
+	EXTENSION_MATRIX_CACHE_TTL = 24 * time.Hour
+)
+
+// The matrix shipped with goliquify, used when no other is configured or
+// the configured one can't be read
+//
+//go:embed extension_matrix.json
+var embeddedExtensionMatrix []byte
+
+// ExtensionRelease is an extension release and the Liquibase versions it
+// supports, from MinCore up to MaxCore inclusive, or any later one when
+// MaxCore is empty
+type ExtensionRelease struct {
+	Version string `json:"version"`
+	MinCore string `json:"minCore"`
+	MaxCore string `json:"maxCore,omitempty"`
+}
+
+// ExtensionMatrix maps extensions to their releases
+type ExtensionMatrix map[string][]ExtensionRelease
+
+// ParseExtensionMatrix reads a matrix from JSON and checks its versions
+func ParseExtensionMatrix(data []byte) (ExtensionMatrix, error) {
+	var matrix ExtensionMatrix
+	if err := json.Unmarshal(data, &matrix); err != nil {
+		return nil, fmt.Errorf("invalid extension matrix: %v", err)
+	}
+	for ext, releases := range matrix {
+		for _, release := range releases {
+			for _, version := range []string{release.Version, release.MinCore, release.MaxCore} {
+				if _, err := ParseSemver(version); err != nil && version != "" {
+					return nil, fmt.Errorf("invalid extension matrix, %s: %v", ext, err)
+				}
+			}
+			if release.Version == "" || release.MinCore == "" {
+				return nil, fmt.Errorf("invalid extension matrix, %s: every release needs a version and minCore", ext)
+			}
+		}
+	}
+	return matrix, nil
+}
+
+// Compatible returns the newest release of an extension supporting a
+// Liquibase version, false when there is none
+func (m ExtensionMatrix) Compatible(ext, core string) (string, bool) {
+	version, err := liquibaseSemver(core)
+	if err != nil {
+		return "", false
+	}
+	var best *Semver
+	for _, release := range m[ext] {
+		minCore, _ := ParseSemver(release.MinCore)
+		if version.Less(minCore) {
+			continue
+		}
+		if release.MaxCore != "" {
+			if maxCore, _ := ParseSemver(release.MaxCore); maxCore.Less(version) {
+				continue
+			}
+		}
+		candidate, _ := ParseSemver(release.Version)
+		if best == nil || best.Less(candidate) {
+			best = &candidate
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.String(), true
+}
+
+// The matrix extension versions are picked from: ExtensionMatrix when set,
+// a file or an http(s) url fetched at most once per EXTENSION_MATRIX_CACHE_TTL,
+// otherwise the embedded one
+func (pl *GoLiquibase) extensionMatrix(ctx context.Context) ExtensionMatrix {
+	embedded, _ := ParseExtensionMatrix(embeddedExtensionMatrix)
+	if pl.ExtensionMatrix == "" {
+		return embedded
+	}
+	data, err := pl.readExtensionMatrix(ctx, pl.ExtensionMatrix)
+	if err == nil {
+		var matrix ExtensionMatrix
+		if matrix, err = ParseExtensionMatrix(data); err == nil {
+			return matrix
+		}
+	}
+	pl.logger().Printf("Warning: using the built-in extension matrix, failed to read %s: %v", pl.ExtensionMatrix, err)
+	return embedded
+}
+
+// Read a matrix from a file, or from a url through the cache dir. A stale
+// cached copy is used when the url can't be fetched.
+func (pl *GoLiquibase) readExtensionMatrix(ctx context.Context, location string) ([]byte, error) {
+	if !isURL(location) {
+		return os.ReadFile(location)
+	}
+	cacheFile := filepath.Join(pl.cacheDir(), EXTENSION_MATRIX_CACHE_FILE)
+	cached, cacheErr := os.ReadFile(cacheFile)
+	if info, err := os.Stat(cacheFile); cacheErr == nil && err == nil && time.Since(info.ModTime()) < EXTENSION_MATRIX_CACHE_TTL {
+		return cached, nil
+	}
+	data, err := fetchExtensionMatrix(ctx, location)
+	if err != nil {
+		if cacheErr == nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	if _, err := ParseExtensionMatrix(data); err != nil {
+		return nil, err
+	}
+	if os.MkdirAll(filepath.Dir(cacheFile), 0755) == nil {
+		os.WriteFile(cacheFile, data, 0644)
+	}
+	return data, nil
+}
+
+func fetchExtensionMatrix(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading the extension matrix: %s", response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// The version of an extension to install with the Liquibase version. Without
+// a compatible release in the matrix, the release numbered like Liquibase is
+// tried, which may not exist or may not load.
+func (pl *GoLiquibase) extensionVersion(matrix ExtensionMatrix, ext string) string {
+	if version, ok := matrix.Compatible(ext, pl.Version); ok {
+		return version
+	}
+	pl.logger().Printf("Warning: no release of %s is known to support Liquibase %s, trying %s-%s", ext, pl.Version, ext, pl.Version)
+	return pl.Version
+}
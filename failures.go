@@ -0,0 +1,336 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailurePattern recognizes a frequent Liquibase or JDBC failure in the
+// output of a run and explains it
+type FailurePattern struct {
+	// ID names the failure, e.g. unknown-host
+	ID      string
+	Pattern *regexp.Regexp
+	// Codes are the error codes of the failure: SQLSTATEs and the codes of
+	// MySQL, SQL Server and Oracle
+	Codes []string
+	// Summary of the failure, $1 and the like expand to the groups of the pattern
+	Summary string
+	Hints   []string
+}
+
+// FAILURE_PATTERNS are tried in order on every line of output, the first
+// match explains the failure
+var FAILURE_PATTERNS = []*FailurePattern{
+	{
+		ID:      "unknown-host",
+		Pattern: regexp.MustCompile(`UnknownHostException: ([^\s:]+)`),
+		Summary: "The database host $1 can't be resolved.",
+		Hints: []string{
+			"Check the host name in the url of the defaults file or --url.",
+			"Hosts on a private network may need a VPN or a tunnel to be reachable.",
+		},
+	},
+	{
+		ID:      "connection-refused",
+		Pattern: regexp.MustCompile(`(?i)Connection refused|Connection to (\S+) refused|Communications link failure`),
+		Codes:   []string{"08001", "08S01"},
+		Summary: "Nothing accepted the connection at the database host and port.",
+		Hints: []string{
+			"Check the host and port in the url, and that the database is running.",
+			"A firewall or security group may be blocking the port from this machine.",
+		},
+	},
+	{
+		ID:      "auth-failed",
+		Pattern: regexp.MustCompile(`password authentication failed for user "([^"]+)"|Access denied for user '([^']+)'|Login failed for user '([^']+)'|ORA-01017`),
+		Codes:   []string{"28P01", "28000", "1045", "18456", "ORA-01017"},
+		Summary: "The database rejected the user name or password.",
+		Hints: []string{
+			"Check username and password in the defaults file, or the secrets they come from.",
+			"The user may lack the right to connect from this host, e.g. pg_hba.conf on Postgres or the host part of MySQL users.",
+		},
+	},
+	{
+		ID:      "ssl-required",
+		Pattern: regexp.MustCompile(`(?i)no pg_hba\.conf entry .*(no encryption|SSL off)|SSL connection is required|insecure transport are prohibited|requires? (an )?SSL|encryption is required`),
+		Codes:   []string{"3159"},
+		Summary: "The database only accepts encrypted connections.",
+		Hints: []string{
+			"Turn on TLS in the url: ?sslmode=require on Postgres, ?sslMode=REQUIRED on MySQL, ;encrypt=true on SQL Server.",
+		},
+	},
+	{
+		ID:      "untrusted-certificate",
+		Pattern: regexp.MustCompile(`PKIX path building failed|unable to find valid certification path|SSLHandshakeException`),
+		Summary: "Java doesn't trust the certificate of the database server.",
+		Hints: []string{
+			"Import the CA certificate of the server into a truststore and pass it with -Djavax.net.ssl.trustStore in JAVA_OPTS.",
+			"Postgres also takes sslrootcert=<ca file> in the url.",
+		},
+	},
+	{
+		ID:      "missing-driver",
+		Pattern: regexp.MustCompile(`ClassNotFoundException: (\S+)|Cannot find database driver: ?(.*)|No suitable driver`),
+		Summary: "No JDBC driver for the database url was found.",
+		Hints: []string{
+			"Install a curated driver with goliquify drivers install <name>, or put the driver jar in the JDBC drivers dir (-j).",
+			"Check the url prefix, e.g. jdbc:postgresql: or jdbc:mysql:, is spelled right.",
+		},
+	},
+	{
+		ID:      "object-exists",
+		Pattern: regexp.MustCompile(`relation "([^"]+)" already exists|Table '([^']+)' already exists|There is already an object named '([^']+)'|ORA-00955`),
+		Codes:   []string{"42P07", "1050", "2714", "ORA-00955"},
+		Summary: "A changeset creates an object that already exists.",
+		Hints: []string{
+			"If the object was created outside Liquibase, mark the changeset as ran with changelog-sync or a precondition with onFail=MARK_RAN.",
+			"If an earlier run failed halfway, drop the leftover object or finish the changeset by hand, then run again.",
+		},
+	},
+	{
+		ID:      "unknown-database",
+		Pattern: regexp.MustCompile(`database "([^"]+)" does not exist|Unknown database '([^']+)'|Cannot open database "([^"]+)"`),
+		Codes:   []string{"3D000", "1049", "4060"},
+		Summary: "The database named in the url doesn't exist on the server.",
+		Hints: []string{
+			"Check the database name in the url, names can be case sensitive.",
+			"Liquibase doesn't create databases, create it first or point at an existing one.",
+		},
+	},
+	{
+		ID:      "permission-denied",
+		Pattern: regexp.MustCompile(`permission denied for \w+ (\S+)|command denied to user|The \w+ permission was denied|ORA-01031`),
+		Codes:   []string{"42501", "1142", "229", "ORA-01031"},
+		Summary: "The database user lacks a privilege a changeset needs.",
+		Hints: []string{
+			"Grant the user the privilege, e.g. CREATE on the schema, or run the migration as the schema owner.",
+			"Check the Liquibase tracking tables are writable by the user too.",
+		},
+	},
+	{
+		ID:      "missing-object",
+		Pattern: regexp.MustCompile(`relation "([^"]+)" does not exist|Table '([^']+)' doesn't exist|Invalid object name '([^']+)'|ORA-00942`),
+		Codes:   []string{"42P01", "1146", "208", "ORA-00942"},
+		Summary: "A changeset refers to a table or view that doesn't exist.",
+		Hints: []string{
+			"Check the changeset creating it ran first, its order in the changelog and its contexts and labels.",
+			"Check the default schema, unqualified names are looked up in it.",
+		},
+	},
+	{
+		ID:      "lock-held",
+		Pattern: regexp.MustCompile(`Could not acquire change log lock`),
+		Summary: "Another Liquibase run holds the changelog lock, or a crashed run left it behind.",
+		Hints: []string{
+			"Check list-locks for who holds it, and run release-locks only once no other run is going.",
+		},
+	},
+	{
+		ID:      "checksum-changed",
+		Pattern: regexp.MustCompile(`(?i)change ?sets? check ?sum`),
+		Summary: "A changeset was edited after it was deployed, its checksum no longer matches.",
+		Hints: []string{
+			"Revert the edit and add a new changeset, or accept the change with goliquify checksums repair.",
+		},
+	},
+}
+
+// FailureExplanation is the explanation of a failure
+type FailureExplanation struct {
+	ID      string   `json:"id"`
+	Summary string   `json:"summary"`
+	Hints   []string `json:"hints"`
+	// Line of output the failure was recognized in
+	Line string `json:"line,omitempty"`
+	// Source is the knowledge base the explanation comes from, empty for the bundled one
+	Source string `json:"source,omitempty"`
+}
+
+func (e *FailureExplanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s [%s]\n", e.Summary, e.ID)
+	for _, hint := range e.Hints {
+		fmt.Fprintf(&b, "  - %s\n", hint)
+	}
+	return b.String()
+}
+
+// Groups of a summary, left out when there is no match to fill them from
+var summaryGroupPattern = regexp.MustCompile(`\$\{?\w+\}? ?`)
+
+// LookupFailure looks up the bundled explanations of a failure ID, an error
+// code or a snippet of output, every line of which is explained
+func LookupFailure(query string) []*FailureExplanation {
+	query = strings.TrimSpace(query)
+	var found []*FailureExplanation
+	for _, p := range FAILURE_PATTERNS {
+		if strings.EqualFold(p.ID, query) || containsFold(p.Codes, query) {
+			found = append(found, &FailureExplanation{ID: p.ID, Summary: summaryGroupPattern.ReplaceAllString(p.Summary, ""), Hints: p.Hints})
+		}
+	}
+	if len(found) > 0 {
+		return found
+	}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(query, "\n") {
+		if e := explainLine(line); e != nil && !seen[e.ID] {
+			seen[e.ID] = true
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// KnowledgeBaseConfig looks failures the bundled patterns don't know up in
+// an online knowledge base, e.g. a team's runbook service
+type KnowledgeBaseConfig struct {
+	// URL is called as GET <url>?q=<query> and answers with a JSON array
+	// of explanations: [{"id": ..., "summary": ..., "hints": [...]}]
+	URL string `yaml:"url"`
+	// TokenEnv holds a bearer token for the knowledge base
+	TokenEnv string `yaml:"tokenEnv"`
+}
+
+// Lookup asks the knowledge base about a failure ID, error code or snippet
+func (c *KnowledgeBaseConfig) Lookup(ctx context.Context, query string) ([]*FailureExplanation, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("the knowledge base needs a url")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid knowledge base url: %v", err)
+	}
+	values := u.Query()
+	values.Set("q", query)
+	u.RawQuery = values.Encode()
+	authorization := ""
+	if token := os.Getenv(c.TokenEnv); c.TokenEnv != "" && token != "" {
+		authorization = "Bearer " + token
+	}
+	var found []*FailureExplanation
+	if err := apiRequest(ctx, "knowledge base", "GET", u.String(), authorization, nil, &found); err != nil {
+		return nil, err
+	}
+	for _, e := range found {
+		e.Source = u.Host
+	}
+	return found, nil
+}
+
+// ExplainFailure explains the first line of output matching a failure
+// pattern, nil when none does
+func ExplainFailure(output string) *FailureExplanation {
+	for _, line := range strings.Split(output, "\n") {
+		if e := explainLine(line); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func explainLine(line string) *FailureExplanation {
+	for _, p := range FAILURE_PATTERNS {
+		match := p.Pattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			continue
+		}
+		summary := string(p.Pattern.ExpandString(nil, p.Summary, line, match))
+		return &FailureExplanation{ID: p.ID, Summary: summary, Hints: p.Hints, Line: strings.TrimSpace(line)}
+	}
+	return nil
+}
+
+// Lines of output reporting an error, looked up in the knowledge base when
+// no bundled pattern explains the failure
+var failureLinePattern = regexp.MustCompile(`(Exception|Error|ERROR)\b:?`)
+
+// Remembers the first failure recognized in the output of a run, and the
+// first line reporting an error
+type failureCollector struct {
+	mu          sync.Mutex
+	explanation *FailureExplanation
+	errorLine   string
+}
+
+func (c *failureCollector) observe(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.explanation == nil {
+		c.explanation = explainLine(line)
+	}
+	if c.errorLine == "" && failureLinePattern.MatchString(line) {
+		c.errorLine = strings.TrimSpace(line)
+	}
+}
+
+func (c *failureCollector) result() (*FailureExplanation, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.explanation, c.errorLine
+}
+
+// Explain a failed run, with the bundled patterns or else the knowledge base
+func (pl *GoLiquibase) explainRun(ctx context.Context, failures *failureCollector) *FailureExplanation {
+	explanation, errorLine := failures.result()
+	if explanation != nil || pl.KnowledgeBase == nil || errorLine == "" {
+		return explanation
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	found, err := pl.KnowledgeBase.Lookup(ctx, errorLine)
+	if err != nil {
+		pl.logger().Printf("Failed to look the failure up: %v", err)
+		return nil
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+// Java stack frames, e.g. "	at liquibase.Scope.child(Scope.java:186)" and "	... 42 more"
+var stackFramePattern = regexp.MustCompile(`^\s+(at \S+\(.*\)|\.\.\. \d+ more)\s*$`)
+
+// stackTraceFilter collapses the frames of Java stack traces written to it
+// into a line per trace, keeping the exception messages and their causes
+type stackTraceFilter struct {
+	*lineWriter
+	w      io.Writer
+	hidden int
+}
+
+func newStackTraceFilter(w io.Writer) *stackTraceFilter {
+	f := &stackTraceFilter{w: w}
+	f.lineWriter = newLineWriter(f.line)
+	return f
+}
+
+func (f *stackTraceFilter) line(line string) {
+	if stackFramePattern.MatchString(line) {
+		f.hidden++
+		return
+	}
+	f.summarize()
+	fmt.Fprintln(f.w, line)
+}
+
+func (f *stackTraceFilter) summarize() {
+	if f.hidden > 0 {
+		fmt.Fprintf(f.w, "\t... %d stack frames hidden, pass --stack-traces or see the run log for them\n", f.hidden)
+		f.hidden = 0
+	}
+}
+
+// Flush writes the trailing line and the frames hidden at the end
+func (f *stackTraceFilter) Flush() {
+	f.lineWriter.Flush()
+	f.summarize()
+}
@@ -0,0 +1,346 @@
+package goliquify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const DEFAULT_FLEET_PARALLEL = 8
+
+// Query reading the deployment history, the table is qualified with the Liquibase schema when set
+const DEPLOYMENT_HISTORY_SQL = "SELECT ID, AUTHOR, FILENAME, TAG FROM %sDATABASECHANGELOG ORDER BY ORDEREXECUTED"
+
+// Query reading the changelog lock, qualified like the deployment history
+const CHANGELOG_LOCK_SQL = "SELECT CASE WHEN LOCKED THEN 1 ELSE 0 END, CAST(LOCKGRANTED AS CHAR(32)), LOCKEDBY FROM %sDATABASECHANGELOGLOCK WHERE ID = 1"
+
+const (
+	FLEET_METHOD_SQL       = "sql"
+	FLEET_METHOD_LIQUIBASE = "liquibase"
+)
+
+const (
+	FLEET_UP_TO_DATE = "up to date"
+	FLEET_BEHIND     = "behind"
+	FLEET_ERROR      = "error"
+)
+
+// Changesets listed by status --verbose, e.g. db/changelog.xml::1::bob
+var pendingChangeSetPattern = regexp.MustCompile(`(?m)^\s+(\S+::\S+::\S+)\s*$`)
+
+// AppliedChangeSet is a row of the deployment history
+type AppliedChangeSet struct {
+	ID     string
+	Author string
+	File   string
+	Tag    string
+}
+
+// ReadDeploymentHistory reads the applied changesets of a database in the
+// order they were applied, straight through database/sql
+func ReadDeploymentHistory(ctx context.Context, db *sql.DB, liquibaseSchema string) ([]AppliedChangeSet, error) {
+	qualifier := ""
+	if liquibaseSchema != "" {
+		qualifier = liquibaseSchema + "."
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(DEPLOYMENT_HISTORY_SQL, qualifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the deployment history: %v", err)
+	}
+	defer rows.Close()
+	var applied []AppliedChangeSet
+	for rows.Next() {
+		var a AppliedChangeSet
+		var tag sql.NullString
+		if err := rows.Scan(&a.ID, &a.Author, &a.File, &tag); err != nil {
+			return nil, err
+		}
+		a.Tag = tag.String
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// ChangelogLock is the state of the Liquibase lock of a database
+type ChangelogLock struct {
+	Locked bool `json:"locked"`
+	// Granted is the lock time as the database prints it
+	Granted  string `json:"granted,omitempty"`
+	LockedBy string `json:"lockedBy,omitempty"`
+}
+
+// ReadChangelogLock reads the Liquibase lock of a database. A lock table
+// without its row reads as unlocked.
+func ReadChangelogLock(ctx context.Context, db *sql.DB, liquibaseSchema string) (*ChangelogLock, error) {
+	qualifier := ""
+	if liquibaseSchema != "" {
+		qualifier = liquibaseSchema + "."
+	}
+	var locked int
+	var granted, lockedBy sql.NullString
+	err := db.QueryRowContext(ctx, fmt.Sprintf(CHANGELOG_LOCK_SQL, qualifier)).Scan(&locked, &granted, &lockedBy)
+	if err == sql.ErrNoRows {
+		return &ChangelogLock{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the changelog lock: %v", err)
+	}
+	return &ChangelogLock{Locked: locked == 1, Granted: strings.TrimSpace(granted.String), LockedBy: lockedBy.String}, nil
+}
+
+// PendingChangeSets returns the changesets of a changelog tree missing from
+// the deployment history. Contexts and labels aren't evaluated, every
+// changeset of the tree counts.
+func PendingChangeSets(tree *ChangelogTree, applied []AppliedChangeSet) []*ChangeSet {
+	byIDAuthor := map[string][]string{}
+	for _, a := range applied {
+		key := a.ID + "::" + a.Author
+		byIDAuthor[key] = append(byIDAuthor[key], a.File)
+	}
+	var pending []*ChangeSet
+	for _, cs := range tree.ChangeSets {
+		found := false
+		for _, file := range byIDAuthor[cs.ID+"::"+cs.Author] {
+			if sameChangelogPath(file, cs.File) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pending = append(pending, cs)
+		}
+	}
+	return pending
+}
+
+// FleetOptions configure a fleet status collection
+type FleetOptions struct {
+	// Parallel is the number of targets queried at once
+	Parallel int
+	// OpenDB opens a database/sql connection for the direct SQL inspector.
+	// Targets fall back to Liquibase when it is nil or fails, e.g. for a
+	// dialect without a linked driver.
+	OpenDB func(dialect, dsn string) (*sql.DB, error)
+	// ExpectedTag marks targets without this tag as behind
+	ExpectedTag string
+}
+
+// FleetStatus is the deployment status of one target
+type FleetStatus struct {
+	Target string `json:"target"`
+	// Method is sql for the direct SQL inspector, or liquibase
+	Method            string        `json:"method,omitempty"`
+	Status            string        `json:"status"`
+	Applied           int           `json:"applied"`
+	Pending           int           `json:"pending"`
+	PendingChangeSets []string      `json:"pendingChangeSets,omitempty"`
+	LatestTag         string        `json:"latestTag,omitempty"`
+	MissingTag        string        `json:"missingTag,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	Duration          time.Duration `json:"duration"`
+}
+
+// CollectFleetStatus queries the pending changesets of many targets
+// concurrently. Targets are read through the direct SQL inspector where
+// possible, which needs no JVM, and through Liquibase status otherwise.
+// Results are in the order of the targets.
+func (pl *GoLiquibase) CollectFleetStatus(ctx context.Context, targets []*Target, opts FleetOptions) []FleetStatus {
+	parallel := opts.Parallel
+	if parallel <= 0 {
+		parallel = DEFAULT_FLEET_PARALLEL
+	}
+	results := make([]FleetStatus, len(targets))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *Target) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			start := time.Now()
+			status := pl.targetStatus(ctx, t, opts)
+			status.Target = t.Name
+			status.Duration = time.Since(start)
+			if status.Error != "" {
+				status.Status = FLEET_ERROR
+			} else if status.Pending > 0 || status.MissingTag != "" {
+				status.Status = FLEET_BEHIND
+			} else {
+				status.Status = FLEET_UP_TO_DATE
+			}
+			results[i] = status
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+// Query one target, through SQL when a connection can be opened
+func (pl *GoLiquibase) targetStatus(ctx context.Context, t *Target, opts FleetOptions) FleetStatus {
+	defaultsFile := pl.DefaultsFile
+	if t.DefaultsFile != "" {
+		defaultsFile = t.DefaultsFile
+	}
+	tpl := pl.withDefaultsFile(defaultsFile)
+
+	if opts.OpenDB != nil {
+		if dialect, dsn, err := tpl.ReadOnlyDSN(); err == nil {
+			if db, err := opts.OpenDB(dialect, dsn); err == nil {
+				defer db.Close()
+				return tpl.sqlTargetStatus(ctx, db, t, opts)
+			}
+		}
+	}
+	return tpl.liquibaseTargetStatus(ctx, t, opts)
+}
+
+// The root changelog and search path of a target, as Liquibase would use them
+func (pl *GoLiquibase) targetChangelog(t *Target) (string, []string) {
+	if t.Changelog != "" {
+		return filepath.Base(t.Changelog), []string{filepath.Dir(t.Changelog)}
+	}
+	return pl.ChangelogLocation()
+}
+
+// Compare the deployment history read through SQL with the local changelog
+func (pl *GoLiquibase) sqlTargetStatus(ctx context.Context, db *sql.DB, t *Target, opts FleetOptions) FleetStatus {
+	status := FleetStatus{Method: FLEET_METHOD_SQL}
+	changelog, searchPath := pl.targetChangelog(t)
+	if changelog == "" {
+		status.Error = "no changelog file for the target"
+		return status
+	}
+	tree, err := LoadChangelogTree(changelog, searchPath)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	applied, err := ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	status.Applied = len(applied)
+	for _, cs := range PendingChangeSets(tree, applied) {
+		status.PendingChangeSets = append(status.PendingChangeSets, cs.Key())
+	}
+	status.Pending = len(status.PendingChangeSets)
+	tagged := false
+	for _, a := range applied {
+		if a.Tag != "" {
+			status.LatestTag = a.Tag
+			tagged = tagged || a.Tag == opts.ExpectedTag
+		}
+	}
+	if opts.ExpectedTag != "" && !tagged {
+		status.MissingTag = opts.ExpectedTag
+	}
+	return status
+}
+
+// Read the pending changesets from Liquibase status
+func (pl *GoLiquibase) liquibaseTargetStatus(ctx context.Context, t *Target, opts FleetOptions) FleetStatus {
+	status := FleetStatus{Method: FLEET_METHOD_LIQUIBASE}
+	if err := pl.Initialize(); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	var args []string
+	if t.Changelog != "" {
+		args = append(args, fmt.Sprintf("--search-path=%s", filepath.Dir(t.Changelog)))
+	}
+	var stdout strings.Builder
+	err := pl.ExecuteWithOptions(ctx, ExecOptions{Args: args, Stdout: &stdout, Stderr: io.Discard}, "status", "--verbose")
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if m := pendingPattern.FindStringSubmatch(stdout.String()); m != nil {
+		status.Pending, _ = strconv.Atoi(m[1])
+		for _, cs := range pendingChangeSetPattern.FindAllStringSubmatch(stdout.String(), -1) {
+			status.PendingChangeSets = append(status.PendingChangeSets, cs[1])
+		}
+	}
+	if opts.ExpectedTag != "" {
+		exists, err := pl.TagExists(opts.ExpectedTag)
+		if err != nil {
+			status.Error = err.Error()
+			return status
+		}
+		if !exists {
+			status.MissingTag = opts.ExpectedTag
+		}
+	}
+	return status
+}
+
+// WriteFleetReport renders the fleet dashboard: targets behind first, then by name
+func WriteFleetReport(w io.Writer, statuses []FleetStatus) {
+	sorted := append([]FleetStatus{}, statuses...)
+	rank := map[string]int{FLEET_ERROR: 0, FLEET_BEHIND: 1, FLEET_UP_TO_DATE: 2}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if rank[sorted[i].Status] != rank[sorted[j].Status] {
+			return rank[sorted[i].Status] < rank[sorted[j].Status]
+		}
+		return sorted[i].Target < sorted[j].Target
+	})
+
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTATUS\tPENDING\tLATEST TAG\tMISSING TAG\tMETHOD\tDURATION\tERROR")
+	for _, s := range sorted {
+		counts[s.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.Target, s.Status, s.Pending, s.LatestTag, s.MissingTag, s.Method, s.Duration.Round(time.Millisecond), s.Error)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d targets: %d up to date, %d behind, %d errors\n", len(statuses), counts[FLEET_UP_TO_DATE], counts[FLEET_BEHIND], counts[FLEET_ERROR])
+}
+
+// WriteFleetReportJSON writes the fleet statuses as JSON
+func WriteFleetReportJSON(w io.Writer, statuses []FleetStatus) error {
+	if statuses == nil {
+		statuses = []FleetStatus{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(statuses)
+}
+
+// FleetTargetsFromGlob makes a target of every defaults file matching a
+// pattern, e.g. envs/*/liquibase.properties, named after its directory or,
+// for files side by side, after the file
+func FleetTargetsFromGlob(pattern string) ([]*Target, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid defaults file pattern %s: %v", pattern, err)
+	}
+	dirs := map[string]int{}
+	for _, m := range matches {
+		dirs[filepath.Dir(m)]++
+	}
+	var targets []*Target
+	for _, m := range matches {
+		name := filepath.Base(filepath.Dir(m))
+		if dirs[filepath.Dir(m)] > 1 || name == "." {
+			name = strings.TrimSuffix(filepath.Base(m), filepath.Ext(m))
+		}
+		targets = append(targets, &Target{Name: name, DefaultsFile: m})
+	}
+	if len(targets) == 0 && !fileExists(pattern) {
+		return nil, fmt.Errorf("no defaults files match %s", pattern)
+	}
+	return targets, nil
+}
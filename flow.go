@@ -0,0 +1,321 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_FLOW_FILE = "liquibase.flowfile.yaml"
+	// First Liquibase version with the flow command, it needs a Liquibase Pro license
+	FLOW_COMMAND_VERSION = "4.15.0"
+)
+
+const (
+	FLOW_ENGINE_AUTO      = "auto"
+	FLOW_ENGINE_LIQUIBASE = "liquibase"
+	FLOW_ENGINE_BUILTIN   = "builtin"
+)
+
+const (
+	FLOW_ACTION_LIQUIBASE = "liquibase"
+	FLOW_ACTION_SHELL     = "shell"
+)
+
+// Flow file variables, ${NAME} or ${ALIAS.NAME} for included variable files
+var flowVariablePattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// FlowAction is one step of a flow stage
+type FlowAction struct {
+	// Type is liquibase or shell
+	Type string `yaml:"type"`
+	// Command is a Liquibase command such as update or checks run, or a shell command line
+	Command string `yaml:"command"`
+	// CmdArgs and GlobalArgs are Liquibase command and global arguments
+	CmdArgs    map[string]any `yaml:"cmdArgs"`
+	GlobalArgs map[string]any `yaml:"globalArgs"`
+}
+
+// FlowStage is a named list of actions
+type FlowStage struct {
+	Name           string
+	Actions        []FlowAction      `yaml:"actions"`
+	StageVariables map[string]string `yaml:"stageVariables"`
+}
+
+// FlowFile is a Liquibase flow file: stages run in order, and the end stage
+// runs last whether they succeed or not
+type FlowFile struct {
+	GlobalVariables map[string]string
+	Stages          []*FlowStage
+	EndStage        *FlowStage
+	// Variables of included files, by alias
+	Includes map[string]map[string]string
+}
+
+// FlowError reports the action a flow failed at
+type FlowError struct {
+	Stage  string
+	Action int
+	Err    error
+}
+
+func (e *FlowError) Error() string {
+	return fmt.Sprintf("flow stage %s failed at action %d: %v", e.Stage, e.Action+1, e.Err)
+}
+
+func (e *FlowError) Unwrap() error {
+	return e.Err
+}
+
+// ReadFlowFile parses a flow file, keeping the order of its stages. Included
+// variable files are read relative to it.
+func ReadFlowFile(path string) (*FlowFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		GlobalVariables map[string]string `yaml:"globalVariables"`
+		Include         map[string]string `yaml:"include"`
+		Stages          yaml.Node         `yaml:"stages"`
+		EndStage        *FlowStage        `yaml:"endStage"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse flow file %s: %v", path, err)
+	}
+	flow := &FlowFile{GlobalVariables: raw.GlobalVariables, EndStage: raw.EndStage, Includes: map[string]map[string]string{}}
+	if flow.EndStage != nil {
+		flow.EndStage.Name = "endStage"
+	}
+
+	// Stages are a mapping, the node keeps them in file order
+	if raw.Stages.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("flow file %s has no stages", path)
+	}
+	for i := 0; i+1 < len(raw.Stages.Content); i += 2 {
+		stage := &FlowStage{Name: raw.Stages.Content[i].Value}
+		if err := raw.Stages.Content[i+1].Decode(stage); err != nil {
+			return nil, fmt.Errorf("invalid stage %s in flow file %s: %v", stage.Name, path, err)
+		}
+		flow.Stages = append(flow.Stages, stage)
+	}
+
+	for alias, file := range raw.Include {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read flow include %s: %v", alias, err)
+		}
+		vars := map[string]string{}
+		if err := yaml.Unmarshal(data, &vars); err != nil {
+			return nil, fmt.Errorf("failed to parse flow include %s: %v", alias, err)
+		}
+		flow.Includes[alias] = vars
+	}
+
+	for _, stage := range append(append([]*FlowStage{}, flow.Stages...), flow.EndStage) {
+		if stage == nil {
+			continue
+		}
+		for i, action := range stage.Actions {
+			if action.Type != FLOW_ACTION_LIQUIBASE && action.Type != FLOW_ACTION_SHELL {
+				return nil, fmt.Errorf("stage %s action %d has type %q, expecting liquibase or shell", stage.Name, i+1, action.Type)
+			}
+			if strings.TrimSpace(action.Command) == "" {
+				return nil, fmt.Errorf("stage %s action %d has no command", stage.Name, i+1)
+			}
+		}
+	}
+	return flow, nil
+}
+
+// Replace ${NAME} variables: stage variables, then global variables, then
+// included files as ${ALIAS.NAME}, then the environment
+func (f *FlowFile) expand(stage *FlowStage, s string) (string, error) {
+	var missing []string
+	expanded := flowVariablePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := flowVariablePattern.FindStringSubmatch(ref)[1]
+		if v, ok := stage.StageVariables[name]; ok {
+			return v
+		}
+		if v, ok := f.GlobalVariables[name]; ok {
+			return v
+		}
+		if alias, key, ok := strings.Cut(name, "."); ok {
+			if v, ok := f.Includes[alias][key]; ok {
+				return v
+			}
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		missing = append(missing, name)
+		return ref
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined flow variable %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// Build --name=value arguments from a flow argument map, sorted for stable command lines
+func (f *FlowFile) flowArgs(stage *FlowStage, values map[string]any) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		value := values[name]
+		if list, ok := value.([]any); ok {
+			parts := make([]string, len(list))
+			for i, v := range list {
+				parts[i] = fmt.Sprint(v)
+			}
+			value = strings.Join(parts, ",")
+		}
+		expanded, err := f.expand(stage, fmt.Sprint(value))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", strings.TrimPrefix(name, "--"), expanded))
+	}
+	return args, nil
+}
+
+// Run one action of a stage
+func (pl *GoLiquibase) runFlowAction(ctx context.Context, flow *FlowFile, stage *FlowStage, action FlowAction) error {
+	command, err := flow.expand(stage, action.Command)
+	if err != nil {
+		return err
+	}
+	if action.Type == FLOW_ACTION_SHELL {
+		pl.logger().Printf("Flow %s: running %s", stage.Name, command)
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+
+	globalArgs, err := flow.flowArgs(stage, action.GlobalArgs)
+	if err != nil {
+		return err
+	}
+	cmdArgs, err := flow.flowArgs(stage, action.CmdArgs)
+	if err != nil {
+		return err
+	}
+	pl.logger().Printf("Flow %s: liquibase %s", stage.Name, command)
+	// Commands can have several words, e.g. checks run
+	args := append(strings.Fields(command), cmdArgs...)
+	return pl.ExecuteWithOptions(ctx, ExecOptions{Args: globalArgs}, args...)
+}
+
+// Run the actions of a stage in order, stopping at the first failure
+func (pl *GoLiquibase) runFlowStage(ctx context.Context, flow *FlowFile, stage *FlowStage) error {
+	for i, action := range stage.Actions {
+		if err := pl.runFlowAction(ctx, flow, stage, action); err != nil {
+			return &FlowError{Stage: stage.Name, Action: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// RunFlow runs a flow file with the built-in runner, which needs no Liquibase
+// Pro license. Stages run in order until one fails, then the end stage runs.
+func (pl *GoLiquibase) RunFlow(ctx context.Context, path string) error {
+	flow, err := ReadFlowFile(path)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	var flowErr error
+	for _, stage := range flow.Stages {
+		if flowErr = pl.runFlowStage(ctx, flow, stage); flowErr != nil {
+			break
+		}
+	}
+	if flow.EndStage != nil {
+		if err := pl.runFlowStage(ctx, flow, flow.EndStage); err != nil && flowErr == nil {
+			flowErr = err
+		}
+	}
+	if flowErr == nil {
+		pl.logger().Printf("Flow %s completed in %s", path, time.Since(start).Round(time.Millisecond))
+	}
+	return flowErr
+}
+
+// RunLiquibaseFlow runs a flow file with the Liquibase flow command, which
+// needs Liquibase Pro
+func (pl *GoLiquibase) RunLiquibaseFlow(ctx context.Context, path string) error {
+	if err := ValidatePath(path); err != nil {
+		return err
+	}
+	return pl.ExecuteWithOptions(ctx, ExecOptions{}, "flow", fmt.Sprintf("--flow-file=%s", path))
+}
+
+// HasProLicense checks if a Liquibase Pro license key is configured, in the
+// environment or the defaults file
+func (pl *GoLiquibase) HasProLicense() bool {
+	if os.Getenv("LIQUIBASE_LICENSE_KEY") != "" {
+		return true
+	}
+	if pl.DefaultsFile == "" || IsEncryptedFile(pl.DefaultsFile) {
+		return false
+	}
+	data, err := os.ReadFile(pl.DefaultsFile)
+	if err != nil {
+		return false
+	}
+	props, err := parsePropertyFile(pl.DefaultsFile, data)
+	if err != nil {
+		return false
+	}
+	for _, key := range []string{"liquibase.licenseKey", "licenseKey", "license-key", "liquibase.license-key"} {
+		if props[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// FlowEngine picks how to run a flow file: the Liquibase flow command when
+// the version has it and a Pro license is configured, else the built-in runner
+func (pl *GoLiquibase) FlowEngine(engine string) (string, error) {
+	switch engine {
+	case FLOW_ENGINE_LIQUIBASE, FLOW_ENGINE_BUILTIN:
+		return engine, nil
+	case "", FLOW_ENGINE_AUTO:
+	default:
+		return "", fmt.Errorf("unknown flow engine %s, expecting auto, liquibase or builtin", engine)
+	}
+	if !pl.HasProLicense() {
+		return FLOW_ENGINE_BUILTIN, nil
+	}
+	if version, err := liquibaseSemver(pl.InstalledVersion()); err == nil {
+		minimum, _ := ParseSemver(FLOW_COMMAND_VERSION)
+		if version.Less(minimum) {
+			return FLOW_ENGINE_BUILTIN, nil
+		}
+	}
+	return FLOW_ENGINE_LIQUIBASE, nil
+}
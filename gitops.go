@@ -0,0 +1,267 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_GITOPS_STATUS_FILE = ".goliquify/gitops-status.json"
+	DEFAULT_GITOPS_POLL        = time.Minute
+
+	GITOPS_SYNCED      = "synced"
+	GITOPS_OUT_OF_SYNC = "out-of-sync"
+	GITOPS_FAILED      = "failed"
+)
+
+// GitOps applies changelogs from a git branch whenever they change
+type GitOps struct {
+	Repo   string
+	Branch string
+	// Directory in the repo holding the changelogs
+	Path string
+	// Local checkout of the repo
+	WorkDir string
+	Poll    time.Duration
+	// Only report pending changes, apply them with a manual sync
+	ManualSync bool
+	// Protected environments need an approval, see CheckApproval
+	Protected bool
+	Approval  string
+	// Status is written here as JSON after every check
+	StatusFile string
+	Config     *Config
+}
+
+// GitOpsStatus reports what the watcher last saw and did
+type GitOpsStatus struct {
+	State         string         `json:"state"`
+	Commit        string         `json:"commit"`
+	AppliedCommit string         `json:"appliedCommit,omitempty"`
+	PlanDigest    string         `json:"planDigest,omitempty"`
+	Message       string         `json:"message,omitempty"`
+	Results       []TargetResult `json:"results,omitempty"`
+	CheckedAt     time.Time      `json:"checkedAt"`
+}
+
+// Watch polls the branch and applies changes until the context is cancelled
+func (g *GitOps) Watch(ctx context.Context, pl *GoLiquibase) error {
+	poll := g.Poll
+	if poll <= 0 {
+		poll = DEFAULT_GITOPS_POLL
+	}
+	for {
+		if _, err := g.Check(ctx, pl, !g.ManualSync); err != nil {
+			pl.logger().Printf("GitOps check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Check fetches the branch once and plans the changelog changes since the last
+// applied commit. They are applied when apply is set, otherwise they are only
+// reported as out of sync.
+func (g *GitOps) Check(ctx context.Context, pl *GoLiquibase, apply bool) (*GitOpsStatus, error) {
+	previous, err := ReadGitOpsStatus(g.statusFile())
+	if err != nil {
+		return nil, err
+	}
+	status := &GitOpsStatus{AppliedCommit: previous.AppliedCommit, CheckedAt: time.Now().UTC()}
+	fail := func(err error) (*GitOpsStatus, error) {
+		status.State = GITOPS_FAILED
+		status.Message = err.Error()
+		if werr := g.writeStatus(status); werr != nil {
+			pl.logger().Printf("Failed to write GitOps status: %v", werr)
+		}
+		return status, err
+	}
+
+	if err := g.syncRepo(ctx); err != nil {
+		return fail(err)
+	}
+	if status.Commit, err = g.git(ctx, "rev-parse", "HEAD"); err != nil {
+		return fail(err)
+	}
+
+	changed := true
+	if status.AppliedCommit != "" {
+		if status.AppliedCommit == status.Commit {
+			changed = false
+		} else {
+			files, err := g.git(ctx, "diff", "--name-only", status.AppliedCommit, status.Commit, "--", g.Path)
+			if err != nil {
+				return fail(err)
+			}
+			changed = files != ""
+		}
+	}
+	if !changed {
+		status.State = GITOPS_SYNCED
+		status.AppliedCommit = status.Commit
+		return status, g.writeStatus(status)
+	}
+
+	targets, err := LoadOrderedTargets(filepath.Join(g.WorkDir, g.Path), "", g.config())
+	if err != nil {
+		return fail(err)
+	}
+	plan, err := pl.planTargets(targets)
+	if err != nil {
+		return fail(err)
+	}
+	status.PlanDigest = PlanDigest(plan)
+
+	if !apply {
+		status.State = GITOPS_OUT_OF_SYNC
+		status.Message = "changes are waiting for a manual sync"
+		pl.logger().Printf("GitOps: %s is out of sync, plan %s", status.Commit, status.PlanDigest)
+		return status, g.writeStatus(status)
+	}
+
+	if g.Protected {
+		if err := CheckApproval(g.Approval, plan, pl.Environment, os.Stdin, os.Stdout); err != nil {
+			return fail(err)
+		}
+	}
+
+	// The plan of every target was approved at once, not one by one
+	approved := pl.withDefaultsFile(pl.DefaultsFile)
+	approved.Protected = false
+	pl.logger().Printf("GitOps: applying %s", status.Commit)
+	status.Results = approved.RunTargets(targets, "update")
+	for _, r := range status.Results {
+		if r.Status != "success" {
+			return fail(fmt.Errorf("update of %s at %s: %s %s", r.Target, status.Commit, r.Status, r.Error))
+		}
+	}
+	status.State = GITOPS_SYNCED
+	status.AppliedCommit = status.Commit
+	return status, g.writeStatus(status)
+}
+
+// Build one plan covering the pending SQL of every target
+func (pl *GoLiquibase) planTargets(targets []*Target) (string, error) {
+	var plan strings.Builder
+	for _, t := range targets {
+		tpl, err := pl.ForTarget(t)
+		if err != nil {
+			return "", err
+		}
+		sql, err := tpl.Output("update-sql", fmt.Sprintf("--changelog-file=%s", filepath.Base(t.Changelog)))
+		if err != nil {
+			return "", fmt.Errorf("failed to plan %s: %v", t.Name, err)
+		}
+		fmt.Fprintf(&plan, "-- Target: %s\n%s\n", t.Name, sql)
+	}
+	return plan.String(), nil
+}
+
+// Clone the repo, or fetch the branch and check out its head
+func (g *GitOps) syncRepo(ctx context.Context) error {
+	if !dirExists(filepath.Join(g.WorkDir, ".git")) {
+		if err := os.MkdirAll(filepath.Dir(g.WorkDir), 0755); err != nil {
+			return err
+		}
+		_, err := runGit(ctx, "", "clone", "--branch", g.Branch, g.Repo, g.WorkDir)
+		return err
+	}
+	if _, err := g.git(ctx, "fetch", "origin", g.Branch); err != nil {
+		return err
+	}
+	_, err := g.git(ctx, "checkout", "--force", "--detach", "FETCH_HEAD")
+	return err
+}
+
+func (g *GitOps) git(ctx context.Context, args ...string) (string, error) {
+	return runGit(ctx, g.WorkDir, args...)
+}
+
+// Run git and return its trimmed output
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	return runGitEnv(ctx, dir, nil, args...)
+}
+
+// Run git with extra environment variables and return its trimmed output
+func runGitEnv(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (g *GitOps) config() *Config {
+	if g.Config == nil {
+		return &Config{}
+	}
+	return g.Config
+}
+
+func (g *GitOps) statusFile() string {
+	if g.StatusFile == "" {
+		return DEFAULT_GITOPS_STATUS_FILE
+	}
+	return g.StatusFile
+}
+
+func (g *GitOps) writeStatus(status *GitOpsStatus) error {
+	path := g.statusFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadGitOpsStatus reads the status file, a missing file is an empty status
+func ReadGitOpsStatus(path string) (*GitOpsStatus, error) {
+	status := &GitOpsStatus{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, fmt.Errorf("failed to parse GitOps status %s: %v", path, err)
+	}
+	return status, nil
+}
+
+// WriteGitOpsStatus prints a status summary
+func WriteGitOpsStatus(w io.Writer, status *GitOpsStatus) {
+	fmt.Fprintf(w, "State:    %s\n", status.State)
+	fmt.Fprintf(w, "Commit:   %s\n", status.Commit)
+	fmt.Fprintf(w, "Applied:  %s\n", status.AppliedCommit)
+	if status.PlanDigest != "" {
+		fmt.Fprintf(w, "Plan:     %s\n", status.PlanDigest)
+	}
+	if status.Message != "" {
+		fmt.Fprintf(w, "Message:  %s\n", status.Message)
+	}
+	if len(status.Results) > 0 {
+		WriteTargetReport(w, status.Results)
+	}
+}
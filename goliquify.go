@@ -0,0 +1,1091 @@
+package goliquify
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Constants
+	DEFAULT_LIQUIBASE_VERSION = "4.21.1"
+	LIQUIBASE_ZIP_URL         = "https://github.com/liquibase/liquibase/releases/download/v{version}/liquibase-{version}.zip"
+	LIQUIBASE_ZIP_FILE        = "liquibase-{version}.zip"
+	LIQUIBASE_DIR             = "liquibase-{version}"
+	LIQUIBASE_EXT_URL         = "https://github.com/liquibase/{ext}/releases/download/{extVersion}/{extVersion2}.jar"
+	USER_PROVIDED_VERSION     = "user-provided"
+	// Datetime format Liquibase accepts for rollback-to-date
+	LIQUIBASE_DATETIME_FORMAT = "2006-01-02T15:04:05"
+)
+
+// Liquibase extensions list as a variable
+var LIQUIBASE_EXT_LIST = []string{"liquibase-bigquery", "liquibase-redshift"}
+
+// GoLiquibase struct. Configure it before use, after that its methods are safe
+// for concurrent use: every call builds its own argument list and never changes
+// the instance, except for installing Liquibase which is done under a lock.
+type GoLiquibase struct {
+	DefaultsFile            string
+	LiquibaseHubMode        string
+	LogLevel                string
+	LiquibaseDir            string
+	JdbcDriversDir          string
+	AdditionalClasspath     string
+	Version                 string
+	LiquibaseLibDir         string
+	LiquibaseInternalDir    string
+	LiquibaseInternalLibDir string
+	ChangelogProperties     map[string]string
+	TemplateDir             string
+	TemplateValues          map[string]any
+	TimingReport            string
+	TimingThreshold         time.Duration
+	HeartbeatInterval       time.Duration
+	EventSinks              []EventSink
+	Environment             string
+	WindowPolicy            *WindowPolicy
+	WindowOverride          string
+	JournalFile             string
+	DryRun                  bool
+	// Protected environments need an approval to change the database, checked
+	// with Approval, see CheckApproval
+	Protected bool
+	Approval  string
+	CacheDir  string
+	// Toolchains kept in the cache dir, nil keeps them all
+	CacheRetention *CacheRetention
+	// Changesets left out of every run, without editing the changelog
+	ChangeSetFilter ChangeSetFilter
+	// File or url of the extension compatibility matrix, the embedded one when empty
+	ExtensionMatrix string
+	// Middleware intercepting every command, see PluginMiddleware
+	Middleware []Middleware
+	// Liquibase build installed instead of a release, the url of a zip or
+	// tar.gz or a local one, or a directory of an extracted build
+	LiquibaseArtifact string
+	// Session parameters of every connection, e.g. lock_timeout, see SessionURL
+	SessionSettings map[string]string
+	// Rewrite the SQL generated for Postgres into safer forms, nil to leave it as is
+	SafeRewrite *SafeRewriteOptions
+	// Back up the database before the backed up commands, nil for no backups
+	Backup *BackupConfig
+	// Record runs in change tickets, nil for none, and the ticket of the runs
+	ChangeTickets *ChangeTicketConfig
+	ChangeTicket  string
+	// Publish the schema after successful updates, nil to publish none
+	SchemaRegistry *SchemaRegistryConfig
+	// Run every command in a private working directory with a scratch Liquibase home
+	Sandbox bool
+	// Fail before running Liquibase on misconfiguration it would only fail on later
+	Strict bool
+	// Print Java stack traces in full, their frames are collapsed to a line by default
+	StackTraces bool
+	// Explain failures the bundled patterns don't know with a knowledge base, nil for none
+	KnowledgeBase *KnowledgeBaseConfig
+	// Encodings and locale of Liquibase, the JVM defaults when empty
+	Encoding EncodingOptions
+	// Turn off Liquibase analytics and Hub traffic, for compliance restricted environments
+	DisableAnalytics bool
+	// Write an operation report for the commands that support it, nil to turn them off
+	OperationReports *OperationReports
+	// Write a signed attestation of the runs of the attested commands, nil to turn them off
+	Attestations *AttestationConfig
+	// Java feature version of a managed JRE downloaded for this platform, 0 uses the Java on the system
+	JavaVersion int
+	// Curated JDBC driver bundles installed with Liquibase, and the bundles whose license was accepted
+	Drivers          []string
+	AcceptedLicenses []string
+	// Commands allowed to run, and the role of the user running them
+	CommandPolicy *CommandPolicy
+	Role          string
+	// SSH bastion the database is reached through and TLS settings of the connection, nil for none
+	Tunnel *TunnelConfig
+	TLS    *TLSConfig
+	// Log in with Kerberos on SQL Server and Oracle, or an Entra access token on Azure SQL, nil for the defaults file's credentials
+	Kerberos *KerberosConfig
+	Entra    *EntraConfig
+	// Aurora DSQL cluster signed IAM tokens are generated for, or Spanner database, nil for neither
+	DSQL    *DSQLConfig
+	Spanner *SpannerConfig
+	// Credentials of the commands only reading the database, nil to run every command with the defaults file's
+	ReadOnly *ReadOnlyCredentials
+	// Encrypted files decrypted for every run, with sops unless Decryptor is set
+	Secrets   SecretsConfig
+	Decryptor Decryptor
+	// Directory the full output of every run is written to, one file per run
+	LogDir       string
+	LogRetention LogRetention
+	// Schema for unqualified objects, and the schema and catalog holding the Liquibase tracking tables
+	DefaultSchemaName    string
+	LiquibaseSchemaName  string
+	LiquibaseCatalogName string
+	// Schemas ForEachSchema runs across, e.g. one per tenant
+	Schemas []string
+	// CI metadata recorded with every run, see DetectCIMetadata
+	CI *CIMetadata
+	// Time zone of the database, datetimes are converted to it for Liquibase
+	Location *time.Location
+	Logger   *log.Logger
+	Runner   Runner
+	// Additional global arguments passed to every command
+	Args []string
+
+	// Whether LiquibaseDir points into the cache rather than at a user provided install
+	managedInstall bool
+	// Whether LiquibaseDir is a user provided install, which Version doesn't describe
+	userInstall bool
+	// Version of the user provided install read from its jars, empty when unknown
+	userInstallVersion string
+	// Java home of the managed JRE, once installed
+	javaHome string
+}
+
+// ExecOptions are settings for a single command on top of the instance settings
+type ExecOptions struct {
+	// Additional global arguments for this command
+	Args []string
+	// Changelog properties for this command, overriding the instance ones
+	ChangelogProperties map[string]string
+	// Output writers, os.Stdout and os.Stderr when nil
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Initialize the GoLiquibase instance. It can be called again after changing
+// settings, arguments are built for every command rather than stored.
+func (pl *GoLiquibase) Initialize() error {
+	if pl.DefaultsFile != "" && !fileExists(pl.DefaultsFile) {
+		return fmt.Errorf("defaultsFile not found! %s", pl.DefaultsFile)
+	}
+
+	return pl.EnsureInstalled(context.Background())
+}
+
+// Build the global Liquibase arguments from the settings
+func (pl *GoLiquibase) BuildArgs() []string {
+	var args []string
+	if pl.DefaultsFile != "" {
+		args = append(args, fmt.Sprintf("--defaults-file=%s", pl.DefaultsFile))
+	}
+
+	hubMode := pl.LiquibaseHubMode
+	if pl.DisableAnalytics {
+		hubMode = "off"
+		args = append(args, pl.analyticsArgs()...)
+	}
+	if hubMode != "" && hubSupported(pl.InstalledVersion()) {
+		args = append(args, fmt.Sprintf("--hub-mode=%s", hubMode))
+	}
+
+	if pl.LogLevel != "" {
+		args = append(args, fmt.Sprintf("--log-level=%s", pl.LogLevel))
+	}
+
+	if pl.DefaultSchemaName != "" {
+		args = append(args, fmt.Sprintf("--default-schema-name=%s", pl.DefaultSchemaName))
+	}
+	if pl.LiquibaseSchemaName != "" {
+		args = append(args, fmt.Sprintf("--liquibase-schema-name=%s", pl.LiquibaseSchemaName))
+	}
+	if pl.LiquibaseCatalogName != "" {
+		args = append(args, fmt.Sprintf("--liquibase-catalog-name=%s", pl.LiquibaseCatalogName))
+	}
+	if pl.Encoding.OutputFileEncoding != "" {
+		args = append(args, fmt.Sprintf("--output-file-encoding=%s", pl.Encoding.OutputFileEncoding))
+	}
+	return args
+}
+
+// Copy the instance for another defaults file, the copy must be initialized again.
+// Changes to the copy's arguments don't affect the original.
+func (pl *GoLiquibase) withDefaultsFile(defaultsFile string) *GoLiquibase {
+	c := *pl
+	c.DefaultsFile = defaultsFile
+	c.Args = append([]string{}, pl.Args...)
+	return &c
+}
+
+// Copy the instance to only read the database with, running commands without
+// journal records, events, backups, tickets, attestations, middleware,
+// guardrails, windows or approvals
+func (pl *GoLiquibase) reader() *GoLiquibase {
+	r := pl.withDefaultsFile(pl.DefaultsFile)
+	r.JournalFile, r.EventSinks, r.Backup, r.ChangeTickets, r.Attestations = "", nil, nil, nil, nil
+	r.Middleware, r.CommandPolicy, r.WindowPolicy, r.SchemaRegistry = nil, nil, nil, nil
+	r.DryRun, r.Protected = false, false
+	return r
+}
+
+// Execute the Liquibase command with arguments
+func (pl *GoLiquibase) Execute(arguments ...string) error {
+	return pl.ExecuteWithOptions(context.Background(), ExecOptions{}, arguments...)
+}
+
+// Execute the Liquibase command and return its standard output
+func (pl *GoLiquibase) Output(arguments ...string) (string, error) {
+	var stdout bytes.Buffer
+	err := pl.ExecuteWithOptions(context.Background(), ExecOptions{Stdout: &stdout}, arguments...)
+	return stdout.String(), err
+}
+
+// Execute the Liquibase command with per-call options
+func (pl *GoLiquibase) ExecuteWithOptions(ctx context.Context, opts ExecOptions, arguments ...string) error {
+	inv, err := pl.beforeMiddleware(ctx, arguments)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = pl.execute(ctx, opts, inv.Args...)
+	pl.afterMiddleware(ctx, inv, start, err)
+	return err
+}
+
+// Run a Liquibase command: prepare it, run it and record how it went
+func (pl *GoLiquibase) execute(ctx context.Context, opts ExecOptions, arguments ...string) error {
+	r, err := pl.newCommandRun(arguments)
+	if err != nil {
+		return err
+	}
+	defer r.cleanup()
+	if err := r.prepare(ctx, opts); err != nil {
+		return err
+	}
+	return r.record(ctx, r.run(ctx))
+}
+
+// commandRun is the state of one command as execute takes it through its
+// stages. The command is parsed once, every stage uses it.
+type commandRun struct {
+	pl        *GoLiquibase
+	arguments []string
+	command   string
+	id        string
+	start     time.Time
+
+	stdout, stderr io.Writer
+	// terminal is stderr before stack traces are filtered out of it
+	terminal io.Writer
+	// Generated SQL to rewrite is held back until it is complete, then
+	// written to sqlOut
+	generatedSQL *bytes.Buffer
+	sqlOut       io.Writer
+
+	liquibaseDir string
+	cmdArgs      []string
+	conn         *connection
+	cmd          *Command
+
+	attestationKey ed25519.PrivateKey
+	backup         *BackupRecord
+	ticket         *changeTicketRun
+
+	progress                 *progressTracker
+	timings                  *timingCollector
+	applied                  *appliedCollector
+	failures                 *failureCollector
+	stdoutLines, stderrLines *lineWriter
+	traces                   *stackTraceFilter
+	logFile                  *runLog
+
+	// cleanups undo what preparing the run set up, in reverse order
+	cleanups []func()
+}
+
+// Parse the command of a run, replaced by its SQL variant in a dry run
+func (pl *GoLiquibase) newCommandRun(arguments []string) (*commandRun, error) {
+	command, err := parseCommand(arguments)
+	if err != nil {
+		return nil, err
+	}
+	if pl.DryRun {
+		if command, arguments, err = pl.dryRunArguments(command, arguments); err != nil {
+			return nil, err
+		}
+	}
+	return &commandRun{pl: pl, arguments: arguments, command: command, id: newRunID(time.Now())}, nil
+}
+
+// Remove what the run set up, temporary directories, the connection,
+// decrypted secrets and the sandbox
+func (r *commandRun) cleanup() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+// Create a temporary directory removed when the run ends
+func (r *commandRun) tempDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	r.cleanups = append(r.cleanups, func() { os.RemoveAll(dir) })
+	return dir, nil
+}
+
+// Prepare the run. Guardrails are checked first, then the command line is
+// built and the connection set up, then windows and approvals are checked
+// and what the run must not start without is done, before the command to
+// run is put together.
+func (r *commandRun) prepare(ctx context.Context, opts ExecOptions) error {
+	if err := r.pl.checkPolicy(r.command); err != nil {
+		return err
+	}
+	r.setOutput(opts)
+	if err := r.globalArgs(opts); err != nil {
+		return err
+	}
+	if err := r.commandArgs(opts); err != nil {
+		return err
+	}
+	if err := r.connect(ctx); err != nil {
+		return err
+	}
+	if err := r.authorize(ctx); err != nil {
+		return err
+	}
+	if err := r.safeguard(ctx); err != nil {
+		return err
+	}
+	r.watchOutput()
+	return r.buildCommand()
+}
+
+// Set the writers of the run, holding back SQL that is rewritten
+func (r *commandRun) setOutput(opts ExecOptions) {
+	r.stdout, r.stderr = opts.Stdout, opts.Stderr
+	if r.stdout == nil {
+		r.stdout = os.Stdout
+	}
+	if r.stderr == nil {
+		r.stderr = os.Stderr
+	}
+	r.sqlOut = r.stdout
+	if r.pl.safeRewrites(r.command) {
+		r.generatedSQL = &bytes.Buffer{}
+		r.stdout = r.generatedSQL
+	}
+}
+
+// Build the arguments before the command: the settings, the classpath and
+// the search path of rendered changelog templates
+func (r *commandRun) globalArgs(opts ExecOptions) error {
+	pl := r.pl
+	var err error
+	if r.liquibaseDir, err = pl.installedDir(); err != nil {
+		return err
+	}
+	if err := pl.checkHubMode(); err != nil {
+		return err
+	}
+	r.cmdArgs = pl.BuildArgs()
+	classpath, err := pl.classpath(r.liquibaseDir)
+	if err != nil {
+		return err
+	}
+	if classpath != "" {
+		r.cmdArgs = append(r.cmdArgs, fmt.Sprintf("--classpath=%s", classpath))
+	}
+	if pl.Strict {
+		if err := pl.strictCheck(r.liquibaseDir, classpath, r.arguments); err != nil {
+			return err
+		}
+	}
+	r.cmdArgs = append(r.cmdArgs, pl.Args...)
+	r.cmdArgs = append(r.cmdArgs, opts.Args...)
+
+	// Render changelog templates and point Liquibase at the rendered copy
+	if pl.TemplateDir != "" {
+		renderDir, err := r.tempDir("goliquify-changelog-")
+		if err != nil {
+			return err
+		}
+		pl.logger().Printf("Rendering changelog templates from %s to %s", pl.TemplateDir, renderDir)
+		data := newTemplateData(pl.TemplateValues, pl.ChangelogProperties)
+		if err := renderChangelogDir(pl.TemplateDir, renderDir, data); err != nil {
+			return err
+		}
+		r.cmdArgs = append(r.cmdArgs, fmt.Sprintf("--search-path=%s", renderDir))
+	}
+	return nil
+}
+
+// Add the command, its changelog properties, reports, changeset filter and
+// session settings
+func (r *commandRun) commandArgs(opts ExecOptions) error {
+	pl := r.pl
+	properties := map[string]string{}
+	for _, props := range []map[string]string{runProperties(r.id, pl.CI), pl.sessionProperties(), pl.ChangelogProperties, opts.ChangelogProperties} {
+		for key, val := range props {
+			properties[key] = val
+		}
+	}
+	r.cmdArgs = append(r.cmdArgs, r.arguments...)
+	propertyArgs, err := changelogPropertyArgs(properties)
+	if err != nil {
+		return err
+	}
+	r.cmdArgs = append(r.cmdArgs, propertyArgs...)
+	reportArgs, err := pl.reportArgs(r.command)
+	if err != nil {
+		return err
+	}
+	r.cmdArgs = append(r.cmdArgs, reportArgs...)
+	if !pl.ChangeSetFilter.empty() {
+		filterDir, err := r.tempDir("goliquify-filter-")
+		if err != nil {
+			return err
+		}
+		if r.cmdArgs, err = pl.changeSetFilterArgs(r.cmdArgs, filterDir); err != nil {
+			return err
+		}
+	}
+	r.cmdArgs, err = pl.sessionArgs(r.cmdArgs)
+	return err
+}
+
+// Set up the connection and decrypt the secrets of the run, then check the
+// complete command line
+func (r *commandRun) connect(ctx context.Context) error {
+	pl := r.pl
+	conn, err := pl.openConnection(ctx, r.cmdArgs)
+	if err != nil {
+		return err
+	}
+	r.cleanups = append(r.cleanups, conn.Close)
+	r.conn, r.cmdArgs = conn, conn.cmdArgs
+
+	// Decrypt secrets for this run only, they are removed when it ends
+	if pl.hasSecrets() {
+		secrets, err := pl.decryptSecrets(ctx, r.arguments)
+		if err != nil {
+			return err
+		}
+		r.cleanups = append(r.cleanups, func() { secrets.Close() })
+		r.cmdArgs = secrets.apply(r.cmdArgs)
+	}
+	return validateArguments(r.cmdArgs)
+}
+
+// Check the maintenance window and the approval of the run
+func (r *commandRun) authorize(ctx context.Context) error {
+	r.start = time.Now()
+	if err := r.pl.checkWindow(r.command, r.start); err != nil {
+		return err
+	}
+	return r.pl.checkApproval(ctx, r.command, r.arguments)
+}
+
+// Do what the run must not start without: load the attestation key, back
+// up the database and open the change ticket
+func (r *commandRun) safeguard(ctx context.Context) error {
+	pl := r.pl
+	var err error
+	// Load the attestation key up front, an attested run must not go unrecorded
+	if pl.Attestations != nil && pl.Attestations.Dir != "" && pl.Attestations.attests(r.command) {
+		if r.attestationKey, err = pl.attestationKey(); err != nil {
+			return err
+		}
+	}
+
+	// Back up the database first, a migration without its backup doesn't start
+	if pl.Backup != nil && !pl.DryRun && pl.Backup.backsUp(r.command) {
+		pl.logger().Printf("Taking a %s backup before %s", pl.Backup.Type, r.command)
+		if r.backup, err = pl.takeBackup(ctx, r.id); err != nil {
+			return fmt.Errorf("pre-migration backup failed, not running %s: %v", r.command, err)
+		}
+		pl.logger().Printf("Backup %s taken", r.backup.Reference)
+	}
+
+	// Record the planned change in its ticket, a run requiring one doesn't start without it
+	if pl.ChangeTickets != nil && !pl.DryRun && pl.ChangeTickets.records(r.command) {
+		if r.ticket, err = pl.openChangeTicket(ctx, r.id, r.command, r.arguments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch the output for progress, failures, and for changeset timings and
+// applied changesets which are only logged at info level
+func (r *commandRun) watchOutput() {
+	pl := r.pl
+	r.progress = &progressTracker{}
+	observers := []func(string){r.progress.observe}
+	if pl.TimingReport != "" {
+		r.timings = &timingCollector{threshold: pl.TimingThreshold}
+		observers = append(observers, r.timings.observe)
+	}
+	if r.attestationKey != nil {
+		r.applied = &appliedCollector{}
+		observers = append(observers, r.applied.observe)
+	}
+	changesetEvents := pl.changesetEvents()
+	if changesetEvents {
+		observers = append(observers, pl.changesetObserver(r.id, r.command))
+	}
+	r.failures = &failureCollector{}
+	observers = append(observers, r.failures.observe)
+	if r.conn.entra != nil {
+		observers = append(observers, r.conn.entra.observe)
+	}
+	if (r.timings != nil || r.applied != nil || changesetEvents) && pl.LogLevel == "" {
+		r.cmdArgs = append([]string{"--log-level=info"}, r.cmdArgs...)
+	}
+	observe := func(line string) {
+		for _, o := range observers {
+			o(line)
+		}
+	}
+	r.stdoutLines, r.stderrLines = newLineWriter(observe), newLineWriter(observe)
+	r.terminal = r.stderr
+	if !pl.StackTraces {
+		r.traces = newStackTraceFilter(r.stderr)
+		r.stderr = r.traces
+	}
+	r.stdout = io.MultiWriter(r.stdout, r.stdoutLines)
+	r.stderr = io.MultiWriter(r.stderr, r.stderrLines)
+}
+
+// Put the command together: its sandbox, Java settings, credentials and
+// connection, and the log file taping its output
+func (r *commandRun) buildCommand() error {
+	pl := r.pl
+	r.cmd = &Command{
+		Path:   filepath.Join(r.liquibaseDir, "liquibase"),
+		Args:   r.cmdArgs,
+		Stdout: r.stdout,
+		Stderr: r.stderr,
+	}
+
+	if pl.Sandbox {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		box, err := newSandbox(r.liquibaseDir)
+		if err != nil {
+			return err
+		}
+		r.cleanups = append(r.cleanups, func() { box.Close() })
+		box.apply(r.cmd, cwd, r.command)
+		pl.logger().Printf("Running in sandbox %s", box.dir)
+	}
+	var err error
+	if r.cmd.Args, err = pl.translateArgs(r.cmd.Args); err != nil {
+		return err
+	}
+	if home := pl.JavaHome(); home != "" {
+		useJavaHome(r.cmd, home)
+	}
+	if pl.DisableAnalytics {
+		optOutAnalytics(r.cmd)
+	}
+	if err := pl.useReadOnlyCredentials(r.cmd, r.command); err != nil {
+		return err
+	}
+	if javaOpts := pl.Encoding.javaOptions(); len(javaOpts) > 0 {
+		addJavaOptions(r.cmd, javaOpts)
+	}
+	r.conn.apply(r.cmd)
+
+	// Tape the full output to a log file, a failure to do so doesn't stop the run
+	if pl.LogDir != "" {
+		if r.logFile, err = pl.openRunLog(r.id, r.command, r.cmd.Args); err != nil {
+			pl.logger().Printf("Failed to open a run log in %s: %v", pl.LogDir, err)
+		} else {
+			r.cmd.Stdout, r.cmd.Stderr = io.MultiWriter(r.cmd.Stdout, r.logFile), io.MultiWriter(r.cmd.Stderr, r.logFile)
+			pl.logger().Printf("Logging output to %s", r.logFile.Path())
+		}
+	}
+	return nil
+}
+
+// Run the prepared command and write out its output, returning how it ended
+func (r *commandRun) run(ctx context.Context) error {
+	pl := r.pl
+	pl.logger().Printf("Current working dir is %s", os.Getenv("PWD"))
+	pl.logger().Printf("Executing liquibase %s", QuoteArgs(r.cmd.Args))
+
+	pl.emit(Event{Type: EVENT_STARTED, RunID: r.id, Time: r.start, Command: r.command, LogFile: r.logFile.Path()})
+
+	stop := make(chan struct{})
+	if pl.HeartbeatInterval > 0 {
+		go pl.heartbeat(r.id, r.command, r.start, pl.HeartbeatInterval, r.progress, stop)
+	}
+	err := pl.runner().Run(ctx, r.cmd)
+	// A token can be revoked or expire early, the login is retried once with
+	// a fresh one. Nothing ran without a login, so only the command is rerun.
+	if err != nil && r.conn.entra.tokenRejected() {
+		r.stdoutLines.Flush()
+		r.stderrLines.Flush()
+		pl.logger().Printf("Azure SQL rejected the access token, retrying with a fresh one")
+		if refreshErr := r.conn.refreshAccessToken(ctx, pl.Entra); refreshErr != nil {
+			pl.logger().Printf("Failed to refresh the access token: %v", refreshErr)
+		} else {
+			if r.generatedSQL != nil {
+				r.generatedSQL.Reset()
+			}
+			err = pl.runner().Run(ctx, r.cmd)
+		}
+	}
+	close(stop)
+	r.stdoutLines.Flush()
+	r.stderrLines.Flush()
+	if r.traces != nil {
+		r.traces.Flush()
+	}
+	// Explain the failures we recognize, rather than leave it to the trace
+	if err != nil {
+		if explanation := pl.explainRun(ctx, r.failures); explanation != nil {
+			fmt.Fprintf(r.terminal, "\n%s", explanation)
+		}
+	}
+	if r.generatedSQL != nil {
+		if err != nil {
+			r.sqlOut.Write(r.generatedSQL.Bytes())
+		} else if err = pl.rewriteOutputFile(r.arguments); err == nil {
+			err = pl.writeSafeRewrite(r.sqlOut, r.generatedSQL.Bytes())
+		}
+	}
+	return err
+}
+
+// Record how the run ended: its log, event, ticket, journal record,
+// attestation and timing report. Returns the error of the run.
+func (r *commandRun) record(ctx context.Context, err error) error {
+	pl := r.pl
+	lastLine, changeset := r.progress.snapshot()
+	finished := Event{Type: EVENT_COMPLETED, RunID: r.id, Command: r.command, ElapsedMs: time.Since(r.start).Milliseconds(), Changeset: changeset, LastLine: lastLine}
+	if err != nil {
+		finished.Type = EVENT_FAILED
+		finished.Error = err.Error()
+	}
+	if closeErr := r.logFile.Close(finished.Type, time.Since(r.start), err); closeErr != nil {
+		pl.logger().Printf("Failed to write run log %s: %v", r.logFile.Path(), closeErr)
+	}
+	finished.LogFile = r.logFile.Path()
+	pl.emit(finished)
+	if r.ticket != nil {
+		pl.resolveChangeTicket(ctx, r.ticket, r.id, finished)
+	}
+	pl.journal(RunRecord{
+		ID:             r.id,
+		Command:        strings.Join(r.arguments, " "),
+		Environment:    pl.Environment,
+		Start:          r.start,
+		End:            time.Now(),
+		Status:         finished.Type,
+		Error:          finished.Error,
+		WindowOverride: pl.WindowOverride,
+		CI:             pl.CI,
+		LogFile:        r.logFile.Path(),
+		Backup:         r.backup,
+		Ticket:         r.ticket.Key(),
+	})
+	attestationErr := r.attest(finished)
+
+	if r.timings != nil {
+		if reportErr := WriteTimingReport(pl.TimingReport, r.timings.report(r.arguments, pl.logger())); reportErr != nil {
+			pl.logger().Printf("Failed to write timing report: %v", reportErr)
+		}
+	}
+
+	if err != nil {
+		if r.conn.entra.tokenRejected() {
+			return fmt.Errorf("failed to execute liquibase command: %w: %v", errAccessTokenRejected, err)
+		}
+		return fmt.Errorf("failed to execute liquibase command: %v", err)
+	}
+	if attestationErr != nil {
+		return fmt.Errorf("liquibase %s succeeded but its attestation could not be written: %v", r.command, attestationErr)
+	}
+	return nil
+}
+
+// Write the attestation of the changesets the run applied, when it attests
+func (r *commandRun) attest(finished Event) error {
+	if r.applied == nil {
+		return nil
+	}
+	pl := r.pl
+	path, err := pl.writeAttestation(r.attestationKey, RunPredicate{
+		RunID:             r.id,
+		Command:           strings.Join(r.arguments, " "),
+		Environment:       pl.Environment,
+		Operator:          currentOperator(pl.CI),
+		CI:                pl.CI,
+		LiquibaseVersion:  pl.InstalledVersion(),
+		StartedOn:         r.start.UTC(),
+		FinishedOn:        time.Now().UTC(),
+		Status:            finished.Type,
+		Error:             finished.Error,
+		ChangeSetsApplied: r.applied.keys,
+	})
+	if err != nil {
+		pl.logger().Printf("Failed to write the attestation of run %s: %v", r.id, err)
+		return err
+	}
+	pl.logger().Printf("Attestation written to %s", path)
+	return nil
+}
+
+// Options before the command that take no value
+var FLAG_OPTIONS = []string{"--help", "-h", "--version", "-v"}
+
+// Return the Liquibase command in a list of arguments, the first one that
+// isn't an option. Options before the command must be written --key=value:
+// a value given as the next argument, or a -- ending the options, would make
+// the command ambiguous, so both are refused.
+func parseCommand(arguments []string) (string, error) {
+	for i, arg := range arguments {
+		if arg == "--" {
+			return "", fmt.Errorf("-- is not supported before the Liquibase command")
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return arg, nil
+		}
+		if !strings.Contains(arg, "=") && !containsString(FLAG_OPTIONS, arg) && i+1 < len(arguments) && !strings.HasPrefix(arguments[i+1], "-") {
+			return "", fmt.Errorf("option %s before the command must be written as %s=<value>", arg, arg)
+		}
+	}
+	return "", nil
+}
+
+// Record a run in the journal. Failures are logged, never fatal.
+func (pl *GoLiquibase) journal(record RunRecord) {
+	if pl.JournalFile == "" {
+		return
+	}
+	if err := AppendJournal(pl.JournalFile, record); err != nil {
+		pl.logger().Printf("Failed to write run journal %s: %v", pl.JournalFile, err)
+	}
+}
+
+// Add an argument to every command. Like the other settings it must not be
+// changed while commands run, use ExecOptions for per-call arguments.
+func (pl *GoLiquibase) AddArg(key, val string) error {
+	if err := ValidateArgKey(key); err != nil {
+		return err
+	}
+	if err := checkControlChars(key, val); err != nil {
+		return err
+	}
+	pl.Args = append(pl.Args, fmt.Sprintf("--%s=%s", key, val))
+	return nil
+}
+
+// Set a changelog property passed to Liquibase as -Dkey=value. Like the other
+// settings it must not be changed while commands run.
+func (pl *GoLiquibase) SetChangelogProperty(key, val string) {
+	if pl.ChangelogProperties == nil {
+		pl.ChangelogProperties = map[string]string{}
+	}
+	pl.ChangelogProperties[key] = val
+}
+
+// Build the -Dkey=value arguments for changelog properties
+func changelogPropertyArgs(properties map[string]string) ([]string, error) {
+	var args []string
+	for _, key := range sortedKeys(properties) {
+		if err := ValidatePropertyKey(key); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("-D%s=%s", key, properties[key]))
+	}
+	return args, nil
+}
+
+// Update the database
+func (pl *GoLiquibase) Update() error {
+	return pl.Execute("update")
+}
+
+// Update the database with SQL statements
+func (pl *GoLiquibase) UpdateSQL() error {
+	return pl.Execute("update-sql")
+}
+
+// Update to a specific tag
+func (pl *GoLiquibase) UpdateToTag(tag string) error {
+	if err := ValidateTag(tag); err != nil {
+		return err
+	}
+	pl.logger().Printf("Updating to tag: %s", tag)
+	return pl.Execute("update-to-tag", tag)
+}
+
+// Validate the database schema
+func (pl *GoLiquibase) Validate() error {
+	return pl.Execute("validate")
+}
+
+// Show the current status of the database
+func (pl *GoLiquibase) Status() error {
+	return pl.Execute("status")
+}
+
+// Rollback the database to a specific tag
+func (pl *GoLiquibase) Rollback(tag string) error {
+	if err := ValidateTag(tag); err != nil {
+		return err
+	}
+	pl.logger().Printf("Rolling back to tag: %s", tag)
+	return pl.Execute("rollback", tag)
+}
+
+// Rollback the database to a specific datetime
+func (pl *GoLiquibase) RollbackToDatetime(datetime time.Time) error {
+	date := pl.formatDatetime(datetime)
+	pl.logger().Printf("Rolling back to %s", date)
+	return pl.Execute("rollback-to-date", fmt.Sprintf("--date=%s", date))
+}
+
+// Generate the SQL to rollback the database to a specific datetime
+func (pl *GoLiquibase) RollbackToDateSQL(datetime time.Time) error {
+	date := pl.formatDatetime(datetime)
+	pl.logger().Printf("Generating rollback SQL to %s", date)
+	return pl.Execute("rollback-to-date-sql", fmt.Sprintf("--date=%s", date))
+}
+
+// Format a datetime the way Liquibase expects. Liquibase reads it without a
+// time zone, so it is converted to the database time zone first.
+func (pl *GoLiquibase) formatDatetime(datetime time.Time) string {
+	loc := pl.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return datetime.In(loc).Format(LIQUIBASE_DATETIME_FORMAT)
+}
+
+// Sync the changelog with the database
+func (pl *GoLiquibase) ChangelogSync() error {
+	pl.logger().Println("Marking all undeployed changes as executed in database.")
+	return pl.Execute("changelog-sync")
+}
+
+// Sync the changelog with the database up to a specific tag
+func (pl *GoLiquibase) ChangelogSyncToTag(tag string) error {
+	if err := ValidateTag(tag); err != nil {
+		return err
+	}
+	pl.logger().Printf("Marking all undeployed changes as executed up to tag %s in database.", tag)
+	return pl.Execute("changelog-sync-to-tag", tag)
+}
+
+// Clear checksums in the database
+func (pl *GoLiquibase) ClearChecksums() error {
+	pl.logger().Println("Clearing checksums in database.")
+	return pl.Execute("clear-checksums")
+}
+
+// Check if a tag has been applied to the database. Output not saying either
+// way, e.g. empty or localized, is an error rather than taken as an answer.
+func (pl *GoLiquibase) TagExists(tag string) (bool, error) {
+	if err := ValidateTag(tag); err != nil {
+		return false, err
+	}
+	out, err := pl.Output("tag-exists", fmt.Sprintf("--tag=%s", tag))
+	if err != nil {
+		return false, err
+	}
+	switch lower := strings.ToLower(out); {
+	case strings.Contains(lower, "does not exist"):
+		return false, nil
+	case strings.Contains(lower, " exists"):
+		return true, nil
+	}
+	return false, fmt.Errorf("unrecognized tag-exists output for tag %s: %q", tag, strings.TrimSpace(out))
+}
+
+// Release locks in the database
+func (pl *GoLiquibase) ReleaseLocks() error {
+	pl.logger().Println("Releasing locks in database.")
+	return pl.Execute("release-locks")
+}
+
+// Download Liquibase from Github and extract it
+func (pl *GoLiquibase) DownloadLiquibase() error {
+	return pl.downloadLiquibase(context.Background())
+}
+
+func (pl *GoLiquibase) downloadLiquibase(ctx context.Context) error {
+	// Processes sharing the cache install a version one at a time
+	if err := os.MkdirAll(filepath.Dir(pl.LiquibaseDir), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockInstall(pl.LiquibaseDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if isLiquibaseInstalled(pl.LiquibaseDir) {
+		err := verifyInstall(pl.LiquibaseDir)
+		if err == errNoInstallManifest {
+			// Those were moved in place whole too, they are taken as they are
+			err = writeInstallManifest(pl.LiquibaseDir, pl.Version)
+		}
+		if err == nil {
+			pl.logger().Printf("Liquibase version %s found, skipping download...", pl.Version)
+			return nil
+		}
+		pl.logger().Printf("Liquibase version %s in %s is damaged (%v), extracting it again", pl.Version, pl.LiquibaseDir, err)
+	}
+
+	return pl.installDistribution(ctx, versioned(LIQUIBASE_ZIP_URL, pl.Version))
+}
+
+// Download Liquibase extension libraries
+func (pl *GoLiquibase) DownloadLiquibaseExtensionLibs() error {
+	return pl.downloadLiquibaseExtensionLibs(context.Background())
+}
+
+func (pl *GoLiquibase) downloadLiquibaseExtensionLibs(ctx context.Context) error {
+	matrix := pl.extensionMatrix(ctx)
+	for _, ext := range LIQUIBASE_EXT_LIST {
+		version := pl.extensionVersion(matrix, ext)
+		extVersion := fmt.Sprintf("%s-%s", ext, version)
+		extVersion2 := fmt.Sprintf("v%s", version)
+		extURL := LIQUIBASE_EXT_URL
+		extURL = strings.ReplaceAll(extURL, "{ext}", ext)
+		extURL = strings.ReplaceAll(extURL, "{extVersion}", extVersion)
+		extURL = strings.ReplaceAll(extURL, "{extVersion2}", extVersion2)
+
+		err := pl.downloadAdditionalJavaLibrary(ctx, extURL, pl.LiquibaseLibDir)
+		if err != nil {
+			pl.logger().Printf("Failed to download Liquibase extension: %s", extVersion)
+		}
+	}
+	return nil
+}
+
+// Download a file from a given URL
+func (pl *GoLiquibase) downloadFile(ctx context.Context, url, destination string) error {
+	pl.logger().Printf("Downloading %s to %s", url, destination)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading file: %s", response.Status)
+	}
+
+	file, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, response.Body)
+	return err
+}
+
+// Download an additional java library
+func (pl *GoLiquibase) downloadAdditionalJavaLibrary(ctx context.Context, downloadURL, destinationDir string) error {
+	parsedURL, err := url.Parse(downloadURL)
+	if err != nil {
+		return err
+	}
+
+	libFileName := filepath.Base(parsedURL.Path)
+	if !strings.HasSuffix(libFileName, ".zip") && !strings.HasSuffix(libFileName, ".jar") {
+		return fmt.Errorf("unexpected URL. Expecting link to a **.jar** or **.zip** file")
+	}
+
+	destinationFile := filepath.Join(destinationDir, libFileName)
+
+	if fileExists(destinationFile) {
+		pl.logger().Printf("Java lib already available, skipping download: %s", destinationFile)
+		return nil
+	}
+
+	pl.logger().Printf("Downloading java lib: %s to %s", downloadURL, destinationFile)
+	return pl.downloadFile(ctx, downloadURL, destinationFile)
+}
+
+// Substitute the Liquibase version in a URL or file name template
+func versioned(template, version string) string {
+	return strings.ReplaceAll(template, "{version}", version)
+}
+
+// Check if a directory exists
+func dirExists(dirname string) bool {
+	info, err := os.Stat(dirname)
+	return err == nil && info.IsDir()
+}
+
+// Check if a file exists
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false
+	}
+	return !info.IsDir()
+}
+
+// Unzip a zip file
+func unzipFile(zipFilePath, destinationDir string) error {
+	reader, err := zip.OpenReader(zipFilePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	root, err := filepath.Abs(destinationDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range reader.File {
+		// Entries must stay in the destination, "../" names can't write elsewhere
+		filePath := filepath.Join(root, file.Name)
+		if filePath != root && !strings.HasPrefix(filePath, root+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %s in %s", file.Name, filepath.Base(zipFilePath))
+		}
+
+		// Check for directory creation
+		if !file.FileInfo().IsDir() {
+			// Extract the file
+			fileReader, err := file.Open()
+			if err != nil {
+				return err
+			}
+			defer fileReader.Close()
+
+			// Create the file
+			os.MkdirAll(filepath.Dir(filePath), 0755)
+
+			// Write the file to the destination, keeping the mode so the launcher stays executable
+			fileWriter, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode().Perm()|0600)
+			if err != nil {
+				return err
+			}
+			defer fileWriter.Close()
+
+			_, err = io.Copy(fileWriter, fileReader)
+			if err != nil {
+				return err
+			}
+		} else {
+			// Create the directory
+			os.MkdirAll(filePath, 0755)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package goliquify_test
+
+import (
+	"testing"
+
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+func TestTagExists(t *testing.T) {
+	for _, tc := range []struct {
+		output string
+		exists bool
+		fails  bool
+	}{
+		{output: "The tag 'v1' exists in jdbc:postgresql://db/app\n", exists: true},
+		{output: "The tag 'v1' does NOT exist in jdbc:postgresql://db/app\n"},
+		{output: "", fails: true},
+		{output: "Das Tag 'v1' existiert\n", fails: true},
+	} {
+		pl, runner := goliquifytest.New(t)
+		runner.On("tag-exists", goliquifytest.Response{Stdout: tc.output})
+		exists, err := pl.TagExists("v1")
+		if (err != nil) != tc.fails {
+			t.Fatalf("output %q: unexpected error %v", tc.output, err)
+		}
+		if exists != tc.exists {
+			t.Fatalf("output %q: exists is %v, want %v", tc.output, exists, tc.exists)
+		}
+	}
+}
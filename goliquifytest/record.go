@@ -0,0 +1,239 @@
+package goliquifytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// RECORD_ENV set to any value makes Golden record against a real Liquibase
+// and database instead of replaying, e.g. GOLIQUIFY_RECORD=1 go test ./...
+const RECORD_ENV = "GOLIQUIFY_RECORD"
+
+// Recording is a Liquibase invocation and its output, as kept in a golden file
+type Recording struct {
+	Command string `json:"command"`
+	// Args are the command and the arguments after it. The global arguments
+	// before it, e.g. the classpath, depend on the machine and aren't kept,
+	// nor are the run and CI metadata properties. Password values are redacted.
+	Args     []string `json:"args"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exitCode,omitempty"`
+}
+
+// Properties differing between a recording and its replays
+var unrecordedProperties = []string{
+	goliquify.PROPERTY_RUN_ID, goliquify.PROPERTY_GIT_SHA, goliquify.PROPERTY_PIPELINE_URL, goliquify.PROPERTY_ACTOR,
+}
+
+// The command and the arguments after it as they are recorded
+func recordedArgs(arguments []string) []string {
+	args := []string{}
+	for i, arg := range arguments {
+		if !strings.HasPrefix(arg, "-") {
+			arguments = arguments[i:]
+			break
+		}
+	}
+	for _, arg := range arguments {
+		name, _, ok := strings.Cut(arg, "=")
+		switch {
+		case !ok:
+		case slices.Contains(unrecordedProperties, strings.TrimPrefix(name, "-D")):
+			continue
+		case strings.HasPrefix(name, "--") && strings.Contains(strings.ToLower(name), "password"):
+			arg = name + "=REDACTED"
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// RecordingRunner runs commands on another runner and records them with
+// their output, to be written to a golden file with Save. Safe for
+// concurrent use.
+type RecordingRunner struct {
+	// Next runs the commands, a goliquify.ExecRunner when nil
+	Next goliquify.Runner
+
+	path       string
+	mu         sync.Mutex
+	recordings []Recording
+}
+
+// NewRecordingRunner returns a runner recording the commands it runs into
+// the golden file at path
+func NewRecordingRunner(path string) *RecordingRunner {
+	return &RecordingRunner{path: path}
+}
+
+// Run runs the command and records its output and exit code. Commands that
+// can't be started aren't recorded.
+func (r *RecordingRunner) Run(ctx context.Context, cmd *goliquify.Command) error {
+	next := r.Next
+	if next == nil {
+		next = goliquify.ExecRunner{}
+	}
+	var stdout, stderr bytes.Buffer
+	run := *cmd
+	run.Stdout, run.Stderr = tee(cmd.Stdout, &stdout), tee(cmd.Stderr, &stderr)
+	err := next.Run(ctx, &run)
+
+	recording := Recording{Command: commandName(cmd.Args), Args: recordedArgs(cmd.Args), Stdout: stdout.String(), Stderr: stderr.String()}
+	var execErr *exec.ExitError
+	var fakeErr *ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &execErr):
+		recording.ExitCode = execErr.ExitCode()
+	case errors.As(err, &fakeErr):
+		recording.ExitCode = fakeErr.Code
+	default:
+		return err
+	}
+	r.mu.Lock()
+	r.recordings = append(r.recordings, recording)
+	r.mu.Unlock()
+	return err
+}
+
+func tee(w io.Writer, buf *bytes.Buffer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(w, buf)
+}
+
+// Recordings returns the commands recorded so far, in order
+func (r *RecordingRunner) Recordings() []Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Recording{}, r.recordings...)
+}
+
+// Save writes the recorded commands to the golden file, replacing it
+func (r *RecordingRunner) Save() error {
+	data, err := json.MarshalIndent(r.Recordings(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0644)
+}
+
+// ReplayRunner answers commands with the output recorded in a golden file,
+// without running anything. Each command gets the first recording of the
+// same command and arguments it hasn't replayed yet, so repeated commands
+// are answered in the order they were recorded. Safe for concurrent use.
+type ReplayRunner struct {
+	path       string
+	mu         sync.Mutex
+	recordings []Recording
+	replayed   []bool
+}
+
+// NewReplayRunner reads the golden file at path
+func NewReplayRunner(path string) (*ReplayRunner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recordings []Recording
+	if err := json.Unmarshal(data, &recordings); err != nil {
+		return nil, fmt.Errorf("failed to read golden file %s: %v", path, err)
+	}
+	return &ReplayRunner{path: path, recordings: recordings, replayed: make([]bool, len(recordings))}, nil
+}
+
+// Run plays the recorded output of the command. A command that wasn't
+// recorded fails.
+func (r *ReplayRunner) Run(ctx context.Context, cmd *goliquify.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	args := recordedArgs(cmd.Args)
+	recording, ok := r.next(args)
+	if !ok {
+		return fmt.Errorf("no recording of liquibase %s left in %s, record it again with %s=1", goliquify.QuoteArgs(args), r.path, RECORD_ENV)
+	}
+	if cmd.Stdout != nil && recording.Stdout != "" {
+		io.WriteString(cmd.Stdout, recording.Stdout)
+	}
+	if cmd.Stderr != nil && recording.Stderr != "" {
+		io.WriteString(cmd.Stderr, recording.Stderr)
+	}
+	if recording.ExitCode != 0 {
+		return &ExitError{Command: recording.Command, Code: recording.ExitCode}
+	}
+	return nil
+}
+
+// Find and use up the first recording of the arguments not replayed yet
+func (r *ReplayRunner) next(args []string) (Recording, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, recording := range r.recordings {
+		if !r.replayed[i] && slices.Equal(recording.Args, args) {
+			r.replayed[i] = true
+			return recording, true
+		}
+	}
+	return Recording{}, false
+}
+
+// Remaining returns the recordings not replayed yet, in order
+func (r *ReplayRunner) Remaining() []Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var remaining []Recording
+	for i, recording := range r.recordings {
+		if !r.replayed[i] {
+			remaining = append(remaining, recording)
+		}
+	}
+	return remaining
+}
+
+// Golden returns a GoLiquibase instance for a test replaying the golden file
+// at path, e.g. testdata/update.golden.json. The test fails when a command
+// it runs wasn't recorded, or a recorded one isn't run. With RECORD_ENV set,
+// commands run on the Liquibase and database the options configure instead,
+// and the golden file is written when the test ends.
+func Golden(t testing.TB, path string, opts ...goliquify.Option) *goliquify.GoLiquibase {
+	t.Helper()
+	if os.Getenv(RECORD_ENV) != "" {
+		recorder := NewRecordingRunner(path)
+		t.Cleanup(func() {
+			if err := recorder.Save(); err != nil {
+				t.Errorf("failed to write golden file %s: %v", path, err)
+			}
+		})
+		return goliquify.New(append(opts, goliquify.WithRunner(recorder))...)
+	}
+	replay, err := NewReplayRunner(path)
+	if err != nil {
+		t.Fatalf("failed to replay %s, record it with %s=1: %v", path, RECORD_ENV, err)
+	}
+	t.Cleanup(func() {
+		for _, recording := range replay.Remaining() {
+			t.Errorf("recorded command liquibase %s was not run", goliquify.QuoteArgs(recording.Args))
+		}
+	})
+	// Nothing runs, so the install the options point at may not exist
+	return goliquify.New(append(opts, goliquify.WithLiquibaseDir(placeholderInstall(t)), goliquify.WithRunner(replay))...)
+}
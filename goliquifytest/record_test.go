@@ -0,0 +1,119 @@
+package goliquifytest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "testdata", "deploy.golden.json")
+
+	// Record from a scripted runner standing in for Liquibase
+	fake := NewRunner()
+	fake.On("update", Response{Stdout: "Running Changeset: db/changelog.xml::1::bob\n", Stderr: "INFO done\n"})
+	fake.Once("status", Response{Stdout: "1 changeset has not been applied\n", ExitCode: 1})
+	fake.On("status", Response{Stdout: "up to date\n"})
+	recorder := NewRecordingRunner(golden)
+	recorder.Next = fake
+	recording, _ := New(t, goliquify.WithRunner(recorder))
+	recorded := deploy(t, recording)
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayRunner(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, _ := New(t, goliquify.WithRunner(replay))
+	if replayed := deploy(t, pl); !reflect.DeepEqual(replayed, recorded) {
+		t.Fatalf("replayed %q, recorded %q", replayed, recorded)
+	}
+	if remaining := replay.Remaining(); len(remaining) != 0 {
+		t.Fatalf("recordings not replayed: %+v", remaining)
+	}
+	err = pl.Execute("update")
+	if err == nil || !strings.Contains(err.Error(), "no recording of liquibase update") {
+		t.Fatalf("update beyond the recordings failed with %v", err)
+	}
+}
+
+// Run the commands of a deployment, returning what each printed and how it ended
+func deploy(t *testing.T, pl *goliquify.GoLiquibase) []string {
+	t.Helper()
+	var results []string
+	for _, args := range [][]string{{"status"}, {"update", "--password=s3cret"}, {"status"}} {
+		var stdout, stderr bytes.Buffer
+		err := pl.ExecuteWithOptions(context.Background(), goliquify.ExecOptions{Stdout: &stdout, Stderr: &stderr}, args...)
+		status := "ok"
+		if err != nil {
+			status = err.Error()
+		}
+		results = append(results, stdout.String()+stderr.String()+status)
+	}
+	return results
+}
+
+func TestRecordingLeavesOutMachineSpecificArguments(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "golden.json")
+	recorder := NewRecordingRunner(golden)
+	recorder.Next = NewRunner()
+	pl, _ := New(t, goliquify.WithRunner(recorder), goliquify.WithArgs("--classpath=/home/me/drivers"))
+	if err := pl.Execute("update", "--password=s3cret", "--contexts=prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"s3cret", "/home/me/drivers", goliquify.PROPERTY_RUN_ID} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("golden file contains %s:\n%s", leaked, data)
+		}
+	}
+	if want := []string{"update", "--password=REDACTED", "--contexts=prod"}; !reflect.DeepEqual(recorder.Recordings()[0].Args, want) {
+		t.Fatalf("recorded %v, want %v", recorder.Recordings()[0].Args, want)
+	}
+}
+
+func TestRecordingSkipsCommandsThatCantStart(t *testing.T) {
+	started := errors.New("no java")
+	fake := NewRunner()
+	fake.On("update", Response{Err: started})
+	recorder := NewRecordingRunner(filepath.Join(t.TempDir(), "golden.json"))
+	recorder.Next = fake
+	if _, _, err := run(t, recorder, "update"); err != started {
+		t.Fatalf("update failed with %v, want %v", err, started)
+	}
+	if recordings := recorder.Recordings(); len(recordings) != 0 {
+		t.Fatalf("recorded %+v", recordings)
+	}
+}
+
+func TestGoldenReplays(t *testing.T) {
+	t.Setenv(RECORD_ENV, "")
+	golden := filepath.Join(t.TempDir(), "golden.json")
+	if err := os.WriteFile(golden, []byte(`[{"command": "history", "args": ["history"], "stdout": "- db/changelog.xml::1::bob\n"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The install of the recording machine doesn't need to exist
+	pl := Golden(t, golden, goliquify.WithLiquibaseDir("/opt/liquibase-not-here"))
+	out, err := pl.Output("history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "- db/changelog.xml::1::bob\n" {
+		t.Fatalf("history printed %q", out)
+	}
+}
@@ -0,0 +1,212 @@
+// Package goliquifytest provides a fake Liquibase for testing code built on
+// GoLiquify without Java or a database
+package goliquifytest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// Invocation is a Liquibase command the fake runner received
+type Invocation struct {
+	// Command is the Liquibase command, e.g. update
+	Command string
+	Args    []string
+	Env     []string
+	Dir     string
+}
+
+// Arg returns the value of a --name=value argument
+func (inv Invocation) Arg(name string) (string, bool) {
+	prefix := "--" + strings.TrimPrefix(name, "--") + "="
+	for _, arg := range inv.Args {
+		if v, ok := strings.CutPrefix(arg, prefix); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Response is the scripted result of a command
+type Response struct {
+	Stdout string
+	Stderr string
+	// ExitCode other than 0 makes the command fail with an ExitError
+	ExitCode int
+	// Err makes the command fail as if Liquibase could not be started
+	Err error
+}
+
+// ExitError is returned for commands scripted to exit with a non-zero code
+type ExitError struct {
+	Command string
+	Code    int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("liquibase %s: exit status %d", e.Command, e.Code)
+}
+
+// A scripted response and the invocations it applies to
+type rule struct {
+	match    func(Invocation) bool
+	response Response
+	once     bool
+}
+
+// Runner is a fake goliquify.Runner. It records every invocation and answers
+// with the first matching scripted response, or Default. Safe for concurrent use.
+type Runner struct {
+	// Default is the response to commands nothing was scripted for
+	Default Response
+
+	mu          sync.Mutex
+	rules       []*rule
+	invocations []Invocation
+}
+
+// NewRunner returns a fake runner on which every command succeeds without output
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+// On scripts the response to every invocation of a command
+func (r *Runner) On(command string, response Response) *Runner {
+	return r.OnMatch(commandIs(command), response)
+}
+
+// Once scripts the response to the next invocation of a command. Once
+// responses are used up in the order they were scripted, before On responses.
+func (r *Runner) Once(command string, response Response) *Runner {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, &rule{match: commandIs(command), response: response, once: true})
+	return r
+}
+
+// OnMatch scripts the response to every invocation match accepts
+func (r *Runner) OnMatch(match func(Invocation) bool, response Response) *Runner {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, &rule{match: match, response: response})
+	return r
+}
+
+func commandIs(command string) func(Invocation) bool {
+	return func(inv Invocation) bool { return inv.Command == command }
+}
+
+// Run records the command and plays its scripted response
+func (r *Runner) Run(ctx context.Context, cmd *goliquify.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	inv := Invocation{
+		Command: commandName(cmd.Args),
+		Args:    append([]string{}, cmd.Args...),
+		Env:     append([]string{}, cmd.Env...),
+		Dir:     cmd.Dir,
+	}
+	response := r.respond(inv)
+
+	if response.Err != nil {
+		return response.Err
+	}
+	if cmd.Stdout != nil && response.Stdout != "" {
+		io.WriteString(cmd.Stdout, response.Stdout)
+	}
+	if cmd.Stderr != nil && response.Stderr != "" {
+		io.WriteString(cmd.Stderr, response.Stderr)
+	}
+	if response.ExitCode != 0 {
+		return &ExitError{Command: inv.Command, Code: response.ExitCode}
+	}
+	return nil
+}
+
+// Record an invocation and find its response
+func (r *Runner) respond(inv Invocation) Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.invocations = append(r.invocations, inv)
+
+	for _, once := range []bool{true, false} {
+		for i, rule := range r.rules {
+			if rule.once != once || !rule.match(inv) {
+				continue
+			}
+			if once {
+				r.rules = append(r.rules[:i:i], r.rules[i+1:]...)
+			}
+			return rule.response
+		}
+	}
+	return r.Default
+}
+
+// Invocations returns the commands run so far, in order
+func (r *Runner) Invocations() []Invocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Invocation{}, r.invocations...)
+}
+
+// Commands returns the names of the commands run so far, in order
+func (r *Runner) Commands() []string {
+	var commands []string
+	for _, inv := range r.Invocations() {
+		commands = append(commands, inv.Command)
+	}
+	return commands
+}
+
+// Reset forgets the recorded invocations and scripted responses
+func (r *Runner) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules, r.invocations = nil, nil
+}
+
+// Return the Liquibase command in a list of arguments, the first one that isn't a flag
+func commandName(arguments []string) string {
+	for _, arg := range arguments {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// New returns a GoLiquibase instance running commands on a fake runner. It
+// points at a placeholder Liquibase install, so Initialize succeeds without
+// downloading anything. Options are applied after the test settings.
+func New(t testing.TB, opts ...goliquify.Option) (*goliquify.GoLiquibase, *Runner) {
+	t.Helper()
+	runner := NewRunner()
+	pl := goliquify.New(append([]goliquify.Option{
+		goliquify.WithLiquibaseDir(placeholderInstall(t)),
+		goliquify.WithRunner(runner),
+	}, opts...)...)
+	return pl, runner
+}
+
+// Create a Liquibase install whose launchers are never run, for instances
+// running commands on a fake runner
+func placeholderInstall(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, launcher := range []string{"liquibase", "liquibase.bat"} {
+		if err := os.WriteFile(filepath.Join(dir, launcher), []byte("placeholder, commands run on a goliquifytest runner\n"), 0755); err != nil {
+			t.Fatalf("failed to create the placeholder Liquibase install: %v", err)
+		}
+	}
+	return dir
+}
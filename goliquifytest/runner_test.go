@@ -0,0 +1,137 @@
+package goliquifytest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// Run a command on the runner, returning its output
+func run(t *testing.T, r goliquify.Runner, args ...string) (string, string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := r.Run(context.Background(), &goliquify.Command{Path: "liquibase", Args: args, Stdout: &stdout, Stderr: &stderr})
+	return stdout.String(), stderr.String(), err
+}
+
+func TestRunnerScriptedResponses(t *testing.T) {
+	r := NewRunner()
+	r.Default = Response{Stdout: "default\n"}
+	r.On("status", Response{Stdout: "status\n"})
+	r.OnMatch(func(inv Invocation) bool {
+		tag, _ := inv.Arg("tag")
+		return inv.Command == "tag-exists" && tag == "v1"
+	}, Response{Stdout: "v1 exists\n"})
+
+	for _, tc := range []struct {
+		args   []string
+		stdout string
+	}{
+		{[]string{"--log-level=info", "status"}, "status\n"},
+		{[]string{"tag-exists", "--tag=v1"}, "v1 exists\n"},
+		{[]string{"tag-exists", "--tag=v2"}, "default\n"},
+		{[]string{"update"}, "default\n"},
+	} {
+		stdout, _, err := run(t, r, tc.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stdout != tc.stdout {
+			t.Errorf("%v printed %q, want %q", tc.args, stdout, tc.stdout)
+		}
+	}
+	if want := []string{"status", "tag-exists", "tag-exists", "update"}; !reflect.DeepEqual(r.Commands(), want) {
+		t.Fatalf("commands %v, want %v", r.Commands(), want)
+	}
+}
+
+func TestRunnerOnceOrdering(t *testing.T) {
+	r := NewRunner()
+	r.On("update", Response{Stdout: "on\n"})
+	r.Once("update", Response{Stdout: "first\n"})
+	r.Once("update", Response{Stdout: "second\n"})
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		stdout, _, err := run(t, r, "update")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.TrimSpace(stdout))
+	}
+	if want := []string{"first", "second", "on", "on"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("responses %v, want %v", got, want)
+	}
+}
+
+func TestRunnerExitError(t *testing.T) {
+	r := NewRunner()
+	r.Once("update", Response{Stderr: "lock held\n", ExitCode: 1})
+	started := errors.New("no java")
+	r.Once("status", Response{Err: started})
+
+	_, stderr, err := run(t, r, "update")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 || exitErr.Command != "update" {
+		t.Fatalf("update failed with %v, want exit status 1", err)
+	}
+	if stderr != "lock held\n" {
+		t.Fatalf("stderr %q, want the scripted one", stderr)
+	}
+	if _, _, err := run(t, r, "status"); err != started {
+		t.Fatalf("status failed with %v, want %v", err, started)
+	}
+
+	// Through an instance the failure reaches the caller
+	pl, runner := New(t)
+	runner.Once("update", Response{ExitCode: 3})
+	if err := pl.Execute("update"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("update failed with %v, want exit status 3", err)
+	}
+	if err := pl.Execute("update"); err != nil {
+		t.Fatalf("second update failed: %v", err)
+	}
+}
+
+func TestRunnerReset(t *testing.T) {
+	r := NewRunner()
+	r.On("status", Response{ExitCode: 1})
+	if _, _, err := run(t, r, "status"); err == nil {
+		t.Fatal("scripted failure didn't fail")
+	}
+	r.Reset()
+	if len(r.Invocations()) != 0 {
+		t.Fatalf("invocations %v left after Reset", r.Invocations())
+	}
+	if _, _, err := run(t, r, "status"); err != nil {
+		t.Fatalf("response scripted before Reset still used: %v", err)
+	}
+}
+
+func TestRunnerRecordsInvocations(t *testing.T) {
+	r := NewRunner()
+	cmd := &goliquify.Command{Path: "liquibase", Args: []string{"update", "--contexts=prod"}, Env: []string{"A=1"}, Dir: "/work"}
+	if err := r.Run(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	// Later changes to the command don't reach the record
+	cmd.Args[1] = "--contexts=dev"
+	inv := r.Invocations()[0]
+	if contexts, _ := inv.Arg("contexts"); contexts != "prod" {
+		t.Fatalf("recorded contexts %q, want prod", contexts)
+	}
+	if inv.Dir != "/work" || !reflect.DeepEqual(inv.Env, []string{"A=1"}) {
+		t.Fatalf("unexpected invocation %+v", inv)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Run(ctx, cmd); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled run failed with %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package goliquifytest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	goliquify "github.com/TFMV/GoLiquify"
+)
+
+// Environment variables of the test database
+const (
+	// TEST_DSN_ENV is the database tests migrate when no DSN is passed
+	TEST_DSN_ENV = "GOLIQUIFY_TEST_DSN"
+	// TEST_IMAGE_ENV is the Postgres image started when there is no database
+	TEST_IMAGE_ENV = "GOLIQUIFY_TEST_IMAGE"
+)
+
+const (
+	DEFAULT_TEST_IMAGE = "postgres:16"
+	// Label of the containers started for tests, remove them with
+	// docker rm -f $(docker ps -aq --filter label=goliquify.testdb)
+	TEST_CONTAINER_LABEL = "goliquify.testdb"
+	TEST_DB_PASSWORD     = "goliquify"
+	TEST_DB_TIMEOUT      = 5 * time.Minute
+)
+
+// Root changelogs looked for at the top of a changelog FS
+var ROOT_CHANGELOGS = []string{
+	"changelog.xml", "changelog.yaml", "changelog.yml", "changelog.json", "changelog.sql",
+	"db.changelog-master.xml", "db.changelog-master.yaml", "db.changelog-master.yml", "db.changelog-master.json",
+}
+
+// MigrateTestDB applies the changelog in changelogFS, e.g. an embed.FS, to a
+// test database and returns its data source name. The database is dsn, else
+// GOLIQUIFY_TEST_DSN, else a Postgres container started with docker and
+// shared by every package testing the same changelog. The test is skipped
+// when there is neither a database nor docker. Migrations are cached: a
+// database already migrated with the same changelog isn't migrated again, so
+// packages after the first don't start Liquibase. The root changelog is the
+// first of ROOT_CHANGELOGS in changelogFS. Options are applied to the
+// GoLiquibase instance running the update, its defaults file is replaced
+// when the DSN has credentials.
+func MigrateTestDB(t testing.TB, dsn string, changelogFS fs.FS, opts ...goliquify.Option) string {
+	t.Helper()
+	for _, name := range ROOT_CHANGELOGS {
+		if _, err := fs.Stat(changelogFS, name); err == nil {
+			return MigrateTestDBChangelog(t, dsn, changelogFS, name, opts...)
+		}
+	}
+	t.Fatalf("no root changelog in the changelog FS, expecting one of %s", strings.Join(ROOT_CHANGELOGS, ", "))
+	return ""
+}
+
+// MigrateTestDBChangelog is MigrateTestDB with the root changelog given
+func MigrateTestDBChangelog(t testing.TB, dsn string, changelogFS fs.FS, changelog string, opts ...goliquify.Option) string {
+	t.Helper()
+	digest, err := changelogDigest(changelogFS)
+	if err != nil {
+		t.Fatalf("failed to read the changelog FS: %v", err)
+	}
+	if dsn == "" {
+		dsn = os.Getenv(TEST_DSN_ENV)
+	}
+	if dsn == "" {
+		if _, err := exec.LookPath("docker"); err != nil {
+			t.Skipf("no test database: set %s or install docker", TEST_DSN_ENV)
+		}
+		if dsn, err = startTestContainer(digest); err != nil {
+			t.Fatalf("failed to start the test database: %v", err)
+		}
+	}
+	jdbcURL, username, password, err := goliquify.JDBCFromDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Packages are tested by parallel processes, one migrates at a time
+	marker, err := cacheFile(dsn)
+	if err != nil {
+		t.Fatalf("failed to create the test database cache: %v", err)
+	}
+	unlock, err := lockFile(marker + ".lock")
+	if err != nil {
+		t.Fatalf("failed to lock the test database: %v", err)
+	}
+	defer unlock()
+	if applied, err := os.ReadFile(marker); err == nil && string(applied) == digest {
+		return dsn
+	}
+
+	dir := t.TempDir()
+	if err := writeFS(dir, changelogFS); err != nil {
+		t.Fatalf("failed to write the changelog: %v", err)
+	}
+	opts = append([]goliquify.Option{
+		goliquify.WithLogger(log.New(testWriter{t}, "", 0)),
+		goliquify.WithAnalyticsDisabled(true),
+	}, opts...)
+	// Credentials go in a defaults file, arguments are logged
+	if username != "" {
+		defaults := filepath.Join(t.TempDir(), "liquibase.properties")
+		if err := os.WriteFile(defaults, []byte(fmt.Sprintf("username=%s\npassword=%s\n", username, password)), 0600); err != nil {
+			t.Fatalf("failed to write the test database credentials: %v", err)
+		}
+		opts = append(opts, goliquify.WithDefaultsFile(defaults))
+	}
+	pl := goliquify.New(opts...)
+	if err := pl.Initialize(); err != nil {
+		t.Fatalf("failed to initialize Liquibase: %v", err)
+	}
+	args := []string{"--url=" + jdbcURL, "--search-path=" + dir}
+	if err := pl.ExecuteWithOptions(context.Background(), goliquify.ExecOptions{Args: args}, "update", "--changelog-file="+changelog); err != nil {
+		t.Fatalf("failed to migrate the test database: %v", err)
+	}
+	if err := os.WriteFile(marker, []byte(digest), 0644); err != nil {
+		t.Logf("failed to cache the test database migration: %v", err)
+	}
+	return dsn
+}
+
+// Digest the files of the changelog FS, their paths and contents
+func changelogDigest(changelogFS fs.FS) (string, error) {
+	var files []string
+	err := fs.WalkDir(changelogFS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		data, err := fs.ReadFile(changelogFS, file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", path.Clean(file), len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write the files of a FS to a directory
+func writeFS(dir string, fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(p))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+// The file recording the changelog a database was migrated with
+func cacheFile(dsn string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "goliquify", "testdb")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dsn))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])), nil
+}
+
+// Take an exclusive lock file, waiting for other processes holding it. Locks
+// left behind by crashed processes expire.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(TEST_DB_TIMEOUT)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > TEST_DB_TIMEOUT {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is still held after %s", path, TEST_DB_TIMEOUT)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Start the Postgres container of a changelog, or reuse the one running.
+// Returns its data source name.
+func startTestContainer(digest string) (string, error) {
+	name := "goliquify-testdb-" + digest[:12]
+	image := os.Getenv(TEST_IMAGE_ENV)
+	if image == "" {
+		image = DEFAULT_TEST_IMAGE
+	}
+	if _, err := docker("inspect", "--format", "{{.Id}}", name); err != nil {
+		// Another package may start it at the same time, its container is used then
+		_, runErr := docker("run", "--detach", "--name", name, "--label", TEST_CONTAINER_LABEL+"="+digest,
+			"--publish", "127.0.0.1::5432", "--env", "POSTGRES_PASSWORD="+TEST_DB_PASSWORD, image)
+		if runErr != nil {
+			if _, err := docker("inspect", "--format", "{{.Id}}", name); err != nil {
+				return "", runErr
+			}
+		}
+	}
+	if _, err := docker("start", name); err != nil {
+		return "", err
+	}
+	out, err := docker("port", name, "5432/tcp")
+	if err != nil {
+		return "", err
+	}
+	mapped := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	port := mapped[strings.LastIndex(mapped, ":")+1:]
+
+	// Postgres listens on TCP once its initialization is done
+	deadline := time.Now().Add(TEST_DB_TIMEOUT)
+	for {
+		if _, err := docker("exec", name, "pg_isready", "--host=127.0.0.1", "--username=postgres"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("the database in %s wasn't ready within %s", name, TEST_DB_TIMEOUT)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Sprintf("postgres://postgres:%s@127.0.0.1:%s/postgres?sslmode=disable", TEST_DB_PASSWORD, port), nil
+}
+
+// Run docker, returning its output
+func docker(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// testWriter logs to a test
+type testWriter struct {
+	t testing.TB
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
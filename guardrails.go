@@ -0,0 +1,82 @@
+package goliquify
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CommandPolicy restricts the Liquibase commands that may run, e.g. in an
+// environment. Entries may be globs like rollback*, and match both spellings
+// of a command, rollback-to-date and rollbackToDate. It guards against
+// mistakes, not against users: the role is whatever the caller says it is, so
+// anyone able to run goliquify can pick the most permissive one. Restrict who
+// may change a database with its credentials, or with the server's roles.
+type CommandPolicy struct {
+	// Allow lists the only commands allowed, every command when empty
+	Allow []string `yaml:"allow"`
+	// Deny lists commands never allowed, even if Allow matches them
+	Deny []string `yaml:"deny"`
+	// Roles replace the policy for the named roles, e.g. dba
+	Roles map[string]*CommandPolicy `yaml:"roles"`
+}
+
+// PolicyError reports a command the policy does not allow
+type PolicyError struct {
+	Command     string
+	Environment string
+	Role        string
+	Reason      string
+}
+
+func (e *PolicyError) Error() string {
+	where := ""
+	if e.Environment != "" {
+		where = " in environment " + e.Environment
+	}
+	if e.Role != "" {
+		where += " for role " + e.Role
+	}
+	return fmt.Sprintf("%s is not allowed%s: %s", e.Command, where, e.Reason)
+}
+
+// Check returns the reason a command is not allowed for a role, or an empty string
+func (p *CommandPolicy) Check(command, role string) string {
+	if p == nil || command == "" {
+		return ""
+	}
+	if rolePolicy, ok := p.Roles[role]; ok && role != "" {
+		p = rolePolicy
+	}
+	for _, pattern := range p.Deny {
+		if commandMatches(pattern, command) {
+			return fmt.Sprintf("denied by %s", pattern)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return ""
+	}
+	for _, pattern := range p.Allow {
+		if commandMatches(pattern, command) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("only %s are allowed", strings.Join(p.Allow, ", "))
+}
+
+// Match a command against a pattern ignoring case and dashes, so kebab and camel case spellings match
+func commandMatches(pattern, command string) bool {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, "-", ""))
+	}
+	ok, err := path.Match(normalize(pattern), normalize(command))
+	return err == nil && ok
+}
+
+// Refuse commands the policy does not allow, before anything is installed or started
+func (pl *GoLiquibase) checkPolicy(command string) error {
+	if reason := pl.CommandPolicy.Check(command, pl.Role); reason != "" {
+		return &PolicyError{Command: command, Environment: pl.Environment, Role: pl.Role, Reason: reason}
+	}
+	return nil
+}
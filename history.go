@@ -0,0 +1,124 @@
+package goliquify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Query reading the full deployment history, the table is qualified with the Liquibase schema when set
+const HISTORY_SQL = "SELECT ID, AUTHOR, FILENAME, DATEEXECUTED, ORDEREXECUTED, EXECTYPE, MD5SUM, DESCRIPTION, COMMENTS, TAG, LIQUIBASE, CONTEXTS, LABELS, DEPLOYMENT_ID FROM %sDATABASECHANGELOG ORDER BY ORDEREXECUTED"
+
+// Execution type of changesets in the changelog that haven't been applied
+const EXEC_TYPE_PENDING = "PENDING"
+
+// Columns of the CSV export
+var HISTORY_CSV_HEADER = []string{"id", "author", "filename", "dateExecuted", "orderExecuted", "execType", "md5sum", "description", "comments", "tag", "liquibase", "contexts", "labels", "deploymentId"}
+
+// Date formats drivers return DATEEXECUTED in when it is read as text
+var historyDateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05"}
+
+// HistoryEntry is a row of the DATABASECHANGELOG table, or a pending changeset
+type HistoryEntry struct {
+	ID               string     `json:"id"`
+	Author           string     `json:"author"`
+	File             string     `json:"filename"`
+	DateExecuted     *time.Time `json:"dateExecuted,omitempty"`
+	OrderExecuted    int        `json:"orderExecuted,omitempty"`
+	ExecType         string     `json:"execType"`
+	MD5Sum           string     `json:"md5sum,omitempty"`
+	Description      string     `json:"description,omitempty"`
+	Comments         string     `json:"comments,omitempty"`
+	Tag              string     `json:"tag,omitempty"`
+	LiquibaseVersion string     `json:"liquibase,omitempty"`
+	Contexts         string     `json:"contexts,omitempty"`
+	Labels           string     `json:"labels,omitempty"`
+	DeploymentID     string     `json:"deploymentId,omitempty"`
+}
+
+// ReadHistory reads the deployment history of a database in the order the
+// changesets were applied, straight through database/sql
+func ReadHistory(ctx context.Context, db *sql.DB, liquibaseSchema string) ([]HistoryEntry, error) {
+	qualifier := ""
+	if liquibaseSchema != "" {
+		qualifier = liquibaseSchema + "."
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(HISTORY_SQL, qualifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the deployment history: %v", err)
+	}
+	defer rows.Close()
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		var executed string
+		var md5sum, description, comments, tag, liquibase, contexts, labels, deploymentID sql.NullString
+		if err := rows.Scan(&e.ID, &e.Author, &e.File, &executed, &e.OrderExecuted, &e.ExecType,
+			&md5sum, &description, &comments, &tag, &liquibase, &contexts, &labels, &deploymentID); err != nil {
+			return nil, err
+		}
+		for _, layout := range historyDateLayouts {
+			if t, err := time.Parse(layout, executed); err == nil {
+				e.DateExecuted = &t
+				break
+			}
+		}
+		e.MD5Sum, e.Description, e.Comments, e.Tag = md5sum.String, description.String, comments.String, tag.String
+		e.LiquibaseVersion, e.Contexts, e.Labels, e.DeploymentID = liquibase.String, contexts.String, labels.String, deploymentID.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PendingHistory lists the changesets of a changelog tree missing from the
+// history as entries of type PENDING, so exports can show the status too
+func PendingHistory(tree *ChangelogTree, history []HistoryEntry) []HistoryEntry {
+	applied := make([]AppliedChangeSet, len(history))
+	for i, e := range history {
+		applied[i] = AppliedChangeSet{ID: e.ID, Author: e.Author, File: e.File, Tag: e.Tag}
+	}
+	var pending []HistoryEntry
+	for _, cs := range PendingChangeSets(tree, applied) {
+		pending = append(pending, HistoryEntry{ID: cs.ID, Author: cs.Author, File: cs.File, ExecType: EXEC_TYPE_PENDING})
+	}
+	return pending
+}
+
+// WriteHistoryCSV writes history entries as CSV with a header row
+func WriteHistoryCSV(w io.Writer, entries []HistoryEntry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(HISTORY_CSV_HEADER); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		executed, order := "", ""
+		if e.DateExecuted != nil {
+			executed = e.DateExecuted.Format(time.RFC3339)
+		}
+		if e.OrderExecuted > 0 {
+			order = strconv.Itoa(e.OrderExecuted)
+		}
+		record := []string{e.ID, e.Author, e.File, executed, order, e.ExecType, e.MD5Sum, e.Description,
+			e.Comments, e.Tag, e.LiquibaseVersion, e.Contexts, e.Labels, e.DeploymentID}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteHistoryJSON writes history entries as a JSON array
+func WriteHistoryJSON(w io.Writer, entries []HistoryEntry) error {
+	if entries == nil {
+		entries = []HistoryEntry{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
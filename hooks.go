@@ -0,0 +1,63 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Marks hooks written by goliquify, so they can be replaced but foreign hooks are left alone
+const HOOK_MARKER = "# Installed by goliquify hooks install"
+
+const preCommitHook = `#!/bin/sh
+` + HOOK_MARKER + `
+# Lint the staged changelogs
+exec goliquify lint --staged
+`
+
+const prePushHook = `#!/bin/sh
+` + HOOK_MARKER + `
+# Lint the changelogs changed since the upstream branch
+upstream=$(git rev-parse --abbrev-ref --symbolic-full-name '@{upstream}' 2>/dev/null || echo origin/HEAD)
+goliquify lint --since "$upstream" || exit 1
+`
+
+// InstallHooks writes pre-commit and pre-push hooks linting the changed
+// changelogs. With validate the pre-push hook also runs Liquibase validate on
+// the changed changesets, which needs a database connection. Existing hooks not written by goliquify
+// are only replaced with force. Returns the paths written.
+func InstallHooks(validate, force bool) ([]string, error) {
+	hooksDir, err := runGit(context.Background(), "", "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return nil, err
+	}
+
+	prePush := prePushHook
+	if validate {
+		prePush += "goliquify validate --since \"$upstream\" || exit 1\n"
+	}
+	hooks := map[string]string{"pre-commit": preCommitHook, "pre-push": prePush}
+
+	// Check every hook before writing any
+	for _, name := range []string{"pre-commit", "pre-push"} {
+		path := filepath.Join(hooksDir, name)
+		existing, err := os.ReadFile(path)
+		if err == nil && !strings.Contains(string(existing), HOOK_MARKER) && !force {
+			return nil, fmt.Errorf("%s already exists, use --force to replace it", path)
+		}
+	}
+	var written []string
+	for _, name := range []string{"pre-commit", "pre-push"} {
+		path := filepath.Join(hooksDir, name)
+		if err := os.WriteFile(path, []byte(hooks[name]), 0755); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
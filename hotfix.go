@@ -0,0 +1,190 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_HOTFIX_CHANGELOG     = "hotfix.sql"
+	DEFAULT_HOTFIX_BRANCH_PREFIX = "hotfix/"
+	DEFAULT_HOTFIX_REMOTE        = "origin"
+	// Label of every hotfix changeset, next to its own id
+	HOTFIX_LABEL = "hotfix"
+)
+
+// HotfixConfig configures where hotfix changesets are kept and how their
+// pull requests are opened
+type HotfixConfig struct {
+	// Changelog is the formatted SQL changelog hotfixes are appended to,
+	// DEFAULT_HOTFIX_CHANGELOG by default. Include it from the root
+	// changelog so later updates know the hotfixes ran.
+	Changelog string `yaml:"changelog"`
+	// Remote and Base are where the pull request branch is pushed and what
+	// it merges into, the repository's default branch when empty
+	Remote string `yaml:"remote"`
+	Base   string `yaml:"base"`
+	// BranchPrefix of the pull request branches, DEFAULT_HOTFIX_BRANCH_PREFIX by default
+	BranchPrefix string `yaml:"branchPrefix"`
+}
+
+// HotfixOptions describe one hotfix
+type HotfixOptions struct {
+	// SQL to apply and the SQL undoing it, which may be empty
+	SQL      string
+	Rollback string
+	// Message is the changeset comment and the pull request title
+	Message string
+	// Author of the changeset, the git user or the OS user by default
+	Author string
+	// PullRequest opens a pull request with the changelog after applying
+	PullRequest bool
+}
+
+// HotfixResult is a hotfix applied
+type HotfixResult struct {
+	ChangeSet string `json:"changeset"`
+	Changelog string `json:"changelog"`
+	// PullRequest is the url of the pull request, empty when none was opened
+	PullRequest string `json:"pullRequest,omitempty"`
+}
+
+// Hotfix wraps ad-hoc SQL into a changeset appended to the hotfix
+// changelog and applies just that changeset, so incident fixes are tracked
+// like any other change. A failed update takes the changeset back out of
+// the changelog. The pull request is opened with gh from a commit of the
+// changelog alone, the working tree and current branch are left as they are.
+func (pl *GoLiquibase) Hotfix(ctx context.Context, config *HotfixConfig, opts HotfixOptions) (*HotfixResult, error) {
+	if config == nil {
+		config = &HotfixConfig{}
+	}
+	if strings.TrimSpace(opts.SQL) == "" {
+		return nil, fmt.Errorf("the hotfix has no SQL")
+	}
+	changelog := firstNonEmpty(config.Changelog, DEFAULT_HOTFIX_CHANGELOG)
+	if !strings.EqualFold(filepath.Ext(changelog), ".sql") {
+		return nil, fmt.Errorf("the hotfix changelog %s must be a formatted SQL changelog", changelog)
+	}
+	author := firstNonEmpty(opts.Author, hotfixAuthor(ctx))
+	id := time.Now().UTC().Format(DEFAULT_ID_TIMESTAMP_FORMAT) + "-" + HOTFIX_LABEL
+
+	previous, err := os.ReadFile(changelog)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	content := string(previous)
+	if len(previous) == 0 {
+		content = "--liquibase formatted sql\n"
+	}
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += "\n" + hotfixChangeSet(id, author, opts)
+	if err := os.MkdirAll(filepath.Dir(changelog), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(changelog, []byte(content), 0644); err != nil {
+		return nil, err
+	}
+	restore := func() {
+		if previous == nil {
+			os.Remove(changelog)
+		} else {
+			os.WriteFile(changelog, previous, 0644)
+		}
+	}
+
+	result := &HotfixResult{ChangeSet: fmt.Sprintf("%s::%s::%s", filepath.ToSlash(changelog), id, author), Changelog: changelog}
+	pl.logger().Printf("Applying hotfix %s", result.ChangeSet)
+	// The changeset's own label picks it out of earlier hotfixes still pending here
+	if err := pl.ExecuteWithOptions(ctx, ExecOptions{}, "update", "--changelog-file="+filepath.ToSlash(changelog), "--label-filter="+id); err != nil {
+		restore()
+		return nil, fmt.Errorf("hotfix failed, %s was left as it was: %v", changelog, err)
+	}
+	if pl.DryRun {
+		restore()
+		return result, nil
+	}
+
+	if opts.PullRequest {
+		if result.PullRequest, err = pl.openHotfixPullRequest(ctx, config, changelog, id, opts.Message); err != nil {
+			return result, fmt.Errorf("hotfix applied but opening its pull request failed: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// The formatted SQL changeset of a hotfix
+func hotfixChangeSet(id, author string, opts HotfixOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--changeset %s:%s labels:%s,%s\n", author, id, HOTFIX_LABEL, id)
+	if opts.Message != "" {
+		fmt.Fprintf(&b, "--comment: %s\n", strings.ReplaceAll(opts.Message, "\n", " "))
+	}
+	b.WriteString(strings.TrimRight(opts.SQL, "\n") + "\n")
+	if rollback := strings.TrimRight(opts.Rollback, "\n"); rollback != "" {
+		for _, line := range strings.Split(rollback, "\n") {
+			fmt.Fprintf(&b, "--rollback %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// The git user name, or the OS user when git has none
+func hotfixAuthor(ctx context.Context) string {
+	if name, err := runGit(ctx, "", "config", "user.name"); err == nil && name != "" {
+		return strings.ReplaceAll(name, " ", ".")
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return DEFAULT_SNIPPET_AUTHOR
+}
+
+// Commit the hotfix changelog on top of HEAD to a branch of its own, push
+// it and open a pull request. The commit is built in a private index, so
+// nothing else in the working tree goes with it.
+func (pl *GoLiquibase) openHotfixPullRequest(ctx context.Context, config *HotfixConfig, changelog, id, message string) (string, error) {
+	dir, err := os.MkdirTemp("", "goliquify-hotfix-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(dir, "index")}
+	title := firstNonEmpty(message, "Hotfix "+id)
+	if _, err := runGitEnv(ctx, "", env, "read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := runGitEnv(ctx, "", env, "add", "--", changelog); err != nil {
+		return "", err
+	}
+	tree, err := runGitEnv(ctx, "", env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	commit, err := runGit(ctx, "", "commit-tree", tree, "-p", "HEAD", "-m", title)
+	if err != nil {
+		return "", err
+	}
+
+	branch := firstNonEmpty(config.BranchPrefix, DEFAULT_HOTFIX_BRANCH_PREFIX) + id
+	remote := firstNonEmpty(config.Remote, DEFAULT_HOTFIX_REMOTE)
+	pl.logger().Printf("Pushing hotfix branch %s to %s", branch, remote)
+	if _, err := runGit(ctx, "", "push", remote, commit+":refs/heads/"+branch); err != nil {
+		return "", err
+	}
+	args := []string{"pr", "create", "--head", branch, "--title", title, "--body", fmt.Sprintf("Hotfix changeset `%s` appended to `%s`, already applied to %s.", id, filepath.ToSlash(changelog), firstNonEmpty(pl.Environment, "the database"))}
+	if config.Base != "" {
+		args = append(args, "--base", config.Base)
+	}
+	out, err := pl.runTool(ctx, "gh", args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
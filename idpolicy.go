@@ -0,0 +1,308 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ID_SCHEME_TIMESTAMP  = "timestamp"
+	ID_SCHEME_TICKET     = "ticket"
+	ID_SCHEME_SEQUENTIAL = "sequential"
+)
+
+// Go layout of timestamp ids, yyyyMMddHHmmss
+const DEFAULT_ID_TIMESTAMP_FORMAT = "20060102150405"
+
+const LINT_ID_POLICY = "id-policy"
+
+// IDPolicyConfig is the scheme the ids of new changesets follow
+type IDPolicyConfig struct {
+	// Scheme is timestamp, ticket or sequential
+	Scheme string `yaml:"scheme"`
+	// TimestampFormat is the Go layout timestamp ids start with, followed
+	// by an optional -description
+	TimestampFormat string `yaml:"timestampFormat"`
+	// TicketPattern is the regular expression ticket ids start with, e.g.
+	// [A-Z]+-\d+, followed by an optional -suffix
+	TicketPattern string `yaml:"ticketPattern"`
+}
+
+// Validate checks the scheme and its settings
+func (p *IDPolicyConfig) Validate() error {
+	switch p.Scheme {
+	case ID_SCHEME_TIMESTAMP, ID_SCHEME_SEQUENTIAL:
+	case ID_SCHEME_TICKET:
+		if p.TicketPattern == "" {
+			return fmt.Errorf("the ticket id scheme needs a ticketPattern")
+		}
+		if _, err := regexp.Compile(p.TicketPattern); err != nil {
+			return fmt.Errorf("invalid ticketPattern: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown id scheme %q, expecting timestamp, ticket or sequential", p.Scheme)
+	}
+	return nil
+}
+
+func (p *IDPolicyConfig) timestampFormat() string {
+	if p.TimestampFormat != "" {
+		return p.TimestampFormat
+	}
+	return DEFAULT_ID_TIMESTAMP_FORMAT
+}
+
+// Check returns why the id of a changeset breaks the policy, empty when it
+// doesn't. Sequential ids are checked against the changesets before it in
+// its file.
+func (p *IDPolicyConfig) Check(file *ChangelogFile, cs *ChangeSet) string {
+	switch p.Scheme {
+	case ID_SCHEME_TIMESTAMP:
+		layout := p.timestampFormat()
+		if len(cs.ID) < len(layout) {
+			return fmt.Sprintf("id %s doesn't start with a %s timestamp", cs.ID, layout)
+		}
+		if _, err := time.Parse(layout, cs.ID[:len(layout)]); err != nil || (len(cs.ID) > len(layout) && cs.ID[len(layout)] != '-') {
+			return fmt.Sprintf("id %s doesn't start with a %s timestamp", cs.ID, layout)
+		}
+	case ID_SCHEME_TICKET:
+		if !regexp.MustCompile(`^(?:` + p.TicketPattern + `)(?:-.+)?$`).MatchString(cs.ID) {
+			return fmt.Sprintf("id %s doesn't start with a ticket matching %s", cs.ID, p.TicketPattern)
+		}
+	case ID_SCHEME_SEQUENTIAL:
+		if expected := nextSequentialID(file, cs); cs.ID != strconv.Itoa(expected) {
+			return fmt.Sprintf("id %s isn't the next id of its file, expecting %d", cs.ID, expected)
+		}
+	}
+	return ""
+}
+
+// The id following the numeric ids of the changesets before one in its file
+func nextSequentialID(file *ChangelogFile, cs *ChangeSet) int {
+	last := 0
+	for _, other := range file.ChangeSets {
+		if other == cs {
+			break
+		}
+		if n, err := strconv.Atoi(other.ID); err == nil && n > last {
+			last = n
+		}
+	}
+	return last + 1
+}
+
+// CheckIDPolicy checks the ids of the changesets added since a git ref.
+// Modified changesets keep their ids, they may have been deployed.
+func CheckIDPolicy(policy *IDPolicyConfig, changed []ChangedChangeSet) []Diagnostic {
+	var diagnostics []Diagnostic
+	files := map[string]*ChangelogFile{}
+	for _, c := range changed {
+		if !c.Added {
+			continue
+		}
+		file, ok := files[c.DiskPath]
+		if !ok {
+			var err error
+			if file, err = ParseChangelogFile(c.DiskPath, c.ChangeSet.File); err != nil {
+				// Parse errors are reported by the regular lint
+				continue
+			}
+			files[c.DiskPath] = file
+		}
+		cs := findChangeSetByLine(file, c.ChangeSet.Line)
+		if cs == nil {
+			continue
+		}
+		if problem := policy.Check(file, cs); problem != "" {
+			diagnostics = append(diagnostics, Diagnostic{
+				Kind:      LINT_ID_POLICY,
+				Severity:  SEVERITY_ERROR,
+				File:      c.DiskPath,
+				Line:      cs.Line,
+				ChangeSet: cs.Key(),
+				Message:   problem,
+			})
+		}
+	}
+	return diagnostics
+}
+
+func findChangeSetByLine(file *ChangelogFile, line int) *ChangeSet {
+	for _, cs := range file.ChangeSets {
+		if cs.Line == line {
+			return cs
+		}
+	}
+	return nil
+}
+
+// IDFix is a changeset id rewritten to follow the policy
+type IDFix struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// IDFixOptions configure FixChangeSetIDs
+type IDFixOptions struct {
+	// Ticket of ticket ids, by default found in the changeset comment and
+	// labels or the git branch name
+	Ticket string
+	// Now is the time of the first timestamp id, later ones are a second
+	// apart to keep their order
+	Now time.Time
+	// Write rewrites the changelogs, otherwise the fixes are only returned
+	Write bool
+}
+
+// FixChangeSetIDs rewrites the ids of the changesets added since a git ref
+// that break the policy. Only added changesets are touched: they can't have
+// been deployed from the ref, so renaming them doesn't make Liquibase run
+// them twice. A new id already used in its file fails the fix.
+func FixChangeSetIDs(policy *IDPolicyConfig, changed []ChangedChangeSet, opts IDFixOptions) ([]IDFix, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	byFile := map[string][]ChangedChangeSet{}
+	var paths []string
+	for _, c := range changed {
+		if !c.Added {
+			continue
+		}
+		if _, ok := byFile[c.DiskPath]; !ok {
+			paths = append(paths, c.DiskPath)
+		}
+		byFile[c.DiskPath] = append(byFile[c.DiskPath], c)
+	}
+	sort.Strings(paths)
+
+	var fixes []IDFix
+	stamp := opts.Now
+	for _, diskPath := range paths {
+		file, err := ParseChangelogFile(diskPath, byFile[diskPath][0].ChangeSet.File)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(diskPath)
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(string(content), "\n")
+		var fileFixes []IDFix
+		// The file's changesets are walked in order, so a sequential id
+		// follows the ids fixed before it
+		for _, cs := range file.ChangeSets {
+			added := false
+			for _, c := range byFile[diskPath] {
+				added = added || c.ChangeSet.Line == cs.Line
+			}
+			if !added || policy.Check(file, cs) == "" {
+				continue
+			}
+			var id string
+			switch policy.Scheme {
+			case ID_SCHEME_TIMESTAMP:
+				id = stamp.Format(policy.timestampFormat())
+				stamp = stamp.Add(time.Second)
+				if _, err := strconv.Atoi(cs.ID); err != nil && cs.ID != "" {
+					id += "-" + cs.ID
+				}
+			case ID_SCHEME_TICKET:
+				ticket := policy.findTicket(cs, opts.Ticket)
+				if ticket == "" {
+					return nil, fmt.Errorf("%s:%d: no ticket matching %s for changeset %s, pass one", diskPath, cs.Line, policy.TicketPattern, cs.ID)
+				}
+				for n := 1; ; n++ {
+					if id = fmt.Sprintf("%s-%d", ticket, n); !hasChangeSetID(file, id, cs.Author) {
+						break
+					}
+				}
+			case ID_SCHEME_SEQUENTIAL:
+				id = strconv.Itoa(nextSequentialID(file, cs))
+			}
+			if hasChangeSetID(file, id, cs.Author) {
+				return nil, fmt.Errorf("%s:%d: can't rename changeset %s to %s, the id is taken, fix it by hand", diskPath, cs.Line, cs.ID, id)
+			}
+			if err := rewriteChangeSetID(lines, diskPath, cs, id); err != nil {
+				return nil, err
+			}
+			fileFixes = append(fileFixes, IDFix{File: diskPath, Line: cs.Line, Old: cs.ID, New: id})
+			cs.ID = id
+		}
+		if len(fileFixes) == 0 {
+			continue
+		}
+		if opts.Write {
+			info, err := os.Stat(diskPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(diskPath, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+				return nil, err
+			}
+		}
+		fixes = append(fixes, fileFixes...)
+	}
+	return fixes, nil
+}
+
+// Find the ticket of a changeset in its comment, its labels, the given
+// ticket or the git branch name
+func (p *IDPolicyConfig) findTicket(cs *ChangeSet, ticket string) string {
+	pattern := regexp.MustCompile(p.TicketPattern)
+	branch, _ := runGit(context.Background(), "", "rev-parse", "--abbrev-ref", "HEAD")
+	for _, source := range []string{cs.ID, cs.Comment, cs.Labels, ticket, branch} {
+		if m := pattern.FindString(source); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+func hasChangeSetID(file *ChangelogFile, id, author string) bool {
+	for _, cs := range file.ChangeSets {
+		if cs.ID == id && cs.Author == author {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewrite the id in the definition of a changeset
+func rewriteChangeSetID(lines []string, diskPath string, cs *ChangeSet, id string) error {
+	old := regexp.QuoteMeta(cs.ID)
+	var pattern *regexp.Regexp
+	var last int
+	switch strings.ToLower(filepath.Ext(diskPath)) {
+	case ".xml":
+		// The attributes of the opening changeSet tag, which may span lines
+		pattern = regexp.MustCompile(`(\bid\s*=\s*["'])` + old + `(["'])`)
+		last = cs.Line - 1
+		for last < len(lines)-1 && !strings.Contains(lines[last], ">") {
+			last++
+		}
+	case ".yaml", ".yml", ".json":
+		pattern = regexp.MustCompile(`^(\s*(?:-\s*)?["']?id["']?\s*:\s*["']?)` + old + `(["']?\s*,?\s*)$`)
+		last = cs.EndLine - 1
+	case ".sql":
+		pattern = regexp.MustCompile(`^(--\s*changeset\s+(?:"[^"]+"|[^:\s]+):)` + old + `(\s|$)`)
+		last = cs.Line - 1
+	default:
+		return fmt.Errorf("rewriting ids in %s files is not supported", filepath.Ext(diskPath))
+	}
+	for i := cs.Line - 1; i <= last && i < len(lines); i++ {
+		if pattern.MatchString(lines[i]) {
+			lines[i] = pattern.ReplaceAllString(lines[i], "${1}"+strings.ReplaceAll(id, "$", "$$")+"${2}")
+			return nil
+		}
+	}
+	return fmt.Errorf("%s:%d: can't find the id of changeset %s", diskPath, cs.Line, cs.ID)
+}
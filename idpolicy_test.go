@@ -0,0 +1,77 @@
+package goliquify
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// Fix every changeset of a copy of a testdata changelog as if it was added
+func fixTestChangelog(t *testing.T, name string, policy *IDPolicyConfig) []byte {
+	t.Helper()
+	input, err := os.ReadFile(filepath.Join("testdata", "idpolicy", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskPath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(diskPath, input, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := ParseChangelogFile(diskPath, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed []ChangedChangeSet
+	for _, cs := range file.ChangeSets {
+		changed = append(changed, ChangedChangeSet{ChangeSet: cs, DiskPath: diskPath, Added: true})
+	}
+	opts := IDFixOptions{Ticket: "OPS-99", Now: time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC), Write: true}
+	if _, err := FixChangeSetIDs(policy, changed, opts); err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := os.ReadFile(diskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixed
+}
+
+func TestFixChangeSetIDsGolden(t *testing.T) {
+	for name, policy := range map[string]*IDPolicyConfig{
+		"timestamp.xml":  {Scheme: ID_SCHEME_TIMESTAMP},
+		"ticket.yaml":    {Scheme: ID_SCHEME_TICKET, TicketPattern: `[A-Z]+-\d+`},
+		"sequential.sql": {Scheme: ID_SCHEME_SEQUENTIAL},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fixed := fixTestChangelog(t, name, policy)
+			golden := filepath.Join("testdata", "idpolicy", name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, fixed, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(fixed) != string(want) {
+				t.Fatalf("fixed changelog:\n%s\nwant:\n%s", fixed, want)
+			}
+		})
+	}
+}
+
+func TestFixChangeSetIDsLeavesValidChangelogsAlone(t *testing.T) {
+	// CRLF line endings, trailing spaces and no final newline are kept
+	input, err := os.ReadFile(filepath.Join("testdata", "idpolicy", "clean.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed := fixTestChangelog(t, "clean.sql", &IDPolicyConfig{Scheme: ID_SCHEME_SEQUENTIAL}); string(fixed) != string(input) {
+		t.Fatalf("a changelog without violations changed:\n%q\nwant:\n%q", fixed, input)
+	}
+}
@@ -0,0 +1,235 @@
+package goliquify
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Risks the impact analyzer flags
+const (
+	IMPACT_TABLE_REWRITE            = "table-rewrite"
+	IMPACT_BLOCKING_INDEX           = "blocking-index-build"
+	IMPACT_NOT_NULL_WITHOUT_DEFAULT = "not-null-without-default"
+)
+
+// Tables with at least this many rows count as large
+const DEFAULT_LARGE_TABLE_ROWS = 1000000
+
+var (
+	sqlBlockCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlLineCommentPattern  = regexp.MustCompile(`--[^\n]*`)
+	sqlCreateIndexPattern  = regexp.MustCompile(`(?is)^create\s+(?:unique\s+)?index\s+(concurrently\s+)?(?:if\s+not\s+exists\s+)?(?:([\w."` + "`" + `]+)\s+)?on\s+(?:only\s+)?([\w."` + "`" + `]+)\s*(?:using\s+\w+\s*)?\(([^)]*)\)`)
+	sqlAlterTablePattern   = regexp.MustCompile(`(?is)^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w."` + "`" + `]+)\s+(.*)$`)
+	sqlDropTablePattern    = regexp.MustCompile(`(?is)^drop\s+table\s+(?:if\s+exists\s+)?([\w."` + "`" + `]+)`)
+	sqlAlterTypePattern    = regexp.MustCompile(`(?is)^alter\s+(?:column\s+)?(\S+)\s+(?:set\s+data\s+)?type\s+(.+?)(?:\s+using\s+.*)?$`)
+	sqlSetNotNullPattern   = regexp.MustCompile(`(?is)^alter\s+(?:column\s+)?(\S+)\s+set\s+not\s+null`)
+	sqlModifyPattern       = regexp.MustCompile(`(?is)^modify\s+(?:column\s+)?(\S+)\s+(\w+(?:\s*\([^)]*\))?)`)
+	sqlAddForeignKey       = regexp.MustCompile(`(?is)^add\s+(?:constraint\s+(\S+)\s+)?foreign\s+key\s*\(([^)]*)\)\s*references\s+([\w."` + "`" + `]+)`)
+	sqlAddConstraint       = regexp.MustCompile(`(?is)^add\s+(constraint|primary|foreign|unique|index|key|check)\b`)
+	sqlAddColumnPattern    = regexp.MustCompile(`(?is)^add\s+(?:column\s+)?(?:if\s+not\s+exists\s+)?(\S+)\s+(.+)$`)
+	sqlDropColumnPattern   = regexp.MustCompile(`(?is)^drop\s+(?:column\s+)?(?:if\s+exists\s+)?(\S+)`)
+	sqlNotNullPattern      = regexp.MustCompile(`(?i)\bnot\s+null\b`)
+	sqlDefaultPattern      = regexp.MustCompile(`(?i)\bdefault\b`)
+)
+
+// ImpactFinding is a risky operation of a pending changeset
+type ImpactFinding struct {
+	ChangeSet string `json:"changeSet"`
+	Risk      string `json:"risk"`
+	Severity  string `json:"severity"`
+	Table     string `json:"table"`
+	// Rows is the estimated row count of the table
+	Rows    int64  `json:"rows"`
+	Message string `json:"message"`
+}
+
+// String describes the finding in one line
+func (f ImpactFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s: %s", f.Severity, f.Risk, f.ChangeSet, f.Message)
+}
+
+// AnalyzeImpact flags the operations of changesets that are risky given the
+// size of their tables: full table rewrites and index builds blocking writes
+// on large tables, and NOT NULL columns without a default on tables with
+// rows. Row counts are keyed by lowercase table name, tables without one
+// are taken to be created by the changesets and skipped.
+func AnalyzeImpact(changesets []*ChangeSet, rowCounts map[string]int64, largeTableRows int64) ([]ImpactFinding, error) {
+	if largeTableRows <= 0 {
+		largeTableRows = DEFAULT_LARGE_TABLE_ROWS
+	}
+	var findings []ImpactFinding
+	for _, cs := range changesets {
+		changes, err := ChangeSetChanges(cs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", changesetLabel(cs.Key()), err)
+		}
+		for _, change := range changes {
+			rows, known := rowCounts[strings.ToLower(change.Table)]
+			if !known || rows < 0 {
+				continue
+			}
+			add := func(risk, severity, format string, args ...any) {
+				findings = append(findings, ImpactFinding{
+					ChangeSet: cs.Key(),
+					Risk:      risk,
+					Severity:  severity,
+					Table:     change.Table,
+					Rows:      rows,
+					Message:   fmt.Sprintf(format, args...),
+				})
+			}
+			switch change.Type {
+			case "modifyDataType":
+				if rows >= largeTableRows {
+					add(IMPACT_TABLE_REWRITE, SEVERITY_WARNING, "changing the type of %s rewrites the table and its ~%d rows under an exclusive lock", change.Object(), rows)
+				}
+			case "createIndex", "addPrimaryKey", "addUniqueConstraint":
+				if rows >= largeTableRows && !change.Concurrently {
+					add(IMPACT_BLOCKING_INDEX, SEVERITY_WARNING, "%s builds an index on %s, blocking writes to its ~%d rows, build it concurrently", change.Type, change.Object(), rows)
+				}
+			}
+			if len(change.NotNullColumns) == 0 || rows == 0 {
+				continue
+			}
+			columns := strings.Join(change.NotNullColumns, ", ")
+			if change.Type == "addNotNullConstraint" {
+				add(IMPACT_NOT_NULL_WITHOUT_DEFAULT, SEVERITY_WARNING, "making %s.%s NOT NULL scans ~%d rows under an exclusive lock and fails if any is NULL, give a defaultNullValue", change.Table, columns, rows)
+			} else {
+				add(IMPACT_NOT_NULL_WITHOUT_DEFAULT, SEVERITY_ERROR, "adding NOT NULL column(s) %s without a default fails on %s, which has ~%d rows", columns, change.Table, rows)
+			}
+		}
+	}
+	return findings, nil
+}
+
+// ChangeSetChanges reads the changes a changeset makes from its definition.
+// Statements of sql changes and formatted SQL changesets are recognized as
+// far as they create indexes, alter tables or drop them.
+func ChangeSetChanges(cs *ChangeSet) ([]SchemaChange, error) {
+	switch strings.ToLower(filepath.Ext(cs.File)) {
+	case ".xml":
+		return parseXMLChanges([]byte(cs.Body))
+	case ".yaml", ".yml":
+		return parseYAMLChanges([]byte("databaseChangeLog:\n" + cs.Body))
+	case ".json":
+		body := strings.TrimSuffix(strings.TrimSpace(cs.Body), ",")
+		return parseYAMLChanges([]byte(`{"databaseChangeLog": [` + body + `]}`))
+	case ".sql":
+		return SQLChanges(cs.Body), nil
+	}
+	return nil, nil
+}
+
+// SQLChanges reads the structural changes of SQL statements. Statements
+// it doesn't recognize are left out.
+func SQLChanges(statements string) []SchemaChange {
+	statements = sqlBlockCommentPattern.ReplaceAllString(statements, "")
+	statements = sqlLineCommentPattern.ReplaceAllString(statements, "")
+	var changes []SchemaChange
+	for _, statement := range strings.Split(statements, ";") {
+		statement = strings.TrimSpace(statement)
+		if m := sqlCreateIndexPattern.FindStringSubmatch(statement); m != nil {
+			changes = append(changes, SchemaChange{
+				Type:         "createIndex",
+				Name:         unquoteIdentifier(m[2]),
+				Table:        unquoteIdentifier(m[3]),
+				Columns:      splitSQLColumns(m[4]),
+				Concurrently: m[1] != "",
+			})
+			continue
+		}
+		if m := sqlDropTablePattern.FindStringSubmatch(statement); m != nil {
+			changes = append(changes, SchemaChange{Type: "dropTable", Table: unquoteIdentifier(m[1])})
+			continue
+		}
+		m := sqlAlterTablePattern.FindStringSubmatch(statement)
+		if m == nil {
+			continue
+		}
+		table := unquoteIdentifier(m[1])
+		for _, action := range splitTopLevel(m[2]) {
+			if change, ok := alterTableChange(table, action); ok {
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes
+}
+
+// Read one action of an ALTER TABLE statement
+func alterTableChange(table, action string) (SchemaChange, bool) {
+	change := SchemaChange{Table: table}
+	if m := sqlSetNotNullPattern.FindStringSubmatch(action); m != nil {
+		change.Type, change.Columns = "addNotNullConstraint", []string{unquoteIdentifier(m[1])}
+		change.NotNullColumns = change.Columns
+		return change, true
+	}
+	if m := sqlAlterTypePattern.FindStringSubmatch(action); m != nil {
+		change.Type, change.Columns, change.NewType = "modifyDataType", []string{unquoteIdentifier(m[1])}, m[2]
+		return change, true
+	}
+	if m := sqlModifyPattern.FindStringSubmatch(action); m != nil {
+		change.Type, change.Columns, change.NewType = "modifyDataType", []string{unquoteIdentifier(m[1])}, m[2]
+		return change, true
+	}
+	if m := sqlAddForeignKey.FindStringSubmatch(action); m != nil {
+		change.Type, change.Name, change.Columns, change.RefTable = "addForeignKeyConstraint", unquoteIdentifier(m[1]), splitSQLColumns(m[2]), unquoteIdentifier(m[3])
+		return change, true
+	}
+	if sqlAddConstraint.MatchString(action) {
+		return change, false
+	}
+	if m := sqlAddColumnPattern.FindStringSubmatch(action); m != nil {
+		change.Type, change.Columns = "addColumn", []string{unquoteIdentifier(m[1])}
+		if sqlNotNullPattern.MatchString(m[2]) && !sqlDefaultPattern.MatchString(m[2]) {
+			change.NotNullColumns = change.Columns
+		}
+		return change, true
+	}
+	if strings.HasPrefix(strings.ToLower(action), "drop constraint") {
+		return change, false
+	}
+	if m := sqlDropColumnPattern.FindStringSubmatch(action); m != nil {
+		change.Type, change.Columns = "dropColumn", []string{unquoteIdentifier(m[1])}
+		return change, true
+	}
+	return change, false
+}
+
+// Split on the commas outside parentheses
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// Read the column names of an index or key column list, leaving out sort orders
+func splitSQLColumns(columns string) []string {
+	var names []string
+	for _, column := range strings.Split(columns, ",") {
+		if fields := strings.Fields(column); len(fields) > 0 {
+			names = append(names, unquoteIdentifier(fields[0]))
+		}
+	}
+	return names
+}
+
+// Drop quotes and qualifiers from an identifier like "public"."orders"
+func unquoteIdentifier(name string) string {
+	return strings.Trim(unqualified(name), "\"`")
+}
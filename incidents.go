@@ -0,0 +1,251 @@
+package goliquify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	INCIDENT_PAGERDUTY = "pagerduty"
+	INCIDENT_OPSGENIE  = "opsgenie"
+
+	PAGERDUTY_EVENTS_URL = "https://events.pagerduty.com/v2/enqueue"
+	OPSGENIE_ALERTS_URL  = "https://api.opsgenie.com/v2/alerts"
+	// Accounts hosted in the EU region of Opsgenie
+	OPSGENIE_EU_ALERTS_URL = "https://api.eu.opsgenie.com/v2/alerts"
+)
+
+const SEVERITY_INFO = "info"
+
+// Incident severities, as PagerDuty names them
+var INCIDENT_SEVERITIES = []string{SEVERITY_CRITICAL, SEVERITY_ERROR, SEVERITY_WARNING, SEVERITY_INFO}
+
+// Opsgenie priorities of the severities
+var opsgeniePriorities = map[string]string{
+	SEVERITY_CRITICAL: "P1",
+	SEVERITY_ERROR:    "P2",
+	SEVERITY_WARNING:  "P3",
+	SEVERITY_INFO:     "P5",
+}
+
+// Commands whose failure opens an incident, unless configured otherwise.
+// drift is a drift check finding differences.
+var DEFAULT_INCIDENT_COMMANDS = []string{
+	"update", "update-count", "update-to-tag", "rollback", "rollback-count",
+	"rollback-to-date", "rollbackToDate", "drop-all", "changelog-sync", "drift",
+}
+
+// IncidentConfig opens alerts in PagerDuty or Opsgenie when a run fails
+type IncidentConfig struct {
+	// Provider is pagerduty or opsgenie
+	Provider string `yaml:"provider"`
+	// KeyEnv is the environment variable holding the PagerDuty routing key
+	// or the Opsgenie API key
+	KeyEnv string `yaml:"keyEnv"`
+	// Severity applies to environments without an incidentSeverity. No
+	// incident is opened for environments without either.
+	Severity string `yaml:"severity"`
+	// Commands whose failure opens an incident, DEFAULT_INCIDENT_COMMANDS
+	// when empty
+	Commands []string `yaml:"commands"`
+	// LogURL links the incident to the run log, {runId}, {env} and
+	// {command} are replaced. The CI pipeline is linked too, if any.
+	LogURL string `yaml:"logUrl"`
+	// Region is eu for Opsgenie accounts hosted in the EU
+	Region string `yaml:"region"`
+	// URL replaces the API endpoint of the provider, e.g. for a proxy
+	URL string `yaml:"url"`
+}
+
+// Validate checks an incident config
+func (c *IncidentConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Provider != INCIDENT_PAGERDUTY && c.Provider != INCIDENT_OPSGENIE {
+		return fmt.Errorf("unknown incident provider %q, expecting pagerduty or opsgenie", c.Provider)
+	}
+	if c.KeyEnv == "" {
+		return fmt.Errorf("incidents need keyEnv, the environment variable holding the %s key", c.Provider)
+	}
+	if c.Severity != "" && !containsString(INCIDENT_SEVERITIES, c.Severity) {
+		return fmt.Errorf("unknown incident severity %q, expecting one of %s", c.Severity, strings.Join(INCIDENT_SEVERITIES, ", "))
+	}
+	return nil
+}
+
+// IncidentSink opens an alert for every failed event of the configured
+// commands. Alerts of the same environment and command are deduplicated by
+// the provider until resolved.
+type IncidentSink struct {
+	Config      *IncidentConfig
+	Environment string
+	Severity    string
+	// Key of the provider, read from the KeyEnv of the config when empty
+	Key    string
+	Client *http.Client
+}
+
+// NewIncidentSink creates the incident sink of an environment, nil when
+// the environment has no severity
+func NewIncidentSink(cfg *IncidentConfig, environment, severity string) (*IncidentSink, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if severity == "" {
+		severity = cfg.Severity
+	}
+	if severity == "" || severity == "none" {
+		return nil, nil
+	}
+	if !containsString(INCIDENT_SEVERITIES, severity) {
+		return nil, fmt.Errorf("unknown incident severity %q for environment %s, expecting one of %s", severity, environment, strings.Join(INCIDENT_SEVERITIES, ", "))
+	}
+	return &IncidentSink{Config: cfg, Environment: environment, Severity: severity}, nil
+}
+
+// Send opens an alert for a failed event
+func (s *IncidentSink) Send(event Event) error {
+	commands := s.Config.Commands
+	if len(commands) == 0 {
+		commands = DEFAULT_INCIDENT_COMMANDS
+	}
+	if event.Type != EVENT_FAILED || !containsString(commands, event.Command) {
+		return nil
+	}
+	// The key is only needed when a run fails, not to run commands
+	key := firstNonEmpty(s.Key, os.Getenv(s.Config.KeyEnv))
+	if key == "" {
+		return fmt.Errorf("%s is not set, can't open a %s incident", s.Config.KeyEnv, s.Config.Provider)
+	}
+	environment := firstNonEmpty(event.Environment, s.Environment)
+	summary := fmt.Sprintf("goliquify %s failed on %s: %s", event.Command, firstNonEmpty(environment, "the database"), event.Error)
+	if event.Command == "drift" {
+		summary = fmt.Sprintf("goliquify detected drift on %s: %s", firstNonEmpty(environment, "the database"), event.Error)
+	}
+	// PagerDuty caps the summary
+	summary = truncate(summary, 1024)
+
+	details := map[string]string{
+		"environment": environment,
+		"command":     event.Command,
+		"runId":       event.RunID,
+		"error":       event.Error,
+		"changeset":   event.Changeset,
+		"lastLine":    event.LastLine,
+		"logFile":     event.LogFile,
+		"schedule":    event.Schedule,
+		"time":        event.Time.UTC().Format(time.RFC3339),
+	}
+	if event.CI != nil {
+		details["ciProvider"], details["gitSha"], details["actor"] = event.CI.Provider, event.CI.GitSHA, event.CI.Actor
+	}
+	for k, v := range details {
+		if v == "" {
+			delete(details, k)
+		}
+	}
+	links := map[string]string{}
+	if s.Config.LogURL != "" {
+		links["Run log"] = strings.NewReplacer(
+			"{runId}", url.PathEscape(event.RunID),
+			"{env}", url.PathEscape(environment),
+			"{command}", url.PathEscape(event.Command),
+		).Replace(s.Config.LogURL)
+	}
+	if event.CI != nil && event.CI.PipelineURL != "" {
+		links["CI pipeline"] = event.CI.PipelineURL
+	}
+	dedupKey := "goliquify-" + firstNonEmpty(environment, "default") + "-" + event.Command
+
+	switch s.Config.Provider {
+	case INCIDENT_PAGERDUTY:
+		var pdLinks []map[string]string
+		for _, text := range sortedKeys(links) {
+			pdLinks = append(pdLinks, map[string]string{"href": links[text], "text": text})
+		}
+		return s.post(firstNonEmpty(s.Config.URL, PAGERDUTY_EVENTS_URL), nil, map[string]any{
+			"routing_key":  key,
+			"event_action": "trigger",
+			"dedup_key":    dedupKey,
+			"payload": map[string]any{
+				"summary":        summary,
+				"source":         "goliquify",
+				"severity":       s.Severity,
+				"component":      environment,
+				"group":          "database-migrations",
+				"class":          event.Command,
+				"custom_details": details,
+			},
+			"links": pdLinks,
+		})
+	default:
+		for _, text := range sortedKeys(links) {
+			details[text] = links[text]
+		}
+		var description strings.Builder
+		fmt.Fprintf(&description, "%s\n", event.Error)
+		for _, text := range sortedKeys(links) {
+			fmt.Fprintf(&description, "\n%s: %s", text, links[text])
+		}
+		endpoint := OPSGENIE_ALERTS_URL
+		if s.Config.Region == "eu" {
+			endpoint = OPSGENIE_EU_ALERTS_URL
+		}
+		return s.post(firstNonEmpty(s.Config.URL, endpoint), map[string]string{"Authorization": "GenieKey " + key}, map[string]any{
+			"message":     truncate(summary, 130),
+			"alias":       dedupKey,
+			"description": description.String(),
+			"priority":    opsgeniePriorities[s.Severity],
+			"source":      "goliquify",
+			"entity":      environment,
+			"tags":        []string{"goliquify", event.Command},
+			"details":     details,
+		})
+	}
+}
+
+func (s *IncidentSink) post(endpoint string, headers map[string]string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.Config.Provider, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", s.Config.Provider, response.Status)
+	}
+	return nil
+}
+
+// Cut a string to n bytes, marking the cut
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
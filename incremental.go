@@ -0,0 +1,160 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChangedChangeSet is a changeset added or modified since a git ref
+type ChangedChangeSet struct {
+	ChangeSet *ChangeSet
+	// DiskPath is the file defining the changeset
+	DiskPath string
+	// Added is set for changesets that did not exist at the ref
+	Added bool
+}
+
+// ChangedChangeSets compares the changelogs changed since a git ref with their
+// version at the ref and returns the changesets added or modified, including
+// uncommitted changes. Files that can't be parsed are returned in broken, so
+// they can be reported rather than silently skipped.
+func ChangedChangeSets(since string) (changed []ChangedChangeSet, broken []string, err error) {
+	ctx := context.Background()
+	root, err := runGit(ctx, "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := runGit(ctx, root, "merge-base", since, "HEAD")
+	if err != nil {
+		return nil, nil, err
+	}
+	// Against the working tree, so uncommitted changes count too
+	out, err := runGit(ctx, root, "diff", "--name-only", "--diff-filter=ACMR", base)
+	if err != nil {
+		return nil, nil, err
+	}
+	untracked, err := runGit(ctx, root, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range strings.Split(out+"\n"+untracked, "\n") {
+		if name == "" {
+			continue
+		}
+		diskPath := filepath.Join(root, filepath.FromSlash(name))
+		if !IsChangelog(diskPath) {
+			continue
+		}
+		if rel, err := filepath.Rel(cwd, diskPath); err == nil && !strings.HasPrefix(rel, "..") {
+			diskPath = rel
+		}
+		current, err := ParseChangelogFile(diskPath, filepath.ToSlash(diskPath))
+		if err != nil {
+			broken = append(broken, diskPath)
+			continue
+		}
+
+		// Bodies at the ref by id::author, none for files added since
+		previous := map[string]string{}
+		if content, err := runGit(ctx, root, "show", fmt.Sprintf("%s:%s", base, name)); err == nil {
+			if old, err := parseChangelogContent(diskPath, current.Path, []byte(content)); err == nil {
+				for _, cs := range old.ChangeSets {
+					previous[cs.ID+"::"+cs.Author] = strings.TrimSpace(cs.Body)
+				}
+			}
+		}
+		for _, cs := range current.ChangeSets {
+			body, existed := previous[cs.ID+"::"+cs.Author]
+			if existed && body == strings.TrimSpace(cs.Body) {
+				continue
+			}
+			changed = append(changed, ChangedChangeSet{ChangeSet: cs, DiskPath: diskPath, Added: !existed})
+		}
+	}
+	return changed, broken, nil
+}
+
+// FilterDiagnostics keeps the diagnostics about the given changesets, and
+// the ones about their files that don't concern a particular changeset
+func FilterDiagnostics(diagnostics []Diagnostic, changed []ChangedChangeSet) []Diagnostic {
+	var filtered []Diagnostic
+	for _, d := range diagnostics {
+		for _, c := range changed {
+			if d.File != "" && !sameChangelogPath(d.File, c.DiskPath) && !sameChangelogPath(d.File, c.ChangeSet.File) {
+				continue
+			}
+			if d.ChangeSet == "" {
+				filtered = append(filtered, d)
+				break
+			}
+			file, id, author, ok := splitChangesetKey(d.ChangeSet)
+			if ok && id == c.ChangeSet.ID && author == c.ChangeSet.Author &&
+				(sameChangelogPath(file, c.ChangeSet.File) || sameChangelogPath(file, c.DiskPath)) {
+				filtered = append(filtered, d)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// ValidateSince lints and validates only the changesets added or modified
+// since a git ref, so checks stay fast in large changelogs. Problems in
+// changesets that did not change are not reported. Liquibase validate is
+// skipped when offline is set or nothing changed.
+func (pl *GoLiquibase) ValidateSince(since string, offline bool, arguments ...string) ([]Diagnostic, error) {
+	changed, broken, err := ChangedChangeSets(since)
+	if err != nil {
+		return nil, err
+	}
+
+	var diagnostics []Diagnostic
+	for _, path := range broken {
+		if _, err := ParseChangelogFile(path, filepath.ToSlash(path)); err != nil {
+			diagnostics = append(diagnostics, parseDiagnostic(path, err))
+		}
+	}
+	if len(changed) == 0 {
+		return diagnostics, nil
+	}
+	pl.logger().Printf("Validating %d changeset(s) changed since %s", len(changed), since)
+
+	var files []string
+	for _, c := range changed {
+		if !containsString(files, c.DiskPath) {
+			files = append(files, c.DiskPath)
+		}
+	}
+	linted, err := LintFiles(files, "")
+	if err != nil {
+		return nil, err
+	}
+	diagnostics = append(diagnostics, FilterDiagnostics(linted, changed)...)
+
+	if !offline {
+		validated, err := pl.ValidateDiagnostics(arguments...)
+		if err != nil {
+			return nil, err
+		}
+		diagnostics = mergeDiagnostics(diagnostics, FilterDiagnostics(validated, changed))
+	}
+	return diagnostics, nil
+}
+
+// Check if a list contains a string
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
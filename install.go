@@ -0,0 +1,176 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Guards installs and the install state of every instance, so concurrent
+// commands neither extract the same version twice nor see a half set up instance
+var installMu sync.Mutex
+
+// Return the default directory Liquibase installs are cached in
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "goliquify")
+	}
+	return filepath.Join(os.TempDir(), "goliquify")
+}
+
+// Return the directory Liquibase installs are cached in
+func (pl *GoLiquibase) cacheDir() string {
+	if pl.CacheDir != "" {
+		return pl.CacheDir
+	}
+	return defaultCacheDir()
+}
+
+// Check if a directory holds an extracted Liquibase distribution
+func isLiquibaseInstalled(dir string) bool {
+	if dir == "" || !dirExists(dir) {
+		return false
+	}
+	launcher := "liquibase"
+	if runtime.GOOS == "windows" {
+		launcher = "liquibase.bat"
+	}
+	return fileExists(filepath.Join(dir, launcher))
+}
+
+// Point the instance at a Liquibase directory
+func (pl *GoLiquibase) setLiquibaseDir(dir string) {
+	pl.LiquibaseDir = dir
+	pl.LiquibaseLibDir = filepath.Join(dir, "lib")
+	pl.LiquibaseInternalDir = filepath.Join(dir, "internal")
+	pl.LiquibaseInternalLibDir = filepath.Join(dir, "internal", "lib")
+}
+
+// EnsureInstalled downloads and verifies the Liquibase toolchain, and the
+// managed JRE when JavaVersion is set, without building any arguments, so
+// installs can be pre-warmed at image build time. A user provided
+// LiquibaseDir is used as-is.
+func (pl *GoLiquibase) EnsureInstalled(ctx context.Context) error {
+	installMu.Lock()
+	defer installMu.Unlock()
+
+	cacheDir := pl.cacheDir()
+	if pl.JavaVersion > 0 {
+		home, err := pl.installJRE(ctx, cacheDir)
+		if err != nil {
+			return err
+		}
+		pl.javaHome = home
+		touchCacheEntry(cacheDir, home)
+	}
+
+	if pl.LiquibaseDir != "" && !pl.managedInstall {
+		if !isLiquibaseInstalled(pl.LiquibaseDir) {
+			return fmt.Errorf("no Liquibase installation found in %s", pl.LiquibaseDir)
+		}
+		pl.userInstall = true
+		pl.userInstallVersion = detectLiquibaseVersion(pl.LiquibaseDir)
+		if len(pl.Drivers) > 0 && pl.JdbcDriversDir != "" {
+			_, err := pl.installDrivers(ctx, pl.JdbcDriversDir, pl.Drivers)
+			return err
+		}
+		return nil
+	}
+
+	if pl.LiquibaseArtifact != "" {
+		if err := pl.setArtifactDir(cacheDir); err != nil {
+			return err
+		}
+		pl.managedInstall = true
+		if err := pl.installArtifact(ctx); err != nil {
+			return err
+		}
+	} else {
+		if IsVersionChannel(pl.Version) {
+			version, err := ResolveVersion(ctx, cacheDir, pl.Version)
+			if err != nil {
+				return err
+			}
+			pl.logger().Printf("Resolved Liquibase %s to %s", pl.Version, version)
+			pl.Version = version
+		}
+		pl.setLiquibaseDir(filepath.Join(cacheDir, versioned(LIQUIBASE_DIR, pl.Version)))
+		pl.managedInstall = true
+
+		// Download and extract liquibase if it doesn't exist
+		if err := pl.downloadLiquibase(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Download additional java libraries
+	if err := pl.downloadLiquibaseExtensionLibs(ctx); err != nil {
+		return err
+	}
+	if len(pl.Drivers) > 0 {
+		dir := pl.JdbcDriversDir
+		if dir == "" {
+			dir = pl.LiquibaseLibDir
+		}
+		if _, err := pl.installDrivers(ctx, dir, pl.Drivers); err != nil {
+			return err
+		}
+	}
+	touchCacheEntry(cacheDir, pl.LiquibaseDir)
+	pl.autoPruneCache(cacheDir)
+	return nil
+}
+
+// Return the Liquibase directory, installing Liquibase first if needed
+func (pl *GoLiquibase) installedDir() (string, error) {
+	installMu.Lock()
+	dir, installed := pl.LiquibaseDir, pl.managedInstall || pl.userInstall
+	installMu.Unlock()
+	if installed {
+		return dir, nil
+	}
+	if err := pl.EnsureInstalled(context.Background()); err != nil {
+		return "", err
+	}
+	installMu.Lock()
+	defer installMu.Unlock()
+	return pl.LiquibaseDir, nil
+}
+
+// InstalledVersion returns the version of the Liquibase commands run on:
+// Version for installs goliquify manages, the version read from the jars of
+// a user provided install, empty when it can't be told
+func (pl *GoLiquibase) InstalledVersion() string {
+	installMu.Lock()
+	defer installMu.Unlock()
+	if pl.userInstall {
+		return pl.userInstallVersion
+	}
+	return pl.Version
+}
+
+// Read the Liquibase version of an install from its manifest, or from the
+// Maven coordinates in its core jar
+func detectLiquibaseVersion(dir string) string {
+	if data, err := os.ReadFile(filepath.Join(dir, INSTALL_MANIFEST_FILE)); err == nil {
+		var manifest installManifest
+		if json.Unmarshal(data, &manifest) == nil && manifest.Version != "" {
+			return manifest.Version
+		}
+	}
+	var jars []string
+	for _, pattern := range []string{"liquibase.jar", "liquibase-core*.jar", "internal/lib/liquibase-core*.jar", "lib/liquibase-core*.jar"} {
+		found, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		jars = append(jars, found...)
+	}
+	for _, jar := range jars {
+		if _, artifact, version := jarMavenCoordinates(jar, "liquibase-core"); artifact == "liquibase-core" && version != "" {
+			return version
+		}
+	}
+	return ""
+}
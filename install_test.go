@@ -0,0 +1,222 @@
+package goliquify
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Create a user provided install whose core jar is of the version
+func userInstall(t *testing.T, version string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "liquibase"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "internal", "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := os.Create(filepath.Join(dir, "internal", "lib", "liquibase-core.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jar.Close()
+	archive := zip.NewWriter(jar)
+	pom, err := archive.Create("META-INF/maven/org.liquibase/liquibase-core/pom.properties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pom.Write([]byte("groupId=org.liquibase\nartifactId=liquibase-core\nversion=" + version + "\n"))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestUserInstallKeepsVersion(t *testing.T) {
+	pl := New(WithLiquibaseDir(userInstall(t, "4.31.0")), WithAnalyticsDisabled(true))
+	if err := pl.EnsureInstalled(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pl.Version != DEFAULT_LIQUIBASE_VERSION {
+		t.Fatalf("Version changed to %s", pl.Version)
+	}
+	if got := pl.InstalledVersion(); got != "4.31.0" {
+		t.Fatalf("installed version is %q, want 4.31.0", got)
+	}
+	// Gated on 4.30.0, so only passed when the version of the install is known
+	if args := pl.BuildArgs(); !slices.Contains(args, "--analytics-enabled=false") {
+		t.Fatalf("args %v lack --analytics-enabled=false", args)
+	}
+}
+
+func TestUserInstallOfUnknownVersion(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "liquibase"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pl := New(WithLiquibaseDir(dir), WithAnalyticsDisabled(true))
+	if err := pl.EnsureInstalled(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := pl.InstalledVersion(); got != "" {
+		t.Fatalf("installed version is %q, want unknown", got)
+	}
+	if args := pl.BuildArgs(); slices.Contains(args, "--analytics-enabled=false") {
+		t.Fatalf("args %v pass --analytics-enabled to an install of unknown version", args)
+	}
+}
+
+func TestUnzipKeepsEntriesInTheDestination(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"../evil", "lib/../../evil", "/../evil"} {
+		path := filepath.Join(dir, "liquibase.zip")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := zip.NewWriter(file)
+		for _, entry := range []string{"liquibase", name} {
+			w, err := archive.Create(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("#!/bin/sh\n"))
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		destination := filepath.Join(dir, "install")
+		if err := unzipFile(path, destination); err == nil {
+			t.Errorf("extracted %s", name)
+		}
+		if fileExists(filepath.Join(dir, "evil")) {
+			t.Fatalf("%s was written outside the destination", name)
+		}
+		os.RemoveAll(destination)
+	}
+}
+
+type tarEntry struct {
+	name, link, content string
+}
+
+func writeTarGz(t *testing.T, path string, entries ...tarEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	archive := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		if e.link != "" {
+			header = &tar.Header{Name: e.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: e.link}
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		archive.Write([]byte(e.content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUntarKeepsLinksInTheDestination(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, entries := range map[string][]tarEntry{
+		"absolute link":       {{name: "lib", link: outside}, {name: "lib/authorized_keys", content: "key"}},
+		"escaping link":       {{name: "lib", link: "../outside"}, {name: "lib/authorized_keys", content: "key"}},
+		"nested escape":       {{name: "a/b/lib", link: "../../../outside"}},
+		"write through links": {{name: "real/keep", content: "x"}, {name: "lib", link: "real"}, {name: "lib/authorized_keys", content: "key"}},
+		"replace a link":      {{name: "real", content: "x"}, {name: "lib", link: "real"}, {name: "lib", content: "key"}},
+	} {
+		archive := filepath.Join(dir, "jre.tar.gz")
+		writeTarGz(t, archive, entries...)
+		destination := filepath.Join(dir, "install")
+		if err := untarGzFile(archive, destination); err == nil {
+			t.Errorf("%s: extracted", name)
+		}
+		if files, _ := os.ReadDir(outside); len(files) != 0 {
+			t.Fatalf("%s: wrote %v outside the destination", name, files)
+		}
+		os.RemoveAll(destination)
+	}
+
+	// The links JRE builds ship, to files of the install, are kept
+	archive := filepath.Join(dir, "jre.tar.gz")
+	writeTarGz(t, archive, tarEntry{name: "legal/java.base/LICENSE", content: "GPL"}, tarEntry{name: "legal/java.sql/LICENSE", link: "../java.base/LICENSE"})
+	destination := filepath.Join(dir, "install")
+	if err := untarGzFile(archive, destination); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destination, "legal", "java.sql", "LICENSE")); err != nil || string(data) != "GPL" {
+		t.Fatalf("link not extracted: %q %v", data, err)
+	}
+}
+
+func TestInstallLockIsHeldWhileItsHolderLives(t *testing.T) {
+	defer func(refresh time.Duration) { installLockRefresh = refresh }(installLockRefresh)
+	installLockRefresh = 10 * time.Millisecond
+	dir := filepath.Join(t.TempDir(), "liquibase-4.21.1")
+
+	unlock, err := lockInstall(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A download outlasting the timeout, the holder refreshes the lock
+	old := time.Now().Add(-2 * INSTALL_LOCK_TIMEOUT)
+	if err := os.Chtimes(dir+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	locked := make(chan func())
+	go func() {
+		if unlock, err := lockInstall(dir); err == nil {
+			locked <- unlock
+		}
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the lock of a live holder was broken")
+	case <-time.After(500 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released lock wasn't taken")
+	}
+
+	// A crashed holder doesn't refresh its lock
+	if err := os.WriteFile(dir+".lock", []byte("1@elsewhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dir+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = lockInstall(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
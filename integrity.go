@@ -0,0 +1,172 @@
+package goliquify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Manifest of the files extracted into an install, written in the install dir
+	INSTALL_MANIFEST_FILE = ".goliquify-manifest.json"
+	// How long an install waits for another process installing the same
+	// version. The holder refreshes the lock while it installs, so locks not
+	// refreshed for this long are left over from crashed processes.
+	INSTALL_LOCK_TIMEOUT = 10 * time.Minute
+)
+
+// How often the holder of an install lock refreshes it
+var installLockRefresh = 30 * time.Second
+
+// An install from before manifests were written
+var errNoInstallManifest = errors.New("no install manifest")
+
+// installManifest records the size and SHA-256 of every extracted file, by
+// its slash separated path in the install
+type installManifest struct {
+	Version string                  `json:"version"`
+	Files   map[string]manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Write the manifest of the files under dir
+func writeInstallManifest(dir, version string) error {
+	manifest := installManifest{Version: version, Files: map[string]manifestFile{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := hashInstallFile(path)
+		if err != nil {
+			return err
+		}
+		manifest.Files[filepath.ToSlash(rel)] = file
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, INSTALL_MANIFEST_FILE), data, 0644)
+}
+
+// Check every file of the manifest is in dir as it was extracted. Files
+// added since, like extensions and drivers, aren't checked.
+func verifyInstall(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, INSTALL_MANIFEST_FILE))
+	if os.IsNotExist(err) {
+		return errNoInstallManifest
+	} else if err != nil {
+		return err
+	}
+	var manifest installManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || len(manifest.Files) == 0 {
+		return fmt.Errorf("unreadable install manifest")
+	}
+	// Sizes first, a partial extraction shows without reading anything
+	for name, expected := range manifest.Files {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("%s is missing", name)
+		}
+		if info.Size() != expected.Size {
+			return fmt.Errorf("%s is %d bytes, expecting %d", name, info.Size(), expected.Size)
+		}
+	}
+	for name, expected := range manifest.Files {
+		file, err := hashInstallFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if file.SHA256 != expected.SHA256 {
+			return fmt.Errorf("%s doesn't match its checksum", name)
+		}
+	}
+	return nil
+}
+
+func hashInstallFile(path string) (manifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Take the install lock of a directory, shared by every process using the
+// cache, waiting for the process holding it. The lock names its holder and is
+// refreshed until released, however long the download takes.
+func lockInstall(dir string) (func(), error) {
+	path := dir + ".lock"
+	deadline := time.Now().Add(INSTALL_LOCK_TIMEOUT)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			host, _ := os.Hostname()
+			fmt.Fprintf(file, "%d@%s\n", os.Getpid(), host)
+			file.Close()
+			return refreshLock(path), nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > INSTALL_LOCK_TIMEOUT {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			holder, _ := os.ReadFile(path)
+			return nil, fmt.Errorf("%s is still being installed by process %s after %s", dir, strings.TrimSpace(string(holder)), INSTALL_LOCK_TIMEOUT)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Keep a lock fresh until the returned function releases it
+func refreshLock(path string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(installLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,420 @@
+package goliquify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	DIALECT_POSTGRES = "postgres"
+	DIALECT_MYSQL    = "mysql"
+)
+
+// Liquibase tracking tables, left out of schema models
+var LIQUIBASE_TABLES = []string{"databasechangelog", "databasechangeloglock"}
+
+// SchemaModel is the structure of one database schema
+type SchemaModel struct {
+	Tables map[string]*TableModel `json:"tables"`
+}
+
+// TableModel is a table with its columns, keys and indexes
+type TableModel struct {
+	Name string `json:"name"`
+	// Schema holding the table, not compared for drift
+	Schema      string             `json:"schema,omitempty"`
+	Columns     []*ColumnModel     `json:"columns"`
+	PrimaryKey  []string           `json:"primaryKey,omitempty"`
+	Indexes     []*IndexModel      `json:"indexes,omitempty"`
+	ForeignKeys []*ForeignKeyModel `json:"foreignKeys,omitempty"`
+}
+
+// ColumnModel is a table column, Type is normalized so types read from
+// different sources compare equal
+type ColumnModel struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// IndexModel is an index other than the primary key
+type IndexModel struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// ForeignKeyModel is a foreign key constraint
+type ForeignKeyModel struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"refTable"`
+	RefColumns []string `json:"refColumns"`
+}
+
+// Queries reading a schema, by dialect. Every query takes the schema name as
+// its only parameter.
+type introspectQueries struct {
+	columns, primaryKeys, foreignKeys, indexes string
+}
+
+var INTROSPECT_QUERIES = map[string]introspectQueries{
+	DIALECT_POSTGRES: {
+		columns: `SELECT c.table_name, c.column_name, c.data_type, c.is_nullable = 'YES',
+	COALESCE(c.character_maximum_length, 0), COALESCE(c.numeric_precision, 0), COALESCE(c.numeric_scale, 0)
+FROM information_schema.columns c
+JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+WHERE c.table_schema = $1 AND t.table_type = 'BASE TABLE'
+ORDER BY c.table_name, c.ordinal_position`,
+		primaryKeys: `SELECT k.table_name, k.column_name
+FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage k ON k.constraint_schema = tc.constraint_schema AND k.constraint_name = tc.constraint_name
+WHERE tc.table_schema = $1 AND tc.constraint_type = 'PRIMARY KEY'
+ORDER BY k.table_name, k.ordinal_position`,
+		foreignKeys: `SELECT k.table_name, k.constraint_name, k.column_name, r.table_name, r.column_name
+FROM information_schema.referential_constraints rc
+JOIN information_schema.key_column_usage k ON k.constraint_schema = rc.constraint_schema AND k.constraint_name = rc.constraint_name
+JOIN information_schema.key_column_usage r ON r.constraint_schema = rc.unique_constraint_schema AND r.constraint_name = rc.unique_constraint_name
+	AND r.ordinal_position = k.position_in_unique_constraint
+WHERE k.table_schema = $1
+ORDER BY k.table_name, k.constraint_name, k.ordinal_position`,
+		indexes: `SELECT t.relname, i.relname, ix.indisunique, a.attname
+FROM pg_catalog.pg_index ix
+JOIN pg_catalog.pg_class t ON t.oid = ix.indrelid
+JOIN pg_catalog.pg_class i ON i.oid = ix.indexrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = t.relnamespace
+JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord) ON true
+JOIN pg_catalog.pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+WHERE n.nspname = $1 AND NOT ix.indisprimary
+ORDER BY t.relname, i.relname, k.ord`,
+	},
+	DIALECT_MYSQL: {
+		columns: `SELECT c.table_name, c.column_name, c.column_type, c.is_nullable = 'YES', 0, 0, 0
+FROM information_schema.columns c
+JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+WHERE c.table_schema = ? AND t.table_type = 'BASE TABLE'
+ORDER BY c.table_name, c.ordinal_position`,
+		primaryKeys: `SELECT table_name, column_name
+FROM information_schema.key_column_usage
+WHERE table_schema = ? AND constraint_name = 'PRIMARY'
+ORDER BY table_name, ordinal_position`,
+		foreignKeys: `SELECT table_name, constraint_name, column_name, referenced_table_name, referenced_column_name
+FROM information_schema.key_column_usage
+WHERE table_schema = ? AND referenced_table_name IS NOT NULL
+ORDER BY table_name, constraint_name, ordinal_position`,
+		indexes: `SELECT table_name, index_name, non_unique = 0, column_name
+FROM information_schema.statistics
+WHERE table_schema = ? AND index_name <> 'PRIMARY'
+ORDER BY table_name, index_name, seq_in_index`,
+	},
+}
+
+// Estimated row counts of the tables of a schema, from the planner statistics.
+// Postgres reports -1 for tables never analyzed.
+var ROW_COUNT_QUERIES = map[string]string{
+	DIALECT_POSTGRES: `SELECT c.relname, c.reltuples::bigint
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relkind IN ('r', 'p')`,
+	DIALECT_MYSQL: `SELECT table_name, COALESCE(table_rows, -1)
+FROM information_schema.tables
+WHERE table_schema = ? AND table_type = 'BASE TABLE'`,
+}
+
+var (
+	typeSizePattern = regexp.MustCompile(`^([a-z][a-z0-9 ]*?)\s*(?:\((.*)\))?\s*(unsigned)?$`)
+	mysqlDSNPattern = regexp.MustCompile(`^(?:([^@]*)@)?tcp\(([^)]*)\)/([^?]*)`)
+	jdbcURLPattern  = regexp.MustCompile(`^jdbc:(postgresql|mysql|mariadb)://([^/?]*)/?([^?;]*)\??(.*)$`)
+)
+
+// Type names meaning the same type across databases and Liquibase snapshots
+var TYPE_ALIASES = map[string]string{
+	"character varying":           "varchar",
+	"varchar2":                    "varchar",
+	"character":                   "char",
+	"bpchar":                      "char",
+	"int4":                        "int",
+	"integer":                     "int",
+	"int8":                        "bigint",
+	"int2":                        "smallint",
+	"bool":                        "boolean",
+	"bit(1)":                      "boolean",
+	"tinyint(1)":                  "boolean",
+	"numeric":                     "decimal",
+	"float8":                      "double",
+	"double precision":            "double",
+	"float4":                      "real",
+	"timestamp without time zone": "timestamp",
+	"timestamp with time zone":    "timestamptz",
+	"time without time zone":      "time",
+	"time with time zone":         "timetz",
+	"datetime":                    "timestamp",
+}
+
+// Types whose size is a display width or implied, compared without it
+var UNSIZED_TYPES = []string{"int", "bigint", "smallint", "mediumint", "tinyint", "boolean", "real", "double",
+	"text", "timestamp", "timestamptz", "time", "timetz", "date", "uuid", "json", "jsonb", "bytea", "serial", "bigserial"}
+
+// NormalizeType reduces a column type to a canonical lowercase form, so the
+// same type read from a database and from a Liquibase snapshot compare equal
+func NormalizeType(t string) string {
+	t = strings.ToLower(strings.Join(strings.Fields(t), " "))
+	if alias, ok := TYPE_ALIASES[t]; ok {
+		return alias
+	}
+	m := typeSizePattern.FindStringSubmatch(t)
+	if m == nil {
+		return t
+	}
+	name, size := m[1], strings.ReplaceAll(m[2], " ", "")
+	if alias, ok := TYPE_ALIASES[name]; ok {
+		name = alias
+	}
+	if m[3] != "" {
+		name += " unsigned"
+	}
+	if size == "" || containsString(UNSIZED_TYPES, strings.TrimSuffix(name, " unsigned")) {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, size)
+}
+
+// Introspect reads the tables of a schema through database/sql, for
+// Postgres or MySQL. The caller opens the database with a driver of its
+// choice. Liquibase's own tables are left out.
+func Introspect(ctx context.Context, db *sql.DB, dialect, schema string) (*SchemaModel, error) {
+	queries, ok := INTROSPECT_QUERIES[dialect]
+	if !ok {
+		return nil, fmt.Errorf("introspecting %s databases is not supported, expecting %s or %s", dialect, DIALECT_POSTGRES, DIALECT_MYSQL)
+	}
+	model := &SchemaModel{Tables: map[string]*TableModel{}}
+	table := func(name string) *TableModel {
+		key := strings.ToLower(name)
+		if model.Tables[key] == nil {
+			model.Tables[key] = &TableModel{Name: name, Schema: schema}
+		}
+		return model.Tables[key]
+	}
+
+	err := queryRows(ctx, db, queries.columns, schema, func(rows *sql.Rows) error {
+		var tableName, name, dataType string
+		var nullable bool
+		var length, precision, scale int
+		if err := rows.Scan(&tableName, &name, &dataType, &nullable, &length, &precision, &scale); err != nil {
+			return err
+		}
+		switch {
+		case length > 0:
+			dataType = fmt.Sprintf("%s(%d)", dataType, length)
+		case precision > 0 && (dataType == "numeric" || dataType == "decimal"):
+			dataType = fmt.Sprintf("%s(%d,%d)", dataType, precision, scale)
+		}
+		t := table(tableName)
+		t.Columns = append(t.Columns, &ColumnModel{Name: name, Type: NormalizeType(dataType), Nullable: nullable})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %v", err)
+	}
+
+	err = queryRows(ctx, db, queries.primaryKeys, schema, func(rows *sql.Rows) error {
+		var tableName, column string
+		if err := rows.Scan(&tableName, &column); err != nil {
+			return err
+		}
+		t := table(tableName)
+		t.PrimaryKey = append(t.PrimaryKey, column)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read primary keys: %v", err)
+	}
+
+	err = queryRows(ctx, db, queries.foreignKeys, schema, func(rows *sql.Rows) error {
+		var tableName, name, column, refTable, refColumn string
+		if err := rows.Scan(&tableName, &name, &column, &refTable, &refColumn); err != nil {
+			return err
+		}
+		t := table(tableName)
+		if n := len(t.ForeignKeys); n == 0 || t.ForeignKeys[n-1].Name != name {
+			t.ForeignKeys = append(t.ForeignKeys, &ForeignKeyModel{Name: name, RefTable: refTable})
+		}
+		fk := t.ForeignKeys[len(t.ForeignKeys)-1]
+		fk.Columns = append(fk.Columns, column)
+		fk.RefColumns = append(fk.RefColumns, refColumn)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %v", err)
+	}
+
+	err = queryRows(ctx, db, queries.indexes, schema, func(rows *sql.Rows) error {
+		var tableName, name, column string
+		var unique bool
+		if err := rows.Scan(&tableName, &name, &unique, &column); err != nil {
+			return err
+		}
+		t := table(tableName)
+		if n := len(t.Indexes); n == 0 || t.Indexes[n-1].Name != name {
+			t.Indexes = append(t.Indexes, &IndexModel{Name: name, Unique: unique})
+		}
+		idx := t.Indexes[len(t.Indexes)-1]
+		idx.Columns = append(idx.Columns, column)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes: %v", err)
+	}
+
+	for _, name := range LIQUIBASE_TABLES {
+		delete(model.Tables, name)
+	}
+	return model, nil
+}
+
+// TableRowCounts reads the estimated row counts of the tables of a schema,
+// keyed by lowercase table name. Counts are -1 when unknown.
+func TableRowCounts(ctx context.Context, db *sql.DB, dialect, schema string) (map[string]int64, error) {
+	query, ok := ROW_COUNT_QUERIES[dialect]
+	if !ok {
+		return nil, fmt.Errorf("reading row counts of %s databases is not supported, expecting %s or %s", dialect, DIALECT_POSTGRES, DIALECT_MYSQL)
+	}
+	counts := map[string]int64{}
+	err := queryRows(ctx, db, query, schema, func(rows *sql.Rows) error {
+		var table string
+		var count int64
+		if err := rows.Scan(&table, &count); err != nil {
+			return err
+		}
+		counts[strings.ToLower(table)] = count
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read row counts: %v", err)
+	}
+	return counts, nil
+}
+
+// Run a query and call scan for every row
+func queryRows(ctx context.Context, db *sql.DB, query, schema string, scan func(*sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query, schema)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// TableNames returns the table names of the model, sorted
+func (m *SchemaModel) TableNames() []string {
+	names := make([]string, 0, len(m.Tables))
+	for name := range m.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DSNFromJDBC converts a Liquibase JDBC url for Postgres or MySQL into a
+// database/sql dialect and data source name
+func DSNFromJDBC(jdbcURL, username, password string) (string, string, error) {
+	m := jdbcURLPattern.FindStringSubmatch(jdbcURL)
+	if m == nil {
+		return "", "", fmt.Errorf("can't convert %s to a data source name, only postgresql and mysql urls are supported", jdbcURL)
+	}
+	host, database, params := m[2], m[3], m[4]
+	query, err := url.ParseQuery(params)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid parameters in %s: %v", jdbcURL, err)
+	}
+	if username == "" {
+		username = query.Get("user")
+	}
+	if password == "" {
+		password = query.Get("password")
+	}
+	query.Del("user")
+	query.Del("password")
+
+	if m[1] == "postgresql" {
+		u := url.URL{Scheme: "postgres", Host: host, Path: "/" + database, RawQuery: query.Encode()}
+		if username != "" {
+			u.User = url.UserPassword(username, password)
+		}
+		return DIALECT_POSTGRES, u.String(), nil
+	}
+	if !strings.Contains(host, ":") {
+		host += ":3306"
+	}
+	dsn := fmt.Sprintf("tcp(%s)/%s", host, database)
+	if username != "" {
+		dsn = fmt.Sprintf("%s:%s@%s", username, password, dsn)
+	}
+	if len(query) > 0 {
+		dsn += "?" + query.Encode()
+	}
+	return DIALECT_MYSQL, dsn, nil
+}
+
+// JDBCFromDSN converts a database/sql data source name for Postgres, a
+// postgres:// url, or MySQL, user:password@tcp(host:port)/database, into a
+// JDBC url and credentials. JDBC urls are returned as they are. Parameters
+// of the MySQL driver for Go are dropped, Connector/J doesn't know them.
+func JDBCFromDSN(dsn string) (string, string, string, error) {
+	if strings.HasPrefix(dsn, "jdbc:") {
+		return dsn, "", "", nil
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid data source name: %v", err)
+		}
+		jdbcURL := fmt.Sprintf("jdbc:postgresql://%s%s", u.Host, u.EscapedPath())
+		if u.RawQuery != "" {
+			jdbcURL += "?" + u.RawQuery
+		}
+		password, _ := u.User.Password()
+		return jdbcURL, u.User.Username(), password, nil
+	}
+	m := mysqlDSNPattern.FindStringSubmatch(dsn)
+	if m == nil {
+		return "", "", "", fmt.Errorf("can't convert the data source name to a JDBC url, expecting a postgres:// url or user:password@tcp(host:port)/database")
+	}
+	username, password, _ := strings.Cut(m[1], ":")
+	return fmt.Sprintf("jdbc:mysql://%s/%s", m[2], m[3]), username, password, nil
+}
+
+// DatabaseDSN converts the JDBC url and credentials of the defaults file
+// into a database/sql dialect and data source name
+func (pl *GoLiquibase) DatabaseDSN() (string, string, error) {
+	jdbcURL, username, password, err := pl.defaultsConnection()
+	if err != nil {
+		return "", "", err
+	}
+	return DSNFromJDBC(jdbcURL, username, password)
+}
+
+// Read the JDBC url and credentials of the defaults file
+func (pl *GoLiquibase) defaultsConnection() (string, string, string, error) {
+	props, err := ReadDefaultsFile(pl.DefaultsFile)
+	if err != nil {
+		return "", "", "", err
+	}
+	jdbcURL := firstNonEmpty(props["url"], props["liquibase.command.url"])
+	if jdbcURL == "" {
+		return "", "", "", fmt.Errorf("%s has no database url", pl.DefaultsFile)
+	}
+	return jdbcURL,
+		firstNonEmpty(props["username"], props["liquibase.command.username"]),
+		firstNonEmpty(props["password"], props["liquibase.command.password"]), nil
+}
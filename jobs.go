@@ -0,0 +1,342 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_JOBS_DIR = ".goliquify/jobs"
+	// Finished jobs kept in the jobs directory, older ones are removed
+	DEFAULT_JOBS_KEPT = 500
+)
+
+// Job states, finished jobs are completed, failed or interrupted
+const (
+	JOB_QUEUED      = "queued"
+	JOB_RUNNING     = "running"
+	JOB_COMPLETED   = EVENT_COMPLETED
+	JOB_FAILED      = EVENT_FAILED
+	JOB_INTERRUPTED = "interrupted"
+)
+
+// Commands run again when the server stopped while they ran. Liquibase
+// picks up an update from the changesets still pending.
+var RESUMABLE_COMMANDS = []string{"status", "validate", "diff", "history", "updateSQL", "update", "rollbackSQL", OPERATION_DRIFT, OPERATION_EXPORT_STATUS}
+
+// Job is a command queued on an environment
+type Job struct {
+	ID          string    `json:"id"`
+	Environment string    `json:"environment"`
+	Command     string    `json:"command"`
+	Args        []string  `json:"args,omitempty"`
+	Principal   string    `json:"principal,omitempty"`
+	Status      string    `json:"status"`
+	Created     time.Time `json:"created"`
+	Start       time.Time `json:"start,omitempty"`
+	End         time.Time `json:"end,omitempty"`
+	// Attempts counts the runs, above 1 when resumed
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Finished reports if the job won't run anymore
+func (j *Job) Finished() bool {
+	return j.Status != JOB_QUEUED && j.Status != JOB_RUNNING
+}
+
+// JobQueueOptions configure a job queue
+type JobQueueOptions struct {
+	// Dir holds the state and log of every job
+	Dir string
+	// Target names the database a job runs against. Jobs of a target run
+	// one at a time, jobs of different targets in parallel.
+	Target func(job *Job) string
+	// Run runs a job, writing its log
+	Run func(ctx context.Context, job *Job, output io.Writer) error
+	// Done is called when a job finishes
+	Done func(job *Job)
+	// Kept is the number of finished jobs kept, DEFAULT_JOBS_KEPT when 0
+	Kept int
+}
+
+// JobQueue runs jobs serialized per target and persists their state, so
+// that a restarted server still reports them and resumes the unfinished
+// ones
+type JobQueue struct {
+	opts    JobQueueOptions
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	pending map[string][]*Job
+	workers map[string]bool
+}
+
+// NewJobQueue loads the jobs of the directory. Queued jobs are resumed,
+// and so are jobs the server stopped running when their command is in
+// RESUMABLE_COMMANDS, the others are marked interrupted.
+func NewJobQueue(opts JobQueueOptions) (*JobQueue, error) {
+	if opts.Kept == 0 {
+		opts.Kept = DEFAULT_JOBS_KEPT
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &JobQueue{opts: opts, jobs: map[string]*Job{}, pending: map[string][]*Job{}, workers: map[string]bool{}}
+
+	files, err := filepath.Glob(filepath.Join(opts.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var resumed []*Job
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Printf("Skipping job %s: %v", file, err)
+			continue
+		}
+		q.jobs[job.ID] = &job
+		switch {
+		case job.Status == JOB_QUEUED:
+			resumed = append(resumed, &job)
+		case job.Status == JOB_RUNNING && containsString(RESUMABLE_COMMANDS, job.Command):
+			job.Status = JOB_QUEUED
+			q.appendLog(&job, "\n--- The server stopped during attempt %d, resuming ---\n", job.Attempts)
+			resumed = append(resumed, &job)
+		case job.Status == JOB_RUNNING:
+			job.Status, job.End = JOB_INTERRUPTED, time.Now()
+			job.Error = fmt.Sprintf("the server stopped while %s ran, check the environment before resuming it", job.Command)
+			if err := q.save(&job); err != nil {
+				return nil, err
+			}
+			if opts.Done != nil {
+				opts.Done(&job)
+			}
+		}
+	}
+	q.prune()
+	sort.Slice(resumed, func(i, j int) bool { return resumed[i].Created.Before(resumed[j].Created) })
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range resumed {
+		log.Printf("Resuming job %s: %s on %s", job.ID, job.Command, job.Environment)
+		if err := q.enqueue(job); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Submit queues a new job
+func (q *JobQueue) Submit(environment, command string, args []string, principal string) (*Job, error) {
+	now := time.Now()
+	job := &Job{
+		ID:          newRunID(now),
+		Environment: environment,
+		Command:     command,
+		Args:        args,
+		Principal:   principal,
+		Status:      JOB_QUEUED,
+		Created:     now,
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[job.ID] = job
+	if err := q.enqueue(job); err != nil {
+		delete(q.jobs, job.ID)
+		return nil, err
+	}
+	copy := *job
+	return &copy, nil
+}
+
+// Resume queues an interrupted or failed job again
+func (q *JobQueue) Resume(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown job %s", id)
+	}
+	if job.Status != JOB_INTERRUPTED && job.Status != JOB_FAILED {
+		return nil, fmt.Errorf("job %s is %s, only interrupted and failed jobs resume", id, job.Status)
+	}
+	job.Status, job.Error = JOB_QUEUED, ""
+	q.appendLog(job, "\n--- Resumed after attempt %d ---\n", job.Attempts)
+	if err := q.enqueue(job); err != nil {
+		return nil, err
+	}
+	copy := *job
+	return &copy, nil
+}
+
+// Get returns a copy of a job
+func (q *JobQueue) Get(id string) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copy := *job
+	return &copy, true
+}
+
+// List returns copies of the jobs of an environment, or of every one when
+// empty, newest first
+func (q *JobQueue) List(environment string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := []Job{}
+	for _, job := range q.jobs {
+		if environment == "" || job.Environment == environment {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID > jobs[j].ID })
+	return jobs
+}
+
+// LogFile returns the path of a job's log
+func (q *JobQueue) LogFile(id string) string {
+	return filepath.Join(q.opts.Dir, id+".log")
+}
+
+// Queue a job on its target, starting a worker for the target when none
+// runs. Called with the lock held.
+func (q *JobQueue) enqueue(job *Job) error {
+	if err := q.save(job); err != nil {
+		return err
+	}
+	target := q.opts.Target(job)
+	q.pending[target] = append(q.pending[target], job)
+	if !q.workers[target] {
+		q.workers[target] = true
+		go q.work(target)
+	}
+	return nil
+}
+
+// Run the jobs of a target one after the other
+func (q *JobQueue) work(target string) {
+	for {
+		q.mu.Lock()
+		if len(q.pending[target]) == 0 {
+			delete(q.pending, target)
+			delete(q.workers, target)
+			q.mu.Unlock()
+			return
+		}
+		job := q.pending[target][0]
+		q.pending[target] = q.pending[target][1:]
+		job.Status, job.Start, job.End = JOB_RUNNING, time.Now(), time.Time{}
+		job.Attempts++
+		if err := q.save(job); err != nil {
+			log.Printf("Failed to save job %s: %v", job.ID, err)
+		}
+		running := *job
+		q.mu.Unlock()
+
+		err := q.run(&running)
+
+		q.mu.Lock()
+		job.Status, job.End, job.Error = JOB_COMPLETED, time.Now(), ""
+		if err != nil {
+			job.Status, job.Error = JOB_FAILED, err.Error()
+		}
+		if err := q.save(job); err != nil {
+			log.Printf("Failed to save job %s: %v", job.ID, err)
+		}
+		finished := *job
+		q.prune()
+		q.mu.Unlock()
+		if q.opts.Done != nil {
+			q.opts.Done(&finished)
+		}
+	}
+}
+
+func (q *JobQueue) run(job *Job) error {
+	output, err := os.OpenFile(q.LogFile(job.ID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	err = q.opts.Run(context.Background(), job, output)
+	if err != nil {
+		fmt.Fprintf(output, "\n%s failed: %v\n", job.Command, err)
+	}
+	return err
+}
+
+// Write the state of a job, replacing the previous one at once
+func (q *JobQueue) save(job *Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.opts.Dir, job.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (q *JobQueue) appendLog(job *Job, format string, args ...any) {
+	file, err := os.OpenFile(q.LogFile(job.ID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintf(file, format, args...)
+}
+
+// Remove the oldest finished jobs beyond the kept ones
+func (q *JobQueue) prune() {
+	var finished []string
+	for id, job := range q.jobs {
+		if job.Finished() {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= q.opts.Kept {
+		return
+	}
+	sort.Strings(finished)
+	for _, id := range finished[:len(finished)-q.opts.Kept] {
+		delete(q.jobs, id)
+		os.Remove(filepath.Join(q.opts.Dir, id+".json"))
+		os.Remove(q.LogFile(id))
+	}
+}
+
+// Running returns the command running on an environment and the number of
+// jobs queued behind it
+func (q *JobQueue) Running(environment string) (command string, queued int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.Environment != environment {
+			continue
+		}
+		switch job.Status {
+		case JOB_RUNNING:
+			command = strings.Join(append([]string{job.Command}, job.Args...), " ")
+		case JOB_QUEUED:
+			queued++
+		}
+	}
+	return command, queued
+}
@@ -0,0 +1,96 @@
+package goliquify
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const DEFAULT_JOURNAL_FILE = ".goliquify/journal.jsonl"
+
+// Serializes journal writes from concurrent commands
+var journalMu sync.Mutex
+
+// RunRecord is one entry in the run journal
+type RunRecord struct {
+	ID             string        `json:"id"`
+	Command        string        `json:"command"`
+	Environment    string        `json:"environment,omitempty"`
+	Start          time.Time     `json:"start"`
+	End            time.Time     `json:"end"`
+	Status         string        `json:"status"`
+	Error          string        `json:"error,omitempty"`
+	WindowOverride string        `json:"windowOverride,omitempty"`
+	CI             *CIMetadata   `json:"ci,omitempty"`
+	LogFile        string        `json:"logFile,omitempty"`
+	Backup         *BackupRecord `json:"backup,omitempty"`
+	// Ticket is the change ticket the run is recorded in
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// Generate a unique, time ordered run ID
+func newRunID(t time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", t.UTC().Format("20060102T150405"), hex.EncodeToString(b))
+}
+
+// Append a record to the journal file
+func AppendJournal(path string, record RunRecord) error {
+	return appendJSONLine(path, record)
+}
+
+// Append a value as a line of JSON to a file
+func appendJSONLine(path string, v any) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Read all records from the journal file
+func ReadJournal(path string) ([]RunRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse journal %s: %v", path, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,296 @@
+package goliquify
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// Adoptium lists the latest Temurin build per feature version, OS and architecture
+	ADOPTIUM_ASSETS_URL = "https://api.adoptium.net/v3/assets/latest/%d/hotspot?os=%s&architecture=%s&image_type=jre&vendor=eclipse"
+	JRE_DIR             = "jre-{version}"
+)
+
+// JRETarget is the platform a managed JRE is built for, in Adoptium terms
+type JRETarget struct {
+	// OS is linux, alpine-linux for musl based systems, mac or windows
+	OS string
+	// Arch is x64 or aarch64
+	Arch string
+}
+
+func (t JRETarget) String() string {
+	return t.OS + "-" + t.Arch
+}
+
+// DetectJRETarget returns the JRE platform of this machine. Alpine and other
+// musl based systems need musl builds, glibc builds don't start there.
+func DetectJRETarget() (JRETarget, error) {
+	var target JRETarget
+	switch runtime.GOARCH {
+	case "amd64":
+		target.Arch = "x64"
+	case "arm64":
+		target.Arch = "aarch64"
+	default:
+		return target, fmt.Errorf("no managed JRE for the %s architecture, install Java yourself", runtime.GOARCH)
+	}
+	switch runtime.GOOS {
+	case "linux":
+		target.OS = "linux"
+		if isMusl() {
+			target.OS = "alpine-linux"
+		}
+	case "darwin":
+		target.OS = "mac"
+	case "windows":
+		target.OS = "windows"
+	default:
+		return target, fmt.Errorf("no managed JRE for %s, install Java yourself", runtime.GOOS)
+	}
+	return target, nil
+}
+
+// Check if the system libc is musl, which has a dynamic loader named ld-musl-<arch>.so.1
+func isMusl() bool {
+	if fileExists("/etc/alpine-release") {
+		return true
+	}
+	matches, _ := filepath.Glob("/lib/ld-musl-*.so.1")
+	return len(matches) > 0
+}
+
+// Find the Java home in an extracted JRE, macOS builds keep it in Contents/Home
+func jreHome(dir string) string {
+	for _, home := range []string{dir, filepath.Join(dir, "Contents", "Home")} {
+		for _, java := range []string{"java", "java.exe"} {
+			if fileExists(filepath.Join(home, "bin", java)) {
+				return home
+			}
+		}
+	}
+	return ""
+}
+
+// Download the managed JRE for this platform unless it is cached, and return its Java home
+func (pl *GoLiquibase) installJRE(ctx context.Context, cacheDir string) (string, error) {
+	target, err := DetectJRETarget()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, versioned(JRE_DIR, fmt.Sprintf("%d-%s", pl.JavaVersion, target)))
+	if home := jreHome(dir); home != "" {
+		pl.logger().Printf("Java %d for %s found, skipping download...", pl.JavaVersion, target)
+		return home, nil
+	}
+
+	var assets []struct {
+		Binary struct {
+			Package struct {
+				Name     string `json:"name"`
+				Link     string `json:"link"`
+				Checksum string `json:"checksum"`
+			} `json:"package"`
+		} `json:"binary"`
+		ReleaseName string `json:"release_name"`
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(ADOPTIUM_ASSETS_URL, pl.JavaVersion, target.OS, target.Arch), nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to look up Java %d for %s: %s", pl.JavaVersion, target, response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(&assets); err != nil {
+		return "", fmt.Errorf("failed to look up Java %d for %s: %v", pl.JavaVersion, target, err)
+	}
+	if len(assets) == 0 {
+		return "", fmt.Errorf("no Java %d JRE is published for %s", pl.JavaVersion, target)
+	}
+	pkg := assets[0].Binary.Package
+
+	archive := filepath.Join(os.TempDir(), pkg.Name)
+	if err := pl.downloadFile(ctx, pkg.Link, archive); err != nil {
+		return "", err
+	}
+	defer os.Remove(archive)
+	actual, err := sha256File(archive)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(actual, pkg.Checksum) {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", pkg.Name, pkg.Checksum, actual)
+	}
+
+	// Extract next to the destination and move it in place, like Liquibase installs
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	extractDir, err := os.MkdirTemp(cacheDir, ".extract-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(extractDir)
+	pl.logger().Printf("Extracting %s to %s", assets[0].ReleaseName, dir)
+	if strings.HasSuffix(pkg.Name, ".zip") {
+		err = unzipFile(archive, extractDir)
+	} else {
+		err = untarGzFile(archive, extractDir)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Archives hold a single top level directory, e.g. jdk-17.0.12+7-jre
+	entries, err := os.ReadDir(extractDir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) != 1 || jreHome(filepath.Join(extractDir, entries[0].Name())) == "" {
+		return "", fmt.Errorf("java launcher not found in %s", pkg.Name)
+	}
+	os.RemoveAll(dir)
+	if err := os.Rename(filepath.Join(extractDir, entries[0].Name()), dir); err != nil {
+		return "", err
+	}
+	return jreHome(dir), nil
+}
+
+// JavaHome returns the Java home of the managed JRE, empty when Java comes from the system
+func (pl *GoLiquibase) JavaHome() string {
+	installMu.Lock()
+	defer installMu.Unlock()
+	return pl.javaHome
+}
+
+// Run the command with the managed JRE, ahead of any Java on the PATH
+func useJavaHome(cmd *Command, home string) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, "JAVA_HOME="+home, "PATH="+filepath.Join(home, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Compute the hex sha256 of a file
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Extract a gzipped tar file, keeping modes and the symlinks JRE builds use
+func untarGzFile(path, destinationDir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	root, err := filepath.Abs(destinationDir)
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, header.Name)
+		if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %s in %s", header.Name, filepath.Base(path))
+		}
+		if err := checkNoSymlinks(root, target); err != nil {
+			return fmt.Errorf("invalid path %s in %s: %v", header.Name, filepath.Base(path), err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Links stay in the install, archives aren't checksummed
+			resolved := filepath.Join(filepath.Dir(target), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || (resolved != root && !strings.HasPrefix(resolved, root+string(os.PathSeparator))) {
+				return fmt.Errorf("invalid link %s -> %s in %s", header.Name, header.Linkname, filepath.Base(path))
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm()|0600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, reader); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Refuse to write through a symlink extracted before, every existing path
+// between root and target must be a real file or directory
+func checkNoSymlinks(root, target string) error {
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == "." {
+		return err
+	}
+	path := root
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", path)
+		}
+	}
+	return nil
+}
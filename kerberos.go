@@ -0,0 +1,155 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// JAAS entry the SQL Server driver logs in with
+const KERBEROS_JAAS_ENTRY = "SQLJDBCDriver"
+
+// KerberosConfig logs in to SQL Server or Oracle with Kerberos, from the
+// ticket cache kinit wrote or from a keytab
+type KerberosConfig struct {
+	// Krb5Conf is the krb5.conf of the realm, the system's when empty
+	Krb5Conf string `yaml:"krb5Conf"`
+	// JAASConfig is a JAAS login configuration with a SQLJDBCDriver entry,
+	// generated from the settings below when empty
+	JAASConfig string `yaml:"jaasConfig"`
+	// TicketCache is the credential cache, KRB5CCNAME or the default one
+	// when empty
+	TicketCache string `yaml:"ticketCache"`
+	// Principal and Keytab log in without a ticket cache, e.g. as a service
+	// account in CI
+	Principal string `yaml:"principal"`
+	Keytab    string `yaml:"keytab"`
+	// ServerSPN is the service principal of SQL Server, derived from the
+	// host by the driver when empty
+	ServerSPN string `yaml:"serverSpn"`
+}
+
+// Validate checks the settings and that their files exist
+func (k *KerberosConfig) Validate() error {
+	if (k.Principal == "") != (k.Keytab == "") {
+		return fmt.Errorf("kerberos needs both a principal and a keytab to log in with a keytab")
+	}
+	for _, file := range []string{k.Krb5Conf, k.JAASConfig, k.Keytab} {
+		if file != "" && !fileExists(file) {
+			return fmt.Errorf("kerberos file %s not found", file)
+		}
+	}
+	return nil
+}
+
+// kerberosLogin is what a command needs to log in with Kerberos
+type kerberosLogin struct {
+	driverProperties map[string]string
+	javaOptions      []string
+	env              []string
+}
+
+// Prepare the Kerberos login of a command, checking a ticket is available
+// before Liquibase starts. Generated files are written to dir.
+func (k *KerberosConfig) login(ctx context.Context, subprotocol, dir string, logger *log.Logger) (*kerberosLogin, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	login := &kerberosLogin{javaOptions: []string{"-Djavax.security.auth.useSubjectCredsOnly=false"}}
+	if k.Krb5Conf != "" {
+		krb5Conf, err := filepath.Abs(k.Krb5Conf)
+		if err != nil {
+			return nil, err
+		}
+		login.javaOptions = append(login.javaOptions, "-Djava.security.krb5.conf="+krb5Conf)
+		login.env = append(login.env, "KRB5_CONFIG="+krb5Conf)
+	}
+	cache := firstNonEmpty(k.TicketCache, os.Getenv("KRB5CCNAME"))
+
+	switch subprotocol {
+	case "sqlserver":
+		login.driverProperties = map[string]string{"integratedSecurity": "true", "authenticationScheme": "JavaKerberos"}
+		if k.ServerSPN != "" {
+			login.driverProperties["serverSpn"] = k.ServerSPN
+		}
+		jaasConfig := k.JAASConfig
+		if jaasConfig == "" {
+			jaasConfig = filepath.Join(dir, "jaas.conf")
+			if err := os.WriteFile(jaasConfig, []byte(k.jaasEntry(cache)), 0600); err != nil {
+				return nil, err
+			}
+		}
+		login.javaOptions = append(login.javaOptions, "-Djava.security.auth.login.config="+jaasConfig)
+	case "oracle":
+		// The Oracle driver only reads ticket caches, a keytab is logged in
+		// to a private one first
+		if k.Keytab != "" {
+			cache = filepath.Join(dir, "krb5cc")
+			logger.Printf("Logging in to Kerberos as %s with keytab %s", k.Principal, k.Keytab)
+			if err := runKerberosTool(ctx, login.env, "kinit", "-k", "-t", k.Keytab, "-c", cache, k.Principal); err != nil {
+				return nil, err
+			}
+		}
+		login.driverProperties = map[string]string{
+			"oracle.net.authentication_services":         "(KERBEROS5)",
+			"oracle.net.kerberos5_mutual_authentication": "true",
+		}
+		if cache != "" {
+			login.driverProperties["oracle.net.kerberos5_cc_name"] = strings.TrimPrefix(cache, "FILE:")
+		}
+	default:
+		return nil, fmt.Errorf("kerberos logins are supported on sqlserver and oracle urls, not %s", subprotocol)
+	}
+
+	if cache != "" {
+		login.env = append(login.env, "KRB5CCNAME="+cache)
+	}
+	if k.Keytab == "" {
+		if err := checkKerberosTicket(ctx, login.env, logger); err != nil {
+			return nil, err
+		}
+	}
+	return login, nil
+}
+
+// The JAAS entry of the SQL Server driver, logging in from the keytab or
+// the ticket cache
+func (k *KerberosConfig) jaasEntry(cache string) string {
+	options := []string{"com.sun.security.auth.module.Krb5LoginModule required", "doNotPrompt=true"}
+	if k.Keytab != "" {
+		keytab, _ := filepath.Abs(k.Keytab)
+		options = append(options, "useKeyTab=true", fmt.Sprintf("keyTab=%q", filepath.ToSlash(keytab)), fmt.Sprintf("principal=%q", k.Principal), "storeKey=true")
+	} else {
+		options = append(options, "useTicketCache=true")
+		if cache != "" {
+			options = append(options, fmt.Sprintf("ticketCache=%q", strings.TrimPrefix(cache, "FILE:")))
+		}
+	}
+	return fmt.Sprintf("%s {\n  %s;\n};\n", KERBEROS_JAAS_ENTRY, strings.Join(options, "\n  "))
+}
+
+// Check the ticket cache holds a valid ticket with klist, so a missing
+// kinit fails before Liquibase starts rather than deep in the driver
+func checkKerberosTicket(ctx context.Context, env []string, logger *log.Logger) error {
+	if _, err := exec.LookPath("klist"); err != nil {
+		logger.Printf("klist not found, not checking for a Kerberos ticket")
+		return nil
+	}
+	if err := runKerberosTool(ctx, env, "klist", "-s"); err != nil {
+		return fmt.Errorf("no valid Kerberos ticket, log in with kinit first")
+	}
+	return nil
+}
+
+func runKerberosTool(ctx context.Context, env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package goliquify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OverlayDefaultsFile returns the overlay of a defaults file for an
+// environment, liquibase.properties becomes liquibase.<env>.properties
+func OverlayDefaultsFile(base, env string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + env + ext
+}
+
+// RenderLayeredDefaults merges the overlay of an environment into its base
+// defaults file, so that shared settings live in the base file and each
+// environment only overrides what differs. Overlay settings replace the
+// base settings of the same key, new ones are appended. The merged file is
+// rendered into cacheDir, the user cache dir when empty, and its path
+// returned. Without an environment or an overlay the base file is returned
+// as is.
+func RenderLayeredDefaults(base, env, cacheDir string) (string, error) {
+	if base == "" || env == "" {
+		return base, nil
+	}
+	overlay := OverlayDefaultsFile(base, env)
+	if !fileExists(overlay) {
+		return base, nil
+	}
+	if IsEncryptedFile(base) || IsEncryptedFile(overlay) {
+		return "", fmt.Errorf("can't layer %s over %s, encrypted defaults files aren't layered", overlay, base)
+	}
+	baseData, err := os.ReadFile(base)
+	if err != nil {
+		return "", fmt.Errorf("failed to read defaults file: %v", err)
+	}
+	overlayData, err := os.ReadFile(overlay)
+	if err != nil {
+		return "", fmt.Errorf("failed to read defaults overlay: %v", err)
+	}
+	merged := mergeDefaults(baseData, overlayData, filepath.Base(overlay))
+
+	// Liquibase looks for changelogs next to the defaults file, which the
+	// rendered file no longer is
+	if !hasAnyKey(propertyLines(merged), "searchPath", "search-path", "liquibase.searchPath") {
+		searchPath := "."
+		if dir := filepath.Dir(base); dir != "." {
+			if abs, err := filepath.Abs(dir); err == nil {
+				dir = abs
+			}
+			searchPath += "," + dir
+		}
+		merged = append(merged, "searchPath="+escapeProperty(searchPath))
+	}
+	content := []byte(strings.Join(merged, "\n") + "\n")
+
+	if cacheDir == "" {
+		cacheDir = defaultCacheDir()
+	}
+	dir := filepath.Join(cacheDir, "defaults")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// Named after the content, so runs with the same layers share the file
+	sum := sha256.Sum256(content)
+	target := filepath.Join(dir, fmt.Sprintf("%s-%s.properties", env, hex.EncodeToString(sum[:6])))
+	if fileExists(target) {
+		return target, nil
+	}
+	tmp, err := os.CreateTemp(dir, ".rendering-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// Merge the lines of an overlay into the lines of a base properties file
+func mergeDefaults(base, overlay []byte, overlayName string) []string {
+	lines := splitLines(base)
+	keys := propertyLines(lines)
+	var added []string
+	for _, line := range splitLines(overlay) {
+		key, ok := propertyKey(line)
+		if !ok {
+			continue
+		}
+		if i, ok := keys[key]; ok {
+			lines[i] = line
+		} else {
+			added = append(added, line)
+		}
+	}
+	// Label the settings only the overlay has
+	if len(added) > 0 {
+		lines = append(append(lines, "# "+overlayName), added...)
+	}
+	return lines
+}
+
+// The line of every key of a properties file
+func propertyLines(lines []string) map[string]int {
+	index := map[string]int{}
+	for i, line := range lines {
+		if key, ok := propertyKey(line); ok {
+			index[key] = i
+		}
+	}
+	return index
+}
+
+// The key of a properties line, the way ReadDefaultsFile reads it
+func propertyKey(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+		return "", false
+	}
+	if i := strings.IndexAny(line, "=:"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line), true
+}
+
+func hasAnyKey(index map[string]int, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := index[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return lines
+}
@@ -0,0 +1,247 @@
+package goliquify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_LINT_CACHE_FILE = ".goliquify/lint-cache.json"
+
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+
+	LINT_MISSING_ID     = "missing-id"
+	LINT_MISSING_AUTHOR = "missing-author"
+	LINT_EMPTY          = "empty-changeset"
+	LINT_NO_ROLLBACK    = "no-rollback"
+)
+
+// Change types Liquibase can't roll back by itself, changesets using them
+// should define a rollback
+var NO_AUTO_ROLLBACK_CHANGES = []string{
+	"sql", "sqlFile", "delete", "insert", "update", "loadData", "loadUpdateData",
+	"dropTable", "dropColumn", "dropIndex", "dropView", "dropSequence", "dropProcedure",
+	"dropForeignKeyConstraint", "dropPrimaryKey", "dropUniqueConstraint", "dropNotNullConstraint",
+	"dropDefaultValue", "modifyDataType", "mergeColumns", "executeCommand", "customChange", "output",
+}
+
+// Liquibase formatted SQL changelogs start with this comment
+var sqlChangelogHeaderPattern = regexp.MustCompile(`(?i)^\s*--\s*liquibase formatted sql`)
+
+var errorLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// Serializes lint cache updates
+var lintCacheMu sync.Mutex
+
+// Cached lint results by file content hash
+type lintCache map[string][]Diagnostic
+
+// IsChangelog checks if a file is a Liquibase changelog, to tell changelogs
+// apart from other XML, YAML, JSON and SQL files in a repository
+func IsChangelog(path string) bool {
+	if !isChangelogFile(path) {
+		return false
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(path), ".sql") {
+		return sqlChangelogHeaderPattern.Match(content)
+	}
+	return strings.Contains(string(content), "databaseChangeLog")
+}
+
+// LintChangelog checks one parsed changelog for problems that don't need a
+// database: changesets without id or author, empty changesets, duplicates
+// within the file, missing relative includes and missing rollbacks
+func LintChangelog(file *ChangelogFile) []Diagnostic {
+	var diagnostics []Diagnostic
+	add := func(kind, severity string, line int, cs *ChangeSet, format string, args ...any) {
+		d := Diagnostic{Kind: kind, Severity: severity, File: file.DiskPath, Line: line, Message: fmt.Sprintf(format, args...)}
+		if cs != nil {
+			d.ChangeSet = cs.Key()
+		}
+		diagnostics = append(diagnostics, d)
+	}
+
+	first := map[string]*ChangeSet{}
+	for _, cs := range file.ChangeSets {
+		if cs.ID == "" {
+			add(LINT_MISSING_ID, SEVERITY_ERROR, cs.Line, cs, "changeset has no id")
+		}
+		if cs.Author == "" {
+			add(LINT_MISSING_AUTHOR, SEVERITY_ERROR, cs.Line, cs, "changeset %s has no author", cs.ID)
+		}
+		if prev, dup := first[cs.Key()]; dup {
+			add(DIAGNOSTIC_DUPLICATE, SEVERITY_ERROR, cs.Line, cs, "duplicate changeset %s, first defined on line %d", changesetLabel(cs.Key()), prev.Line)
+		} else {
+			first[cs.Key()] = cs
+		}
+		if len(cs.Changes) == 0 {
+			add(LINT_EMPTY, SEVERITY_WARNING, cs.Line, cs, "changeset %s has no changes", changesetLabel(cs.Key()))
+			continue
+		}
+		if !cs.Rollback {
+			for _, change := range cs.Changes {
+				if containsFold(NO_AUTO_ROLLBACK_CHANGES, change) {
+					add(LINT_NO_ROLLBACK, SEVERITY_WARNING, cs.Line, cs, "changeset %s uses %s, which can't be rolled back automatically, add a rollback", changesetLabel(cs.Key()), change)
+					break
+				}
+			}
+		}
+	}
+
+	// Relative includes can be checked without knowing the search path
+	for _, inc := range file.Includes {
+		if !inc.Relative {
+			continue
+		}
+		rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(file.Path)), filepath.FromSlash(inc.File))
+		if err != nil {
+			continue
+		}
+		target := filepath.Join(filepath.Dir(file.DiskPath), rel)
+		if (inc.All && !dirExists(target)) || (!inc.All && !fileExists(target)) {
+			add(DIAGNOSTIC_MISSING_INCLUDE, SEVERITY_ERROR, inc.Line, nil, "included file %s was not found", inc.File)
+		}
+	}
+	return diagnostics
+}
+
+// LintFiles lints changelog files. Results for files whose content did not
+// change since the last run are read from the cache file, pass an empty
+// cache path to always lint.
+func LintFiles(paths []string, cachePath string) ([]Diagnostic, error) {
+	cache := lintCache{}
+	if cachePath != "" {
+		lintCacheMu.Lock()
+		defer lintCacheMu.Unlock()
+		if data, err := os.ReadFile(cachePath); err == nil {
+			json.Unmarshal(data, &cache)
+		}
+	}
+
+	var diagnostics []Diagnostic
+	for _, p := range paths {
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(append([]byte(p+"\x00"), content...))
+		key := hex.EncodeToString(sum[:])
+		if cached, ok := cache[key]; ok {
+			diagnostics = append(diagnostics, cached...)
+			continue
+		}
+
+		var found []Diagnostic
+		file, err := parseChangelogContent(p, filepath.ToSlash(p), content)
+		if err != nil {
+			found = []Diagnostic{parseDiagnostic(p, err)}
+		} else {
+			found = LintChangelog(file)
+		}
+		cache[key] = found
+		diagnostics = append(diagnostics, found...)
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(cache)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(cachePath, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return diagnostics, nil
+}
+
+// Turn a parse error into a diagnostic, located on its line when the parser reports one
+func parseDiagnostic(path string, err error) Diagnostic {
+	d := Diagnostic{Kind: DIAGNOSTIC_PARSE, Severity: SEVERITY_ERROR, File: path, Message: err.Error()}
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		d.Line, d.Message = syntaxErr.Line, syntaxErr.Msg
+	} else if m := errorLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		d.Line, _ = strconv.Atoi(m[1])
+	}
+	d.Message = strings.TrimPrefix(d.Message, path+": ")
+	return d
+}
+
+// ChangedChangelogs lists the changelogs changed in git: the staged ones, or
+// the ones changed since a ref when since is set. Deleted files are left out.
+func ChangedChangelogs(since string, staged bool) ([]string, error) {
+	args := []string{"diff", "--name-only", "--diff-filter=ACMR"}
+	switch {
+	case staged:
+		args = append(args, "--cached")
+	case since != "":
+		args = append(args, since+"...HEAD")
+	}
+	ctx := context.Background()
+	root, err := runGit(ctx, "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	out, err := runGit(ctx, root, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	var changelogs []string
+	for _, name := range strings.Split(out, "\n") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if IsChangelog(path) {
+			if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+			changelogs = append(changelogs, path)
+		}
+	}
+	return changelogs, nil
+}
+
+// Check if a list contains a string, ignoring case
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasErrors reports whether any diagnostic is an error rather than a warning
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity != SEVERITY_WARNING {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Run logs kept when no retention is configured
+const DEFAULT_LOG_MAX_FILES = 50
+
+// LogRetention limits the run logs kept in the log dir. Zero values don't limit.
+type LogRetention struct {
+	MaxFiles int           `yaml:"maxFiles"`
+	MaxAge   time.Duration `yaml:"maxAge"`
+}
+
+// runLog tapes the full output of one run to a file
+type runLog struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open the log file of a run and prune old ones. The file is named after the
+// run ID, so logs sort by time and can be matched with journal entries.
+func (pl *GoLiquibase) openRunLog(runID, command string, args []string) (*runLog, error) {
+	if err := os.MkdirAll(pl.LogDir, 0755); err != nil {
+		return nil, err
+	}
+	retention := pl.LogRetention
+	if retention.MaxFiles == 0 && retention.MaxAge == 0 {
+		retention.MaxFiles = DEFAULT_LOG_MAX_FILES
+	}
+	// Make room for the new log
+	if retention.MaxFiles > 0 {
+		retention.MaxFiles--
+	}
+	if err := PruneLogs(pl.LogDir, retention); err != nil {
+		pl.logger().Printf("Failed to prune run logs in %s: %v", pl.LogDir, err)
+	}
+
+	name := runID
+	if command != "" {
+		name += "-" + command
+	}
+	path := filepath.Join(pl.LogDir, name+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(file, "# %s liquibase %s\n", time.Now().Format(time.RFC3339), QuoteArgs(args))
+	return &runLog{path: path, file: file}, nil
+}
+
+// Write output to the log, stdout and stderr write concurrently
+func (l *runLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Write(p)
+}
+
+// Path of the log file, empty when the run isn't logged
+func (l *runLog) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Record how the run ended and close the file
+func (l *runLog) Close(status string, elapsed time.Duration, runErr error) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	footer := fmt.Sprintf("# %s %s after %s\n", time.Now().Format(time.RFC3339), status, elapsed.Round(time.Millisecond))
+	if runErr != nil {
+		footer = fmt.Sprintf("# %s %s after %s: %v\n", time.Now().Format(time.RFC3339), status, elapsed.Round(time.Millisecond), runErr)
+	}
+	l.file.WriteString(footer)
+	return l.file.Close()
+}
+
+// PruneLogs removes the run logs in a directory beyond the retention: logs
+// older than MaxAge, and the oldest logs beyond the MaxFiles newest
+func PruneLogs(dir string, retention LogRetention) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var logs []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, info)
+	}
+	// Newest first
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ModTime().After(logs[j].ModTime()) })
+
+	var errs []string
+	for i, info := range logs {
+		expired := retention.MaxAge > 0 && time.Since(info.ModTime()) > retention.MaxAge
+		if expired || (retention.MaxFiles > 0 && i >= retention.MaxFiles) {
+			if err := os.Remove(filepath.Join(dir, info.Name())); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
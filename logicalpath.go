@@ -0,0 +1,198 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const LINT_NO_LOGICAL_PATH = "no-logical-file-path"
+
+// The root element of an XML changelog and the changelog line of a YAML one
+var (
+	xmlChangelogRootPattern  = regexp.MustCompile(`<(\w+:)?databaseChangeLog\b`)
+	yamlChangelogRootPattern = regexp.MustCompile(`(?m)^databaseChangeLog:[ \t]*\r?\n`)
+	jsonChangelogRootPattern = regexp.MustCompile(`"databaseChangeLog"\s*:\s*\[`)
+)
+
+// LogicalPathStatus is how the changesets of a changelog file are identified
+type LogicalPathStatus struct {
+	// File is where the changelog is on disk
+	File string `json:"file"`
+	// Path is the path Liquibase finds the changelog by
+	Path string `json:"path"`
+	// LogicalFilePath of the file, empty when its changesets are recorded by Path
+	LogicalFilePath string `json:"logicalFilePath,omitempty"`
+	ChangeSets      int    `json:"changesets"`
+	// Unpinned is the number of changesets recorded by Path, whose
+	// coordinates change when the file moves
+	Unpinned int `json:"unpinned"`
+}
+
+// AuditLogicalFilePaths reports for every changelog file of a tree whether
+// its changesets keep their coordinates when the file is moved
+func AuditLogicalFilePaths(tree *ChangelogTree) []LogicalPathStatus {
+	var statuses []LogicalPathStatus
+	for _, f := range tree.Files {
+		s := LogicalPathStatus{File: f.DiskPath, Path: f.Path, LogicalFilePath: f.LogicalFilePath, ChangeSets: len(f.ChangeSets)}
+		for _, cs := range f.ChangeSets {
+			if !cs.Pinned {
+				s.Unpinned++
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// LintLogicalFilePath flags changelogs whose changesets are recorded by the
+// path of the file, so moving the file makes Liquibase run them again
+func LintLogicalFilePath(file *ChangelogFile) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, cs := range file.ChangeSets {
+		if !cs.Pinned {
+			diagnostics = append(diagnostics, Diagnostic{
+				Kind:      LINT_NO_LOGICAL_PATH,
+				Severity:  SEVERITY_ERROR,
+				File:      file.DiskPath,
+				Line:      cs.Line,
+				ChangeSet: cs.Key(),
+				Message:   fmt.Sprintf("changeset %s has no logicalFilePath, it is recorded by the path of the file and runs again if the file moves", changesetLabel(cs.Key())),
+			})
+		}
+	}
+	return diagnostics
+}
+
+// LintLogicalFilePaths checks that the changesets of changelog files have a
+// logicalFilePath
+func LintLogicalFilePaths(paths []string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, p := range paths {
+		file, err := ParseChangelogFile(p, filepath.ToSlash(p))
+		if err != nil {
+			// Parse errors are reported by the regular lint
+			continue
+		}
+		diagnostics = append(diagnostics, LintLogicalFilePath(file)...)
+	}
+	return diagnostics
+}
+
+// SetLogicalFilePath pins the changesets of a changelog file to a logical
+// path: on the root element of XML, YAML and JSON changelogs, and on every
+// changeset of formatted SQL ones, which have no root. Changelogs already
+// pinned are left alone. Returns whether the file was changed.
+func SetLogicalFilePath(file *ChangelogFile, logical string) (bool, error) {
+	if file.LogicalFilePath != "" {
+		return false, nil
+	}
+	content, err := os.ReadFile(file.DiskPath)
+	if err != nil {
+		return false, err
+	}
+	text := string(content)
+	switch strings.ToLower(filepath.Ext(file.DiskPath)) {
+	case ".xml":
+		loc := xmlChangelogRootPattern.FindStringIndex(text)
+		if loc == nil {
+			return false, fmt.Errorf("%s: no databaseChangeLog element", file.DiskPath)
+		}
+		text = text[:loc[1]] + fmt.Sprintf(` logicalFilePath="%s"`, xmlEscape(logical)) + text[loc[1]:]
+	case ".yaml", ".yml":
+		loc := yamlChangelogRootPattern.FindStringIndex(text)
+		if loc == nil {
+			return false, fmt.Errorf("%s: no databaseChangeLog list", file.DiskPath)
+		}
+		text = text[:loc[1]] + fmt.Sprintf("  - logicalFilePath: %q\n", logical) + text[loc[1]:]
+	case ".json":
+		loc := jsonChangelogRootPattern.FindStringIndex(text)
+		if loc == nil {
+			return false, fmt.Errorf("%s: no databaseChangeLog list", file.DiskPath)
+		}
+		text = text[:loc[1]] + fmt.Sprintf("\n    {\"logicalFilePath\": %q},", logical) + text[loc[1]:]
+	case ".sql":
+		changed := false
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			trimmed := strings.TrimRight(line, "\r")
+			if sqlChangesetPattern.MatchString(strings.TrimSpace(trimmed)) && !strings.Contains(trimmed, "logicalFilePath:") {
+				lines[i] = trimmed + " logicalFilePath:" + logical + line[len(trimmed):]
+				changed = true
+			}
+		}
+		if !changed {
+			return false, nil
+		}
+		text = strings.Join(lines, "\n")
+	default:
+		return false, fmt.Errorf("%s: unsupported changelog format", file.DiskPath)
+	}
+	info, err := os.Stat(file.DiskPath)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(file.DiskPath, []byte(text), info.Mode().Perm())
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+// MovedChangeSet is a deployed changeset whose file was moved: the history
+// records it under a path the changelog no longer has
+type MovedChangeSet struct {
+	ID     string `json:"id"`
+	Author string `json:"author"`
+	// From is the path the deployment history records
+	From string `json:"from"`
+	// To is the path the changelog records it by now
+	To string `json:"to"`
+	// File is where the changeset is on disk
+	File string `json:"file"`
+}
+
+// FindMovedChangeSets finds the changesets Liquibase would run again because
+// their file moved: pending changesets whose id and author were deployed
+// under exactly one other path, which no changeset of the tree has
+func FindMovedChangeSets(tree *ChangelogTree, applied []AppliedChangeSet) []MovedChangeSet {
+	var moved []MovedChangeSet
+	for _, cs := range PendingChangeSets(tree, applied) {
+		var from []string
+		for _, a := range applied {
+			if a.ID != cs.ID || a.Author != cs.Author || containsString(from, a.File) {
+				continue
+			}
+			if tree.Find(a.File+"::"+a.ID+"::"+a.Author) == nil {
+				from = append(from, a.File)
+			}
+		}
+		if len(from) != 1 {
+			continue
+		}
+		m := MovedChangeSet{ID: cs.ID, Author: cs.Author, From: from[0], To: cs.File, File: cs.File}
+		if f := tree.FindFile(cs.File); f != nil {
+			m.File = f.DiskPath
+		}
+		moved = append(moved, m)
+	}
+	return moved
+}
+
+// MovedChangeSetsSQL returns the statements recording moved changesets under
+// their new path, so Liquibase doesn't run them again
+func MovedChangeSetsSQL(moved []MovedChangeSet, liquibaseSchema string) []string {
+	qualifier := ""
+	if liquibaseSchema != "" {
+		qualifier = liquibaseSchema + "."
+	}
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	var statements []string
+	for _, m := range moved {
+		statements = append(statements, fmt.Sprintf("UPDATE %sDATABASECHANGELOG SET FILENAME = %s WHERE ID = %s AND AUTHOR = %s AND FILENAME = %s",
+			qualifier, quote(m.To), quote(m.ID), quote(m.Author), quote(m.From)))
+	}
+	return statements
+}
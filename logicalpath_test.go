@@ -0,0 +1,73 @@
+package goliquify
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSameChangelogPath(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		// Relative
+		{"db/changelog.xml", "db/changelog.xml", true},
+		{"./db/changelog.xml", "db/changelog.xml", true},
+		{"db/../db/changelog.xml", "db/changelog.xml", true},
+		{"changelog.xml", "db/changelog.xml", true},
+		{"db/changelog.xml", "db/changelog.yaml", false},
+		{"otherdb/changelog.xml", "db/changelog.xml", false},
+		// Absolute
+		{"/home/ci/app/db/changelog.xml", "db/changelog.xml", true},
+		{"/home/ci/app/db/changelog.xml", "/home/ci/app/db/changelog.xml", true},
+		{"/home/ci/app/legacy/changelog.xml", "db/changelog.xml", false},
+		// Windows separators
+		{`db\changelog.xml`, "db/changelog.xml", true},
+		{`C:\ci\app\db\changelog.xml`, "db/changelog.xml", true},
+		{`C:\ci\app\legacy\changelog.xml`, "db/changelog.xml", false},
+		// classpath:
+		{"classpath:db/changelog.xml", "db/changelog.xml", true},
+		{"classpath:/db/changelog.xml", "db/changelog.xml", true},
+		{`classpath:db\changelog.xml`, "/home/ci/app/db/changelog.xml", true},
+		{"classpath:legacy/changelog.xml", "db/changelog.xml", false},
+	} {
+		if same := sameChangelogPath(tc.a, tc.b); same != tc.same {
+			t.Errorf("sameChangelogPath(%q, %q) = %v, want %v", tc.a, tc.b, same, tc.same)
+		}
+		if same := sameChangelogPath(tc.b, tc.a); same != tc.same {
+			t.Errorf("sameChangelogPath(%q, %q) = %v, want %v", tc.b, tc.a, same, tc.same)
+		}
+	}
+}
+
+func TestMovedChangeSetsByRecordedPath(t *testing.T) {
+	chdir(t, t.TempDir())
+	if err := os.MkdirAll("db", 0755); err != nil {
+		t.Fatal(err)
+	}
+	changelog := `<?xml version="1.0" encoding="UTF-8"?>
+<databaseChangeLog xmlns="http://www.liquibase.org/xml/ns/dbchangelog" logicalFilePath="db/users.xml">
+    <changeSet id="1" author="bob">
+        <sql>CREATE TABLE users (id INT)</sql>
+    </changeSet>
+</databaseChangeLog>
+`
+	if err := os.WriteFile("db/changelog.xml", []byte(changelog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := LoadChangelogTree("db/changelog.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recorded by the logical path in another form, the changeset didn't move
+	for _, recorded := range []string{"db/users.xml", "classpath:db/users.xml", `db\users.xml`, "/home/ci/app/db/users.xml"} {
+		if moved := FindMovedChangeSets(tree, []AppliedChangeSet{{ID: "1", Author: "bob", File: recorded}}); len(moved) != 0 {
+			t.Errorf("deployed as %s, found moved %+v", recorded, moved)
+		}
+	}
+	moved := FindMovedChangeSets(tree, []AppliedChangeSet{{ID: "1", Author: "bob", File: "legacy/users.xml"}})
+	if len(moved) != 1 || moved[0].From != "legacy/users.xml" || moved[0].To != "db/users.xml" {
+		t.Fatalf("moved %+v, want legacy/users.xml to db/users.xml", moved)
+	}
+}
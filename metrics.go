@@ -0,0 +1,246 @@
+package goliquify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	METRICS_DOGSTATSD  = "dogstatsd"
+	METRICS_CLOUDWATCH = "cloudwatch"
+
+	DEFAULT_METRICS_NAMESPACE = "goliquify"
+	// Address of the Datadog agent, unless DD_AGENT_HOST and DD_DOGSTATSD_PORT say otherwise
+	DEFAULT_DOGSTATSD_ADDR = "127.0.0.1:8125"
+)
+
+// MetricsConfig reports run outcomes, run durations and drift to a
+// metrics backend
+type MetricsConfig struct {
+	// Provider is dogstatsd or cloudwatch
+	Provider string `yaml:"provider"`
+	// Namespace prefixes the DogStatsD metric names and is the CloudWatch
+	// namespace, goliquify when empty
+	Namespace string `yaml:"namespace"`
+	// Service tags every metric, e.g. the application owning the database
+	Service string `yaml:"service"`
+	// Tags added to every metric
+	Tags map[string]string `yaml:"tags"`
+	// Address of the DogStatsD agent, host:port or unix:///path/to/socket
+	Address string `yaml:"address"`
+	// Output receives the CloudWatch embedded metric format records: a
+	// file, or stdout or stderr for the agent collecting the logs, stderr
+	// when empty
+	Output string `yaml:"output"`
+}
+
+// Validate checks a metrics config
+func (c *MetricsConfig) Validate() error {
+	switch c.Provider {
+	case METRICS_DOGSTATSD, METRICS_CLOUDWATCH:
+		return nil
+	}
+	return fmt.Errorf("unknown metrics provider %q, expecting dogstatsd or cloudwatch", c.Provider)
+}
+
+// A metric of an event, with its DogStatsD type and CloudWatch unit
+type metric struct {
+	name  string
+	value float64
+	kind  string
+	unit  string
+}
+
+// The metrics of a finished run, or of a drift check. Started and
+// heartbeat events have none.
+func eventMetrics(event Event) []metric {
+	if event.Type != EVENT_COMPLETED && event.Type != EVENT_FAILED {
+		return nil
+	}
+	failed := 0.0
+	if event.Type == EVENT_FAILED {
+		failed = 1
+	}
+	if event.Command == OPERATION_DRIFT {
+		return []metric{
+			{"drift.checks", 1, "c", "Count"},
+			{"drift.differences", float64(event.Differences), "g", "Count"},
+		}
+	}
+	return []metric{
+		{"runs", 1, "c", "Count"},
+		{"runs.failed", failed, "c", "Count"},
+		{"run.duration", float64(event.ElapsedMs), "d", "Milliseconds"},
+	}
+}
+
+// The tags of an event's metrics
+func (c *MetricsConfig) eventTags(event Event) map[string]string {
+	tags := map[string]string{}
+	for k, v := range c.Tags {
+		tags[k] = v
+	}
+	tags["env"] = firstNonEmpty(event.Environment, "default")
+	tags["command"] = event.Command
+	tags["status"] = event.Type
+	if c.Service != "" {
+		tags["service"] = c.Service
+	}
+	if event.Schedule != "" {
+		tags["schedule"] = event.Schedule
+	}
+	return tags
+}
+
+// NewMetricsSink creates the event sink reporting the metrics of a config
+func NewMetricsSink(cfg *MetricsConfig) (EventSink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Provider == METRICS_DOGSTATSD {
+		address := cfg.Address
+		if address == "" {
+			address = DEFAULT_DOGSTATSD_ADDR
+			if host := os.Getenv("DD_AGENT_HOST"); host != "" {
+				address = net.JoinHostPort(host, firstNonEmpty(os.Getenv("DD_DOGSTATSD_PORT"), "8125"))
+			}
+		}
+		return &DogStatsDSink{Config: cfg, Address: address}, nil
+	}
+	return &CloudWatchSink{Config: cfg}, nil
+}
+
+// DogStatsDSink sends the metrics of events to a Datadog agent
+type DogStatsDSink struct {
+	Config  *MetricsConfig
+	Address string
+}
+
+// Send sends the metrics of an event as one DogStatsD datagram
+func (s *DogStatsDSink) Send(event Event) error {
+	metrics := eventMetrics(event)
+	if len(metrics) == 0 {
+		return nil
+	}
+	tags := s.Config.eventTags(event)
+	var encoded []string
+	for _, k := range sortedKeys(tags) {
+		encoded = append(encoded, statsdTag(k)+":"+statsdTag(tags[k]))
+	}
+	namespace := firstNonEmpty(s.Config.Namespace, DEFAULT_METRICS_NAMESPACE)
+	var lines []string
+	for _, m := range metrics {
+		lines = append(lines, fmt.Sprintf("%s.%s:%g|%s|#%s", namespace, m.name, m.value, m.kind, strings.Join(encoded, ",")))
+	}
+
+	network, address := "udp", s.Address
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, address = "unixgram", path
+	}
+	conn, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("dogstatsd: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		return fmt.Errorf("dogstatsd: %v", err)
+	}
+	return nil
+}
+
+// DogStatsD separates tags with commas and metrics with pipes
+func statsdTag(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", " ").Replace(s)
+}
+
+// CloudWatchSink writes the metrics of events in the CloudWatch embedded
+// metric format, for the CloudWatch agent or Lambda to extract them from
+// the logs
+type CloudWatchSink struct {
+	Config *MetricsConfig
+	// Writer receives the records instead of the Output of the config
+	Writer io.Writer
+	mu     sync.Mutex
+}
+
+// CloudWatch metric names of the metrics
+var cloudWatchNames = map[string]string{
+	"runs":              "Runs",
+	"runs.failed":       "FailedRuns",
+	"run.duration":      "RunDuration",
+	"drift.checks":      "DriftChecks",
+	"drift.differences": "DriftDifferences",
+}
+
+// Send writes the metrics of an event as one embedded metric format record
+func (s *CloudWatchSink) Send(event Event) error {
+	metrics := eventMetrics(event)
+	if len(metrics) == 0 {
+		return nil
+	}
+	record := map[string]any{}
+	// The dimensions are capitalized, the other tags are plain properties
+	for k, v := range s.Config.eventTags(event) {
+		record[k] = v
+	}
+	dimensions := []string{"Environment", "Command"}
+	record["Environment"], record["Command"] = record["env"], event.Command
+	delete(record, "env")
+	delete(record, "command")
+	if s.Config.Service != "" {
+		dimensions = append(dimensions, "Service")
+		record["Service"] = s.Config.Service
+		delete(record, "service")
+	}
+	var definitions []map[string]string
+	for _, m := range metrics {
+		name := cloudWatchNames[m.name]
+		definitions = append(definitions, map[string]string{"Name": name, "Unit": m.unit})
+		record[name] = m.value
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i]["Name"] < definitions[j]["Name"] })
+	if event.RunID != "" {
+		record["runId"] = event.RunID
+	}
+	record["_aws"] = map[string]any{
+		"Timestamp": event.Time.UnixMilli(),
+		"CloudWatchMetrics": []map[string]any{{
+			"Namespace":  firstNonEmpty(s.Config.Namespace, DEFAULT_METRICS_NAMESPACE),
+			"Dimensions": [][]string{dimensions},
+			"Metrics":    definitions,
+		}},
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Writer != nil {
+		_, err = s.Writer.Write(data)
+		return err
+	}
+	switch s.Config.Output {
+	case "", "stderr":
+		_, err = os.Stderr.Write(data)
+	case "stdout":
+		_, err = os.Stdout.Write(data)
+	default:
+		var file *os.File
+		if file, err = os.OpenFile(s.Config.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return fmt.Errorf("cloudwatch metrics: %v", err)
+		}
+		defer file.Close()
+		_, err = file.Write(data)
+	}
+	return err
+}
@@ -0,0 +1,393 @@
+package goliquify
+
+import (
+	"log"
+	"time"
+)
+
+// Option configures a GoLiquibase instance
+type Option func(*GoLiquibase)
+
+// New creates a GoLiquibase instance configured by the options
+func New(opts ...Option) *GoLiquibase {
+	pl := &GoLiquibase{
+		Version:             DEFAULT_LIQUIBASE_VERSION,
+		ChangelogProperties: map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(pl)
+	}
+	return pl
+}
+
+// WithDefaultsFile sets the liquibase.properties file
+func WithDefaultsFile(path string) Option {
+	return func(pl *GoLiquibase) { pl.DefaultsFile = path }
+}
+
+// WithHubMode sets the Liquibase Hub mode
+func WithHubMode(mode string) Option {
+	return func(pl *GoLiquibase) { pl.LiquibaseHubMode = mode }
+}
+
+// WithLogLevel sets the Liquibase log level
+func WithLogLevel(level string) Option {
+	return func(pl *GoLiquibase) { pl.LogLevel = level }
+}
+
+// WithVersion sets the Liquibase version to install
+func WithVersion(version string) Option {
+	return func(pl *GoLiquibase) { pl.Version = version }
+}
+
+// WithLiquibaseDir uses an existing Liquibase installation instead of downloading one
+func WithLiquibaseDir(dir string) Option {
+	return func(pl *GoLiquibase) { pl.setLiquibaseDir(dir) }
+}
+
+// WithLiquibaseArtifact installs a Liquibase build instead of a release, e.g.
+// a nightly or a local build: the url of a zip or tar.gz, a local one, or a
+// directory of an extracted build. version names the build, when empty it is
+// read from the artifact name, e.g. liquibase-4.33.0-SNAPSHOT.zip.
+func WithLiquibaseArtifact(location, version string) Option {
+	return func(pl *GoLiquibase) {
+		pl.LiquibaseArtifact = location
+		pl.Version = version
+	}
+}
+
+// WithCacheDir sets the directory downloaded Liquibase versions are cached in
+func WithCacheDir(dir string) Option {
+	return func(pl *GoLiquibase) { pl.CacheDir = dir }
+}
+
+// WithCacheRetention limits the toolchains kept in the cache dir. With
+// Auto set the cache is pruned in the background after installs.
+func WithCacheRetention(retention *CacheRetention) Option {
+	return func(pl *GoLiquibase) { pl.CacheRetention = retention }
+}
+
+// WithExtensionMatrix picks extension versions from the compatibility
+// matrix in a file or at an http(s) url instead of the embedded one
+func WithExtensionMatrix(location string) Option {
+	return func(pl *GoLiquibase) { pl.ExtensionMatrix = location }
+}
+
+// WithJDBCDriversDir sets the directory holding JDBC driver jars
+func WithJDBCDriversDir(dir string) Option {
+	return func(pl *GoLiquibase) { pl.JdbcDriversDir = dir }
+}
+
+// WithAdditionalClasspath adds java libraries and Liquibase extensions to the classpath.
+// Entries are separated by the OS path list separator or commas and may be globs or directories.
+func WithAdditionalClasspath(classpath string) Option {
+	return func(pl *GoLiquibase) { pl.AdditionalClasspath = classpath }
+}
+
+// WithChangelogProperty sets a changelog property
+func WithChangelogProperty(key, val string) Option {
+	return func(pl *GoLiquibase) { pl.SetChangelogProperty(key, val) }
+}
+
+// WithChangelogProperties sets several changelog properties
+func WithChangelogProperties(props map[string]string) Option {
+	return func(pl *GoLiquibase) {
+		for key, val := range props {
+			pl.SetChangelogProperty(key, val)
+		}
+	}
+}
+
+// WithSessionSettings sets session parameters of every connection, e.g.
+// lock_timeout and statement_timeout. Later settings override earlier ones.
+func WithSessionSettings(settings map[string]string) Option {
+	return func(pl *GoLiquibase) {
+		for name, value := range settings {
+			if pl.SessionSettings == nil {
+				pl.SessionSettings = map[string]string{}
+			}
+			pl.SessionSettings[name] = value
+		}
+	}
+}
+
+// WithArgs adds global arguments passed to every command
+func WithArgs(args ...string) Option {
+	return func(pl *GoLiquibase) { pl.Args = append(pl.Args, args...) }
+}
+
+// WithTemplateDir renders the changelogs in dir through Go templates before every command
+func WithTemplateDir(dir string, values map[string]any) Option {
+	return func(pl *GoLiquibase) {
+		pl.TemplateDir = dir
+		pl.TemplateValues = values
+	}
+}
+
+// WithChangeSetFilter leaves changesets out of every run, or runs only the
+// given ones, as id::author::path
+func WithChangeSetFilter(skip, only []string) Option {
+	return func(pl *GoLiquibase) { pl.ChangeSetFilter = ChangeSetFilter{Skip: skip, Only: only} }
+}
+
+// WithTimingReport writes changeset timings to path, flagging those above the threshold
+func WithTimingReport(path string, threshold time.Duration) Option {
+	return func(pl *GoLiquibase) {
+		pl.TimingReport = path
+		pl.TimingThreshold = threshold
+	}
+}
+
+// WithHeartbeat emits a heartbeat event at every interval while a command runs
+func WithHeartbeat(interval time.Duration) Option {
+	return func(pl *GoLiquibase) { pl.HeartbeatInterval = interval }
+}
+
+// WithEventSink sends run events to a sink
+func WithEventSink(sink EventSink) Option {
+	return func(pl *GoLiquibase) { pl.EventSinks = append(pl.EventSinks, sink) }
+}
+
+// WithEnvironment sets the environment name recorded for runs
+func WithEnvironment(name string) Option {
+	return func(pl *GoLiquibase) { pl.Environment = name }
+}
+
+// WithWindowPolicy restricts commands to maintenance windows. A non-empty
+// override reason lets them run outside the windows.
+func WithWindowPolicy(policy *WindowPolicy, overrideReason string) Option {
+	return func(pl *GoLiquibase) {
+		pl.WindowPolicy = policy
+		pl.WindowOverride = overrideReason
+	}
+}
+
+// WithApproval makes the environment protected, refusing to change the
+// database without an approval of the plan checked with method, see CheckApproval
+func WithApproval(protected bool, method string) Option {
+	return func(pl *GoLiquibase) {
+		pl.Protected = protected
+		pl.Approval = method
+	}
+}
+
+// WithJournal records every run in a journal file
+func WithJournal(path string) Option {
+	return func(pl *GoLiquibase) { pl.JournalFile = path }
+}
+
+// WithLogDir writes the full output of every run to a file in dir, keeping
+// the logs within the retention
+func WithLogDir(dir string, retention LogRetention) Option {
+	return func(pl *GoLiquibase) {
+		pl.LogDir = dir
+		pl.LogRetention = retention
+	}
+}
+
+// WithManagedJRE runs Liquibase with a Temurin JRE of the given feature
+// version, e.g. 17, downloaded for this OS, architecture and libc and cached
+// next to Liquibase. 0 uses the Java on the system.
+func WithManagedJRE(javaVersion int) Option {
+	return func(pl *GoLiquibase) { pl.JavaVersion = javaVersion }
+}
+
+// WithOperationReports writes a Liquibase operation report for the commands
+// that support one, on Liquibase 4.26 and later. They replace Liquibase Hub.
+func WithOperationReports(reports *OperationReports) Option {
+	return func(pl *GoLiquibase) { pl.OperationReports = reports }
+}
+
+// WithAttestations writes a signed in-toto attestation of every run of the
+// attested commands, for tamper-evident change records
+func WithAttestations(attestations *AttestationConfig) Option {
+	return func(pl *GoLiquibase) { pl.Attestations = attestations }
+}
+
+// WithDrivers installs curated JDBC driver bundles with Liquibase, e.g. mssql
+// or oracle. Bundles whose license must be accepted are only installed when
+// listed in acceptedLicenses.
+func WithDrivers(drivers []string, acceptedLicenses []string) Option {
+	return func(pl *GoLiquibase) {
+		pl.Drivers = drivers
+		pl.AcceptedLicenses = acceptedLicenses
+	}
+}
+
+// WithCommandPolicy restricts the commands that may run, for the given role.
+// The role is trusted as given, see CommandPolicy.
+func WithCommandPolicy(policy *CommandPolicy, role string) Option {
+	return func(pl *GoLiquibase) {
+		pl.CommandPolicy = policy
+		pl.Role = role
+	}
+}
+
+// WithTunnel reaches the database through an SSH bastion
+func WithTunnel(tunnel *TunnelConfig) Option {
+	return func(pl *GoLiquibase) { pl.Tunnel = tunnel }
+}
+
+// WithTLS connects with an SSL mode, a server CA and a client certificate
+func WithTLS(tls *TLSConfig) Option {
+	return func(pl *GoLiquibase) { pl.TLS = tls }
+}
+
+// WithKerberos logs in to SQL Server or Oracle with Kerberos
+func WithKerberos(kerberos *KerberosConfig) Option {
+	return func(pl *GoLiquibase) { pl.Kerberos = kerberos }
+}
+
+// WithEntra logs in to Azure SQL with a Microsoft Entra ID access token
+func WithEntra(entra *EntraConfig) Option {
+	return func(pl *GoLiquibase) { pl.Entra = entra }
+}
+
+// WithDSQL connects to an Aurora DSQL cluster with IAM authentication tokens
+func WithDSQL(dsql *DSQLConfig) Option {
+	return func(pl *GoLiquibase) { pl.DSQL = dsql }
+}
+
+// WithSpanner connects to a Cloud Spanner database through its Liquibase
+// extension
+func WithSpanner(spanner *SpannerConfig) Option {
+	return func(pl *GoLiquibase) { pl.Spanner = spanner }
+}
+
+// WithReadOnlyCredentials runs the commands only reading the database with
+// separate credentials
+func WithReadOnlyCredentials(credentials *ReadOnlyCredentials) Option {
+	return func(pl *GoLiquibase) { pl.ReadOnly = credentials }
+}
+
+// WithSecrets decrypts encrypted property files and changelogs for every run
+func WithSecrets(secrets SecretsConfig) Option {
+	return func(pl *GoLiquibase) { pl.Secrets = secrets }
+}
+
+// WithDecryptor sets how encrypted files are decrypted, with sops by default
+func WithDecryptor(decryptor Decryptor) Option {
+	return func(pl *GoLiquibase) { pl.Decryptor = decryptor }
+}
+
+// WithStrict fails runs on unreachable changelog includes, a missing JDBC
+// driver and deprecated flags before Liquibase starts
+func WithStrict(strict bool) Option {
+	return func(pl *GoLiquibase) { pl.Strict = strict }
+}
+
+// WithStackTraces prints Java stack traces in full instead of collapsing
+// their frames
+func WithStackTraces(full bool) Option {
+	return func(pl *GoLiquibase) { pl.StackTraces = full }
+}
+
+// WithKnowledgeBase looks up failures the bundled patterns don't explain
+// in an online knowledge base
+func WithKnowledgeBase(kb *KnowledgeBaseConfig) Option {
+	return func(pl *GoLiquibase) { pl.KnowledgeBase = kb }
+}
+
+// WithEncoding sets the encodings Liquibase reads and writes files with,
+// and the locale of the JVM
+func WithEncoding(encoding EncodingOptions) Option {
+	return func(pl *GoLiquibase) { pl.Encoding = encoding }
+}
+
+// WithDryRun runs the SQL variant of mutating commands
+func WithDryRun(dryRun bool) Option {
+	return func(pl *GoLiquibase) { pl.DryRun = dryRun }
+}
+
+// WithSafeRewrite rewrites the SQL that update-sql and its variants generate
+// for Postgres into forms holding locks briefly, for review before running
+func WithSafeRewrite(opts *SafeRewriteOptions) Option {
+	return func(pl *GoLiquibase) { pl.SafeRewrite = opts }
+}
+
+// WithBackup backs up the database before the backed up commands, recording
+// the backup in the run journal
+func WithBackup(backup *BackupConfig) Option {
+	return func(pl *GoLiquibase) { pl.Backup = backup }
+}
+
+// WithChangeTickets records the runs in change tickets, key being the
+// ticket of the runs when one was opened beforehand
+func WithChangeTickets(cfg *ChangeTicketConfig, key string) Option {
+	return func(pl *GoLiquibase) { pl.ChangeTickets, pl.ChangeTicket = cfg, key }
+}
+
+// WithSchemaRegistry publishes the schema after successful updates
+func WithSchemaRegistry(registry *SchemaRegistryConfig) Option {
+	return func(pl *GoLiquibase) { pl.SchemaRegistry = registry }
+}
+
+// WithDefaultSchema sets the schema unqualified objects are created in
+func WithDefaultSchema(schema string) Option {
+	return func(pl *GoLiquibase) { pl.DefaultSchemaName = schema }
+}
+
+// WithLiquibaseSchema sets the schema and catalog of the Liquibase tracking tables
+func WithLiquibaseSchema(schema, catalog string) Option {
+	return func(pl *GoLiquibase) {
+		pl.LiquibaseSchemaName = schema
+		pl.LiquibaseCatalogName = catalog
+	}
+}
+
+// WithSchemas sets the schemas ForEachSchema runs across
+func WithSchemas(schemas ...string) Option {
+	return func(pl *GoLiquibase) { pl.Schemas = schemas }
+}
+
+// WithCIMetadata records CI metadata with every run
+func WithCIMetadata(ci *CIMetadata) Option {
+	return func(pl *GoLiquibase) { pl.CI = ci }
+}
+
+// WithLocation sets the time zone of the database, local time by default
+func WithLocation(loc *time.Location) Option {
+	return func(pl *GoLiquibase) { pl.Location = loc }
+}
+
+// WithSandbox runs every command in a private working directory with a
+// scratch copy of the Liquibase install, so parallel commands can't interfere
+func WithSandbox(sandbox bool) Option {
+	return func(pl *GoLiquibase) { pl.Sandbox = sandbox }
+}
+
+// WithAnalyticsDisabled turns off Liquibase analytics and Hub traffic for every command
+func WithAnalyticsDisabled(disabled bool) Option {
+	return func(pl *GoLiquibase) { pl.DisableAnalytics = disabled }
+}
+
+// WithLogger sets the logger, the standard logger by default
+func WithLogger(logger *log.Logger) Option {
+	return func(pl *GoLiquibase) { pl.Logger = logger }
+}
+
+// WithMiddleware adds middleware intercepting every command
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(pl *GoLiquibase) { pl.Middleware = append(pl.Middleware, middleware...) }
+}
+
+// WithRunner sets how Liquibase commands are run, as a child process by default
+func WithRunner(runner Runner) Option {
+	return func(pl *GoLiquibase) { pl.Runner = runner }
+}
+
+// Return the logger
+func (pl *GoLiquibase) logger() *log.Logger {
+	if pl.Logger != nil {
+		return pl.Logger
+	}
+	return log.Default()
+}
+
+// Return the runner
+func (pl *GoLiquibase) runner() Runner {
+	if pl.Runner != nil {
+		return pl.Runner
+	}
+	return ExecRunner{}
+}
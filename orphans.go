@@ -0,0 +1,91 @@
+package goliquify
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Directories never holding changelogs of the project
+var ORPHAN_SKIP_DIRS = []string{"node_modules", "target", "build", "vendor"}
+
+// OrphanChangelog is a changelog file with changesets that the root
+// changelog never includes, so Liquibase never runs them
+type OrphanChangelog struct {
+	File       string `json:"file"`
+	ChangeSets int    `json:"changesets"`
+	// Line of the first changeset
+	Line int `json:"line"`
+}
+
+// Orphans walks directories for changelog files with changesets that aren't
+// part of the tree. Without directories the directory of the root changelog
+// is walked. Hidden directories and build output are skipped, as are files
+// that don't parse as changelogs, e.g. pom.xml or plain SQL.
+func (t *ChangelogTree) Orphans(dirs ...string) ([]OrphanChangelog, error) {
+	if len(t.Files) == 0 {
+		return nil, nil
+	}
+	if len(dirs) == 0 {
+		dirs = []string{filepath.Dir(t.Files[0].DiskPath)}
+	}
+	reachable := map[string]bool{}
+	for _, f := range t.Files {
+		if abs, err := filepath.Abs(f.DiskPath); err == nil {
+			reachable[abs] = true
+		}
+	}
+
+	var orphans []OrphanChangelog
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != dir && (strings.HasPrefix(d.Name(), ".") || containsString(ORPHAN_SKIP_DIRS, d.Name())) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !isChangelogFile(d.Name()) {
+				return nil
+			}
+			abs, err := filepath.Abs(p)
+			if err != nil || reachable[abs] || seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+			file, err := ParseChangelogFile(p, filepath.ToSlash(p))
+			if err != nil || len(file.ChangeSets) == 0 {
+				return nil
+			}
+			orphans = append(orphans, OrphanChangelog{File: p, ChangeSets: len(file.ChangeSets), Line: file.ChangeSets[0].Line})
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to look for orphan changelogs: %v", err)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].File < orphans[j].File })
+	return orphans, nil
+}
+
+// OrphanDiagnostics turns orphan changelogs into warnings
+func OrphanDiagnostics(orphans []OrphanChangelog) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, o := range orphans {
+		diagnostics = append(diagnostics, Diagnostic{
+			Kind:     DIAGNOSTIC_ORPHAN,
+			Severity: SEVERITY_WARNING,
+			File:     o.File,
+			Line:     o.Line,
+			Message:  fmt.Sprintf("changelog isn't included by the root changelog, its %d changeset(s) never run", o.ChangeSets),
+		})
+	}
+	return diagnostics
+}
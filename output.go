@@ -0,0 +1,45 @@
+package goliquify
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// lineWriter is an io.Writer calling a function for every complete line written to it
+type lineWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	observe func(line string)
+}
+
+func newLineWriter(observe func(line string)) *lineWriter {
+	return &lineWriter{observe: observe}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.observe(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// Flush passes on a trailing line without a newline
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() > 0 {
+		w.observe(strings.TrimRight(w.buf.String(), "\r\n"))
+		w.buf.Reset()
+	}
+}
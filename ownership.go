@@ -0,0 +1,254 @@
+package goliquify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const DEFAULT_OWNERS_FILE = "CHANGELOG_OWNERS"
+
+const (
+	LINT_UNOWNED      = "unowned"
+	LINT_OWNER_AUTHOR = "owner-author"
+	LINT_OWNER_LABELS = "owner-labels"
+	LINT_OWNER_TICKET = "owner-ticket"
+)
+
+// TeamConventions are the rules changesets owned by a team must follow
+type TeamConventions struct {
+	// Authors are globs the changeset author must match, e.g. payments-*
+	Authors []string `yaml:"authors"`
+	// Labels every changeset must carry
+	Labels []string `yaml:"labels"`
+	// TicketPattern is a regular expression the id, comment or labels must match, e.g. PAY-\d+
+	TicketPattern string `yaml:"ticketPattern"`
+}
+
+// Override returns the conventions with the fields set in other replacing these
+func (c TeamConventions) Override(other *TeamConventions) TeamConventions {
+	if other == nil {
+		return c
+	}
+	if len(other.Authors) > 0 {
+		c.Authors = other.Authors
+	}
+	if len(other.Labels) > 0 {
+		c.Labels = other.Labels
+	}
+	if other.TicketPattern != "" {
+		c.TicketPattern = other.TicketPattern
+	}
+	return c
+}
+
+// OwnershipConfig maps changelogs to owning teams and their conventions
+type OwnershipConfig struct {
+	// OwnersFile is a CODEOWNERS style file of path patterns and owning teams
+	OwnersFile string `yaml:"ownersFile"`
+	// RequireOwner warns about changelogs no team owns
+	RequireOwner bool `yaml:"requireOwner"`
+	// Defaults apply to every team, Teams override them per team
+	Defaults TeamConventions             `yaml:"defaults"`
+	Teams    map[string]*TeamConventions `yaml:"teams"`
+}
+
+// An owners file line: the last matching line decides the owners, like CODEOWNERS
+type ownersRule struct {
+	pattern *regexp.Regexp
+	owners  []string
+}
+
+// Ownership checks changesets against the conventions of the teams owning their files
+type Ownership struct {
+	Config *OwnershipConfig
+	// Root is the directory owners file patterns are relative to
+	Root  string
+	rules []ownersRule
+}
+
+// LoadOwnership reads the owners file of the config. Patterns are relative to
+// the repository root, or the working directory outside git.
+func LoadOwnership(cfg *OwnershipConfig) (*Ownership, error) {
+	ownersFile := cfg.OwnersFile
+	if ownersFile == "" {
+		ownersFile = DEFAULT_OWNERS_FILE
+	}
+	root, err := runGit(context.Background(), "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		root = "."
+	}
+	o := &Ownership{Config: cfg, Root: root}
+
+	file, err := os.Open(ownersFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read owners file: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		pattern, err := ownersPattern(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %s: %v", ownersFile, line, fields[0], err)
+		}
+		// A pattern without owners unsets the owners of an earlier match
+		o.rules = append(o.rules, ownersRule{pattern: pattern, owners: fields[1:]})
+	}
+	return o, scanner.Err()
+}
+
+// Compile a CODEOWNERS pattern. Patterns with a slash are anchored at the
+// root, others match a name in any directory, and a match on a directory
+// covers everything below it.
+func ownersPattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	p := strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(p, "/") {
+		p += "**"
+	}
+	var re strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case p[i] == '*':
+			re.WriteString("[^/]*")
+		case p[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	if anchored {
+		return regexp.Compile("^" + re.String() + "(/.*)?$")
+	}
+	return regexp.Compile("(^|/)" + re.String() + "(/.*)?$")
+}
+
+// Owners returns the teams owning a file
+func (o *Ownership) Owners(diskPath string) []string {
+	rel := diskPath
+	if abs, err := filepath.Abs(diskPath); err == nil {
+		if root, err := filepath.Abs(o.Root); err == nil {
+			if r, err := filepath.Rel(root, abs); err == nil {
+				rel = r
+			}
+		}
+	}
+	rel = filepath.ToSlash(rel)
+	var owners []string
+	for _, rule := range o.rules {
+		if rule.pattern.MatchString(rel) {
+			owners = rule.owners
+		}
+	}
+	return owners
+}
+
+// Conventions returns the rules for a team, its overrides on top of the defaults
+func (o *Ownership) Conventions(team string) TeamConventions {
+	return o.Config.Defaults.Override(o.Config.Teams[team])
+}
+
+// Lint checks the changesets of a parsed changelog against the conventions of
+// its owning teams. With several owners a changeset passes if it follows the
+// conventions of any of them.
+func (o *Ownership) Lint(file *ChangelogFile) []Diagnostic {
+	owners := o.Owners(file.DiskPath)
+	if len(owners) == 0 {
+		if o.Config.RequireOwner {
+			return []Diagnostic{{Kind: LINT_UNOWNED, Severity: SEVERITY_WARNING, File: file.DiskPath, Line: 1, Message: "no team owns this changelog"}}
+		}
+		return nil
+	}
+
+	var diagnostics []Diagnostic
+	for _, cs := range file.ChangeSets {
+		var problems []Diagnostic
+		for _, team := range owners {
+			found := o.checkChangeSet(file, cs, team)
+			if len(found) == 0 {
+				problems = nil
+				break
+			}
+			// Report against the first owner, the primary one
+			if problems == nil {
+				problems = found
+			}
+		}
+		diagnostics = append(diagnostics, problems...)
+	}
+	return diagnostics
+}
+
+// Check one changeset against the conventions of a team
+func (o *Ownership) checkChangeSet(file *ChangelogFile, cs *ChangeSet, team string) []Diagnostic {
+	conventions := o.Conventions(team)
+	var problems []Diagnostic
+	add := func(kind, format string, args ...any) {
+		problems = append(problems, Diagnostic{Kind: kind, Severity: SEVERITY_ERROR, File: file.DiskPath, Line: cs.Line, ChangeSet: cs.Key(), Message: fmt.Sprintf(format, args...)})
+	}
+	label := changesetLabel(cs.Key())
+
+	if len(conventions.Authors) > 0 && !matchesAny(conventions.Authors, cs.Author) {
+		add(LINT_OWNER_AUTHOR, "changeset %s has author %q, %s expects one of %s", label, cs.Author, team, strings.Join(conventions.Authors, ", "))
+	}
+	labels := map[string]bool{}
+	for _, l := range strings.Split(cs.Labels, ",") {
+		labels[strings.ToLower(strings.TrimSpace(l))] = true
+	}
+	for _, required := range conventions.Labels {
+		if !labels[strings.ToLower(required)] {
+			add(LINT_OWNER_LABELS, "changeset %s is missing the label %s required by %s", label, required, team)
+		}
+	}
+	if conventions.TicketPattern != "" {
+		ticket, err := regexp.Compile(conventions.TicketPattern)
+		if err != nil {
+			add(LINT_OWNER_TICKET, "invalid ticket pattern %s for %s: %v", conventions.TicketPattern, team, err)
+		} else if !ticket.MatchString(cs.ID) && !ticket.MatchString(cs.Comment) && !ticket.MatchString(cs.Labels) {
+			add(LINT_OWNER_TICKET, "changeset %s has no ticket reference matching %s in its id, comment or labels", label, conventions.TicketPattern)
+		}
+	}
+	return problems
+}
+
+// Check if a value matches one of the globs, ignoring case
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(strings.ToLower(p), strings.ToLower(value)); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LintFiles checks the changesets of changelog files against the ownership conventions
+func (o *Ownership) LintFiles(paths []string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, p := range paths {
+		file, err := ParseChangelogFile(p, filepath.ToSlash(p))
+		if err != nil {
+			// Parse errors are reported by the regular lint
+			continue
+		}
+		diagnostics = append(diagnostics, o.Lint(file)...)
+	}
+	return diagnostics
+}
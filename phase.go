@@ -0,0 +1,72 @@
+package goliquify
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	PHASE_EXPAND   = "expand"
+	PHASE_MIGRATE  = "migrate"
+	PHASE_CONTRACT = "contract"
+)
+
+// Phases of an expand/contract rollout, in the order they are applied
+var PHASES = []string{PHASE_EXPAND, PHASE_MIGRATE, PHASE_CONTRACT}
+
+// PhaseConfig maps rollout phases to changeset labels
+type PhaseConfig struct {
+	// Labels overrides the label used for a phase, the phase name is used by default
+	Labels map[string]string `yaml:"labels"`
+	// ContractRequiresTag is the tag marking the app version that no longer needs the old schema
+	ContractRequiresTag string `yaml:"contractRequiresTag"`
+}
+
+// Return the changeset label for a phase
+func (c PhaseConfig) Label(phase string) string {
+	if label, ok := c.Labels[phase]; ok && label != "" {
+		return label
+	}
+	return phase
+}
+
+// Build the label filter argument for a phase
+func PhaseArgs(phases PhaseConfig, phase string) ([]string, error) {
+	for _, p := range PHASES {
+		if p == phase {
+			return []string{fmt.Sprintf("--label-filter=%s", phases.Label(phase))}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown phase %q, expecting one of %s", phase, strings.Join(PHASES, ", "))
+}
+
+// Refuse to contract the schema before the app version that stopped using it is tagged.
+// Contract changesets drop what older app versions still read and write, so running
+// them while the old version can still be serving traffic breaks it.
+func (pl *GoLiquibase) CheckContractAllowed(appVersionTag string) error {
+	if appVersionTag == "" {
+		return fmt.Errorf("the contract phase requires an app version tag, set --app-version-tag or phases.contractRequiresTag")
+	}
+	exists, err := pl.TagExists(appVersionTag)
+	if err != nil {
+		return fmt.Errorf("failed to check app version tag %s: %v", appVersionTag, err)
+	}
+	if !exists {
+		return fmt.Errorf("contract changesets cannot be applied before app version tag %s is deployed", appVersionTag)
+	}
+	return nil
+}
+
+// Update the changesets of one rollout phase
+func (pl *GoLiquibase) UpdatePhase(phases PhaseConfig, phase, appVersionTag string) error {
+	args, err := PhaseArgs(phases, phase)
+	if err != nil {
+		return err
+	}
+	if phase == PHASE_CONTRACT {
+		if err := pl.CheckContractAllowed(appVersionTag); err != nil {
+			return err
+		}
+	}
+	return pl.Execute(append([]string{"update"}, args...)...)
+}
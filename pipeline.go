@@ -0,0 +1,279 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pipeline step kinds
+const (
+	STEP_LINT     = "lint"
+	STEP_VALIDATE = "validate"
+	STEP_PLAN     = "plan"
+	STEP_APPLY    = "apply"
+	STEP_VERIFY   = "verify"
+	STEP_TAG      = "tag"
+	STEP_COMMAND  = "command"
+	STEP_SHELL    = "shell"
+)
+
+// What a pipeline does when a step fails
+const (
+	ON_FAILURE_ABORT    = "abort"
+	ON_FAILURE_CONTINUE = "continue"
+)
+
+const (
+	STEP_SUCCEEDED = "succeeded"
+	STEP_FAILED    = "failed"
+	STEP_SKIPPED   = "skipped"
+)
+
+const DEFAULT_PLAN_FILE = "plan.sql"
+
+// The steps run when none are configured
+var DEFAULT_PIPELINE = []PipelineStep{
+	{Run: STEP_VALIDATE},
+	{Run: STEP_PLAN},
+	{Run: STEP_APPLY},
+	{Run: STEP_VERIFY},
+}
+
+// Liquibase status output for undeployed changesets
+var pendingPattern = regexp.MustCompile(`(\d+) change ?sets? ha(?:s|ve) not been applied`)
+
+// PipelineStep is one step of a pipeline
+type PipelineStep struct {
+	// Name shown in the report, the kind of step by default
+	Name string `yaml:"name" json:"name"`
+	// Run is the kind of step: lint, validate, plan, apply, verify, tag, command or shell
+	Run string `yaml:"run" json:"run"`
+	// Args are Liquibase arguments, the files for lint, the tag for tag, the
+	// command line for shell, or the command and its arguments for command
+	Args []string `yaml:"args" json:"args,omitempty"`
+	// Out is the plan file written by plan, plan.sql by default
+	Out string `yaml:"out" json:"out,omitempty"`
+	// OnFailure is abort (the default) or continue
+	OnFailure string `yaml:"onFailure" json:"onFailure,omitempty"`
+	// Timeout stops the step when it runs longer
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// PipelineConfig is the pipeline of the config file
+type PipelineConfig struct {
+	Steps []PipelineStep `yaml:"steps"`
+	// Report is a file the JSON report is written to
+	Report string `yaml:"report"`
+}
+
+// StepResult is the outcome of one step
+type StepResult struct {
+	Name       string    `json:"name"`
+	Run        string    `json:"run"`
+	Status     string    `json:"status"`
+	Start      time.Time `json:"start,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	// Details such as the plan file and its digest, or the pending changesets
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// PipelineReport is the consolidated report of a pipeline run
+type PipelineReport struct {
+	Environment string       `json:"environment,omitempty"`
+	Status      string       `json:"status"`
+	Start       time.Time    `json:"start"`
+	End         time.Time    `json:"end"`
+	DurationMs  int64        `json:"durationMs"`
+	Steps       []StepResult `json:"steps"`
+}
+
+// Failed reports whether any step failed, including steps allowed to continue
+func (r *PipelineReport) Failed() bool {
+	return r.Status == STEP_FAILED
+}
+
+// WriteJSON writes the report to a file
+func (r *PipelineReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Check the steps before running any of them
+func validatePipeline(steps []PipelineStep) error {
+	kinds := []string{STEP_LINT, STEP_VALIDATE, STEP_PLAN, STEP_APPLY, STEP_VERIFY, STEP_TAG, STEP_COMMAND, STEP_SHELL}
+	for i, step := range steps {
+		if !containsString(kinds, step.Run) {
+			return fmt.Errorf("pipeline step %d runs %q, expecting one of %s", i+1, step.Run, strings.Join(kinds, ", "))
+		}
+		if step.OnFailure != "" && step.OnFailure != ON_FAILURE_ABORT && step.OnFailure != ON_FAILURE_CONTINUE {
+			return fmt.Errorf("pipeline step %d has onFailure %q, expecting abort or continue", i+1, step.OnFailure)
+		}
+		if (step.Run == STEP_TAG || step.Run == STEP_COMMAND || step.Run == STEP_SHELL) && len(step.Args) == 0 {
+			return fmt.Errorf("pipeline step %d (%s) needs args", i+1, step.Run)
+		}
+	}
+	return nil
+}
+
+// RunPipeline runs the steps in order. A failing step stops the pipeline
+// unless it continues on failure, the steps after it are reported as
+// skipped. The report is returned even when the pipeline fails.
+func (pl *GoLiquibase) RunPipeline(ctx context.Context, steps []PipelineStep) (*PipelineReport, error) {
+	if len(steps) == 0 {
+		steps = DEFAULT_PIPELINE
+	}
+	if err := validatePipeline(steps); err != nil {
+		return nil, err
+	}
+
+	report := &PipelineReport{Environment: pl.Environment, Status: STEP_SUCCEEDED, Start: time.Now()}
+	var abortErr error
+	for _, step := range steps {
+		result := StepResult{Name: step.Name, Run: step.Run}
+		if result.Name == "" {
+			result.Name = step.Run
+		}
+		if abortErr != nil {
+			result.Status = STEP_SKIPPED
+			report.Steps = append(report.Steps, result)
+			continue
+		}
+
+		pl.logger().Printf("Pipeline step %s", result.Name)
+		result.Start = time.Now()
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		if step.Timeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		}
+		details, err := pl.runPipelineStep(stepCtx, step)
+		cancel()
+		result.DurationMs = time.Since(result.Start).Milliseconds()
+		result.Details = details
+		result.Status = STEP_SUCCEEDED
+		if err != nil {
+			result.Status = STEP_FAILED
+			result.Error = err.Error()
+			report.Status = STEP_FAILED
+			if step.OnFailure == ON_FAILURE_CONTINUE {
+				pl.logger().Printf("Pipeline step %s failed, continuing: %v", result.Name, err)
+			} else {
+				abortErr = fmt.Errorf("pipeline step %s failed: %v", result.Name, err)
+			}
+		}
+		report.Steps = append(report.Steps, result)
+	}
+	report.End = time.Now()
+	report.DurationMs = report.End.Sub(report.Start).Milliseconds()
+	if abortErr == nil && report.Failed() {
+		abortErr = fmt.Errorf("pipeline completed with failed steps")
+	}
+	return report, abortErr
+}
+
+// Run one step, returning details for the report
+func (pl *GoLiquibase) runPipelineStep(ctx context.Context, step PipelineStep) (map[string]string, error) {
+	switch step.Run {
+	case STEP_LINT:
+		files := step.Args
+		if len(files) == 0 {
+			changelog, dirs := pl.ChangelogLocation()
+			if changelog == "" {
+				return nil, fmt.Errorf("no changelog to lint, set args or the changelog file")
+			}
+			files = []string{resolveChangelog(changelog, dirs)}
+		}
+		diagnostics, err := LintFiles(files, "")
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]string{"problems": strconv.Itoa(len(diagnostics))}
+		if HasErrors(diagnostics) {
+			return details, fmt.Errorf("lint found %d problem(s)", len(diagnostics))
+		}
+		return details, nil
+
+	case STEP_VALIDATE:
+		return nil, pl.ExecuteWithOptions(ctx, ExecOptions{}, append([]string{"validate"}, step.Args...)...)
+
+	case STEP_PLAN:
+		out := step.Out
+		if out == "" {
+			out = DEFAULT_PLAN_FILE
+		}
+		var plan strings.Builder
+		if err := pl.ExecuteWithOptions(ctx, ExecOptions{Stdout: &plan}, append([]string{"update-sql"}, step.Args...)...); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(out, []byte(plan.String()), 0644); err != nil {
+			return nil, err
+		}
+		return map[string]string{"plan": out, "digest": PlanDigest(plan.String())}, nil
+
+	case STEP_APPLY:
+		return nil, pl.ExecuteWithOptions(ctx, ExecOptions{}, append([]string{"update"}, step.Args...)...)
+
+	case STEP_VERIFY:
+		// Nothing may be left undeployed after the apply
+		var status strings.Builder
+		if err := pl.ExecuteWithOptions(ctx, ExecOptions{Stdout: &status}, append([]string{"status", "--verbose"}, step.Args...)...); err != nil {
+			return nil, err
+		}
+		pending := "0"
+		if m := pendingPattern.FindStringSubmatch(status.String()); m != nil {
+			pending = m[1]
+		}
+		details := map[string]string{"pending": pending}
+		if pending != "0" {
+			return details, fmt.Errorf("%s changeset(s) are still pending", pending)
+		}
+		return details, nil
+
+	case STEP_TAG:
+		if err := ValidateTag(step.Args[0]); err != nil {
+			return nil, err
+		}
+		return map[string]string{"tag": step.Args[0]}, pl.ExecuteWithOptions(ctx, ExecOptions{}, "tag", fmt.Sprintf("--tag=%s", step.Args[0]))
+
+	case STEP_COMMAND:
+		return nil, pl.ExecuteWithOptions(ctx, ExecOptions{}, step.Args...)
+
+	case STEP_SHELL:
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", append([]string{"/C"}, step.Args...)...)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", strings.Join(step.Args, " "))
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return nil, cmd.Run()
+	}
+	return nil, fmt.Errorf("unknown pipeline step %s", step.Run)
+}
+
+// Find the root changelog on disk, it may be relative to a search path entry
+func resolveChangelog(changelog string, dirs []string) string {
+	for _, dir := range dirs {
+		if path := filepath.Join(dir, changelog); fileExists(path) {
+			return path
+		}
+	}
+	return changelog
+}
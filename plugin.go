@@ -0,0 +1,173 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Executables on the PATH named goliquify-plugin-<name> are command plugins
+const PLUGIN_PREFIX = "goliquify-plugin-"
+
+// Middleware intercepts the Liquibase commands of an instance, e.g. for
+// organization policies or integrations. Before runs ahead of the command
+// and may change its arguments, or refuse it by returning an error. After
+// observes how a command Before let through ended. Middleware runs in the
+// order it was added, and is given the command's own arguments, not the
+// connection and credentials goliquify adds to them.
+type Middleware interface {
+	Before(ctx context.Context, inv *Invocation) error
+	After(ctx context.Context, inv *Invocation, result InvocationResult)
+}
+
+// Invocation is a Liquibase command as middleware sees it
+type Invocation struct {
+	Command     string `json:"command"`
+	Environment string `json:"environment,omitempty"`
+	DryRun      bool   `json:"dryRun,omitempty"`
+	// Args are the command and its arguments, e.g. update --contexts=prod
+	Args []string `json:"args"`
+}
+
+// InvocationResult is how a command ended, Status being EVENT_COMPLETED or EVENT_FAILED
+type InvocationResult struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMs int64  `json:"elapsedMs"`
+}
+
+// Run the Before of every middleware, each seeing the arguments the
+// previous ones left
+func (pl *GoLiquibase) beforeMiddleware(ctx context.Context, arguments []string) (*Invocation, error) {
+	command, err := parseCommand(arguments)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Invocation{Command: command, Environment: pl.Environment, DryRun: pl.DryRun, Args: arguments}
+	for _, m := range pl.Middleware {
+		if err := m.Before(ctx, inv); err != nil {
+			return nil, err
+		}
+		if inv.Command, err = parseCommand(inv.Args); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// Run the After of every middleware
+func (pl *GoLiquibase) afterMiddleware(ctx context.Context, inv *Invocation, start time.Time, err error) {
+	result := InvocationResult{Status: EVENT_COMPLETED, ElapsedMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status, result.Error = EVENT_FAILED, err.Error()
+	}
+	for _, m := range pl.Middleware {
+		m.After(ctx, inv, result)
+	}
+}
+
+// PluginMiddleware runs a goliquify-plugin-<name> executable as middleware.
+// Before runs "<plugin> before" with the invocation as JSON on stdin. A
+// plugin exiting with an error refuses the command, with its output as the
+// reason. One printing an invocation replaces the arguments with its args.
+// After runs "<plugin> after" with the invocation and its result, whose
+// failures are only logged.
+func PluginMiddleware(pl *GoLiquibase, name string) (Middleware, error) {
+	path, err := exec.LookPath(PLUGIN_PREFIX + name)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s%s not found on the PATH", PLUGIN_PREFIX, name)
+	}
+	return &pluginMiddleware{pl: pl, name: name, path: path}, nil
+}
+
+type pluginMiddleware struct {
+	pl   *GoLiquibase
+	name string
+	path string
+}
+
+// Run the plugin with input on stdin, returning its output
+func (p *pluginMiddleware) run(ctx context.Context, hook string, input any) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := &Command{Path: p.path, Args: []string{hook}, Env: os.Environ(), Stdin: bytes.NewReader(data), Stdout: &stdout, Stderr: &stderr}
+	if err := p.pl.runner().Run(ctx, cmd); err != nil {
+		if reason := strings.TrimSpace(firstNonEmpty(stderr.String(), stdout.String())); reason != "" {
+			return nil, fmt.Errorf("%v: %s", err, reason)
+		}
+		return nil, err
+	}
+	if stderr.Len() > 0 {
+		p.pl.logger().Printf("Plugin %s: %s", p.name, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (p *pluginMiddleware) Before(ctx context.Context, inv *Invocation) error {
+	out, err := p.run(ctx, "before", inv)
+	if err != nil {
+		return fmt.Errorf("plugin %s refused %s: %v", p.name, inv.Command, err)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+	var changed Invocation
+	if err := json.Unmarshal(out, &changed); err != nil {
+		return fmt.Errorf("failed to parse the invocation printed by plugin %s: %v", p.name, err)
+	}
+	if len(changed.Args) == 0 {
+		return fmt.Errorf("plugin %s printed an invocation without args", p.name)
+	}
+	if !slices.Equal(changed.Args, inv.Args) {
+		p.pl.logger().Printf("Plugin %s changed the arguments to %s", p.name, QuoteArgs(changed.Args))
+		inv.Args = changed.Args
+	}
+	return nil
+}
+
+func (p *pluginMiddleware) After(ctx context.Context, inv *Invocation, result InvocationResult) {
+	input := struct {
+		*Invocation
+		Result InvocationResult `json:"result"`
+	}{inv, result}
+	if _, err := p.run(ctx, "after", input); err != nil {
+		p.pl.logger().Printf("Plugin %s failed after %s: %v", p.name, inv.Command, err)
+	}
+}
+
+// ListPlugins returns the names of the plugins on the PATH, the first one
+// of a name shadowing later ones
+func ListPlugins() map[string]string {
+	plugins := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), PLUGIN_PREFIX)
+			name = strings.TrimSuffix(name, ".exe")
+			if !ok || name == "" || entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if _, err := exec.LookPath(path); err != nil {
+				continue
+			}
+			if _, seen := plugins[name]; !seen {
+				plugins[name] = path
+			}
+		}
+	}
+	return plugins
+}
@@ -0,0 +1,629 @@
+package goliquify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Diff policy rules
+const (
+	POLICY_NO_TABLE_DROPS    = "no-table-drops"
+	POLICY_NO_COLUMN_DROPS   = "no-column-drops"
+	POLICY_NO_TYPE_NARROWING = "no-type-narrowing"
+	POLICY_FK_REQUIRES_INDEX = "fk-requires-index"
+)
+
+// Severity of a rule that is turned off
+const SEVERITY_OFF = "off"
+
+var POLICY_RULES = []string{POLICY_NO_TABLE_DROPS, POLICY_NO_COLUMN_DROPS, POLICY_NO_TYPE_NARROWING, POLICY_FK_REQUIRES_INDEX}
+
+var (
+	diffSectionPattern    = regexp.MustCompile(`^(Missing|Unexpected|Changed) (.+?)\(s\):\s*(NONE)?\s*$`)
+	diffTypeChangePattern = regexp.MustCompile(`^type changed from '(.*)' to '(.*)'$`)
+	diffForeignKeyPattern = regexp.MustCompile(`^(\S+)\((\S+?)\[(.*?)\] -> (\S+?)\[(.*?)\]\)$`)
+	diffIndexPattern      = regexp.MustCompile(`(?i)^(\S+)(?:\s+unique)?\s+on\s+([^(\s]+)\((.*)\)$`)
+	typeArgsPattern       = regexp.MustCompile(`^([a-z ]+)\(([^)]*)\)$`)
+	leadingDigitsPattern  = regexp.MustCompile(`^\d+`)
+)
+
+// Character types, text being unbounded
+var STRING_TYPES = []string{"varchar", "char", "nvarchar", "nchar", "text"}
+
+// Integer types from narrowest to widest
+var INTEGER_TYPES = []string{"tinyint", "smallint", "mediumint", "int", "bigint"}
+
+// SchemaChange is one structural change of a diff, as Liquibase's
+// diff-changelog would write it
+type SchemaChange struct {
+	// Type is the Liquibase change type, e.g. dropColumn or createIndex
+	Type  string `json:"type"`
+	Table string `json:"table,omitempty"`
+	// Name of the index or constraint
+	Name    string   `json:"name,omitempty"`
+	Columns []string `json:"columns,omitempty"`
+	// OldType and NewType are the column types of modifyDataType, OldType
+	// only when known
+	OldType string `json:"oldType,omitempty"`
+	NewType string `json:"newType,omitempty"`
+	// RefTable is the table a new foreign key references
+	RefTable string `json:"refTable,omitempty"`
+	// NotNullColumns are the columns added or made NOT NULL without a default
+	NotNullColumns []string `json:"notNullColumns,omitempty"`
+	// Concurrently is set on indexes built without blocking writes
+	Concurrently bool `json:"concurrently,omitempty"`
+}
+
+// Object describes what the change touches, e.g. table orders or column orders.note
+func (c SchemaChange) Object() string {
+	switch {
+	case c.Table == "":
+		return c.Name
+	case len(c.Columns) == 1:
+		return fmt.Sprintf("column %s.%s", c.Table, c.Columns[0])
+	case len(c.Columns) > 1:
+		return fmt.Sprintf("columns %s(%s)", c.Table, strings.Join(c.Columns, ", "))
+	}
+	return "table " + c.Table
+}
+
+// DiffPolicy is a policies file: the severity of each rule and the tables or
+// columns exempt from them
+type DiffPolicy struct {
+	// Rules maps rule names to error, warning or off
+	Rules map[string]string `yaml:"rules"`
+	// Allow lists tables and table.column names the rules don't apply to
+	Allow []string `yaml:"allow"`
+}
+
+// PolicyViolation is a change that breaks a policy rule
+type PolicyViolation struct {
+	Rule     string       `json:"rule"`
+	Severity string       `json:"severity"`
+	Message  string       `json:"message"`
+	Change   SchemaChange `json:"change"`
+}
+
+// String describes the violation in one line
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Severity, v.Rule, v.Message)
+}
+
+// ReadDiffPolicy reads and checks a policies file
+func ReadDiffPolicy(path string) (*DiffPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy DiffPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for rule, severity := range policy.Rules {
+		if !containsString(POLICY_RULES, rule) {
+			return nil, fmt.Errorf("%s: unknown rule %s, expecting one of %s", path, rule, strings.Join(POLICY_RULES, ", "))
+		}
+		if severity != SEVERITY_ERROR && severity != SEVERITY_WARNING && severity != SEVERITY_OFF {
+			return nil, fmt.Errorf("%s: rule %s has severity %s, expecting error, warning or off", path, rule, severity)
+		}
+	}
+	return &policy, nil
+}
+
+// Check if the policy exempts a table or one of its columns
+func (p *DiffPolicy) allows(table string, columns []string) bool {
+	if containsFold(p.Allow, table) {
+		return true
+	}
+	for _, column := range columns {
+		if containsFold(p.Allow, table+"."+column) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnforceDiffPolicy checks the changes of a diff against a policy. The
+// schema being changed is optional, with it indexes that already exist
+// count for fk-requires-index and modifyDataType changes without an old
+// type are checked against the current column type.
+func EnforceDiffPolicy(policy *DiffPolicy, changes []SchemaChange, schema *SchemaModel) []PolicyViolation {
+	var violations []PolicyViolation
+	add := func(rule string, change SchemaChange, format string, args ...any) {
+		severity := policy.Rules[rule]
+		if severity == "" || severity == SEVERITY_OFF || policy.allows(change.Table, change.Columns) {
+			return
+		}
+		violations = append(violations, PolicyViolation{Rule: rule, Severity: severity, Message: fmt.Sprintf(format, args...), Change: change})
+	}
+
+	for _, change := range changes {
+		switch change.Type {
+		case "dropTable":
+			add(POLICY_NO_TABLE_DROPS, change, "table %s is dropped", change.Table)
+		case "dropColumn":
+			for _, column := range change.Columns {
+				c := change
+				c.Columns = []string{column}
+				add(POLICY_NO_COLUMN_DROPS, c, "column %s.%s is dropped", change.Table, column)
+			}
+		case "modifyDataType":
+			oldType := change.OldType
+			if oldType == "" && schema != nil && len(change.Columns) == 1 {
+				if column := schemaColumn(schema, change.Table, change.Columns[0]); column != nil {
+					oldType = column.Type
+				}
+			}
+			if oldType != "" && IsTypeNarrowing(oldType, change.NewType) {
+				add(POLICY_NO_TYPE_NARROWING, change, "%s narrows from %s to %s", change.Object(), NormalizeType(oldType), NormalizeType(change.NewType))
+			}
+		case "addForeignKeyConstraint":
+			if !hasIndexCovering(changes, schema, change.Table, change.Columns) {
+				add(POLICY_FK_REQUIRES_INDEX, change, "foreign key %s on %s has no index starting with its columns", change.Name, change.Object())
+			}
+		}
+	}
+	return violations
+}
+
+// Find a column of a schema model, names compare case insensitively
+func schemaColumn(schema *SchemaModel, table, column string) *ColumnModel {
+	for _, t := range schema.Tables {
+		if !strings.EqualFold(t.Name, table) {
+			continue
+		}
+		for _, c := range t.Columns {
+			if strings.EqualFold(c.Name, column) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Check if an index, primary key or unique constraint created by the changes
+// or found in the schema starts with the columns
+func hasIndexCovering(changes []SchemaChange, schema *SchemaModel, table string, columns []string) bool {
+	covers := func(indexColumns []string) bool {
+		if len(indexColumns) < len(columns) {
+			return false
+		}
+		for i, column := range columns {
+			if !strings.EqualFold(indexColumns[i], column) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, change := range changes {
+		switch change.Type {
+		case "createIndex", "addPrimaryKey", "addUniqueConstraint":
+			if strings.EqualFold(change.Table, table) && covers(change.Columns) {
+				return true
+			}
+		}
+	}
+	if schema == nil {
+		return false
+	}
+	for _, t := range schema.Tables {
+		if !strings.EqualFold(t.Name, table) {
+			continue
+		}
+		if covers(t.PrimaryKey) {
+			return true
+		}
+		for _, idx := range t.Indexes {
+			if covers(idx.Columns) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsTypeNarrowing checks if changing a column type can lose data: shorter
+// strings, smaller integers or decimals, floats to reals and timestamps to
+// dates. Changes between unrelated types aren't judged and return false.
+func IsTypeNarrowing(oldType, newType string) bool {
+	oldName, oldArgs := splitType(NormalizeType(oldType))
+	newName, newArgs := splitType(NormalizeType(newType))
+	if oldName == newName && slicesEqual(oldArgs, newArgs) {
+		return false
+	}
+	switch {
+	case containsString(STRING_TYPES, oldName) && containsString(STRING_TYPES, newName):
+		if newName == "text" {
+			return false
+		}
+		if oldName == "text" || len(oldArgs) == 0 {
+			return len(newArgs) > 0
+		}
+		return len(newArgs) > 0 && newArgs[0] < oldArgs[0]
+	case containsString(INTEGER_TYPES, oldName) && containsString(INTEGER_TYPES, newName):
+		return indexOf(INTEGER_TYPES, newName) < indexOf(INTEGER_TYPES, oldName)
+	case oldName == "decimal" && containsString(INTEGER_TYPES, newName):
+		return len(oldArgs) < 2 || oldArgs[1] > 0
+	case oldName == "decimal" && newName == "decimal":
+		if len(oldArgs) == 0 || len(newArgs) == 0 {
+			return len(newArgs) > 0
+		}
+		oldScale, newScale := argAt(oldArgs, 1), argAt(newArgs, 1)
+		// Both the digits left of the point and the ones right of it must fit
+		return newScale < oldScale || newArgs[0]-newScale < oldArgs[0]-oldScale
+	case oldName == "double" && newName == "real":
+		return true
+	case (oldName == "timestamp" || oldName == "timestamptz") && newName == "date":
+		return true
+	}
+	return false
+}
+
+// Split a normalized type into its name and numeric size arguments
+func splitType(t string) (string, []int) {
+	m := typeArgsPattern.FindStringSubmatch(t)
+	if m == nil {
+		return strings.TrimSuffix(t, " unsigned"), nil
+	}
+	var args []int
+	for _, arg := range strings.Split(m[2], ",") {
+		// Sizes like 255 BYTE or 255 CHAR count by their number
+		n, err := strconv.Atoi(leadingDigitsPattern.FindString(arg))
+		if err != nil {
+			return m[1], nil
+		}
+		args = append(args, n)
+	}
+	return m[1], args
+}
+
+func argAt(args []int, i int) int {
+	if i < len(args) {
+		return args[i]
+	}
+	return 0
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func slicesEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDiffReport reads the text report of Liquibase diff into the changes
+// that would bring the target database in line with the reference one:
+// unexpected objects are dropped, missing ones created and changed column
+// types modified. Liquibase reports a type as changed from the reference
+// type to the target type, the old type is the latter.
+func ParseDiffReport(r io.Reader) ([]SchemaChange, error) {
+	var changes []SchemaChange
+	var section string
+	var changedColumn *SchemaChange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if m := diffSectionPattern.FindStringSubmatch(line); m != nil {
+			section = m[1] + " " + m[2]
+			if m[3] != "" {
+				section = ""
+			}
+			changedColumn = nil
+			continue
+		}
+		if trimmed == "" || !strings.HasPrefix(line, " ") {
+			// Headers like Reference Database: end a section
+			if trimmed != "" {
+				section = ""
+			}
+			continue
+		}
+
+		switch section {
+		case "Missing Table":
+			changes = append(changes, SchemaChange{Type: "createTable", Table: unqualified(trimmed)})
+		case "Unexpected Table":
+			changes = append(changes, SchemaChange{Type: "dropTable", Table: unqualified(trimmed)})
+		case "Missing Column", "Unexpected Column":
+			table, column := splitColumnName(trimmed)
+			changeType := "addColumn"
+			if section == "Unexpected Column" {
+				changeType = "dropColumn"
+			}
+			changes = append(changes, SchemaChange{Type: changeType, Table: table, Columns: []string{column}})
+		case "Changed Column":
+			if m := diffTypeChangePattern.FindStringSubmatch(trimmed); m != nil && changedColumn != nil {
+				c := *changedColumn
+				c.Type, c.NewType, c.OldType = "modifyDataType", m[1], m[2]
+				changes = append(changes, c)
+			} else if !strings.Contains(trimmed, " changed from ") {
+				table, column := splitColumnName(trimmed)
+				changedColumn = &SchemaChange{Table: table, Columns: []string{column}}
+			}
+		case "Missing Foreign Key":
+			if m := diffForeignKeyPattern.FindStringSubmatch(trimmed); m != nil {
+				changes = append(changes, SchemaChange{Type: "addForeignKeyConstraint", Name: m[1], Table: unqualified(m[2]), Columns: splitColumns(m[3]), RefTable: unqualified(m[4])})
+			}
+		case "Missing Index", "Missing Primary Key", "Missing Unique Constraint":
+			if m := diffIndexPattern.FindStringSubmatch(trimmed); m != nil {
+				changeType := map[string]string{"Missing Index": "createIndex", "Missing Primary Key": "addPrimaryKey", "Missing Unique Constraint": "addUniqueConstraint"}[section]
+				changes = append(changes, SchemaChange{Type: changeType, Name: m[1], Table: unqualified(m[2]), Columns: splitColumns(m[3])})
+			}
+		}
+	}
+	return changes, scanner.Err()
+}
+
+// Drop the schema and catalog from a name like PUBLIC.ORDERS
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Split a column name like PUBLIC.ORDERS.NOTE into its table and column
+func splitColumnName(name string) (string, string) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return "", name
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// Split a comma separated column list
+func splitColumns(columns string) []string {
+	var names []string
+	for _, column := range strings.Split(columns, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			names = append(names, unqualified(column))
+		}
+	}
+	return names
+}
+
+// ParseDiffChangelog reads the changes of a changelog written by Liquibase
+// diff-changelog, in XML, YAML or JSON
+func ParseDiffChangelog(path string) ([]SchemaChange, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var changes []SchemaChange
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		changes, err = parseXMLChanges(content)
+	case ".yaml", ".yml", ".json":
+		changes, err = parseYAMLChanges(content)
+	default:
+		err = fmt.Errorf("unsupported changelog format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return changes, nil
+}
+
+// A column nested in a change
+type changeColumn struct {
+	name       string
+	notNull    bool
+	hasDefault bool
+}
+
+// Check if change attributes give a column default, e.g. defaultValueNumeric
+func hasDefaultAttr(attrs map[string]string) bool {
+	for key := range attrs {
+		if strings.HasPrefix(key, "defaultValue") {
+			return true
+		}
+	}
+	return false
+}
+
+// Build a change from its attributes and its nested columns
+func schemaChange(changeType string, attrs map[string]string, columns []changeColumn) SchemaChange {
+	change := SchemaChange{
+		Type:    changeType,
+		Table:   attrs["tableName"],
+		Name:    firstNonEmpty(attrs["indexName"], attrs["constraintName"]),
+		NewType: attrs["newDataType"],
+	}
+	switch {
+	case attrs["columnName"] != "":
+		change.Columns = []string{attrs["columnName"]}
+	case attrs["columnNames"] != "":
+		change.Columns = splitColumns(attrs["columnNames"])
+	}
+	nested := len(change.Columns) == 0
+	for _, column := range columns {
+		if nested {
+			change.Columns = append(change.Columns, column.name)
+		}
+		if column.notNull && !column.hasDefault {
+			change.NotNullColumns = append(change.NotNullColumns, column.name)
+		}
+	}
+	switch changeType {
+	case "addForeignKeyConstraint":
+		change.Table = attrs["baseTableName"]
+		change.Columns = splitColumns(attrs["baseColumnNames"])
+		change.RefTable = attrs["referencedTableName"]
+	case "addNotNullConstraint":
+		if attrs["defaultNullValue"] == "" {
+			change.NotNullColumns = change.Columns
+		}
+	}
+	return change
+}
+
+// Parse the changes of an XML changelog. The statements of sql changes are
+// read into the changes they make, where recognized.
+func parseXMLChanges(content []byte) ([]SchemaChange, error) {
+	var changes []SchemaChange
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	var current *SchemaChange
+	var currentAttrs map[string]string
+	var columns []changeColumn
+	var statements strings.Builder
+	var depth int
+	inChangeSet := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return changes, nil
+			}
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if t.Name.Local == "changeSet" {
+				inChangeSet, depth = true, 0
+				continue
+			}
+			if !inChangeSet {
+				continue
+			}
+			depth++
+			switch {
+			case depth == 1 && t.Name.Local != "rollback":
+				current, currentAttrs, columns = &SchemaChange{Type: t.Name.Local}, attrs, nil
+				statements.Reset()
+			case depth == 2 && current != nil && t.Name.Local == "column":
+				columns = append(columns, changeColumn{name: attrs["name"], hasDefault: hasDefaultAttr(attrs)})
+			case depth == 3 && current != nil && t.Name.Local == "constraints" && len(columns) > 0:
+				columns[len(columns)-1].notNull = attrs["nullable"] == "false" || attrs["primaryKey"] == "true"
+			}
+		case xml.CharData:
+			if current != nil && depth == 1 && current.Type == "sql" {
+				statements.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "changeSet" {
+				inChangeSet = false
+				continue
+			}
+			if !inChangeSet {
+				continue
+			}
+			if depth == 1 && current != nil {
+				if current.Type == "sql" {
+					changes = append(changes, SQLChanges(statements.String())...)
+				} else {
+					changes = append(changes, schemaChange(current.Type, currentAttrs, columns))
+				}
+				current = nil
+			}
+			depth--
+		}
+	}
+}
+
+// Parse the changes of a YAML or JSON changelog
+func parseYAMLChanges(content []byte) ([]SchemaChange, error) {
+	var doc struct {
+		DatabaseChangeLog []struct {
+			ChangeSet struct {
+				Changes []map[string]map[string]any `yaml:"changes"`
+			} `yaml:"changeSet"`
+		} `yaml:"databaseChangeLog"`
+	}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	var changes []SchemaChange
+	for _, entry := range doc.DatabaseChangeLog {
+		for _, change := range entry.ChangeSet.Changes {
+			for changeType, body := range change {
+				if changeType == "sql" {
+					changes = append(changes, SQLChanges(fmt.Sprint(body["sql"]))...)
+					continue
+				}
+				attrs := map[string]string{}
+				var columns []changeColumn
+				for key, value := range body {
+					switch v := value.(type) {
+					case []any:
+						if key != "columns" {
+							continue
+						}
+						for _, item := range v {
+							if column, ok := item.(map[string]any)["column"].(map[string]any); ok {
+								columns = append(columns, yamlChangeColumn(column))
+							}
+						}
+					case map[string]any:
+					default:
+						attrs[key] = fmt.Sprint(v)
+					}
+				}
+				changes = append(changes, schemaChange(changeType, attrs, columns))
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Read a column nested in a YAML or JSON change
+func yamlChangeColumn(column map[string]any) changeColumn {
+	attrs := map[string]string{}
+	for key, value := range column {
+		attrs[key] = fmt.Sprint(value)
+	}
+	c := changeColumn{name: attrs["name"], hasDefault: hasDefaultAttr(attrs)}
+	if constraints, ok := column["constraints"].(map[string]any); ok {
+		c.notNull = fmt.Sprint(constraints["nullable"]) == "false" || fmt.Sprint(constraints["primaryKey"]) == "true"
+	}
+	return c
+}
+
+// DiffChanges runs Liquibase diff, its report is printed as it runs, and
+// returns the changes it found
+func (pl *GoLiquibase) DiffChanges(ctx context.Context, arguments ...string) ([]SchemaChange, error) {
+	var report bytes.Buffer
+	err := pl.ExecuteWithOptions(ctx, ExecOptions{Stdout: io.MultiWriter(os.Stdout, &report)}, append([]string{"diff"}, arguments...)...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDiffReport(&report)
+}
+
+// SortViolations orders violations errors first, then by rule
+func SortViolations(violations []PolicyViolation) {
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Severity != violations[j].Severity {
+			return violations[i].Severity == SEVERITY_ERROR
+		}
+		return violations[i].Rule < violations[j].Rule
+	})
+}
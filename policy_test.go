@@ -0,0 +1,297 @@
+package goliquify_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+const diffReport = `Reference Database: REF @ jdbc:postgresql://localhost/ref (Default Schema: public)
+Comparison Database: APP @ jdbc:postgresql://localhost/app (Default Schema: public)
+Compared Schemas: public
+Product Name: EQUAL
+Missing Column(s): 
+     public.orders.status
+Unexpected Column(s): 
+     public.orders.note
+Changed Column(s): 
+     public.orders.amount
+          type changed from 'numeric(10, 2)' to 'numeric(12, 2)'
+     public.users.name
+          type changed from 'varchar(50)' to 'varchar(255)'
+Missing Foreign Key(s): 
+     fk_orders_users(public.orders[user_id] -> public.users[id])
+Missing Index(s): NONE
+Unexpected Table(s): 
+     public.audit_log
+`
+
+func TestParseDiffReport(t *testing.T) {
+	changes, err := goliquify.ParseDiffReport(strings.NewReader(diffReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []goliquify.SchemaChange{
+		{Type: "addColumn", Table: "orders", Columns: []string{"status"}},
+		{Type: "dropColumn", Table: "orders", Columns: []string{"note"}},
+		{Type: "modifyDataType", Table: "orders", Columns: []string{"amount"}, OldType: "numeric(12, 2)", NewType: "numeric(10, 2)"},
+		{Type: "modifyDataType", Table: "users", Columns: []string{"name"}, OldType: "varchar(255)", NewType: "varchar(50)"},
+		{Type: "addForeignKeyConstraint", Table: "orders", Name: "fk_orders_users", Columns: []string{"user_id"}, RefTable: "users"},
+		{Type: "dropTable", Table: "audit_log"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes\n%+v\nwant\n%+v", changes, want)
+	}
+}
+
+func TestParseDiffChangelog(t *testing.T) {
+	want := []goliquify.SchemaChange{
+		{Type: "dropColumn", Table: "orders", Columns: []string{"note"}},
+		{Type: "modifyDataType", Table: "users", Columns: []string{"name"}, NewType: "varchar(50)"},
+		{Type: "addForeignKeyConstraint", Table: "orders", Name: "fk_orders_users", Columns: []string{"user_id"}, RefTable: "users"},
+		{Type: "createIndex", Table: "orders", Name: "idx_orders_user", Columns: []string{"user_id"}},
+	}
+	for _, tc := range []struct {
+		name, content string
+	}{
+		{"diff.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<databaseChangeLog xmlns="http://www.liquibase.org/xml/ns/dbchangelog">
+    <changeSet id="1" author="diff">
+        <dropColumn tableName="orders" columnName="note"/>
+        <rollback><addColumn tableName="orders"><column name="note" type="text"/></addColumn></rollback>
+    </changeSet>
+    <changeSet id="2" author="diff">
+        <modifyDataType tableName="users" columnName="name" newDataType="varchar(50)"/>
+    </changeSet>
+    <changeSet id="3" author="diff">
+        <addForeignKeyConstraint constraintName="fk_orders_users" baseTableName="orders" baseColumnNames="user_id" referencedTableName="users" referencedColumnNames="id"/>
+        <createIndex indexName="idx_orders_user" tableName="orders">
+            <column name="user_id"/>
+        </createIndex>
+    </changeSet>
+</databaseChangeLog>
+`},
+		{"diff.yaml", `databaseChangeLog:
+  - changeSet:
+      id: 1
+      author: diff
+      changes:
+        - dropColumn:
+            tableName: orders
+            columnName: note
+  - changeSet:
+      id: 2
+      author: diff
+      changes:
+        - modifyDataType:
+            tableName: users
+            columnName: name
+            newDataType: varchar(50)
+  - changeSet:
+      id: 3
+      author: diff
+      changes:
+        - addForeignKeyConstraint:
+            constraintName: fk_orders_users
+            baseTableName: orders
+            baseColumnNames: user_id
+            referencedTableName: users
+            referencedColumnNames: id
+        - createIndex:
+            indexName: idx_orders_user
+            tableName: orders
+            columns:
+              - column:
+                  name: user_id
+`},
+	} {
+		path := filepath.Join(t.TempDir(), tc.name)
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		changes, err := goliquify.ParseDiffChangelog(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(changes, want) {
+			t.Fatalf("%s: changes\n%+v\nwant\n%+v", tc.name, changes, want)
+		}
+	}
+}
+
+func TestIsTypeNarrowing(t *testing.T) {
+	for _, tc := range []struct {
+		oldType, newType string
+		narrows          bool
+	}{
+		{"varchar(255)", "varchar(50)", true},
+		{"varchar(50)", "varchar(255)", false},
+		{"text", "varchar(4000)", true},
+		{"varchar(50)", "text", false},
+		{"bigint", "int", true},
+		{"int", "bigint", false},
+		{"decimal(12,2)", "decimal(10,2)", true},
+		{"decimal(10,2)", "decimal(12,4)", false},
+		{"decimal(10,2)", "decimal(10,4)", true},
+		{"decimal(10,2)", "bigint", true},
+		{"double", "real", true},
+		{"timestamp", "date", true},
+		{"varchar(50)", "int", false},
+		{"int", "int", false},
+	} {
+		if narrows := goliquify.IsTypeNarrowing(tc.oldType, tc.newType); narrows != tc.narrows {
+			t.Errorf("%s to %s: narrowing is %v, want %v", tc.oldType, tc.newType, narrows, tc.narrows)
+		}
+	}
+}
+
+func TestEnforceDiffPolicy(t *testing.T) {
+	changes := []goliquify.SchemaChange{
+		{Type: "dropTable", Table: "audit_log"},
+		{Type: "dropColumn", Table: "orders", Columns: []string{"note", "legacy_ref"}},
+		{Type: "modifyDataType", Table: "users", Columns: []string{"name"}, NewType: "varchar(50)"},
+		{Type: "addForeignKeyConstraint", Table: "orders", Name: "fk_orders_users", Columns: []string{"user_id"}, RefTable: "users"},
+		{Type: "addForeignKeyConstraint", Table: "orders", Name: "fk_orders_shops", Columns: []string{"shop_id"}, RefTable: "shops"},
+		{Type: "addForeignKeyConstraint", Table: "orders", Name: "fk_orders_carts", Columns: []string{"cart_id"}, RefTable: "carts"},
+		{Type: "createIndex", Table: "orders", Name: "idx_orders_shop", Columns: []string{"shop_id", "created_at"}},
+	}
+	schema := &goliquify.SchemaModel{Tables: map[string]*goliquify.TableModel{
+		"users":  {Name: "users", Columns: []*goliquify.ColumnModel{{Name: "name", Type: "varchar(255)"}}},
+		"orders": {Name: "orders", Indexes: []*goliquify.IndexModel{{Name: "idx_orders_cart", Columns: []string{"cart_id"}}}},
+	}}
+	all := map[string]string{
+		goliquify.POLICY_NO_TABLE_DROPS:    goliquify.SEVERITY_ERROR,
+		goliquify.POLICY_NO_COLUMN_DROPS:   goliquify.SEVERITY_ERROR,
+		goliquify.POLICY_NO_TYPE_NARROWING: goliquify.SEVERITY_WARNING,
+		goliquify.POLICY_FK_REQUIRES_INDEX: goliquify.SEVERITY_ERROR,
+	}
+	for _, tc := range []struct {
+		name   string
+		policy goliquify.DiffPolicy
+		schema *goliquify.SchemaModel
+		want   []string
+	}{
+		{
+			name:   "all rules with the schema",
+			policy: goliquify.DiffPolicy{Rules: all},
+			schema: schema,
+			want: []string{
+				"error: no-table-drops: table audit_log is dropped",
+				"error: no-column-drops: column orders.note is dropped",
+				"error: no-column-drops: column orders.legacy_ref is dropped",
+				"warning: no-type-narrowing: column users.name narrows from varchar(255) to varchar(50)",
+				"error: fk-requires-index: foreign key fk_orders_users on column orders.user_id has no index starting with its columns",
+			},
+		},
+		{
+			// Without the schema the old type and the existing index are unknown
+			name:   "all rules without the schema",
+			policy: goliquify.DiffPolicy{Rules: all},
+			want: []string{
+				"error: no-table-drops: table audit_log is dropped",
+				"error: no-column-drops: column orders.note is dropped",
+				"error: no-column-drops: column orders.legacy_ref is dropped",
+				"error: fk-requires-index: foreign key fk_orders_users on column orders.user_id has no index starting with its columns",
+				"error: fk-requires-index: foreign key fk_orders_carts on column orders.cart_id has no index starting with its columns",
+			},
+		},
+		{
+			name:   "allowed tables and columns",
+			policy: goliquify.DiffPolicy{Rules: all, Allow: []string{"AUDIT_LOG", "orders.legacy_ref", "orders.user_id"}},
+			schema: schema,
+			want: []string{
+				"error: no-column-drops: column orders.note is dropped",
+				"warning: no-type-narrowing: column users.name narrows from varchar(255) to varchar(50)",
+			},
+		},
+		{
+			name: "rules turned off or not set",
+			policy: goliquify.DiffPolicy{Rules: map[string]string{
+				goliquify.POLICY_NO_TABLE_DROPS:  goliquify.SEVERITY_OFF,
+				goliquify.POLICY_NO_COLUMN_DROPS: goliquify.SEVERITY_WARNING,
+			}},
+			schema: schema,
+			want: []string{
+				"warning: no-column-drops: column orders.note is dropped",
+				"warning: no-column-drops: column orders.legacy_ref is dropped",
+			},
+		},
+	} {
+		var got []string
+		for _, v := range goliquify.EnforceDiffPolicy(&tc.policy, changes, tc.schema) {
+			got = append(got, v.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: violations\n%s\nwant\n%s", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestSortViolations(t *testing.T) {
+	violations := []goliquify.PolicyViolation{
+		{Rule: goliquify.POLICY_NO_TYPE_NARROWING, Severity: goliquify.SEVERITY_WARNING},
+		{Rule: goliquify.POLICY_NO_TABLE_DROPS, Severity: goliquify.SEVERITY_ERROR},
+		{Rule: goliquify.POLICY_FK_REQUIRES_INDEX, Severity: goliquify.SEVERITY_WARNING},
+		{Rule: goliquify.POLICY_NO_COLUMN_DROPS, Severity: goliquify.SEVERITY_ERROR},
+	}
+	goliquify.SortViolations(violations)
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Rule)
+	}
+	want := []string{goliquify.POLICY_NO_COLUMN_DROPS, goliquify.POLICY_NO_TABLE_DROPS, goliquify.POLICY_FK_REQUIRES_INDEX, goliquify.POLICY_NO_TYPE_NARROWING}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order %v, want %v", got, want)
+	}
+}
+
+func TestReadDiffPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		fails   string
+	}{
+		{content: "rules:\n  no-column-drops: error\n  no-type-narrowing: warning\n  fk-requires-index: \"off\"\nallow:\n  - orders.note\n"},
+		{content: "rules:\n  no-index-drops: error\n", fails: "unknown rule no-index-drops"},
+		{content: "rules:\n  no-column-drops: fatal\n", fails: "has severity fatal"},
+		{content: "rules: [", fails: "failed to parse"},
+	} {
+		path := filepath.Join(t.TempDir(), "policies.yaml")
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		policy, err := goliquify.ReadDiffPolicy(path)
+		if tc.fails != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.fails) {
+				t.Errorf("%q: error %v, want %q", tc.content, err, tc.fails)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.content, err)
+		}
+		if policy.Rules[goliquify.POLICY_FK_REQUIRES_INDEX] != goliquify.SEVERITY_OFF || len(policy.Allow) != 1 {
+			t.Fatalf("policy %+v", policy)
+		}
+	}
+}
+
+func TestDiffChanges(t *testing.T) {
+	pl, runner := goliquifytest.New(t)
+	runner.On("diff", goliquifytest.Response{Stdout: diffReport})
+	changes, err := pl.DiffChanges(context.Background(), "--reference-url=jdbc:postgresql://localhost/ref")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 6 {
+		t.Fatalf("%d changes, want 6: %+v", len(changes), changes)
+	}
+	if url, _ := runner.Invocations()[0].Arg("reference-url"); url != "jdbc:postgresql://localhost/ref" {
+		t.Fatalf("diff ran with %v", runner.Invocations()[0].Args)
+	}
+}
@@ -0,0 +1,213 @@
+package goliquify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PUBLISHER_KAFKA = "kafka"
+	PUBLISHER_NATS  = "nats"
+
+	// EVENT_SCHEMA_VERSION names the JSON schema of published events,
+	// bumped on incompatible changes
+	EVENT_SCHEMA_VERSION = "goliquify.event/v1"
+	// Topic or subject events are published to, {env} is replaced by the environment
+	DEFAULT_EVENT_TOPIC = "goliquify.events"
+)
+
+// EventPublisherConfig publishes run events to a Kafka topic, through a
+// Kafka REST proxy, or to a NATS subject
+type EventPublisherConfig struct {
+	// Provider is kafka or nats
+	Provider string `yaml:"provider"`
+	// URL of the Kafka REST proxy, e.g. http://rest-proxy:8082, or of the
+	// NATS server, e.g. nats://nats:4222 or tls://nats:4222
+	URL string `yaml:"url"`
+	// Topic of Kafka or subject of NATS, DEFAULT_EVENT_TOPIC when empty
+	Topic string `yaml:"topic"`
+	// UserEnv and PasswordEnv hold the credentials, Basic auth for the REST
+	// proxy. NATS takes a token from PasswordEnv when there's no user.
+	UserEnv     string `yaml:"userEnv"`
+	PasswordEnv string `yaml:"passwordEnv"`
+}
+
+// Validate checks an event publisher config
+func (c *EventPublisherConfig) Validate() error {
+	if c.Provider != PUBLISHER_KAFKA && c.Provider != PUBLISHER_NATS {
+		return fmt.Errorf("unknown event publisher %q, expecting kafka or nats", c.Provider)
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s event publisher needs a url", c.Provider)
+	}
+	switch {
+	case c.Provider == PUBLISHER_KAFKA && u.Scheme != "http" && u.Scheme != "https":
+		return fmt.Errorf("kafka events go through a REST proxy, expecting an http or https url")
+	case c.Provider == PUBLISHER_NATS && u.Scheme != "nats" && u.Scheme != "tls":
+		return fmt.Errorf("nats url %s, expecting nats:// or tls://", c.URL)
+	}
+	return nil
+}
+
+// PublishedEvent is the JSON document published for every event. Fields
+// are only ever added to a schema version.
+type PublishedEvent struct {
+	Schema string `json:"schema"`
+	// ID is unique to the event, for consumers to drop duplicates
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Event
+}
+
+// EventPublisher publishes run events, including an event per applied
+// changeset, for data platforms to follow schema changes as they happen
+type EventPublisher struct {
+	Config *EventPublisherConfig
+	Client *http.Client
+}
+
+// NewEventPublisher creates the publisher of a config
+func NewEventPublisher(cfg *EventPublisherConfig) (*EventPublisher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &EventPublisher{Config: cfg}, nil
+}
+
+// ChangesetEvents subscribes the publisher to the changeset events
+func (p *EventPublisher) ChangesetEvents() bool {
+	return true
+}
+
+// Send publishes an event. Heartbeats aren't published.
+func (p *EventPublisher) Send(event Event) error {
+	if event.Type == EVENT_HEARTBEAT {
+		return nil
+	}
+	source, _ := os.Hostname()
+	data, err := json.Marshal(PublishedEvent{
+		Schema: EVENT_SCHEMA_VERSION,
+		ID:     newRunID(event.Time),
+		Source: "goliquify@" + source,
+		Event:  event,
+	})
+	if err != nil {
+		return err
+	}
+	environment := firstNonEmpty(event.Environment, "default")
+	topic := strings.ReplaceAll(firstNonEmpty(p.Config.Topic, DEFAULT_EVENT_TOPIC), "{env}", environment)
+	if p.Config.Provider == PUBLISHER_KAFKA {
+		return p.publishKafka(topic, environment, data)
+	}
+	return p.publishNATS(topic, data)
+}
+
+// Produce a record through the REST proxy, keyed by environment so that
+// the events of a database stay in order
+func (p *EventPublisher) publishKafka(topic, key string, data []byte) error {
+	body, err := json.Marshal(map[string]any{"records": []map[string]any{{"key": key, "value": json.RawMessage(data)}}})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(p.Config.URL, "/")+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	request.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if p.Config.UserEnv != "" {
+		request.SetBasicAuth(os.Getenv(p.Config.UserEnv), os.Getenv(p.Config.PasswordEnv))
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("kafka: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("kafka REST proxy returned %s", response.Status)
+	}
+	return nil
+}
+
+// Publish a message with the NATS client protocol, waiting for the server
+// to acknowledge it with a PONG
+func (p *EventPublisher) publishNATS(subject string, data []byte) error {
+	u, _ := url.Parse(p.Config.URL)
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("nats: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("nats: unexpected greeting from %s: %q %v", host, strings.TrimSpace(line), err)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if u.Scheme == "tls" || info.TLSRequired {
+		secure := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := secure.Handshake(); err != nil {
+			return fmt.Errorf("nats: %v", err)
+		}
+		conn, reader = secure, bufio.NewReader(secure)
+	}
+
+	connect := map[string]any{"verbose": false, "pedantic": false, "name": "goliquify", "lang": "go", "protocol": 1}
+	user, password := os.Getenv(p.Config.UserEnv), os.Getenv(p.Config.PasswordEnv)
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	switch {
+	case p.Config.UserEnv != "" || user != "":
+		connect["user"], connect["pass"] = user, password
+	case password != "":
+		connect["auth_token"] = password
+	}
+	options, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "CONNECT %s\r\nPUB %s %d\r\n", options, subject, len(data))
+	message.Write(data)
+	message.WriteString("\r\nPING\r\n")
+	if _, err := conn.Write(message.Bytes()); err != nil {
+		return fmt.Errorf("nats: %v", err)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("nats: %v", err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+)
+
+// Commands that only read the database, run with the read-only credentials
+var READ_ONLY_COMMANDS = []string{"status", "history", "validate", "diff", "diff-changelog", "generate-changelog", "snapshot", "snapshot-reference", "db-doc", "list-locks", "tag-exists", "unexpected-changesets"}
+
+// Environment variables Liquibase reads credentials from, taking precedence
+// over the defaults file
+const (
+	LIQUIBASE_USERNAME_ENV = "LIQUIBASE_COMMAND_USERNAME"
+	LIQUIBASE_PASSWORD_ENV = "LIQUIBASE_COMMAND_PASSWORD"
+)
+
+// ReadOnlyCredentials are the database credentials of the commands that
+// only inspect the database, so the write credentials of the defaults file
+// are only used by the commands changing it
+type ReadOnlyCredentials struct {
+	Username string `yaml:"username"`
+	// PasswordEnv is the environment variable holding the password
+	PasswordEnv string `yaml:"passwordEnv"`
+	// Commands run with the read-only credentials, READ_ONLY_COMMANDS when
+	// empty. Entries may be globs, matched like guardrails.
+	Commands []string `yaml:"commands"`
+}
+
+// Validate checks the credentials are complete
+func (c *ReadOnlyCredentials) Validate() error {
+	if c.Username == "" {
+		return fmt.Errorf("readOnly needs a username")
+	}
+	return nil
+}
+
+// Whether a command runs with the read-only credentials
+func (c *ReadOnlyCredentials) uses(command string) bool {
+	if c == nil || command == "" {
+		return false
+	}
+	commands := c.Commands
+	if len(commands) == 0 {
+		commands = READ_ONLY_COMMANDS
+	}
+	for _, pattern := range commands {
+		if commandMatches(pattern, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// The password, a variable that isn't set fails rather than falling back
+// to the write credentials
+func (c *ReadOnlyCredentials) password() (string, error) {
+	if c.PasswordEnv == "" {
+		return "", nil
+	}
+	password, ok := os.LookupEnv(c.PasswordEnv)
+	if !ok {
+		return "", fmt.Errorf("the read-only password variable %s is not set", c.PasswordEnv)
+	}
+	return password, nil
+}
+
+// Hand the read-only credentials to a read-only command through the
+// environment, where they take precedence over the defaults file
+func (pl *GoLiquibase) useReadOnlyCredentials(cmd *Command, command string) error {
+	if !pl.ReadOnly.uses(command) {
+		return nil
+	}
+	password, err := pl.ReadOnly.password()
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, LIQUIBASE_USERNAME_ENV+"="+pl.ReadOnly.Username, LIQUIBASE_PASSWORD_ENV+"="+password)
+	pl.logger().Printf("Running %s with the read-only credentials of %s", command, pl.ReadOnly.Username)
+	return nil
+}
+
+// ReadOnlyDSN is DatabaseDSN with the read-only credentials when they are
+// set, for reading the database straight through database/sql
+func (pl *GoLiquibase) ReadOnlyDSN() (string, string, error) {
+	if pl.ReadOnly == nil {
+		return pl.DatabaseDSN()
+	}
+	jdbcURL, _, _, err := pl.defaultsConnection()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := pl.ReadOnly.password()
+	if err != nil {
+		return "", "", err
+	}
+	return DSNFromJDBC(jdbcURL, pl.ReadOnly.Username, password)
+}
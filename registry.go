@@ -0,0 +1,164 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SCHEMA_RELEASE_VERSION names the format of published schema releases
+const SCHEMA_RELEASE_VERSION = "goliquify.schema/v1"
+
+// Commands whose success publishes the schema
+var PUBLISHED_COMMANDS = []string{"update", "update-count", "update-to-tag"}
+
+// SchemaRegistryConfig publishes the schema of an environment after every
+// successful update, as a JSON document per tag
+type SchemaRegistryConfig struct {
+	// Location is an s3:// or gs:// prefix, an http(s) URL or a directory,
+	// {env} is replaced by the environment. Releases are written to
+	// <location>/<tag>.json and <location>/latest.json.
+	Location string `yaml:"location"`
+	// TokenEnv holds a bearer token for http locations
+	TokenEnv string `yaml:"tokenEnv"`
+}
+
+// Validate checks a schema registry config
+func (c *SchemaRegistryConfig) Validate() error {
+	if c.Location == "" {
+		return fmt.Errorf("the schema registry needs a location")
+	}
+	return nil
+}
+
+// SchemaRelease is the schema of an environment as of a tag
+type SchemaRelease struct {
+	Format      string `json:"format"`
+	Environment string `json:"environment,omitempty"`
+	// Version is the latest tag of the deployment history, the publication
+	// time for untagged deployments
+	Version     string       `json:"version"`
+	Schema      string       `json:"schema"`
+	PublishedAt time.Time    `json:"publishedAt"`
+	Changesets  int          `json:"changesets"`
+	CI          *CIMetadata  `json:"ci,omitempty"`
+	Model       *SchemaModel `json:"model"`
+}
+
+// PublishSchema reads the schema and deployment history of the database
+// and publishes them to the schema registry, returning the locations
+// written
+func (pl *GoLiquibase) PublishSchema(ctx context.Context, openDB func(dialect, dsn string) (*sql.DB, error)) ([]string, error) {
+	c := pl.SchemaRegistry
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	dialect, dsn, err := pl.ReadOnlyDSN()
+	if err != nil {
+		return nil, err
+	}
+	db, err := openDB(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	schema := pl.DefaultSchemaName
+	if schema == "" && dialect == DIALECT_POSTGRES {
+		schema = "public"
+	}
+	model, err := Introspect(ctx, db, dialect, schema)
+	if err != nil {
+		return nil, err
+	}
+	history, err := ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	release := SchemaRelease{
+		Format:      SCHEMA_RELEASE_VERSION,
+		Environment: pl.Environment,
+		Version:     now.Format("20060102T150405Z"),
+		Schema:      schema,
+		PublishedAt: now,
+		Changesets:  len(history),
+		CI:          pl.CI,
+		Model:       model,
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Tag != "" {
+			release.Version = history[i].Tag
+			break
+		}
+	}
+	data, err := json.MarshalIndent(release, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	location := strings.TrimSuffix(strings.ReplaceAll(c.Location, "{env}", firstNonEmpty(pl.Environment, "default")), "/")
+	var written []string
+	for _, name := range []string{release.Version + ".json", "latest.json"} {
+		target := location + "/" + name
+		if err := pl.putRegistryFile(ctx, target, data); err != nil {
+			return written, fmt.Errorf("failed to publish the schema to %s: %v", target, err)
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
+
+// Write a file to the registry location
+func (pl *GoLiquibase) putRegistryFile(ctx context.Context, target string, data []byte) error {
+	switch {
+	case strings.HasPrefix(target, "s3://"), strings.HasPrefix(target, "gs://"):
+		file, err := os.CreateTemp("", "goliquify-schema-*.json")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(target, "s3://") {
+			_, err = pl.runTool(ctx, "aws", "s3", "cp", file.Name(), target, "--content-type", "application/json")
+		} else {
+			_, err = pl.runTool(ctx, "gcloud", "storage", "cp", file.Name(), target, "--content-type=application/json")
+		}
+		return err
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		request, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if token := os.Getenv(pl.SchemaRegistry.TokenEnv); pl.SchemaRegistry.TokenEnv != "" && token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := (&http.Client{Timeout: 30 * time.Second}).Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= 300 {
+			return fmt.Errorf("%s returned %s", target, response.Status)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(target, data, 0644)
+}
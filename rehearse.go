@@ -0,0 +1,349 @@
+package goliquify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Risk levels of a rehearsal
+const (
+	RISK_LOW    = "low"
+	RISK_MEDIUM = "medium"
+	RISK_HIGH   = "high"
+)
+
+// How often the locks of a rehearsal are sampled
+const DEFAULT_LOCK_SAMPLE_INTERVAL = 500 * time.Millisecond
+
+// Table locks held or awaited by the other sessions of the database, which
+// on a clone are Liquibase's
+const POSTGRES_LOCKS_SQL = `SELECT c.relname, l.mode, l.granted
+FROM pg_locks l
+JOIN pg_class c ON c.oid = l.relation
+WHERE l.locktype = 'relation' AND l.pid <> pg_backend_pid()
+  AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+  AND c.relkind IN ('r', 'p', 'm')
+  AND c.relnamespace NOT IN (SELECT oid FROM pg_namespace WHERE nspname IN ('pg_catalog', 'information_schema'))
+  AND c.relname NOT IN ('databasechangelog', 'databasechangeloglock')`
+
+// Lock modes that block reads or writes of a table
+var BLOCKING_LOCK_MODES = []string{"ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"}
+
+// RehearsalConfig is how update is rehearsed for an environment
+type RehearsalConfig struct {
+	Clone CloneConfig `yaml:"clone"`
+	// SlowThreshold flags changesets running longer, 0 flags none
+	SlowThreshold time.Duration `yaml:"slowThreshold"`
+	// LockThreshold flags blocking locks held longer, 0 flags every one
+	LockThreshold time.Duration `yaml:"lockThreshold"`
+	// LargeTableRows is the row count from which tables count as large
+	LargeTableRows int64 `yaml:"largeTableRows"`
+}
+
+// RehearsalOptions configure a rehearsal
+type RehearsalOptions struct {
+	Clone CloneProvider
+	// Keep leaves the clone up for inspection
+	Keep bool
+	// OpenDB opens the clone through database/sql, to find the pending
+	// changesets, analyze their impact and sample locks. Nil skips those.
+	OpenDB         func(dialect, dsn string) (*sql.DB, error)
+	SlowThreshold  time.Duration
+	LockThreshold  time.Duration
+	LargeTableRows int64
+	// Schema of the tables analyzed, the default schema or public by default
+	Schema string
+	// Args are added to the update, e.g. --contexts
+	Args []string
+}
+
+// LockObservation is a table lock seen while the rehearsal ran. The time it
+// was held is measured by sampling, it is approximate.
+type LockObservation struct {
+	Table    string `json:"table"`
+	Mode     string `json:"mode"`
+	HeldMs   int64  `json:"heldMs"`
+	Blocking bool   `json:"blocking"`
+	Waited   bool   `json:"waited,omitempty"`
+}
+
+// RehearsalReport is the pre-deploy risk report of a rehearsal
+type RehearsalReport struct {
+	Environment string            `json:"environment,omitempty"`
+	Clone       string            `json:"clone"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Risk        string            `json:"risk"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	DurationMs  int64             `json:"durationMs"`
+	Pending     int               `json:"pending"`
+	Changesets  []ChangesetTiming `json:"changesets"`
+	SlowCount   int               `json:"slowCount"`
+	Locks       []LockObservation `json:"locks,omitempty"`
+	Findings    []ImpactFinding   `json:"findings,omitempty"`
+	Notes       []string          `json:"notes,omitempty"`
+}
+
+// WriteJSON writes the report to a file
+func (r *RehearsalReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Rate the risk: high when the update failed, an impact finding is an error
+// or a blocking lock was held past the threshold, medium for warnings and
+// slow changesets
+func (r *RehearsalReport) assess(lockThreshold time.Duration) {
+	r.Risk = RISK_LOW
+	if r.SlowCount > 0 {
+		r.Risk = RISK_MEDIUM
+	}
+	for _, f := range r.Findings {
+		if f.Severity == SEVERITY_ERROR {
+			r.Risk = RISK_HIGH
+			return
+		}
+		r.Risk = RISK_MEDIUM
+	}
+	for _, l := range r.Locks {
+		if l.Blocking && l.HeldMs >= lockThreshold.Milliseconds() {
+			r.Risk = RISK_HIGH
+			return
+		}
+	}
+	if r.Status == EVENT_FAILED {
+		r.Risk = RISK_HIGH
+	}
+}
+
+// Rehearse provisions a clone of the database, applies the pending
+// changesets to it, recording their timings and the table locks they take,
+// and tears the clone down. The report is returned even when the update
+// fails on the clone, the error is then the update's.
+func (pl *GoLiquibase) Rehearse(ctx context.Context, opts RehearsalOptions) (*RehearsalReport, error) {
+	start := time.Now()
+	report := &RehearsalReport{Environment: pl.Environment, Status: EVENT_COMPLETED, Start: start}
+	name := "goliquify-rehearsal-" + strings.ToLower(newRunID(start))
+
+	clone, err := opts.Clone.Provision(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision clone %s: %v", name, err)
+	}
+	report.Clone = clone.Name
+	if opts.Keep {
+		pl.logger().Printf("Keeping clone %s at %s", clone.Name, stripURLCredentials(clone.URL))
+	} else {
+		defer func() {
+			pl.logger().Printf("Tearing down clone %s", clone.Name)
+			if err := opts.Clone.Teardown(context.WithoutCancel(ctx), clone); err != nil {
+				pl.logger().Printf("Failed to tear down clone %s: %v", clone.Name, err)
+			}
+		}()
+	}
+
+	dir, err := os.MkdirTemp("", "goliquify-rehearsal-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	rehearsal, err := pl.onClone(clone, dir)
+	if err != nil {
+		return nil, err
+	}
+	rehearsal.TimingReport = filepath.Join(dir, "timings.json")
+	rehearsal.TimingThreshold = opts.SlowThreshold
+
+	var sampler *lockSampler
+	if opts.OpenDB != nil {
+		db, dialect, err := rehearsal.openClone(opts.OpenDB)
+		if err != nil {
+			report.Notes = append(report.Notes, fmt.Sprintf("clone not analyzed: %v", err))
+		} else {
+			defer db.Close()
+			report.Notes = append(report.Notes, rehearsal.analyzeClone(ctx, db, dialect, opts, report)...)
+			if dialect == DIALECT_POSTGRES {
+				sampler = newLockSampler(db)
+			} else {
+				report.Notes = append(report.Notes, "locks are only sampled on Postgres")
+			}
+		}
+	}
+
+	stop := make(chan struct{})
+	var sampled sync.WaitGroup
+	if sampler != nil {
+		sampled.Add(1)
+		go func() {
+			defer sampled.Done()
+			sampler.run(ctx, DEFAULT_LOCK_SAMPLE_INTERVAL, stop)
+		}()
+	}
+	updateErr := rehearsal.ExecuteWithOptions(ctx, ExecOptions{}, append([]string{"update"}, opts.Args...)...)
+	close(stop)
+	sampled.Wait()
+
+	report.End = time.Now()
+	report.DurationMs = report.End.Sub(start).Milliseconds()
+	if updateErr != nil {
+		report.Status, report.Error = EVENT_FAILED, updateErr.Error()
+	}
+	if data, err := os.ReadFile(rehearsal.TimingReport); err == nil {
+		var timings TimingReport
+		if json.Unmarshal(data, &timings) == nil {
+			report.Changesets, report.SlowCount = timings.Changesets, timings.SlowCount
+		}
+	}
+	if sampler != nil {
+		report.Locks = sampler.observations()
+	}
+	report.assess(opts.LockThreshold)
+	return report, updateErr
+}
+
+// Open the clone through database/sql with the credentials of its defaults file
+func (pl *GoLiquibase) openClone(open func(dialect, dsn string) (*sql.DB, error)) (*sql.DB, string, error) {
+	dialect, dsn, err := pl.DatabaseDSN()
+	if err != nil {
+		return nil, "", err
+	}
+	db, err := open(dialect, dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	return db, dialect, nil
+}
+
+// Find the pending changesets of the clone and the impact they have on its
+// tables, returning notes on what couldn't be analyzed
+func (pl *GoLiquibase) analyzeClone(ctx context.Context, db *sql.DB, dialect string, opts RehearsalOptions, report *RehearsalReport) []string {
+	changelog, searchPath := pl.ChangelogLocation(opts.Args...)
+	if changelog == "" {
+		return []string{"no changelog file to find pending changesets in"}
+	}
+	tree, err := LoadChangelogTree(changelog, searchPath)
+	if err != nil {
+		return []string{fmt.Sprintf("changelog not analyzed: %v", err)}
+	}
+	// A clone without a DATABASECHANGELOG table has every changeset pending
+	applied, err := ReadDeploymentHistory(ctx, db, pl.LiquibaseSchemaName)
+	if err != nil {
+		pl.logger().Printf("Treating every changeset as pending: %v", err)
+	}
+	pending := PendingChangeSets(tree, applied)
+	report.Pending = len(pending)
+
+	schema := firstNonEmpty(opts.Schema, pl.DefaultSchemaName)
+	if schema == "" && dialect == DIALECT_POSTGRES {
+		schema = "public"
+	}
+	if schema == "" {
+		if target, err := pl.backupTarget("mysql"); err == nil {
+			schema = target.database
+		}
+	}
+	rowCounts, err := TableRowCounts(ctx, db, dialect, schema)
+	if err != nil {
+		return []string{fmt.Sprintf("impact not analyzed: %v", err)}
+	}
+	if report.Findings, err = AnalyzeImpact(pending, rowCounts, opts.LargeTableRows); err != nil {
+		return []string{fmt.Sprintf("impact not analyzed: %v", err)}
+	}
+	return nil
+}
+
+// lockSampler polls the table locks of the clone while the update runs
+type lockSampler struct {
+	db   *sql.DB
+	mu   sync.Mutex
+	held map[[2]string]*LockObservation
+	// Times each lock was first and last seen
+	first, last map[[2]string]time.Time
+}
+
+func newLockSampler(db *sql.DB) *lockSampler {
+	return &lockSampler{
+		db:    db,
+		held:  map[[2]string]*LockObservation{},
+		first: map[[2]string]time.Time{},
+		last:  map[[2]string]time.Time{},
+	}
+}
+
+// Sample at every interval until stopped
+func (s *lockSampler) run(ctx context.Context, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.sample(ctx, interval)
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Record the locks held now. A lock seen once counts as held for one interval.
+func (s *lockSampler) sample(ctx context.Context, interval time.Duration) {
+	rows, err := s.db.QueryContext(ctx, POSTGRES_LOCKS_SQL)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rows.Next() {
+		var table, mode string
+		var granted bool
+		if rows.Scan(&table, &mode, &granted) != nil {
+			continue
+		}
+		key := [2]string{table, mode}
+		observation, ok := s.held[key]
+		if !ok {
+			observation = &LockObservation{Table: table, Mode: mode, Blocking: containsString(BLOCKING_LOCK_MODES, mode)}
+			s.held[key] = observation
+			s.first[key] = now
+		}
+		observation.Waited = observation.Waited || !granted
+		s.last[key] = now
+		observation.HeldMs = (s.last[key].Sub(s.first[key]) + interval).Milliseconds()
+	}
+}
+
+// The locks seen, the longest held first
+func (s *lockSampler) observations() []LockObservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var observations []LockObservation
+	for _, o := range s.held {
+		observations = append(observations, *o)
+	}
+	sort.Slice(observations, func(i, j int) bool {
+		if observations[i].HeldMs != observations[j].HeldMs {
+			return observations[i].HeldMs > observations[j].HeldMs
+		}
+		return observations[i].Table+observations[i].Mode < observations[j].Table+observations[j].Mode
+	})
+	return observations
+}
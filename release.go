@@ -0,0 +1,134 @@
+package goliquify
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	BUMP_MAJOR = "major"
+	BUMP_MINOR = "minor"
+	BUMP_PATCH = "patch"
+)
+
+// Query listing the tags applied to the database, formatted with the changelog table
+const DEPLOYED_TAGS_SQL = "SELECT TAG FROM %s WHERE TAG IS NOT NULL"
+
+var semverPattern = regexp.MustCompile(`\bv?(\d+)\.(\d+)\.(\d+)\b`)
+
+// Semver is a major.minor.patch version, optionally written with a v prefix
+type Semver struct {
+	Prefix string
+	Major  int
+	Minor  int
+	Patch  int
+}
+
+// ParseSemver parses a tag such as v1.4.2
+func ParseSemver(tag string) (Semver, error) {
+	m := semverPattern.FindStringSubmatch(tag)
+	if m == nil || m[0] != tag {
+		return Semver{}, fmt.Errorf("tag %q is not a semantic version", tag)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	prefix := ""
+	if strings.HasPrefix(tag, "v") {
+		prefix = "v"
+	}
+	return Semver{Prefix: prefix, Major: major, Minor: minor, Patch: patch}, nil
+}
+
+func (v Semver) String() string {
+	return fmt.Sprintf("%s%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+}
+
+// Less reports whether v is an older version than other
+func (v Semver) Less(other Semver) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+// Bump returns the next version for a major, minor or patch release
+func (v Semver) Bump(part string) (Semver, error) {
+	switch part {
+	case BUMP_MAJOR:
+		return Semver{Prefix: v.Prefix, Major: v.Major + 1}, nil
+	case BUMP_MINOR:
+		return Semver{Prefix: v.Prefix, Major: v.Major, Minor: v.Minor + 1}, nil
+	case BUMP_PATCH:
+		return Semver{Prefix: v.Prefix, Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}, nil
+	}
+	return Semver{}, fmt.Errorf("unknown version bump %q, expecting %s, %s or %s", part, BUMP_MAJOR, BUMP_MINOR, BUMP_PATCH)
+}
+
+// Find every semantic version tag in the execute-sql output. Only the result
+// after the "Output of" header is read so version banners are not mistaken for tags.
+func parseSemverTags(output string) []Semver {
+	if _, result, ok := strings.Cut(output, "Output of "); ok {
+		output = result
+	}
+	var versions []Semver
+	for _, match := range semverPattern.FindAllString(output, -1) {
+		if v, err := ParseSemver(match); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// LatestDeployedVersion reads the highest semantic version tag from the
+// database history. The boolean is false when no version has been tagged yet.
+func (pl *GoLiquibase) LatestDeployedVersion() (Semver, bool, error) {
+	// The query only reads, so it runs under a dry run too, and isn't a run
+	// to journal, guard or back up
+	output, err := pl.reader().Output("execute-sql", "--sql="+fmt.Sprintf(DEPLOYED_TAGS_SQL, pl.ChangelogTable()))
+	if err != nil {
+		return Semver{}, false, fmt.Errorf("failed to read deployed tags: %v", err)
+	}
+	versions := parseSemverTags(output)
+	if len(versions) == 0 {
+		return Semver{}, false, nil
+	}
+	latest := versions[0]
+	for _, v := range versions[1:] {
+		if latest.Less(v) {
+			latest = v
+		}
+	}
+	return latest, true, nil
+}
+
+// NextRelease computes the next release tag from the latest deployed one.
+// Without a deployed version the first release is bumped from v0.0.0.
+func (pl *GoLiquibase) NextRelease(bump string) (Semver, error) {
+	latest, found, err := pl.LatestDeployedVersion()
+	if err != nil {
+		return Semver{}, err
+	}
+	if !found {
+		latest = Semver{Prefix: "v"}
+	}
+	return latest.Bump(bump)
+}
+
+// Release tags the database with the next semantic version and returns it
+func (pl *GoLiquibase) Release(bump string) (Semver, error) {
+	next, err := pl.NextRelease(bump)
+	if err != nil {
+		return Semver{}, err
+	}
+	pl.logger().Printf("Tagging release %s", next)
+	if err := pl.Execute("tag", fmt.Sprintf("--tag=%s", next)); err != nil {
+		return Semver{}, err
+	}
+	return next, nil
+}
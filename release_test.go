@@ -0,0 +1,76 @@
+package goliquify_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+func TestLatestDeployedVersionReadsConfiguredTable(t *testing.T) {
+	defaults := filepath.Join(t.TempDir(), "liquibase.properties")
+	if err := os.WriteFile(defaults, []byte("liquibaseSchemaName: tracking\ndatabaseChangeLogTableName: DEPLOYS\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pl, runner := goliquifytest.New(t, goliquify.WithDefaultsFile(defaults))
+	runner.On("execute-sql", goliquifytest.Response{Stdout: "v1.2.0 |\nv1.10.3 |\n"})
+
+	latest, ok, err := pl.LatestDeployedVersion()
+	if err != nil || !ok {
+		t.Fatalf("no deployed version: %v", err)
+	}
+	if latest.String() != "v1.10.3" {
+		t.Fatalf("latest version is %s, want v1.10.3", latest)
+	}
+	sql, _ := runner.Invocations()[0].Arg("sql")
+	if want := "SELECT TAG FROM tracking.DEPLOYS WHERE TAG IS NOT NULL"; sql != want {
+		t.Fatalf("queried %q, want %q", sql, want)
+	}
+}
+
+func TestChangelogTableFromArguments(t *testing.T) {
+	pl := goliquify.New(
+		goliquify.WithLiquibaseSchema("ignored", ""),
+		goliquify.WithArgs("--liquibase-schema-name=audit", "--database-changelog-table-name=CHANGES"),
+	)
+	if table := pl.ChangelogTable(); table != "audit.CHANGES" {
+		t.Fatalf("table is %s, want audit.CHANGES", table)
+	}
+	if table := goliquify.New().ChangelogTable(); table != "DATABASECHANGELOG" {
+		t.Fatalf("table is %s, want DATABASECHANGELOG", table)
+	}
+}
+
+// Refuses every command it sees
+type refusingMiddleware struct{}
+
+func (refusingMiddleware) Before(ctx context.Context, inv *goliquify.Invocation) error {
+	return fmt.Errorf("%s refused", inv.Command)
+}
+
+func (refusingMiddleware) After(ctx context.Context, inv *goliquify.Invocation, result goliquify.InvocationResult) {
+}
+
+func TestLatestDeployedVersionIsNotARun(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "journal.jsonl")
+	events := &eventLog{}
+	pl, runner := goliquifytest.New(t, goliquify.WithJournal(journal), goliquify.WithEventSink(events), goliquify.WithMiddleware(refusingMiddleware{}),
+		goliquify.WithCommandPolicy(&goliquify.CommandPolicy{Deny: []string{"execute-sql"}}, ""),
+		goliquify.WithWindowPolicy(&goliquify.WindowPolicy{Windows: []goliquify.MaintenanceWindow{{Cron: "0 0 31 2 *", Duration: time.Minute}}, Commands: []string{"execute-sql"}}, ""))
+	runner.On("execute-sql", goliquifytest.Response{Stdout: "v1.2.0 |\n"})
+
+	if _, _, err := pl.LatestDeployedVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Fatalf("reading the versions was journaled: %v", err)
+	}
+	if len(events.events) != 0 {
+		t.Fatalf("reading the versions sent %+v", events.events)
+	}
+}
@@ -0,0 +1,64 @@
+package goliquify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// First Liquibase version writing operation reports, which replace Hub's reporting
+const OPERATION_REPORTS_VERSION = "4.26.0"
+
+// Commands that write an operation report
+var REPORT_COMMANDS = []string{
+	"update", "update-count", "update-to-tag", "update-testing-rollback",
+	"rollback", "rollback-count", "rollback-to-date",
+	"diff", "checks-run",
+}
+
+// OperationReports writes an HTML report of each run, the successor of Liquibase Hub
+type OperationReports struct {
+	// Path is the directory reports are written to, Liquibase's working directory by default
+	Path string `yaml:"path"`
+	// Name of the report file, Liquibase names it after the command and time by default
+	Name string `yaml:"name"`
+	// Open the report in a browser when done
+	Open bool `yaml:"open"`
+}
+
+// Check if the version has Hub, versions that can't be parsed are assumed to
+func hubSupported(version string) bool {
+	v, err := ParseSemver(version)
+	return err != nil || removedArgument("hub-mode", false, v) == nil
+}
+
+// Hub was sunset and later versions reject its flags. Turning it off is
+// what those versions do anyway, any other mode is an error.
+func (pl *GoLiquibase) checkHubMode() error {
+	mode := strings.ToLower(pl.LiquibaseHubMode)
+	version := pl.InstalledVersion()
+	if mode == "" || mode == "off" || pl.DisableAnalytics || hubSupported(version) {
+		return nil
+	}
+	return fmt.Errorf("Liquibase Hub was sunset and Liquibase %s has no --hub-mode, use operation reports instead of hub mode %s", version, pl.LiquibaseHubMode)
+}
+
+// Arguments writing an operation report for a command, when enabled
+func (pl *GoLiquibase) reportArgs(command string) ([]string, error) {
+	if pl.OperationReports == nil || !containsString(REPORT_COMMANDS, command) {
+		return nil, nil
+	}
+	if version, err := ParseSemver(pl.InstalledVersion()); err == nil {
+		minimum, _ := ParseSemver(OPERATION_REPORTS_VERSION)
+		if version.Less(minimum) {
+			return nil, fmt.Errorf("operation reports need Liquibase %s or later, this is %s", OPERATION_REPORTS_VERSION, version)
+		}
+	}
+	args := []string{"--report-enabled=true", fmt.Sprintf("--report-open=%t", pl.OperationReports.Open)}
+	if pl.OperationReports.Path != "" {
+		args = append(args, fmt.Sprintf("--report-path=%s", pl.OperationReports.Path))
+	}
+	if pl.OperationReports.Name != "" {
+		args = append(args, fmt.Sprintf("--report-name=%s", pl.OperationReports.Name))
+	}
+	return args, nil
+}
@@ -0,0 +1,239 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RestoreOptions select the run whose pre-migration backup is restored
+type RestoreOptions struct {
+	// RunID is the journal ID of the run
+	RunID string
+	// Tag the changelog is synced to after the restore, none to skip the sync
+	Tag string
+	// TargetInstance is the RDS instance the snapshot is restored to, a new
+	// one named <instance>-restored by default
+	TargetInstance string
+	// AllowSchemaOnly restores schema-only dumps, which recreate the tables empty
+	AllowSchemaOnly bool
+}
+
+// FindRun looks up a run in the journal file by ID
+func FindRun(journal, id string) (*RunRecord, error) {
+	records, err := ReadJournal(journal)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if records[i].ID == id {
+			return &records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("run %s is not in the journal %s", id, journal)
+}
+
+// Restore restores the backup taken before a run and syncs the changelog
+// to a tag, so Liquibase's records match the restored database. Dumps are
+// restored into the database of the defaults file, Cloud SQL backups into
+// their instance. RDS snapshots are restored to a new instance, whose
+// changelog is synced through its endpoint.
+func (pl *GoLiquibase) Restore(ctx context.Context, opts RestoreOptions) error {
+	if pl.JournalFile == "" {
+		return fmt.Errorf("no run journal to find run %s in", opts.RunID)
+	}
+	run, err := FindRun(pl.JournalFile, opts.RunID)
+	if err != nil {
+		return err
+	}
+	backup := run.Backup
+	if backup == nil {
+		return fmt.Errorf("run %s took no backup", opts.RunID)
+	}
+	if backup.SchemaOnly && !opts.AllowSchemaOnly {
+		return fmt.Errorf("the backup of run %s is schema-only, restoring it drops the data of the tables it recreates", opts.RunID)
+	}
+	if opts.Tag != "" {
+		if err := ValidateTag(opts.Tag); err != nil {
+			return err
+		}
+	}
+	// Check the policy and windows up front, a restore must not stop before the sync
+	start := time.Now()
+	for _, command := range []string{"restore", "changelog-sync-to-tag"} {
+		if err := pl.checkPolicy(command); err != nil {
+			return err
+		}
+		if err := pl.checkWindow(command, start); err != nil {
+			return err
+		}
+	}
+	if pl.DryRun {
+		pl.logger().Printf("Dry run: would %s", restorePlan(backup, opts))
+		if opts.Tag != "" {
+			pl.logger().Printf("Dry run: would then sync the changelog to tag %s", opts.Tag)
+		}
+		return nil
+	}
+
+	record := RunRecord{
+		ID:             newRunID(start),
+		Command:        "restore --run " + opts.RunID,
+		Environment:    pl.Environment,
+		Start:          start,
+		Status:         EVENT_COMPLETED,
+		WindowOverride: pl.WindowOverride,
+		CI:             pl.CI,
+		Backup:         backup,
+	}
+	var syncArgs []string
+	if syncArgs, err = pl.restoreBackup(ctx, backup, opts); err == nil && opts.Tag != "" {
+		// The sync is no migration, it takes no backup of its own
+		sync := *pl
+		sync.Backup = nil
+		sync.Args = append(append([]string{}, pl.Args...), syncArgs...)
+		err = sync.ChangelogSyncToTag(opts.Tag)
+	} else if err == nil {
+		pl.logger().Printf("No tag to sync the changelog to, the DATABASECHANGELOG table is as the backup left it")
+	}
+	record.End = time.Now()
+	if err != nil {
+		record.Status, record.Error = EVENT_FAILED, err.Error()
+	}
+	pl.journal(record)
+	if err != nil {
+		return fmt.Errorf("failed to restore the backup of run %s: %v", opts.RunID, err)
+	}
+	return nil
+}
+
+// Describe what restoring a backup does
+func restorePlan(backup *BackupRecord, opts RestoreOptions) string {
+	switch backup.Type {
+	case BACKUP_PG_DUMP, BACKUP_MYSQLDUMP:
+		return fmt.Sprintf("restore %s into the database of the defaults file, replacing the tables it dumped", backup.Reference)
+	case BACKUP_RDS:
+		return fmt.Sprintf("restore RDS snapshot %s to a new instance %s", backup.Reference, firstNonEmpty(opts.TargetInstance, backup.Instance+"-restored"))
+	case BACKUP_CLOUDSQL:
+		return fmt.Sprintf("restore Cloud SQL backup %s into instance %s, replacing its data", backup.Reference, backup.Instance)
+	}
+	return fmt.Sprintf("restore %s backup %s", backup.Type, backup.Reference)
+}
+
+// Restore a backup, returning the arguments the changelog sync needs to
+// reach the restored database
+func (pl *GoLiquibase) restoreBackup(ctx context.Context, backup *BackupRecord, opts RestoreOptions) ([]string, error) {
+	cmd := &Command{Env: os.Environ(), Stdout: pl.logger().Writer(), Stderr: pl.logger().Writer()}
+
+	switch backup.Type {
+	case BACKUP_PG_DUMP, BACKUP_MYSQLDUMP:
+		if !fileExists(backup.Reference) {
+			return nil, fmt.Errorf("dump file %s not found", backup.Reference)
+		}
+		postgres := backup.Type == BACKUP_PG_DUMP
+		scheme := "postgresql"
+		if !postgres {
+			scheme = "mysql"
+		}
+		target, err := pl.backupTarget(scheme)
+		if err != nil {
+			return nil, err
+		}
+		if backup.Database != "" && target.database != backup.Database {
+			return nil, fmt.Errorf("the dump is of database %s, not of %s in %s", backup.Database, target.database, pl.DefaultsFile)
+		}
+		if postgres {
+			cmd.Path = "pg_restore"
+			cmd.Args = []string{"--clean", "--if-exists", "--no-owner", "--single-transaction"}
+		} else {
+			// mysqldump drops and recreates every table it dumped
+			cmd.Path = "mysql"
+			cmd.Args = []string{"--execute=source " + backup.Reference}
+		}
+		target.connect(cmd, postgres)
+		if postgres {
+			cmd.Args = append(cmd.Args, backup.Reference)
+		}
+		pl.logger().Printf("Restoring %s into database %s", backup.Reference, target.database)
+		if err := pl.runner().Run(ctx, cmd); err != nil {
+			return nil, fmt.Errorf("%s failed: %v", cmd.Path, err)
+		}
+		return nil, nil
+
+	case BACKUP_RDS:
+		instance := firstNonEmpty(opts.TargetInstance, backup.Instance+"-restored")
+		var region []string
+		if backup.Region != "" {
+			region = []string{"--region", backup.Region}
+		}
+		cmd.Path = "aws"
+		cmd.Args = append([]string{"rds", "restore-db-instance-from-db-snapshot", "--db-instance-identifier", instance, "--db-snapshot-identifier", backup.Reference}, region...)
+		cmd.Stdout = &bytes.Buffer{}
+		pl.logger().Printf("Restoring RDS snapshot %s to instance %s", backup.Reference, instance)
+		if err := pl.runner().Run(ctx, cmd); err != nil {
+			return nil, fmt.Errorf("failed to restore RDS snapshot %s: %v", backup.Reference, err)
+		}
+		wait := *cmd
+		wait.Args = append([]string{"rds", "wait", "db-instance-available", "--db-instance-identifier", instance}, region...)
+		pl.logger().Printf("Waiting for RDS instance %s to become available", instance)
+		if err := pl.runner().Run(ctx, &wait); err != nil {
+			return nil, fmt.Errorf("RDS instance %s didn't become available: %v", instance, err)
+		}
+		var endpoint bytes.Buffer
+		describe := *cmd
+		describe.Args = append([]string{"rds", "describe-db-instances", "--db-instance-identifier", instance, "--query", "DBInstances[0].Endpoint.Address", "--output", "text"}, region...)
+		describe.Stdout = &endpoint
+		if err := pl.runner().Run(ctx, &describe); err != nil {
+			return nil, fmt.Errorf("failed to look up the endpoint of RDS instance %s: %v", instance, err)
+		}
+		host := strings.TrimSpace(endpoint.String())
+		pl.logger().Printf("RDS instance %s is available at %s, point the applications at it", instance, host)
+		jdbcURL, err := pl.restoredURL(host)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"--url=" + jdbcURL}, nil
+
+	case BACKUP_CLOUDSQL:
+		cmd.Path = "gcloud"
+		cmd.Args = []string{"sql", "backups", "restore", backup.Reference, "--restore-instance=" + backup.Instance, "--quiet"}
+		if backup.Project != "" {
+			cmd.Args = append(cmd.Args, "--project", backup.Project)
+		}
+		pl.logger().Printf("Restoring Cloud SQL backup %s into instance %s", backup.Reference, backup.Instance)
+		if err := pl.runner().Run(ctx, cmd); err != nil {
+			return nil, fmt.Errorf("failed to restore Cloud SQL backup %s: %v", backup.Reference, err)
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown backup type %q", backup.Type)
+}
+
+// The defaults file url with its host replaced by the restored instance's
+func (pl *GoLiquibase) restoredURL(host string) (string, error) {
+	props, err := ReadDefaultsFile(pl.DefaultsFile)
+	if err != nil {
+		return "", err
+	}
+	jdbcURL := firstNonEmpty(props["url"], props["liquibase.command.url"])
+	m := jdbcURLPattern.FindStringSubmatch(jdbcURL)
+	if m == nil {
+		return "", fmt.Errorf("%s has no postgresql or mysql url to point at the restored instance", pl.DefaultsFile)
+	}
+	hostPort := m[2]
+	if at := strings.LastIndex(hostPort, "@"); at >= 0 {
+		host = hostPort[:at+1] + host
+		hostPort = hostPort[at+1:]
+	}
+	if _, port, ok := strings.Cut(hostPort, ":"); ok {
+		host += ":" + port
+	}
+	restored := fmt.Sprintf("jdbc:%s://%s/%s", m[1], host, m[3])
+	if m[4] != "" {
+		restored += "?" + m[4]
+	}
+	return restored, nil
+}
@@ -0,0 +1,69 @@
+package goliquify_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+// Set up a journal with a run that took a pg_dump backup
+func journalWithBackup(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "before.dump")
+	defaults := filepath.Join(dir, "liquibase.properties")
+	journal := filepath.Join(dir, "journal.jsonl")
+	if err := os.WriteFile(dump, []byte("dump"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(defaults, []byte("url: jdbc:postgresql://localhost:5432/app\nusername: app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	record := goliquify.RunRecord{ID: "run1", Command: "update", Start: time.Now(), End: time.Now(), Status: goliquify.EVENT_COMPLETED,
+		Backup: &goliquify.BackupRecord{Type: goliquify.BACKUP_PG_DUMP, Reference: dump, Database: "app", Time: time.Now()}}
+	if err := goliquify.AppendJournal(journal, record); err != nil {
+		t.Fatal(err)
+	}
+	return journal, defaults
+}
+
+func TestRestoreChecksThePolicy(t *testing.T) {
+	journal, defaults := journalWithBackup(t)
+	pl, runner := goliquifytest.New(t, goliquify.WithJournal(journal), goliquify.WithDefaultsFile(defaults),
+		goliquify.WithCommandPolicy(&goliquify.CommandPolicy{Deny: []string{"restore"}}, ""))
+
+	err := pl.Restore(context.Background(), goliquify.RestoreOptions{RunID: "run1"})
+	var policyErr *goliquify.PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("restore failed with %v, want a policy error", err)
+	}
+	if invocations := runner.Invocations(); len(invocations) != 0 {
+		t.Fatalf("refused restore ran %v", invocations)
+	}
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	journal, defaults := journalWithBackup(t)
+	pl, runner := goliquifytest.New(t, goliquify.WithJournal(journal), goliquify.WithDefaultsFile(defaults), goliquify.WithDryRun(true))
+	if err := pl.Restore(context.Background(), goliquify.RestoreOptions{RunID: "run1", Tag: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if invocations := runner.Invocations(); len(invocations) != 0 {
+		t.Fatalf("dry run ran %v", invocations)
+	}
+
+	// The same restore without a dry run does run pg_restore
+	pl.DryRun = false
+	if err := pl.Restore(context.Background(), goliquify.RestoreOptions{RunID: "run1"}); err != nil {
+		t.Fatal(err)
+	}
+	if invocations := runner.Invocations(); len(invocations) != 1 {
+		t.Fatalf("restore ran %v, want pg_restore", invocations)
+	}
+}
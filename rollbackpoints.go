@@ -0,0 +1,83 @@
+package goliquify
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rollback points listed by default
+const DEFAULT_ROLLBACK_POINTS = 10
+
+// RollbackPoint is a state of the deployment history a database can be
+// rolled back to: a tag, or the state before a deployment
+type RollbackPoint struct {
+	// Tag rolled back to, empty for the state before a deployment
+	Tag string `json:"tag,omitempty"`
+	// DeploymentID of the deployment rolled back first, for points before one
+	DeploymentID string     `json:"deploymentId,omitempty"`
+	DateExecuted *time.Time `json:"dateExecuted,omitempty"`
+	// ChangeSets rolled back to reach the point, the latest first
+	ChangeSets []HistoryEntry `json:"changesets"`
+}
+
+// String describes the point for picking one
+func (p RollbackPoint) String() string {
+	what := "before deployment " + p.DeploymentID
+	if p.Tag != "" {
+		what = "tag " + p.Tag
+		if p.DeploymentID != "" {
+			what += ", before deployment " + p.DeploymentID
+		}
+	}
+	if p.DateExecuted != nil {
+		what += fmt.Sprintf(" (%s)", p.DateExecuted.Local().Format("2006-01-02 15:04"))
+	}
+	return fmt.Sprintf("%s, rolls back %d changeset(s)", what, len(p.ChangeSets))
+}
+
+// Args returns the Liquibase command rolling back to the point, or its SQL
+// variant printing the rollback SQL instead
+func (p RollbackPoint) Args(sql bool) []string {
+	command, arg := "rollback", "--tag="+p.Tag
+	if p.Tag == "" {
+		command, arg = "rollback-count", fmt.Sprintf("--count=%d", len(p.ChangeSets))
+	}
+	if sql {
+		command += "-sql"
+	}
+	return []string{command, arg}
+}
+
+// RollbackPoints lists the latest points of a deployment history, the most
+// recent first: every tag, and the state before every deployment. Rolling
+// back to a point undoes the changesets applied after it. At most limit
+// points are returned, all of them when limit is 0.
+func RollbackPoints(history []HistoryEntry, limit int) []RollbackPoint {
+	var points []RollbackPoint
+	for i := len(history) - 1; i >= 0 && (limit == 0 || len(points) < limit); i-- {
+		// The state before row i is a point when a tag marks the row before
+		// it, or when row i starts a deployment. Tags are preferred to roll
+		// back to, they don't depend on counting rows.
+		p := RollbackPoint{DateExecuted: history[i].DateExecuted}
+		if i > 0 {
+			p.Tag = history[i-1].Tag
+		}
+		if i == 0 || history[i-1].DeploymentID != history[i].DeploymentID {
+			p.DeploymentID = history[i].DeploymentID
+		}
+		if p.Tag == "" && p.DeploymentID == "" {
+			continue
+		}
+		p.ChangeSets = latestFirst(history[i:])
+		points = append(points, p)
+	}
+	return points
+}
+
+func latestFirst(entries []HistoryEntry) []HistoryEntry {
+	reversed := make([]HistoryEntry, len(entries))
+	for i, e := range entries {
+		reversed[len(entries)-1-i] = e
+	}
+	return reversed
+}
@@ -0,0 +1,177 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DEFAULT_ROLLOUT_STATE_FILE = ".goliquify/rollout.json"
+
+// RolloutState is the progress of a command run against a fleet of targets,
+// written after every target so an interrupted rollout can be resumed
+type RolloutState struct {
+	Command string    `json:"command"`
+	Args    []string  `json:"args,omitempty"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+	// Results holds the latest result of every target, in the order they ran
+	Results []TargetResult `json:"results"`
+}
+
+// RolloutOptions configure where the progress of a rollout is kept and
+// whether an earlier one is resumed
+type RolloutOptions struct {
+	// StateFile is DEFAULT_ROLLOUT_STATE_FILE when empty
+	StateFile string
+	// Resume skips the targets the rollout in the state file already
+	// succeeded or failed for
+	Resume bool
+	// RetryFailed resumes the rollout, running the failed targets again
+	RetryFailed bool
+}
+
+func (o RolloutOptions) stateFile() string {
+	return firstNonEmpty(o.StateFile, DEFAULT_ROLLOUT_STATE_FILE)
+}
+
+// ReadRolloutState reads the state of a rollout, nil when there is none
+func ReadRolloutState(path string) (*RolloutState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &RolloutState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse rollout state %s: %v", path, err)
+	}
+	return state, nil
+}
+
+// Write the state, replacing the file at once so an interruption never
+// leaves half of it behind
+func (s *RolloutState) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Result returns the latest result of a target, nil when it never ran
+func (s *RolloutState) Result(target string) *TargetResult {
+	for i := range s.Results {
+		if s.Results[i].Target == target {
+			return &s.Results[i]
+		}
+	}
+	return nil
+}
+
+func (s *RolloutState) record(result TargetResult) {
+	if r := s.Result(result.Target); r != nil {
+		*r = result
+	} else {
+		s.Results = append(s.Results, result)
+	}
+	s.Updated = time.Now()
+}
+
+// RolloutTargets runs a command against targets in order like RunTargets,
+// optionally as a canary rollout, recording the result of every target in
+// the state file as it goes. Resuming runs the command only for the targets
+// the earlier rollout didn't succeed for, leaving failed ones alone unless
+// they are retried. The results cover every target, the ones resumed past
+// with their earlier result.
+func (pl *GoLiquibase) RolloutTargets(ctx context.Context, opts RolloutOptions, canary CanaryOptions, targets []*Target, command string, arguments ...string) ([]TargetResult, error) {
+	path := opts.stateFile()
+	state := &RolloutState{Command: command, Args: arguments, Started: time.Now()}
+	if opts.Resume || opts.RetryFailed {
+		previous, err := ReadRolloutState(path)
+		if err != nil {
+			return nil, err
+		}
+		if previous == nil {
+			return nil, fmt.Errorf("no rollout to resume in %s", path)
+		}
+		if previous.Command != command || !slices.Equal(previous.Args, arguments) {
+			return nil, fmt.Errorf("the rollout in %s ran %s, not %s, start a new one instead of resuming it", path, strings.Join(append([]string{previous.Command}, previous.Args...), " "), strings.Join(append([]string{command}, arguments...), " "))
+		}
+		state = previous
+	}
+
+	done := map[string]bool{}
+	var pending []string
+	byName := map[string]*Target{}
+	for _, t := range targets {
+		byName[t.Name] = t
+		previous := state.Result(t.Name)
+		switch {
+		case previous != nil && previous.Status == "success":
+			done[t.Name] = true
+		case previous != nil && previous.Status == "failed" && !opts.RetryFailed:
+		default:
+			pending = append(pending, t.Name)
+		}
+	}
+	if opts.Resume || opts.RetryFailed {
+		pl.logger().Printf("Resuming the rollout of %s: %d target(s) succeeded, %d left to run", state.Started.Local().Format("2006-01-02 15:04"), len(done), len(pending))
+	}
+	if err := state.Write(path); err != nil {
+		return nil, err
+	}
+
+	// Targets run one at a time, so the state is written after each
+	run := func(wave []string, _ map[string]bool) []TargetResult {
+		var results []TargetResult
+		for _, name := range wave {
+			result := pl.runTargets(targets, []*Target{byName[name]}, done, command, arguments...)[0]
+			if result.Status == "success" {
+				done[name] = true
+			}
+			state.record(result)
+			if err := state.Write(path); err != nil {
+				pl.logger().Printf("Failed to write the rollout state %s: %v", path, err)
+			}
+			results = append(results, result)
+		}
+		return results
+	}
+	ran := map[string]TargetResult{}
+	if len(pending) > 0 {
+		for _, r := range pl.Canary(ctx, canary, pending, run) {
+			ran[r.Target] = r
+			if r.Status == TARGET_STATUS_HALTED {
+				state.record(r)
+			}
+		}
+		if err := state.Write(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []TargetResult
+	for _, t := range targets {
+		if r, ok := ran[t.Name]; ok {
+			results = append(results, r)
+		} else if r := state.Result(t.Name); r != nil {
+			results = append(results, *r)
+		}
+	}
+	return results, nil
+}
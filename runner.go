@@ -0,0 +1,38 @@
+package goliquify
+
+import (
+	"context"
+	"io"
+	"os/exec"
+)
+
+// Command is a single invocation of the Liquibase launcher
+type Command struct {
+	Path   string
+	Args   []string
+	Env    []string
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runner runs Liquibase commands. The default runs the launcher as a child
+// process, other implementations can be plugged in with WithRunner.
+type Runner interface {
+	Run(ctx context.Context, cmd *Command) error
+}
+
+// ExecRunner runs commands as child processes
+type ExecRunner struct{}
+
+// Run starts the command and waits for it to finish
+func (ExecRunner) Run(ctx context.Context, c *Command) error {
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = c.Env
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	return cmd.Run()
+}
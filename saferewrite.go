@@ -0,0 +1,200 @@
+package goliquify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Lock timeout of rewritten SQL when none is set
+const DEFAULT_LOCK_TIMEOUT = 5 * time.Second
+
+// Commands whose generated SQL is rewritten
+var SAFE_REWRITE_COMMANDS = []string{"update-sql", "updateSQL", "update-count-sql", "updateCountSQL", "update-to-tag-sql", "updateToTagSQL"}
+
+var (
+	safeCreateIndexPattern   = regexp.MustCompile(`(?is)^(create\s+(?:unique\s+)?index)\s+(concurrently\b)?`)
+	safeAddConstraintPattern = regexp.MustCompile(`(?is)^alter\s+table\s+(?:only\s+)?(\S+)\s+add\s+constraint\s+(\S+)\s+(?:foreign\s+key|check)\b`)
+	notValidPattern          = regexp.MustCompile(`(?i)\bnot\s+valid\b`)
+	dollarQuotePattern       = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+)
+
+// SafeRewriteOptions turn on rewriting the SQL generated for Postgres into
+// forms that hold locks briefly
+type SafeRewriteOptions struct {
+	// LockTimeout bounds how long each statement waits for its locks, DEFAULT_LOCK_TIMEOUT when zero
+	LockTimeout time.Duration
+}
+
+// RewritePostgresSafe rewrites SQL for Postgres into safer equivalents:
+// indexes are built CONCURRENTLY, foreign key and check constraints are
+// added NOT VALID and validated by a separate statement, and the whole
+// script runs under a lock_timeout. Returns the SQL and notes on what
+// was rewritten, for review.
+func RewritePostgresSafe(script string, opts SafeRewriteOptions) (string, []string) {
+	timeout := opts.LockTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_LOCK_TIMEOUT
+	}
+	var out strings.Builder
+	var notes []string
+	fmt.Fprintf(&out, "-- Rewritten by goliquify for Postgres, review before running\nSET lock_timeout = '%dms';\n", timeout.Milliseconds())
+	for _, statement := range splitSQLStatements(script) {
+		// Comments before a statement are kept as they are
+		body := strings.TrimLeft(stripLeadingComments(statement), " \t\r\n")
+		prefix := statement[:len(statement)-len(body)]
+
+		if m := safeCreateIndexPattern.FindStringSubmatchIndex(body); m != nil && m[4] < 0 {
+			body = body[:m[3]] + " CONCURRENTLY" + body[m[3]:]
+			notes = append(notes, fmt.Sprintf("index built CONCURRENTLY, its changeset must set runInTransaction to false: %s", firstLine(body)))
+		} else if m := safeAddConstraintPattern.FindStringSubmatch(body); m != nil && !notValidPattern.MatchString(body) {
+			body = strings.TrimRight(body, " \t\r\n")
+			terminated := strings.HasSuffix(body, ";")
+			body = strings.TrimSuffix(body, ";") + " NOT VALID;\n" + fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", m[1], m[2])
+			if terminated {
+				body += ";"
+			}
+			body += "\n"
+			notes = append(notes, fmt.Sprintf("constraint %s added NOT VALID and validated separately", m[2]))
+		}
+		out.WriteString(prefix + body)
+	}
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	out.WriteString("RESET lock_timeout;\n")
+	return out.String(), notes
+}
+
+// Split a script into statements, each keeping its terminator and the
+// comments and whitespace before it. Semicolons in quotes, comments and
+// dollar quoted bodies don't end a statement.
+func splitSQLStatements(script string) []string {
+	var statements []string
+	start := 0
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"':
+			if end := strings.IndexByte(script[i+1:], c); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(script)
+			}
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			if end := strings.IndexByte(script[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(script)
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			if end := strings.Index(script[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(script)
+			}
+		case c == '$':
+			if tag := dollarQuotePattern.FindString(script[i:]); tag != "" {
+				if end := strings.Index(script[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(script)
+				}
+			}
+		case c == ';':
+			// The line break after the terminator stays with the statement
+			end := i + 1
+			if strings.HasPrefix(script[end:], "\r\n") {
+				end += 2
+			} else if strings.HasPrefix(script[end:], "\n") {
+				end++
+			}
+			statements = append(statements, script[start:end])
+			start, i = end, end-1
+		}
+	}
+	if start < len(script) {
+		statements = append(statements, script[start:])
+	}
+	return statements
+}
+
+// Strip the comments and whitespace a statement starts with
+func stripLeadingComments(statement string) string {
+	for {
+		trimmed := strings.TrimLeft(statement, " \t\r\n")
+		switch {
+		case strings.HasPrefix(trimmed, "--"):
+			end := strings.IndexByte(trimmed, '\n')
+			if end < 0 {
+				return ""
+			}
+			statement = trimmed[end+1:]
+		case strings.HasPrefix(trimmed, "/*"):
+			end := strings.Index(trimmed, "*/")
+			if end < 0 {
+				return ""
+			}
+			statement = trimmed[end+2:]
+		default:
+			return statement
+		}
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSpace(line)
+}
+
+// Check if the safe rewrite applies to a command, only Postgres SQL is rewritten
+func (pl *GoLiquibase) safeRewrites(command string) bool {
+	if pl.SafeRewrite == nil || !containsString(SAFE_REWRITE_COMMANDS, command) {
+		return false
+	}
+	if dialect, _, err := pl.DatabaseDSN(); err == nil && dialect != DIALECT_POSTGRES {
+		pl.logger().Printf("Not rewriting the SQL of %s for Postgres, the database is %s", command, dialect)
+		return false
+	}
+	return true
+}
+
+// Rewrite generated SQL and write it out, with the notes logged. No output,
+// as with --output-file, stays empty.
+func (pl *GoLiquibase) writeSafeRewrite(w io.Writer, script []byte) error {
+	if len(bytes.TrimSpace(script)) == 0 {
+		_, err := w.Write(script)
+		return err
+	}
+	rewritten, notes := RewritePostgresSafe(string(script), *pl.SafeRewrite)
+	for _, note := range notes {
+		pl.logger().Printf("Safe rewrite: %s", note)
+	}
+	_, err := io.WriteString(w, rewritten)
+	return err
+}
+
+// Rewrite the SQL Liquibase wrote to an --output-file in place
+func (pl *GoLiquibase) rewriteOutputFile(arguments []string) error {
+	var path string
+	for _, arg := range arguments {
+		if value, ok := strings.CutPrefix(arg, "--output-file="); ok {
+			path = value
+		}
+	}
+	if path == "" {
+		return nil
+	}
+	script, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rewritten bytes.Buffer
+	if err := pl.writeSafeRewrite(&rewritten, script); err != nil {
+		return err
+	}
+	return os.WriteFile(path, rewritten.Bytes(), 0644)
+}
@@ -0,0 +1,162 @@
+package goliquify_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	goliquify "github.com/TFMV/GoLiquify"
+	"github.com/TFMV/GoLiquify/goliquifytest"
+)
+
+const safeRewriteHeader = "-- Rewritten by goliquify for Postgres, review before running\nSET lock_timeout = '5000ms';\n"
+
+func TestRewritePostgresSafe(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		want   string
+		notes  []string
+	}{
+		{
+			name:   "index",
+			script: "CREATE INDEX idx_orders_user ON public.orders(user_id);\n",
+			want:   "CREATE INDEX CONCURRENTLY idx_orders_user ON public.orders(user_id);\n",
+			notes:  []string{"index built CONCURRENTLY, its changeset must set runInTransaction to false: CREATE INDEX CONCURRENTLY idx_orders_user ON public.orders(user_id);"},
+		},
+		{
+			name:   "unique index after a comment",
+			script: "-- Changeset db/changelog.xml::2::bob\ncreate unique index idx_users_email on users(email);\n",
+			want:   "-- Changeset db/changelog.xml::2::bob\ncreate unique index CONCURRENTLY idx_users_email on users(email);\n",
+			notes:  []string{"index built CONCURRENTLY, its changeset must set runInTransaction to false: create unique index CONCURRENTLY idx_users_email on users(email);"},
+		},
+		{
+			name:   "concurrent index",
+			script: "CREATE INDEX CONCURRENTLY idx_orders_user ON orders(user_id);\n",
+			want:   "CREATE INDEX CONCURRENTLY idx_orders_user ON orders(user_id);\n",
+		},
+		{
+			name:   "foreign key",
+			script: "ALTER TABLE public.orders ADD CONSTRAINT fk_orders_users FOREIGN KEY (user_id) REFERENCES public.users (id);\n",
+			want:   "ALTER TABLE public.orders ADD CONSTRAINT fk_orders_users FOREIGN KEY (user_id) REFERENCES public.users (id) NOT VALID;\nALTER TABLE public.orders VALIDATE CONSTRAINT fk_orders_users;\n",
+			notes:  []string{"constraint fk_orders_users added NOT VALID and validated separately"},
+		},
+		{
+			name:   "check constraint without a terminator",
+			script: "ALTER TABLE ONLY orders ADD CONSTRAINT chk_amount CHECK (amount > 0)",
+			want:   "ALTER TABLE ONLY orders ADD CONSTRAINT chk_amount CHECK (amount > 0) NOT VALID;\nALTER TABLE orders VALIDATE CONSTRAINT chk_amount\n",
+			notes:  []string{"constraint chk_amount added NOT VALID and validated separately"},
+		},
+		{
+			name:   "constraint already not valid",
+			script: "ALTER TABLE orders ADD CONSTRAINT chk_amount CHECK (amount > 0) NOT VALID;\n",
+			want:   "ALTER TABLE orders ADD CONSTRAINT chk_amount CHECK (amount > 0) NOT VALID;\n",
+		},
+		{
+			name:   "primary key",
+			script: "ALTER TABLE orders ADD CONSTRAINT pk_orders PRIMARY KEY (id);\n",
+			want:   "ALTER TABLE orders ADD CONSTRAINT pk_orders PRIMARY KEY (id);\n",
+		},
+		{
+			// Semicolons in quotes, comments and function bodies don't end a statement
+			name: "quoted semicolons",
+			script: "INSERT INTO notes (body) VALUES ('a; CREATE INDEX x ON t(c)');\n" +
+				"/* ; CREATE INDEX y ON t(c); */\n" +
+				"CREATE FUNCTION f() RETURNS void AS $body$ BEGIN CREATE INDEX z ON t(c); END $body$ LANGUAGE plpgsql;\n" +
+				"CREATE INDEX idx_t ON t(c);\n",
+			want: "INSERT INTO notes (body) VALUES ('a; CREATE INDEX x ON t(c)');\n" +
+				"/* ; CREATE INDEX y ON t(c); */\n" +
+				"CREATE FUNCTION f() RETURNS void AS $body$ BEGIN CREATE INDEX z ON t(c); END $body$ LANGUAGE plpgsql;\n" +
+				"CREATE INDEX CONCURRENTLY idx_t ON t(c);\n",
+			notes: []string{"index built CONCURRENTLY, its changeset must set runInTransaction to false: CREATE INDEX CONCURRENTLY idx_t ON t(c);"},
+		},
+	} {
+		rewritten, notes := goliquify.RewritePostgresSafe(tc.script, goliquify.SafeRewriteOptions{})
+		if want := safeRewriteHeader + tc.want + "RESET lock_timeout;\n"; rewritten != want {
+			t.Errorf("%s: rewritten\n%s\nwant\n%s", tc.name, rewritten, want)
+		}
+		if !reflect.DeepEqual(notes, tc.notes) {
+			t.Errorf("%s: notes %q, want %q", tc.name, notes, tc.notes)
+		}
+	}
+}
+
+func TestRewritePostgresSafeLockTimeout(t *testing.T) {
+	rewritten, _ := goliquify.RewritePostgresSafe("SELECT 1;\n", goliquify.SafeRewriteOptions{LockTimeout: 2 * time.Minute})
+	if !strings.Contains(rewritten, "SET lock_timeout = '120000ms';\n") {
+		t.Fatalf("rewritten\n%s", rewritten)
+	}
+}
+
+// A defaults file pointing at a database of a dialect
+func dialectDefaults(t *testing.T, url string) goliquify.Option {
+	path := filepath.Join(t.TempDir(), "liquibase.properties")
+	if err := os.WriteFile(path, []byte("url="+url+"\nusername=app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return goliquify.WithDefaultsFile(path)
+}
+
+func TestSafeRewriteOfGeneratedSQL(t *testing.T) {
+	const script = "CREATE INDEX idx_orders_user ON orders(user_id);\n"
+	rewritten := safeRewriteHeader + "CREATE INDEX CONCURRENTLY idx_orders_user ON orders(user_id);\nRESET lock_timeout;\n"
+	postgres := dialectDefaults(t, "jdbc:postgresql://localhost/app")
+	for _, tc := range []struct {
+		name    string
+		command string
+		opts    []goliquify.Option
+		fails   bool
+		want    string
+	}{
+		{name: "update-sql", command: "update-sql", opts: []goliquify.Option{postgres, goliquify.WithSafeRewrite(&goliquify.SafeRewriteOptions{})}, want: rewritten},
+		{name: "legacy name", command: "updateSQL", opts: []goliquify.Option{postgres, goliquify.WithSafeRewrite(&goliquify.SafeRewriteOptions{})}, want: rewritten},
+		{name: "not turned on", command: "update-sql", opts: []goliquify.Option{postgres}, want: script},
+		{name: "other command", command: "rollback-sql", opts: []goliquify.Option{postgres, goliquify.WithSafeRewrite(&goliquify.SafeRewriteOptions{})}, want: script},
+		{name: "mysql", command: "update-sql", opts: []goliquify.Option{dialectDefaults(t, "jdbc:mysql://localhost/app"), goliquify.WithSafeRewrite(&goliquify.SafeRewriteOptions{})}, want: script},
+		// A failed run's partial SQL is passed through for the error to be seen
+		{name: "failed run", command: "update-sql", opts: []goliquify.Option{postgres, goliquify.WithSafeRewrite(&goliquify.SafeRewriteOptions{})}, fails: true, want: script},
+	} {
+		pl, runner := goliquifytest.New(t, tc.opts...)
+		response := goliquifytest.Response{Stdout: script}
+		if tc.fails {
+			response.ExitCode = 1
+		}
+		runner.On(tc.command, response)
+		var stdout bytes.Buffer
+		err := pl.ExecuteWithOptions(context.Background(), goliquify.ExecOptions{Stdout: &stdout}, tc.command)
+		if (err != nil) != tc.fails {
+			t.Fatalf("%s: unexpected error %v", tc.name, err)
+		}
+		if stdout.String() != tc.want {
+			t.Errorf("%s: output\n%s\nwant\n%s", tc.name, stdout.String(), tc.want)
+		}
+	}
+}
+
+func TestSafeRewriteOfOutputFile(t *testing.T) {
+	pl, runner := goliquifytest.New(t, dialectDefaults(t, "jdbc:postgresql://localhost/app"), goliquify.WithSafeRewrite(&goliquify.SafeRewriteOptions{}))
+	// The fake runner writes nothing, the file stands for what Liquibase wrote
+	path := filepath.Join(t.TempDir(), "update.sql")
+	if err := os.WriteFile(path, []byte("ALTER TABLE orders ADD CONSTRAINT fk_orders_users FOREIGN KEY (user_id) REFERENCES users (id);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pl.Execute("update-sql", "--output-file="+path); err != nil {
+		t.Fatal(err)
+	}
+	if runner.Commands()[0] != "update-sql" {
+		t.Fatalf("ran %v", runner.Commands())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := safeRewriteHeader + "ALTER TABLE orders ADD CONSTRAINT fk_orders_users FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;\nALTER TABLE orders VALIDATE CONSTRAINT fk_orders_users;\nRESET lock_timeout;\n"
+	if string(content) != want {
+		t.Fatalf("output file\n%s\nwant\n%s", content, want)
+	}
+}
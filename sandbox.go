@@ -0,0 +1,165 @@
+package goliquify
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Flags whose value is a path relative to the working directory. In a sandbox
+// they are made absolute so they still point at the caller's files.
+var SANDBOX_PATH_FLAGS = []string{"--defaults-file", "--output-file", "--reports-path", "--snapshot-file"}
+
+// Directories of the install commands write to, e.g. dropping drivers into
+// lib. A sandbox copies them, sharing the files would change the cache.
+var SANDBOX_WRITABLE_DIRS = []string{"lib", filepath.Join("internal", "lib")}
+
+// Commands whose --changelog-file is written rather than looked up on the search path
+var CHANGELOG_WRITING_COMMANDS = []string{"generate-changelog", "generateChangeLog", "diff-changelog", "diffChangeLog"}
+
+// sandbox is a private working directory and Liquibase home for one command
+type sandbox struct {
+	dir  string
+	home string
+	work string
+	tmp  string
+}
+
+// Set up a sandbox with a scratch copy of the Liquibase install. The writable
+// directories are copied so writes stay in the sandbox, the other files are
+// hard linked where possible to keep the copy cheap. Hard links share their
+// content with the cache, so only files nothing writes to are linked.
+func newSandbox(liquibaseDir string) (*sandbox, error) {
+	dir, err := os.MkdirTemp("", "goliquify-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	s := &sandbox{
+		dir:  dir,
+		home: filepath.Join(dir, "liquibase"),
+		work: filepath.Join(dir, "work"),
+		tmp:  filepath.Join(dir, "tmp"),
+	}
+	for _, d := range []string{s.work, s.tmp} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if err := linkTree(liquibaseDir, s.home); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to set up the Liquibase sandbox: %v", err)
+	}
+	return s, nil
+}
+
+// Run the command inside the sandbox. cwd is the caller's working directory,
+// relative paths in the arguments are resolved against it.
+func (s *sandbox) apply(cmd *Command, cwd string, command string) {
+	cmd.Path = filepath.Join(s.home, filepath.Base(cmd.Path))
+	cmd.Dir = s.work
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	javaOpts := strings.TrimSpace(os.Getenv("JAVA_OPTS") + " -Djava.io.tmpdir=" + s.tmp)
+	cmd.Env = append(env, "LIQUIBASE_HOME="+s.home, "JAVA_OPTS="+javaOpts)
+
+	writesChangelog := false
+	for _, c := range CHANGELOG_WRITING_COMMANDS {
+		writesChangelog = writesChangelog || c == command
+	}
+	hasSearchPath := false
+	args := make([]string, 0, len(cmd.Args)+1)
+	for _, arg := range cmd.Args {
+		flag, value, ok := strings.Cut(arg, "=")
+		switch {
+		case !ok:
+		case flag == "--search-path":
+			hasSearchPath = true
+			paths := strings.Split(value, ",")
+			for i, p := range paths {
+				paths[i] = absolutePath(cwd, p)
+			}
+			arg = flag + "=" + strings.Join(paths, ",")
+		case flag == "--changelog-file" && writesChangelog:
+			arg = flag + "=" + absolutePath(cwd, value)
+		default:
+			for _, f := range SANDBOX_PATH_FLAGS {
+				if flag == f {
+					arg = flag + "=" + absolutePath(cwd, value)
+				}
+			}
+		}
+		args = append(args, arg)
+	}
+	// Liquibase searches the working directory by default, keep finding the caller's changelogs
+	if !hasSearchPath {
+		args = append([]string{"--search-path=" + cwd}, args...)
+	}
+	cmd.Args = args
+}
+
+// Remove the sandbox
+func (s *sandbox) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// Resolve a path against a directory unless it is absolute or a URL
+func absolutePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) || strings.Contains(path, "://") {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Recreate a directory tree, copying the files of SANDBOX_WRITABLE_DIRS and
+// hard linking the others, or copying them too when links are not possible
+// (e.g. across file systems)
+func linkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !containsString(SANDBOX_WRITABLE_DIRS, filepath.Dir(rel)) {
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// Copy a file keeping its permissions
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,50 @@
+package goliquify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandboxWritesDontReachTheCache(t *testing.T) {
+	install := t.TempDir()
+	files := map[string]string{
+		"liquibase":                        "#!/bin/sh\n",
+		filepath.Join("lib", "driver.jar"): "driver",
+		filepath.Join("internal", "lib", "liquibase-core.jar"): "core",
+	}
+	for name, content := range files {
+		path := filepath.Join(install, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := newSandbox(install)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// Writing in place, as installing a driver over an old one does
+	for _, name := range []string{filepath.Join("lib", "driver.jar"), filepath.Join("internal", "lib", "liquibase-core.jar")} {
+		if err := os.WriteFile(filepath.Join(s.home, name), []byte("changed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(s.home, "lib", "new.jar"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(install, name))
+		if err != nil || string(data) != content {
+			t.Errorf("cached %s is %q, %v, want %q", name, data, err, content)
+		}
+	}
+	if fileExists(filepath.Join(install, "lib", "new.jar")) {
+		t.Error("a file written in the sandbox is in the cache")
+	}
+}
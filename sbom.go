@@ -0,0 +1,347 @@
+package goliquify
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kinds of toolchain components
+const (
+	COMPONENT_LIQUIBASE = "liquibase"
+	COMPONENT_BUNDLED   = "bundled"
+	COMPONENT_EXTENSION = "extension"
+	COMPONENT_DRIVER    = "driver"
+	COMPONENT_LIBRARY   = "library"
+	COMPONENT_JRE       = "jre"
+)
+
+const (
+	SBOM_CYCLONEDX = "cyclonedx"
+	SBOM_SPDX      = "spdx"
+)
+
+// Versions at the end of jar names, as in postgresql-42.7.3.jar
+var jarVersionPattern = regexp.MustCompile(`^(.+?)-(\d[\w.+-]*)\.jar$`)
+
+// ToolchainComponent is a piece of the Liquibase toolchain: the distribution,
+// a jar it runs with or the managed JRE
+type ToolchainComponent struct {
+	Kind    string `json:"kind"`
+	Group   string `json:"group,omitempty"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// PURL is the package URL, pkg:maven/<group>/<name>@<version> for jars
+	PURL   string `json:"purl,omitempty"`
+	Path   string `json:"path,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	SHA1   string `json:"sha1,omitempty"`
+}
+
+// Toolchain lists the components Liquibase runs with: the distribution and
+// its bundled jars, extensions, JDBC drivers, additional classpath jars and
+// the managed JRE. Liquibase is installed first if needed.
+func (pl *GoLiquibase) Toolchain() ([]ToolchainComponent, error) {
+	liquibaseDir, err := pl.installedDir()
+	if err != nil {
+		return nil, err
+	}
+	version := pl.InstalledVersion()
+	components := []ToolchainComponent{{
+		Kind:    COMPONENT_LIQUIBASE,
+		Group:   "org.liquibase",
+		Name:    "liquibase",
+		Version: version,
+		PURL:    fmt.Sprintf("pkg:github/liquibase/liquibase@v%s", version),
+		Path:    liquibaseDir,
+	}}
+	if version == "" {
+		components[0].PURL = ""
+	}
+
+	seen := map[string]bool{}
+	addJars := func(dir string, recursive bool, kind string) error {
+		jars, err := findJars(dir, recursive)
+		if err != nil {
+			return err
+		}
+		for _, jar := range jars {
+			if seen[jar] {
+				continue
+			}
+			seen[jar] = true
+			component, err := jarComponent(jar, kind)
+			if err != nil {
+				return err
+			}
+			components = append(components, component)
+		}
+		return nil
+	}
+
+	for _, dir := range []string{filepath.Join(liquibaseDir, "internal", "lib"), filepath.Join(liquibaseDir, "internal", "extensions")} {
+		if dirExists(dir) {
+			if err := addJars(dir, false, COMPONENT_BUNDLED); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if pl.JdbcDriversDir != "" {
+		if err := addJars(pl.JdbcDriversDir, true, COMPONENT_DRIVER); err != nil {
+			return nil, err
+		}
+	}
+	// The lib dir of an install holds extensions and drivers added to it
+	if libDir := filepath.Join(liquibaseDir, "lib"); dirExists(libDir) {
+		if err := addJars(libDir, false, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range splitClasspath(pl.AdditionalClasspath) {
+		jars, err := expandClasspathEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, jar := range jars {
+			if seen[jar] || !strings.HasSuffix(strings.ToLower(jar), ".jar") {
+				continue
+			}
+			seen[jar] = true
+			component, err := jarComponent(jar, COMPONENT_LIBRARY)
+			if err != nil {
+				return nil, err
+			}
+			components = append(components, component)
+		}
+	}
+
+	if home := pl.JavaHome(); home != "" {
+		components = append(components, jreComponent(home))
+	}
+	return components, nil
+}
+
+// Describe a jar from its Maven metadata, or its file name when it has none.
+// An empty kind is worked out from the curated drivers and extensions.
+func jarComponent(path, kind string) (ToolchainComponent, error) {
+	component := ToolchainComponent{Kind: kind, Path: path}
+	name := filepath.Base(path)
+	if m := jarVersionPattern.FindStringSubmatch(name); m != nil {
+		component.Name, component.Version = m[1], m[2]
+	} else {
+		component.Name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if group, artifact, version := jarMavenCoordinates(path, component.Name); artifact != "" {
+		component.Group, component.Name, component.Version = group, artifact, version
+	}
+	if component.Group != "" && component.Version != "" {
+		component.PURL = fmt.Sprintf("pkg:maven/%s/%s@%s", component.Group, component.Name, component.Version)
+	}
+
+	if component.Kind == "" {
+		component.Kind = COMPONENT_LIBRARY
+		if containsString(LIQUIBASE_EXT_LIST, component.Name) {
+			component.Kind = COMPONENT_EXTENSION
+		}
+		for _, bundle := range DRIVER_BUNDLES {
+			for _, artifact := range bundle.Artifacts {
+				if artifact.FileName() == name {
+					component.Kind = COMPONENT_DRIVER
+				}
+			}
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return component, err
+	}
+	defer file.Close()
+	sha256Hash, sha1Hash := sha256.New(), sha1.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Hash, sha1Hash), file); err != nil {
+		return component, err
+	}
+	component.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	component.SHA1 = hex.EncodeToString(sha1Hash.Sum(nil))
+	return component, nil
+}
+
+// Read the Maven coordinates of a jar from its pom.properties. Shaded jars
+// carry several, the one matching the file name wins.
+func jarMavenCoordinates(path, fileArtifact string) (string, string, string) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return "", "", ""
+	}
+	defer archive.Close()
+	var group, artifact, version string
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, "META-INF/maven/") || !strings.HasSuffix(f.Name, "/pom.properties") {
+			continue
+		}
+		reader, err := f.Open()
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			continue
+		}
+		props := map[string]string{}
+		for _, line := range strings.Split(string(data), "\n") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok && !strings.HasPrefix(key, "#") {
+				props[key] = value
+			}
+		}
+		if props["artifactId"] == "" {
+			continue
+		}
+		if artifact == "" || props["artifactId"] == fileArtifact {
+			group, artifact, version = props["groupId"], props["artifactId"], props["version"]
+		}
+		if artifact == fileArtifact {
+			break
+		}
+	}
+	return group, artifact, version
+}
+
+// Describe the managed JRE from its release file
+func jreComponent(home string) ToolchainComponent {
+	component := ToolchainComponent{Kind: COMPONENT_JRE, Group: "org.eclipse.temurin", Name: "temurin-jre", Path: home}
+	if data, err := os.ReadFile(filepath.Join(home, "release")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), "JAVA_VERSION="); ok {
+				component.Version = strings.Trim(value, `"`)
+			}
+		}
+	}
+	if component.Version != "" {
+		component.PURL = fmt.Sprintf("pkg:generic/temurin-jre@%s", component.Version)
+	}
+	return component
+}
+
+// A random RFC 4122 version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WriteSBOM writes the components as a CycloneDX 1.5 or SPDX 2.3 JSON document
+func WriteSBOM(w io.Writer, format string, components []ToolchainComponent) error {
+	var document any
+	switch format {
+	case "", SBOM_CYCLONEDX:
+		document = cycloneDXDocument(components)
+	case SBOM_SPDX:
+		document = spdxDocument(components)
+	default:
+		return fmt.Errorf("unknown SBOM format %s, expecting cyclonedx or spdx", format)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+func cycloneDXDocument(components []ToolchainComponent) map[string]any {
+	var entries []map[string]any
+	refs := map[string]bool{}
+	for i, c := range components {
+		entry := map[string]any{
+			"type":    "library",
+			"bom-ref": fmt.Sprintf("component-%d", i+1),
+			"name":    c.Name,
+		}
+		switch c.Kind {
+		case COMPONENT_LIQUIBASE, COMPONENT_JRE:
+			entry["type"] = "application"
+		}
+		if c.Group != "" {
+			entry["group"] = c.Group
+		}
+		if c.Version != "" {
+			entry["version"] = c.Version
+		}
+		if c.PURL != "" {
+			entry["purl"] = c.PURL
+			// The same jar can be in two places, refs must stay unique
+			if !refs[c.PURL] {
+				entry["bom-ref"] = c.PURL
+				refs[c.PURL] = true
+			}
+		}
+		if c.SHA256 != "" {
+			entry["hashes"] = []map[string]string{{"alg": "SHA-256", "content": c.SHA256}, {"alg": "SHA-1", "content": c.SHA1}}
+		}
+		entry["properties"] = []map[string]string{{"name": "goliquify:kind", "value": c.Kind}, {"name": "goliquify:path", "value": c.Path}}
+		entries = append(entries, entry)
+	}
+	return map[string]any{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newUUID(),
+		"version":      1,
+		"metadata": map[string]any{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools":     map[string]any{"components": []map[string]string{{"type": "application", "name": "goliquify"}}},
+			"component": map[string]string{"type": "application", "name": "liquibase-toolchain"},
+		},
+		"components": entries,
+	}
+}
+
+func spdxDocument(components []ToolchainComponent) map[string]any {
+	var packages, relationships []map[string]any
+	for i, c := range components {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		pkg := map[string]any{
+			"SPDXID":           id,
+			"name":             c.Name,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"comment":          fmt.Sprintf("%s at %s", c.Kind, c.Path),
+		}
+		if c.Group != "" {
+			pkg["supplier"] = "Organization: " + c.Group
+		}
+		if c.Version != "" {
+			pkg["versionInfo"] = c.Version
+		}
+		if c.SHA256 != "" {
+			pkg["checksums"] = []map[string]string{{"algorithm": "SHA256", "checksumValue": c.SHA256}, {"algorithm": "SHA1", "checksumValue": c.SHA1}}
+		}
+		if c.PURL != "" {
+			pkg["externalRefs"] = []map[string]string{{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl", "referenceLocator": c.PURL}}
+		}
+		packages = append(packages, pkg)
+		relationships = append(relationships, map[string]any{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": id})
+	}
+	return map[string]any{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              "liquibase-toolchain",
+		"documentNamespace": "https://spdx.org/spdxdocs/goliquify-" + newUUID(),
+		"creationInfo": map[string]any{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: goliquify"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
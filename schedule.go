@@ -0,0 +1,219 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Operations only schedules run, besides the commands of SERVER_COMMANDS
+const (
+	// Compare the schema with the baseline snapshot, failing on drift
+	OPERATION_DRIFT = "drift"
+	// Write the state of the environments as JSON to the output file
+	OPERATION_EXPORT_STATUS = "export-status"
+)
+
+// SCHEDULE_PRINCIPAL prefixes the schedule name as the principal of its jobs
+const SCHEDULE_PRINCIPAL = "schedule:"
+
+// ScheduledOperation is an operation the server runs on environments every
+// time a cron expression fires
+type ScheduledOperation struct {
+	Name string `yaml:"name"`
+	Cron string `yaml:"cron"`
+	// Timezone of the cron expression, local time when empty
+	Timezone string `yaml:"timezone"`
+	// Operation is drift, export-status or a command of SERVER_COMMANDS
+	Operation string `yaml:"operation"`
+	// Tag of rollback and rollbackSQL
+	Tag string `yaml:"tag"`
+	// Environments the operation runs on, every one when empty
+	Environments []string `yaml:"environments"`
+	// Jitter delays every run by a random duration up to it, so that
+	// schedules firing together don't hit the databases at once
+	Jitter time.Duration `yaml:"jitter"`
+	// Output is the file export-status writes, {env} and {time} are
+	// replaced by the environment and the start time
+	Output string `yaml:"output"`
+	// Notify are webhooks receiving a failed event when a run fails,
+	// besides the webhooks of the config
+	Notify []string `yaml:"notify"`
+}
+
+// Validate checks a scheduled operation
+func (o *ScheduledOperation) Validate() error {
+	if o.Name == "" {
+		return fmt.Errorf("scheduled operation without a name")
+	}
+	if _, err := ParseCron(o.Cron); err != nil {
+		return fmt.Errorf("schedule %s: %v", o.Name, err)
+	}
+	if _, err := time.LoadLocation(o.Timezone); err != nil {
+		return fmt.Errorf("schedule %s: invalid timezone: %v", o.Name, err)
+	}
+	switch {
+	case o.Operation == OPERATION_EXPORT_STATUS:
+		if o.Output == "" {
+			return fmt.Errorf("schedule %s: export-status needs an output file", o.Name)
+		}
+	case o.Operation == OPERATION_DRIFT:
+	case containsString(TAG_COMMANDS, o.Operation):
+		if err := ValidateTag(o.Tag); err != nil {
+			return fmt.Errorf("schedule %s: %v", o.Name, err)
+		}
+	case !containsString(SERVER_COMMANDS, o.Operation):
+		return fmt.Errorf("schedule %s: unknown operation %q, expecting drift, export-status or one of %s", o.Name, o.Operation, strings.Join(SERVER_COMMANDS, ", "))
+	}
+	if o.Jitter < 0 {
+		return fmt.Errorf("schedule %s: negative jitter", o.Name)
+	}
+	return nil
+}
+
+// Arguments of the jobs of the operation
+func (o *ScheduledOperation) args() []string {
+	switch {
+	case containsString(TAG_COMMANDS, o.Operation):
+		return []string{"--tag=" + o.Tag}
+	case o.Operation == OPERATION_EXPORT_STATUS:
+		return []string{"--output=" + o.Output}
+	}
+	return nil
+}
+
+// Check the scheduled operations of the server
+func (s *Server) validateSchedules() error {
+	names := map[string]bool{}
+	for _, op := range s.opts.Schedules {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+		if names[op.Name] {
+			return fmt.Errorf("schedule %s is defined twice", op.Name)
+		}
+		names[op.Name] = true
+		for _, name := range op.Environments {
+			if _, ok := s.opts.Environments[name]; !ok {
+				return fmt.Errorf("schedule %s: unknown environment %s", op.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// RunSchedules queues the scheduled operations as jobs every time they
+// fire, until the context is canceled. A run is skipped while the previous
+// run of the operation on the environment is still queued or running.
+func (s *Server) RunSchedules(ctx context.Context) error {
+	type parsed struct {
+		op       ScheduledOperation
+		cron     *CronSchedule
+		location *time.Location
+	}
+	var active []parsed
+	for _, op := range s.opts.Schedules {
+		cron, _ := ParseCron(op.Cron)
+		location, _ := time.LoadLocation(op.Timezone)
+		active = append(active, parsed{op, cron, location})
+	}
+
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(next.Sub(now)):
+		}
+		for _, p := range active {
+			if !p.cron.Matches(next.In(p.location)) {
+				continue
+			}
+			environments := p.op.Environments
+			if len(environments) == 0 {
+				environments = s.environmentNames()
+			}
+			for _, name := range environments {
+				go s.fireSchedule(ctx, p.op, name)
+			}
+		}
+	}
+}
+
+// Queue a run of a scheduled operation after its jitter
+func (s *Server) fireSchedule(ctx context.Context, op ScheduledOperation, environment string) {
+	if op.Jitter > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(op.Jitter)))):
+		}
+	}
+	principal := SCHEDULE_PRINCIPAL + op.Name
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs.List(environment) {
+		if job.Principal == principal && !job.Finished() {
+			log.Printf("Skipping schedule %s on %s, job %s is still %s", op.Name, environment, job.ID, job.Status)
+			return
+		}
+	}
+	if _, err := s.jobs.Submit(environment, op.Operation, op.args(), principal); err != nil {
+		log.Printf("Failed to queue schedule %s on %s: %v", op.Name, environment, err)
+		s.notifyFailure(&Job{Environment: environment, Command: op.Operation, Principal: principal, Error: err.Error()})
+	}
+}
+
+// The scheduled operation a job was queued for
+func (s *Server) jobSchedule(job *Job) (ScheduledOperation, bool) {
+	name, ok := strings.CutPrefix(job.Principal, SCHEDULE_PRINCIPAL)
+	if !ok {
+		return ScheduledOperation{}, false
+	}
+	for _, op := range s.opts.Schedules {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	return ScheduledOperation{}, false
+}
+
+// Send a failed event for a run of a scheduled operation to the webhooks
+// of the environment and of the operation
+func (s *Server) notifyFailure(job *Job) {
+	op, ok := s.jobSchedule(job)
+	if !ok {
+		return
+	}
+	event := Event{
+		Type:        EVENT_FAILED,
+		Time:        time.Now(),
+		Command:     job.Command,
+		Environment: job.Environment,
+		Schedule:    op.Name,
+		Error:       job.Error,
+	}
+	if job.ID != "" {
+		event.LogFile = filepath.Join(s.opts.JobsDir, job.ID+".log")
+	}
+	// Failed Liquibase runs and drift checks already sent their event to the
+	// sinks of the environment, export-status and interrupted runs didn't
+	var sinks []EventSink
+	sent := containsString(SERVER_COMMANDS, job.Command) || job.Command == OPERATION_DRIFT
+	if pl, ok := s.opts.Environments[job.Environment]; ok && (job.Status != JOB_FAILED || !sent) {
+		sinks = append(sinks, pl.EventSinks...)
+	}
+	for _, url := range op.Notify {
+		sinks = append(sinks, &WebhookSink{URL: url})
+	}
+	for _, sink := range sinks {
+		if err := sink.Send(event); err != nil {
+			log.Printf("Failed to notify the failure of schedule %s: %v", op.Name, err)
+		}
+	}
+}
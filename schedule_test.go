@@ -0,0 +1,163 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An event sink keeping what it receives
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recordingSink) Send(event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingSink) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+// Wait for a job to finish
+func waitForJob(t *testing.T, s *Server, id string) *Job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if job, ok := s.jobs.Get(id); ok && job.Finished() {
+			return job
+		}
+	}
+	t.Fatalf("job %s didn't finish", id)
+	return nil
+}
+
+func TestScheduleSkipsRunsWhileOneIsUnfinished(t *testing.T) {
+	release := make(chan struct{})
+	s, err := NewServer(ServerOptions{
+		Environments: map[string]*GoLiquibase{"prod": New()},
+		JobsDir:      t.TempDir(),
+		Drift: func(ctx context.Context, pl *GoLiquibase) ([]SchemaDifference, error) {
+			<-release
+			return nil, nil
+		},
+		Schedules: []ScheduledOperation{{Name: "nightly", Cron: "0 2 * * *", Operation: OPERATION_DRIFT}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := s.opts.Schedules[0]
+	s.fireSchedule(context.Background(), op, "prod")
+	s.fireSchedule(context.Background(), op, "prod")
+	jobs := s.jobs.List("prod")
+	if len(jobs) != 1 || jobs[0].Principal != "schedule:nightly" {
+		t.Fatalf("jobs %+v, want one of schedule:nightly", jobs)
+	}
+	close(release)
+	if job := waitForJob(t, s, jobs[0].ID); job.Status != JOB_COMPLETED {
+		t.Fatalf("job %s is %s: %s", job.ID, job.Status, job.Error)
+	}
+	s.fireSchedule(context.Background(), op, "prod")
+	jobs = s.jobs.List("prod")
+	if len(jobs) != 2 {
+		t.Fatalf("%d jobs after the first finished, want 2", len(jobs))
+	}
+	for _, job := range jobs {
+		waitForJob(t, s, job.ID)
+	}
+}
+
+func TestScheduleJitterStopsWithTheContext(t *testing.T) {
+	s, err := NewServer(ServerOptions{
+		Environments: map[string]*GoLiquibase{"prod": New()},
+		JobsDir:      t.TempDir(),
+		Schedules:    []ScheduledOperation{{Name: "nightly", Cron: "0 2 * * *", Operation: "status", Jitter: time.Hour}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.fireSchedule(ctx, s.opts.Schedules[0], "prod")
+	if jobs := s.jobs.List("prod"); len(jobs) != 0 {
+		t.Fatalf("jobs %+v queued after the server stopped", jobs)
+	}
+}
+
+func TestScheduleFailureNotifications(t *testing.T) {
+	received := make(chan Event, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	t.Cleanup(webhook.Close)
+
+	sink := &recordingSink{}
+	pl := New()
+	pl.EventSinks = []EventSink{sink}
+	s, err := NewServer(ServerOptions{
+		Environments: map[string]*GoLiquibase{"prod": pl},
+		JobsDir:      t.TempDir(),
+		Drift: func(ctx context.Context, pl *GoLiquibase) ([]SchemaDifference, error) {
+			return []SchemaDifference{{Kind: "unexpected", Object: "table orders_copy"}}, nil
+		},
+		Schedules: []ScheduledOperation{
+			{Name: "nightly", Cron: "0 2 * * *", Operation: OPERATION_DRIFT, Notify: []string{webhook.URL}},
+			{Name: "hourly", Cron: "0 * * * *", Operation: OPERATION_EXPORT_STATUS, Output: t.TempDir(), Notify: []string{webhook.URL}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range s.opts.Schedules {
+		s.fireSchedule(context.Background(), op, "prod")
+	}
+	for _, job := range s.jobs.List("prod") {
+		if job := waitForJob(t, s, job.ID); job.Status != JOB_FAILED {
+			t.Fatalf("%s job is %s, want failed", job.Command, job.Status)
+		}
+	}
+
+	// The webhooks of both schedules are notified, after the sinks of the
+	// environment
+	var notified []Event
+	for len(notified) < 2 {
+		select {
+		case event := <-received:
+			notified = append(notified, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notified of %+v, want a failure of nightly and hourly", notified)
+		}
+	}
+	schedules := map[string]bool{}
+	for _, event := range notified {
+		if event.Type != EVENT_FAILED || event.Environment != "prod" || event.Error == "" || event.LogFile == "" {
+			t.Errorf("notified of %+v", event)
+		}
+		schedules[event.Schedule] = true
+	}
+	if len(notified) != 2 || !schedules["nightly"] || !schedules["hourly"] {
+		t.Fatalf("notified of %+v, want a failure of nightly and hourly", notified)
+	}
+	// A failed run doesn't reach the environment sinks twice, export-status
+	// didn't send an event of its own
+	commands := map[string]int{}
+	for _, event := range sink.received() {
+		if event.Type == EVENT_FAILED {
+			commands[event.Command]++
+		}
+	}
+	if commands[OPERATION_DRIFT] != 1 || commands[OPERATION_EXPORT_STATUS] != 1 {
+		t.Fatalf("environment sinks received %+v, want one failure of drift and export-status", sink.received())
+	}
+}
@@ -0,0 +1,54 @@
+package goliquify
+
+import (
+	"time"
+)
+
+// Changelog property holding the schema a command runs against
+const PROPERTY_SCHEMA = "goliquify.schema"
+
+// ForSchema copies the instance to run against one schema. Objects and the
+// Liquibase tracking tables both go into that schema, so every schema keeps
+// its own history.
+func (pl *GoLiquibase) ForSchema(schema string) (*GoLiquibase, error) {
+	if err := validateValue("schema", schema); err != nil {
+		return nil, err
+	}
+	c := pl.withDefaultsFile(pl.DefaultsFile)
+	c.DefaultSchemaName = schema
+	c.LiquibaseSchemaName = schema
+	c.ChangelogProperties = map[string]string{}
+	for key, val := range pl.ChangelogProperties {
+		c.ChangelogProperties[key] = val
+	}
+	c.ChangelogProperties[PROPERTY_SCHEMA] = schema
+	return c, nil
+}
+
+// ForEachSchema runs the same command against each schema in turn, e.g. to
+// migrate every tenant of a schema-per-tenant database. Without schemas the
+// instance's Schemas are used. A failing schema does not stop the others.
+func (pl *GoLiquibase) ForEachSchema(schemas []string, command string, arguments ...string) []TargetResult {
+	if len(schemas) == 0 {
+		schemas = pl.Schemas
+	}
+	var results []TargetResult
+	for _, schema := range schemas {
+		result := TargetResult{Target: schema, Command: command}
+		start := time.Now()
+		spl, err := pl.ForSchema(schema)
+		if err == nil {
+			pl.logger().Printf("Running %s for schema %s", command, schema)
+			err = spl.Execute(append([]string{command}, arguments...)...)
+		}
+		result.Duration = time.Since(start)
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+		} else {
+			result.Status = "success"
+		}
+		results = append(results, result)
+	}
+	return results
+}
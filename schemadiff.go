@@ -0,0 +1,312 @@
+package goliquify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	DIFF_MISSING    = "missing"
+	DIFF_UNEXPECTED = "unexpected"
+	DIFF_CHANGED    = "changed"
+)
+
+// Liquibase snapshot object types read into a schema model
+const (
+	snapshotTable      = "liquibase.structure.core.Table"
+	snapshotPrimaryKey = "liquibase.structure.core.PrimaryKey"
+	snapshotIndex      = "liquibase.structure.core.Index"
+	snapshotForeignKey = "liquibase.structure.core.ForeignKey"
+)
+
+// SchemaDifference is one difference between an expected and an actual schema
+type SchemaDifference struct {
+	// Kind is missing (expected but not found), unexpected or changed
+	Kind string `json:"kind"`
+	// Object is what differs, e.g. table orders or column orders.id
+	Object   string `json:"object"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// String describes the difference in one line
+func (d SchemaDifference) String() string {
+	switch d.Kind {
+	case DIFF_MISSING:
+		return fmt.Sprintf("missing %s", d.Object)
+	case DIFF_UNEXPECTED:
+		return fmt.Sprintf("unexpected %s", d.Object)
+	}
+	return fmt.Sprintf("%s differs: expected %s, found %s", d.Object, d.Expected, d.Actual)
+}
+
+// ReadSnapshot reads the tables of a snapshot written by Liquibase snapshot
+// with --snapshot-format=json into a schema model
+func ReadSnapshot(path string) (*SchemaModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot struct {
+		Snapshot struct {
+			Objects map[string][]map[string]map[string]any `json:"objects"`
+		} `json:"snapshot"`
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %v", path, err)
+	}
+
+	// Objects refer to each other as type#snapshotId
+	byID := map[string]map[string]any{}
+	objects := map[string][]map[string]any{}
+	for objectType, entries := range snapshot.Snapshot.Objects {
+		for _, entry := range entries {
+			for _, object := range entry {
+				objects[objectType] = append(objects[objectType], object)
+				if id := snapshotString(object["snapshotId"]); id != "" {
+					byID[objectType+"#"+id] = object
+				}
+			}
+		}
+	}
+	name := func(ref any) string {
+		if object, ok := ref.(map[string]any); ok {
+			return snapshotString(object["name"])
+		}
+		if object := byID[snapshotString(ref)]; object != nil {
+			return snapshotString(object["name"])
+		}
+		return ""
+	}
+	names := func(refs any) []string {
+		list, _ := refs.([]any)
+		var result []string
+		for _, ref := range list {
+			result = append(result, name(ref))
+		}
+		return result
+	}
+
+	model := &SchemaModel{Tables: map[string]*TableModel{}}
+	tables := map[string]*TableModel{}
+	for _, object := range objects[snapshotTable] {
+		t := &TableModel{Name: snapshotString(object["name"])}
+		if schema := byID[snapshotString(object["schema"])]; schema != nil {
+			t.Schema = snapshotString(schema["name"])
+		}
+		model.Tables[strings.ToLower(t.Name)] = t
+		tables[snapshotTable+"#"+snapshotString(object["snapshotId"])] = t
+		refs, _ := object["columns"].([]any)
+		for _, ref := range refs {
+			column, _ := ref.(map[string]any)
+			if column == nil {
+				column = byID[snapshotString(ref)]
+			}
+			if column != nil {
+				t.Columns = append(t.Columns, snapshotColumnModel(column))
+			}
+		}
+	}
+	for _, object := range objects[snapshotPrimaryKey] {
+		if t := tables[snapshotString(object["table"])]; t != nil {
+			t.PrimaryKey = names(object["columns"])
+		}
+	}
+	for _, object := range objects[snapshotIndex] {
+		t := tables[snapshotString(object["table"])]
+		if t == nil || isBackingIndex(object, objects[snapshotPrimaryKey]) {
+			continue
+		}
+		t.Indexes = append(t.Indexes, &IndexModel{Name: snapshotString(object["name"]), Columns: names(object["columns"]), Unique: snapshotBool(object["unique"])})
+	}
+	for _, object := range objects[snapshotForeignKey] {
+		t := tables[snapshotString(object["foreignKeyTable"])]
+		if t == nil {
+			continue
+		}
+		refTable := ""
+		if ref := byID[snapshotString(object["primaryKeyTable"])]; ref != nil {
+			refTable = snapshotString(ref["name"])
+		}
+		t.ForeignKeys = append(t.ForeignKeys, &ForeignKeyModel{
+			Name:       snapshotString(object["name"]),
+			Columns:    names(object["foreignKeyColumns"]),
+			RefTable:   refTable,
+			RefColumns: names(object["primaryKeyColumns"]),
+		})
+	}
+
+	for _, name := range LIQUIBASE_TABLES {
+		delete(model.Tables, name)
+	}
+	return model, nil
+}
+
+// Read a column of a snapshot, with its type normalized
+func snapshotColumnModel(object map[string]any) *ColumnModel {
+	column := &ColumnModel{Name: snapshotString(object["name"]), Nullable: true}
+	if v, ok := object["nullable"]; ok {
+		column.Nullable = snapshotBool(v)
+	}
+	typ, _ := object["type"].(map[string]any)
+	if typ == nil {
+		return column
+	}
+	dataType := snapshotString(typ["typeName"])
+	if size := snapshotString(typ["columnSize"]); size != "" {
+		if digits := snapshotString(typ["decimalDigits"]); digits != "" {
+			size += "," + digits
+		}
+		dataType = fmt.Sprintf("%s(%s)", dataType, size)
+	}
+	column.Type = NormalizeType(dataType)
+	return column
+}
+
+// Check if an index only backs the primary key, which databases don't list as an index
+func isBackingIndex(index map[string]any, primaryKeys []map[string]any) bool {
+	for _, pk := range primaryKeys {
+		backing := snapshotString(pk["backingIndex"])
+		if backing != "" && strings.HasSuffix(backing, "#"+snapshotString(index["snapshotId"])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot values carry their Java type, as in 255!{java.lang.Integer}
+func snapshotString(v any) string {
+	switch v := v.(type) {
+	case string:
+		s, _, _ := strings.Cut(v, "!{")
+		return s
+	case float64:
+		return fmt.Sprint(v)
+	case bool:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func snapshotBool(v any) bool {
+	return snapshotString(v) == "true"
+}
+
+// DiffSchemas compares an actual schema against the expected one. Names are
+// compared ignoring case, and index and foreign key names are ignored so
+// only their definitions count.
+func DiffSchemas(expected, actual *SchemaModel) []SchemaDifference {
+	var diffs []SchemaDifference
+	for _, name := range expected.TableNames() {
+		e, a := expected.Tables[name], actual.Tables[name]
+		if a == nil {
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_MISSING, Object: "table " + e.Name})
+			continue
+		}
+		diffs = append(diffs, diffTables(e, a)...)
+	}
+	for _, name := range actual.TableNames() {
+		if expected.Tables[name] == nil {
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_UNEXPECTED, Object: "table " + actual.Tables[name].Name})
+		}
+	}
+	return diffs
+}
+
+// Compare the columns, primary key, indexes and foreign keys of a table
+func diffTables(expected, actual *TableModel) []SchemaDifference {
+	var diffs []SchemaDifference
+	columns := map[string]*ColumnModel{}
+	for _, c := range actual.Columns {
+		columns[strings.ToLower(c.Name)] = c
+	}
+	for _, e := range expected.Columns {
+		object := fmt.Sprintf("column %s.%s", expected.Name, e.Name)
+		a := columns[strings.ToLower(e.Name)]
+		delete(columns, strings.ToLower(e.Name))
+		switch {
+		case a == nil:
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_MISSING, Object: object})
+		case e.Type != "" && a.Type != "" && e.Type != a.Type:
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_CHANGED, Object: object + " type", Expected: e.Type, Actual: a.Type})
+		case e.Nullable != a.Nullable:
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_CHANGED, Object: object + " nullability", Expected: nullability(e.Nullable), Actual: nullability(a.Nullable)})
+		}
+	}
+	for _, c := range actual.Columns {
+		if columns[strings.ToLower(c.Name)] != nil {
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_UNEXPECTED, Object: fmt.Sprintf("column %s.%s", actual.Name, c.Name)})
+		}
+	}
+
+	if e, a := columnList(expected.PrimaryKey), columnList(actual.PrimaryKey); e != a {
+		if e == "" {
+			e = "none"
+		}
+		if a == "" {
+			a = "none"
+		}
+		diffs = append(diffs, SchemaDifference{Kind: DIFF_CHANGED, Object: fmt.Sprintf("primary key of %s", expected.Name), Expected: e, Actual: a})
+	}
+
+	indexKey := func(idx *IndexModel) string {
+		if idx.Unique {
+			return "unique " + columnList(idx.Columns)
+		}
+		return columnList(idx.Columns)
+	}
+	var expectedIndexes, actualIndexes []string
+	for _, idx := range expected.Indexes {
+		expectedIndexes = append(expectedIndexes, indexKey(idx))
+	}
+	for _, idx := range actual.Indexes {
+		actualIndexes = append(actualIndexes, indexKey(idx))
+	}
+	diffs = append(diffs, diffSets("index on "+expected.Name, expectedIndexes, actualIndexes)...)
+
+	fkKey := func(fk *ForeignKeyModel) string {
+		return fmt.Sprintf("%s -> %s%s", columnList(fk.Columns), strings.ToLower(fk.RefTable), columnList(fk.RefColumns))
+	}
+	var expectedFKs, actualFKs []string
+	for _, fk := range expected.ForeignKeys {
+		expectedFKs = append(expectedFKs, fkKey(fk))
+	}
+	for _, fk := range actual.ForeignKeys {
+		actualFKs = append(actualFKs, fkKey(fk))
+	}
+	diffs = append(diffs, diffSets("foreign key on "+expected.Name, expectedFKs, actualFKs)...)
+	return diffs
+}
+
+// Report the entries only found on one side
+func diffSets(object string, expected, actual []string) []SchemaDifference {
+	var diffs []SchemaDifference
+	for _, e := range expected {
+		if !containsString(actual, e) {
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_MISSING, Object: fmt.Sprintf("%s %s", object, e)})
+		}
+	}
+	for _, a := range actual {
+		if !containsString(expected, a) {
+			diffs = append(diffs, SchemaDifference{Kind: DIFF_UNEXPECTED, Object: fmt.Sprintf("%s %s", object, a)})
+		}
+	}
+	return diffs
+}
+
+// Format columns as (a, b), lowercased for comparison
+func columnList(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return "(" + strings.ToLower(strings.Join(columns, ", ")) + ")"
+}
+
+func nullability(nullable bool) string {
+	if nullable {
+		return "nullable"
+	}
+	return "not null"
+}
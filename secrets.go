@@ -0,0 +1,284 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_SOPS_BINARY = "sops"
+
+// File name infixes marking encrypted files, as in secrets.enc.yaml
+var ENCRYPTED_INFIXES = []string{".enc", ".sops"}
+
+// SecretsConfig lists the encrypted files decrypted for every run
+type SecretsConfig struct {
+	// PropertyFiles are encrypted YAML, JSON or properties files of changelog properties
+	PropertyFiles []string `yaml:"propertyFiles"`
+	// Changelogs are globs of encrypted changelog fragments. They are included
+	// by their name without the .enc or .sops infix, e.g. grants.sql for grants.enc.sql.
+	Changelogs []string `yaml:"changelogs"`
+	// Sops is the sops binary, found on the PATH by default
+	Sops string `yaml:"sops"`
+}
+
+// Merge adds the files of other, other's sops binary wins when set
+func (s SecretsConfig) Merge(other SecretsConfig) SecretsConfig {
+	s.PropertyFiles = append(append([]string{}, s.PropertyFiles...), other.PropertyFiles...)
+	s.Changelogs = append(append([]string{}, s.Changelogs...), other.Changelogs...)
+	if other.Sops != "" {
+		s.Sops = other.Sops
+	}
+	return s
+}
+
+// Decryptor decrypts a file into memory
+type Decryptor interface {
+	Decrypt(ctx context.Context, path string) ([]byte, error)
+}
+
+// SopsDecryptor decrypts files with sops, which finds age, PGP or cloud KMS keys the usual way
+type SopsDecryptor struct {
+	Binary string
+}
+
+// Decrypt runs sops --decrypt and returns the plaintext
+func (s SopsDecryptor) Decrypt(ctx context.Context, path string) ([]byte, error) {
+	binary := s.Binary
+	if binary == "" {
+		binary = DEFAULT_SOPS_BINARY
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "--decrypt", path)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// IsEncryptedFile checks if a file name carries an encrypted infix
+func IsEncryptedFile(path string) bool {
+	return decryptedName(path) != path
+}
+
+// Strip the encrypted infix from a file name, secrets.enc.yaml becomes secrets.yaml
+func decryptedName(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for _, infix := range ENCRYPTED_INFIXES {
+		if strings.HasSuffix(base, infix) {
+			return strings.TrimSuffix(base, infix) + ext
+		}
+	}
+	return path
+}
+
+// runSecrets holds what was decrypted for one run
+type runSecrets struct {
+	dir          string
+	defaultsFile string
+	searchPath   string
+}
+
+// Remove everything decrypted for the run
+func (r *runSecrets) Close() error {
+	return os.RemoveAll(r.dir)
+}
+
+// Point the arguments at the decrypted defaults file and changelogs. The
+// search paths already in the arguments, e.g. of rendered templates or
+// skipped changesets, are kept after the decrypted changelogs.
+func (r *runSecrets) apply(args []string) []string {
+	var result, searchPath []string
+	for _, arg := range args {
+		if r.defaultsFile != "" && strings.HasPrefix(arg, "--defaults-file=") {
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--search-path="); ok && r.searchPath != "" {
+			searchPath = append(searchPath, strings.Split(value, ",")...)
+			continue
+		}
+		result = append(result, arg)
+	}
+	var extra []string
+	if r.defaultsFile != "" {
+		extra = append(extra, "--defaults-file="+r.defaultsFile)
+	}
+	if r.searchPath != "" {
+		paths := strings.Split(r.searchPath, ",")
+		merged := append(append(paths[:1:1], searchPath...), paths[1:]...)
+		extra = append(extra, "--search-path="+strings.Join(uniquePaths(merged), ","))
+	}
+	return append(extra, result...)
+}
+
+// Check if a run has anything to decrypt
+func (pl *GoLiquibase) hasSecrets() bool {
+	return len(pl.Secrets.PropertyFiles) > 0 || len(pl.Secrets.Changelogs) > 0 || IsEncryptedFile(pl.DefaultsFile)
+}
+
+// Decrypt the secrets of a run into a private directory, memory backed where
+// the OS has one. Secret properties go into a copy of the defaults file rather
+// than on the command line, where other processes could read them.
+func (pl *GoLiquibase) decryptSecrets(ctx context.Context, args []string) (*runSecrets, error) {
+	decryptor := pl.Decryptor
+	if decryptor == nil {
+		decryptor = SopsDecryptor{Binary: pl.Secrets.Sops}
+	}
+	parent := ""
+	if dirExists("/dev/shm") {
+		parent = "/dev/shm"
+	}
+	dir, err := os.MkdirTemp(parent, "goliquify-secrets-")
+	if err != nil {
+		return nil, err
+	}
+	if parent == "" {
+		pl.logger().Printf("Warning: no memory backed directory, decrypted secrets are written to %s on disk until the command ends", dir)
+	}
+	secrets := &runSecrets{dir: dir}
+	fail := func(err error) (*runSecrets, error) {
+		secrets.Close()
+		return nil, err
+	}
+
+	properties := map[string]string{}
+	for _, path := range pl.Secrets.PropertyFiles {
+		content, err := decryptor.Decrypt(ctx, path)
+		if err != nil {
+			return fail(err)
+		}
+		props, err := parsePropertyFile(decryptedName(path), content)
+		if err != nil {
+			return fail(fmt.Errorf("invalid secret properties in %s: %v", path, err))
+		}
+		for key, val := range props {
+			if err := ValidatePropertyKey(key); err != nil {
+				return fail(fmt.Errorf("invalid secret property in %s: %v", path, err))
+			}
+			properties[key] = val
+		}
+	}
+
+	// The defaults file copy holds the decrypted settings and the secret properties
+	if len(properties) > 0 || IsEncryptedFile(pl.DefaultsFile) {
+		var defaults []byte
+		switch {
+		case IsEncryptedFile(pl.DefaultsFile):
+			if defaults, err = decryptor.Decrypt(ctx, pl.DefaultsFile); err != nil {
+				return fail(err)
+			}
+		case pl.DefaultsFile != "":
+			if defaults, err = os.ReadFile(pl.DefaultsFile); err != nil {
+				return fail(err)
+			}
+		}
+		var buf bytes.Buffer
+		buf.Write(defaults)
+		if len(defaults) > 0 && !bytes.HasSuffix(defaults, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		keys := make([]string, 0, len(properties))
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "parameter.%s=%s\n", key, escapeProperty(properties[key]))
+		}
+		secrets.defaultsFile = filepath.Join(dir, "liquibase.properties")
+		if err := os.WriteFile(secrets.defaultsFile, buf.Bytes(), 0600); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Decrypted changelogs are found first on the search path, under their plain name
+	decrypted := 0
+	for _, pattern := range pl.Secrets.Changelogs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fail(fmt.Errorf("invalid secret changelog pattern %s: %v", pattern, err))
+		}
+		for _, path := range matches {
+			content, err := decryptor.Decrypt(ctx, path)
+			if err != nil {
+				return fail(err)
+			}
+			// The path is kept so includes relative to the changelog still resolve
+			changelogs := filepath.Join(dir, "changelogs")
+			target := filepath.Join(changelogs, decryptedName(filepath.Clean(path)))
+			if !strings.HasPrefix(target, changelogs+string(filepath.Separator)) {
+				return fail(fmt.Errorf("secret changelog %s is outside the working directory, use a pattern below it", path))
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return fail(err)
+			}
+			if err := os.WriteFile(target, content, 0600); err != nil {
+				return fail(err)
+			}
+			decrypted++
+		}
+	}
+	if decrypted > 0 {
+		searchPath := []string{filepath.Join(dir, "changelogs")}
+		_, dirs := pl.ChangelogLocation(args...)
+		for _, d := range dirs {
+			if abs, err := filepath.Abs(d); err == nil {
+				d = abs
+			}
+			searchPath = append(searchPath, d)
+		}
+		secrets.searchPath = strings.Join(uniquePaths(searchPath), ",")
+	}
+	pl.logger().Printf("Decrypted %d secret properties and %d changelogs for this run", len(properties), decrypted)
+	return secrets, nil
+}
+
+// Parse changelog properties from a YAML, JSON or properties file
+func parsePropertyFile(name string, content []byte) (map[string]string, error) {
+	props := map[string]string{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		var values map[string]any
+		var err error
+		if strings.EqualFold(filepath.Ext(name), ".json") {
+			err = json.Unmarshal(content, &values)
+		} else {
+			err = yaml.Unmarshal(content, &values)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range values {
+			// sops keeps its metadata in the file, it isn't a property
+			if key == "sops" {
+				continue
+			}
+			props[key] = fmt.Sprint(val)
+		}
+	default:
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+				continue
+			}
+			key, val, _ := strings.Cut(line, "=")
+			props[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+	return props, nil
+}
+
+// Escape a value for a Java properties file
+func escapeProperty(val string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(val)
+}
@@ -0,0 +1,71 @@
+package goliquify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type plainDecryptor struct{}
+
+func (plainDecryptor) Decrypt(ctx context.Context, path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// Run the test from dir, restoring the working directory after it
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestSecretChangelogsStayInTheSecretsDir(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"work/db/users.sops.xml", "outside.sops.xml"} {
+		path = filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("<databaseChangeLog/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chdir(t, filepath.Join(root, "work"))
+
+	pl := New(WithSecrets(SecretsConfig{Changelogs: []string{"db/*.sops.xml"}}))
+	pl.Decryptor = plainDecryptor{}
+	secrets, err := pl.decryptSecrets(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secrets.Close()
+	if !fileExists(filepath.Join(secrets.dir, "changelogs", "db", "users.xml")) {
+		t.Fatalf("db/users.sops.xml not decrypted to db/users.xml")
+	}
+
+	pl = New(WithSecrets(SecretsConfig{Changelogs: []string{"../*.sops.xml"}}))
+	pl.Decryptor = plainDecryptor{}
+	if secrets, err := pl.decryptSecrets(context.Background(), nil); err == nil {
+		secrets.Close()
+		t.Fatal("decrypted a changelog outside the working directory")
+	} else if !strings.Contains(err.Error(), "outside") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestSecretsKeepTheSearchPath(t *testing.T) {
+	secrets := &runSecrets{searchPath: "/secrets/changelogs,/work/db"}
+	args := secrets.apply([]string{"--search-path=/render", "--log-level=info", "update", "--search-path=/skip,/work/db"})
+	want := []string{"--search-path=/secrets/changelogs,/render,/skip,/work/db", "--log-level=info", "update"}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Fatalf("arguments %q, want %q", args, want)
+	}
+}
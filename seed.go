@@ -0,0 +1,240 @@
+package goliquify
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	SEED_CHANGELOG_FILE = "seed.changelog.xml"
+	SEED_AUTHOR         = "goliquify"
+	DEFAULT_PRIMARY_KEY = "id"
+)
+
+// SeedConfig holds the settings for reference data seeding
+type SeedConfig struct {
+	// PrimaryKeys maps table names to the primary key columns used to upsert rows
+	PrimaryKeys map[string]string `yaml:"primaryKeys"`
+	// Separator is the CSV field separator, a comma by default
+	Separator string `yaml:"separator"`
+}
+
+// SeedFile is a fixture file loaded into one table
+type SeedFile struct {
+	Path    string
+	Table   string
+	Context string
+}
+
+type seedChangelog struct {
+	XMLName    xml.Name        `xml:"databaseChangeLog"`
+	Xmlns      string          `xml:"xmlns,attr"`
+	XmlnsXsi   string          `xml:"xmlns:xsi,attr"`
+	SchemaLoc  string          `xml:"xsi:schemaLocation,attr"`
+	ChangeSets []seedChangeSet `xml:"changeSet"`
+}
+
+type seedChangeSet struct {
+	ID             string        `xml:"id,attr"`
+	Author         string        `xml:"author,attr"`
+	RunOnChange    bool          `xml:"runOnChange,attr"`
+	Context        string        `xml:"context,attr,omitempty"`
+	LoadData       *seedLoadData `xml:"loadData,omitempty"`
+	LoadUpdateData *seedLoadData `xml:"loadUpdateData,omitempty"`
+}
+
+type seedLoadData struct {
+	TableName  string `xml:"tableName,attr"`
+	File       string `xml:"file,attr"`
+	PrimaryKey string `xml:"primaryKey,attr,omitempty"`
+	Separator  string `xml:"separator,attr,omitempty"`
+}
+
+// Find the CSV and JSON fixtures in a seed directory. Files directly in the
+// directory apply to every environment, files in a sub directory only to the
+// context named after it.
+func FindSeedFiles(dataDir string) ([]SeedFile, error) {
+	var seeds []SeedFile
+	err := filepath.WalkDir(dataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".csv" && ext != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		context := ""
+		if dir := filepath.Dir(rel); dir != "." {
+			context = strings.Split(filepath.ToSlash(dir), "/")[0]
+		}
+		seeds = append(seeds, SeedFile{
+			Path:    path,
+			Table:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Context: context,
+		})
+		return nil
+	})
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i].Path < seeds[j].Path })
+	return seeds, err
+}
+
+// Generate a changelog loading the seed files into outDir. JSON fixtures are
+// converted to CSV since that is what Liquibase loads. With upsert set rows
+// are loaded with loadUpdateData so existing rows are updated in place.
+func GenerateSeedChangelog(seeds []SeedFile, outDir string, cfg SeedConfig, upsert bool) error {
+	changelog := seedChangelog{
+		Xmlns:     "http://www.liquibase.org/xml/ns/dbchangelog",
+		XmlnsXsi:  "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLoc: "http://www.liquibase.org/xml/ns/dbchangelog http://www.liquibase.org/xml/ns/dbchangelog/dbchangelog-latest.xsd",
+	}
+
+	for _, seed := range seeds {
+		dataFile := filepath.ToSlash(filepath.Join("data", seed.Context, seed.Table+".csv"))
+		if err := os.MkdirAll(filepath.Join(outDir, filepath.Dir(dataFile)), 0755); err != nil {
+			return err
+		}
+		if err := writeSeedCSV(seed.Path, filepath.Join(outDir, dataFile)); err != nil {
+			return err
+		}
+
+		id := "seed-" + seed.Table
+		if seed.Context != "" {
+			id = fmt.Sprintf("seed-%s-%s", seed.Context, seed.Table)
+		}
+		load := &seedLoadData{TableName: seed.Table, File: dataFile, Separator: cfg.Separator}
+		changeSet := seedChangeSet{ID: id, Author: SEED_AUTHOR, RunOnChange: true, Context: seed.Context}
+		if upsert {
+			load.PrimaryKey = DEFAULT_PRIMARY_KEY
+			if pk, ok := cfg.PrimaryKeys[seed.Table]; ok {
+				load.PrimaryKey = pk
+			}
+			changeSet.LoadUpdateData = load
+		} else {
+			changeSet.LoadData = load
+		}
+		changelog.ChangeSets = append(changelog.ChangeSets, changeSet)
+	}
+
+	data, err := xml.MarshalIndent(changelog, "", "    ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	return os.WriteFile(filepath.Join(outDir, SEED_CHANGELOG_FILE), data, 0644)
+}
+
+// Copy a CSV fixture, or convert a JSON array of objects to CSV
+func writeSeedCSV(src, dest string) error {
+	if strings.ToLower(filepath.Ext(src)) == ".csv" {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0644)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("failed to parse seed file %s, expecting an array of objects: %v", src, err)
+	}
+
+	columnSet := map[string]bool{}
+	for _, row := range rows {
+		for col := range row {
+			columnSet[col] = true
+		}
+	}
+	var columns []string
+	for col := range columnSet {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			record[i] = seedValue(row[col])
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Format a JSON value as a CSV field, null values are written as NULL
+func seedValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return val
+	case float64:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%f", val), "0"), ".")
+	case bool:
+		return fmt.Sprintf("%t", val)
+	default:
+		data, _ := json.Marshal(val)
+		return string(data)
+	}
+}
+
+// Seed the database with the fixtures in dataDir, limited to a context if given
+func (pl *GoLiquibase) Seed(dataDir, context string, cfg SeedConfig, upsert bool) error {
+	seeds, err := FindSeedFiles(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(seeds) == 0 {
+		return fmt.Errorf("no CSV or JSON seed files found in %s", dataDir)
+	}
+
+	outDir, err := os.MkdirTemp("", "goliquify-seed-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := GenerateSeedChangelog(seeds, outDir, cfg, upsert); err != nil {
+		return err
+	}
+	pl.logger().Printf("Seeding %d tables from %s", len(seeds), dataDir)
+
+	args := []string{
+		fmt.Sprintf("--search-path=%s", outDir),
+		"update",
+		fmt.Sprintf("--changelog-file=%s", SEED_CHANGELOG_FILE),
+	}
+	if context != "" {
+		args = append(args, fmt.Sprintf("--context-filter=%s", context))
+	}
+	return pl.Execute(args...)
+}
@@ -0,0 +1,567 @@
+package goliquify
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SERVER_ADDR = "127.0.0.1:8080"
+	// SERVER_TOKEN_ENV holds a token with the admin role
+	SERVER_TOKEN_ENV     = "GOLIQUIFY_SERVER_TOKEN"
+	DEFAULT_RUNS_LIMIT   = 50
+	SERVER_QUERY_TIMEOUT = 30 * time.Second
+)
+
+// Commands the API can trigger, roles restrict them further
+var SERVER_COMMANDS = []string{"status", "validate", "diff", "history", "updateSQL", "update", "rollbackSQL", "rollback", "drop-all"}
+
+// Commands of the API taking a tag
+var TAG_COMMANDS = []string{"rollbackSQL", "rollback"}
+
+// The web dashboard served with --ui
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// ServerOptions configure the API server
+type ServerOptions struct {
+	// Environments maps environment names to their GoLiquibase instances
+	Environments map[string]*GoLiquibase
+	// OpenDB opens database/sql connections to read deployment state, see
+	// FleetOptions
+	OpenDB func(dialect, dsn string) (*sql.DB, error)
+	// Drift compares the schema of an environment with its baseline, drift
+	// isn't reported when nil
+	Drift func(ctx context.Context, pl *GoLiquibase) ([]SchemaDifference, error)
+	// Auth authenticates requests and authorizes commands. Without any
+	// token or provider the state is readable by anyone and commands are
+	// disabled.
+	Auth *Authenticator
+	// AuditFile logs the commands triggered and refused, not logged when empty
+	AuditFile string
+	// JobsDir holds the state and logs of the queued commands,
+	// DEFAULT_JOBS_DIR when empty
+	JobsDir string
+	// Schedules are operations queued when their cron expression fires,
+	// see RunSchedules
+	Schedules []ScheduledOperation
+	// UI serves the web dashboard at /
+	UI bool
+}
+
+// Server serves the deployment state of environments over HTTP and queues
+// commands on them. One command runs at a time per database.
+type Server struct {
+	opts ServerOptions
+	jobs *JobQueue
+	mu   sync.Mutex
+}
+
+// EnvironmentState is the deployment state of an environment
+type EnvironmentState struct {
+	FleetStatus
+	Lock *ChangelogLock `json:"lock,omitempty"`
+	// Running is the command running on the environment
+	Running string `json:"running,omitempty"`
+	// Queued counts the jobs waiting to run on the environment
+	Queued int `json:"queued,omitempty"`
+}
+
+// DriftState is the drift of an environment from its baseline
+type DriftState struct {
+	Environment string             `json:"environment"`
+	Drifted     bool               `json:"drifted"`
+	Differences []SchemaDifference `json:"differences"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// CommandRequest is the optional body of a command request
+type CommandRequest struct {
+	// Tag is the tag rollback and rollbackSQL go back to
+	Tag string `json:"tag"`
+}
+
+// NewServer creates an API server, resuming the jobs a previous server
+// left unfinished
+func NewServer(opts ServerOptions) (*Server, error) {
+	if opts.JobsDir == "" {
+		opts.JobsDir = DEFAULT_JOBS_DIR
+	}
+	s := &Server{opts: opts}
+	if err := s.validateSchedules(); err != nil {
+		return nil, err
+	}
+	jobs, err := NewJobQueue(JobQueueOptions{
+		Dir:    opts.JobsDir,
+		Target: s.jobTarget,
+		Run:    s.runJob,
+		Done: func(job *Job) {
+			s.audit(APIAuditRecord{Time: job.End, Principal: job.Principal, Environment: job.Environment, Command: job.Command, Args: job.Args, Job: job.ID, Status: job.Status, Error: job.Error})
+			if job.Status != JOB_COMPLETED {
+				s.notifyFailure(job)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the jobs of %s: %v", opts.JobsDir, err)
+	}
+	s.jobs = jobs
+	return s, nil
+}
+
+// Handler returns the HTTP handler of the API, and of the dashboard when
+// enabled:
+//
+//	GET  /api/environments                            state of every environment
+//	GET  /api/environments/{env}                      state of one environment
+//	GET  /api/environments/{env}/drift                drift from the baseline
+//	GET  /api/runs?environment=&limit=                recent runs of the journal
+//	POST /api/environments/{env}/commands/{command}   queue a command allowed for the role
+//	GET  /api/jobs?environment=&limit=                 queued, running and finished jobs
+//	GET  /api/jobs/{id}                                state of a job
+//	GET  /api/jobs/{id}/log?offset=                    log of a job from a byte offset
+//	POST /api/jobs/{id}/resume                         queue an interrupted or failed job again
+//
+// With authentication configured every API request needs a bearer token.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/environments", s.viewer(s.handleEnvironments))
+	mux.HandleFunc("GET /api/environments/{env}", s.viewer(s.handleEnvironment))
+	mux.HandleFunc("GET /api/environments/{env}/drift", s.viewer(s.handleDrift))
+	mux.HandleFunc("GET /api/runs", s.viewer(s.handleRuns))
+	mux.HandleFunc("POST /api/environments/{env}/commands/{command}", s.handleCommand)
+	mux.HandleFunc("GET /api/jobs", s.viewer(s.handleJobs))
+	mux.HandleFunc("GET /api/jobs/{id}", s.viewer(s.handleJob))
+	mux.HandleFunc("GET /api/jobs/{id}/log", s.viewer(s.handleJobLog))
+	mux.HandleFunc("POST /api/jobs/{id}/resume", s.handleResume)
+	if s.opts.UI {
+		files, _ := fs.Sub(dashboardFiles, "dashboard")
+		mux.Handle("GET /", http.FileServer(http.FS(files)))
+	}
+	return mux
+}
+
+// Require an authenticated caller to read state, when authentication is configured
+func (s *Server) viewer(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Auth.Enabled() {
+			if _, err := s.opts.Auth.Authenticate(r); err != nil {
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// Names of the environments, sorted
+func (s *Server) environmentNames() []string {
+	names := make([]string, 0, len(s.opts.Environments))
+	for name := range s.opts.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) handleEnvironments(w http.ResponseWriter, r *http.Request) {
+	names := s.environmentNames()
+	states := make([]EnvironmentState, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			states[i] = s.environmentState(r.Context(), name)
+		}(i, name)
+	}
+	wg.Wait()
+	writeJSON(w, http.StatusOK, states)
+}
+
+func (s *Server) handleEnvironment(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("env")
+	if _, ok := s.opts.Environments[name]; !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown environment %s", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.environmentState(r.Context(), name))
+}
+
+// Read the pending changesets and lock of an environment
+func (s *Server) environmentState(ctx context.Context, name string) EnvironmentState {
+	ctx, cancel := context.WithTimeout(ctx, SERVER_QUERY_TIMEOUT)
+	defer cancel()
+	pl := s.opts.Environments[name]
+	// Dashboards poll, status falling back to Liquibase isn't journaled or sent
+	polling := pl.reader()
+	state := EnvironmentState{
+		FleetStatus: polling.CollectFleetStatus(ctx, []*Target{{Name: name}}, FleetOptions{OpenDB: s.opts.OpenDB})[0],
+	}
+	state.Running, state.Queued = s.jobs.Running(name)
+	if s.opts.OpenDB != nil {
+		if dialect, dsn, err := pl.ReadOnlyDSN(); err == nil {
+			if db, err := s.opts.OpenDB(dialect, dsn); err == nil {
+				defer db.Close()
+				state.Lock, _ = ReadChangelogLock(ctx, db, pl.LiquibaseSchemaName)
+			}
+		}
+	}
+	return state
+}
+
+func (s *Server) handleDrift(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("env")
+	pl, ok := s.opts.Environments[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown environment %s", name))
+		return
+	}
+	if s.opts.Drift == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("drift checks aren't enabled"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), SERVER_QUERY_TIMEOUT)
+	defer cancel()
+	state := DriftState{Environment: name, Differences: []SchemaDifference{}}
+	if diffs, err := s.opts.Drift(ctx, pl); err != nil {
+		state.Error = err.Error()
+	} else if len(diffs) > 0 {
+		state.Drifted, state.Differences = true, diffs
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	environment := r.URL.Query().Get("environment")
+	limit := DEFAULT_RUNS_LIMIT
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+	}
+	// Environments usually share the journal, each one is read once
+	runs := []RunRecord{}
+	read := map[string]bool{}
+	for _, name := range s.environmentNames() {
+		journal := s.opts.Environments[name].JournalFile
+		if journal == "" || read[journal] {
+			continue
+		}
+		read[journal] = true
+		records, err := ReadJournal(journal)
+		if err != nil {
+			continue
+		}
+		for _, record := range records {
+			if environment == "" || record.Environment == environment {
+				runs = append(runs, record)
+			}
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Start.After(runs[j].Start) })
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	name, command := r.PathValue("env"), r.PathValue("command")
+	audit := APIAuditRecord{Time: time.Now(), Remote: r.RemoteAddr, Environment: name, Command: command, Status: API_DENIED}
+	refuse := func(status int, err error) {
+		audit.Error = err.Error()
+		s.audit(audit)
+		writeError(w, status, err)
+	}
+
+	principal, err := s.commandCaller(r, &audit)
+	if err != nil {
+		refuse(http.StatusUnauthorized, err)
+		return
+	}
+	if _, ok := s.opts.Environments[name]; !ok {
+		refuse(http.StatusNotFound, fmt.Errorf("unknown environment %s", name))
+		return
+	}
+	if !containsString(SERVER_COMMANDS, command) {
+		refuse(http.StatusBadRequest, fmt.Errorf("unknown command %s, expecting one of %s", command, strings.Join(SERVER_COMMANDS, ", ")))
+		return
+	}
+	if reason := s.opts.Auth.Allowed(principal, command); reason != "" {
+		refuse(http.StatusForbidden, fmt.Errorf("%s is not allowed for role %s: %s", command, principal.Role, reason))
+		return
+	}
+	var request CommandRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			refuse(http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	var args []string
+	if containsString(TAG_COMMANDS, command) {
+		if request.Tag == "" {
+			refuse(http.StatusBadRequest, fmt.Errorf("%s needs a tag", command))
+			return
+		}
+		if err := ValidateTag(request.Tag); err != nil {
+			refuse(http.StatusBadRequest, err)
+			return
+		}
+		args = append(args, "--tag="+request.Tag)
+	}
+	audit.Args = args
+
+	job, err := s.jobs.Submit(name, command, args, principal.Name)
+	if err != nil {
+		refuse(http.StatusInternalServerError, err)
+		return
+	}
+	audit.Job, audit.Status = job.ID, JOB_QUEUED
+	s.audit(audit)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// Authenticate the caller of a command, filling its audit record
+func (s *Server) commandCaller(r *http.Request, audit *APIAuditRecord) (*Principal, error) {
+	if !s.opts.Auth.Enabled() {
+		return nil, fmt.Errorf("commands are disabled, the server has no tokens")
+	}
+	principal, err := s.opts.Auth.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	audit.Principal, audit.Role, audit.Method = principal.Name, principal.Role, principal.Method
+	return principal, nil
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	limit := DEFAULT_RUNS_LIMIT
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+	}
+	jobs := s.jobs.List(r.URL.Query().Get("environment"))
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// Serve the log of a job from an offset. X-Log-Offset is the offset to
+// follow the log from.
+func (s *Server) handleJobLog(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.jobs.Get(id); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job %s", id))
+		return
+	}
+	var offset int64
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset %q", v))
+			return
+		}
+	}
+	var data []byte
+	file, err := os.Open(s.jobs.LogFile(id))
+	if err == nil {
+		defer file.Close()
+		if _, err = file.Seek(offset, io.SeekStart); err == nil {
+			data, err = io.ReadAll(file)
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Log-Offset", strconv.FormatInt(offset+int64(len(data)), 10))
+	w.Write(data)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	audit := APIAuditRecord{Time: time.Now(), Remote: r.RemoteAddr, Command: "resume", Job: id, Status: API_DENIED}
+	refuse := func(status int, err error) {
+		audit.Error = err.Error()
+		s.audit(audit)
+		writeError(w, status, err)
+	}
+
+	principal, err := s.commandCaller(r, &audit)
+	if err != nil {
+		refuse(http.StatusUnauthorized, err)
+		return
+	}
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		refuse(http.StatusNotFound, fmt.Errorf("unknown job %s", id))
+		return
+	}
+	audit.Environment, audit.Command, audit.Args = job.Environment, job.Command, job.Args
+	if reason := s.opts.Auth.Allowed(principal, job.Command); reason != "" {
+		refuse(http.StatusForbidden, fmt.Errorf("%s is not allowed for role %s: %s", job.Command, principal.Role, reason))
+		return
+	}
+	if job, err = s.jobs.Resume(id); err != nil {
+		refuse(http.StatusConflict, err)
+		return
+	}
+	audit.Status = JOB_QUEUED
+	s.audit(audit)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// Jobs against the same database run one at a time, environments without
+// a known database are their own target
+func (s *Server) jobTarget(job *Job) string {
+	if _, dsn, err := s.opts.Environments[job.Environment].DatabaseDSN(); err == nil {
+		return dsn
+	}
+	return "environment:" + job.Environment
+}
+
+func (s *Server) runJob(ctx context.Context, job *Job, output io.Writer) error {
+	pl, ok := s.opts.Environments[job.Environment]
+	if !ok {
+		return fmt.Errorf("unknown environment %s", job.Environment)
+	}
+	switch job.Command {
+	case OPERATION_DRIFT:
+		return s.runDrift(ctx, job, output)
+	case OPERATION_EXPORT_STATUS:
+		return s.exportStatus(ctx, job, output)
+	}
+	if err := pl.Initialize(); err != nil {
+		return err
+	}
+	if err := pl.ExecuteWithOptions(ctx, ExecOptions{Stdout: output, Stderr: output}, append([]string{job.Command}, job.Args...)...); err != nil {
+		return err
+	}
+	// A schema that couldn't be published doesn't fail the update
+	if pl.SchemaRegistry != nil && s.opts.OpenDB != nil && containsString(PUBLISHED_COMMANDS, job.Command) {
+		written, err := pl.PublishSchema(ctx, s.opts.OpenDB)
+		if err != nil {
+			fmt.Fprintf(output, "Failed to publish the schema: %v\n", err)
+		} else {
+			fmt.Fprintf(output, "Schema published to %s\n", strings.Join(written, ", "))
+		}
+	}
+	return nil
+}
+
+// Compare an environment with its baseline, failing when it drifted
+func (s *Server) runDrift(ctx context.Context, job *Job, output io.Writer) error {
+	if s.opts.Drift == nil {
+		return fmt.Errorf("drift checks aren't enabled")
+	}
+	ctx, cancel := context.WithTimeout(ctx, SERVER_QUERY_TIMEOUT)
+	defer cancel()
+	pl := s.opts.Environments[job.Environment]
+	diffs, err := s.opts.Drift(ctx, pl)
+	if err != nil {
+		return err
+	}
+	event := Event{Type: EVENT_COMPLETED, RunID: job.ID, Command: OPERATION_DRIFT, Differences: len(diffs)}
+	if schedule, ok := s.jobSchedule(job); ok {
+		event.Schedule = schedule.Name
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(output, "%s matches its baseline\n", job.Environment)
+		pl.SendEvent(event)
+		return nil
+	}
+	for _, diff := range diffs {
+		fmt.Fprintf(output, "%s\n", diff)
+	}
+	err = fmt.Errorf("%s drifted from its baseline: %d difference(s)", job.Environment, len(diffs))
+	event.Type, event.Error = EVENT_FAILED, err.Error()
+	pl.SendEvent(event)
+	return err
+}
+
+// Write the state of an environment as JSON to the --output argument
+func (s *Server) exportStatus(ctx context.Context, job *Job, output io.Writer) error {
+	var path string
+	for _, arg := range job.Args {
+		if v, ok := strings.CutPrefix(arg, "--output="); ok {
+			path = v
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("export-status needs an output file")
+	}
+	path = strings.NewReplacer("{env}", job.Environment, "{time}", job.Start.UTC().Format("20060102T150405")).Replace(path)
+	state := s.environmentState(ctx, job.Environment)
+	// The export is the job running on the environment
+	state.Running, state.Queued = "", 0
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Wrote the status of %s to %s\n", job.Environment, path)
+	if state.Error != "" {
+		return fmt.Errorf("status of %s: %s", job.Environment, state.Error)
+	}
+	return nil
+}
+
+// Log an API action to the audit file
+func (s *Server) audit(record APIAuditRecord) {
+	if s.opts.AuditFile == "" {
+		return
+	}
+	if err := AppendAPIAudit(s.opts.AuditFile, record); err != nil {
+		log.Printf("Failed to write API audit log %s: %v", s.opts.AuditFile, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
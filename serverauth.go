@@ -0,0 +1,523 @@
+package goliquify
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API roles from least to most privileged
+const (
+	ROLE_VIEWER   = "viewer"
+	ROLE_OPERATOR = "operator"
+	ROLE_ADMIN    = "admin"
+)
+
+const (
+	DEFAULT_API_AUDIT_FILE = ".goliquify/api-audit.jsonl"
+	DEFAULT_ROLE_CLAIM     = "groups"
+	// OIDC signing keys are refreshed at most this often when a token
+	// names an unknown key
+	OIDC_KEYS_REFRESH = time.Minute
+	OIDC_CLOCK_SKEW   = time.Minute
+)
+
+// Outcomes of API actions in the audit log
+const (
+	API_DENIED = "denied"
+)
+
+var SERVER_ROLES = []string{ROLE_VIEWER, ROLE_OPERATOR, ROLE_ADMIN}
+
+// DEFAULT_ROLE_POLICIES are the commands each role may trigger, replaced
+// per role by the roles of the server config
+var DEFAULT_ROLE_POLICIES = map[string]*CommandPolicy{
+	ROLE_VIEWER:   {Allow: []string{"status", "validate", "diff", "history"}},
+	ROLE_OPERATOR: {Allow: []string{"status", "validate", "diff", "history", "updateSQL", "update", "rollbackSQL"}},
+	ROLE_ADMIN:    {},
+}
+
+// ServerConfig holds the authentication of the API server
+type ServerConfig struct {
+	// Tokens are static bearer tokens, e.g. for CI
+	Tokens []APIToken `yaml:"tokens"`
+	// OIDC accepts ID tokens of an OpenID Connect provider
+	OIDC *OIDCConfig `yaml:"oidc"`
+	// Roles replace the commands a role may trigger
+	Roles map[string]*CommandPolicy `yaml:"roles"`
+	// Audit is the audit log of API triggered actions, 'off' to disable
+	Audit string `yaml:"audit"`
+	// Schedules are operations the server runs periodically
+	Schedules []ScheduledOperation `yaml:"schedules"`
+}
+
+// APIToken is a static bearer token and its role. The token is read from
+// an environment variable, or only its SHA-256 is configured.
+type APIToken struct {
+	Name   string `yaml:"name"`
+	Role   string `yaml:"role"`
+	Env    string `yaml:"env"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// OIDCConfig accepts the ID tokens an OpenID Connect provider issues for an
+// audience, with roles mapped from a claim
+type OIDCConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// RoleClaim holds the groups of the user, groups by default
+	RoleClaim string `yaml:"roleClaim"`
+	// Roles maps claim values to roles, the most privileged one applies
+	Roles map[string]string `yaml:"roles"`
+	// DefaultRole applies to users without a mapped claim value, they are
+	// refused when empty
+	DefaultRole string `yaml:"defaultRole"`
+}
+
+// Principal is an authenticated API caller
+type Principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Method is token or oidc
+	Method string `json:"method"`
+}
+
+// APIAuditRecord is an action triggered through the API
+type APIAuditRecord struct {
+	Time        time.Time `json:"time"`
+	Principal   string    `json:"principal,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	Remote      string    `json:"remote,omitempty"`
+	Environment string    `json:"environment"`
+	Command     string    `json:"command"`
+	Args        []string  `json:"args,omitempty"`
+	// Job is the job the command was queued as
+	Job string `json:"job,omitempty"`
+	// Status is denied, queued, completed, failed or interrupted
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Validate checks the roles of the server config
+func (c *ServerConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, t := range c.Tokens {
+		if !containsString(SERVER_ROLES, t.Role) {
+			return fmt.Errorf("server token %s has role %q, expecting one of %s", t.Name, t.Role, strings.Join(SERVER_ROLES, ", "))
+		}
+		if (t.Env == "") == (t.SHA256 == "") {
+			return fmt.Errorf("server token %s needs either env or sha256", t.Name)
+		}
+	}
+	if c.OIDC != nil {
+		if c.OIDC.Issuer == "" || c.OIDC.Audience == "" {
+			return fmt.Errorf("server oidc needs an issuer and an audience")
+		}
+		for value, role := range c.OIDC.Roles {
+			if !containsString(SERVER_ROLES, role) {
+				return fmt.Errorf("server oidc maps %s to role %q, expecting one of %s", value, role, strings.Join(SERVER_ROLES, ", "))
+			}
+		}
+		if c.OIDC.DefaultRole != "" && !containsString(SERVER_ROLES, c.OIDC.DefaultRole) {
+			return fmt.Errorf("server oidc default role %q, expecting one of %s", c.OIDC.DefaultRole, strings.Join(SERVER_ROLES, ", "))
+		}
+	}
+	for role := range c.Roles {
+		if !containsString(SERVER_ROLES, role) {
+			return fmt.Errorf("server roles: unknown role %s, expecting one of %s", role, strings.Join(SERVER_ROLES, ", "))
+		}
+	}
+	for _, op := range c.Schedules {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authenticator checks the bearer tokens of API requests
+type Authenticator struct {
+	tokens   []resolvedToken
+	oidc     *oidcVerifier
+	policies map[string]*CommandPolicy
+}
+
+type resolvedToken struct {
+	principal Principal
+	digest    []byte
+}
+
+// NewAuthenticator resolves the tokens of a server config. adminToken is
+// an additional token with the admin role, e.g. from the command line.
+func NewAuthenticator(cfg *ServerConfig, adminToken string) (*Authenticator, error) {
+	if cfg == nil {
+		cfg = &ServerConfig{}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	a := &Authenticator{policies: map[string]*CommandPolicy{}}
+	for role, policy := range DEFAULT_ROLE_POLICIES {
+		a.policies[role] = policy
+	}
+	for role, policy := range cfg.Roles {
+		a.policies[role] = policy
+	}
+	if adminToken != "" {
+		digest := sha256.Sum256([]byte(adminToken))
+		a.tokens = append(a.tokens, resolvedToken{Principal{Name: "admin-token", Role: ROLE_ADMIN, Method: "token"}, digest[:]})
+	}
+	for _, t := range cfg.Tokens {
+		var digest []byte
+		if t.Env != "" {
+			token := os.Getenv(t.Env)
+			if token == "" {
+				return nil, fmt.Errorf("server token %s: %s is not set", t.Name, t.Env)
+			}
+			sum := sha256.Sum256([]byte(token))
+			digest = sum[:]
+		} else {
+			var err error
+			if digest, err = hex.DecodeString(t.SHA256); err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("server token %s: sha256 isn't a hex SHA-256 digest", t.Name)
+			}
+		}
+		a.tokens = append(a.tokens, resolvedToken{Principal{Name: t.Name, Role: t.Role, Method: "token"}, digest})
+	}
+	if cfg.OIDC != nil {
+		a.oidc = &oidcVerifier{cfg: cfg.OIDC, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return a, nil
+}
+
+// Enabled reports if any token or provider is configured. Without one
+// nothing authenticates.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.tokens) > 0 || a.oidc != nil)
+}
+
+// Authenticate returns the principal of a request's bearer token
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, fmt.Errorf("a bearer token is required")
+	}
+	if a.oidc != nil && strings.Count(token, ".") == 2 {
+		return a.oidc.authenticate(r.Context(), token)
+	}
+	digest := sha256.Sum256([]byte(token))
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], t.digest) == 1 {
+			principal := t.principal
+			return &principal, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid token")
+}
+
+// Allowed returns the reason a principal may not trigger a command, or an
+// empty string
+func (a *Authenticator) Allowed(p *Principal, command string) string {
+	policy, ok := a.policies[p.Role]
+	if !ok {
+		return fmt.Sprintf("unknown role %s", p.Role)
+	}
+	return policy.Check(command, "")
+}
+
+// AppendAPIAudit appends a record to the API audit log
+func AppendAPIAudit(path string, record APIAuditRecord) error {
+	return appendJSONLine(path, record)
+}
+
+// oidcVerifier checks ID tokens against the signing keys the provider
+// publishes, discovered from its issuer
+type oidcVerifier struct {
+	cfg     *OIDCConfig
+	client  *http.Client
+	mu      sync.Mutex
+	keys    map[string]signingKey
+	fetched time.Time
+}
+
+// signingKey is a provider key with the one algorithm it verifies, so a
+// token can't pick another one for it
+type signingKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// The JWS algorithm of each EC curve
+var EC_CURVE_ALGS = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}
+
+func (v *oidcVerifier) authenticate(ctx context.Context, token string) (*Principal, error) {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	name, _ := claims["email"].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+
+	// The most privileged role of the claim values
+	claim := v.cfg.RoleClaim
+	if claim == "" {
+		claim = DEFAULT_ROLE_CLAIM
+	}
+	var values []string
+	switch value := claims[claim].(type) {
+	case string:
+		values = []string{value}
+	case []any:
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	role, rank := v.cfg.DefaultRole, -1
+	for _, value := range values {
+		if mapped, ok := v.cfg.Roles[value]; ok {
+			if i := indexOf(SERVER_ROLES, mapped); i > rank {
+				role, rank = mapped, i
+			}
+		}
+	}
+	if role == "" {
+		return nil, fmt.Errorf("%s has no role", name)
+	}
+	return &Principal{Name: name, Role: role, Method: "oidc"}, nil
+}
+
+// Verify the signature and the registered claims of a JWT
+func (v *oidcVerifier) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return nil, fmt.Errorf("issued by %q", iss)
+	}
+	audienceOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audienceOK = aud == v.cfg.Audience
+	case []any:
+		for _, a := range aud {
+			audienceOK = audienceOK || a == v.cfg.Audience
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("not issued for %s", v.cfg.Audience)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(OIDC_CLOCK_SKEW)) {
+		return nil, fmt.Errorf("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(OIDC_CLOCK_SKEW).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key signingKey, signed string, signature []byte) error {
+	if alg != key.alg {
+		return fmt.Errorf("%s token for a %s key", alg, key.alg)
+	}
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("%s with an RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("%s with an EC key", alg)
+		}
+		if len(signature) != 2*size {
+			return fmt.Errorf("malformed %s signature", alg)
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key")
+	}
+	return nil
+}
+
+// Return a signing key of the provider, fetching the keys when the key
+// isn't known, rotated keys appear that way
+func (v *oidcVerifier) key(ctx context.Context, kid string) (signingKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < OIDC_KEYS_REFRESH {
+		return signingKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	keys, err := v.fetchKeys(ctx)
+	v.fetched = time.Now()
+	if err != nil {
+		return signingKey{}, fmt.Errorf("failed to fetch the signing keys of %s: %v", v.cfg.Issuer, err)
+	}
+	v.keys = keys
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return signingKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Fetch the provider's keys. EC keys verify the algorithm of their curve,
+// RSA keys the alg of the JWK or RS256, the OIDC default, without one.
+func (v *oidcVerifier) fetchKeys(ctx context.Context) (map[string]signingKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, strings.TrimSuffix(v.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("no jwks_uri in the discovery document")
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]signingKey{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			alg := firstNonEmpty(k.Alg, "RS256")
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if !containsString([]string{"RS256", "RS384", "RS512"}, alg) || errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = signingKey{alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[k.Crv]
+			alg := EC_CURVE_ALGS[k.Crv]
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if !ok || (k.Alg != "" && k.Alg != alg) || errX != nil || errY != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if _, err := key.ECDH(); err != nil {
+				continue
+			}
+			keys[k.Kid] = signingKey{alg, key}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (v *oidcVerifier) getJSON(ctx context.Context, url string, result any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := v.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
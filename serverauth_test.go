@@ -0,0 +1,126 @@
+package goliquify
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A provider publishing the given keys
+func oidcProvider(t *testing.T, keys ...map[string]string) *oidcVerifier {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	return &oidcVerifier{cfg: &OIDCConfig{Issuer: server.URL, Audience: "goliquify"}, client: server.Client()}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{"kty": "EC", "kid": kid, "crv": key.Curve.Params().Name,
+		"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))}
+}
+
+// Sign a token with the hash of alg, whatever the key
+func signJWT(t *testing.T, v *oidcVerifier, alg, kid string, key crypto.Signer) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	claims, _ := json.Marshal(map[string]any{"iss": v.cfg.Issuer, "aud": "goliquify", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	h := hash.New()
+	h.Write([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCAlgorithmIsPinnedPerKey(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaJWK := map[string]string{"kty": "RSA", "kid": "rsa",
+		"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())}
+	v := oidcProvider(t, ecJWK("p256", p256), ecJWK("p384", p384), rsaJWK)
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      crypto.Signer
+		valid    bool
+	}{
+		{"ES256", "p256", p256, true},
+		{"ES384", "p384", p384, true},
+		{"RS256", "rsa", rsaKey, true},
+		// The hash of another algorithm over the same curve
+		{"ES384", "p256", p256, false},
+		{"ES512", "p256", p256, false},
+		{"ES256", "p384", p384, false},
+		// RSA keys without an alg only verify RS256
+		{"RS512", "rsa", rsaKey, false},
+		{"ES256", "rsa", p256, false},
+	} {
+		_, err := v.verify(context.Background(), signJWT(t, v, tc.alg, tc.kid, tc.key))
+		if tc.valid && err != nil {
+			t.Errorf("%s token for %s rejected: %v", tc.alg, tc.kid, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s token for %s accepted", tc.alg, tc.kid)
+		}
+	}
+
+	token := signJWT(t, v, "ES256", "p256", p256)
+	for _, malformed := range []string{"", "a.b", token[:strings.LastIndex(token, ".")], token + ".x"} {
+		if _, err := v.verify(context.Background(), malformed); err == nil {
+			t.Errorf("malformed token %q accepted", malformed)
+		}
+	}
+}
+
+func TestOIDCKeysWithAnotherAlgAreSkipped(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mislabeled := ecJWK("p256", p256)
+	mislabeled["alg"] = "ES384"
+	encryption := ecJWK("enc", p256)
+	encryption["use"] = "enc"
+	v := oidcProvider(t, mislabeled, encryption, ecJWK("ok", p256))
+
+	keys, err := v.fetchKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys["ok"].alg != "ES256" {
+		t.Fatalf("keys %+v, want only ok for ES256", keys)
+	}
+}
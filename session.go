@@ -0,0 +1,133 @@
+package goliquify
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Changelog properties carrying the session settings, e.g. goliquify.session.lock_timeout
+const PROPERTY_SESSION_PREFIX = "goliquify.session."
+
+var (
+	sessionNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+	sessionValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.:+-]+$`)
+	jdbcSchemePattern   = regexp.MustCompile(`^jdbc:(postgresql|mysql|mariadb):`)
+)
+
+// ValidateSessionSettings checks session parameter names and values. Values
+// are kept to plain words, numbers and durations so they can't break out of
+// the JDBC url.
+func ValidateSessionSettings(settings map[string]string) error {
+	for name, value := range settings {
+		if !sessionNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid session setting name %q", name)
+		}
+		if !sessionValuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value %q for session setting %s, expecting a word, number or duration like 5s", value, name)
+		}
+	}
+	return nil
+}
+
+// SessionURL adds session settings to a Postgres, MySQL or MariaDB JDBC url,
+// through the options parameter of the Postgres driver and the
+// sessionVariables parameter of the MySQL and MariaDB drivers. Setting names
+// are the database's own, e.g. lock_timeout on Postgres and lock_wait_timeout
+// on MySQL. Other urls are returned unchanged with ok false.
+func SessionURL(jdbcURL string, settings map[string]string) (string, bool) {
+	m := jdbcSchemePattern.FindStringSubmatch(jdbcURL)
+	if m == nil || len(settings) == 0 {
+		return jdbcURL, false
+	}
+	base, query, _ := strings.Cut(jdbcURL, "?")
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		if param != "" {
+			params = append(params, param)
+		}
+	}
+
+	var parameter, separator string
+	var assignments []string
+	for _, name := range sortedKeys(settings) {
+		if m[1] == "postgresql" {
+			assignments = append(assignments, fmt.Sprintf("-c %s=%s", name, settings[name]))
+		} else {
+			assignments = append(assignments, fmt.Sprintf("%s=%s", name, settings[name]))
+		}
+	}
+	if m[1] == "postgresql" {
+		parameter, separator = "options", " "
+	} else {
+		parameter, separator = "sessionVariables", ","
+	}
+	value := strings.Join(assignments, separator)
+
+	// Settings already in the url are kept, the new ones follow them
+	found := false
+	for i, param := range params {
+		key, existing, _ := strings.Cut(param, "=")
+		if key != parameter {
+			continue
+		}
+		if decoded, err := url.QueryUnescape(existing); err == nil {
+			existing = decoded
+		}
+		params[i] = parameter + "=" + url.QueryEscape(existing+separator+value)
+		found = true
+	}
+	if !found {
+		params = append(params, parameter+"="+url.QueryEscape(value))
+	}
+	return base + "?" + strings.Join(params, "&"), true
+}
+
+// Add the session settings to the url of a command. A --url argument is
+// rewritten, otherwise the url of the defaults file is passed as one.
+func (pl *GoLiquibase) sessionArgs(cmdArgs []string) ([]string, error) {
+	if len(pl.SessionSettings) == 0 {
+		return cmdArgs, nil
+	}
+	if err := ValidateSessionSettings(pl.SessionSettings); err != nil {
+		return nil, err
+	}
+	for i, arg := range cmdArgs {
+		if jdbcURL, ok := strings.CutPrefix(arg, "--url="); ok {
+			if sessionURL, ok := SessionURL(jdbcURL, pl.SessionSettings); ok {
+				cmdArgs[i] = "--url=" + sessionURL
+			} else {
+				pl.logger().Printf("Session settings can't be added to %s, they are only passed as changelog properties", stripURLCredentials(jdbcURL))
+			}
+			return cmdArgs, nil
+		}
+	}
+	if pl.DefaultsFile == "" || !fileExists(pl.DefaultsFile) {
+		return cmdArgs, nil
+	}
+	props, err := ReadDefaultsFile(pl.DefaultsFile)
+	if err != nil {
+		return nil, err
+	}
+	jdbcURL := firstNonEmpty(props["url"], props["liquibase.command.url"])
+	if jdbcURL == "" {
+		return cmdArgs, nil
+	}
+	sessionURL, ok := SessionURL(jdbcURL, pl.SessionSettings)
+	if !ok {
+		pl.logger().Printf("Session settings can't be added to %s, they are only passed as changelog properties", stripURLCredentials(jdbcURL))
+		return cmdArgs, nil
+	}
+	return append(cmdArgs, "--url="+sessionURL), nil
+}
+
+// The session settings as changelog properties, for changelogs that set them
+// themselves, e.g. in a preamble changeset with runAlways
+func (pl *GoLiquibase) sessionProperties() map[string]string {
+	props := map[string]string{}
+	for name, value := range pl.SessionSettings {
+		props[PROPERTY_SESSION_PREFIX+name] = value
+	}
+	return props
+}
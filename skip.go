@@ -0,0 +1,261 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Label given to the changesets a run leaves out, filtered with !SKIP_CHANGESET_LABEL
+const SKIP_CHANGESET_LABEL = "goliquify-skip"
+
+var (
+	xmlChangeSetTagPattern = regexp.MustCompile(`<(\w+:)?changeSet\b[^>]*>`)
+	xmlLabelsPattern       = regexp.MustCompile(`\slabels\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// ChangeSetFilter leaves changesets out of a single run without editing the
+// changelog: the changelog files defining them are rendered to a temporary
+// directory with a goliquify-skip label on the left out changesets, ahead of
+// the search path, and the label filter excludes the label. Changesets are
+// given as id::author::path, or id::author when unambiguous.
+type ChangeSetFilter struct {
+	// Skip leaves these changesets out
+	Skip []string
+	// Only runs just these changesets
+	Only []string
+}
+
+func (f ChangeSetFilter) empty() bool {
+	return len(f.Skip) == 0 && len(f.Only) == 0
+}
+
+// Find the changeset a filter entry names
+func findFilteredChangeSet(tree *ChangelogTree, entry string) (*ChangeSet, error) {
+	parts := strings.SplitN(entry, "::", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid changeset %q, expecting id::author::path", entry)
+	}
+	if len(parts) == 3 {
+		if cs := tree.Find(parts[2] + "::" + parts[0] + "::" + parts[1]); cs != nil {
+			return cs, nil
+		}
+		return nil, fmt.Errorf("changeset %s not found in the changelog", entry)
+	}
+	var match *ChangeSet
+	for _, cs := range tree.ChangeSets {
+		if cs.ID == parts[0] && cs.Author == parts[1] {
+			if match != nil {
+				return nil, fmt.Errorf("changeset %s is defined in %s and %s, add the path", entry, match.File, cs.File)
+			}
+			match = cs
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("changeset %s not found in the changelog", entry)
+	}
+	return match, nil
+}
+
+// Render the changelog files with the changesets the filter leaves out
+// labelled into dir and return the arguments pointing Liquibase at them:
+// the rendered directory ahead of the search path, and the label filter of
+// the arguments, or the defaults file, excluding the label. Commands
+// without a changelog are left alone.
+func (pl *GoLiquibase) changeSetFilterArgs(cmdArgs []string, dir string) ([]string, error) {
+	changelog, searchPath := pl.ChangelogLocation(cmdArgs...)
+	if changelog == "" {
+		return cmdArgs, nil
+	}
+	if pl.TemplateDir != "" {
+		return nil, fmt.Errorf("changeset filters can't be combined with changelog templates")
+	}
+	tree, err := LoadChangelogTree(changelog, searchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := map[*ChangeSet]bool{}
+	if len(pl.ChangeSetFilter.Only) > 0 {
+		for _, cs := range tree.ChangeSets {
+			skipped[cs] = true
+		}
+		for _, entry := range pl.ChangeSetFilter.Only {
+			cs, err := findFilteredChangeSet(tree, entry)
+			if err != nil {
+				return nil, err
+			}
+			delete(skipped, cs)
+			pl.logger().Printf("Running only changeset %s", cs.Key())
+		}
+	}
+	for _, entry := range pl.ChangeSetFilter.Skip {
+		cs, err := findFilteredChangeSet(tree, entry)
+		if err != nil {
+			return nil, err
+		}
+		skipped[cs] = true
+		pl.logger().Printf("Skipping changeset %s for this run", cs.Key())
+	}
+
+	for _, file := range tree.Files {
+		var labelled []*ChangeSet
+		for _, cs := range file.ChangeSets {
+			if skipped[cs] {
+				labelled = append(labelled, cs)
+			}
+		}
+		if len(labelled) == 0 {
+			continue
+		}
+		if filepath.IsAbs(file.Path) {
+			return nil, fmt.Errorf("can't filter changesets of %s, it is included by an absolute path", file.Path)
+		}
+		content, err := os.ReadFile(file.DiskPath)
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := labelChangeSets(file.DiskPath, content, labelled)
+		if err != nil {
+			return nil, err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(target, rendered, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	// The label filter in effect, combined with the exclusion
+	labelFilter := ""
+	if props, err := ReadDefaultsFile(pl.DefaultsFile); err == nil {
+		labelFilter = firstNonEmpty(props["label-filter"], props["labelFilter"], props["labels"], props["liquibase.command.labelFilter"])
+	}
+	var args []string
+	for _, arg := range cmdArgs {
+		if v, ok := strings.CutPrefix(arg, "--label-filter="); ok {
+			labelFilter = v
+		} else if v, ok := strings.CutPrefix(arg, "--labels="); ok {
+			labelFilter = v
+		} else if !strings.HasPrefix(arg, "--search-path=") {
+			args = append(args, arg)
+		}
+	}
+	exclude := "!" + SKIP_CHANGESET_LABEL
+	if labelFilter != "" {
+		exclude = fmt.Sprintf("(%s) and %s", labelFilter, exclude)
+	}
+	// The rendered copies shadow the originals on the search path
+	args = append(args,
+		"--search-path="+strings.Join(append([]string{dir}, searchPath...), ","),
+		"--duplicate-file-mode=SILENT",
+		"--label-filter="+exclude,
+	)
+	return args, nil
+}
+
+// Add the skip label to changesets of a changelog. Labels aren't part of
+// the checksum, the rendered changesets keep theirs.
+func labelChangeSets(diskPath string, content []byte, changeSets []*ChangeSet) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(diskPath)) {
+	case ".xml":
+		return labelXMLChangeSets(content, changeSets), nil
+	case ".yaml", ".yml", ".json":
+		return labelYAMLChangeSets(content, changeSets)
+	case ".sql":
+		return labelSQLChangeSets(content, changeSets), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported changelog format", diskPath)
+}
+
+// Labels with the skip label added
+func addSkipLabel(labels string) string {
+	if strings.TrimSpace(labels) == "" {
+		return SKIP_CHANGESET_LABEL
+	}
+	return labels + "," + SKIP_CHANGESET_LABEL
+}
+
+// Label the changeSet tags starting on the lines of the changesets
+func labelXMLChangeSets(content []byte, changeSets []*ChangeSet) []byte {
+	offsets := lineOffsets(content)
+	text := string(content)
+	// From the end, so the offsets of earlier changesets stay valid
+	for i := len(changeSets) - 1; i >= 0; i-- {
+		start := offsets[changeSets[i].Line-1]
+		loc := xmlChangeSetTagPattern.FindStringIndex(text[start:])
+		if loc == nil {
+			continue
+		}
+		tag := text[start+loc[0] : start+loc[1]]
+		if m := xmlLabelsPattern.FindStringSubmatchIndex(tag); m != nil {
+			value := tag[m[2]+1 : m[3]-1]
+			tag = tag[:m[2]] + `"` + addSkipLabel(value) + `"` + tag[m[3]:]
+		} else {
+			end := strings.Index(tag, "changeSet") + len("changeSet")
+			tag = tag[:end] + ` labels="` + SKIP_CHANGESET_LABEL + `"` + tag[end:]
+		}
+		text = text[:start+loc[0]] + tag + text[start+loc[1]:]
+	}
+	return []byte(text)
+}
+
+// Label the changesets of a YAML or JSON changelog. The document is written
+// back as YAML, which Liquibase also reads from .json files.
+func labelYAMLChangeSets(content []byte, changeSets []*ChangeSet) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	lines := map[int]bool{}
+	for _, cs := range changeSets {
+		lines[cs.Line] = true
+	}
+	entries := mappingValue(doc.Content[0], "databaseChangeLog")
+	for _, entry := range entries.Content {
+		node := mappingValue(entry, "changeSet")
+		if node == nil || !lines[entry.Line] {
+			continue
+		}
+		if labels := mappingValue(node, "labels"); labels != nil {
+			labels.Value = addSkipLabel(labels.Value)
+		} else {
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: "labels"},
+				&yaml.Node{Kind: yaml.ScalarNode, Value: SKIP_CHANGESET_LABEL})
+		}
+	}
+	return yaml.Marshal(&doc)
+}
+
+// Label the --changeset lines of a formatted SQL changelog
+func labelSQLChangeSets(content []byte, changeSets []*ChangeSet) []byte {
+	lines := strings.Split(string(content), "\n")
+	for _, cs := range changeSets {
+		line := lines[cs.Line-1]
+		trimmed := strings.TrimRight(line, "\r")
+		labelled := false
+		for _, m := range sqlAttributePattern.FindAllStringSubmatchIndex(trimmed, -1) {
+			if trimmed[m[2]:m[3]] == "labels" {
+				value := addSkipLabel(strings.Trim(trimmed[m[4]:m[5]], `"`))
+				if strings.ContainsAny(value, " \t") {
+					value = `"` + value + `"`
+				}
+				trimmed = trimmed[:m[4]] + value + trimmed[m[5]:]
+				labelled = true
+				break
+			}
+		}
+		if !labelled {
+			trimmed += " labels:" + SKIP_CHANGESET_LABEL
+		}
+		lines[cs.Line-1] = trimmed + line[len(strings.TrimRight(line, "\r")):]
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
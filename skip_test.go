@@ -0,0 +1,146 @@
+package goliquify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChangeSetFilterLabelsSkippedChangeSets(t *testing.T) {
+	for _, tc := range []struct {
+		name, changelog string
+		// Lines of the rendered changelog, labelled and left alone
+		labelled, unlabelled []string
+	}{
+		{
+			"changelog.xml",
+			`<?xml version="1.0" encoding="UTF-8"?>
+<databaseChangeLog xmlns="http://www.liquibase.org/xml/ns/dbchangelog">
+    <changeSet id="1" author="bob">
+        <sql>CREATE TABLE a (id INT)</sql>
+    </changeSet>
+    <changeSet id="2" author="bob" labels="billing">
+        <sql>CREATE TABLE b (id INT)</sql>
+    </changeSet>
+    <changeSet id="3" author="bob">
+        <sql>CREATE TABLE c (id INT)</sql>
+    </changeSet>
+</databaseChangeLog>
+`,
+			[]string{`<changeSet id="2" author="bob" labels="billing,goliquify-skip">`, `<changeSet labels="goliquify-skip" id="3" author="bob">`},
+			[]string{`<changeSet id="1" author="bob">`},
+		},
+		{
+			"changelog.yaml",
+			`databaseChangeLog:
+  - changeSet:
+      id: "1"
+      author: bob
+      changes:
+        - sql: CREATE TABLE a (id INT)
+  - changeSet:
+      id: "2"
+      author: bob
+      labels: billing
+      changes:
+        - sql: CREATE TABLE b (id INT)
+  - changeSet:
+      id: "3"
+      author: bob
+      changes:
+        - sql: CREATE TABLE c (id INT)
+`,
+			[]string{"labels: billing,goliquify-skip", "labels: goliquify-skip"},
+			nil,
+		},
+		{
+			"changelog.sql",
+			`--liquibase formatted sql
+
+--changeset bob:1
+CREATE TABLE a (id INT);
+
+--changeset bob:2 labels:billing
+CREATE TABLE b (id INT);
+
+--changeset bob:3
+CREATE TABLE c (id INT);
+`,
+			[]string{"--changeset bob:2 labels:billing,goliquify-skip", "--changeset bob:3 labels:goliquify-skip"},
+			[]string{"--changeset bob:1\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chdir(t, t.TempDir())
+			if err := os.WriteFile(tc.name, []byte(tc.changelog), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			pl := New(WithChangeSetFilter([]string{"2::bob", "3::bob::" + tc.name}, nil))
+			args, err := pl.changeSetFilterArgs([]string{"update", "--changelog-file=" + tc.name}, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rendered, err := os.ReadFile(filepath.Join(dir, tc.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tc.labelled {
+				if !strings.Contains(string(rendered), line) {
+					t.Errorf("rendered changelog has no %q:\n%s", line, rendered)
+				}
+			}
+			for _, line := range tc.unlabelled {
+				if !strings.Contains(string(rendered), line) {
+					t.Errorf("changeset 1 was changed, no %q:\n%s", line, rendered)
+				}
+			}
+			if n := strings.Count(string(rendered), SKIP_CHANGESET_LABEL); n != 2 {
+				t.Errorf("%d changesets labelled, want 2:\n%s", n, rendered)
+			}
+			want := []string{"update", "--changelog-file=" + tc.name, "--search-path=" + dir + ",.", "--duplicate-file-mode=SILENT", "--label-filter=!" + SKIP_CHANGESET_LABEL}
+			if strings.Join(args, " ") != strings.Join(want, " ") {
+				t.Errorf("arguments %q, want %q", args, want)
+			}
+		})
+	}
+}
+
+func TestChangeSetFilterKeepsTheLabelFilter(t *testing.T) {
+	chdir(t, t.TempDir())
+	changelog := "--liquibase formatted sql\n\n--changeset bob:1\nSELECT 1;\n\n--changeset bob:2\nSELECT 2;\n"
+	if err := os.WriteFile("changelog.sql", []byte(changelog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("liquibase.properties", []byte("labels: from-defaults\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args   []string
+		filter string
+	}{
+		{nil, "(from-defaults) and !goliquify-skip"},
+		{[]string{"--label-filter=billing or shipping"}, "(billing or shipping) and !goliquify-skip"},
+		{[]string{"--labels=billing"}, "(billing) and !goliquify-skip"},
+	} {
+		pl := New(WithDefaultsFile("liquibase.properties"), WithChangeSetFilter(nil, []string{"1::bob"}))
+		args, err := pl.changeSetFilterArgs(append([]string{"update", "--changelog-file=changelog.sql", "--search-path=."}, tc.args...), t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var filters []string
+		for _, arg := range args {
+			if filter, ok := strings.CutPrefix(arg, "--label-filter="); ok {
+				filters = append(filters, filter)
+			}
+			if strings.HasPrefix(arg, "--labels=") {
+				t.Errorf("%v: --labels kept next to the label filter", tc.args)
+			}
+		}
+		if len(filters) != 1 || filters[0] != tc.filter {
+			t.Errorf("%v: label filters %q, want %q", tc.args, filters, tc.filter)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package goliquify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Version of builds whose artifact name has none
+	SNAPSHOT_VERSION = "snapshot"
+	// How long a build installed from a url is used before it is downloaded
+	// again, so nightly builds are picked up
+	SNAPSHOT_REFRESH_INTERVAL = 12 * time.Hour
+)
+
+// Version in the name of a distribution, e.g. liquibase-4.33.0-SNAPSHOT.tar.gz
+var artifactVersionPattern = regexp.MustCompile(`^liquibase-(\d+\.\d+\.\d+(?:[-+][0-9A-Za-z.-]+?)?)(?:\.zip|\.tar\.gz|\.tgz)?$`)
+
+// Parse a Liquibase version, pre-releases and snapshots as the release they lead to
+func liquibaseSemver(version string) (Semver, error) {
+	if i := strings.IndexAny(version, "-+"); i > 0 {
+		version = version[:i]
+	}
+	return ParseSemver(version)
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// The version a build is installed as, from its artifact name
+func artifactVersion(location string) string {
+	name := path.Base(filepath.ToSlash(location))
+	if isURL(location) {
+		name = path.Base(strings.SplitN(location, "?", 2)[0])
+	}
+	if m := artifactVersionPattern.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return SNAPSHOT_VERSION
+}
+
+// Point the instance at the cache dir of the LiquibaseArtifact build, which
+// is keyed by its location so builds of the same version don't share it
+func (pl *GoLiquibase) setArtifactDir(cacheDir string) error {
+	location := pl.LiquibaseArtifact
+	if !isURL(location) {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return fmt.Errorf("liquibase build %s not found", location)
+		}
+		location = abs
+	}
+	if pl.Version == "" {
+		pl.Version = artifactVersion(location)
+	}
+	sum := sha256.Sum256([]byte(location))
+	pl.setLiquibaseDir(filepath.Join(cacheDir, versioned(LIQUIBASE_DIR, pl.Version+"-"+hex.EncodeToString(sum[:4]))))
+	return nil
+}
+
+// Install the LiquibaseArtifact build unless the cached install is intact and
+// current: builds from a url are refreshed every SNAPSHOT_REFRESH_INTERVAL,
+// local builds when they change
+func (pl *GoLiquibase) installArtifact(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(pl.LiquibaseDir), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockInstall(pl.LiquibaseDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if isLiquibaseInstalled(pl.LiquibaseDir) && verifyInstall(pl.LiquibaseDir) == nil {
+		info, err := os.Stat(filepath.Join(pl.LiquibaseDir, INSTALL_MANIFEST_FILE))
+		if err != nil {
+			return err
+		}
+		current := time.Since(info.ModTime()) < SNAPSHOT_REFRESH_INTERVAL
+		if !isURL(pl.LiquibaseArtifact) {
+			changed, err := latestChange(pl.LiquibaseArtifact)
+			if err != nil {
+				return err
+			}
+			current = !changed.After(info.ModTime())
+		}
+		if current {
+			pl.logger().Printf("Liquibase build %s found, skipping install...", pl.Version)
+			return nil
+		}
+	}
+	pl.logger().Printf("Installing Liquibase build %s from %s", pl.Version, pl.LiquibaseArtifact)
+	return pl.installDistribution(ctx, pl.LiquibaseArtifact)
+}
+
+// The last time a file, or any file in a directory, changed
+func latestChange(location string) (time.Time, error) {
+	var latest time.Time
+	err := filepath.WalkDir(location, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// Install a Liquibase distribution into LiquibaseDir from a url or a local
+// zip or tar.gz, or copy an extracted one from a local directory. It is
+// extracted next to the destination and moved in place, so an interrupted
+// install never looks complete. The caller holds the install lock.
+func (pl *GoLiquibase) installDistribution(ctx context.Context, source string) error {
+	parent := filepath.Dir(pl.LiquibaseDir)
+	archive := source
+	if isURL(source) {
+		// A download of its own, so concurrent installs of other cache dirs don't clash
+		name := path.Base(strings.SplitN(source, "?", 2)[0])
+		download, err := os.CreateTemp(parent, ".download-*-"+name)
+		if err != nil {
+			return err
+		}
+		download.Close()
+		archive = download.Name()
+		defer os.Remove(archive)
+		if err := pl.downloadFile(ctx, source, archive); err != nil {
+			return err
+		}
+	}
+
+	extractDir, err := os.MkdirTemp(parent, ".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(extractDir)
+
+	pl.logger().Printf("Extracting Liquibase to %s", pl.LiquibaseDir)
+	lower := strings.ToLower(archive)
+	switch {
+	case dirExists(archive):
+		err = linkTree(archive, extractDir)
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		err = untarGzFile(archive, extractDir)
+	default:
+		err = unzipFile(archive, extractDir)
+	}
+	if err != nil {
+		return err
+	}
+	// Some builds wrap the distribution in a directory of its own
+	distribution := extractDir
+	if entries, err := os.ReadDir(extractDir); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		distribution = filepath.Join(extractDir, entries[0].Name())
+	}
+	if !isLiquibaseInstalled(distribution) {
+		return fmt.Errorf("liquibase launcher not found in %s", source)
+	}
+	if err := writeInstallManifest(distribution, pl.Version); err != nil {
+		return fmt.Errorf("failed to write the install manifest: %v", err)
+	}
+	os.RemoveAll(pl.LiquibaseDir)
+	return os.Rename(distribution, pl.LiquibaseDir)
+}
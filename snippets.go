@@ -0,0 +1,546 @@
+package goliquify
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Changelog formats snippets render to
+const (
+	FORMAT_XML  = "xml"
+	FORMAT_YAML = "yaml"
+	FORMAT_JSON = "json"
+	FORMAT_SQL  = "sql"
+)
+
+// Liquibase names of the databases snippets support
+const (
+	DBMS_POSTGRES = "postgresql"
+	DBMS_MYSQL    = "mysql"
+)
+
+const DEFAULT_SNIPPET_AUTHOR = "goliquify"
+
+var (
+	identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*)?$`)
+	columnTypePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 ]*(\(\d+(,\s*\d+)?\))?$`)
+)
+
+// SnippetParam is a parameter of a snippet
+type SnippetParam struct {
+	Name        string
+	Description string
+	// Default is the value when the parameter isn't given, a required
+	// parameter has none
+	Default  string
+	Required bool
+	// Type marks a parameter holding a column type rather than an identifier
+	Type bool
+}
+
+// Snippet is a reusable changeset of the snippet library
+type Snippet struct {
+	Name        string
+	Description string
+	Params      []SnippetParam
+	build       func(values map[string]string, dbms string) (snippetChangeSet, error)
+}
+
+// SnippetOptions holds the settings of a rendered snippet
+type SnippetOptions struct {
+	// Values of the snippet parameters
+	Values map[string]string
+	// Format is the changelog format, xml by default
+	Format string
+	// DBMS is the database the changeset is for, postgresql by default
+	DBMS string
+	// ID and Author of the changeset, the ID defaults to the snippet name
+	// and table
+	ID     string
+	Author string
+}
+
+// SNIPPETS is the snippet library
+var SNIPPETS = map[string]*Snippet{
+	"audit-columns": {
+		Name:        "audit-columns",
+		Description: "Add created_at, created_by, updated_at and updated_by columns to a table",
+		Params: []SnippetParam{
+			{Name: "table", Description: "Table to add the columns to, may be schema qualified", Required: true},
+			{Name: "userType", Description: "Type of the created_by and updated_by columns", Default: "varchar(255)", Type: true},
+		},
+		build: func(values map[string]string, dbms string) (snippetChangeSet, error) {
+			schema, table := splitTable(values["table"])
+			return snippetChangeSet{Changes: snippetChanges{&snippetAddColumn{
+				SchemaName: schema,
+				TableName:  table,
+				Columns: snippetColumns{
+					{Name: "created_at", Type: "timestamp", DefaultValueComputed: "CURRENT_TIMESTAMP", Constraints: &snippetConstraints{Nullable: boolPtr(false)}},
+					{Name: "created_by", Type: values["userType"]},
+					{Name: "updated_at", Type: "timestamp"},
+					{Name: "updated_by", Type: values["userType"]},
+				},
+			}}}, nil
+		},
+	},
+	"soft-delete": {
+		Name:        "soft-delete",
+		Description: "Add a nullable deleted_at column to a table and index it",
+		Params: []SnippetParam{
+			{Name: "table", Description: "Table to soft delete rows of, may be schema qualified", Required: true},
+			{Name: "column", Description: "Column holding the deletion time", Default: "deleted_at"},
+		},
+		build: func(values map[string]string, dbms string) (snippetChangeSet, error) {
+			schema, table := splitTable(values["table"])
+			column := values["column"]
+			return snippetChangeSet{Changes: snippetChanges{
+				&snippetAddColumn{SchemaName: schema, TableName: table, Columns: snippetColumns{{Name: column, Type: "timestamp"}}},
+				&snippetCreateIndex{IndexName: fmt.Sprintf("idx_%s_%s", table, column), SchemaName: schema, TableName: table, Columns: snippetColumns{{Name: column}}},
+			}}, nil
+		},
+	},
+	"updated-at-trigger": {
+		Name:        "updated-at-trigger",
+		Description: "Set a table's updated_at column on every update, with a trigger on Postgres and ON UPDATE on MySQL",
+		Params: []SnippetParam{
+			{Name: "table", Description: "Table to maintain the column of, may be schema qualified", Required: true},
+			{Name: "column", Description: "Timestamp column set on update", Default: "updated_at"},
+		},
+		build: func(values map[string]string, dbms string) (snippetChangeSet, error) {
+			table, column := values["table"], values["column"]
+			if dbms == DBMS_MYSQL {
+				return snippetChangeSet{
+					DBMS: DBMS_MYSQL,
+					Changes: snippetChanges{&snippetSQL{SQL: fmt.Sprintf(
+						"ALTER TABLE %s MODIFY COLUMN %s TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP", table, column)}},
+					Rollback: snippetChanges{&snippetSQL{SQL: fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s TIMESTAMP NULL", table, column)}},
+				}, nil
+			}
+			schema, name := splitTable(table)
+			function := fmt.Sprintf("%s_set_%s", name, column)
+			if schema != "" {
+				function = schema + "." + function
+			}
+			return snippetChangeSet{
+				DBMS: DBMS_POSTGRES,
+				Changes: snippetChanges{
+					&snippetSQL{SplitStatements: boolPtr(false), SQL: fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+BEGIN
+    NEW.%s = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`, function, column)},
+					&snippetSQL{SQL: fmt.Sprintf("CREATE TRIGGER %s_set_%s BEFORE UPDATE ON %s FOR EACH ROW EXECUTE FUNCTION %s()", name, column, table, function)},
+				},
+				Rollback: snippetChanges{
+					&snippetSQL{SQL: fmt.Sprintf("DROP TRIGGER IF EXISTS %s_set_%s ON %s", name, column, table)},
+					&snippetSQL{SQL: fmt.Sprintf("DROP FUNCTION IF EXISTS %s()", function)},
+				},
+			}, nil
+		},
+	},
+	"uuid-pk-table": {
+		Name:        "uuid-pk-table",
+		Description: "Create a table with a generated UUID primary key and a created_at column",
+		Params: []SnippetParam{
+			{Name: "table", Description: "Table to create, may be schema qualified", Required: true},
+			{Name: "pk", Description: "Primary key column", Default: "id"},
+		},
+		build: func(values map[string]string, dbms string) (snippetChangeSet, error) {
+			schema, table := splitTable(values["table"])
+			// gen_random_uuid is built into Postgres 13, expression defaults into MySQL 8.0.13
+			generate := "gen_random_uuid()"
+			if dbms == DBMS_MYSQL {
+				generate = "(UUID())"
+			}
+			return snippetChangeSet{Changes: snippetChanges{&snippetCreateTable{
+				SchemaName: schema,
+				TableName:  table,
+				Columns: snippetColumns{
+					{Name: values["pk"], Type: "uuid", DefaultValueComputed: generate, Constraints: &snippetConstraints{Nullable: boolPtr(false), PrimaryKey: true, PrimaryKeyName: "pk_" + table}},
+					{Name: "created_at", Type: "timestamp", DefaultValueComputed: "CURRENT_TIMESTAMP", Constraints: &snippetConstraints{Nullable: boolPtr(false)}},
+				},
+			}}}, nil
+		},
+	},
+}
+
+// SnippetNames returns the names of the snippets in the library, sorted
+func SnippetNames() []string {
+	names := make([]string, 0, len(SNIPPETS))
+	for name := range SNIPPETS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ChangelogFormat returns the format of a changelog file from its extension,
+// xml when it isn't a changelog
+func ChangelogFormat(changelogFile string) string {
+	switch strings.ToLower(filepath.Ext(changelogFile)) {
+	case ".yaml", ".yml":
+		return FORMAT_YAML
+	case ".json":
+		return FORMAT_JSON
+	case ".sql":
+		return FORMAT_SQL
+	}
+	return FORMAT_XML
+}
+
+// DialectDBMS returns the Liquibase database name of a database/sql dialect
+func DialectDBMS(dialect string) string {
+	if dialect == DIALECT_MYSQL {
+		return DBMS_MYSQL
+	}
+	return DBMS_POSTGRES
+}
+
+// RenderSnippet renders a snippet of the library as a changelog holding its
+// changeset. Parameters are checked to be identifiers, so values can't
+// inject SQL.
+func RenderSnippet(name string, opts SnippetOptions) ([]byte, error) {
+	snippet, ok := SNIPPETS[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown snippet %s, expecting one of %s", name, strings.Join(SnippetNames(), ", "))
+	}
+	dbms := firstNonEmpty(opts.DBMS, DBMS_POSTGRES)
+	if dbms != DBMS_POSTGRES && dbms != DBMS_MYSQL {
+		return nil, fmt.Errorf("unknown dbms %s, expecting %s or %s", dbms, DBMS_POSTGRES, DBMS_MYSQL)
+	}
+
+	values := map[string]string{}
+	for key := range opts.Values {
+		if !snippet.hasParam(key) {
+			return nil, fmt.Errorf("snippet %s has no parameter %s", name, key)
+		}
+	}
+	for _, param := range snippet.Params {
+		value := firstNonEmpty(opts.Values[param.Name], param.Default)
+		if value == "" {
+			return nil, fmt.Errorf("snippet %s needs the %s parameter: %s", name, param.Name, param.Description)
+		}
+		if param.Type && !columnTypePattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid %s %q, expecting a column type", param.Name, value)
+		}
+		if !param.Type && !identifierPattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid %s %q, expecting an identifier", param.Name, value)
+		}
+		values[param.Name] = value
+	}
+
+	changeSet, err := snippet.build(values, dbms)
+	if err != nil {
+		return nil, err
+	}
+	changeSet.ID = firstNonEmpty(opts.ID, name+"-"+strings.ReplaceAll(values["table"], ".", "-"))
+	changeSet.Author = firstNonEmpty(opts.Author, DEFAULT_SNIPPET_AUTHOR)
+
+	switch firstNonEmpty(opts.Format, FORMAT_XML) {
+	case FORMAT_XML:
+		if len(changeSet.Rollback) > 0 {
+			changeSet.XMLRollback = &snippetXMLRollback{Changes: changeSet.Rollback}
+		}
+		data, err := xml.MarshalIndent(snippetXMLChangelog{
+			Xmlns:      "http://www.liquibase.org/xml/ns/dbchangelog",
+			XmlnsXsi:   "http://www.w3.org/2001/XMLSchema-instance",
+			SchemaLoc:  "http://www.liquibase.org/xml/ns/dbchangelog http://www.liquibase.org/xml/ns/dbchangelog/dbchangelog-latest.xsd",
+			ChangeSets: []snippetChangeSet{changeSet},
+		}, "", "    ")
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte(xml.Header), data...), '\n'), nil
+	case FORMAT_YAML:
+		var out bytes.Buffer
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(snippetChangelog{Entries: []snippetEntry{{changeSet}}}); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case FORMAT_JSON:
+		data, err := json.MarshalIndent(snippetChangelog{Entries: []snippetEntry{{changeSet}}}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FORMAT_SQL:
+		return changeSet.formattedSQL(dbms), nil
+	}
+	return nil, fmt.Errorf("unknown format %s, expecting xml, yaml, json or sql", opts.Format)
+}
+
+func (s *Snippet) hasParam(name string) bool {
+	for _, param := range s.Params {
+		if param.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a table name into its schema and name
+func splitTable(table string) (string, string) {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return schema, name
+	}
+	return "", table
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// Changesets are rendered by the encoders of every format, the structs carry
+// the XML, YAML and JSON names of Liquibase
+
+type snippetXMLChangelog struct {
+	XMLName    xml.Name           `xml:"databaseChangeLog"`
+	Xmlns      string             `xml:"xmlns,attr"`
+	XmlnsXsi   string             `xml:"xmlns:xsi,attr"`
+	SchemaLoc  string             `xml:"xsi:schemaLocation,attr"`
+	ChangeSets []snippetChangeSet `xml:"changeSet"`
+}
+
+type snippetChangelog struct {
+	Entries []snippetEntry `yaml:"databaseChangeLog" json:"databaseChangeLog"`
+}
+
+type snippetEntry struct {
+	ChangeSet snippetChangeSet `yaml:"changeSet" json:"changeSet"`
+}
+
+type snippetChangeSet struct {
+	ID       string         `xml:"id,attr" yaml:"id" json:"id"`
+	Author   string         `xml:"author,attr" yaml:"author" json:"author"`
+	DBMS     string         `xml:"dbms,attr,omitempty" yaml:"dbms,omitempty" json:"dbms,omitempty"`
+	Changes  snippetChanges `xml:"change" yaml:"changes" json:"changes"`
+	Rollback snippetChanges `xml:"-" yaml:"rollback,omitempty" json:"rollback,omitempty"`
+	// XML wraps the rollback changes in an element
+	XMLRollback *snippetXMLRollback `xml:"rollback,omitempty" yaml:"-" json:"-"`
+}
+
+type snippetXMLRollback struct {
+	Changes snippetChanges `xml:"change"`
+}
+
+type snippetChange interface {
+	kind() string
+	// Statements applying and reverting the change in formatted SQL
+	statements(dbms string) []string
+	rollback(dbms string) []string
+}
+
+// snippetChanges are elements in XML and single key maps in YAML and JSON
+type snippetChanges []snippetChange
+
+func (c snippetChanges) wrapped() []map[string]snippetChange {
+	var changes []map[string]snippetChange
+	for _, change := range c {
+		changes = append(changes, map[string]snippetChange{change.kind(): change})
+	}
+	return changes
+}
+
+func (c snippetChanges) MarshalYAML() (any, error) {
+	return c.wrapped(), nil
+}
+
+func (c snippetChanges) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.wrapped())
+}
+
+type snippetColumn struct {
+	Name                 string              `xml:"name,attr" yaml:"name" json:"name"`
+	Type                 string              `xml:"type,attr,omitempty" yaml:"type,omitempty" json:"type,omitempty"`
+	DefaultValueComputed string              `xml:"defaultValueComputed,attr,omitempty" yaml:"defaultValueComputed,omitempty" json:"defaultValueComputed,omitempty"`
+	Constraints          *snippetConstraints `xml:"constraints,omitempty" yaml:"constraints,omitempty" json:"constraints,omitempty"`
+}
+
+type snippetConstraints struct {
+	Nullable       *bool  `xml:"nullable,attr,omitempty" yaml:"nullable,omitempty" json:"nullable,omitempty"`
+	PrimaryKey     bool   `xml:"primaryKey,attr,omitempty" yaml:"primaryKey,omitempty" json:"primaryKey,omitempty"`
+	PrimaryKeyName string `xml:"primaryKeyName,attr,omitempty" yaml:"primaryKeyName,omitempty" json:"primaryKeyName,omitempty"`
+}
+
+// snippetColumns are column elements in XML and column maps in YAML and JSON
+type snippetColumns []snippetColumn
+
+func (c snippetColumns) wrapped() []map[string]snippetColumn {
+	var columns []map[string]snippetColumn
+	for _, column := range c {
+		columns = append(columns, map[string]snippetColumn{"column": column})
+	}
+	return columns
+}
+
+func (c snippetColumns) MarshalYAML() (any, error) {
+	return c.wrapped(), nil
+}
+
+func (c snippetColumns) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.wrapped())
+}
+
+type snippetAddColumn struct {
+	XMLName    xml.Name       `xml:"addColumn" yaml:"-" json:"-"`
+	SchemaName string         `xml:"schemaName,attr,omitempty" yaml:"schemaName,omitempty" json:"schemaName,omitempty"`
+	TableName  string         `xml:"tableName,attr" yaml:"tableName" json:"tableName"`
+	Columns    snippetColumns `xml:"column" yaml:"columns" json:"columns"`
+}
+
+func (c *snippetAddColumn) kind() string { return "addColumn" }
+
+func (c *snippetAddColumn) statements(dbms string) []string {
+	var adds []string
+	for _, column := range c.Columns {
+		adds = append(adds, "ADD COLUMN "+column.definition(dbms))
+	}
+	return []string{fmt.Sprintf("ALTER TABLE %s %s", qualifiedTable(c.SchemaName, c.TableName), strings.Join(adds, ", "))}
+}
+
+func (c *snippetAddColumn) rollback(dbms string) []string {
+	var drops []string
+	for _, column := range c.Columns {
+		drops = append(drops, "DROP COLUMN "+column.Name)
+	}
+	return []string{fmt.Sprintf("ALTER TABLE %s %s", qualifiedTable(c.SchemaName, c.TableName), strings.Join(drops, ", "))}
+}
+
+type snippetCreateTable struct {
+	XMLName    xml.Name       `xml:"createTable" yaml:"-" json:"-"`
+	SchemaName string         `xml:"schemaName,attr,omitempty" yaml:"schemaName,omitempty" json:"schemaName,omitempty"`
+	TableName  string         `xml:"tableName,attr" yaml:"tableName" json:"tableName"`
+	Columns    snippetColumns `xml:"column" yaml:"columns" json:"columns"`
+}
+
+func (c *snippetCreateTable) kind() string { return "createTable" }
+
+func (c *snippetCreateTable) statements(dbms string) []string {
+	var definitions []string
+	for _, column := range c.Columns {
+		definitions = append(definitions, "    "+column.definition(dbms))
+	}
+	for _, column := range c.Columns {
+		if column.Constraints != nil && column.Constraints.PrimaryKey {
+			definitions = append(definitions, fmt.Sprintf("    CONSTRAINT %s PRIMARY KEY (%s)", column.Constraints.PrimaryKeyName, column.Name))
+		}
+	}
+	return []string{fmt.Sprintf("CREATE TABLE %s (\n%s\n)", qualifiedTable(c.SchemaName, c.TableName), strings.Join(definitions, ",\n"))}
+}
+
+func (c *snippetCreateTable) rollback(dbms string) []string {
+	return []string{"DROP TABLE " + qualifiedTable(c.SchemaName, c.TableName)}
+}
+
+type snippetCreateIndex struct {
+	XMLName    xml.Name       `xml:"createIndex" yaml:"-" json:"-"`
+	IndexName  string         `xml:"indexName,attr" yaml:"indexName" json:"indexName"`
+	SchemaName string         `xml:"schemaName,attr,omitempty" yaml:"schemaName,omitempty" json:"schemaName,omitempty"`
+	TableName  string         `xml:"tableName,attr" yaml:"tableName" json:"tableName"`
+	Columns    snippetColumns `xml:"column" yaml:"columns" json:"columns"`
+}
+
+func (c *snippetCreateIndex) kind() string { return "createIndex" }
+
+func (c *snippetCreateIndex) statements(dbms string) []string {
+	var columns []string
+	for _, column := range c.Columns {
+		columns = append(columns, column.Name)
+	}
+	return []string{fmt.Sprintf("CREATE INDEX %s ON %s (%s)", c.IndexName, qualifiedTable(c.SchemaName, c.TableName), strings.Join(columns, ", "))}
+}
+
+func (c *snippetCreateIndex) rollback(dbms string) []string {
+	if dbms == DBMS_MYSQL {
+		return []string{fmt.Sprintf("DROP INDEX %s ON %s", c.IndexName, qualifiedTable(c.SchemaName, c.TableName))}
+	}
+	return []string{"DROP INDEX " + qualifiedTable(c.SchemaName, c.IndexName)}
+}
+
+type snippetSQL struct {
+	XMLName         xml.Name `xml:"sql" yaml:"-" json:"-"`
+	SplitStatements *bool    `xml:"splitStatements,attr,omitempty" yaml:"splitStatements,omitempty" json:"splitStatements,omitempty"`
+	SQL             string   `xml:",cdata" yaml:"sql" json:"sql"`
+}
+
+func (c *snippetSQL) kind() string { return "sql" }
+
+func (c *snippetSQL) statements(dbms string) []string { return []string{c.SQL} }
+
+func (c *snippetSQL) rollback(dbms string) []string { return nil }
+
+func qualifiedTable(schema, table string) string {
+	if schema != "" {
+		return schema + "." + table
+	}
+	return table
+}
+
+// Column definition of a DDL statement, in the types of a database
+func (c snippetColumn) definition(dbms string) string {
+	typ := strings.ToUpper(c.Type)
+	if typ == "UUID" && dbms == DBMS_MYSQL {
+		typ = "CHAR(36)"
+	}
+	definition := c.Name + " " + typ
+	if c.DefaultValueComputed != "" {
+		definition += " DEFAULT " + c.DefaultValueComputed
+	}
+	if c.Constraints != nil && c.Constraints.Nullable != nil && !*c.Constraints.Nullable {
+		definition += " NOT NULL"
+	}
+	return definition
+}
+
+// Render a changeset as a formatted SQL changelog. Rollbacks are the reverse
+// of the changes unless the changeset has its own.
+func (cs snippetChangeSet) formattedSQL(dbms string) []byte {
+	var out bytes.Buffer
+	out.WriteString("--liquibase formatted sql\n\n")
+	fmt.Fprintf(&out, "--changeset %s:%s", cs.Author, cs.ID)
+	if cs.DBMS != "" {
+		fmt.Fprintf(&out, " dbms:%s", cs.DBMS)
+	}
+	delimiter := ";"
+	for _, change := range cs.Changes {
+		if sql, ok := change.(*snippetSQL); ok && sql.SplitStatements != nil && !*sql.SplitStatements {
+			// Function bodies hold semicolons, statements end with a line
+			// holding only a slash
+			out.WriteString(" endDelimiter:/")
+			delimiter = "\n/"
+			break
+		}
+	}
+	out.WriteString("\n")
+	for _, change := range cs.Changes {
+		for _, statement := range change.statements(dbms) {
+			out.WriteString(statement + delimiter + "\n")
+		}
+	}
+
+	var rollback []string
+	if len(cs.Rollback) > 0 {
+		for _, change := range cs.Rollback {
+			rollback = append(rollback, change.statements(dbms)...)
+		}
+	} else {
+		for i := len(cs.Changes) - 1; i >= 0; i-- {
+			rollback = append(rollback, cs.Changes[i].rollback(dbms)...)
+		}
+	}
+	for _, statement := range rollback {
+		fmt.Fprintf(&out, "--rollback %s;\n", strings.ReplaceAll(statement, "\n", " "))
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,78 @@
+package goliquify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Liquibase offline database of the schema SQL when none is given
+const DEFAULT_OFFLINE_DATABASE = "postgresql"
+
+// ConsolidatedSchema renders the DDL of the whole changelog with update-sql
+// against an offline connection, which has no changesets applied, so no
+// database is needed. The Liquibase tracking statements are left out, the
+// result is a schema sqlc can read. database is the Liquibase short name of
+// the database, e.g. postgresql or mysql.
+func (pl *GoLiquibase) ConsolidatedSchema(ctx context.Context, database string) (string, error) {
+	if database == "" {
+		database = DEFAULT_OFFLINE_DATABASE
+	}
+	dir, err := os.MkdirTemp("", "goliquify-schema-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	// The offline state of the changelog starts empty, so every changeset is rendered
+	state := filepath.Join(dir, "databasechangelog.csv")
+	output := filepath.Join(dir, "schema.sql")
+	url := fmt.Sprintf("offline:%s?outputLiquibaseSql=none&changeLogFile=%s", database, state)
+
+	offline := pl.withDefaultsFile(pl.DefaultsFile)
+	offline.DryRun, offline.ChangeTickets, offline.SafeRewrite = false, nil, nil
+	if err := offline.ExecuteWithOptions(ctx, ExecOptions{Args: []string{"--url=" + url}}, "update-sql", "--output-file="+output); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return "", err
+	}
+	return cleanSchemaSQL(string(data)), nil
+}
+
+// Keep the DDL of the changesets out of update-sql output: the header is
+// dropped, and so are the statements on the Liquibase tracking tables
+func cleanSchemaSQL(sql string) string {
+	var out strings.Builder
+	out.WriteString("-- Code generated by goliquify sqlc. DO NOT EDIT.\n")
+	var statement []string
+	inHeader := true
+	scanner := bufio.NewScanner(strings.NewReader(sql))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if inHeader && (trimmed == "" || strings.HasPrefix(trimmed, "--")) && !strings.HasPrefix(trimmed, "-- Changeset ") {
+			continue
+		}
+		inHeader = false
+		if len(statement) == 0 && trimmed == "" {
+			continue
+		}
+		statement = append(statement, line)
+		if strings.HasSuffix(trimmed, ";") {
+			text := strings.Join(statement, "\n")
+			if !strings.Contains(strings.ToLower(text), "databasechangelog") {
+				out.WriteString("\n" + text + "\n")
+			}
+			statement = nil
+		}
+	}
+	if len(statement) > 0 {
+		out.WriteString(strings.Join(statement, "\n") + "\n")
+	}
+	return out.String()
+}
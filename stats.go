@@ -0,0 +1,249 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Largest raw SQL changesets listed by default
+const DEFAULT_STATS_TOP = 10
+
+// Month of the changesets whose file isn't committed to git
+const UNCOMMITTED_MONTH = "uncommitted"
+
+// ChangelogStats describes the size and hygiene of a changelog
+type ChangelogStats struct {
+	Files      int `json:"files"`
+	ChangeSets int `json:"changesets"`
+	// Changesets by author, change type and context. Changesets without a
+	// context are counted under (none).
+	ByAuthor     map[string]int `json:"byAuthor"`
+	ByChangeType map[string]int `json:"byChangeType"`
+	ByContext    map[string]int `json:"byContext"`
+	// RollbackCoverage is the percentage of changesets that can be rolled
+	// back, with an explicit rollback or with changes Liquibase rolls back
+	// automatically
+	RollbackCoverage float64 `json:"rollbackCoverage"`
+	// WithoutRollback lists the changesets that can't be rolled back
+	WithoutRollback []string `json:"withoutRollback,omitempty"`
+	// LargestSQL are the largest raw SQL changesets, largest first
+	LargestSQL []SQLChangeSetSize `json:"largestSql,omitempty"`
+	// Growth is the number of changesets added per month, from the git
+	// history of the changelog files
+	Growth []GrowthMonth `json:"growth,omitempty"`
+}
+
+// SQLChangeSetSize is the size of a raw SQL changeset
+type SQLChangeSetSize struct {
+	ChangeSet string `json:"changeset"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Lines     int    `json:"lines"`
+	Bytes     int    `json:"bytes"`
+}
+
+// GrowthMonth is the number of changesets added in a month
+type GrowthMonth struct {
+	Month string `json:"month"`
+	Added int    `json:"added"`
+	Total int    `json:"total"`
+}
+
+// StatsOptions tune the changelog statistics
+type StatsOptions struct {
+	// Top is the number of largest raw SQL changesets, DEFAULT_STATS_TOP when zero
+	Top int
+	// Git reads the growth of the changelog from git blame
+	Git bool
+}
+
+// ComputeStats counts the changesets of a changelog tree by author, change
+// type and context, measures rollback coverage and the largest raw SQL
+// changesets, and, with opts.Git, the growth of the changelog per month
+func ComputeStats(ctx context.Context, tree *ChangelogTree, opts StatsOptions) (*ChangelogStats, error) {
+	stats := &ChangelogStats{
+		Files:        len(tree.Files),
+		ChangeSets:   len(tree.ChangeSets),
+		ByAuthor:     map[string]int{},
+		ByChangeType: map[string]int{},
+		ByContext:    map[string]int{},
+	}
+	covered := 0
+	var sqlSizes []SQLChangeSetSize
+	for _, cs := range tree.ChangeSets {
+		stats.ByAuthor[cs.Author]++
+		stats.ByContext[firstNonEmpty(cs.Context, "(none)")]++
+		rollback, rawSQL := true, false
+		for _, change := range cs.Changes {
+			stats.ByChangeType[change]++
+			if containsFold(NO_AUTO_ROLLBACK_CHANGES, change) {
+				rollback = false
+			}
+			if change == "sql" || change == "sqlFile" {
+				rawSQL = true
+			}
+		}
+		if cs.Rollback || rollback {
+			covered++
+		} else {
+			stats.WithoutRollback = append(stats.WithoutRollback, changesetLabel(cs.Key()))
+		}
+		if rawSQL {
+			sqlSizes = append(sqlSizes, SQLChangeSetSize{
+				ChangeSet: changesetLabel(cs.Key()),
+				File:      cs.File,
+				Line:      cs.Line,
+				Lines:     max(cs.EndLine-cs.Line+1, 1),
+				Bytes:     len(cs.Body),
+			})
+		}
+	}
+	if stats.ChangeSets > 0 {
+		stats.RollbackCoverage = float64(covered) * 100 / float64(stats.ChangeSets)
+	}
+
+	sort.SliceStable(sqlSizes, func(i, j int) bool { return sqlSizes[i].Bytes > sqlSizes[j].Bytes })
+	top := opts.Top
+	if top <= 0 {
+		top = DEFAULT_STATS_TOP
+	}
+	stats.LargestSQL = sqlSizes[:min(top, len(sqlSizes))]
+
+	if opts.Git {
+		growth, err := changelogGrowth(ctx, tree)
+		if err != nil {
+			return nil, err
+		}
+		stats.Growth = growth
+	}
+	return stats, nil
+}
+
+// Count the changesets added per month. A changeset dates from the commit
+// that last touched the first line of its definition, as git blame tells.
+func changelogGrowth(ctx context.Context, tree *ChangelogTree) ([]GrowthMonth, error) {
+	added := map[string]int{}
+	for _, file := range tree.Files {
+		if len(file.ChangeSets) == 0 {
+			continue
+		}
+		times, err := blameTimes(ctx, file.DiskPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, cs := range file.ChangeSets {
+			month := UNCOMMITTED_MONTH
+			if t, ok := times[cs.Line]; ok {
+				month = t.Format("2006-01")
+			}
+			added[month]++
+		}
+	}
+	months := make([]string, 0, len(added))
+	for month := range added {
+		months = append(months, month)
+	}
+	// Uncommitted changesets sort last, after every month
+	sort.Strings(months)
+	var growth []GrowthMonth
+	total := 0
+	for _, month := range months {
+		total += added[month]
+		growth = append(growth, GrowthMonth{Month: month, Added: added[month], Total: total})
+	}
+	return growth, nil
+}
+
+// The author time of every line of a file, by line number. Lines of files
+// outside a git repository, or not committed yet, have none.
+func blameTimes(ctx context.Context, path string) (map[int]time.Time, error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	times := map[int]time.Time{}
+	if _, err := runGit(ctx, dir, "rev-parse", "--git-dir"); err != nil {
+		return times, nil
+	}
+	if _, err := runGit(ctx, dir, "ls-files", "--error-unmatch", name); err != nil {
+		return times, nil
+	}
+	out, err := runGit(ctx, dir, "blame", "--line-porcelain", name)
+	if err != nil {
+		return nil, err
+	}
+	// Every line starts with a header of its commit: <sha> <original line>
+	// <final line> [<lines>], then the commit fields and the line itself
+	// prefixed by a tab
+	line := 0
+	var uncommitted bool
+	for _, text := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(text, "\t"):
+			line = 0
+		case line == 0:
+			fields := strings.Fields(text)
+			if len(fields) >= 3 {
+				line, _ = strconv.Atoi(fields[2])
+				uncommitted = strings.Trim(fields[0], "0") == ""
+			}
+		case strings.HasPrefix(text, "author-time ") && !uncommitted:
+			if seconds, err := strconv.ParseInt(strings.TrimPrefix(text, "author-time "), 10, 64); err == nil {
+				times[line] = time.Unix(seconds, 0).UTC()
+			}
+		}
+	}
+	return times, nil
+}
+
+// Print the statistics as text tables
+func PrintStats(w io.Writer, stats *ChangelogStats) {
+	fmt.Fprintf(w, "%d changesets in %d files, rollback coverage %.1f%%\n", stats.ChangeSets, stats.Files, stats.RollbackCoverage)
+	printCounts := func(title string, counts map[string]int) {
+		keys := make([]string, 0, len(counts))
+		for key := range counts {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if counts[keys[i]] != counts[keys[j]] {
+				return counts[keys[i]] > counts[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		fmt.Fprintf(w, "\n%s\n", title)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, key := range keys {
+			fmt.Fprintf(tw, "  %s\t%d\n", key, counts[key])
+		}
+		tw.Flush()
+	}
+	printCounts("BY AUTHOR", stats.ByAuthor)
+	printCounts("BY CHANGE TYPE", stats.ByChangeType)
+	printCounts("BY CONTEXT", stats.ByContext)
+
+	if len(stats.LargestSQL) > 0 {
+		fmt.Fprintf(w, "\nLARGEST RAW SQL CHANGESETS\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  CHANGESET\tLOCATION\tLINES\tBYTES")
+		for _, s := range stats.LargestSQL {
+			fmt.Fprintf(tw, "  %s\t%s:%d\t%d\t%d\n", s.ChangeSet, s.File, s.Line, s.Lines, s.Bytes)
+		}
+		tw.Flush()
+	}
+	if len(stats.Growth) > 0 {
+		fmt.Fprintf(w, "\nGROWTH\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  MONTH\tADDED\tTOTAL")
+		for _, g := range stats.Growth {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\n", g.Month, g.Added, g.Total)
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,180 @@
+package goliquify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefixes of the driver jar names of JDBC subprotocols
+var JDBC_DRIVER_JARS = map[string][]string{
+	"postgresql":  {"postgresql-"},
+	"mysql":       {"mysql-connector"},
+	"mariadb":     {"mariadb-java-client"},
+	"sqlserver":   {"mssql-jdbc"},
+	"oracle":      {"ojdbc"},
+	"db2":         {"jcc", "db2jcc"},
+	"snowflake":   {"snowflake-jdbc"},
+	"redshift":    {"redshift-jdbc"},
+	"h2":          {"h2-"},
+	"hsqldb":      {"hsqldb"},
+	"sqlite":      {"sqlite-jdbc"},
+	"firebirdsql": {"jaybird"},
+	"clickhouse":  {"clickhouse-jdbc"},
+}
+
+// StrictError lists every problem strict mode found
+type StrictError struct {
+	Problems []string
+}
+
+func (e *StrictError) Error() string {
+	return fmt.Sprintf("strict mode found %d problem(s):\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// CheckConfigKeys reads a config file and fails on keys GoLiquify doesn't
+// know, which LoadConfig ignores. A missing file is fine.
+func CheckConfigKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&Config{}); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Check a run for misconfiguration Liquibase only fails on later, with a
+// stack trace: changelog includes that can't be found, no driver for the
+// database url, and deprecated flags and commands
+func (pl *GoLiquibase) strictCheck(liquibaseDir, classpath string, arguments []string) error {
+	var problems []string
+	args := append(append([]string{}, pl.Args...), arguments...)
+
+	// Templated and encrypted changelogs are only found once rendered
+	if pl.TemplateDir == "" && len(pl.Secrets.Changelogs) == 0 {
+		if changelog, searchPath := pl.ChangelogLocation(arguments...); changelog != "" {
+			tree, err := LoadChangelogTree(changelog, searchPath)
+			if err != nil {
+				problems = append(problems, err.Error())
+			} else {
+				for _, d := range tree.Diagnostics() {
+					if d.Kind == DIAGNOSTIC_MISSING_INCLUDE {
+						problems = append(problems, d.String())
+					}
+				}
+			}
+		}
+	}
+
+	if problem := pl.checkDriver(liquibaseDir, classpath, args); problem != "" {
+		problems = append(problems, problem)
+	}
+
+	if mode := strings.ToLower(pl.LiquibaseHubMode); mode != "" && mode != "off" {
+		problems = append(problems, fmt.Sprintf("hub mode %s is deprecated, Liquibase Hub was sunset, use operation reports", pl.LiquibaseHubMode))
+	}
+	commandSeen := false
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			if !commandSeen {
+				commandSeen = true
+				if renamed, ok := COMMAND_RENAMES[arg]; ok {
+					problems = append(problems, fmt.Sprintf("command %s is deprecated, use %s", arg, renamed))
+				}
+				for _, removed := range REMOVED_ARGUMENTS {
+					if removed.Command && removed.Name == arg {
+						problems = append(problems, fmt.Sprintf("command %s was removed in Liquibase %s", arg, removed.Since))
+					}
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		flag, _, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if renamed, ok := FLAG_RENAMES[flag]; ok && renamed != flag {
+			problems = append(problems, fmt.Sprintf("flag --%s is deprecated, use --%s", flag, renamed))
+		}
+		for _, removed := range REMOVED_ARGUMENTS {
+			if !removed.Command && removed.Name == flag {
+				problems = append(problems, fmt.Sprintf("flag --%s was removed in Liquibase %s", flag, removed.Since))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &StrictError{Problems: problems}
+	}
+	return nil
+}
+
+// Check that a driver jar for the subprotocol of the database url is on the
+// classpath or in the Liquibase install, returning the problem if not
+func (pl *GoLiquibase) checkDriver(liquibaseDir, classpath string, args []string) string {
+	props, _ := ReadDefaultsFile(pl.DefaultsFile)
+	url := props["url"]
+	for _, arg := range args {
+		if v, ok := strings.CutPrefix(arg, "--url="); ok {
+			url = v
+		}
+	}
+	rest, ok := strings.CutPrefix(url, "jdbc:")
+	if !ok {
+		return ""
+	}
+	subprotocol, _, _ := strings.Cut(rest, ":")
+	prefixes, known := JDBC_DRIVER_JARS[subprotocol]
+	if !known {
+		return ""
+	}
+
+	jars := splitClasspath(classpath)
+	for _, entry := range splitClasspath(props["classpath"]) {
+		expanded, _ := expandClasspathEntry(entry)
+		jars = append(jars, expanded...)
+	}
+	for _, dir := range []string{"lib", "internal/lib"} {
+		found, _ := findJars(filepath.Join(liquibaseDir, dir), true)
+		jars = append(jars, found...)
+	}
+	for _, jar := range jars {
+		name := strings.ToLower(filepath.Base(jar))
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return ""
+			}
+		}
+	}
+
+	hint := "add its jar to the JDBC drivers dir or the classpath"
+	var bundles []string
+	for name, bundle := range DRIVER_BUNDLES {
+		for _, artifact := range bundle.Artifacts {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(artifact.Artifact, prefix) {
+					bundles = append(bundles, name)
+				}
+			}
+		}
+	}
+	if len(bundles) > 0 {
+		sort.Strings(bundles)
+		hint = fmt.Sprintf("install it with goliquify drivers install %s, or %s", strings.Join(bundles, " "), hint)
+	}
+	return fmt.Sprintf("no JDBC driver for %s urls (%s*.jar) was found, %s", subprotocol, strings.Join(prefixes, "*.jar, "), hint)
+}
@@ -0,0 +1,123 @@
+package goliquify
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// File extensions rendered through text/template, everything else is copied as-is
+var TEMPLATE_EXTENSIONS = []string{".xml", ".yaml", ".yml", ".json", ".sql"}
+
+// TemplateData is the data available to changelog templates
+type TemplateData struct {
+	Env        map[string]string
+	Values     map[string]any
+	Properties map[string]string
+}
+
+// Functions available to changelog templates, modelled after the sprig library
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"env":       os.Getenv,
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"trim":      strings.TrimSpace,
+		"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":     func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":      func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"quote":     func(s string) string { return fmt.Sprintf("%q", s) },
+		"squote":    func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" },
+		"now":       time.Now,
+		"date":      func(layout string, t time.Time) string { return t.Format(layout) },
+		"default": func(def any, val any) any {
+			if val == nil || val == "" {
+				return def
+			}
+			return val
+		},
+		"ternary": func(yes, no any, cond bool) any {
+			if cond {
+				return yes
+			}
+			return no
+		},
+		"required": func(msg string, val any) (any, error) {
+			if val == nil || val == "" {
+				return nil, fmt.Errorf("%s", msg)
+			}
+			return val, nil
+		},
+	}
+}
+
+// Build the template data from the process environment and the given values
+func newTemplateData(values map[string]any, properties map[string]string) TemplateData {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if key, val, ok := strings.Cut(kv, "="); ok {
+			env[key] = val
+		}
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	return TemplateData{Env: env, Values: values, Properties: properties}
+}
+
+// Render every changelog file under srcDir into destDir
+func renderChangelogDir(srcDir, destDir string, data TemplateData) error {
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !isTemplateFile(path) {
+			return os.WriteFile(target, content, 0644)
+		}
+
+		tmpl, err := template.New(rel).Funcs(templateFuncs()).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("failed to parse changelog template %s: %v", path, err)
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := tmpl.Execute(file, data); err != nil {
+			return fmt.Errorf("failed to render changelog template %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// Check if a file should be rendered as a template
+func isTemplateFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range TEMPLATE_EXTENSIONS {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+--liquibase formatted sql
+
+--changeset bob:1
+CREATE TABLE users (id INT);  
+
+--changeset bob:2
+ALTER TABLE users ADD email TEXT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+--liquibase formatted sql
+
+--changeset bob:1
+CREATE TABLE users (id INT);
+
+--changeset bob:add-email labels:billing
+ALTER TABLE users ADD email TEXT;
+
+--changeset "ana smith":9 context:prod
+CREATE INDEX users_email ON users (email);
@@ -0,0 +1,90 @@
+package goliquify
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Liquibase logs "ChangeSet <file>::<id>::<author> ran successfully in <n>ms" at info level
+var changesetRanPattern = regexp.MustCompile(`ChangeSet (.+?)::(.+?)::(.+?) ran successfully in (\d+)\s*ms`)
+
+// ChangesetTiming is the execution time of one changeset
+type ChangesetTiming struct {
+	File       string        `json:"file"`
+	ID         string        `json:"id"`
+	Author     string        `json:"author"`
+	Duration   time.Duration `json:"-"`
+	DurationMs int64         `json:"durationMs"`
+	Slow       bool          `json:"slow"`
+}
+
+// TimingReport lists the changeset execution times of one command
+type TimingReport struct {
+	Command     string            `json:"command"`
+	ThresholdMs int64             `json:"thresholdMs"`
+	TotalMs     int64             `json:"totalMs"`
+	SlowCount   int               `json:"slowCount"`
+	Changesets  []ChangesetTiming `json:"changesets"`
+}
+
+// timingCollector picks changeset timings out of the Liquibase output
+type timingCollector struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	timings   []ChangesetTiming
+}
+
+func (c *timingCollector) observe(line string) {
+	m := changesetRanPattern.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	ms, _ := strconv.ParseInt(m[4], 10, 64)
+	timing := ChangesetTiming{
+		File:       m[1],
+		ID:         m[2],
+		Author:     m[3],
+		Duration:   time.Duration(ms) * time.Millisecond,
+		DurationMs: ms,
+	}
+	timing.Slow = c.threshold > 0 && timing.Duration >= c.threshold
+
+	c.mu.Lock()
+	c.timings = append(c.timings, timing)
+	c.mu.Unlock()
+}
+
+// Build the report, warning about every changeset above the threshold
+func (c *timingCollector) report(arguments []string, logger *log.Logger) TimingReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := TimingReport{
+		Command:     strings.Join(arguments, " "),
+		ThresholdMs: c.threshold.Milliseconds(),
+		Changesets:  append([]ChangesetTiming{}, c.timings...),
+	}
+	for _, t := range c.timings {
+		report.TotalMs += t.DurationMs
+		if t.Slow {
+			report.SlowCount++
+			logger.Printf("Slow changeset %s::%s::%s took %s (threshold %s)", t.File, t.ID, t.Author, t.Duration, c.threshold)
+		}
+	}
+	return report
+}
+
+// Write a timing report as JSON
+func WriteTimingReport(path string, report TimingReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
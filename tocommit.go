@@ -0,0 +1,104 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChangelogTreeAt loads a changelog tree as it was at a git revision, from a
+// temporary worktree checked out at the revision. The search path is mapped
+// into the worktree, so the changesets keep the paths Liquibase records.
+func ChangelogTreeAt(ctx context.Context, revision, changelogFile string, searchPath []string) (*ChangelogTree, error) {
+	root, err := runGit(ctx, "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	commit, err := runGit(ctx, root, "rev-parse", "--verify", revision+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision %s: %v", revision, err)
+	}
+	worktree, err := os.MkdirTemp("", "goliquify-commit-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(worktree)
+	if _, err := runGit(ctx, root, "worktree", "add", "--detach", worktree, commit); err != nil {
+		return nil, err
+	}
+	defer runGit(context.WithoutCancel(ctx), root, "worktree", "remove", "--force", worktree)
+
+	// Paths in the repository are looked up in the worktree instead
+	mapPath := func(p string) (string, error) {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = resolved
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside the git repository %s", p, root)
+		}
+		return filepath.Join(worktree, rel), nil
+	}
+	if len(searchPath) == 0 {
+		searchPath = []string{"."}
+	}
+	var mapped []string
+	for _, dir := range searchPath {
+		m, err := mapPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		mapped = append(mapped, m)
+	}
+	if filepath.IsAbs(changelogFile) {
+		if changelogFile, err = mapPath(changelogFile); err != nil {
+			return nil, err
+		}
+	}
+	tree, err := LoadChangelogTree(changelogFile, mapped)
+	if err != nil {
+		return nil, fmt.Errorf("at %s: %v", revision, err)
+	}
+	return tree, nil
+}
+
+// CountToCommit returns how many of the pending changesets update-count has
+// to apply to deploy exactly the changesets that existed at a git revision,
+// so the schema matches an application built from it. Changesets added after
+// the revision must come after the ones it has in the changelog, otherwise
+// update-count can't skip them and an error is returned.
+func (pl *GoLiquibase) CountToCommit(ctx context.Context, revision string, applied []AppliedChangeSet, arguments ...string) (int, error) {
+	changelogFile, searchPath := pl.ChangelogLocation(arguments...)
+	if changelogFile == "" {
+		return 0, fmt.Errorf("no changelog file to find the changesets of %s in", revision)
+	}
+	current, err := LoadChangelogTree(changelogFile, searchPath)
+	if err != nil {
+		return 0, err
+	}
+	atCommit, err := ChangelogTreeAt(ctx, revision, changelogFile, searchPath)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var later *ChangeSet
+	for _, cs := range PendingChangeSets(current, applied) {
+		existed := atCommit.Find(cs.Key()) != nil
+		switch {
+		case existed && later != nil:
+			return 0, fmt.Errorf("changeset %s existed at %s but comes after %s, which was added later, so update-count can't deploy one without the other", changesetLabel(cs.Key()), revision, changesetLabel(later.Key()))
+		case existed:
+			count++
+		case later == nil:
+			later = cs
+		}
+	}
+	return count, nil
+}
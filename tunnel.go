@@ -0,0 +1,448 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_SSH_BINARY = "ssh"
+	// How long an SSH tunnel may take to start forwarding
+	DEFAULT_TUNNEL_TIMEOUT = 30 * time.Second
+)
+
+const (
+	TLS_MODE_REQUIRE     = "require"
+	TLS_MODE_VERIFY_CA   = "verify-ca"
+	TLS_MODE_VERIFY_FULL = "verify-full"
+)
+
+// Ports of the JDBC subprotocols, for urls without one
+var JDBC_DEFAULT_PORTS = map[string]int{
+	"postgresql": 5432,
+	"redshift":   5439,
+	"mysql":      3306,
+	"mariadb":    3306,
+	"sqlserver":  1433,
+	"oracle":     1521,
+	"db2":        50000,
+}
+
+// The subprotocol and the first host and port of a JDBC url, e.g.
+// jdbc:postgresql://db:5432/app or jdbc:oracle:thin:@db:1521/app
+var jdbcHostPattern = regexp.MustCompile(`^jdbc:([a-z0-9]+)(?::[a-z]+)*:(?://|@//|@)(\[[^\]]+\]|[^/:;?,@]+)(?::(\d+))?`)
+
+// TunnelConfig is an SSH bastion the database is reached through
+type TunnelConfig struct {
+	// Bastion is the jump host as user@host or user@host:port
+	Bastion string `yaml:"bastion"`
+	// IdentityFile is the private key, ssh's own keys and agent otherwise
+	IdentityFile string `yaml:"identityFile"`
+	// Options are ssh -o options, e.g. StrictHostKeyChecking=accept-new
+	Options []string `yaml:"options"`
+	// SSH is the ssh binary, found on the PATH by default
+	SSH string `yaml:"ssh"`
+}
+
+// TLSConfig is the SSL mode, server CA and client certificate of the JDBC
+// connection. Postgres takes PEM files and a PKCS-8 key, the other drivers
+// Java truststores and keystores.
+type TLSConfig struct {
+	// Mode is require, verify-ca or verify-full
+	Mode string `yaml:"mode"`
+	// RootCert is the CA of the server, a truststore except on Postgres
+	RootCert            string `yaml:"rootCert"`
+	RootCertPasswordEnv string `yaml:"rootCertPasswordEnv"`
+	// ClientCert is the client certificate, a keystore holding the key too
+	// except on Postgres, which takes the key in ClientKey
+	ClientCert           string `yaml:"clientCert"`
+	ClientKey            string `yaml:"clientKey"`
+	ClientKeyPasswordEnv string `yaml:"clientKeyPasswordEnv"`
+}
+
+// Validate checks the SSL mode
+func (c *TLSConfig) Validate() error {
+	switch c.Mode {
+	case "", TLS_MODE_REQUIRE, TLS_MODE_VERIFY_CA, TLS_MODE_VERIFY_FULL:
+		return nil
+	}
+	return fmt.Errorf("unknown tls mode %q, expecting require, verify-ca or verify-full", c.Mode)
+}
+
+// DriverProperties returns the JDBC driver properties of the settings for a
+// JDBC subprotocol, e.g. postgresql
+func (c *TLSConfig) DriverProperties(subprotocol string) (map[string]string, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	mode := c.Mode
+	if mode == "" {
+		mode = TLS_MODE_VERIFY_FULL
+	}
+	props := map[string]string{}
+	set := func(key, value string) {
+		if value != "" {
+			props[key] = value
+		}
+	}
+	setEnv := func(key, env string) {
+		if env != "" {
+			set(key, os.Getenv(env))
+		}
+	}
+	fileURL := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		return "file:" + filepath.ToSlash(path)
+	}
+	switch subprotocol {
+	case "postgresql", "redshift":
+		props["sslmode"] = mode
+		set("sslrootcert", c.RootCert)
+		set("sslcert", c.ClientCert)
+		set("sslkey", c.ClientKey)
+		setEnv("sslpassword", c.ClientKeyPasswordEnv)
+	case "mysql":
+		props["sslMode"] = map[string]string{TLS_MODE_REQUIRE: "REQUIRED", TLS_MODE_VERIFY_CA: "VERIFY_CA", TLS_MODE_VERIFY_FULL: "VERIFY_IDENTITY"}[mode]
+		set("trustCertificateKeyStoreUrl", fileURL(c.RootCert))
+		setEnv("trustCertificateKeyStorePassword", c.RootCertPasswordEnv)
+		set("clientCertificateKeyStoreUrl", fileURL(c.ClientCert))
+		setEnv("clientCertificateKeyStorePassword", c.ClientKeyPasswordEnv)
+	case "mariadb":
+		props["sslMode"] = map[string]string{TLS_MODE_REQUIRE: "trust", TLS_MODE_VERIFY_CA: "verify-ca", TLS_MODE_VERIFY_FULL: "verify-full"}[mode]
+		set("serverSslCert", c.RootCert)
+		set("keyStore", c.ClientCert)
+		setEnv("keyStorePassword", c.ClientKeyPasswordEnv)
+	case "sqlserver":
+		if c.ClientCert != "" || c.ClientKey != "" {
+			return nil, fmt.Errorf("client certificates are not supported on sqlserver urls")
+		}
+		props["encrypt"] = "true"
+		props["trustServerCertificate"] = strconv.FormatBool(mode == TLS_MODE_REQUIRE)
+		set("trustStore", c.RootCert)
+		setEnv("trustStorePassword", c.RootCertPasswordEnv)
+	default:
+		return nil, fmt.Errorf("tls settings are supported on postgresql, redshift, mysql, mariadb and sqlserver urls, not %s", subprotocol)
+	}
+	if c.ClientKey != "" && subprotocol != "postgresql" && subprotocol != "redshift" {
+		return nil, fmt.Errorf("%s takes the client key in the clientCert keystore, not in clientKey", subprotocol)
+	}
+	return props, nil
+}
+
+// Driver properties keeping the server's host name checkable through a
+// tunnel, where the url points at 127.0.0.1. The SQL Server driver is told the
+// host the certificate names. The Postgres, MySQL and MariaDB drivers can't
+// be, so verify-full is refused with a tunnel there rather than always failing.
+func tunnelHostProperties(c *TLSConfig, subprotocol, host string) (map[string]string, error) {
+	if subprotocol == "sqlserver" {
+		return map[string]string{"hostNameInCertificate": host}, nil
+	}
+	if c == nil || (c.Mode != "" && c.Mode != TLS_MODE_VERIFY_FULL) {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("tls mode verify-full checks the host name %s, which the %s driver can't be told through a tunnel to 127.0.0.1, use verify-ca with a tunnel", host, subprotocol)
+}
+
+// Tunnel is an SSH port forward from a local port to a database, through a bastion
+type Tunnel struct {
+	LocalPort int
+	cmd       *exec.Cmd
+	exited    chan error
+}
+
+// OpenTunnel starts ssh forwarding a local port to host:port through the
+// bastion, and waits until the forward accepts connections
+func OpenTunnel(ctx context.Context, cfg *TunnelConfig, host string, port int) (*Tunnel, error) {
+	bastion, bastionPort := cfg.Bastion, ""
+	if at := strings.LastIndex(bastion, "@"); at >= 0 {
+		if i := strings.LastIndex(bastion, ":"); i > at {
+			bastion, bastionPort = cfg.Bastion[:i], cfg.Bastion[i+1:]
+		}
+	}
+	if !strings.Contains(bastion, "@") {
+		return nil, fmt.Errorf("invalid tunnel bastion %q, expecting user@host or user@host:port", cfg.Bastion)
+	}
+	localPort, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-N", "-o", "ExitOnForwardFailure=yes", "-o", "BatchMode=yes", "-o", "ServerAliveInterval=30",
+		"-L", fmt.Sprintf("127.0.0.1:%d:%s:%d", localPort, host, port)}
+	if bastionPort != "" {
+		args = append(args, "-p", bastionPort)
+	}
+	if cfg.IdentityFile != "" {
+		args = append(args, "-i", cfg.IdentityFile)
+	}
+	for _, option := range cfg.Options {
+		args = append(args, "-o", option)
+	}
+	args = append(args, bastion)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(firstNonEmpty(cfg.SSH, DEFAULT_SSH_BINARY), args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the ssh tunnel: %v", err)
+	}
+	t := &Tunnel{LocalPort: localPort, cmd: cmd, exited: make(chan error, 1)}
+	go func() { t.exited <- cmd.Wait() }()
+
+	deadline := time.NewTimer(DEFAULT_TUNNEL_TIMEOUT)
+	defer deadline.Stop()
+	for {
+		if conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", localPort), time.Second); err == nil {
+			conn.Close()
+			return t, nil
+		}
+		select {
+		case err := <-t.exited:
+			return nil, fmt.Errorf("ssh tunnel through %s failed: %v: %s", cfg.Bastion, err, strings.TrimSpace(stderr.String()))
+		case <-ctx.Done():
+			t.Close()
+			return nil, ctx.Err()
+		case <-deadline.C:
+			t.Close()
+			return nil, fmt.Errorf("ssh tunnel through %s didn't start forwarding within %s", cfg.Bastion, DEFAULT_TUNNEL_TIMEOUT)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Close stops the tunnel
+func (t *Tunnel) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.cmd.Process.Kill()
+	<-t.exited
+	return nil
+}
+
+// Pick a local port no one listens on
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// TunnelURL points a JDBC url at a local port instead of its host and port
+func TunnelURL(jdbcURL string, localPort int) (string, error) {
+	m := jdbcHostPattern.FindStringSubmatchIndex(jdbcURL)
+	if m == nil {
+		return "", fmt.Errorf("can't find the host of %s to tunnel to", stripURLCredentials(jdbcURL))
+	}
+	end := m[5]
+	if m[6] >= 0 {
+		end = m[7]
+	}
+	return fmt.Sprintf("%s127.0.0.1:%d%s", jdbcURL[:m[4]], localPort, jdbcURL[end:]), nil
+}
+
+// Find the host and port a JDBC url connects to, and its subprotocol
+func jdbcHost(jdbcURL string) (subprotocol, host string, port int, err error) {
+	m := jdbcHostPattern.FindStringSubmatch(jdbcURL)
+	if m == nil {
+		return "", "", 0, fmt.Errorf("can't find the host of %s", stripURLCredentials(jdbcURL))
+	}
+	subprotocol, host = m[1], m[2]
+	if m[3] != "" {
+		port, _ = strconv.Atoi(m[3])
+	} else if port = JDBC_DEFAULT_PORTS[subprotocol]; port == 0 {
+		return "", "", 0, fmt.Errorf("%s has no port and %s has no default one", stripURLCredentials(jdbcURL), subprotocol)
+	}
+	return subprotocol, host, port, nil
+}
+
+// connection holds what a command needs to reach the database, released
+// once the command ends
+type connection struct {
+	tunnel  *Tunnel
+	cmdArgs []string
+	// Private directory of the driver properties and login files
+	dir         string
+	driverProps map[string]string
+	// JVM options and environment variables of the command
+	javaOptions []string
+	env         []string
+	// Watches for Azure SQL rejecting the access token, nil without Entra
+	entra *entraLoginObserver
+}
+
+func (c *connection) driverPropertiesFile() string {
+	return filepath.Join(c.dir, "driver.properties")
+}
+
+func (c *connection) writeDriverProperties() error {
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(c.driverProps) {
+		fmt.Fprintf(&buf, "%s=%s\n", key, escapeProperty(c.driverProps[key]))
+	}
+	return os.WriteFile(c.driverPropertiesFile(), buf.Bytes(), 0600)
+}
+
+func (c *connection) Close() {
+	c.tunnel.Close()
+	if c.dir != "" {
+		os.RemoveAll(c.dir)
+	}
+}
+
+// Hand the JVM options and environment variables of the connection to a command
+func (c *connection) apply(cmd *Command) {
+	if len(c.javaOptions) > 0 {
+		addJavaOptions(cmd, c.javaOptions)
+	}
+	if len(c.env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, c.env...)
+	}
+}
+
+// Set up the tunnel, TLS, Kerberos, Entra and cloud database settings of a
+// command. The tunnel rewrites the --url argument, or passes the url of the
+// defaults file as one. Driver settings are passed in a driver properties
+// file, keeping key passwords off the command line.
+func (pl *GoLiquibase) openConnection(ctx context.Context, cmdArgs []string) (_ *connection, err error) {
+	c := &connection{cmdArgs: cmdArgs}
+	needsHost := pl.Tunnel != nil || pl.TLS != nil || pl.Kerberos != nil || pl.Entra != nil
+	if !needsHost && pl.DSQL == nil && pl.Spanner == nil {
+		return c, nil
+	}
+	urlIndex := -1
+	var jdbcURL string
+	for i, arg := range cmdArgs {
+		if u, ok := strings.CutPrefix(arg, "--url="); ok {
+			urlIndex, jdbcURL = i, u
+		}
+	}
+	if urlIndex < 0 && pl.DefaultsFile != "" && fileExists(pl.DefaultsFile) {
+		props, err := ReadDefaultsFile(pl.DefaultsFile)
+		if err != nil {
+			return nil, err
+		}
+		jdbcURL = firstNonEmpty(props["url"], props["liquibase.command.url"])
+	}
+	if jdbcURL == "" {
+		// Cloud databases build their url from the config
+		switch {
+		case pl.DSQL != nil:
+			jdbcURL = pl.DSQL.URL()
+		case pl.Spanner != nil:
+			jdbcURL = pl.Spanner.URL()
+		default:
+			// Commands without a database have nothing to connect to
+			return c, nil
+		}
+		c.cmdArgs = append(c.cmdArgs, "--url="+jdbcURL)
+		urlIndex = len(c.cmdArgs) - 1
+	}
+	var subprotocol, host string
+	var port int
+	if needsHost {
+		if subprotocol, host, port, err = jdbcHost(jdbcURL); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+	if c.dir, err = os.MkdirTemp("", "goliquify-connection-"); err != nil {
+		return nil, err
+	}
+
+	driverProps := map[string]string{}
+	if pl.TLS != nil {
+		props, err := pl.TLS.DriverProperties(subprotocol)
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range props {
+			driverProps[key] = val
+		}
+	}
+	if pl.Kerberos != nil {
+		login, err := pl.Kerberos.login(ctx, subprotocol, c.dir, pl.logger())
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range login.driverProperties {
+			driverProps[key] = val
+		}
+		c.javaOptions, c.env = login.javaOptions, login.env
+	}
+	if pl.Entra != nil {
+		if subprotocol != "sqlserver" {
+			return nil, fmt.Errorf("entra access tokens are supported on sqlserver urls, not %s", subprotocol)
+		}
+		token, err := pl.Entra.AccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		driverProps["accessToken"] = token
+		c.entra = &entraLoginObserver{}
+	}
+	if pl.DSQL != nil {
+		// The token is the password, handed over like read-only credentials
+		token, err := pl.DSQLAuthToken(ctx, pl.DSQL)
+		if err != nil {
+			return nil, err
+		}
+		c.env = append(c.env, LIQUIBASE_USERNAME_ENV+"="+pl.DSQL.user(), LIQUIBASE_PASSWORD_ENV+"="+token)
+	}
+	if pl.Tunnel != nil {
+		props, err := tunnelHostProperties(pl.TLS, subprotocol, host)
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range props {
+			driverProps[key] = val
+		}
+	}
+	if len(driverProps) > 0 {
+		c.driverProps = driverProps
+		if err := c.writeDriverProperties(); err != nil {
+			return nil, err
+		}
+		c.cmdArgs = append(c.cmdArgs, "--driver-properties-file="+c.driverPropertiesFile())
+	}
+
+	if pl.Tunnel != nil {
+		pl.logger().Printf("Opening an ssh tunnel to %s:%d through %s", host, port, pl.Tunnel.Bastion)
+		if c.tunnel, err = OpenTunnel(ctx, pl.Tunnel, host, port); err != nil {
+			return nil, err
+		}
+		tunneled, err := TunnelURL(jdbcURL, c.tunnel.LocalPort)
+		if err != nil {
+			return nil, err
+		}
+		if urlIndex >= 0 {
+			c.cmdArgs[urlIndex] = "--url=" + tunneled
+		} else {
+			c.cmdArgs = append(c.cmdArgs, "--url="+tunneled)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,35 @@
+package goliquify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTunnelKeepsTheHostNameCheckable(t *testing.T) {
+	for _, tc := range []struct {
+		tls         *TLSConfig
+		subprotocol string
+		props       map[string]string
+		err         string
+	}{
+		{nil, "sqlserver", map[string]string{"hostNameInCertificate": "db.example.com"}, ""},
+		{&TLSConfig{Mode: TLS_MODE_VERIFY_FULL}, "sqlserver", map[string]string{"hostNameInCertificate": "db.example.com"}, ""},
+		{nil, "postgresql", nil, ""},
+		{&TLSConfig{Mode: TLS_MODE_VERIFY_CA}, "postgresql", nil, ""},
+		// verify-full is the default mode
+		{&TLSConfig{}, "postgresql", nil, "verify-full"},
+		{&TLSConfig{Mode: TLS_MODE_VERIFY_FULL}, "mysql", nil, "verify-full"},
+		{&TLSConfig{Mode: TLS_MODE_VERIFY_FULL}, "mariadb", nil, "verify-full"},
+	} {
+		props, err := tunnelHostProperties(tc.tls, tc.subprotocol, "db.example.com")
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s %+v: failed with %v, want %q", tc.subprotocol, tc.tls, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || len(props) != len(tc.props) || props["hostNameInCertificate"] != tc.props["hostNameInCertificate"] {
+			t.Errorf("%s %+v: properties %v, %v, want %v", tc.subprotocol, tc.tls, props, err, tc.props)
+		}
+	}
+}
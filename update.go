@@ -0,0 +1,89 @@
+package goliquify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// First Liquibase version with update --rollback-on-error, a Liquibase Pro feature
+const ROLLBACK_ON_ERROR_VERSION = "4.26.0"
+
+// Counts the changesets an update started and the ones it finished
+type deployCounter struct {
+	mu       sync.Mutex
+	started  int
+	finished int
+}
+
+func (c *deployCounter) observe(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if runningChangesetPattern.MatchString(line) {
+		c.started++
+	} else if changesetRanPattern.MatchString(line) {
+		c.finished++
+	}
+}
+
+// The changesets a failed update left deployed. Finished changesets are only
+// logged at info level, otherwise the last one started is the one that failed.
+func (c *deployCounter) deployed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished > 0 {
+		return c.finished
+	}
+	if c.started > 0 {
+		return c.started - 1
+	}
+	return 0
+}
+
+// Check if the installed Liquibase rolls back failed updates itself
+func (pl *GoLiquibase) nativeRollbackOnError() bool {
+	version, err := liquibaseSemver(pl.InstalledVersion())
+	if err != nil {
+		return false
+	}
+	minimum, _ := ParseSemver(ROLLBACK_ON_ERROR_VERSION)
+	return !version.Less(minimum) && pl.HasProLicense()
+}
+
+// Run an update, rolling back what it deployed when it fails
+func (pl *GoLiquibase) updateRollingBackOnError(args []string) error {
+	if pl.DryRun {
+		return pl.Execute(args...)
+	}
+	if _, err := pl.installedDir(); err != nil {
+		return err
+	}
+	if pl.nativeRollbackOnError() {
+		return pl.Execute(append(args, "--rollback-on-error=true")...)
+	}
+
+	pl.logger().Printf("Liquibase %s has no Pro update --rollback-on-error, rolling back with rollback-count on failure", firstNonEmpty(pl.InstalledVersion(), USER_PROVIDED_VERSION))
+	counter := &deployCounter{}
+	stdoutLines, stderrLines := newLineWriter(counter.observe), newLineWriter(counter.observe)
+	err := pl.ExecuteWithOptions(context.Background(), ExecOptions{
+		Stdout: io.MultiWriter(os.Stdout, stdoutLines),
+		Stderr: io.MultiWriter(os.Stderr, stderrLines),
+	}, args...)
+	stdoutLines.Flush()
+	stderrLines.Flush()
+	if err == nil {
+		return nil
+	}
+
+	deployed := counter.deployed()
+	if deployed == 0 {
+		return fmt.Errorf("update failed before deploying any changeset: %v", err)
+	}
+	pl.logger().Printf("Update failed, rolling back the %d changeset(s) it deployed", deployed)
+	if rollbackErr := pl.Execute("rollback-count", fmt.Sprintf("--count=%d", deployed)); rollbackErr != nil {
+		return fmt.Errorf("update failed: %v, and rolling back its %d changeset(s) failed too: %v", err, deployed, rollbackErr)
+	}
+	return fmt.Errorf("update failed and its %d changeset(s) were rolled back: %v", deployed, err)
+}
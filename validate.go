@@ -0,0 +1,233 @@
+package goliquify
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ValidationError reports a user supplied value that is unsafe to pass to Liquibase
+type ValidationError struct {
+	Kind   string
+	Value  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Kind, e.Value, e.Reason)
+}
+
+// Reject control characters, which can break log output and command lines
+func checkControlChars(kind, value string) error {
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return &ValidationError{Kind: kind, Value: value, Reason: "contains control characters"}
+		}
+	}
+	return nil
+}
+
+// Check a value that is passed as a command argument. Values starting with a
+// dash would be read as a flag, letting them inject options.
+func validateValue(kind, value string) error {
+	if value == "" {
+		return &ValidationError{Kind: kind, Value: value, Reason: "is empty"}
+	}
+	if strings.HasPrefix(value, "-") {
+		return &ValidationError{Kind: kind, Value: value, Reason: "must not start with '-'"}
+	}
+	return checkControlChars(kind, value)
+}
+
+// ValidateTag checks a tag name
+func ValidateTag(tag string) error {
+	return validateValue("tag", tag)
+}
+
+// ValidatePath checks a file path. Relative paths starting with a dash can be
+// passed by prefixing them with ./
+func ValidatePath(path string) error {
+	return validateValue("path", path)
+}
+
+// ValidateArgKey checks the name of a --key=value argument
+func ValidateArgKey(key string) error {
+	if key == "" {
+		return &ValidationError{Kind: "argument name", Value: key, Reason: "is empty"}
+	}
+	for _, r := range key {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.' || r == '_') {
+			return &ValidationError{Kind: "argument name", Value: key, Reason: "may only contain letters, digits, '-', '.' and '_'"}
+		}
+	}
+	if strings.HasPrefix(key, "-") {
+		return &ValidationError{Kind: "argument name", Value: key, Reason: "must not start with '-'"}
+	}
+	return nil
+}
+
+// ValidatePropertyKey checks the name of a changelog property
+func ValidatePropertyKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "= \t") {
+		return &ValidationError{Kind: "changelog property", Value: key, Reason: "must be non-empty without '=' or whitespace"}
+	}
+	return checkControlChars("changelog property", key)
+}
+
+// Check every argument of a command line for control characters
+func validateArguments(args []string) error {
+	for _, arg := range args {
+		if err := checkControlChars("argument", arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuoteArgs renders arguments as a shell command line, quoting where needed,
+// so logged command lines can be copied and run as-is
+func QuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quoteArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range arg {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.,:/=@+%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// UpdateOptions selects what an update applies
+type UpdateOptions struct {
+	// Tag updates up to and including the changeset with this tag
+	Tag string
+	// Count applies only the next Count changesets
+	Count int
+	// ChangelogFile overrides the changelog from the defaults file
+	ChangelogFile string
+	ContextFilter string
+	LabelFilter   string
+	// RollbackOnError rolls back every changeset the update deployed when one
+	// of them fails, making the whole update the transaction boundary instead
+	// of each changeset. Liquibase Pro 4.26 and later do it natively, other
+	// versions have it emulated with rollback-count.
+	RollbackOnError bool
+	// Args are more update arguments, passed as given
+	Args []string
+}
+
+// Build the command and arguments for the options
+func (o UpdateOptions) args() ([]string, error) {
+	if o.Tag != "" && o.Count > 0 {
+		return nil, fmt.Errorf("update can be limited by tag or by count, not both")
+	}
+	if o.Count < 0 {
+		return nil, fmt.Errorf("invalid update count %d", o.Count)
+	}
+
+	args := []string{"update"}
+	switch {
+	case o.Tag != "":
+		if err := ValidateTag(o.Tag); err != nil {
+			return nil, err
+		}
+		args = []string{"update-to-tag", fmt.Sprintf("--tag=%s", o.Tag)}
+	case o.Count > 0:
+		args = []string{"update-count", fmt.Sprintf("--count=%d", o.Count)}
+	}
+	args, err := appendFilterArgs(args, o.ChangelogFile, o.ContextFilter, o.LabelFilter)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, o.Args...), nil
+}
+
+// RollbackOptions selects what a rollback undoes
+type RollbackOptions struct {
+	// Tag rolls back every changeset applied after this tag
+	Tag string
+	// Count rolls back the last Count changesets
+	Count int
+	// ChangelogFile overrides the changelog from the defaults file
+	ChangelogFile string
+	ContextFilter string
+	LabelFilter   string
+}
+
+// Build the command and arguments for the options
+func (o RollbackOptions) args() ([]string, error) {
+	var args []string
+	switch {
+	case o.Tag != "" && o.Count > 0:
+		return nil, fmt.Errorf("rollback is either to a tag or by count, not both")
+	case o.Tag != "":
+		if err := ValidateTag(o.Tag); err != nil {
+			return nil, err
+		}
+		args = []string{"rollback", fmt.Sprintf("--tag=%s", o.Tag)}
+	case o.Count > 0:
+		args = []string{"rollback-count", fmt.Sprintf("--count=%d", o.Count)}
+	default:
+		return nil, fmt.Errorf("rollback needs a tag or a positive count")
+	}
+	return appendFilterArgs(args, o.ChangelogFile, o.ContextFilter, o.LabelFilter)
+}
+
+// Add the validated changelog, context and label arguments
+func appendFilterArgs(args []string, changelogFile, contextFilter, labelFilter string) ([]string, error) {
+	if changelogFile != "" {
+		if err := ValidatePath(changelogFile); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--changelog-file=%s", changelogFile))
+	}
+	if contextFilter != "" {
+		if err := validateValue("context filter", contextFilter); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--context-filter=%s", contextFilter))
+	}
+	if labelFilter != "" {
+		if err := validateValue("label filter", labelFilter); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--label-filter=%s", labelFilter))
+	}
+	return args, nil
+}
+
+// Update the database as selected by the options
+func (pl *GoLiquibase) UpdateWith(opts UpdateOptions) error {
+	args, err := opts.args()
+	if err != nil {
+		return err
+	}
+	if opts.RollbackOnError {
+		return pl.updateRollingBackOnError(args)
+	}
+	return pl.Execute(args...)
+}
+
+// Rollback the database as selected by the options
+func (pl *GoLiquibase) RollbackWith(opts RollbackOptions) error {
+	args, err := opts.args()
+	if err != nil {
+		return err
+	}
+	pl.logger().Printf("Rolling back: %s", QuoteArgs(args[1:]))
+	return pl.Execute(args...)
+}
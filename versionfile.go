@@ -0,0 +1,58 @@
+package goliquify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File pinning the Liquibase version of a repository, like .nvmrc for Node
+const VERSION_FILE = ".liquibase-version"
+
+// FindVersionFile looks for a version file in dir and its parents, up to the
+// root of the git repository. Returns the file and the version it pins, or
+// empty strings when there is none.
+func FindVersionFile(dir string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for {
+		path := filepath.Join(dir, VERSION_FILE)
+		if fileExists(path) {
+			version, err := ReadVersionFile(path)
+			return path, version, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || dirExists(filepath.Join(dir, ".git")) || fileExists(filepath.Join(dir, ".git")) {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// ReadVersionFile reads the version pinned by a version file: its first line
+// that isn't blank or a # comment, with an optional v prefix, or a channel
+// like latest-4.x
+func ReadVersionFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if IsVersionChannel(line) {
+			return line, nil
+		}
+		version := strings.TrimPrefix(line, "v")
+		if _, err := ParseSemver(version); err != nil {
+			return "", fmt.Errorf("invalid Liquibase version %q in %s", line, path)
+		}
+		return version, nil
+	}
+	return "", fmt.Errorf("no Liquibase version in %s", path)
+}
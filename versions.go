@@ -0,0 +1,238 @@
+package goliquify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LIQUIBASE_RELEASES_URL = "https://api.github.com/repos/liquibase/liquibase/releases?per_page=100"
+	RELEASES_CACHE_FILE    = "liquibase-releases.json"
+	// How long the cached release list is used without asking GitHub again
+	RELEASES_CACHE_TTL = 6 * time.Hour
+	LATEST_VERSION     = "latest"
+)
+
+// Channels follow the newest release of a major or minor line, as in latest-4.x or latest-4.29.x
+var channelPattern = regexp.MustCompile(`^latest-(\d+)\.(?:(\d+)\.)?x$`)
+
+// Links to further pages of a GitHub API listing
+var nextLinkPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// LiquibaseRelease is a published Liquibase release
+type LiquibaseRelease struct {
+	Version    string    `json:"version"`
+	Published  time.Time `json:"published"`
+	Prerelease bool      `json:"prerelease"`
+}
+
+// The release list cached between runs, with the ETag to revalidate it
+type releasesCache struct {
+	Fetched  time.Time          `json:"fetched"`
+	ETag     string             `json:"etag"`
+	Releases []LiquibaseRelease `json:"releases"`
+}
+
+// RateLimitError reports that the GitHub API refused a request for exceeding its rate limit
+type RateLimitError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	msg := "GitHub API rate limit exceeded, set GITHUB_TOKEN to raise it"
+	if !e.Reset.IsZero() {
+		msg += fmt.Sprintf(" or retry after %s", e.Reset.Format(time.Kitchen))
+	}
+	return msg
+}
+
+// IsVersionChannel checks if a version is latest or a channel like latest-4.x
+func IsVersionChannel(version string) bool {
+	return version == LATEST_VERSION || channelPattern.MatchString(version)
+}
+
+// ListReleases returns the Liquibase releases, newest first. The list is
+// cached in cacheDir for RELEASES_CACHE_TTL and revalidated with its ETag,
+// which GitHub doesn't count against the rate limit. When GitHub can't be
+// reached or the rate limit is exhausted a stale cache is used instead.
+func ListReleases(ctx context.Context, cacheDir string) ([]LiquibaseRelease, error) {
+	cacheFile := filepath.Join(cacheDir, RELEASES_CACHE_FILE)
+	var cache releasesCache
+	if data, err := os.ReadFile(cacheFile); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	if len(cache.Releases) > 0 && time.Since(cache.Fetched) < RELEASES_CACHE_TTL {
+		return cache.Releases, nil
+	}
+
+	releases, etag, err := fetchReleases(ctx, cache.ETag)
+	switch {
+	case err != nil && len(cache.Releases) > 0:
+		// A stale list beats failing the run
+		return cache.Releases, nil
+	case err != nil:
+		return nil, err
+	case releases == nil:
+		// Not modified since the cached list
+		releases, etag = cache.Releases, cache.ETag
+	}
+
+	cache = releasesCache{Fetched: time.Now(), ETag: etag, Releases: releases}
+	if data, err := json.Marshal(cache); err == nil {
+		if os.MkdirAll(cacheDir, 0755) == nil {
+			os.WriteFile(cacheFile, data, 0644)
+		}
+	}
+	return releases, nil
+}
+
+// Fetch every page of releases from GitHub. Returns nil releases when the
+// first page is unchanged since etag.
+func fetchReleases(ctx context.Context, etag string) ([]LiquibaseRelease, string, error) {
+	var releases []LiquibaseRelease
+	newETag := ""
+	for url := LIQUIBASE_RELEASES_URL; url != ""; {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		request.Header.Set("Accept", "application/vnd.github+json")
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		first := url == LIQUIBASE_RELEASES_URL
+		if first && etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, "", err
+		}
+		if first && response.StatusCode == http.StatusNotModified {
+			response.Body.Close()
+			return nil, etag, nil
+		}
+		if err := rateLimitError(response); err != nil {
+			response.Body.Close()
+			return nil, "", err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, "", fmt.Errorf("failed to list Liquibase releases: %s", response.Status)
+		}
+
+		var page []struct {
+			TagName     string    `json:"tag_name"`
+			PublishedAt time.Time `json:"published_at"`
+			Prerelease  bool      `json:"prerelease"`
+			Draft       bool      `json:"draft"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list Liquibase releases: %v", err)
+		}
+		for _, r := range page {
+			version := strings.TrimPrefix(r.TagName, "v")
+			// Skip drafts and tags like v4.30.0-beta that aren't plain versions
+			if _, err := ParseSemver(version); err != nil || r.Draft {
+				continue
+			}
+			releases = append(releases, LiquibaseRelease{Version: version, Published: r.PublishedAt, Prerelease: r.Prerelease})
+		}
+		if first {
+			newETag = response.Header.Get("ETag")
+		}
+		url = ""
+		if m := nextLinkPattern.FindStringSubmatch(response.Header.Get("Link")); m != nil {
+			url = m[1]
+		}
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		a, _ := ParseSemver(releases[i].Version)
+		b, _ := ParseSemver(releases[j].Version)
+		return b.Less(a)
+	})
+	return releases, newETag, nil
+}
+
+// Detect a rate limited response, GitHub answers 403 or 429 with no requests remaining
+func rateLimitError(response *http.Response) error {
+	if response.StatusCode != http.StatusForbidden && response.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if response.StatusCode == http.StatusForbidden && response.Header.Get("X-RateLimit-Remaining") != "0" {
+		return nil
+	}
+	err := &RateLimitError{}
+	if reset, parseErr := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64); parseErr == nil {
+		err.Reset = time.Unix(reset, 0)
+	} else if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil {
+		err.Reset = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return err
+}
+
+// LatestRelease picks the newest stable release of a channel: latest, latest-4.x or latest-4.29.x
+func LatestRelease(releases []LiquibaseRelease, channel string) (string, error) {
+	major, minor := -1, -1
+	if channel != LATEST_VERSION {
+		m := channelPattern.FindStringSubmatch(channel)
+		if m == nil {
+			return "", fmt.Errorf("invalid version channel %s, expecting latest, latest-<major>.x or latest-<major>.<minor>.x", channel)
+		}
+		major, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			minor, _ = strconv.Atoi(m[2])
+		}
+	}
+	var newest *Semver
+	for _, r := range releases {
+		if r.Prerelease {
+			continue
+		}
+		v, err := ParseSemver(r.Version)
+		if err != nil || (major >= 0 && v.Major != major) || (minor >= 0 && v.Minor != minor) {
+			continue
+		}
+		if newest == nil || newest.Less(v) {
+			newest = &v
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no Liquibase release matches %s", channel)
+	}
+	return newest.String(), nil
+}
+
+// ResolveVersion turns latest and channels like latest-4.x into a release
+// version, other versions are returned as they are
+func ResolveVersion(ctx context.Context, cacheDir, version string) (string, error) {
+	if !IsVersionChannel(version) {
+		return version, nil
+	}
+	releases, err := ListReleases(ctx, cacheDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve Liquibase version %s: %v", version, err)
+	}
+	return LatestRelease(releases, version)
+}
+
+// Releases lists the Liquibase releases, cached in the instance's cache dir
+func (pl *GoLiquibase) Releases(ctx context.Context) ([]LiquibaseRelease, error) {
+	return ListReleases(ctx, pl.cacheDir())
+}
+
+// IsVersionCached checks if a Liquibase version is installed in the cache dir
+func (pl *GoLiquibase) IsVersionCached(version string) bool {
+	return isLiquibaseInstalled(filepath.Join(pl.cacheDir(), versioned(LIQUIBASE_DIR, version)))
+}
@@ -0,0 +1,202 @@
+package goliquify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	// Kind of the diagnostics policy modules report without one
+	LINT_POLICY = "policy"
+	// How long a policy module may run on one lint
+	POLICY_TIMEOUT = 30 * time.Second
+	// Name of the host module policy modules import their functions from
+	POLICY_HOST_MODULE = "goliquify"
+	// Memory a policy module may grow to, in 64 KiB pages: 256 MiB
+	POLICY_MEMORY_LIMIT_PAGES = 4096
+)
+
+// PolicyConfig lists the WASM modules checking changelogs with user rules
+type PolicyConfig struct {
+	// Modules are WASM modules, e.g. Go programs built with GOOS=wasip1
+	Modules []string `yaml:"modules"`
+}
+
+// PolicyInput is what a policy module reads from the host: the parsed changelogs
+// and, when given, the differences between an expected and the live schema
+type PolicyInput struct {
+	Files       []PolicyFile       `json:"files"`
+	Differences []SchemaDifference `json:"differences,omitempty"`
+}
+
+// PolicyFile is a parsed changelog file as policy modules see it
+type PolicyFile struct {
+	Path            string            `json:"path"`
+	LogicalFilePath string            `json:"logicalFilePath,omitempty"`
+	ChangeSets      []PolicyChangeSet `json:"changeSets"`
+}
+
+// PolicyChangeSet is a changeset with the source of its definition
+type PolicyChangeSet struct {
+	*ChangeSet
+	Body string `json:"body"`
+}
+
+// NewPolicyInput parses changelog files into the model given to policy
+// modules. Files failing to parse are left out, the regular lint reports them.
+func NewPolicyInput(paths []string, differences []SchemaDifference) *PolicyInput {
+	input := &PolicyInput{Files: []PolicyFile{}, Differences: differences}
+	for _, p := range paths {
+		file, err := ParseChangelogFile(p, filepath.ToSlash(p))
+		if err != nil {
+			continue
+		}
+		policyFile := PolicyFile{Path: file.DiskPath, LogicalFilePath: file.LogicalFilePath, ChangeSets: []PolicyChangeSet{}}
+		for _, cs := range file.ChangeSets {
+			policyFile.ChangeSets = append(policyFile.ChangeSets, PolicyChangeSet{ChangeSet: cs, Body: cs.Body})
+		}
+		input.Files = append(input.Files, policyFile)
+	}
+	return input
+}
+
+// ReadSchemaDifferences reads differences written by drift --format json
+func ReadSchemaDifferences(path string) ([]SchemaDifference, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var differences []SchemaDifference
+	if err := json.Unmarshal(data, &differences); err != nil {
+		return nil, fmt.Errorf("failed to read schema differences %s: %v", path, err)
+	}
+	return differences, nil
+}
+
+// RunPolicies runs every policy module on the input, on the WASM runtime
+// embedded in goliquify. A module reads the input as JSON and reports the
+// problems it finds as diagnostics through the functions of the goliquify
+// host module:
+//
+//	input_size() i32            the length of the input
+//	read_input(ptr, len i32) i32 copies the input to memory, returns the length copied
+//	report(ptr, len i32)         reports a diagnostic, a JSON object in memory
+//
+// A module is a WASI command checking in its main, or exports a check
+// function. It gets no directories, environment, network or real clock, only
+// WASI's stdout and stderr, so it only sees what it is given.
+func RunPolicies(ctx context.Context, cfg *PolicyConfig, input *PolicyInput) ([]Diagnostic, error) {
+	if len(cfg.Modules) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var diagnostics []Diagnostic
+	for _, module := range cfg.Modules {
+		binary, err := os.ReadFile(module)
+		if err != nil {
+			return nil, fmt.Errorf("policy module %s not found", module)
+		}
+		found, err := runPolicy(ctx, binary, data)
+		if err != nil {
+			return nil, fmt.Errorf("policy module %s failed: %v", module, err)
+		}
+		diagnostics = append(diagnostics, found...)
+	}
+	return diagnostics, nil
+}
+
+func runPolicy(ctx context.Context, binary, input []byte) ([]Diagnostic, error) {
+	ctx, cancel := context.WithTimeout(ctx, POLICY_TIMEOUT)
+	defer cancel()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(POLICY_MEMORY_LIMIT_PAGES))
+	defer runtime.Close(ctx)
+
+	// The host API, the only functions a module gets besides WASI
+	var diagnostics []Diagnostic
+	var reportErr error
+	inputSize := func() uint32 {
+		return uint32(len(input))
+	}
+	readInput := func(_ context.Context, m api.Module, ptr, size uint32) uint32 {
+		n := min(size, uint32(len(input)))
+		if !m.Memory().Write(ptr, input[:n]) {
+			panic(errors.New("read_input out of memory range"))
+		}
+		return n
+	}
+	report := func(_ context.Context, m api.Module, ptr, size uint32) {
+		data, ok := m.Memory().Read(ptr, size)
+		if !ok {
+			panic(errors.New("report out of memory range"))
+		}
+		var diagnostic Diagnostic
+		if err := json.Unmarshal(data, &diagnostic); err != nil {
+			reportErr = fmt.Errorf("failed to parse a diagnostic it reported: %v", err)
+			panic(reportErr)
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	_, err := runtime.NewHostModuleBuilder(POLICY_HOST_MODULE).
+		NewFunctionBuilder().WithFunc(inputSize).Export("input_size").
+		NewFunctionBuilder().WithFunc(readInput).Export("read_input").
+		NewFunctionBuilder().WithFunc(report).Export("report").
+		Instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Only WASI itself, no directories are mounted for it to open
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	var output bytes.Buffer
+	config := wazero.NewModuleConfig().
+		WithStdout(&output).
+		WithStderr(&output).
+		WithStartFunctions("_initialize", "_start")
+	module, err := runtime.InstantiateWithConfig(ctx, binary, config)
+	if err == nil {
+		if check := module.ExportedFunction("check"); check != nil {
+			_, err = check.Call(ctx)
+		}
+	}
+	if err != nil {
+		var exitErr *sys.ExitError
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			return nil, fmt.Errorf("timed out after %s", POLICY_TIMEOUT)
+		case reportErr != nil:
+			return nil, reportErr
+		case errors.As(err, &exitErr) && exitErr.ExitCode() == 0:
+		default:
+			if reason := strings.TrimSpace(output.String()); reason != "" {
+				return nil, fmt.Errorf("%v: %s", err, reason)
+			}
+			return nil, err
+		}
+	}
+	for i := range diagnostics {
+		if diagnostics[i].Kind == "" {
+			diagnostics[i].Kind = LINT_POLICY
+		}
+		if diagnostics[i].Severity == "" {
+			diagnostics[i].Severity = SEVERITY_ERROR
+		}
+	}
+	return diagnostics, nil
+}
@@ -0,0 +1,163 @@
+package goliquify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// WASM instructions and types the test modules use
+const (
+	wasmI32      = 0x7f
+	wasmI32Const = 0x41
+	wasmI32Eq    = 0x46
+	wasmCall     = 0x10
+	wasmIf       = 0x04
+	wasmLoop     = 0x03
+	wasmBr       = 0x0c
+	wasmEnd      = 0x0b
+	wasmVoid     = 0x40
+)
+
+func uleb(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		if n >>= 7; n != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+// Signed LEB128 of a non-negative number, as i32.const takes it
+func sleb(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		if n >>= 7; n != 0 || b&0x40 != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+func wasmName(s string) []byte {
+	return append(uleb(len(s)), s...)
+}
+
+func wasmVector(items ...[]byte) []byte {
+	out := uleb(len(items))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(len(content))...), content...)
+}
+
+// Assemble a module importing host functions of the goliquify module (or
+// of another one), with one exported function running code over one page
+// of memory holding data at offset 0
+type wasmModule struct {
+	importModule string
+	export       string
+	code         []byte
+	data         string
+}
+
+func (m wasmModule) binary() []byte {
+	types := wasmVector(
+		[]byte{0x60, 0, 1, wasmI32},                   // () -> i32
+		[]byte{0x60, 2, wasmI32, wasmI32, 1, wasmI32}, // (i32, i32) -> i32
+		[]byte{0x60, 2, wasmI32, wasmI32, 0},          // (i32, i32)
+		[]byte{0x60, 0, 0},                            // ()
+	)
+	imports := wasmVector(
+		append(append(wasmName(m.importModule), wasmName("input_size")...), 0, 0),
+		append(append(wasmName(m.importModule), wasmName("read_input")...), 0, 1),
+		append(append(wasmName(m.importModule), wasmName("report")...), 0, 2),
+	)
+	body := append(append([]byte{0}, m.code...), wasmEnd)
+	out := []byte{0, 'a', 's', 'm', 1, 0, 0, 0}
+	out = append(out, wasmSection(1, types)...)
+	out = append(out, wasmSection(2, imports)...)
+	out = append(out, wasmSection(3, wasmVector([]byte{3}))...)
+	out = append(out, wasmSection(5, wasmVector([]byte{0, 1}))...)
+	out = append(out, wasmSection(7, wasmVector(append(wasmName(m.export), 0, 3)))...)
+	out = append(out, wasmSection(10, wasmVector(append(uleb(len(body)), body...)))...)
+	data := append([]byte{0, wasmI32Const, 0, wasmEnd}, wasmName(m.data)...)
+	return append(out, wasmSection(11, wasmVector(data))...)
+}
+
+// Reads the whole input to offset 1024 and reports the diagnostic at
+// offset 0 when it got all of it
+func reportingModule(export, diagnostic string) wasmModule {
+	code := append([]byte{wasmI32Const}, sleb(1024)...)
+	code = append(code, wasmCall, 0, wasmCall, 1, wasmCall, 0, wasmI32Eq, wasmIf, wasmVoid, wasmI32Const, 0, wasmI32Const)
+	code = append(code, sleb(len(diagnostic))...)
+	code = append(code, wasmCall, 2, wasmEnd)
+	return wasmModule{importModule: POLICY_HOST_MODULE, export: export, code: code, data: diagnostic}
+}
+
+func writeModule(t *testing.T, m wasmModule) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), m.export+".wasm")
+	if err := os.WriteFile(path, m.binary(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunPolicies(t *testing.T) {
+	command := writeModule(t, reportingModule("_start", `{"file": "db/changelog.xml", "line": 3, "message": "no drop table"}`))
+	reactor := writeModule(t, reportingModule("check", `{"severity": "warning", "message": "missing comment"}`))
+	input := &PolicyInput{Files: []PolicyFile{{Path: "db/changelog.xml", ChangeSets: []PolicyChangeSet{}}}}
+
+	diagnostics, err := RunPolicies(context.Background(), &PolicyConfig{Modules: []string{command, reactor}}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 2 {
+		t.Fatalf("diagnostics %+v, want one of each module", diagnostics)
+	}
+	if d := diagnostics[0]; d.Kind != LINT_POLICY || d.Severity != SEVERITY_ERROR || d.File != "db/changelog.xml" || d.Line != 3 || d.Message != "no drop table" {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+	if d := diagnostics[1]; d.Severity != SEVERITY_WARNING || d.Message != "missing comment" {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+}
+
+func TestRunPoliciesRefusesModules(t *testing.T) {
+	input := &PolicyInput{Files: []PolicyFile{}}
+	for name, tc := range map[string]struct {
+		module wasmModule
+		err    string
+	}{
+		// Only the host API is importable, nothing reaching the network or files
+		"unknown import":    {wasmModule{importModule: "env", export: "_start"}, "module[env] not instantiated"},
+		"invalid report":    {reportingModule("_start", `not json`), "failed to parse a diagnostic"},
+		"out of the memory": {wasmModule{importModule: POLICY_HOST_MODULE, export: "_start", code: append(append([]byte{wasmI32Const}, sleb(65536)...), wasmI32Const, 1, wasmCall, 2)}, "out of memory range"},
+	} {
+		_, err := RunPolicies(context.Background(), &PolicyConfig{Modules: []string{writeModule(t, tc.module)}}, input)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: failed with %v, want %q", name, err, tc.err)
+		}
+	}
+
+	// A module that doesn't end is stopped
+	loop := wasmModule{importModule: POLICY_HOST_MODULE, export: "_start", code: []byte{wasmLoop, wasmVoid, wasmBr, 0, wasmEnd}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := RunPolicies(ctx, &PolicyConfig{Modules: []string{writeModule(t, loop)}}, input); err == nil {
+		t.Fatal("endless module wasn't stopped")
+	}
+}
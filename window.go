@@ -0,0 +1,101 @@
+package goliquify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Commands that are only allowed inside a maintenance window, unless configured otherwise
+var DEFAULT_WINDOW_COMMANDS = []string{
+	"update", "update-count", "update-to-tag", "update-testing-rollback",
+	"rollback", "rollback-count", "rollback-to-date", "rollbackToDate",
+	"drop-all", "changelog-sync", "changelog-sync-to-tag", "clear-checksums",
+}
+
+// MaintenanceWindow opens at every time the cron expression fires and stays open for the duration
+type MaintenanceWindow struct {
+	Cron     string        `yaml:"cron"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// WindowPolicy restricts commands to maintenance windows
+type WindowPolicy struct {
+	Windows  []MaintenanceWindow
+	Commands []string
+	Location *time.Location
+}
+
+// Check if t falls inside one of the windows
+func (p *WindowPolicy) InWindow(t time.Time) (bool, error) {
+	if p.Location != nil {
+		t = t.In(p.Location)
+	}
+	t = t.Truncate(time.Minute)
+	for _, w := range p.Windows {
+		schedule, err := ParseCron(w.Cron)
+		if err != nil {
+			return false, err
+		}
+		// Look back for a window start that is still open
+		for start := t; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+			if schedule.Matches(start) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Check if a command is restricted to maintenance windows
+func (p *WindowPolicy) Guards(command string) bool {
+	commands := p.Commands
+	if len(commands) == 0 {
+		commands = DEFAULT_WINDOW_COMMANDS
+	}
+	for _, c := range commands {
+		if strings.EqualFold(c, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the next time a window opens after t
+func (p *WindowPolicy) NextWindow(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range p.Windows {
+		schedule, err := ParseCron(w.Cron)
+		if err != nil {
+			continue
+		}
+		if n := schedule.Next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// Refuse guarded commands outside the maintenance windows unless an override reason is given
+func (pl *GoLiquibase) checkWindow(command string, now time.Time) error {
+	policy := pl.WindowPolicy
+	if policy == nil || len(policy.Windows) == 0 || !policy.Guards(command) {
+		return nil
+	}
+	inWindow, err := policy.InWindow(now)
+	if err != nil {
+		return err
+	}
+	if inWindow {
+		return nil
+	}
+	if pl.WindowOverride != "" {
+		pl.logger().Printf("Running %s outside the maintenance window, override reason: %s", command, pl.WindowOverride)
+		return nil
+	}
+	msg := fmt.Sprintf("%s is not allowed outside the maintenance window", command)
+	if next := policy.NextWindow(now); !next.IsZero() {
+		msg += fmt.Sprintf(", the next window opens at %s", next.Format(time.RFC1123))
+	}
+	return fmt.Errorf("%s; use --override-window with a reason to run it anyway", msg)
+}